@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// command is a CLI command, as offered by the shell completions
+type command struct {
+	name        string
+	description string
+	flags       []commandFlag
+}
+
+// commandFlag is a flag of a command, with the values it accepts when they are known
+type commandFlag struct {
+	name        string
+	description string
+	values      []string
+}
+
+// commands are the CLI commands, in the order they are offered by the shell completions
+var commands = []command{
+	{name: "status", description: "Show the status of the migrations", flags: []commandFlag{
+		{name: "format", description: "Output format", values: []string{"text", "json"}},
+		{name: "no-color", description: "Disable colored output"},
+	}},
+	{name: "migrate", description: "Apply the pending migrations", flags: []commandFlag{
+		{name: "auto-approve", description: "Skip the confirmation of the plan"},
+		{name: "wait-for-lock", description: "Maximum wait for the migration lock"},
+		{name: "lease", description: "Serialize the runs with a lease of the lock table"},
+		{name: "lock-timeout", description: "Maximum wait of the statements for the locks of other sessions"},
+		{name: "statement-timeout", description: "Maximum execution time of each statement"},
+		{name: "context", description: "Context recorded with the applied migrations"},
+		{name: "report", description: "File receiving the report of the run, as HTML or JSON"},
+		{name: "label", description: "Labels of the migrations applied"},
+	}},
+	{name: "apply", description: "Apply a single pending migration", flags: []commandFlag{
+		{name: "file", description: "Name of the pending migration to apply"},
+		{name: "ahead", description: "Apply it ahead of the pending migrations sorting before it"},
+		{name: "context", description: "Context recorded with the applied migration"},
+		{name: "report", description: "File receiving the report of the run, as HTML or JSON"},
+	}},
+	{name: "validate", description: "Check the migration files without modifying the database", flags: []commandFlag{
+		{name: "fix", description: "Renumber the pending migration files sharing a sequence number"},
+	}},
+	{name: "doctor", description: "Check the migration files, like validate", flags: []commandFlag{
+		{name: "fix", description: "Renumber the pending migration files sharing a sequence number"},
+	}},
+	{name: "check", description: "Fail when migrations are pending, failed or drifted"},
+	{name: "lint", description: "Check the pending migrations against the lint rules"},
+	{name: "plan-hash", description: "Print the hash of the pending migrations sent for approval"},
+	{name: "estimate", description: "Estimate the impact of the pending migrations", flags: []commandFlag{
+		{name: "format", description: "Output format", values: []string{"text", "json"}},
+	}},
+	{name: "restore", description: "Remove the failed migrations from the history table"},
+	{name: "force", description: "Mark a migration as applied without executing it", flags: []commandFlag{
+		{name: "not-applied", description: "Mark the migration as not applied instead"},
+	}},
+	{name: "mark-applied", description: "Record a migration applied by hand without executing it"},
+	{name: "redo", description: "Roll back and apply again the last migration"},
+	{name: "squash", description: "Consolidate old migrations into a baseline", flags: []commandFlag{
+		{name: "before", description: "Version before which migrations are squashed"},
+		{name: "archive-dir", description: "Directory the squashed files are moved to"},
+	}},
+	{name: "dump", description: "Print the schema of the database"},
+	{name: "schema-at", description: "Print the schema resulting from the migrations up to a version"},
+	{name: "drift", description: "Compare the database with the schema snapshot", flags: []commandFlag{
+		{name: "schema-file", description: "Schema snapshot to compare the database with"},
+	}},
+	{name: "history", description: "Export the migration history", flags: []commandFlag{
+		{name: "format", description: "Output format", values: []string{"json", "csv"}},
+	}},
+	{name: "prune", description: "Archive and delete the oldest history rows", flags: []commandFlag{
+		{name: "keep", description: "Number of latest applied migrations kept in the history"},
+		{name: "archive", description: "Compressed file receiving the pruned rows"},
+	}},
+	{name: "import", description: "Import the history of another migration tool", flags: []commandFlag{
+		{name: "from", description: "Migration tool to import the history from", values: []string{"flyway", "golang-migrate", "goose"}},
+		{name: "table", description: "History table of the migration tool"},
+	}},
+	{name: "seed", description: "Apply the new and changed seed files"},
+	{name: "sign", description: "Write the signatures of the migration files", flags: []commandFlag{
+		{name: "key", description: "PEM file of the Ed25519 private key"},
+	}},
+	{name: "bundle", description: "Write the migration files into an archive", flags: []commandFlag{
+		{name: "output", description: "The .zip, .tar.gz or .tgz archive to write"},
+	}},
+	{name: "preflight", description: "Check that a migration run can succeed"},
+	{name: "serve", description: "Serve the gRPC migration service", flags: []commandFlag{
+		{name: "addr", description: "Address to listen on"},
+	}},
+	{name: "clean", description: "Drop all objects of the schema"},
+	{name: "completion", description: "Print a shell completion script"},
+}
+
+// globalFlags are the flags accepted by every command but completion
+var globalFlags = []commandFlag{
+	{name: "output", description: "Output of the command", values: []string{"text", "json"}},
+	{name: "quiet", description: "Only print the errors"},
+	{name: "verbose", description: "Also print each executed statement"},
+}
+
+// allFlags returns the flags of the command followed by the global flags
+func (c command) allFlags() []commandFlag {
+	if c.name == "completion" {
+		return c.flags
+	}
+	return append(append([]commandFlag{}, c.flags...), globalFlags...)
+}
+
+// completionShells are the shells accepted by `gosmm completion`
+var completionShells = []string{"bash", "zsh", "fish"}
+
+// writeCompletion writes the completion script of the shell named by args to w
+func writeCompletion(w io.Writer, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: gosmm completion %s", strings.Join(completionShells, "|"))
+	}
+	switch args[0] {
+	case "bash":
+		return writeBashCompletion(w)
+	case "zsh":
+		return writeZshCompletion(w)
+	case "fish":
+		return writeFishCompletion(w)
+	default:
+		return fmt.Errorf("unsupported shell: %s", args[0])
+	}
+}
+
+// writeBashCompletion writes a completion script to source from ~/.bashrc
+func writeBashCompletion(w io.Writer) error {
+	var b strings.Builder
+	names := make([]string, len(commands))
+	for i, c := range commands {
+		names[i] = c.name
+	}
+	b.WriteString("# bash completion for gosmm, add to ~/.bashrc: source <(gosmm completion bash)\n")
+	b.WriteString("_gosmm() {\n")
+	b.WriteString("    local cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]}\n")
+	b.WriteString("    if [ \"$COMP_CWORD\" -eq 1 ]; then\n")
+	fmt.Fprintf(&b, "        COMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(names, " "))
+	b.WriteString("        return\n")
+	b.WriteString("    fi\n")
+	b.WriteString("    case \"${COMP_WORDS[1]} $prev\" in\n")
+	for _, c := range commands {
+		for _, f := range c.allFlags() {
+			if len(f.values) > 0 {
+				fmt.Fprintf(&b, "        \"%s --%s\") COMPREPLY=($(compgen -W %q -- \"$cur\")); return ;;\n", c.name, f.name, strings.Join(f.values, " "))
+			}
+		}
+	}
+	b.WriteString("    esac\n")
+	b.WriteString("    case \"${COMP_WORDS[1]}\" in\n")
+	for _, c := range commands {
+		if c.name == "completion" {
+			fmt.Fprintf(&b, "        completion) COMPREPLY=($(compgen -W %q -- \"$cur\")) ;;\n", strings.Join(completionShells, " "))
+			continue
+		}
+		commandFlags := c.allFlags()
+		flags := make([]string, len(commandFlags))
+		for i, f := range commandFlags {
+			flags[i] = "--" + f.name
+		}
+		fmt.Fprintf(&b, "        %s) COMPREPLY=($(compgen -W %q -- \"$cur\")) ;;\n", c.name, strings.Join(flags, " "))
+	}
+	b.WriteString("    esac\n")
+	b.WriteString("}\n")
+	b.WriteString("complete -F _gosmm gosmm\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeZshCompletion writes a completion script to source from ~/.zshrc or to install as _gosmm in $fpath
+func writeZshCompletion(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("#compdef gosmm\n")
+	b.WriteString("# zsh completion for gosmm, add to ~/.zshrc: source <(gosmm completion zsh)\n")
+	b.WriteString("_gosmm() {\n")
+	b.WriteString("    local -a commands\n")
+	b.WriteString("    commands=(\n")
+	for _, c := range commands {
+		fmt.Fprintf(&b, "        '%s:%s'\n", c.name, c.description)
+	}
+	b.WriteString("    )\n")
+	b.WriteString("    if (( CURRENT == 2 )); then\n")
+	b.WriteString("        _describe 'command' commands\n")
+	b.WriteString("        return\n")
+	b.WriteString("    fi\n")
+	b.WriteString("    case $words[2] in\n")
+	for _, c := range commands {
+		if c.name == "completion" {
+			fmt.Fprintf(&b, "        completion) _values 'shell' %s ;;\n", strings.Join(completionShells, " "))
+			continue
+		}
+		fmt.Fprintf(&b, "        %s) _arguments", c.name)
+		for _, f := range c.allFlags() {
+			if len(f.values) > 0 {
+				fmt.Fprintf(&b, " '--%s[%s]:%s:(%s)'", f.name, f.description, f.name, strings.Join(f.values, " "))
+			} else {
+				fmt.Fprintf(&b, " '--%s[%s]'", f.name, f.description)
+			}
+		}
+		b.WriteString(" ;;\n")
+	}
+	b.WriteString("    esac\n")
+	b.WriteString("}\n")
+	// called when autoloaded from $fpath, registered when sourced
+	b.WriteString("if [ \"$funcstack[1]\" = \"_gosmm\" ]; then\n")
+	b.WriteString("    _gosmm \"$@\"\n")
+	b.WriteString("else\n")
+	b.WriteString("    compdef _gosmm gosmm\n")
+	b.WriteString("fi\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeFishCompletion writes a completion script to install as ~/.config/fish/completions/gosmm.fish
+func writeFishCompletion(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("# fish completion for gosmm: gosmm completion fish > ~/.config/fish/completions/gosmm.fish\n")
+	b.WriteString("complete -c gosmm -f\n")
+	for _, c := range commands {
+		fmt.Fprintf(&b, "complete -c gosmm -n __fish_use_subcommand -a %s -d '%s'\n", c.name, c.description)
+	}
+	for _, c := range commands {
+		if c.name == "completion" {
+			fmt.Fprintf(&b, "complete -c gosmm -n '__fish_seen_subcommand_from completion' -a '%s'\n", strings.Join(completionShells, " "))
+			continue
+		}
+		for _, f := range c.allFlags() {
+			fmt.Fprintf(&b, "complete -c gosmm -n '__fish_seen_subcommand_from %s' -l %s -d '%s'", c.name, f.name, f.description)
+			if len(f.values) > 0 {
+				fmt.Fprintf(&b, " -x -a '%s'", strings.Join(f.values, " "))
+			}
+			b.WriteString("\n")
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -1,87 +1,1142 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"github.com/joho/godotenv"
-	"github.com/k1e1n04/gosmm/pkg/gosmm"
+	"github.com/k1e1n04/gosmm/v2/pkg/gosmm"
+	"github.com/k1e1n04/gosmm/v2/pkg/grpcserver"
+	"io"
+	"io/ioutil"
 	"log"
+	"net"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3" // SQLite driver
 )
 
 const (
-	defaultMigrationsDir = "./migrations"
+	progressBarWidth = 20
+	// defaultServeAddr is the address `gosmm serve` listens on without --addr
+	defaultServeAddr = ":50051"
+	// defaultSchemaFile is the schema snapshot compared by `gosmm drift` unless GOSMM_SCHEMA_FILE is set
+	defaultSchemaFile = "schema.sql"
+	// defaultLease is the lease of `gosmm migrate --lease` unless GOSMM_LEASE is set
+	defaultLease = 30 * time.Second
 )
 
+// defaultConfigFiles are the configuration files looked up in the working directory when GOSMM_CONFIG is not set
+var defaultConfigFiles = []string{"gosmm.yaml", "gosmm.yml", "gosmm.toml"}
+
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Println("Usage: gosmm <command>")
+	output, args, err := parseOutput(os.Args[1:])
+	if err != nil {
+		log.Fatalf("Invalid arguments: %v", err)
+	}
+	logLevel, args = parseVerbosity(args)
+	if len(args) < 1 {
+		fmt.Println("Usage: gosmm [--output text|json] [-q|-v|-vv] <command>")
 		os.Exit(1)
 	}
+	command := args[0]
+
+	// completions are generated without a configuration or a database
+	if command == "completion" {
+		if err := writeCompletion(os.Stdout, args[1:]); err != nil {
+			log.Fatalf("Command failed: %v", err)
+		}
+		return
+	}
 
-	err := godotenv.Load(".env")
+	err = godotenv.Load(".env")
 	if err != nil {
 		log.Printf("Warning: Could not load .env file. If this is a production environment, ensure environment variables are set appropriately.")
 	}
 
-	port, err := strconv.Atoi(os.Getenv("GOSMM_PORT"))
+	run := func() error {
+		return runCommand(command, args[1:])
+	}
+	if output == outputJSON {
+		err = executeJSON(os.Stdout, run, command)
+	} else {
+		err = run()
+	}
 	if err != nil {
-		log.Fatalf("Invalid port: %v", err)
+		var exit *exitError
+		if errors.As(err, &exit) {
+			if exit.err != nil {
+				log.Printf("Command failed: %v", exit.err)
+			}
+			os.Exit(exit.code)
+		}
+		log.Fatalf("Command failed: %v", err)
 	}
+}
 
-	driver := os.Getenv("GOSMM_DRIVER")
-
-	config := gosmm.DBConfig{
-		Driver:   driver,
-		Host:     os.Getenv("GOSMM_HOST"),
-		Port:     port,
-		User:     os.Getenv("GOSMM_USER"),
-		Password: os.Getenv("GOSMM_PASSWORD"),
-		DBName:   os.Getenv("GOSMM_DBNAME"),
+// runCommand connects to the configured database and executes the command. Every failure of `gosmm status`,
+// including the configuration and the connection, exits with statusErrorExitCode.
+func runCommand(command string, args []string) error {
+	err := connectAndExecute(command, args)
+	if command == "status" {
+		return statusError(err)
 	}
+	return err
+}
 
-	db, err := gosmm.ConnectDB(config)
+// connectAndExecute connects to the configured database and executes the command, see runCommand
+func connectAndExecute(command string, args []string) error {
+	config, err := gosmm.LoadConfig(configPath())
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	if logLevel == "" {
+		logLevel = config.DB.LogLevel
+	}
+	config.DB.LogLevel = logLevel
+	// the migrations are signed by the release pipeline, which has no database
+	if command == "sign" {
+		return signMigrations(config.Migration, args)
+	}
+	// the commands reload the configuration, whose source cache directory is the same
+	if err := gosmm.FetchSource(context.Background(), config.Migration); err != nil {
+		return fmt.Errorf("could not fetch migrations: %w", err)
+	}
+	if command == "bundle" {
+		return bundleMigrations(config.Migration, args)
+	}
+	db, err := gosmm.Connect(config.DB)
 	if err != nil {
-		log.Fatalf("Could not connect to database: %v", err)
+		return fmt.Errorf("could not connect to database: %w", err)
 	}
+	defer db.Close()
+	return executeCommand(db, command, args, config.DB.Driver)
+}
 
-	command := os.Args[1]
+// statusErrorExitCode is the exit code of `gosmm status` when the status cannot be determined,
+// distinct from the exit codes of StatusReport.ExitCode
+const statusErrorExitCode = 3
 
-	if err := executeCommand(db, command, driver); err != nil {
-		log.Fatalf("Command failed: %v", err)
+// statusError makes a failure of `gosmm status` exit with statusErrorExitCode, unless it already carries its
+// exit code, e.g. that of the pending migrations
+func statusError(err error) error {
+	var exit *exitError
+	if err == nil || errors.As(err, &exit) {
+		return err
+	}
+	return &exitError{code: statusErrorExitCode, err: err}
+}
+
+// interruptedExitCode is the exit code of a migration run interrupted by SIGINT or SIGTERM
+const interruptedExitCode = 130
+
+// exitError makes main exit with code, logging err when it is set
+type exitError struct {
+	code int
+	err  error
+}
+
+// Error returns the error, or the exit code when there is none
+func (e *exitError) Error() string {
+	if e.err == nil {
+		return fmt.Sprintf("exit status %d", e.code)
 	}
+	return e.err.Error()
+}
+
+// Unwrap returns the error
+func (e *exitError) Unwrap() error {
+	return e.err
 }
 
-func executeCommand(db *sql.DB, command string, driver string) error {
+func executeCommand(db *sql.DB, command string, args []string, driver string) error {
+	// restore and force are how a dirty database is fixed, so there is no point warning about it
+	if command != "restore" && command != "force" {
+		if err := warnIfDirty(db, driver); err != nil {
+			if command == "status" {
+				return statusError(err)
+			}
+			return err
+		}
+	}
+
 	switch command {
 	case "status":
-		if err := gosmm.DisplayStatus(db); err != nil {
-			log.Fatalf("Status check failed: %v", err)
+		if err := showStatus(db, args, driver); err != nil {
+			return statusError(err)
 		}
 
 	case "migrate":
-		// Get migrations directory from environment variable
-		migrationsDir := os.Getenv("GOSMM_MIGRATIONS_DIR")
-		if migrationsDir == "" {
-			migrationsDir = defaultMigrationsDir
+		flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+		autoApprove := flags.Bool("auto-approve", false, "skip the confirmation of the plan")
+		waitForLock := flags.Duration("wait-for-lock", 0, "maximum wait for the migration lock held by another run, unbounded when zero")
+		lease := flags.Bool("lease", false, "serialize the runs with a lease of the gosmm_migration_lock table")
+		lockTimeout := flags.Duration("lock-timeout", 0, "maximum wait of the migration statements for the locks of other sessions")
+		statementTimeout := flags.Duration("statement-timeout", 0, "maximum execution time of each migration statement")
+		auditContext := flags.String("context", "", "free-form context recorded with the applied migrations, e.g. a change request")
+		report := flags.String("report", "", "file receiving the report of the run, as HTML when it ends with .html and JSON otherwise")
+		label := flags.String("label", "", "comma-separated labels of the migrations applied, the others remaining pending")
+		if err := flags.Parse(args); err != nil {
+			return err
+		}
+		loaded, err := loadConfig(driver)
+		if err != nil {
+			return err
+		}
+		if *label != "" {
+			loaded.Migration.Labels = strings.Split(*label, ",")
+		}
+		if *auditContext != "" {
+			loaded.Migration.Context = *auditContext
+		}
+		if *report != "" {
+			loaded.Migration.ReportFile = *report
+		}
+		if *waitForLock > 0 {
+			loaded.Migration.WaitForLock = *waitForLock
+		}
+		if *lease && loaded.Migration.Lease == 0 {
+			loaded.Migration.Lease = defaultLease
+		}
+		if *lockTimeout > 0 {
+			loaded.Migration.LockTimeout = *lockTimeout
+		}
+		if *statementTimeout > 0 {
+			loaded.Migration.StatementTimeout = *statementTimeout
+		}
+		result := recordApplied(&loaded.Migration)
+		if loaded.Tenants.Schemas != nil || loaded.Tenants.Query != "" {
+			return migrateTenants(db, loaded, *autoApprove)
+		}
+		if result != nil {
+			if result.Plan, err = gosmm.Plan(db, loaded.Migration); err != nil {
+				return fmt.Errorf("failed to plan the migration: %w", err)
+			}
+		}
+		if loaded.Confirm && !*autoApprove {
+			if err := confirmPlan(db, loaded); err != nil {
+				return err
+			}
 		}
 		// Perform database migration
-		if err := gosmm.Migrate(db, migrationsDir, driver); err != nil {
-			log.Fatalf("Migration failed: %v", err)
+		ctx, release := interruptible(&loaded.Migration)
+		defer release()
+		if err := gosmm.MigrateWithContext(ctx, db, loaded.Migration); err != nil {
+			return interruptedExit(fmt.Errorf("migration failed: %w", err))
+		}
+		infof("Migration completed successfully.\n")
+
+	case "apply":
+		flags := flag.NewFlagSet("apply", flag.ContinueOnError)
+		file := flags.String("file", "", "name of the pending migration to apply")
+		ahead := flags.Bool("ahead", false, "apply it ahead of the pending migrations sorting before it")
+		auditContext := flags.String("context", "", "free-form context recorded with the applied migration, e.g. a change request")
+		report := flags.String("report", "", "file receiving the report of the run, as HTML when it ends with .html and JSON otherwise")
+		if err := flags.Parse(args); err != nil {
+			return err
+		}
+		if *file == "" || flags.NArg() != 0 {
+			return fmt.Errorf("usage: gosmm apply --file <name> [--ahead]")
+		}
+		config, err := loadMigrationConfig(driver)
+		if err != nil {
+			return err
+		}
+		if *auditContext != "" {
+			config.Context = *auditContext
+		}
+		if *report != "" {
+			config.ReportFile = *report
+		}
+		recordApplied(&config)
+		ctx, release := interruptible(&config)
+		defer release()
+		if err := gosmm.ApplyWithContext(ctx, db, config, *file, *ahead); err != nil {
+			return interruptedExit(fmt.Errorf("apply failed: %w", err))
+		}
+		infof("Apply completed successfully.\n")
+
+	case "validate", "doctor":
+		// doctor is validate, named after the diagnosis of the migration files of concurrent branches
+		flags := flag.NewFlagSet(command, flag.ContinueOnError)
+		fix := flags.Bool("fix", false, "renumber the pending migration files sharing a sequence number before validating")
+		if err := flags.Parse(args); err != nil {
+			return err
+		}
+		config, err := loadMigrationConfig(driver)
+		if err != nil {
+			return err
+		}
+		if *fix {
+			renumbered, err := gosmm.FixDuplicateSequences(db, config)
+			for _, renumbering := range renumbered {
+				infof("Renumbered %s to %s.\n", renumbering.From, renumbering.To)
+				for _, requirer := range renumbering.Requirers {
+					infof("Updated the requirement of %s.\n", requirer)
+				}
+			}
+			if err != nil {
+				return fmt.Errorf("%s failed: %w", command, err)
+			}
+		}
+		if err := gosmm.Validate(db, config); err != nil {
+			var validation *gosmm.ValidationError
+			if errors.As(err, &validation) {
+				setResult(validation.Issues)
+			}
+			return fmt.Errorf("%s failed: %w", command, err)
+		}
+		infof("Validation completed successfully.\n")
+
+	case "check":
+		config, err := loadMigrationConfig(driver)
+		if err != nil {
+			return err
 		}
-		fmt.Println("Migration completed successfully.")
+		if err := gosmm.Check(db, config); err != nil {
+			return fmt.Errorf("check failed: %w", err)
+		}
+		infof("Check completed successfully.\n")
+
+	case "lint":
+		config, err := loadMigrationConfig(driver)
+		if err != nil {
+			return err
+		}
+		issues, err := gosmm.Lint(db, config)
+		if err != nil {
+			return fmt.Errorf("lint failed: %w", err)
+		}
+		setResult(issues)
+		for _, issue := range issues {
+			fmt.Println(issue)
+		}
+		if len(issues) > 0 {
+			return fmt.Errorf("lint failed: %d issue(s) in the pending migrations", len(issues))
+		}
+		infof("Lint completed successfully.\n")
+
+	case "plan-hash":
+		config, err := loadMigrationConfig(driver)
+		if err != nil {
+			return err
+		}
+		hash, err := gosmm.PlanHash(db, config)
+		if err != nil {
+			return fmt.Errorf("plan-hash failed: %w", err)
+		}
+		setResult(hash)
+		fmt.Println(hash)
+
+	case "estimate":
+		flags := flag.NewFlagSet("estimate", flag.ContinueOnError)
+		format := flags.String("format", "text", "output format (text or json)")
+		if err := flags.Parse(args); err != nil {
+			return err
+		}
+		if *format != "text" && *format != "json" {
+			return fmt.Errorf("unsupported estimate format: %s", *format)
+		}
+		config, err := loadMigrationConfig(driver)
+		if err != nil {
+			return err
+		}
+		estimates, err := gosmm.Estimate(db, config)
+		if err != nil {
+			return fmt.Errorf("estimate failed: %w", err)
+		}
+		setResult(estimates)
+		if *format == "json" {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(estimates)
+		}
+		return printEstimates(os.Stdout, estimates)
 
 	case "restore":
-		if err := gosmm.Restore(db); err != nil {
-			log.Fatalf("Restore failed: %v", err)
+		config, err := loadMigrationConfig(driver)
+		if err != nil {
+			return err
+		}
+		if err := gosmm.RestoreWithConfig(db, config); err != nil {
+			return fmt.Errorf("restore failed: %w", err)
 		}
 
+	case "force":
+		flags := flag.NewFlagSet("force", flag.ContinueOnError)
+		notApplied := flags.Bool("not-applied", false, "mark the migration as not applied instead of applied")
+		if err := flags.Parse(args); err != nil {
+			return err
+		}
+		if flags.NArg() != 1 {
+			return fmt.Errorf("usage: gosmm force [--not-applied] <filename>")
+		}
+		config, err := loadMigrationConfig(driver)
+		if err != nil {
+			return err
+		}
+		if err := gosmm.Force(db, config, flags.Arg(0), !*notApplied); err != nil {
+			return fmt.Errorf("force failed: %w", err)
+		}
+		infof("Force completed successfully.\n")
+
+	case "mark-applied":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: gosmm mark-applied <file>")
+		}
+		config, err := loadMigrationConfig(driver)
+		if err != nil {
+			return err
+		}
+		if err := gosmm.MarkApplied(db, config, args[0]); err != nil {
+			return fmt.Errorf("mark-applied failed: %w", err)
+		}
+		infof("Mark-applied completed successfully.\n")
+
+	case "redo":
+		config, err := loadMigrationConfig(driver)
+		if err != nil {
+			return err
+		}
+		if err := gosmm.Redo(db, config); err != nil {
+			return fmt.Errorf("redo failed: %w", err)
+		}
+		infof("Redo completed successfully.\n")
+
+	case "squash":
+		flags := flag.NewFlagSet("squash", flag.ContinueOnError)
+		before := flags.String("before", "", "squash the migrations sorting before this version, e.g. v20230101")
+		archiveDir := flags.String("archive-dir", "", "directory the squashed files are moved to (default: the migrations directory with an _archive suffix)")
+		if err := flags.Parse(args); err != nil {
+			return err
+		}
+		if *before == "" {
+			return fmt.Errorf("usage: gosmm squash --before <version> [--archive-dir dir]")
+		}
+		config, err := loadMigrationConfig(driver)
+		if err != nil {
+			return err
+		}
+		result, err := gosmm.Squash(db, config, gosmm.SquashOptions{Before: *before, ArchiveDir: *archiveDir})
+		if err != nil {
+			return fmt.Errorf("squash failed: %w", err)
+		}
+		setResult(result)
+		infof("Squashed %d migrations into %s, archived in %s.\n", len(result.Squashed), result.Baseline, result.ArchiveDir)
+
+	case "dump":
+		config, err := loadMigrationConfig(driver)
+		if err != nil {
+			return err
+		}
+		var schema strings.Builder
+		if err := gosmm.DumpSchema(db, config, &schema); err != nil {
+			return fmt.Errorf("dump failed: %w", err)
+		}
+		setResult(map[string]string{"schema": schema.String()})
+		fmt.Print(schema.String())
+
+	case "schema-at":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: gosmm schema-at <version>")
+		}
+		loaded, err := loadConfig(driver)
+		if err != nil {
+			return err
+		}
+		loaded.DB.Driver = driver
+		if err := printSchemaAt(os.Stdout, loaded, args[0]); err != nil {
+			return fmt.Errorf("schema-at failed: %w", err)
+		}
+
+	case "drift":
+		flags := flag.NewFlagSet("drift", flag.ContinueOnError)
+		schemaFile := flags.String("schema-file", "", "schema snapshot to compare the database with (default: GOSMM_SCHEMA_FILE or "+defaultSchemaFile+")")
+		if err := flags.Parse(args); err != nil {
+			return err
+		}
+		config, err := loadMigrationConfig(driver)
+		if err != nil {
+			return err
+		}
+		return checkDrift(db, config, *schemaFile)
+
+	case "history":
+		flags := flag.NewFlagSet("history", flag.ContinueOnError)
+		format := flags.String("format", string(gosmm.HistoryFormatJSON), "output format (json or csv)")
+		if err := flags.Parse(args); err != nil {
+			return err
+		}
+		config, err := loadMigrationConfig(driver)
+		if err != nil {
+			return err
+		}
+		if jsonOutput != nil {
+			history, err := gosmm.GetHistory(db, config)
+			if err != nil {
+				return fmt.Errorf("history export failed: %w", err)
+			}
+			setResult(history)
+			return nil
+		}
+		if err := gosmm.ExportHistory(db, config, os.Stdout, gosmm.HistoryFormat(*format)); err != nil {
+			return fmt.Errorf("history export failed: %w", err)
+		}
+
+	case "prune":
+		flags := flag.NewFlagSet("prune", flag.ContinueOnError)
+		keep := flags.Int("keep", 0, "number of latest applied migrations whose history rows are kept")
+		archive := flags.String("archive", "", "gzip-compressed JSON or CSV (.csv.gz) file receiving the pruned rows (default: gosmm-history-<time>.json.gz)")
+		if err := flags.Parse(args); err != nil {
+			return err
+		}
+		if *keep < 1 {
+			return fmt.Errorf("usage: gosmm prune --keep <count> [--archive file]")
+		}
+		if *archive == "" {
+			*archive = "gosmm-history-" + time.Now().UTC().Format("20060102150405") + ".json.gz"
+		}
+		config, err := loadMigrationConfig(driver)
+		if err != nil {
+			return err
+		}
+		result, err := gosmm.Prune(db, config, gosmm.PruneOptions{Keep: *keep, Archive: *archive})
+		if err != nil {
+			return fmt.Errorf("prune failed: %w", err)
+		}
+		setResult(result)
+		if result.Pruned == 0 {
+			infof("Nothing to prune, %d or fewer migrations in the history.\n", *keep)
+		} else {
+			infof("Pruned %d history row(s) through %s, archived in %s.\n", result.Pruned, result.PrunedThrough, result.Archive)
+		}
+
+	case "import":
+		flags := flag.NewFlagSet("import", flag.ContinueOnError)
+		from := flags.String("from", "", "migration tool to import the history from (flyway, golang-migrate or goose)")
+		table := flags.String("table", "", "history table of the migration tool (defaults to the tool's table name)")
+		if err := flags.Parse(args); err != nil {
+			return err
+		}
+		config, err := loadMigrationConfig(driver)
+		if err != nil {
+			return err
+		}
+		imported, err := gosmm.ImportHistory(db, config, gosmm.ImportConfig{Source: gosmm.ImportSource(*from), Table: *table})
+		if err != nil {
+			return fmt.Errorf("import failed: %w", err)
+		}
+		setResult(map[string]interface{}{"imported": imported, "from": *from})
+		infof("Imported %d migration(s) from %s.\n", imported, *from)
+
+	case "seed":
+		config, err := loadMigrationConfig(driver)
+		if err != nil {
+			return err
+		}
+		if err := gosmm.Seed(db, config); err != nil {
+			return fmt.Errorf("seed failed: %w", err)
+		}
+		infof("Seed completed successfully.\n")
+
+	case "preflight":
+		config, err := loadMigrationConfig(driver)
+		if err != nil {
+			return err
+		}
+		report, err := gosmm.Preflight(db, config)
+		if err != nil {
+			return err
+		}
+		setResult(report)
+		fmt.Print(report)
+		if !report.Passed() {
+			return fmt.Errorf("preflight checks failed")
+		}
+		infof("Preflight checks passed.\n")
+
+	case "serve":
+		flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+		addr := flags.String("addr", defaultServeAddr, "address the gRPC migration service listens on")
+		if err := flags.Parse(args); err != nil {
+			return err
+		}
+		config, err := loadMigrationConfig(driver)
+		if err != nil {
+			return err
+		}
+		return serve(db, config, *addr)
+
+	case "clean":
+		config, err := loadMigrationConfig(driver)
+		if err != nil {
+			return err
+		}
+		if err := gosmm.Clean(db, config); err != nil {
+			return fmt.Errorf("clean failed: %w", err)
+		}
+		infof("Clean completed successfully.\n")
+
 	default:
 		fmt.Println("Unknown command:", command)
+		if jsonOutput != nil {
+			return fmt.Errorf("%w: %s", errUnknownCommand, command)
+		}
+	}
+
+	return nil
+}
+
+// warnIfDirty prints a warning to stderr when the history table holds failed migrations
+func warnIfDirty(db *sql.DB, driver string) error {
+	config, err := loadMigrationConfig(driver)
+	if err != nil {
+		return err
+	}
+	dirty, err := gosmm.DirtyMigrations(db, config)
+	if err != nil {
+		return err
+	}
+	if len(dirty) > 0 {
+		fmt.Fprintf(os.Stderr, "WARNING: the database is dirty, failed migration(s): %s\n", strings.Join(dirty, ", "))
+		fmt.Fprintln(os.Stderr, "Fix the schema by hand, then run `gosmm force <filename>` (or `gosmm force --not-applied <filename>`), or `gosmm restore`.")
+	}
+	return nil
+}
+
+// showStatus prints the status of the migrations in the format given by the --format flag, and returns
+// an *exitError when the database is not up to date, so that CI pipelines can gate on the exit code
+func showStatus(db *sql.DB, args []string, driver string) error {
+	flags := flag.NewFlagSet("status", flag.ContinueOnError)
+	format := flags.String("format", "text", "output format (text or json)")
+	noColor := flags.Bool("no-color", false, "disable colored output")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("unsupported status format: %s", *format)
+	}
+	config, err := loadMigrationConfig(driver)
+	if err != nil {
+		return err
+	}
+
+	report, err := gosmm.Status(db, config)
+	if err != nil {
+		return fmt.Errorf("status check failed: %w", err)
+	}
+	setResult(report)
+	if *format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			return err
+		}
+	} else if err := printStatus(os.Stdout, report, !*noColor && colorEnabled(os.Stdout)); err != nil {
+		return err
 	}
+	if code := report.ExitCode(); code != 0 {
+		exit := &exitError{code: code}
+		// the JSON output identifies the state with the code of its error, the text output already shows it
+		if jsonOutput != nil {
+			if report.State == gosmm.StateDirty {
+				exit.err = gosmm.ErrDirtyState
+			} else {
+				exit.err = gosmm.ErrPendingMigrations
+			}
+		}
+		return exit
+	}
+	return nil
+}
+
+// checkDrift prints the objects of the database differing from the schema snapshot, and fails when there are any
+func checkDrift(db *sql.DB, config gosmm.MigrationConfig, schemaFile string) error {
+	if schemaFile == "" {
+		schemaFile = config.SchemaFile
+	}
+	if schemaFile == "" {
+		schemaFile = defaultSchemaFile
+	}
+	f, err := os.Open(schemaFile)
+	if err != nil {
+		return fmt.Errorf("failed to open schema snapshot: %w", err)
+	}
+	defer f.Close()
+
+	drifts, err := gosmm.Drift(db, config, f)
+	if err != nil {
+		return fmt.Errorf("drift check failed: %w", err)
+	}
+	setResult(drifts)
+	if len(drifts) == 0 {
+		infof("The database matches %s.\n", schemaFile)
+		return nil
+	}
+	for _, drift := range drifts {
+		fmt.Printf("%s %s: %s\n", strings.ToUpper(string(drift.Change)), drift.Kind, drift.Name)
+		if drift.Change == gosmm.DriftChanged {
+			printDefinitionDiff(os.Stdout, drift.Expected, drift.Actual)
+		}
+	}
+	return fmt.Errorf("schema drift: %d object(s) differ from %s", len(drifts), schemaFile)
+}
+
+// printDefinitionDiff writes the lines of the expected definition missing from the actual one prefixed
+// with "-", followed by the lines of the actual definition missing from the expected one prefixed with "+"
+func printDefinitionDiff(w io.Writer, expected string, actual string) {
+	expectedLines := strings.Split(expected, "\n")
+	actualLines := strings.Split(actual, "\n")
+	contains := func(lines []string, line string) bool {
+		for _, l := range lines {
+			if strings.TrimSpace(l) == strings.TrimSpace(line) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, line := range expectedLines {
+		if !contains(actualLines, line) {
+			fmt.Fprintf(w, "  - %s\n", strings.TrimSpace(line))
+		}
+	}
+	for _, line := range actualLines {
+		if !contains(expectedLines, line) {
+			fmt.Fprintf(w, "  + %s\n", strings.TrimSpace(line))
+		}
+	}
+}
+
+// ANSI escape sequences of the colors of the migration states
+const (
+	colorReset  = "\033[0m"
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+)
+
+// stateColors are the colors of the migration and database states, "pending" being both
+var stateColors = map[string]string{
+	string(gosmm.MigrationApplied): colorGreen,
+	string(gosmm.MigrationPending): colorYellow,
+	string(gosmm.MigrationFailed):  colorRed,
+	string(gosmm.MigrationSkipped): colorYellow,
+	string(gosmm.StateUpToDate):    colorGreen,
+	string(gosmm.StateDirty):       colorRed,
+}
+
+// printStatus writes the migrations of the report as aligned columns, followed by the state of the database
+func printStatus(w io.Writer, report gosmm.StatusReport, color bool) error {
+	paint := func(state string) string {
+		if !color {
+			return state
+		}
+		return stateColors[state] + state + colorReset
+	}
+
+	// the metadata columns are only shown when a migration has a header, see gosmm.MigrationMetadata
+	withMetadata := false
+	for _, migration := range report.Migrations {
+		if migration.Author != "" || migration.Ticket != "" || migration.Description != "" {
+			withMetadata = true
+		}
+	}
+	orDash := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return s
+	}
+
+	fmt.Fprintln(w, "Migration Status:")
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if withMetadata {
+		fmt.Fprintln(table, "RANK\tFILENAME\tINSTALLED ON\tEXECUTION TIME (ms)\tROWS\tAUTHOR\tTICKET\tDESCRIPTION\tSTATE")
+	} else {
+		fmt.Fprintln(table, "RANK\tFILENAME\tINSTALLED ON\tEXECUTION TIME (ms)\tROWS\tSTATE")
+	}
+	for _, migration := range report.Migrations {
+		rank, installedOn, executionTime, rows := "-", "-", "-", "-"
+		if migration.InstalledOn != nil {
+			rank = strconv.Itoa(migration.InstalledRank)
+			installedOn = migration.InstalledOn.Format("2006-01-02 15:04:05")
+			executionTime = strconv.FormatInt(migration.ExecutionTime, 10)
+			rows = strconv.FormatInt(migration.RowsAffected, 10)
+		}
+		state := paint(string(migration.State))
+		if migration.FailedStatement > 0 {
+			state += fmt.Sprintf(" (statement %d)", migration.FailedStatement)
+		}
+		// the state is the last column, so its escape sequences do not break the alignment
+		if withMetadata {
+			fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", rank, migration.Filename, installedOn, executionTime, rows,
+				orDash(migration.Author), orDash(migration.Ticket), orDash(migration.Description), state)
+		} else {
+			fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\n", rank, migration.Filename, installedOn, executionTime, rows, state)
+		}
+	}
+	if err := table.Flush(); err != nil {
+		return err
+	}
+	skipped := ""
+	if report.Skipped > 0 {
+		skipped = fmt.Sprintf(", %d skipped", report.Skipped)
+	}
+	_, err := fmt.Fprintf(w, "State: %s (%d applied, %d pending, %d failed%s)\n", paint(string(report.State)), report.Applied, report.Pending, report.Failed, skipped)
+	return err
+}
+
+// printEstimates writes the statements and referenced tables of each pending migration as aligned columns,
+// followed by its long operations
+func printEstimates(w io.Writer, estimates []gosmm.MigrationEstimate) error {
+	if len(estimates) == 0 {
+		_, err := fmt.Fprintln(w, "No pending migrations.")
+		return err
+	}
+	orUnknown := func(n int64) string {
+		if n < 0 {
+			return "?"
+		}
+		return strconv.FormatInt(n, 10)
+	}
+	for _, estimate := range estimates {
+		if estimate.Go {
+			fmt.Fprintf(w, "%s: Go migration, not estimated\n", estimate.Filename)
+			continue
+		}
+		fmt.Fprintf(w, "%s: %d statement(s)\n", estimate.Filename, estimate.Statements)
+		if len(estimate.Tables) > 0 {
+			table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(table, "  TABLE\tROWS\tBYTES")
+			for _, t := range estimate.Tables {
+				if t.New {
+					fmt.Fprintf(table, "  %s\tnew\tnew\n", t.Name)
+				} else {
+					fmt.Fprintf(table, "  %s\t%s\t%s\n", t.Name, orUnknown(t.Rows), orUnknown(t.Bytes))
+				}
+			}
+			if err := table.Flush(); err != nil {
+				return err
+			}
+		}
+		for _, operation := range estimate.LongOperations {
+			fmt.Fprintf(w, "  long operation: %s\n", operation)
+		}
+	}
+	return nil
+}
+
+// colorEnabled reports whether colors are written to f: it must be a terminal, and NO_COLOR must not be set
+func colorEnabled(f *os.File) bool {
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// configPath returns the configuration file named by GOSMM_CONFIG, or the first of defaultConfigFiles
+// found in the working directory. It returns "" when there is none, to configure from environment variables.
+func configPath() string {
+	if path := os.Getenv("GOSMM_CONFIG"); path != "" {
+		return path
+	}
+	for _, path := range defaultConfigFiles {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
 
+// loadMigrationConfig loads the MigrationConfig from the configuration file or environment variables
+func loadMigrationConfig(driver string) (gosmm.MigrationConfig, error) {
+	loaded, err := loadConfig(driver)
+	return loaded.Migration, err
+}
+
+// signMigrations writes the detached signatures of the migration files with the private key of the --key flag
+func signMigrations(config gosmm.MigrationConfig, args []string) error {
+	flags := flag.NewFlagSet("sign", flag.ContinueOnError)
+	keyPath := flags.String("key", "", "PEM file of the Ed25519 private key")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *keyPath == "" {
+		return fmt.Errorf("usage: gosmm sign --key <private key file>")
+	}
+	data, err := ioutil.ReadFile(*keyPath)
+	if err != nil {
+		return fmt.Errorf("sign failed: %w", err)
+	}
+	key, err := gosmm.ParsePrivateKey(data)
+	if err != nil {
+		return fmt.Errorf("sign failed: invalid key %s: %w", *keyPath, err)
+	}
+	signed, err := gosmm.SignMigrations(config, key)
+	if err != nil {
+		return fmt.Errorf("sign failed: %w", err)
+	}
+	setResult(map[string][]string{"signatures": signed})
+	for _, path := range signed {
+		infof("SIGN  %s\n", path)
+	}
+	infof("Signed %d migration files.\n", len(signed))
 	return nil
 }
+
+// bundleMigrations writes the migration files into the archive of the --output flag
+func bundleMigrations(config gosmm.MigrationConfig, args []string) error {
+	flags := flag.NewFlagSet("bundle", flag.ContinueOnError)
+	output := flags.String("output", "", "the .zip, .tar.gz or .tgz archive to write")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *output == "" {
+		return fmt.Errorf("usage: gosmm bundle --output <archive>")
+	}
+	bundled, err := gosmm.WriteBundle(config, *output)
+	if err != nil {
+		return fmt.Errorf("bundle failed: %w", err)
+	}
+	setResult(map[string]interface{}{"bundle": *output, "files": bundled})
+	for _, name := range bundled {
+		infof("ADD   %s\n", name)
+	}
+	infof("Bundled %d files into %s.\n", len(bundled), *output)
+	return nil
+}
+
+// printSchemaAt writes to w the schema resulting from the migrations up to version, replayed on a scratch
+// database. The replay only prints its warnings, to stderr, so that w only holds the schema.
+func printSchemaAt(w io.Writer, loaded gosmm.Config, version string) error {
+	var schema strings.Builder
+	err := gosmm.WithScratchDatabase(loaded.DB, loaded.Migration, func(scratch *sql.DB, config gosmm.MigrationConfig) error {
+		if config.LogLevel.Enabled(gosmm.LogInfo) {
+			config.LogLevel = gosmm.LogWarn
+		}
+		return gosmm.SchemaAt(scratch, config, version, &schema)
+	})
+	if err != nil {
+		return err
+	}
+	setResult(map[string]string{"schema": schema.String()})
+	_, err = io.WriteString(w, schema.String())
+	return err
+}
+
+// loadConfig loads the configuration from the configuration file or environment variables
+func loadConfig(driver string) (gosmm.Config, error) {
+	loaded, err := gosmm.LoadConfig(configPath())
+	if err != nil {
+		return gosmm.Config{}, err
+	}
+	loaded.Migration.Driver = driver
+	if logLevel != "" {
+		loaded.Migration.LogLevel = logLevel
+	}
+	if showProgress := os.Getenv("GOSMM_PROGRESS"); showProgress != "" {
+		show, err := strconv.ParseBool(showProgress)
+		if err != nil {
+			return loaded, fmt.Errorf("invalid GOSMM_PROGRESS: %w", err)
+		}
+		if show {
+			loaded.Migration.Progress = printProgress(os.Stderr)
+		}
+	}
+	return loaded, nil
+}
+
+// serve serves the gRPC migration service on addr until SIGINT or SIGTERM, requiring the token
+// of GOSMM_SERVE_TOKEN when it is set
+func serve(db *sql.DB, config gosmm.MigrationConfig, addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	token := os.Getenv("GOSMM_SERVE_TOKEN")
+	if token == "" {
+		log.Printf("Warning: GOSMM_SERVE_TOKEN is not set, the migration service is not authenticated.")
+	}
+	server := grpcserver.NewServer(db, config, token)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		if _, ok := <-signals; ok {
+			// lets a running migration complete
+			server.GracefulStop()
+		}
+	}()
+
+	fmt.Printf("Serving the migration service on %s\n", listener.Addr())
+	return server.Serve(listener)
+}
+
+// interruptible handles SIGINT and SIGTERM during a migration run of config: the first signal stops the run once
+// the migrations in progress complete, and the second aborts them, rolling back their transactions. The returned
+// function stops the handling.
+func interruptible(config *gosmm.MigrationConfig) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stop := make(chan struct{})
+	config.Stop = stop
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-signals:
+		case <-done:
+			return
+		}
+		fmt.Fprintln(os.Stderr, "Stopping after the migrations in progress, interrupt again to abort them.")
+		close(stop)
+		select {
+		case <-signals:
+		case <-done:
+			return
+		}
+		fmt.Fprintln(os.Stderr, "Aborting the migrations in progress.")
+		cancel()
+	}()
+	return ctx, func() {
+		signal.Stop(signals)
+		close(done)
+		cancel()
+	}
+}
+
+// interruptedExit makes an interrupted migration run exit with interruptedExitCode
+func interruptedExit(err error) error {
+	if errors.Is(err, gosmm.ErrInterrupted) {
+		return &exitError{code: interruptedExitCode, err: err}
+	}
+	return err
+}
+
+// migrateTenants migrates the schema of each tenant of the configuration
+func migrateTenants(db *sql.DB, loaded gosmm.Config, autoApprove bool) error {
+	schemas, err := loaded.Tenants.Resolve(db)
+	if err != nil {
+		return err
+	}
+	if loaded.Confirm && !autoApprove {
+		fmt.Printf("Migrations will be applied to %d tenant schema(s) of %s: %s\n", len(schemas), describeTarget(loaded.DB), strings.Join(schemas, ", "))
+		if err := confirm(); err != nil {
+			return err
+		}
+	}
+	if err := gosmm.MigrateTenants(db, schemas, gosmm.MigrateAllOptions{Config: loaded.Migration}); err != nil {
+		return fmt.Errorf("migration failed: %w", err)
+	}
+	infof("Migration of %d tenant(s) completed successfully.\n", len(schemas))
+	return nil
+}
+
+// confirmInput is where the answer to the confirmation of the plan is read from
+var confirmInput io.Reader = os.Stdin
+
+// confirmPlan shows the pending migrations and asks for confirmation, Terraform-style.
+// It returns an error unless "yes" is typed.
+func confirmPlan(db *sql.DB, loaded gosmm.Config) error {
+	plan, err := gosmm.Plan(db, loaded.Migration)
+	if err != nil {
+		return fmt.Errorf("failed to plan the migration: %w", err)
+	}
+	if len(plan) == 0 {
+		return nil // nothing to confirm, migrate reports there is nothing to apply
+	}
+	fmt.Printf("The following migration(s) will be applied to %s:\n", describeTarget(loaded.DB))
+	for i, migration := range plan {
+		if migration.Go {
+			fmt.Printf("  %d. %s (Go migration)\n", i+1, migration.Filename)
+		} else {
+			fmt.Printf("  %d. %s (%d statement(s))\n", i+1, migration.Filename, migration.Statements)
+		}
+	}
+	return confirm()
+}
+
+// confirm asks to type "yes" to proceed
+func confirm() error {
+	fmt.Print("Do you want to proceed? Only 'yes' will be accepted: ")
+	answer, err := bufio.NewReader(confirmInput).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("failed to read the confirmation: %w", err)
+	}
+	fmt.Println()
+	if strings.TrimSpace(answer) != "yes" {
+		return fmt.Errorf("migration cancelled")
+	}
+	return nil
+}
+
+// describeTarget names the database of the configuration, without the credentials of a DSN
+func describeTarget(config gosmm.DBConfig) string {
+	target := config.Driver
+	if config.Host != "" {
+		target += " " + config.Host
+	}
+	if config.DBName != "" {
+		target += " database " + config.DBName
+	}
+	return target
+}
+
+// printProgress returns a ProgressFunc redrawing a progress bar line on w for each event
+func printProgress(w io.Writer) gosmm.ProgressFunc {
+	return func(event gosmm.Event) {
+		if event.Kind == gosmm.EventStatementExecuted {
+			fmt.Fprint(w, "\r"+progressLine(event))
+			return
+		}
+		fmt.Fprint(w, "\r"+progressLine(event)+"\n")
+	}
+}
+
+// progressLine renders a progress event as a single line
+func progressLine(event gosmm.Event) string {
+	prefix := fmt.Sprintf("[%d/%d] %s", event.Index, event.Total, event.Migration.Filename)
+	if event.Target != "" {
+		// the tenants are migrated concurrently, so their lines are interleaved
+		prefix = event.Target + " " + prefix
+	}
+	switch event.Kind {
+	case gosmm.EventMigrationStarted:
+		return prefix + " started"
+	case gosmm.EventStatementExecuted:
+		if event.FileSize > 0 {
+			// the statements of a streamed file are not counted in advance
+			percent := int(event.BytesRead * 100 / event.FileSize)
+			return fmt.Sprintf("%s %s %d statements, %d%% read", prefix, progressBar(percent, 100, progressBarWidth), event.StatementIndex, percent)
+		}
+		return fmt.Sprintf("%s %s %d/%d statements", prefix, progressBar(event.StatementIndex, event.StatementCount, progressBarWidth), event.StatementIndex, event.StatementCount)
+	case gosmm.EventMigrationFinished:
+		return fmt.Sprintf("%s finished in %s", prefix, event.Duration.Round(time.Millisecond))
+	case gosmm.EventMigrationFailed:
+		return fmt.Sprintf("%s failed after %s", prefix, event.Duration.Round(time.Millisecond))
+	default:
+		return prefix
+	}
+}
+
+// progressBar renders done out of total as a bar of the given width
+func progressBar(done int, total int, width int) string {
+	filled := width
+	if total > 0 {
+		filled = done * width / total
+	}
+	return "[" + strings.Repeat("#", filled) + strings.Repeat(".", width-filled) + "]"
+}
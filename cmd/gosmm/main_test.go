@@ -2,11 +2,24 @@ package main
 
 import (
 	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
 	"database/sql"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/k1e1n04/gosmm/v2/pkg/gosmm"
 	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
+	"syscall"
 	"testing"
+	"time"
 )
 
 const (
@@ -25,6 +38,9 @@ func setupTestDB(t *testing.T) (*sql.DB, func()) {
 }
 
 func TestExecuteStatusCommand(t *testing.T) {
+	os.Setenv("GOSMM_MIGRATIONS_DIR", t.TempDir())
+	defer os.Unsetenv("GOSMM_MIGRATIONS_DIR")
+
 	// Set up a mock DB and teardown function
 	db, teardown := setupTestDB(t)
 	defer teardown()
@@ -35,7 +51,7 @@ func TestExecuteStatusCommand(t *testing.T) {
 	os.Stdout = w
 
 	// Test the "status" command
-	err := executeCommand(db, "status", "sqlite3")
+	err := executeCommand(db, "status", nil, "sqlite3")
 	assert.NoError(t, err)
 
 	// Restore stdout
@@ -52,6 +68,33 @@ func TestExecuteStatusCommand(t *testing.T) {
 	}
 }
 
+func TestStatusCommandExitCodeOnFailure(t *testing.T) {
+	t.Setenv("GOSMM_DRIVER", "postgres")
+	t.Setenv("GOSMM_HOST", "127.0.0.1")
+	t.Setenv("GOSMM_PORT", "1")
+	t.Setenv("GOSMM_USER", "gosmm")
+	t.Setenv("GOSMM_DBNAME", "gosmm")
+	t.Setenv("GOSMM_SSL_MODE", "disable")
+	t.Setenv("GOSMM_CONNECT_TIMEOUT", "1s")
+
+	// an unreachable database is not a database with pending migrations
+	err := runCommand("status", nil)
+	var exit *exitError
+	if assert.ErrorAs(t, err, &exit) {
+		assert.Equal(t, statusErrorExitCode, exit.code)
+	}
+	assert.Equal(t, statusErrorExitCode, newCommandError(err).ExitCode)
+
+	// nor is a configuration failing to load in the dirty check
+	db, teardown := setupTestDB(t)
+	defer teardown()
+	t.Setenv("GOSMM_WAIT_FOR_LOCK", "soon")
+	err = executeCommand(db, "status", nil, "sqlite3")
+	if assert.ErrorAs(t, err, &exit) {
+		assert.Equal(t, statusErrorExitCode, exit.code)
+	}
+}
+
 func TestExecuteRestoreCommand(t *testing.T) {
 	// Set up a mock DB and teardown function
 	db, teardown := setupTestDB(t)
@@ -63,7 +106,7 @@ func TestExecuteRestoreCommand(t *testing.T) {
 	os.Stdout = w
 
 	// Test the "restore" command
-	err := executeCommand(db, "restore", "sqlite3")
+	err := executeCommand(db, "restore", nil, "sqlite3")
 	assert.NoError(t, err)
 
 	// Restore stdout
@@ -102,7 +145,7 @@ func TestExecuteMigrateCommand(t *testing.T) {
 	os.Stdout = w
 
 	// Test the "migrate" command
-	err := executeCommand(db, "migrate", "sqlite3")
+	err := executeCommand(db, "migrate", nil, "sqlite3")
 	assert.NoError(t, err)
 
 	// Restore stdout
@@ -130,7 +173,7 @@ func TestExecuteUnknownCommand(t *testing.T) {
 	os.Stdout = w
 
 	// Test an unknown command
-	err := executeCommand(db, "unknown", "sqlite3")
+	err := executeCommand(db, "unknown", nil, "sqlite3")
 	assert.NoError(t, err)
 
 	// Restore stdout
@@ -146,3 +189,1032 @@ func TestExecuteUnknownCommand(t *testing.T) {
 		t.Errorf("Unexpected output: %s", output)
 	}
 }
+
+func TestExecuteValidateCommand(t *testing.T) {
+	// set GOSMM_MIGRATIONS_DIR to the test migrations directory
+	os.Setenv("GOSMM_MIGRATIONS_DIR", migrationsDir)
+
+	// Create test_migrations directory if it doesn't exist
+	if _, err := os.Stat(migrationsDir); os.IsNotExist(err) {
+		err := os.Mkdir(migrationsDir, 0755)
+		if err != nil {
+			t.Fatalf("Failed to create test_migrations directory: %v", err)
+		}
+	}
+
+	// Set up a mock DB and teardown function
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	// Capture stdout
+	old := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+
+	// Test the "validate" command
+	err := executeCommand(db, "validate", nil, "sqlite3")
+	assert.NoError(t, err)
+
+	// Restore stdout
+	w.Close()
+	os.Stdout = old
+
+	var buf bytes.Buffer
+	buf.ReadFrom(r)
+	output := buf.String()
+
+	// Validate the output
+	if !strings.Contains(output, "Validation completed successfully.") {
+		t.Errorf("Unexpected output: %s", output)
+	}
+}
+
+func TestExecuteDoctorCommand(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"v20230101_create_users_00001.sql": "CREATE TABLE users (id INTEGER);",
+		"v20230102_add_email_00002.sql":    "ALTER TABLE users ADD COLUMN email TEXT;",
+		"v20230103_add_name_00002.sql":     "ALTER TABLE users ADD COLUMN name TEXT;",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test migration file: %v", err)
+		}
+	}
+	os.Setenv("GOSMM_MIGRATIONS_DIR", dir)
+	defer os.Unsetenv("GOSMM_MIGRATIONS_DIR")
+
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	// doctor reports the duplicate sequence numbers like validate, and renumbers them with --fix
+	err := executeCommand(db, "doctor", nil, "sqlite3")
+	assert.ErrorContains(t, err, "doctor failed")
+	assert.NoError(t, executeCommand(db, "doctor", []string{"--fix"}, "sqlite3"))
+	assert.FileExists(t, filepath.Join(dir, "v20230103_add_name_00003.sql"))
+}
+
+func TestExecuteCleanCommand(t *testing.T) {
+	os.Setenv("GOSMM_ALLOW_CLEAN", "true")
+	defer os.Unsetenv("GOSMM_ALLOW_CLEAN")
+
+	// Set up a mock DB and teardown function
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	_, err := db.Exec("CREATE TABLE test_table (id INTEGER)")
+	if err != nil {
+		t.Fatalf("Failed to create test_table: %v", err)
+	}
+
+	// Capture stdout
+	old := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+
+	// Test the "clean" command
+	err = executeCommand(db, "clean", nil, "sqlite3")
+	assert.NoError(t, err)
+
+	// Restore stdout
+	w.Close()
+	os.Stdout = old
+
+	var buf bytes.Buffer
+	buf.ReadFrom(r)
+	output := buf.String()
+
+	// Validate the output
+	if !strings.Contains(output, "Clean completed successfully.") {
+		t.Errorf("Unexpected output: %s", output)
+	}
+}
+
+func TestExecuteSeedCommand(t *testing.T) {
+	seedsDir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(seedsDir, "roles.sql"), []byte("INSERT INTO roles VALUES ('admin');"), 0644); err != nil {
+		t.Fatalf("Failed to create seed file: %v", err)
+	}
+	os.Setenv("GOSMM_SEEDS_DIR", seedsDir)
+	defer os.Unsetenv("GOSMM_SEEDS_DIR")
+
+	// Set up a mock DB and teardown function
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	_, err := db.Exec("CREATE TABLE roles (name TEXT)")
+	if err != nil {
+		t.Fatalf("Failed to create roles table: %v", err)
+	}
+
+	// Capture stdout
+	old := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+
+	// Test the "seed" command
+	err = executeCommand(db, "seed", nil, "sqlite3")
+	assert.NoError(t, err)
+
+	// Restore stdout
+	w.Close()
+	os.Stdout = old
+
+	var buf bytes.Buffer
+	buf.ReadFrom(r)
+	output := buf.String()
+
+	// Validate the output
+	if !strings.Contains(output, "Seed completed successfully.") {
+		t.Errorf("Unexpected output: %s", output)
+	}
+}
+
+func TestExecuteForceCommand(t *testing.T) {
+	// Set up a mock DB and teardown function
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	_, err := db.Exec(`CREATE TABLE gosmm_migration_history (installed_rank INTEGER, filename TEXT, installed_on TIMESTAMP, execution_time INTEGER, success BOOLEAN)`)
+	if err != nil {
+		t.Fatalf("Failed to create gosmm_migration_history table: %v", err)
+	}
+	_, err = db.Exec(`INSERT INTO gosmm_migration_history VALUES (1, 'v20230101_create_test_data_00001.sql', '2023-01-01 00:00:00', 5, FALSE)`)
+	if err != nil {
+		t.Fatalf("Failed to insert gosmm_migration_history entry: %v", err)
+	}
+
+	// Capture stdout
+	old := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+
+	// Test the "force" command
+	err = executeCommand(db, "force", []string{"--not-applied", "v20230101_create_test_data_00001.sql"}, "sqlite3")
+	assert.NoError(t, err)
+
+	// Restore stdout
+	w.Close()
+	os.Stdout = old
+
+	var buf bytes.Buffer
+	buf.ReadFrom(r)
+	output := buf.String()
+
+	// Validate the output
+	if !strings.Contains(output, "Force completed successfully.") {
+		t.Errorf("Unexpected output: %s", output)
+	}
+
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM gosmm_migration_history").Scan(&count)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	// The filename is required
+	err = executeCommand(db, "force", nil, "sqlite3")
+	assert.Error(t, err)
+}
+
+func TestExecuteMarkAppliedCommand(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	os.Setenv("GOSMM_MIGRATIONS_DIR", dir)
+	defer os.Unsetenv("GOSMM_MIGRATIONS_DIR")
+
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	err := executeCommand(db, "mark-applied", []string{filepath.Join(dir, "v20230101_create_users_00001.sql")}, "sqlite3")
+	assert.NoError(t, err)
+
+	// The migration was recorded without being executed
+	var success bool
+	err = db.QueryRow("SELECT success FROM gosmm_migration_history WHERE filename = 'v20230101_create_users_00001.sql'").Scan(&success)
+	assert.NoError(t, err)
+	assert.True(t, success)
+	_, err = db.Exec("SELECT id FROM users")
+	assert.Error(t, err)
+
+	// A recorded migration is refused, and the file is required
+	assert.Error(t, executeCommand(db, "mark-applied", []string{"v20230101_create_users_00001.sql"}, "sqlite3"))
+	assert.Error(t, executeCommand(db, "mark-applied", nil, "sqlite3"))
+}
+
+func TestExecuteApplyCommand(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"v20230101_create_users_00001.sql":  "CREATE TABLE users (id INTEGER);",
+		"v20230102_create_orders_00001.sql": "CREATE TABLE orders (id INTEGER);",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test migration file: %v", err)
+		}
+	}
+	os.Setenv("GOSMM_MIGRATIONS_DIR", dir)
+	defer os.Unsetenv("GOSMM_MIGRATIONS_DIR")
+
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	// The file is required, and must be the next pending migration unless applied ahead
+	assert.Error(t, executeCommand(db, "apply", nil, "sqlite3"))
+	assert.Error(t, executeCommand(db, "apply", []string{"--file", "v20230102_create_orders_00001.sql"}, "sqlite3"))
+	report := filepath.Join(t.TempDir(), "report.json")
+	assert.NoError(t, executeCommand(db, "apply", []string{"--file", "v20230102_create_orders_00001.sql", "--ahead", "--report", report}, "sqlite3"))
+	data, err := ioutil.ReadFile(report)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"filename": "v20230102_create_orders_00001.sql"`)
+
+	_, err = db.Exec("SELECT id FROM orders")
+	assert.NoError(t, err)
+	_, err = db.Exec("SELECT id FROM users")
+	assert.Error(t, err)
+}
+
+func TestInterruptible(t *testing.T) {
+	var config gosmm.MigrationConfig
+	ctx, release := interruptible(&config)
+	defer release()
+
+	// The first signal stops the run, the second aborts it
+	if err := syscall.Kill(os.Getpid(), syscall.SIGINT); err != nil {
+		t.Fatalf("Failed to send SIGINT: %v", err)
+	}
+	select {
+	case <-config.Stop:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The run was not stopped")
+	}
+	assert.NoError(t, ctx.Err())
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatalf("Failed to send SIGTERM: %v", err)
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("The run was not aborted")
+	}
+
+	var exit *exitError
+	assert.True(t, errors.As(interruptedExit(fmt.Errorf("migration failed: %w", gosmm.ErrInterrupted)), &exit))
+	assert.Equal(t, interruptedExitCode, exit.code)
+}
+
+func TestExecuteRedoCommand(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER);\n-- gosmm:down\nDROP TABLE users;"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	os.Setenv("GOSMM_MIGRATIONS_DIR", dir)
+	defer os.Unsetenv("GOSMM_MIGRATIONS_DIR")
+
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	// Nothing to redo yet
+	assert.Error(t, executeCommand(db, "redo", nil, "sqlite3"))
+
+	assert.NoError(t, gosmm.MigrateWithConfig(db, gosmm.MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}))
+	_, err := db.Exec("INSERT INTO users (id) VALUES (1)")
+	assert.NoError(t, err)
+	assert.NoError(t, executeCommand(db, "redo", nil, "sqlite3"))
+
+	// The table was dropped and created again
+	var count int
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count))
+	assert.Equal(t, 0, count)
+}
+
+func TestExecuteHistoryCommand(t *testing.T) {
+	// Set up a mock DB and teardown function
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	_, err := db.Exec(`CREATE TABLE gosmm_migration_history (installed_rank INTEGER, filename TEXT, installed_on TIMESTAMP, execution_time INTEGER, success BOOLEAN)`)
+	if err != nil {
+		t.Fatalf("Failed to create gosmm_migration_history table: %v", err)
+	}
+	_, err = db.Exec(`INSERT INTO gosmm_migration_history VALUES (1, 'v20230101_create_test_data_00001.sql', '2023-01-01 00:00:00', 5, TRUE)`)
+	if err != nil {
+		t.Fatalf("Failed to insert gosmm_migration_history entry: %v", err)
+	}
+
+	// Capture stdout
+	old := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+
+	// Test the "history" command
+	err = executeCommand(db, "history", []string{"--format", "csv"}, "sqlite3")
+	assert.NoError(t, err)
+
+	// Restore stdout
+	w.Close()
+	os.Stdout = old
+
+	var buf bytes.Buffer
+	buf.ReadFrom(r)
+	output := buf.String()
+
+	// Validate the output
+	assert.Equal(t, "installed_rank,filename,installed_on,execution_time,success,checksum,failed_statement,committed_statements,author,ticket,description,backup,applied_by,context,rows_affected,approval,ahead\n"+
+		"1,v20230101_create_test_data_00001.sql,2023-01-01T00:00:00Z,5,true,,,,,,,,,,,,\n", output)
+}
+
+func TestProgressLine(t *testing.T) {
+	migration := gosmm.MigrationInfo{Filename: "v20230101_create_test_data_00001.sql"}
+	assert.Equal(t, "[1/2] v20230101_create_test_data_00001.sql started",
+		progressLine(gosmm.Event{Kind: gosmm.EventMigrationStarted, Migration: migration, Index: 1, Total: 2}))
+	assert.Equal(t, "[1/2] v20230101_create_test_data_00001.sql [##########..........] 2/4 statements",
+		progressLine(gosmm.Event{Kind: gosmm.EventStatementExecuted, Migration: migration, Index: 1, Total: 2, StatementIndex: 2, StatementCount: 4}))
+	assert.Equal(t, "[1/2] v20230101_create_test_data_00001.sql [#####...............] 120 statements, 25% read",
+		progressLine(gosmm.Event{Kind: gosmm.EventStatementExecuted, Migration: migration, Index: 1, Total: 2, StatementIndex: 120, BytesRead: 1 << 20, FileSize: 4 << 20}))
+	assert.Equal(t, "[1/2] v20230101_create_test_data_00001.sql finished in 1.5s",
+		progressLine(gosmm.Event{Kind: gosmm.EventMigrationFinished, Migration: migration, Index: 1, Total: 2, Duration: 1500 * time.Millisecond}))
+	assert.Equal(t, "tenant_a [1/2] v20230101_create_test_data_00001.sql started",
+		progressLine(gosmm.Event{Kind: gosmm.EventMigrationStarted, Migration: migration, Index: 1, Total: 2, Target: "tenant_a"}))
+}
+
+func TestExecutePreflightCommand(t *testing.T) {
+	os.Setenv("GOSMM_MIGRATIONS_DIR", t.TempDir())
+	defer os.Unsetenv("GOSMM_MIGRATIONS_DIR")
+
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	// Capture stdout
+	old := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+
+	err := executeCommand(db, "preflight", nil, "sqlite3")
+	assert.NoError(t, err)
+
+	// Restore stdout
+	w.Close()
+	os.Stdout = old
+
+	var buf bytes.Buffer
+	buf.ReadFrom(r)
+	assert.Contains(t, buf.String(), "PASSED   connectivity")
+	assert.Contains(t, buf.String(), "Preflight checks passed.")
+
+	// A missing migrations directory fails the checks
+	os.Setenv("GOSMM_MIGRATIONS_DIR", filepath.Join(t.TempDir(), "missing"))
+	old = os.Stdout
+	_, w, _ = os.Pipe()
+	os.Stdout = w
+	err = executeCommand(db, "preflight", nil, "sqlite3")
+	w.Close()
+	os.Stdout = old
+	assert.Error(t, err)
+}
+
+func TestExecuteMigrateCommandWithTenants(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte(`CREATE TABLE "${schema}".users (id INTEGER);`), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	os.Setenv("GOSMM_MIGRATIONS_DIR", dir)
+	defer os.Unsetenv("GOSMM_MIGRATIONS_DIR")
+	os.Setenv("GOSMM_TENANT_SCHEMAS", "tenant_a,tenant_b")
+	defer os.Unsetenv("GOSMM_TENANT_SCHEMAS")
+
+	db, teardown := setupTestDB(t)
+	defer teardown()
+	// SQLite schemas are attached databases, which only exist on the connection attaching them
+	db.SetMaxOpenConns(1)
+	for _, schema := range []string{"tenant_a", "tenant_b"} {
+		if _, err := db.Exec("ATTACH DATABASE ':memory:' AS " + schema); err != nil {
+			t.Fatalf("Failed to attach tenant schema: %v", err)
+		}
+	}
+
+	old := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+
+	err := executeCommand(db, "migrate", nil, "sqlite3")
+	assert.NoError(t, err)
+
+	w.Close()
+	os.Stdout = old
+
+	var buf bytes.Buffer
+	buf.ReadFrom(r)
+	assert.Contains(t, buf.String(), "Migration of 2 tenant(s) completed successfully.")
+
+	for _, schema := range []string{"tenant_a", "tenant_b"} {
+		var count int
+		err = db.QueryRow("SELECT COUNT(*) FROM " + schema + ".users").Scan(&count)
+		assert.NoError(t, err, schema)
+	}
+}
+
+func TestExecuteStatusCommandWithJSONFormat(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	os.Setenv("GOSMM_MIGRATIONS_DIR", dir)
+	defer os.Unsetenv("GOSMM_MIGRATIONS_DIR")
+
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	status := func() (gosmm.StatusReport, error) {
+		old := os.Stdout
+		r, w, _ := os.Pipe()
+		os.Stdout = w
+		err := executeCommand(db, "status", []string{"--format", "json"}, "sqlite3")
+		w.Close()
+		os.Stdout = old
+
+		var report gosmm.StatusReport
+		if decodeErr := json.NewDecoder(r).Decode(&report); decodeErr != nil {
+			t.Fatalf("Failed to decode status: %v", decodeErr)
+		}
+		return report, err
+	}
+
+	// Pending migrations exit with 1
+	report, err := status()
+	var exit *exitError
+	assert.True(t, errors.As(err, &exit))
+	assert.Equal(t, 1, exit.code)
+	assert.Equal(t, gosmm.StatePending, report.State)
+	assert.Equal(t, 1, report.Pending)
+
+	// Up to date exits with 0
+	assert.NoError(t, gosmm.MigrateWithConfig(db, gosmm.MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}))
+	report, err = status()
+	assert.NoError(t, err)
+	assert.Equal(t, gosmm.StateUpToDate, report.State)
+
+	// A failed migration exits with 2
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230102_add_email_00002.sql"), []byte("ALTER TABLE missing_table ADD COLUMN email TEXT;"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	assert.Error(t, gosmm.MigrateWithConfig(db, gosmm.MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}))
+	report, err = status()
+	assert.True(t, errors.As(err, &exit))
+	assert.Equal(t, 2, exit.code)
+	assert.Equal(t, gosmm.StateDirty, report.State)
+
+	// An unsupported format exits with 3
+	err = executeCommand(db, "status", []string{"--format", "xml"}, "sqlite3")
+	assert.True(t, errors.As(err, &exit))
+	assert.Equal(t, statusErrorExitCode, exit.code)
+}
+
+func TestExecuteCheckCommand(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	os.Setenv("GOSMM_MIGRATIONS_DIR", dir)
+	defer os.Unsetenv("GOSMM_MIGRATIONS_DIR")
+
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	// The pending migration fails the check
+	err := executeCommand(db, "check", nil, "sqlite3")
+	assert.ErrorIs(t, err, gosmm.ErrPendingMigrations)
+
+	assert.NoError(t, gosmm.MigrateWithConfig(db, gosmm.MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}))
+
+	// Capture stdout
+	old := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+
+	err = executeCommand(db, "check", nil, "sqlite3")
+	assert.NoError(t, err)
+
+	// Restore stdout
+	w.Close()
+	os.Stdout = old
+
+	var buf bytes.Buffer
+	buf.ReadFrom(r)
+	assert.Contains(t, buf.String(), "Check completed successfully.")
+}
+
+func TestExecuteMigrateCommandWithConfirmation(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER);\nCREATE INDEX users_id ON users (id);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	os.Setenv("GOSMM_MIGRATIONS_DIR", dir)
+	defer os.Unsetenv("GOSMM_MIGRATIONS_DIR")
+	os.Setenv("GOSMM_CONFIRM", "true")
+	defer os.Unsetenv("GOSMM_CONFIRM")
+	defer func(input io.Reader) { confirmInput = input }(confirmInput)
+
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	migrate := func(answer string, args ...string) (string, error) {
+		confirmInput = strings.NewReader(answer)
+		old := os.Stdout
+		r, w, _ := os.Pipe()
+		os.Stdout = w
+		err := executeCommand(db, "migrate", args, "sqlite3")
+		w.Close()
+		os.Stdout = old
+
+		var buf bytes.Buffer
+		buf.ReadFrom(r)
+		return buf.String(), err
+	}
+
+	// Any answer but yes cancels the run
+	output, err := migrate("no\n")
+	assert.EqualError(t, err, "migration cancelled")
+	assert.Contains(t, output, "1. v20230101_create_users_00001.sql (2 statement(s))")
+	report, err := gosmm.Status(db, gosmm.MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Pending)
+
+	output, err = migrate("yes\n")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "Migration completed successfully.")
+
+	// --auto-approve skips the confirmation
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230102_add_email_00002.sql"), []byte("ALTER TABLE users ADD COLUMN email TEXT;"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	output, err = migrate("", "--auto-approve")
+	assert.NoError(t, err)
+	assert.NotContains(t, output, "Do you want to proceed?")
+	assert.Contains(t, output, "Migration completed successfully.")
+}
+
+func TestWriteCompletion(t *testing.T) {
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		var buf bytes.Buffer
+		err := writeCompletion(&buf, []string{shell})
+		assert.NoError(t, err)
+		for _, c := range commands {
+			assert.Contains(t, buf.String(), c.name, shell)
+		}
+		assert.Contains(t, buf.String(), "auto-approve", shell)
+		assert.Contains(t, buf.String(), "golang-migrate", shell)
+	}
+
+	var buf bytes.Buffer
+	assert.EqualError(t, writeCompletion(&buf, []string{"powershell"}), "unsupported shell: powershell")
+	assert.Error(t, writeCompletion(&buf, nil))
+}
+
+func TestPrintStatus(t *testing.T) {
+	installedOn := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	report := gosmm.StatusReport{
+		State:   gosmm.StateDirty,
+		Applied: 1,
+		Pending: 1,
+		Failed:  1,
+		Migrations: []gosmm.MigrationStatus{
+			{Filename: "v20230101_create_users_00001.sql", State: gosmm.MigrationApplied, InstalledRank: 1, InstalledOn: &installedOn, ExecutionTime: 12, RowsAffected: 1500},
+			{Filename: "v20230102_add_email_00002.sql", State: gosmm.MigrationFailed, InstalledRank: 2, InstalledOn: &installedOn, ExecutionTime: 3, FailedStatement: 2},
+			{Filename: "v20230103_add_name_00003.sql", State: gosmm.MigrationPending},
+		},
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, printStatus(&buf, report, false))
+	assert.Equal(t, `Migration Status:
+RANK  FILENAME                          INSTALLED ON         EXECUTION TIME (ms)  ROWS  STATE
+1     v20230101_create_users_00001.sql  2023-01-01 12:00:00  12                   1500  applied
+2     v20230102_add_email_00002.sql     2023-01-01 12:00:00  3                    0     failed (statement 2)
+-     v20230103_add_name_00003.sql      -                    -                    -     pending
+State: dirty (1 applied, 1 pending, 1 failed)
+`, buf.String())
+
+	buf.Reset()
+	assert.NoError(t, printStatus(&buf, report, true))
+	assert.Contains(t, buf.String(), "\033[32mapplied\033[0m")
+	assert.Contains(t, buf.String(), "\033[31mfailed\033[0m (statement 2)")
+	assert.Contains(t, buf.String(), "State: \033[31mdirty\033[0m")
+
+	report.Migrations[0].Author, report.Migrations[0].Ticket = "Jane Doe", "PROJ-1"
+	report.Migrations[2].Description = "Add the name of the users"
+	buf.Reset()
+	assert.NoError(t, printStatus(&buf, report, false))
+	assert.Equal(t, `Migration Status:
+RANK  FILENAME                          INSTALLED ON         EXECUTION TIME (ms)  ROWS  AUTHOR    TICKET  DESCRIPTION                STATE
+1     v20230101_create_users_00001.sql  2023-01-01 12:00:00  12                   1500  Jane Doe  PROJ-1  -                          applied
+2     v20230102_add_email_00002.sql     2023-01-01 12:00:00  3                    0     -         -       -                          failed (statement 2)
+-     v20230103_add_name_00003.sql      -                    -                    -     -         -       Add the name of the users  pending
+State: dirty (1 applied, 1 pending, 1 failed)
+`, buf.String())
+
+	report.Migrations[2].State, report.Pending, report.Skipped = gosmm.MigrationSkipped, 0, 1
+	buf.Reset()
+	assert.NoError(t, printStatus(&buf, report, false))
+	assert.Contains(t, buf.String(), "Add the name of the users  skipped\n")
+	assert.Contains(t, buf.String(), "State: dirty (1 applied, 0 pending, 1 failed, 1 skipped)\n")
+}
+
+func TestExecuteMigrateCommandWithLease(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	os.Setenv("GOSMM_MIGRATIONS_DIR", dir)
+	defer os.Unsetenv("GOSMM_MIGRATIONS_DIR")
+
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	err := executeCommand(db, "migrate", []string{"--lease", "--wait-for-lock", "5m"}, "sqlite3")
+	assert.NoError(t, err)
+
+	// The lease was released
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM gosmm_migration_lock").Scan(&count)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	err = executeCommand(db, "migrate", []string{"--wait-for-lock", "soon"}, "sqlite3")
+	assert.Error(t, err)
+	err = executeCommand(db, "migrate", []string{"--lock-timeout", "5s"}, "sqlite3")
+	assert.NoError(t, err)
+}
+
+func TestExecuteSquashCommand(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "migrations")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatalf("Failed to create migrations directory: %v", err)
+	}
+	for name, content := range map[string]string{
+		"v20230101_create_users_00001.sql": "CREATE TABLE users (id INTEGER);",
+		"v20230102_add_email_00002.sql":    "ALTER TABLE users ADD COLUMN email TEXT;",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test migration file: %v", err)
+		}
+	}
+	os.Setenv("GOSMM_MIGRATIONS_DIR", dir)
+	defer os.Unsetenv("GOSMM_MIGRATIONS_DIR")
+
+	db, teardown := setupTestDB(t)
+	defer teardown()
+	assert.NoError(t, gosmm.Migrate(db, dir, "sqlite3"))
+
+	// The version is required
+	err := executeCommand(db, "squash", nil, "sqlite3")
+	assert.Error(t, err)
+
+	old := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+	err = executeCommand(db, "squash", []string{"--before", "v20230201"}, "sqlite3")
+	w.Close()
+	os.Stdout = old
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	buf.ReadFrom(r)
+	assert.Contains(t, buf.String(), "Squashed 2 migrations into v20230102_baseline_00002.sql, archived in "+dir+"_archive.")
+	_, err = os.Stat(filepath.Join(dir+"_archive", "v20230101_create_users_00001.sql"))
+	assert.NoError(t, err)
+}
+
+func TestExecuteDumpCommand(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	os.Setenv("GOSMM_MIGRATIONS_DIR", dir)
+	defer os.Unsetenv("GOSMM_MIGRATIONS_DIR")
+
+	db, teardown := setupTestDB(t)
+	defer teardown()
+	assert.NoError(t, gosmm.Migrate(db, dir, "sqlite3"))
+
+	old := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+	err := executeCommand(db, "dump", nil, "sqlite3")
+	w.Close()
+	os.Stdout = old
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	buf.ReadFrom(r)
+	assert.Equal(t, "-- Schema after v20230101_create_users_00001.sql, generated by gosmm. Do not edit.\n\n-- table: users\nCREATE TABLE users (id INTEGER);\n", buf.String())
+}
+
+func TestExecuteSchemaAtCommand(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"v20230101_create_users_00001.sql": "CREATE TABLE users (id INTEGER);",
+		"v20230102_add_email_00002.sql":    "ALTER TABLE users ADD COLUMN email TEXT;",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test migration file: %v", err)
+		}
+	}
+	os.Setenv("GOSMM_MIGRATIONS_DIR", dir)
+	defer os.Unsetenv("GOSMM_MIGRATIONS_DIR")
+
+	db, teardown := setupTestDB(t)
+	defer teardown()
+	assert.NoError(t, gosmm.Migrate(db, dir, "sqlite3"))
+
+	old := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+	err := executeCommand(db, "schema-at", []string{"v20230101_create_users_00001"}, "sqlite3")
+	w.Close()
+	os.Stdout = old
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	buf.ReadFrom(r)
+	assert.Equal(t, "-- Schema after v20230101_create_users_00001.sql, generated by gosmm. Do not edit.\n\n-- table: users\nCREATE TABLE users (id INTEGER);\n", buf.String())
+
+	// The schema is written to the given writer only
+	loaded, err := loadConfig("sqlite3")
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	loaded.DB.Driver = "sqlite3"
+	buf.Reset()
+	assert.NoError(t, printSchemaAt(&buf, loaded, "v20230102_add_email_00002"))
+	assert.Contains(t, buf.String(), "-- Schema after v20230102_add_email_00002.sql")
+
+	assert.Error(t, executeCommand(db, "schema-at", []string{"v20230103_missing_00003.sql"}, "sqlite3"))
+	assert.Error(t, executeCommand(db, "schema-at", nil, "sqlite3"))
+}
+
+func TestExecuteDriftCommand(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	schemaFile := filepath.Join(t.TempDir(), "schema.sql")
+	os.Setenv("GOSMM_MIGRATIONS_DIR", dir)
+	defer os.Unsetenv("GOSMM_MIGRATIONS_DIR")
+	os.Setenv("GOSMM_SCHEMA_FILE", schemaFile)
+	defer os.Unsetenv("GOSMM_SCHEMA_FILE")
+
+	db, teardown := setupTestDB(t)
+	defer teardown()
+	assert.NoError(t, gosmm.MigrateWithConfig(db, gosmm.MigrationConfig{MigrationsDir: dir, Driver: "sqlite3", SchemaFile: schemaFile}))
+	_, err := db.Exec("CREATE INDEX users_id ON users (id)")
+	assert.NoError(t, err)
+
+	old := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+	err = executeCommand(db, "drift", nil, "sqlite3")
+	w.Close()
+	os.Stdout = old
+	assert.EqualError(t, err, "schema drift: 1 object(s) differ from "+schemaFile)
+
+	var buf bytes.Buffer
+	buf.ReadFrom(r)
+	assert.Equal(t, "ADDED index: users.users_id\n", buf.String())
+
+	// A missing snapshot is an error
+	err = executeCommand(db, "drift", []string{"--schema-file", filepath.Join(dir, "missing.sql")}, "sqlite3")
+	assert.Error(t, err)
+}
+
+func TestPrintDefinitionDiff(t *testing.T) {
+	var buf bytes.Buffer
+	printDefinitionDiff(&buf, "CREATE TABLE users (\n    id integer,\n    email text\n)", "CREATE TABLE users (\n    id integer,\n    email character varying(255)\n)")
+	assert.Equal(t, "  - email text\n  + email character varying(255)\n", buf.String())
+}
+
+func TestExecuteLintCommand(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER, name TEXT);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	os.Setenv("GOSMM_MIGRATIONS_DIR", dir)
+	defer os.Unsetenv("GOSMM_MIGRATIONS_DIR")
+
+	db, teardown := setupTestDB(t)
+	defer teardown()
+	assert.NoError(t, executeCommand(db, "lint", nil, "sqlite3"))
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230102_drop_name_00002.sql"), []byte("ALTER TABLE users DROP COLUMN name;"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	old := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+	err := executeCommand(db, "lint", nil, "sqlite3")
+	w.Close()
+	os.Stdout = old
+	assert.EqualError(t, err, "lint failed: 1 issue(s) in the pending migrations")
+
+	var buf bytes.Buffer
+	buf.ReadFrom(r)
+	assert.Contains(t, buf.String(), "v20230102_drop_name_00002.sql:1: drop-column: dropping column(s) name of users")
+
+	// Rules can be disabled
+	os.Setenv("GOSMM_LINT_DISABLE", "drop-column")
+	defer os.Unsetenv("GOSMM_LINT_DISABLE")
+	assert.NoError(t, executeCommand(db, "lint", nil, "sqlite3"))
+}
+
+func TestExecuteEstimateCommand(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230102_create_orders_00002.sql"), []byte("CREATE TABLE orders (id INTEGER);\nUPDATE users SET name = 'a';"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	os.Setenv("GOSMM_MIGRATIONS_DIR", dir)
+	defer os.Unsetenv("GOSMM_MIGRATIONS_DIR")
+
+	db, teardown := setupTestDB(t)
+	defer teardown()
+	_, err := db.Exec("CREATE TABLE users (id INTEGER, name TEXT)")
+	assert.NoError(t, err)
+	_, err = db.Exec("INSERT INTO users (id, name) VALUES (1, 'a'), (2, 'b')")
+	assert.NoError(t, err)
+
+	old := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+	err = executeCommand(db, "estimate", []string{"--format", "json"}, "sqlite3")
+	w.Close()
+	os.Stdout = old
+	assert.NoError(t, err)
+
+	var estimates []gosmm.MigrationEstimate
+	assert.NoError(t, json.NewDecoder(r).Decode(&estimates))
+	assert.Len(t, estimates, 1)
+	assert.Equal(t, []gosmm.TableEstimate{{Name: "orders", New: true}, {Name: "users", Rows: 2, Bytes: -1}}, estimates[0].Tables)
+
+	var buf bytes.Buffer
+	assert.NoError(t, printEstimates(&buf, estimates))
+	assert.Contains(t, buf.String(), "v20230102_create_orders_00002.sql: 2 statement(s)")
+	assert.Contains(t, buf.String(), "  users   2     ?")
+
+	assert.Error(t, executeCommand(db, "estimate", []string{"--format", "xml"}, "sqlite3"))
+}
+
+func TestParseOutput(t *testing.T) {
+	output, args, err := parseOutput([]string{"--output", "json", "migrate", "--auto-approve"})
+	assert.NoError(t, err)
+	assert.Equal(t, outputJSON, output)
+	assert.Equal(t, []string{"migrate", "--auto-approve"}, args)
+
+	output, args, err = parseOutput([]string{"status", "--format", "json", "-output=json"})
+	assert.NoError(t, err)
+	assert.Equal(t, outputJSON, output)
+	assert.Equal(t, []string{"status", "--format", "json"}, args)
+
+	output, args, err = parseOutput([]string{"migrate"})
+	assert.NoError(t, err)
+	assert.Equal(t, outputText, output)
+	assert.Equal(t, []string{"migrate"}, args)
+
+	_, _, err = parseOutput([]string{"migrate", "--output", "yaml"})
+	assert.EqualError(t, err, "unsupported output: yaml")
+	_, _, err = parseOutput([]string{"migrate", "--output"})
+	assert.Error(t, err)
+}
+
+func TestExecuteJSON(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	os.Setenv("GOSMM_MIGRATIONS_DIR", dir)
+	defer os.Unsetenv("GOSMM_MIGRATIONS_DIR")
+
+	db, teardown := setupTestDB(t)
+	defer teardown()
+	run := func(command string, args ...string) (map[string]interface{}, error) {
+		var buf bytes.Buffer
+		err := executeJSON(&buf, func() error {
+			return executeCommand(db, command, args, "sqlite3")
+		}, command)
+		var output map[string]interface{}
+		assert.NoError(t, json.Unmarshal(buf.Bytes(), &output), buf.String())
+		return output, err
+	}
+
+	// Pending migrations fail status with the code of their state
+	output, err := run("status")
+	assert.Error(t, err)
+	assert.Equal(t, false, output["success"])
+	assert.Equal(t, "pending_migrations", output["error"].(map[string]interface{})["code"])
+	assert.Equal(t, float64(1), output["error"].(map[string]interface{})["exit_code"])
+	assert.Equal(t, "pending", output["result"].(map[string]interface{})["state"])
+
+	output, err = run("migrate")
+	assert.NoError(t, err)
+	assert.Equal(t, "migrate", output["command"])
+	assert.Equal(t, true, output["success"])
+	result := output["result"].(map[string]interface{})
+	assert.Equal(t, "v20230101_create_users_00001.sql", result["plan"].([]interface{})[0].(map[string]interface{})["filename"])
+	assert.Equal(t, "v20230101_create_users_00001.sql", result["applied"].([]interface{})[0].(map[string]interface{})["filename"])
+
+	// A failed statement is located in the error
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230102_add_email_00002.sql"), []byte("ALTER TABLE users ADD COLUMN email TEXT;\nALTER TABLE missing ADD COLUMN email TEXT;"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	output, err = run("migrate")
+	assert.Error(t, err)
+	failure := output["error"].(map[string]interface{})
+	assert.Equal(t, "migration_failed", failure["code"])
+	assert.Equal(t, "v20230102_add_email_00002.sql", failure["file"])
+	assert.Equal(t, float64(2), failure["statement"])
+	assert.Empty(t, output["result"].(map[string]interface{})["applied"])
+
+	output, err = run("unknown")
+	assert.Error(t, err)
+	assert.Equal(t, "unknown_command", output["error"].(map[string]interface{})["code"])
+	assert.Nil(t, jsonOutput)
+}
+
+func TestParseVerbosity(t *testing.T) {
+	level, args := parseVerbosity([]string{"-v", "migrate", "--auto-approve"})
+	assert.Equal(t, gosmm.LogDebug, level)
+	assert.Equal(t, []string{"migrate", "--auto-approve"}, args)
+
+	level, args = parseVerbosity([]string{"migrate", "-vv"})
+	assert.Equal(t, gosmm.LogTrace, level)
+	assert.Equal(t, []string{"migrate"}, args)
+
+	level, _ = parseVerbosity([]string{"--quiet", "status"})
+	assert.Equal(t, gosmm.LogError, level)
+
+	level, _ = parseVerbosity([]string{"status"})
+	assert.Equal(t, gosmm.LogLevel(""), level)
+}
+
+func TestExecuteQuietCommand(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	os.Setenv("GOSMM_MIGRATIONS_DIR", dir)
+	defer os.Unsetenv("GOSMM_MIGRATIONS_DIR")
+	logLevel = gosmm.LogError
+	defer func() { logLevel = "" }()
+
+	db, teardown := setupTestDB(t)
+	defer teardown()
+	old := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+	err := executeCommand(db, "migrate", nil, "sqlite3")
+	w.Close()
+	os.Stdout = old
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	buf.ReadFrom(r)
+	assert.Empty(t, buf.String())
+}
+
+func TestSignMigrations(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "v20230101_create_users_00001.sql")
+	if err := ioutil.WriteFile(path, []byte("CREATE TABLE users (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	assert.NoError(t, err)
+	keyPath := filepath.Join(t.TempDir(), "release.pem")
+	if err := ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatalf("Failed to create key file: %v", err)
+	}
+	config := gosmm.MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}
+
+	assert.EqualError(t, signMigrations(config, nil), "usage: gosmm sign --key <private key file>")
+	assert.NoError(t, signMigrations(config, []string{"--key", keyPath}))
+
+	db, teardown := setupTestDB(t)
+	defer teardown()
+	config.Signatures = &gosmm.Signatures{PublicKeys: []ed25519.PublicKey{publicKey}}
+	assert.NoError(t, gosmm.MigrateWithConfig(db, config))
+}
+
+func TestBundleMigrations(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	config := gosmm.MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}
+	output := filepath.Join(t.TempDir(), "migrations.tar.gz")
+
+	assert.EqualError(t, bundleMigrations(config, nil), "usage: gosmm bundle --output <archive>")
+	assert.NoError(t, bundleMigrations(config, []string{"--output", output}))
+	assert.FileExists(t, output)
+	assert.Error(t, bundleMigrations(config, []string{"--output", filepath.Join(t.TempDir(), "migrations.tar")}))
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/k1e1n04/gosmm/v2/pkg/gosmm"
+)
+
+const (
+	// outputText is the default output of the commands, meant to be read by humans
+	outputText = "text"
+	// outputJSON writes a single commandOutput document to stdout, the text output going to stderr
+	outputJSON = "json"
+)
+
+// commandOutput is the document written to stdout by a command run with --output json
+type commandOutput struct {
+	Command    string `json:"command"`
+	Success    bool   `json:"success"`
+	DurationMs int64  `json:"duration_ms"`
+	// Result is the result of the command, e.g. the applied migrations of migrate or the report of status
+	Result interface{}   `json:"result,omitempty"`
+	Error  *commandError `json:"error,omitempty"`
+}
+
+// commandError is the error of a command in JSON output
+type commandError struct {
+	// Code identifies the error, see errorCode
+	Code     string `json:"code"`
+	Message  string `json:"message"`
+	ExitCode int    `json:"exit_code"`
+	// File and Statement locate the failed statement of a migration_failed error
+	File      string `json:"file,omitempty"`
+	Statement int    `json:"statement,omitempty"`
+}
+
+// migrateResult is the result of migrate in JSON output
+type migrateResult struct {
+	// Plan holds the migrations that were pending when the run started, omitted for the tenant schemas
+	Plan    []gosmm.PlannedMigration `json:"plan,omitempty"`
+	Applied []appliedMigration       `json:"applied"`
+}
+
+// appliedMigration is a migration applied by migrate
+type appliedMigration struct {
+	Filename   string `json:"filename"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// jsonOutput collects the result of the running command with --output json, it is nil with the text output
+var jsonOutput *commandOutput
+
+// setResult records the result of the running command with --output json
+func setResult(result interface{}) {
+	if jsonOutput != nil {
+		jsonOutput.Result = result
+	}
+}
+
+// recordApplied makes config record the migrations it applies in the result of the running command with
+// --output json, which it returns, or returns nil with the text output
+func recordApplied(config *gosmm.MigrationConfig) *migrateResult {
+	if jsonOutput == nil {
+		return nil
+	}
+	result := &migrateResult{Applied: []appliedMigration{}}
+	setResult(result)
+	progress := config.Progress
+	config.Progress = func(event gosmm.Event) {
+		if event.Kind == gosmm.EventMigrationFinished {
+			result.Applied = append(result.Applied, appliedMigration{Filename: event.Migration.Filename, DurationMs: event.Duration.Milliseconds()})
+		}
+		if progress != nil {
+			progress(event)
+		}
+	}
+	return result
+}
+
+// parseOutput removes the global --output flag from args, which may be given before or after the command,
+// and returns its value, outputText when it is not given
+func parseOutput(args []string) (string, []string, error) {
+	output := outputText
+	rest := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || name != "output" {
+			rest = append(rest, arg)
+			continue
+		}
+		if !hasValue {
+			if i+1 == len(args) {
+				return "", nil, fmt.Errorf("flag needs an argument: --output")
+			}
+			i++
+			value = args[i]
+		}
+		if value != outputText && value != outputJSON {
+			return "", nil, fmt.Errorf("unsupported output: %s", value)
+		}
+		output = value
+	}
+	return output, rest, nil
+}
+
+// logLevel is the log level given by the -q and -v flags, or else by the configuration, "" for the default
+var logLevel gosmm.LogLevel
+
+// parseVerbosity removes the global -q, -v and -vv flags from args, and returns the log level they set:
+// LogError for -q, LogDebug for -v and LogTrace for -vv. It returns "" when none is given.
+func parseVerbosity(args []string) (gosmm.LogLevel, []string) {
+	var level gosmm.LogLevel
+	rest := make([]string, 0, len(args))
+	for _, arg := range args {
+		switch arg {
+		case "-q", "--quiet":
+			level = gosmm.LogError
+		case "-v", "--verbose":
+			level = gosmm.LogDebug
+		case "-vv":
+			level = gosmm.LogTrace
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	return level, rest
+}
+
+// infof prints a message of the CLI, e.g. that a command completed, unless the log level is below LogInfo
+func infof(format string, args ...interface{}) {
+	if logLevel.Enabled(gosmm.LogInfo) {
+		fmt.Printf(format, args...)
+	}
+}
+
+// executeJSON runs the command with run, writing its text output to stderr and a commandOutput to w.
+// It returns the error of the command, so that the exit code is the same as with the text output.
+func executeJSON(w io.Writer, run func() error, command string) error {
+	output := &commandOutput{Command: command}
+	stdout := os.Stdout
+	os.Stdout = os.Stderr
+	jsonOutput = output
+	start := time.Now()
+	err := run()
+	output.DurationMs = time.Since(start).Milliseconds()
+	jsonOutput = nil
+	os.Stdout = stdout
+
+	if err := writeOutput(w, output, err); err != nil {
+		return err
+	}
+	return err
+}
+
+// writeOutput completes output with the error of the command and writes it to w
+func writeOutput(w io.Writer, output *commandOutput, err error) error {
+	output.Success = err == nil
+	if err != nil {
+		output.Error = newCommandError(err)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(output)
+}
+
+// newCommandError describes err with its code and the exit code of gosmm
+func newCommandError(err error) *commandError {
+	result := &commandError{Code: errorCode(err), Message: err.Error(), ExitCode: 1}
+	var exit *exitError
+	if errors.As(err, &exit) {
+		result.ExitCode = exit.code
+	}
+	var failed *gosmm.ErrMigrationFailed
+	if errors.As(err, &failed) {
+		result.File = failed.File
+		result.Statement = failed.StatementIndex
+	}
+	return result
+}
+
+// errUnknownCommand is returned for an unknown command with --output json
+var errUnknownCommand = errors.New("unknown command")
+
+// errorCodes are the codes of the errors of the gosmm package, checked in order
+var errorCodes = []struct {
+	err  error
+	code string
+}{
+	{gosmm.ErrDirtyState, "dirty_state"},
+	{gosmm.ErrChecksumMismatch, "checksum_mismatch"},
+	{gosmm.ErrMissingFile, "missing_file"},
+	{gosmm.ErrPendingMigrations, "pending_migrations"},
+	{gosmm.ErrLockTimeout, "lock_timeout"},
+	{gosmm.ErrUnsafeMigration, "unsafe_migration"},
+	{gosmm.ErrCleanNotAllowed, "clean_not_allowed"},
+	{gosmm.ErrReadOnlyDatabase, "read_only_database"},
+	{gosmm.ErrHistoryTableTooNew, "history_table_too_new"},
+	{gosmm.ErrUnsignedMigration, "unsigned_migration"},
+	{gosmm.ErrNotApproved, "not_approved"},
+}
+
+// errorCode returns the code identifying err in JSON output: migration_failed for a failed statement,
+// validation_failed, one of errorCodes, unknown_command, or "error" for the other errors
+func errorCode(err error) string {
+	var failed *gosmm.ErrMigrationFailed
+	if errors.As(err, &failed) {
+		return "migration_failed"
+	}
+	var validation *gosmm.ValidationError
+	if errors.As(err, &validation) {
+		return "validation_failed"
+	}
+	for _, known := range errorCodes {
+		if errors.Is(err, known.err) {
+			return known.code
+		}
+	}
+	if errors.Is(err, errUnknownCommand) {
+		return "unknown_command"
+	}
+	return "error"
+}
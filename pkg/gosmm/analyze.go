@@ -0,0 +1,69 @@
+package gosmm
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// analyzeTables returns the tables whose statistics are refreshed once the migration file is applied: those
+// listed by its header, or those its statements and load directives write rows to, see MigrationConfig.Analyze
+func analyzeTables(config MigrationConfig, metadata MigrationMetadata, statements []string) []string {
+	if metadata.SkipAnalyze || !config.Analyze && !metadata.Analyze {
+		return nil
+	}
+	if len(metadata.AnalyzeTables) > 0 {
+		return metadata.AnalyzeTables
+	}
+	var tables []string
+	seen := make(map[string]bool)
+	for _, statement := range statements {
+		var table string
+		if load, ok := parseLoadDirective(statement); ok {
+			table = load.table
+		} else if operation, written := statementTable(lintCode(statement)); operation == "INSERT" || operation == "UPDATE" || operation == "DELETE" {
+			table = written
+		}
+		if table != "" && !seen[strings.ToLower(table)] {
+			seen[strings.ToLower(table)] = true
+			tables = append(tables, table)
+		}
+	}
+	return tables
+}
+
+// analyzeStatement returns the statement refreshing the statistics of a table for the driver, empty when the
+// driver has none
+func analyzeStatement(driver string, table string) string {
+	switch driver {
+	case "postgres", "sqlite3":
+		return "ANALYZE " + table
+	case "mysql":
+		return "ANALYZE TABLE " + table
+	case "sqlserver":
+		return "UPDATE STATISTICS " + table
+	default:
+		return ""
+	}
+}
+
+// analyzeMigration refreshes the statistics of the tables after the migration was applied. The migration is
+// applied and recorded by then, so a failure is logged as a warning instead of failing the run.
+func analyzeMigration(ctx context.Context, db *sql.DB, config MigrationConfig, filename string, tables []string) {
+	for _, table := range tables {
+		if config.Schema != "" && !strings.Contains(table, ".") {
+			table = quoteIdentifier(config.Driver, config.Schema) + "." + table
+		}
+		statement := analyzeStatement(config.Driver, table)
+		if statement == "" {
+			return
+		}
+		start := time.Now()
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			config.LogLevel.printf(LogWarn, "WARNING: failed to analyze %s after %s: %v\n", table, filename, err)
+			continue
+		}
+		config.LogLevel.printf(LogDebug, "ANALYZE %s after %s (%d ms)\n", table, filename, time.Since(start).Milliseconds())
+	}
+}
@@ -0,0 +1,55 @@
+package gosmm
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnalyzeTables(t *testing.T) {
+	statements := []string{
+		"CREATE TABLE users (id INTEGER, email TEXT)",
+		"INSERT INTO users (id) VALUES (1)",
+		"UPDATE users SET email = 'a@example.com'",
+		"DELETE FROM sessions WHERE expired",
+		"-- gosmm:load countries countries.csv",
+		"ALTER TABLE orders ADD COLUMN total INTEGER",
+	}
+	assert.Nil(t, analyzeTables(MigrationConfig{}, MigrationMetadata{}, statements))
+	assert.Equal(t, []string{"users", "sessions", "countries"}, analyzeTables(MigrationConfig{Analyze: true}, MigrationMetadata{}, statements))
+	assert.Equal(t, []string{"users", "sessions", "countries"}, analyzeTables(MigrationConfig{}, MigrationMetadata{Analyze: true}, statements))
+	assert.Equal(t, []string{"orders"}, analyzeTables(MigrationConfig{}, MigrationMetadata{Analyze: true, AnalyzeTables: []string{"orders"}}, statements))
+	assert.Nil(t, analyzeTables(MigrationConfig{Analyze: true}, MigrationMetadata{SkipAnalyze: true}, statements))
+
+	assert.Equal(t, "ANALYZE TABLE users", analyzeStatement("mysql", "users"))
+	assert.Equal(t, "UPDATE STATISTICS users", analyzeStatement("sqlserver", "users"))
+	assert.Equal(t, "", analyzeStatement("snowflake", "users"))
+}
+
+func TestMigrateWithAnalyze(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER, email TEXT);\nCREATE INDEX users_email ON users (email);\nCREATE TABLE orders (id INTEGER);\nCREATE INDEX orders_id ON orders (id);"), 0644); err != nil {
+		t.Fatalf("Failed to create migration file: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230102_backfill_users_00002.sql"), []byte("-- gosmm:analyze\nINSERT INTO users (id, email) VALUES (1, 'a@example.com'), (2, 'b@example.com');\nINSERT INTO orders (id) VALUES (1);"), 0644); err != nil {
+		t.Fatalf("Failed to create migration file: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230103_backfill_orders_00003.sql"), []byte("INSERT INTO orders (id) VALUES (2);"), 0644); err != nil {
+		t.Fatalf("Failed to create migration file: %v", err)
+	}
+	assert.NoError(t, MigrateWithConfig(db, MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}))
+
+	// the statistics of the tables written by the annotated migration were refreshed, orders with one row
+	var stat string
+	err := db.QueryRow("SELECT stat FROM sqlite_stat1 WHERE idx = 'users_email'").Scan(&stat)
+	assert.NoError(t, err)
+	assert.Equal(t, "2 1", stat)
+	err = db.QueryRow("SELECT stat FROM sqlite_stat1 WHERE idx = 'orders_id'").Scan(&stat)
+	assert.NoError(t, err)
+	assert.Equal(t, "1 1", stat)
+}
@@ -0,0 +1,59 @@
+package gosmm
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Apply applies the pending migration named filename alone, e.g. a hotfix which must ship ahead of the queue.
+// filename may omit the .sql extension or be the path of the migration file. Unless ahead is set, it must be
+// the next pending migration. A migration applied ahead is recorded as such in the history, so that the following
+// runs apply the pending migrations sorting before it without AllowOutOfOrder.
+func Apply(db *sql.DB, config MigrationConfig, filename string, ahead bool) error {
+	return ApplyWithContext(context.Background(), db, config, filename, ahead)
+}
+
+// ApplyWithContext is Apply, aborted by the cancellation of ctx like MigrateWithContext
+func ApplyWithContext(ctx context.Context, db *sql.DB, config MigrationConfig, filename string, ahead bool) error {
+	return migrate(ctx, db, config, &cherryPick{filename: filepath.Base(filename), ahead: ahead})
+}
+
+// cherryPick is the migration applied alone by Apply
+type cherryPick struct {
+	filename string
+	// ahead allows pending migrations to sort before it
+	ahead bool
+}
+
+// matches reports whether filename is the picked migration
+func (p *cherryPick) matches(filename string) bool {
+	return filename == p.filename || strings.TrimSuffix(filename, sqlFileExtension) == p.filename
+}
+
+// check fails unless the picked migration is among the pending migrations filenames, and the next one
+// unless it is applied ahead. It reports whether pending migrations sort before it.
+func (p *cherryPick) check(filenames []string, executed map[string]bool, config MigrationConfig) (bool, error) {
+	var before []string
+	for _, filename := range filenames {
+		if !p.matches(filename) {
+			if !executed[filename] && !config.skips(filename) {
+				before = append(before, filename)
+			}
+			continue
+		}
+		if executed[filename] {
+			return false, fmt.Errorf("migration %s is already applied", filename)
+		}
+		if config.skips(filename) {
+			return false, fmt.Errorf("migration %s is skipped", filename)
+		}
+		if len(before) > 0 && !p.ahead {
+			return false, fmt.Errorf("migration %s is not the next pending migration: %s must be applied first, or apply it ahead of them", filename, strings.Join(before, ", "))
+		}
+		return len(before) > 0, nil
+	}
+	return false, fmt.Errorf("migration %s not found", p.filename)
+}
@@ -0,0 +1,60 @@
+package gosmm
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApply(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"v20230101_create_users_00001.sql":  "CREATE TABLE users (id INTEGER, email TEXT);",
+		"v20230102_add_name_00002.sql":      "ALTER TABLE users ADD COLUMN name TEXT;",
+		"v20230103_index_email_00003.sql":   "CREATE INDEX idx_users_email ON users (email);",
+		"v20230104_hotfix_orders_00004.sql": "CREATE TABLE orders (id INTEGER);",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create migration file: %v", err)
+		}
+	}
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}
+
+	// The next pending migration is applied alone, named without its extension
+	assert.NoError(t, Apply(db, config, "v20230101_create_users_00001", false))
+	history, err := GetHistory(db, config)
+	assert.NoError(t, err)
+	assert.Len(t, history, 1)
+
+	// A migration sorting after pending ones is only applied ahead of them explicitly
+	err = Apply(db, config, "v20230104_hotfix_orders_00004.sql", false)
+	assert.ErrorContains(t, err, "v20230102_add_name_00002.sql, v20230103_index_email_00003.sql must be applied first")
+	assert.NoError(t, Apply(db, config, filepath.Join(dir, "v20230104_hotfix_orders_00004.sql"), true))
+	_, err = db.Exec("SELECT id FROM orders")
+	assert.NoError(t, err)
+	_, err = db.Exec("SELECT name FROM users")
+	assert.Error(t, err)
+
+	assert.Error(t, Apply(db, config, "v20230104_hotfix_orders_00004.sql", true))
+	assert.ErrorContains(t, Apply(db, config, "v20230105_missing_00005.sql", true), "not found")
+
+	// The migrations skipped by the hotfix are applied by the following runs, which keep the strict ordering
+	assert.NoError(t, MigrateWithConfig(db, config))
+	assert.NoError(t, Validate(db, config))
+	history, err = GetHistory(db, config)
+	assert.NoError(t, err)
+	assert.Len(t, history, 4)
+	for _, entry := range history {
+		assert.Equal(t, entry.Filename == "v20230104_hotfix_orders_00004.sql", entry.Ahead, entry.Filename)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230102_add_phone_00005.sql"), []byte("ALTER TABLE users ADD COLUMN phone TEXT;"), 0644); err != nil {
+		t.Fatalf("Failed to create migration file: %v", err)
+	}
+	assert.ErrorContains(t, MigrateWithConfig(db, config), "sorts before the latest applied migration v20230103_index_email_00003.sql")
+}
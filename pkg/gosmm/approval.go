@@ -0,0 +1,372 @@
+package gosmm
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultApprovalPollInterval is the default of Approval.PollInterval
+	defaultApprovalPollInterval = 30 * time.Second
+	// defaultApprovalTimeout bounds each request to Approval.URL when Approval.Client is nil
+	defaultApprovalTimeout = 10 * time.Second
+	// maxApprovalLength is the size of the approval column of the history table
+	maxApprovalLength = 255
+)
+
+// ErrApprovalPending is returned by Approval.Func while the approval is pending, so that it is checked again
+var ErrApprovalPending = errors.New("approval pending")
+
+// ApprovalStatus is the state of an approval returned by Approval.URL
+type ApprovalStatus string
+
+const (
+	// ApprovalApproved lets the run apply its migrations
+	ApprovalApproved ApprovalStatus = "approved"
+	// ApprovalPending makes the run check the approval again after Approval.PollInterval
+	ApprovalPending ApprovalStatus = "pending"
+	// ApprovalRejected fails the run with ErrNotApproved
+	ApprovalRejected ApprovalStatus = "rejected"
+)
+
+// Approval blocks the runs applying migrations until an external change approval passes, e.g. in the
+// change-management API. It is awaited before the migration lock is taken, so that a pending approval does not
+// block the other runs, and the plan is checked again once locked, so that only the approved plan is applied.
+// The id of the approval is recorded with each applied migration.
+type Approval struct {
+	// URL is posted the ApprovalRequest as JSON, and responds with an ApprovalResponse
+	URL string
+	// Headers are added to the requests to URL, e.g. an Authorization header
+	Headers map[string]string
+	// Func checks the approval instead of URL, returning its id, or an error wrapping ErrApprovalPending while
+	// it is pending
+	Func func(ctx context.Context, request ApprovalRequest) (string, error)
+	// Environments restricts the approval to the runs of these environments, see MigrationConfig.Environment,
+	// e.g. production. All runs need an approval when empty.
+	Environments []string
+	// PollInterval is the delay between two checks of a pending approval, 30s when zero
+	PollInterval time.Duration
+	// Timeout bounds the wait for a pending approval, after which the run fails with ErrNotApproved. The wait is
+	// unbounded when zero.
+	Timeout time.Duration
+	// Client sends the requests to URL, an http.Client with a 10s timeout when nil
+	Client *http.Client
+}
+
+// ApprovalRequest describes the run an approval is checked for
+type ApprovalRequest struct {
+	// PlanHash identifies the pending migrations and their content, see PlanHash
+	PlanHash    string   `json:"plan_hash"`
+	Migrations  []string `json:"migrations"`
+	Driver      string   `json:"driver"`
+	Schema      string   `json:"schema,omitempty"`
+	Environment string   `json:"environment,omitempty"`
+	// AppliedBy and Context are the audit columns recorded with the applied migrations
+	AppliedBy string `json:"applied_by,omitempty"`
+	Context   string `json:"context,omitempty"`
+}
+
+// ApprovalResponse is the response of Approval.URL
+type ApprovalResponse struct {
+	Status ApprovalStatus `json:"status"`
+	// ID identifies the approval, e.g. the change request, and is recorded in the history table
+	ID string `json:"approval_id"`
+	// Reason explains a rejection
+	Reason string `json:"reason,omitempty"`
+}
+
+// gates reports whether the runs of the environment need an approval
+func (a Approval) gates(environment string) bool {
+	if len(a.Environments) == 0 {
+		return true
+	}
+	for _, e := range a.Environments {
+		if e == environment {
+			return true
+		}
+	}
+	return false
+}
+
+// await checks the approval of request until it is approved, returning its id, rejected or timed out
+func (a Approval) await(ctx context.Context, config MigrationConfig, request ApprovalRequest) (string, error) {
+	interval := a.PollInterval
+	if interval <= 0 {
+		interval = defaultApprovalPollInterval
+	}
+	var deadline time.Time
+	if a.Timeout > 0 {
+		deadline = time.Now().Add(a.Timeout)
+	}
+	waiting := false
+	for {
+		id, err := a.check(ctx, request)
+		if err == nil {
+			config.LogLevel.printf(LogInfo, "Run approved by %s\n", id)
+			return id, nil
+		}
+		if !errors.Is(err, ErrApprovalPending) {
+			return "", err
+		}
+		if !waiting {
+			config.LogLevel.printf(LogInfo, "Waiting for the approval of plan %s\n", request.PlanHash)
+			waiting = true
+		}
+		delay := interval
+		if !deadline.IsZero() {
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				return "", fmt.Errorf("%w: still pending after %s", ErrNotApproved, a.Timeout)
+			}
+			if remaining < delay {
+				delay = remaining
+			}
+		}
+		if err := sleep(ctx, delay); err != nil {
+			return "", err
+		}
+	}
+}
+
+// check checks the approval of request once
+func (a Approval) check(ctx context.Context, request ApprovalRequest) (string, error) {
+	id, err := a.query(ctx, request)
+	if err != nil {
+		return "", err
+	}
+	if len(id) > maxApprovalLength {
+		return "", fmt.Errorf("approval id longer than %d characters", maxApprovalLength)
+	}
+	return id, nil
+}
+
+// query asks Func or URL for the approval of request
+func (a Approval) query(ctx context.Context, request ApprovalRequest) (string, error) {
+	if a.Func != nil {
+		id, err := a.Func(ctx, request)
+		if err != nil {
+			return "", err
+		}
+		if id == "" {
+			return "", fmt.Errorf("%w: no approval id", ErrNotApproved)
+		}
+		return id, nil
+	}
+	if a.URL == "" {
+		return "", fmt.Errorf("neither URL nor Func is set")
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.URL, bytes.NewReader(body))
+	if err != nil {
+		return "", withoutURL(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range a.Headers {
+		req.Header.Set(name, value)
+	}
+	client := a.Client
+	if client == nil {
+		client = &http.Client{Timeout: defaultApprovalTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to check approval: %w", withoutURL(err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("approval check responded with %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	var response ApprovalResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return "", fmt.Errorf("invalid approval response: %w", err)
+	}
+	switch response.Status {
+	case ApprovalApproved:
+		if response.ID == "" {
+			return "", fmt.Errorf("%w: no approval id", ErrNotApproved)
+		}
+		return response.ID, nil
+	case ApprovalPending:
+		return "", ErrApprovalPending
+	case ApprovalRejected:
+		if response.Reason != "" {
+			return "", fmt.Errorf("%w: rejected: %s", ErrNotApproved, response.Reason)
+		}
+		return "", fmt.Errorf("%w: rejected", ErrNotApproved)
+	default:
+		return "", fmt.Errorf("invalid approval status %q", response.Status)
+	}
+}
+
+// approvedPlan is the plan approved before the migration lock is taken, see awaitApproval
+type approvedPlan struct {
+	hash string
+	id   string
+}
+
+// awaitApproval waits for the approval of the migrations the run is about to apply before it takes the migration
+// lock, so that a pending approval does not block the other runs of the database. It returns nil when the run
+// needs no approval, or when its plan cannot be read yet, e.g. before the history store is initialized.
+func awaitApproval(ctx context.Context, db *sql.DB, config MigrationConfig, pick *cherryPick) (*approvedPlan, error) {
+	if config.Approval == nil || !config.Approval.gates(config.Environment) {
+		return nil, nil
+	}
+	// a migration applied alone is picked whatever its labels
+	if pick != nil {
+		config.Labels = nil
+	}
+	report, err := Status(db, config)
+	if err != nil {
+		config.LogLevel.printf(LogWarn, "Failed to read the plan to approve, checking its approval once locked: %v\n", err)
+		return nil, nil
+	}
+	files, err := config.migrationFiles()
+	if err != nil {
+		return nil, err
+	}
+	paths := make(map[string]string, len(files))
+	for _, file := range files {
+		if file.inEnvironment(config.Environment) {
+			paths[file.name] = file.path
+		}
+	}
+	var filenames []string
+	for _, migration := range report.Migrations {
+		resumed := migration.State == MigrationFailed && config.ResumeMode
+		if migration.State != MigrationPending && !resumed || !config.selects(migration.Labels) {
+			continue
+		}
+		if pick == nil || pick.matches(migration.Filename) {
+			filenames = append(filenames, migration.Filename)
+		}
+	}
+	if len(filenames) == 0 {
+		return nil, nil
+	}
+	hash, err := planHash(paths, filenames)
+	if err != nil {
+		return nil, err
+	}
+	appliedBy, auditContext, err := auditMigrations(db, config)
+	if err != nil {
+		return nil, err
+	}
+	id, err := config.Approval.await(ctx, config, ApprovalRequest{
+		PlanHash:    hash,
+		Migrations:  filenames,
+		Driver:      config.Driver,
+		Schema:      config.Schema,
+		Environment: config.Environment,
+		AppliedBy:   appliedBy,
+		Context:     auditContext,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &approvedPlan{hash: hash, id: id}, nil
+}
+
+// approveRun sets the id of the approval on the pending migrations of a run once the migration lock is held.
+// The approval awaited before locking holds when the plan is unchanged; a plan changed since, e.g. by another
+// run applying a part of it, is checked once, as waiting for it would block the other runs.
+func approveRun(ctx context.Context, config MigrationConfig, paths map[string]string, pending []MigrationInfo, approved *approvedPlan) error {
+	if config.Approval == nil || len(pending) == 0 || !config.Approval.gates(config.Environment) {
+		return nil
+	}
+	filenames := migrationFilenames(pending)
+	hash, err := planHash(paths, filenames)
+	if err != nil {
+		return err
+	}
+	var id string
+	if approved != nil && approved.hash == hash {
+		id = approved.id
+	} else {
+		id, err = config.Approval.check(ctx, ApprovalRequest{
+			PlanHash:    hash,
+			Migrations:  filenames,
+			Driver:      config.Driver,
+			Schema:      config.Schema,
+			Environment: config.Environment,
+			AppliedBy:   pending[0].AppliedBy,
+			Context:     pending[0].Context,
+		})
+		if errors.Is(err, ErrApprovalPending) {
+			return fmt.Errorf("%w: plan %s changed while its approval was awaited, and is pending", ErrNotApproved, hash)
+		}
+		if err != nil {
+			return err
+		}
+		config.LogLevel.printf(LogInfo, "Run approved by %s\n", id)
+	}
+	for i := range pending {
+		pending[i].Approval = id
+	}
+	return nil
+}
+
+// PlanHash returns the hash identifying the pending migrations and the content of their files, which is sent to
+// Approval, so that a change request can be approved for the exact plan later applied. It never modifies the
+// database.
+func PlanHash(db *sql.DB, config MigrationConfig) (string, error) {
+	plan, err := Plan(db, config)
+	if err != nil {
+		return "", err
+	}
+	files, err := config.migrationFiles()
+	if err != nil {
+		return "", err
+	}
+	paths := make(map[string]string, len(files))
+	for _, file := range files {
+		if file.inEnvironment(config.Environment) {
+			paths[file.name] = file.path
+		}
+	}
+	filenames := make([]string, len(plan))
+	for i, migration := range plan {
+		filenames[i] = migration.Filename
+	}
+	return planHash(paths, filenames)
+}
+
+// planHash hashes the names of the migrations in order with the SHA-256 of their file, Go migrations having
+// none
+func planHash(paths map[string]string, filenames []string) (string, error) {
+	hash := sha256.New()
+	for _, filename := range filenames {
+		fmt.Fprintf(hash, "%s\x00", filename)
+		if path, ok := paths[filename]; ok {
+			file, err := os.Open(path)
+			if err != nil {
+				return "", fmt.Errorf("failed to read file: %w", err)
+			}
+			content := sha256.New()
+			_, err = io.Copy(content, file)
+			file.Close()
+			if err != nil {
+				return "", fmt.Errorf("failed to read file: %w", err)
+			}
+			hash.Write([]byte(hex.EncodeToString(content.Sum(nil))))
+		}
+		hash.Write([]byte("\n"))
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
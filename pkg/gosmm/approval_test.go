@@ -0,0 +1,148 @@
+package gosmm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrateWithApproval(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create migration file: %v", err)
+	}
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3", Environment: "production", Context: "CHG-1234"}
+	hash, err := PlanHash(db, config)
+	assert.NoError(t, err)
+
+	var requests []ApprovalRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		var request ApprovalRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		requests = append(requests, request)
+		if len(requests) == 1 {
+			json.NewEncoder(w).Encode(ApprovalResponse{Status: ApprovalPending})
+			return
+		}
+		json.NewEncoder(w).Encode(ApprovalResponse{Status: ApprovalApproved, ID: "CAB-42"})
+	}))
+	defer server.Close()
+	config.Approval = &Approval{URL: server.URL, Headers: map[string]string{"Authorization": "Bearer secret"}, PollInterval: time.Millisecond}
+	assert.NoError(t, MigrateWithConfig(db, config))
+
+	if assert.Len(t, requests, 2) {
+		assert.Equal(t, ApprovalRequest{PlanHash: hash, Migrations: []string{"v20230101_create_users_00001.sql"}, Driver: "sqlite3",
+			Environment: "production", Context: "CHG-1234"}, requests[0])
+	}
+	history, err := GetHistory(db, config)
+	assert.NoError(t, err)
+	if assert.Len(t, history, 1) {
+		assert.Equal(t, "CAB-42", history[0].Approval)
+	}
+
+	// A run without pending migrations needs no approval
+	assert.NoError(t, MigrateWithConfig(db, config))
+	assert.Len(t, requests, 2)
+}
+
+func TestMigrateWithApprovalNotApproved(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create migration file: %v", err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(ApprovalResponse{Status: ApprovalRejected, Reason: "change freeze"})
+	}))
+	defer server.Close()
+
+	// A rejected run applies nothing
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3", Approval: &Approval{URL: server.URL}}
+	err := MigrateWithConfig(db, config)
+	assert.True(t, errors.Is(err, ErrNotApproved))
+	assert.Contains(t, err.Error(), "rejected: change freeze")
+	plan, err := Plan(db, config)
+	assert.NoError(t, err)
+	assert.Len(t, plan, 1)
+
+	// A pending approval times out
+	config.Approval = &Approval{
+		Func: func(ctx context.Context, request ApprovalRequest) (string, error) {
+			return "", ErrApprovalPending
+		},
+		PollInterval: time.Millisecond,
+		Timeout:      10 * time.Millisecond,
+	}
+	err = MigrateWithConfig(db, config)
+	assert.True(t, errors.Is(err, ErrNotApproved))
+
+	// The runs of the other environments need no approval
+	config.Approval.Environments = []string{"production"}
+	config.Environment = "staging"
+	assert.NoError(t, MigrateWithConfig(db, config))
+	history, err := GetHistory(db, config)
+	assert.NoError(t, err)
+	if assert.Len(t, history, 1) {
+		assert.Equal(t, "", history[0].Approval)
+	}
+}
+
+func TestMigrateWithApprovalAwaitedWithoutLock(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create migration file: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230102_create_orders_00002.sql"), []byte("CREATE TABLE orders (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create migration file: %v", err)
+	}
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3", Lease: time.Minute, WaitForLock: 50 * time.Millisecond}
+	other := config
+
+	// Another run applies a migration while the approval is awaited, as the lock is not held yet
+	var requests []ApprovalRequest
+	config.Approval = &Approval{Func: func(ctx context.Context, request ApprovalRequest) (string, error) {
+		requests = append(requests, request)
+		if len(requests) == 1 {
+			assert.NoError(t, Apply(db, other, "v20230101_create_users_00001.sql", false))
+			return "CAB-1", nil
+		}
+		return "", ErrApprovalPending
+	}}
+	err := MigrateWithConfig(db, config)
+	assert.True(t, errors.Is(err, ErrNotApproved))
+	assert.Contains(t, err.Error(), "changed while its approval was awaited")
+
+	// The changed plan is checked once locked
+	if assert.Len(t, requests, 2) {
+		assert.Equal(t, []string{"v20230101_create_users_00001.sql", "v20230102_create_orders_00002.sql"}, requests[0].Migrations)
+		assert.Equal(t, []string{"v20230102_create_orders_00002.sql"}, requests[1].Migrations)
+	}
+
+	config.Approval.Func = func(ctx context.Context, request ApprovalRequest) (string, error) {
+		return "CAB-2", nil
+	}
+	assert.NoError(t, MigrateWithConfig(db, config))
+	history, err := GetHistory(db, config)
+	assert.NoError(t, err)
+	if assert.Len(t, history, 2) {
+		assert.Equal(t, "", history[0].Approval)
+		assert.Equal(t, "CAB-2", history[1].Approval)
+	}
+}
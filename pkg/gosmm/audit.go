@@ -0,0 +1,97 @@
+package gosmm
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// maxAppliedByLength is the size of the applied_by column of the history table
+	maxAppliedByLength = 255
+	// maxContextLength is the size of the context column of the history table
+	maxContextLength = 1000
+)
+
+// ciJobVariables are the environment variables holding the id of the running CI job, checked in order
+var ciJobVariables = []string{
+	"GITHUB_RUN_ID",    // GitHub Actions
+	"CI_JOB_ID",        // GitLab CI
+	"BUILDKITE_JOB_ID", // Buildkite
+	"CIRCLE_BUILD_NUM", // CircleCI
+	"BUILD_TAG",        // Jenkins
+}
+
+// auditMigrations returns the applied_by and context values recorded with the migrations applied with config:
+// the database user, followed by the OS user, the hostname and the CI job id with MigrationConfig.AuditHost,
+// e.g. "app (alice@build-01, ci job 4242)", and MigrationConfig.Context
+func auditMigrations(db *sql.DB, config MigrationConfig) (appliedBy string, auditContext string, err error) {
+	if len(config.Context) > maxContextLength {
+		return "", "", fmt.Errorf("context longer than %d characters", maxContextLength)
+	}
+	appliedBy, err = databaseUser(db, config.Driver)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read database user: %w", err)
+	}
+	if config.AuditHost {
+		if host := hostAudit(os.Getenv); appliedBy == "" {
+			appliedBy = host
+		} else {
+			appliedBy += " (" + host + ")"
+		}
+	}
+	return truncate(appliedBy, maxAppliedByLength), config.Context, nil
+}
+
+// databaseUser returns the user of the database session, "" for SQLite which has none
+func databaseUser(db *sql.DB, driver string) (string, error) {
+	var query string
+	switch driver {
+	case "postgres":
+		query = "SELECT current_user"
+	case "mysql":
+		query = "SELECT CURRENT_USER()"
+	case "sqlserver":
+		query = "SELECT SUSER_SNAME()"
+	default:
+		return "", nil
+	}
+	var name sql.NullString
+	if err := db.QueryRow(query).Scan(&name); err != nil {
+		return "", err
+	}
+	return name.String, nil
+}
+
+// hostAudit returns the OS user and the hostname running gosmm, e.g. "alice@build-01", followed by the id of
+// the CI job read with getenv, e.g. "alice@build-01, ci job 4242"
+func hostAudit(getenv func(string) string) string {
+	name := getenv("USER")
+	if current, err := user.Current(); err == nil {
+		name = current.Username
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	parts := []string{name + "@" + hostname}
+	for _, variable := range ciJobVariables {
+		if id := getenv(variable); id != "" {
+			parts = append(parts, "ci job "+id)
+			break
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// truncate returns s cut to at most n bytes, without splitting a character
+func truncate(s string, n int) string {
+	for len(s) > n {
+		_, size := utf8.DecodeLastRuneInString(s)
+		s = s[:len(s)-size]
+	}
+	return s
+}
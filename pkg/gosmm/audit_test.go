@@ -0,0 +1,74 @@
+package gosmm
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrateRecordsAudit(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+	t.Setenv("GITHUB_RUN_ID", "4242")
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER);"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3", AuditHost: true, Context: "CHG-1234"}
+	assert.NoError(t, MigrateWithConfig(db, config))
+
+	history, err := GetHistory(db, config)
+	assert.NoError(t, err)
+	if assert.Len(t, history, 1) {
+		assert.Equal(t, "CHG-1234", history[0].Context)
+		// SQLite has no database user
+		assert.Contains(t, history[0].AppliedBy, "@")
+		assert.True(t, strings.HasSuffix(history[0].AppliedBy, ", ci job 4242"), history[0].AppliedBy)
+	}
+}
+
+func TestMigrateWithoutAudit(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER);"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}
+	assert.NoError(t, MigrateWithConfig(db, config))
+
+	history, err := GetHistory(db, config)
+	assert.NoError(t, err)
+	if assert.Len(t, history, 1) {
+		assert.Empty(t, history[0].AppliedBy)
+		assert.Empty(t, history[0].Context)
+	}
+}
+
+func TestMigrateContextTooLong(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	config := MigrationConfig{MigrationsDir: t.TempDir(), Driver: "sqlite3", Context: strings.Repeat("x", maxContextLength+1)}
+	err := MigrateWithConfig(db, config)
+	assert.EqualError(t, err, "context longer than 1000 characters")
+}
+
+func TestHostAudit(t *testing.T) {
+	env := map[string]string{"USER": "alice", "CI_JOB_ID": "77", "BUILD_TAG": "jenkins-1"}
+	audit := hostAudit(func(name string) string { return env[name] })
+	assert.True(t, strings.HasSuffix(audit, ", ci job 77"), audit)
+	assert.NotContains(t, audit, "jenkins-1")
+}
+
+func TestTruncate(t *testing.T) {
+	assert.Equal(t, "abc", truncate("abc", 3))
+	assert.Equal(t, "ab", truncate("abc", 2))
+	// a character is not split
+	assert.Equal(t, "a", truncate("aé", 2))
+}
@@ -0,0 +1,321 @@
+package gosmm
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// rdsAuthTokenLifetime is the lifetime of the RDS IAM authentication tokens, fixed by RDS
+	rdsAuthTokenLifetime = 15 * time.Minute
+	// defaultSecretRefreshInterval is how long a password fetched from Secrets Manager is used
+	// before it is fetched again, to pick up rotations
+	defaultSecretRefreshInterval = 15 * time.Minute
+	awsTimeFormat                = "20060102T150405Z"
+	awsDateFormat                = "20060102"
+)
+
+// AWSCredentials are the credentials signing the requests to AWS
+type AWSCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// AWSCredentialsFunc returns the credentials signing a request to AWS.
+// It can wrap the credentials provider of the AWS SDK to use instance roles or SSO.
+type AWSCredentialsFunc func(ctx context.Context) (AWSCredentials, error)
+
+// AWSCredentialsFromEnv returns the credentials of the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY
+// and AWS_SESSION_TOKEN environment variables
+func AWSCredentialsFromEnv(context.Context) (AWSCredentials, error) {
+	credentials := AWSCredentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if credentials.AccessKeyID == "" || credentials.SecretAccessKey == "" {
+		return AWSCredentials{}, errors.New("missing AWS_ACCESS_KEY_ID or AWS_SECRET_ACCESS_KEY")
+	}
+	return credentials, nil
+}
+
+// awsRegion returns region, or the region of the AWS_REGION or AWS_DEFAULT_REGION environment variables
+func awsRegion(region string) (string, error) {
+	for _, r := range []string{region, os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION")} {
+		if r != "" {
+			return r, nil
+		}
+	}
+	return "", errors.New("missing AWS region")
+}
+
+// awsCredentials returns the credentials of fn, or of the environment when fn is nil
+func awsCredentials(ctx context.Context, fn AWSCredentialsFunc) (AWSCredentials, error) {
+	if fn == nil {
+		fn = AWSCredentialsFromEnv
+	}
+	credentials, err := fn(ctx)
+	if err != nil {
+		return AWSCredentials{}, fmt.Errorf("failed to get AWS credentials: %w", err)
+	}
+	return credentials, nil
+}
+
+// RDSIAMAuth is a PasswordProvider generating RDS IAM authentication tokens for DBConfig.User,
+// valid for 15 minutes. RDS requires TLS for IAM authentication, and the mysql driver also needs
+// the allowCleartextPasswords parameter.
+type RDSIAMAuth struct {
+	// Region of the database, AWS_REGION or AWS_DEFAULT_REGION when empty
+	Region string
+	// Credentials signing the token, the AWS_* environment variables when nil
+	Credentials AWSCredentialsFunc
+}
+
+// Password implements PasswordProvider
+func (a RDSIAMAuth) Password(ctx context.Context, config DBConfig) (string, time.Time, error) {
+	region, err := awsRegion(a.Region)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	credentials, err := awsCredentials(ctx, a.Credentials)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	now := time.Now().UTC()
+	token := rdsAuthToken(net.JoinHostPort(config.Host, strconv.Itoa(config.Port)), config.User, region, credentials, now)
+	return token, now.Add(rdsAuthTokenLifetime), nil
+}
+
+// rdsAuthToken returns the RDS IAM authentication token of user on endpoint (host:port),
+// a presigned rds-db:connect request without its scheme
+func rdsAuthToken(endpoint string, user string, region string, credentials AWSCredentials, now time.Time) string {
+	signer := awsSigner{credentials: credentials, region: region, service: "rds-db", now: now}
+	query := url.Values{
+		"Action":              {"connect"},
+		"DBUser":              {user},
+		"X-Amz-Algorithm":     {"AWS4-HMAC-SHA256"},
+		"X-Amz-Credential":    {credentials.AccessKeyID + "/" + signer.scope()},
+		"X-Amz-Date":          {now.Format(awsTimeFormat)},
+		"X-Amz-Expires":       {strconv.Itoa(int(rdsAuthTokenLifetime / time.Second))},
+		"X-Amz-SignedHeaders": {"host"},
+	}
+	if credentials.SessionToken != "" {
+		query.Set("X-Amz-Security-Token", credentials.SessionToken)
+	}
+	headers := map[string]string{"host": endpoint}
+	signature := signer.signature(http.MethodGet, "/", query, headers, hashHex(nil))
+	return endpoint + "/?" + awsQuery(query) + "&X-Amz-Signature=" + signature
+}
+
+// SecretsManagerPassword is a PasswordProvider fetching the password from an AWS Secrets Manager secret.
+// A secret holding a JSON object, as created for RDS databases, provides its "password" key;
+// any other secret is the password itself. The password is fetched again every RefreshInterval
+// to pick up rotations.
+type SecretsManagerPassword struct {
+	// SecretID is the name or ARN of the secret
+	SecretID string
+	// Region of the secret, AWS_REGION or AWS_DEFAULT_REGION when empty
+	Region string
+	// Credentials signing the request, the AWS_* environment variables when nil
+	Credentials AWSCredentialsFunc
+	// RefreshInterval is how long the password is used before it is fetched again, 15 minutes when zero
+	RefreshInterval time.Duration
+	// Endpoint overrides the Secrets Manager endpoint, e.g. for a VPC endpoint
+	Endpoint string
+	// Client sends the request, http.DefaultClient when nil
+	Client *http.Client
+}
+
+// Password implements PasswordProvider
+func (s SecretsManagerPassword) Password(ctx context.Context, _ DBConfig) (string, time.Time, error) {
+	if s.SecretID == "" {
+		return "", time.Time{}, errors.New("missing secret id")
+	}
+	region, err := awsRegion(s.Region)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	credentials, err := awsCredentials(ctx, s.Credentials)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = "https://secretsmanager." + region + ".amazonaws.com"
+	}
+	body, err := json.Marshal(map[string]string{"SecretId": s.SecretID})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("invalid secrets manager endpoint: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	now := time.Now().UTC()
+	awsSigner{credentials: credentials, region: region, service: "secretsmanager", now: now}.sign(req, body)
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to get secret %s: %w", s.SecretID, err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to read secret %s: %w", s.SecretID, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", time.Time{}, fmt.Errorf("failed to get secret %s: %s: %s", s.SecretID, resp.Status, strings.TrimSpace(string(data)))
+	}
+
+	var value struct {
+		SecretString string
+	}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to parse secret %s: %w", s.SecretID, err)
+	}
+	password := value.SecretString
+	var fields map[string]interface{}
+	if json.Unmarshal([]byte(password), &fields) == nil {
+		p, ok := fields["password"].(string)
+		if !ok {
+			return "", time.Time{}, fmt.Errorf("secret %s has no password key", s.SecretID)
+		}
+		password = p
+	}
+
+	refreshInterval := s.RefreshInterval
+	if refreshInterval == 0 {
+		refreshInterval = defaultSecretRefreshInterval
+	}
+	return password, now.Add(refreshInterval), nil
+}
+
+// awsSigner signs requests with AWS Signature Version 4
+type awsSigner struct {
+	credentials AWSCredentials
+	region      string
+	service     string
+	now         time.Time
+}
+
+// sign adds the Signature Version 4 authorization headers to req
+func (s awsSigner) sign(req *http.Request, body []byte) {
+	req.Header.Set("X-Amz-Date", s.now.Format(awsTimeFormat))
+	if s.credentials.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.credentials.SessionToken)
+	}
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.Join(values, ",")
+	}
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	signature := s.signature(req.Method, path, req.URL.Query(), headers, hashHex(body))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.credentials.AccessKeyID, s.scope(), strings.Join(sortedKeys(headers), ";"), signature))
+}
+
+// scope returns the credential scope of the signature
+func (s awsSigner) scope() string {
+	return s.now.Format(awsDateFormat) + "/" + s.region + "/" + s.service + "/aws4_request"
+}
+
+// signature returns the signature of the request with the given lower-case headers and payload hash
+func (s awsSigner) signature(method string, path string, query url.Values, headers map[string]string, payloadHash string) string {
+	names := sortedKeys(headers)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	canonicalRequest := strings.Join([]string{
+		method,
+		path,
+		awsQuery(query),
+		canonicalHeaders.String(),
+		strings.Join(names, ";"),
+		payloadHash,
+	}, "\n")
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		s.now.Format(awsTimeFormat),
+		s.scope(),
+		hashHex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := []byte("AWS4" + s.credentials.SecretAccessKey)
+	for _, part := range []string{s.now.Format(awsDateFormat), s.region, s.service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// awsQuery encodes query in key order with the percent-encoding of Signature Version 4
+func awsQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, awsEscape(key)+"="+awsEscape(value))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// awsEscape percent-encodes s, spaces included, as Signature Version 4 requires
+func awsEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// sortedKeys returns the keys of m in order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// hashHex returns the hex-encoded SHA-256 of data
+func hashHex(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data with key
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
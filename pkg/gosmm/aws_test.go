@@ -0,0 +1,118 @@
+package gosmm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testAWSCredentials are the example credentials of the AWS Signature Version 4 test suite
+var testAWSCredentials = AWSCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+
+func staticAWSCredentials(credentials AWSCredentials) AWSCredentialsFunc {
+	return func(context.Context) (AWSCredentials, error) { return credentials, nil }
+}
+
+func TestAWSSigner(t *testing.T) {
+	// The get-vanilla case of the AWS Signature Version 4 test suite
+	req, _ := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	now, _ := time.Parse(awsTimeFormat, "20150830T123600Z")
+	awsSigner{credentials: testAWSCredentials, region: "us-east-1", service: "service", now: now}.sign(req, nil)
+
+	assert.Equal(t, "20150830T123600Z", req.Header.Get("X-Amz-Date"))
+	assert.Equal(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31", req.Header.Get("Authorization"))
+}
+
+func TestRDSAuthToken(t *testing.T) {
+	now, _ := time.Parse(awsTimeFormat, "20230101T000000Z")
+	credentials := testAWSCredentials
+	credentials.SessionToken = "session token"
+
+	token := rdsAuthToken("db.example.com:5432", "app", "eu-west-1", credentials, now)
+	assert.True(t, strings.HasPrefix(token, "db.example.com:5432/?Action=connect&DBUser=app&"), token)
+
+	query, err := url.ParseQuery(strings.SplitN(token, "?", 2)[1])
+	assert.NoError(t, err)
+	assert.Equal(t, "AKIDEXAMPLE/20230101/eu-west-1/rds-db/aws4_request", query.Get("X-Amz-Credential"))
+	assert.Equal(t, "900", query.Get("X-Amz-Expires"))
+	assert.Equal(t, "session token", query.Get("X-Amz-Security-Token"))
+	assert.Len(t, query.Get("X-Amz-Signature"), 64)
+	// The session token is encoded with %20, as the signature requires
+	assert.Contains(t, token, "X-Amz-Security-Token=session%20token")
+
+	// The token is signed for the user and the endpoint
+	assert.NotEqual(t, token, rdsAuthToken("db.example.com:5432", "admin", "eu-west-1", credentials, now))
+}
+
+func TestRDSIAMAuth(t *testing.T) {
+	auth := RDSIAMAuth{Region: "eu-west-1", Credentials: staticAWSCredentials(testAWSCredentials)}
+	token, expiresAt, err := auth.Password(context.Background(), DBConfig{Host: "db.example.com", Port: 3306, User: "app"})
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(token, "db.example.com:3306/?Action=connect&DBUser=app&"), token)
+	assert.WithinDuration(t, time.Now().Add(15*time.Minute), expiresAt, time.Minute)
+}
+
+func TestSecretsManagerPassword(t *testing.T) {
+	secret := `{"username": "app", "password": "s3cret"}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "secretsmanager.GetSecretValue", r.Header.Get("X-Amz-Target"))
+		assert.True(t, strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/"))
+		assert.Contains(t, r.Header.Get("Authorization"), "/eu-west-1/secretsmanager/aws4_request")
+		var body map[string]string
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		if body["SecretId"] != "prod/app/db" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"__type": "ResourceNotFoundException"}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"SecretString": secret})
+	}))
+	defer server.Close()
+
+	provider := SecretsManagerPassword{
+		SecretID:        "prod/app/db",
+		Region:          "eu-west-1",
+		Credentials:     staticAWSCredentials(testAWSCredentials),
+		RefreshInterval: time.Hour,
+		Endpoint:        server.URL,
+	}
+	password, expiresAt, err := provider.Password(context.Background(), DBConfig{})
+	assert.NoError(t, err)
+	assert.Equal(t, "s3cret", password)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), expiresAt, time.Minute)
+
+	// A plain secret is the password itself
+	secret = "plain s3cret"
+	password, _, err = provider.Password(context.Background(), DBConfig{})
+	assert.NoError(t, err)
+	assert.Equal(t, "plain s3cret", password)
+
+	// A JSON secret without a password key
+	secret = `{"username": "app"}`
+	_, _, err = provider.Password(context.Background(), DBConfig{})
+	assert.Error(t, err)
+
+	provider.SecretID = "missing"
+	_, _, err = provider.Password(context.Background(), DBConfig{})
+	assert.ErrorContains(t, err, "ResourceNotFoundException")
+}
+
+func TestAWSCredentialsFromEnv(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+	credentials, err := AWSCredentialsFromEnv(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, AWSCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret"}, credentials)
+
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	_, err = AWSCredentialsFromEnv(context.Background())
+	assert.Error(t, err)
+}
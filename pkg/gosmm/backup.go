@@ -0,0 +1,113 @@
+package gosmm
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// maxBackupLength is the size of the backup column of the history table
+const maxBackupLength = 1000
+
+// destructiveStatementPattern matches the statements dropping or emptying tables, schemas and databases
+var destructiveStatementPattern = regexp.MustCompile(`(?is)^\s*(?:DROP\s+(?:TABLE|SCHEMA|DATABASE)\b|TRUNCATE\b)`)
+
+// Backup takes a backup before each destructive migration: one dropping or truncating a table, dropping a
+// column, schema or database, or annotated with "-- gosmm:destructive true". The reference of the backup
+// artifact it returns, e.g. the path of a dump or the ID of a snapshot, is recorded in the history table.
+// A failed backup fails the migration before any of its statements is executed.
+type Backup struct {
+	// Command is a text/template of a command run with sh -c, whose trimmed output is the reference, e.g.
+	// "pg_dump -Fc -f /backups/{{.Name}}-{{.Timestamp}}.dump app && echo /backups/{{.Name}}-{{.Timestamp}}.dump".
+	// The template is executed with a BackupTarget.
+	Command string
+	// Func takes the backup instead of Command, and returns its reference
+	Func func(ctx context.Context, target BackupTarget) (string, error)
+}
+
+// BackupTarget describes the destructive migration a backup is taken for
+type BackupTarget struct {
+	// Filename is the name of the migration file, and Name the same without its .sql extension
+	Filename string
+	Name     string
+	Driver   string
+	Schema   string
+	// Environment is MigrationConfig.Environment
+	Environment string
+	// Timestamp is the UTC time the backup is taken at, as 20060102150405
+	Timestamp string
+}
+
+// take takes the backup of target and returns its reference
+func (b Backup) take(ctx context.Context, target BackupTarget) (string, error) {
+	var reference string
+	if b.Func != nil {
+		var err error
+		if reference, err = b.Func(ctx, target); err != nil {
+			return "", err
+		}
+	} else {
+		if b.Command == "" {
+			return "", fmt.Errorf("neither Command nor Func is set")
+		}
+		tmpl, err := template.New("backup").Option("missingkey=error").Parse(b.Command)
+		if err != nil {
+			return "", fmt.Errorf("invalid command template: %w", err)
+		}
+		var command bytes.Buffer
+		if err := tmpl.Execute(&command, target); err != nil {
+			return "", fmt.Errorf("invalid command template: %w", err)
+		}
+		cmd := exec.CommandContext(ctx, "sh", "-c", command.String())
+		cmd.Stderr = os.Stderr
+		output, err := cmd.Output()
+		if err != nil {
+			return "", err
+		}
+		reference = string(output)
+	}
+	reference = strings.TrimSpace(reference)
+	if len(reference) > maxBackupLength {
+		return "", fmt.Errorf("backup reference longer than %d characters", maxBackupLength)
+	}
+	return reference, nil
+}
+
+// backupMigration takes the backup of config before the migration, and records its reference in migration
+func backupMigration(ctx context.Context, config MigrationConfig, migration *MigrationInfo) error {
+	target := BackupTarget{
+		Filename:    migration.Filename,
+		Name:        strings.TrimSuffix(migration.Filename, ".sql"),
+		Driver:      config.Driver,
+		Schema:      config.Schema,
+		Environment: config.Environment,
+		Timestamp:   time.Now().UTC().Format("20060102150405"),
+	}
+	config.LogLevel.printf(LogInfo, "Backing up before %s\n", migration.Filename)
+	reference, err := config.Backup.take(ctx, target)
+	if err != nil {
+		return fmt.Errorf("backup before %s failed: %w", migration.Filename, err)
+	}
+	migration.Backup = reference
+	return nil
+}
+
+// isDestructive reports whether the migration is annotated as destructive, or one of its statements drops
+// or truncates a table, drops a column, a schema or a database
+func isDestructive(metadata MigrationMetadata, statements []string) bool {
+	if metadata.Destructive {
+		return true
+	}
+	for _, statement := range statements {
+		if destructiveStatementPattern.MatchString(lintCode(statement)) || lintDropColumn(LintStatement{SQL: statement}) != "" {
+			return true
+		}
+	}
+	return false
+}
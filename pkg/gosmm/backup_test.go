@@ -0,0 +1,118 @@
+package gosmm
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsDestructive(t *testing.T) {
+	for statement, destructive := range map[string]bool{
+		"DROP TABLE users":                              true,
+		"drop schema billing cascade":                   true,
+		"TRUNCATE TABLE sessions":                       true,
+		"-- clean up\nDROP TABLE IF EXISTS users":       true,
+		"ALTER TABLE users DROP COLUMN email":           true,
+		"ALTER TABLE users DROP email":                  true,
+		"ALTER TABLE users DROP CONSTRAINT users_email": false,
+		"DROP INDEX users_email":                        false,
+		"CREATE TABLE users (id INTEGER)":               false,
+		"INSERT INTO logs VALUES ('DROP TABLE users')":  false,
+	} {
+		assert.Equal(t, destructive, isDestructive(MigrationMetadata{}, []string{statement}), statement)
+	}
+	assert.True(t, isDestructive(MigrationMetadata{Destructive: true}, []string{"DELETE FROM sessions"}))
+}
+
+func TestMigrateWithBackup(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"v20230101_create_users_00001.sql":    "CREATE TABLE users (id INTEGER, email TEXT);",
+		"v20230102_drop_email_00002.sql":      "ALTER TABLE users DROP COLUMN email;",
+		"v20230103_delete_users_00003.sql":    "-- gosmm:destructive true\nDELETE FROM users;",
+		"v20230104_create_sessions_00004.sql": "CREATE TABLE sessions (id INTEGER);",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test migration file: %v", err)
+		}
+	}
+
+	var targets []BackupTarget
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3", Backup: &Backup{
+		Func: func(ctx context.Context, target BackupTarget) (string, error) {
+			targets = append(targets, target)
+			return " snapshot-" + target.Name + "\n", nil
+		},
+	}}
+	assert.NoError(t, MigrateWithConfig(db, config))
+
+	// Only the destructive migrations were backed up
+	if assert.Len(t, targets, 2) {
+		assert.Equal(t, "v20230102_drop_email_00002.sql", targets[0].Filename)
+		assert.Equal(t, "sqlite3", targets[0].Driver)
+		assert.Len(t, targets[0].Timestamp, 14)
+		assert.Equal(t, "v20230103_delete_users_00003", targets[1].Name)
+	}
+	history, err := GetHistory(db, config)
+	assert.NoError(t, err)
+	if assert.Len(t, history, 4) {
+		assert.Equal(t, "", history[0].Backup)
+		assert.Equal(t, "snapshot-v20230102_drop_email_00002", history[1].Backup)
+		assert.Equal(t, "snapshot-v20230103_delete_users_00003", history[2].Backup)
+		assert.Equal(t, "", history[3].Backup)
+	}
+}
+
+func TestMigrateWithBackupCommand(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_drop_users_00001.sql"), []byte("DROP TABLE IF EXISTS users;"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3", Backup: &Backup{Command: "echo /backups/{{.Name}}.dump"}}
+	assert.NoError(t, MigrateWithConfig(db, config))
+	history, err := GetHistory(db, config)
+	assert.NoError(t, err)
+	if assert.Len(t, history, 1) {
+		assert.Equal(t, "/backups/v20230101_drop_users_00001.dump", history[0].Backup)
+	}
+}
+
+func TestMigrateWithFailedBackup(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_drop_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER);\nDROP TABLE users;"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+
+	for _, backup := range []*Backup{
+		{Func: func(context.Context, BackupTarget) (string, error) { return "", errors.New("disk full") }},
+		{Command: "exit 1"},
+		{Command: "echo {{.Unknown}}"},
+	} {
+		config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3", Backup: backup}
+		err := MigrateWithConfig(db, config)
+		assert.ErrorContains(t, err, "backup before v20230101_drop_users_00001.sql failed")
+
+		// No statement was executed and nothing was recorded
+		var count int
+		assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'users'").Scan(&count))
+		assert.Equal(t, 0, count)
+		history, err := GetHistory(db, config)
+		assert.NoError(t, err)
+		assert.Empty(t, history)
+	}
+}
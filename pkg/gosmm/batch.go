@@ -0,0 +1,207 @@
+package gosmm
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// defaultBatchSize is the default of BatchOptions.Size
+const defaultBatchSize = 1000
+
+// batchCheckpointTable records the key each checkpointed Batch resumes from
+const batchCheckpointTable = "gosmm_batch_checkpoint"
+
+// BatchOptions configures Batch
+type BatchOptions struct {
+	// Table is the table iterated, inserted in the queries as is
+	Table string
+	// Key is the integer primary key column the batches are ranges of, inserted in the queries as is
+	Key string
+	// Size is the width of the key range of a batch, 1000 when zero
+	Size int64
+	// Sleep pauses between two batches, giving the other transactions room on a busy table
+	Sleep time.Duration
+	// Progress is called after each committed batch
+	Progress func(BatchProgress)
+	// Checkpoint names the backfill in the gosmm_batch_checkpoint table, which records the end of each batch in its
+	// transaction, so that a run interrupted or failed resumes after the last committed batch rather than from the
+	// minimum of the key. The checkpoint is deleted after the last batch. Empty disables checkpoints.
+	Checkpoint string
+	// Driver is the driver of db, which the queries of the checkpoint are written for
+	Driver string
+}
+
+// BatchProgress is the progress of Batch after a committed batch
+type BatchProgress struct {
+	Table string
+	// From and To are the key range of the batch, To being excluded
+	From int64
+	To   int64
+	// Batches is the number of committed batches
+	Batches int
+	// Percent is the share of the key range of the table processed
+	Percent float64
+}
+
+// BatchFunc processes the rows of a batch, whose key is in [from, to), in its own transaction tx,
+// e.g. with an UPDATE ... WHERE id >= from AND id < to. It must not commit or rollback tx.
+type BatchFunc func(ctx context.Context, tx *sql.Tx, from int64, to int64) error
+
+// Batch calls fn for consecutive ranges of the key of the table, from its minimum to its maximum, each in
+// its own transaction committed before the next one, so that a large backfill in a Go migration does not
+// hold a long transaction. The batches run on db rather than in the transaction of the migration, so
+// register the migration with a closure over db. A failed run leaves the previous batches committed
+// and the migration is run again from the start, so fn must be idempotent, e.g. skip the rows already
+// backfilled, unless opts.Checkpoint resumes it after the last committed batch. Rows inserted after Batch
+// read the key range are not processed.
+func Batch(ctx context.Context, db *sql.DB, opts BatchOptions, fn BatchFunc) error {
+	if opts.Table == "" || opts.Key == "" {
+		return fmt.Errorf("missing table or key of batch")
+	}
+	if opts.Checkpoint != "" && opts.Driver == "" {
+		return fmt.Errorf("missing driver of batch checkpoint %s", opts.Checkpoint)
+	}
+	size := opts.Size
+	if size <= 0 {
+		size = defaultBatchSize
+	}
+
+	var min, max sql.NullInt64
+	query := fmt.Sprintf("SELECT MIN(%s), MAX(%s) FROM %s", opts.Key, opts.Key, opts.Table)
+	if err := db.QueryRowContext(ctx, query).Scan(&min, &max); err != nil {
+		return fmt.Errorf("failed to read key range of %s: %w", opts.Table, err)
+	}
+	if !min.Valid {
+		return nil // empty table
+	}
+
+	start := min.Int64
+	if opts.Checkpoint != "" {
+		resumed, err := loadCheckpoint(ctx, db, opts.Driver, opts.Checkpoint, start)
+		if err != nil {
+			return err
+		}
+		if resumed > max.Int64 {
+			return deleteCheckpoint(ctx, db, opts.Driver, opts.Checkpoint) // the last batch was committed
+		}
+		start = resumed
+	}
+
+	batches := 0
+	for from := start; ; from += size {
+		if batches > 0 && opts.Sleep > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(opts.Sleep):
+			}
+		}
+		to := from + size
+		if err := runBatch(ctx, db, opts, fn, from, to); err != nil {
+			return fmt.Errorf("batch [%d, %d) of %s failed: %w", from, to, opts.Table, err)
+		}
+		batches++
+		if opts.Progress != nil {
+			done := to - min.Int64
+			if to > max.Int64 {
+				done = max.Int64 - min.Int64 + 1
+			}
+			opts.Progress(BatchProgress{
+				Table:   opts.Table,
+				From:    from,
+				To:      to,
+				Batches: batches,
+				Percent: float64(done) * 100 / float64(max.Int64-min.Int64+1),
+			})
+		}
+		// the next range would start after the maximum, or overflow
+		if to > max.Int64 || to < from {
+			if opts.Checkpoint != "" {
+				return deleteCheckpoint(ctx, db, opts.Driver, opts.Checkpoint)
+			}
+			return nil
+		}
+	}
+}
+
+// runBatch runs fn in a transaction, committed when fn succeeds together with the checkpoint of opts
+func runBatch(ctx context.Context, db *sql.DB, opts BatchOptions, fn BatchFunc, from int64, to int64) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	if err := fn(ctx, tx, from, to); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if opts.Checkpoint != "" {
+		query := `UPDATE ` + batchCheckpointTable + ` SET next_key = ` + bindParam(opts.Driver, 1) + `, updated_at = ` +
+			bindParam(opts.Driver, 2) + ` WHERE checkpoint_name = ` + bindParam(opts.Driver, 3)
+		if _, err := tx.ExecContext(ctx, query, to, time.Now().UnixMilli(), opts.Checkpoint); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record checkpoint: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// loadCheckpoint returns the key the batches of the checkpoint named name resume from, recording min for a
+// new checkpoint
+func loadCheckpoint(ctx context.Context, db *sql.DB, driver string, name string, min int64) (int64, error) {
+	if dialectFor(driver) != nil {
+		return 0, fmt.Errorf("batch checkpoints are not supported by the %s driver", driver)
+	}
+	if _, err := db.ExecContext(ctx, batchCheckpointTableDDL(driver)); err != nil {
+		return 0, fmt.Errorf("failed to create checkpoint table: %w", err)
+	}
+	var next int64
+	err := db.QueryRowContext(ctx, `SELECT next_key FROM `+batchCheckpointTable+` WHERE checkpoint_name = `+bindParam(driver, 1), name).Scan(&next)
+	if err == nil {
+		return next, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("failed to read checkpoint %s: %w", name, err)
+	}
+	query := `INSERT INTO ` + batchCheckpointTable + ` (checkpoint_name, next_key, updated_at) VALUES (` + bindParams(driver, 3) + `)`
+	if _, err := db.ExecContext(ctx, query, name, min, time.Now().UnixMilli()); err != nil {
+		return 0, fmt.Errorf("failed to record checkpoint %s: %w", name, err)
+	}
+	return min, nil
+}
+
+// deleteCheckpoint deletes the checkpoint named name after its last batch, so that the backfill starts over
+// when it is run again
+func deleteCheckpoint(ctx context.Context, db *sql.DB, driver string, name string) error {
+	if _, err := db.ExecContext(ctx, `DELETE FROM `+batchCheckpointTable+` WHERE checkpoint_name = `+bindParam(driver, 1), name); err != nil {
+		return fmt.Errorf("failed to delete checkpoint %s: %w", name, err)
+	}
+	return nil
+}
+
+// batchCheckpointTableDDL returns the statement creating the checkpoint table for the given driver
+func batchCheckpointTableDDL(driver string) string {
+	switch driver {
+	case "mysql":
+		return `CREATE TABLE IF NOT EXISTS ` + batchCheckpointTable + ` (
+			checkpoint_name VARCHAR(255) NOT NULL PRIMARY KEY,
+			next_key BIGINT NOT NULL,
+			updated_at BIGINT NOT NULL
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`
+	case "sqlserver":
+		return `IF OBJECT_ID(N'` + batchCheckpointTable + `', N'U') IS NULL
+		CREATE TABLE ` + batchCheckpointTable + ` (
+			checkpoint_name NVARCHAR(255) NOT NULL PRIMARY KEY,
+			next_key BIGINT NOT NULL,
+			updated_at BIGINT NOT NULL
+		)`
+	default:
+		return `CREATE TABLE IF NOT EXISTS ` + batchCheckpointTable + ` (
+			checkpoint_name VARCHAR(255) NOT NULL PRIMARY KEY,
+			next_key BIGINT NOT NULL,
+			updated_at BIGINT NOT NULL
+		)`
+	}
+}
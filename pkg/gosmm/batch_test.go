@@ -0,0 +1,149 @@
+package gosmm
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatch(t *testing.T) {
+	// the batches run on their own connections, which share a database file
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "batch.db"))
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT, domain TEXT);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	assert.NoError(t, MigrateWithConfig(db, MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}))
+	for id := 3; id <= 27; id++ {
+		if _, err := db.Exec("INSERT INTO users (id, email) VALUES (?, ?)", id, "user@example.com"); err != nil {
+			t.Fatalf("Failed to insert user: %v", err)
+		}
+	}
+
+	var progress []BatchProgress
+	backfill := func(ctx context.Context, conn *sql.Conn, tx *sql.Tx) error {
+		return Batch(ctx, db, BatchOptions{
+			Table:    "users",
+			Key:      "id",
+			Size:     10,
+			Sleep:    time.Millisecond,
+			Progress: func(p BatchProgress) { progress = append(progress, p) },
+		}, func(ctx context.Context, tx *sql.Tx, from int64, to int64) error {
+			_, err := tx.ExecContext(ctx, "UPDATE users SET domain = 'example.com' WHERE id >= ? AND id < ? AND domain IS NULL", from, to)
+			return err
+		})
+	}
+	err = MigrateWithConfig(db, MigrationConfig{
+		MigrationsDir: dir,
+		Driver:        "sqlite3",
+		GoMigrations:  map[string]GoMigrationFunc{"v20230102_backfill_domain_00002": backfill},
+	})
+	assert.NoError(t, err)
+
+	var missing int
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM users WHERE domain IS NULL").Scan(&missing))
+	assert.Equal(t, 0, missing)
+	assert.Equal(t, []BatchProgress{
+		{Table: "users", From: 3, To: 13, Batches: 1, Percent: 40},
+		{Table: "users", From: 13, To: 23, Batches: 2, Percent: 80},
+		{Table: "users", From: 23, To: 33, Batches: 3, Percent: 100},
+	}, progress)
+}
+
+func TestBatchErrors(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "batch.db"))
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT)"); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	noop := func(ctx context.Context, tx *sql.Tx, from int64, to int64) error { return nil }
+
+	assert.EqualError(t, Batch(context.Background(), db, BatchOptions{Table: "users"}, noop), "missing table or key of batch")
+
+	// An empty table has no batch
+	called := false
+	err = Batch(context.Background(), db, BatchOptions{Table: "users", Key: "id"}, func(ctx context.Context, tx *sql.Tx, from int64, to int64) error {
+		called = true
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.False(t, called)
+
+	// A failed batch is rolled back and stops the iteration
+	if _, err := db.Exec("INSERT INTO users (id, email) VALUES (1, 'a@example.com'), (2000, 'b@example.com')"); err != nil {
+		t.Fatalf("Failed to insert users: %v", err)
+	}
+	errBackfill := errors.New("backfill failed")
+	err = Batch(context.Background(), db, BatchOptions{Table: "users", Key: "id"}, func(ctx context.Context, tx *sql.Tx, from int64, to int64) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM users WHERE id >= ? AND id < ?", from, to); err != nil {
+			return err
+		}
+		return errBackfill
+	})
+	assert.True(t, errors.Is(err, errBackfill))
+	assert.EqualError(t, err, "batch [1, 1001) of users failed: backfill failed")
+	var count int
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count))
+	assert.Equal(t, 2, count)
+}
+
+func TestBatchCheckpoint(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "batch.db"))
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY, domain TEXT)"); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	for id := 1; id <= 25; id++ {
+		if _, err := db.Exec("INSERT INTO users (id) VALUES (?)", id); err != nil {
+			t.Fatalf("Failed to insert user: %v", err)
+		}
+	}
+	opts := BatchOptions{Table: "users", Key: "id", Size: 10, Checkpoint: "v20230102_backfill_domain_00002", Driver: "sqlite3"}
+	assert.EqualError(t, Batch(context.Background(), db, BatchOptions{Table: "users", Key: "id", Checkpoint: "backfill"}, nil), "missing driver of batch checkpoint backfill")
+
+	// The second batch fails after the first one was committed
+	errBackfill := errors.New("backfill failed")
+	err = Batch(context.Background(), db, opts, func(ctx context.Context, tx *sql.Tx, from int64, to int64) error {
+		if from > 1 {
+			return errBackfill
+		}
+		_, err := tx.ExecContext(ctx, "UPDATE users SET domain = 'example.com' WHERE id >= ? AND id < ?", from, to)
+		return err
+	})
+	assert.True(t, errors.Is(err, errBackfill))
+	var next int64
+	assert.NoError(t, db.QueryRow("SELECT next_key FROM gosmm_batch_checkpoint WHERE checkpoint_name = ?", opts.Checkpoint).Scan(&next))
+	assert.Equal(t, int64(11), next)
+
+	// The next run resumes after the committed batch, and deletes the checkpoint after the last one
+	var ranges [][2]int64
+	err = Batch(context.Background(), db, opts, func(ctx context.Context, tx *sql.Tx, from int64, to int64) error {
+		ranges = append(ranges, [2]int64{from, to})
+		_, err := tx.ExecContext(ctx, "UPDATE users SET domain = 'example.com' WHERE id >= ? AND id < ?", from, to)
+		return err
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, [][2]int64{{11, 21}, {21, 31}}, ranges)
+	var count int
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM users WHERE domain IS NULL").Scan(&count))
+	assert.Equal(t, 0, count)
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM gosmm_batch_checkpoint").Scan(&count))
+	assert.Equal(t, 0, count)
+}
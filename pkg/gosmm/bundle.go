@@ -0,0 +1,302 @@
+package gosmm
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// bundleManifestFile is the manifest of a bundle, listing the SHA-256 of its files
+	bundleManifestFile = "gosmm-manifest.json"
+	// bundleETagPrefix starts the ETags of the files of a bundle, their SHA-256 in the manifest
+	bundleETagPrefix = "sha256:"
+)
+
+// bundleModTime is the modification time of the entries of the bundles written by WriteBundle, fixed so that
+// bundling the same files gives the same archive
+var bundleModTime = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// bundleManifest is the content of the manifest of a bundle
+type bundleManifest struct {
+	// Files holds the hex encoded SHA-256 of the files of the bundle by name
+	Files map[string]string `json:"files"`
+}
+
+// isBundle reports whether path is a bundle, by its extension
+func isBundle(path string) bool {
+	return strings.HasSuffix(path, ".zip") || strings.HasSuffix(path, ".tar.gz") || strings.HasSuffix(path, ".tgz")
+}
+
+// BundleSource is a Source reading the migration files of a .zip, .tar.gz or .tgz archive, e.g. the single
+// immutable artifact of a release written by WriteBundle. The archive holds a gosmm-manifest.json listing the
+// SHA-256 of every file, and an archive whose files do not match it exactly is rejected.
+type BundleSource struct {
+	// Path is the path of the archive
+	Path string
+
+	files map[string][]byte
+}
+
+// List implements Source, reading the archive and checking it against its manifest
+func (b *BundleSource) List(context.Context) ([]SourceObject, error) {
+	files, err := readBundle(b.Path)
+	if err != nil {
+		return nil, err
+	}
+	b.files = files
+	objects := make([]SourceObject, 0, len(files))
+	for name, data := range files {
+		objects = append(objects, SourceObject{Name: name, ETag: bundleETagPrefix + hashHex(data)})
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Name < objects[j].Name })
+	return objects, nil
+}
+
+// Get implements Source with the content read by List
+func (b *BundleSource) Get(_ context.Context, object SourceObject) ([]byte, error) {
+	data, ok := b.files[object.Name]
+	if !ok {
+		return nil, fmt.Errorf("%s is not in the bundle %s", object.Name, b.Path)
+	}
+	if err := verifyETag(data, object.ETag); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// readBundle returns the content of the files of the archive at path by name, checked against its manifest
+func readBundle(path string) (map[string][]byte, error) {
+	var (
+		files map[string][]byte
+		err   error
+	)
+	if strings.HasSuffix(path, ".zip") {
+		files, err = readZipBundle(path)
+	} else {
+		files, err = readTarBundle(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundle %s: %w", path, err)
+	}
+
+	data, ok := files[bundleManifestFile]
+	if !ok {
+		return nil, fmt.Errorf("invalid bundle %s: missing %s", path, bundleManifestFile)
+	}
+	delete(files, bundleManifestFile)
+	var manifest bundleManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid bundle %s: invalid %s: %w", path, bundleManifestFile, err)
+	}
+	for name, sum := range manifest.Files {
+		data, ok := files[name]
+		if !ok {
+			return nil, fmt.Errorf("invalid bundle %s: %s is in the manifest but not in the archive", path, name)
+		}
+		if actual := hashHex(data); !strings.EqualFold(actual, sum) {
+			return nil, fmt.Errorf("invalid bundle %s: the SHA-256 of %s is %s instead of %s", path, name, actual, sum)
+		}
+	}
+	for name := range files {
+		if _, ok := manifest.Files[name]; !ok {
+			return nil, fmt.Errorf("invalid bundle %s: %s is not in the manifest", path, name)
+		}
+	}
+	return files, nil
+}
+
+// readZipBundle returns the content of the files of a zip archive by name
+func readZipBundle(path string) (map[string][]byte, error) {
+	reader, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	files := make(map[string][]byte)
+	for _, file := range reader.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		if !file.Mode().IsRegular() {
+			return nil, fmt.Errorf("%s is not a regular file", file.Name)
+		}
+		rc, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", file.Name, err)
+		}
+		files[file.Name] = data
+	}
+	return files, nil
+}
+
+// readTarBundle returns the content of the files of a gzip compressed tar archive by name
+func readTarBundle(path string) (map[string][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	reader := tar.NewReader(gz)
+	files := make(map[string][]byte)
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			continue
+		case tar.TypeReg:
+		default:
+			return nil, fmt.Errorf("%s is not a regular file", header.Name)
+		}
+		data, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", header.Name, err)
+		}
+		files[strings.TrimPrefix(header.Name, "./")] = data
+	}
+}
+
+// WriteBundle writes the files of the migration directories, including the environment or nested directories, the
+// templates and the detached signatures, into the archive at path, a .zip, .tar.gz or .tgz file, with a manifest
+// listing their SHA-256. The archive is read by BundleSource. It returns the names of the bundled files.
+func WriteBundle(config MigrationConfig, path string) ([]string, error) {
+	if !isBundle(path) {
+		return nil, fmt.Errorf("unsupported bundle %s, only .zip, .tar.gz and .tgz archives are supported", path)
+	}
+	files := make(map[string][]byte)
+	for _, dir := range config.migrationDirs() {
+		if err := readBundleDir(dir, "", config.NestedDirs, files); err != nil {
+			return nil, err
+		}
+	}
+	names := make([]string, 0, len(files))
+	manifest := bundleManifest{Files: make(map[string]string, len(files))}
+	for name, data := range files {
+		names = append(names, name)
+		manifest.Files[name] = hashHex(data)
+	}
+	sort.Strings(names)
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if strings.HasSuffix(path, ".zip") {
+		err = writeZipBundle(&buf, data, names, files)
+	} else {
+		err = writeTarBundle(&buf, data, names, files)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write bundle %s: %w", path, err)
+	}
+	if err := writeFileAtomic(path, buf.Bytes()); err != nil {
+		return nil, fmt.Errorf("failed to write bundle %s: %w", path, err)
+	}
+	return names, nil
+}
+
+// readBundleDir adds the files of dir, and of its environment directories at the top level or of its
+// subdirectories at any depth when nested, to files under their name prefixed with prefix
+func readBundleDir(dir string, prefix string, nested bool, files map[string][]byte) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read migrations directory: %w", err)
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if entry.IsDir() {
+			if prefix == "" || nested {
+				if err := readBundleDir(path, prefix+entry.Name()+"/", nested, files); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if prefix == "" && entry.Name() == sourceManifestFile {
+			continue
+		}
+		name := prefix + entry.Name()
+		if _, ok := files[name]; ok {
+			return fmt.Errorf("duplicate migration file %s in %s", name, dir)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read file: %w", err)
+		}
+		files[name] = data
+	}
+	return nil
+}
+
+// writeZipBundle writes the manifest and the files into a zip archive
+func writeZipBundle(w io.Writer, manifest []byte, names []string, files map[string][]byte) error {
+	writer := zip.NewWriter(w)
+	for i, name := range append([]string{bundleManifestFile}, names...) {
+		data := manifest
+		if i > 0 {
+			data = files[name]
+		}
+		header := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: bundleModTime}
+		header.SetMode(0644)
+		entry, err := writer.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		if _, err := entry.Write(data); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+// writeTarBundle writes the manifest and the files into a gzip compressed tar archive
+func writeTarBundle(w io.Writer, manifest []byte, names []string, files map[string][]byte) error {
+	gz := gzip.NewWriter(w)
+	writer := tar.NewWriter(gz)
+	for i, name := range append([]string{bundleManifestFile}, names...) {
+		data := manifest
+		if i > 0 {
+			data = files[name]
+		}
+		header := &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(data)), ModTime: bundleModTime}
+		if err := writer.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := writer.Write(data); err != nil {
+			return err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
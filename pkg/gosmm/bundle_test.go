@@ -0,0 +1,155 @@
+package gosmm
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setupBundleDir(t *testing.T) string {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "dev"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		"v20230101_create_users_00001.sql":     "CREATE TABLE users (id INTEGER PRIMARY KEY);",
+		"v20230101_create_users_00001.sql.sig": "c2lnbmF0dXJl\n",
+		"dev/v20230102_seed_users_00002.sql":   "INSERT INTO users (id) VALUES (1);",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, filepath.FromSlash(name)), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test migration file: %v", err)
+		}
+	}
+	return dir
+}
+
+func TestWriteBundle(t *testing.T) {
+	config := MigrationConfig{MigrationsDir: setupBundleDir(t), Driver: "sqlite3", Environment: "dev"}
+
+	for _, name := range []string{"migrations.tar.gz", "migrations.zip"} {
+		path := filepath.Join(t.TempDir(), name)
+		bundled, err := WriteBundle(config, path)
+		assert.NoError(t, err, name)
+		assert.Equal(t, []string{"dev/v20230102_seed_users_00002.sql", "v20230101_create_users_00001.sql", "v20230101_create_users_00001.sql.sig"}, bundled, name)
+
+		// bundling the same files gives the same archive
+		first, err := ioutil.ReadFile(path)
+		assert.NoError(t, err)
+		_, err = WriteBundle(config, path)
+		assert.NoError(t, err)
+		second, err := ioutil.ReadFile(path)
+		assert.NoError(t, err)
+		assert.Equal(t, first, second, name)
+
+		// the bundle is read as a migrations source
+		db, teardown := setupTestDB(t)
+		bundleConfig := MigrationConfig{Source: &BundleSource{Path: path}, SourceCacheDir: t.TempDir(), Driver: "sqlite3", Environment: "dev"}
+		assert.NoError(t, FetchSource(context.Background(), bundleConfig), name)
+		assert.FileExists(t, filepath.Join(bundleConfig.SourceCacheDir, "v20230101_create_users_00001.sql.sig"))
+		assert.NoError(t, MigrateWithConfig(db, bundleConfig), name)
+		var count int
+		assert.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&count))
+		assert.Equal(t, 1, count, name)
+		teardown()
+	}
+
+	_, err := WriteBundle(config, filepath.Join(t.TempDir(), "migrations.rar"))
+	assert.ErrorContains(t, err, "unsupported bundle")
+}
+
+// writeZip writes a zip archive of files and returns its path
+func writeZip(t *testing.T, files map[string]string) string {
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	for name, content := range files {
+		entry, err := writer.Create(name)
+		assert.NoError(t, err)
+		_, err = entry.Write([]byte(content))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, writer.Close())
+	path := filepath.Join(t.TempDir(), "migrations.zip")
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestWriteBundleWithNestedDirs(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "2023", "users"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "2023", "users", "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER PRIMARY KEY);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3", NestedDirs: true}
+
+	path := filepath.Join(t.TempDir(), "migrations.tar.gz")
+	bundled, err := WriteBundle(config, path)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2023/users/v20230101_create_users_00001.sql"}, bundled)
+
+	db, teardown := setupTestDB(t)
+	defer teardown()
+	bundleConfig := MigrationConfig{Source: &BundleSource{Path: path}, SourceCacheDir: t.TempDir(), Driver: "sqlite3", NestedDirs: true}
+	assert.NoError(t, FetchSource(context.Background(), bundleConfig))
+	assert.NoError(t, MigrateWithConfig(db, bundleConfig))
+	history, err := GetHistory(db, bundleConfig)
+	assert.NoError(t, err)
+	assert.Len(t, history, 1)
+}
+
+func TestBundleSourceRejectsArchivesNotMatchingTheManifest(t *testing.T) {
+	migration := "CREATE TABLE users (id INTEGER PRIMARY KEY);"
+	manifest := `{"files": {"v20230101_create_users_00001.sql": "` + hashHex([]byte(migration)) + `"}}`
+
+	tests := map[string]struct {
+		files map[string]string
+		err   string
+	}{
+		"missing manifest": {
+			files: map[string]string{"v20230101_create_users_00001.sql": migration},
+			err:   "missing gosmm-manifest.json",
+		},
+		"modified file": {
+			files: map[string]string{bundleManifestFile: manifest, "v20230101_create_users_00001.sql": "DROP TABLE users;"},
+			err:   "the SHA-256 of v20230101_create_users_00001.sql",
+		},
+		"missing file": {
+			files: map[string]string{bundleManifestFile: manifest},
+			err:   "v20230101_create_users_00001.sql is in the manifest but not in the archive",
+		},
+		"unlisted file": {
+			files: map[string]string{bundleManifestFile: manifest, "v20230101_create_users_00001.sql": migration, "v20230102_drop_users_00002.sql": "DROP TABLE users;"},
+			err:   "v20230102_drop_users_00002.sql is not in the manifest",
+		},
+	}
+	for name, tt := range tests {
+		source := &BundleSource{Path: writeZip(t, tt.files)}
+		_, err := source.List(context.Background())
+		assert.ErrorContains(t, err, tt.err, name)
+	}
+
+	source := &BundleSource{Path: writeZip(t, map[string]string{bundleManifestFile: manifest, "v20230101_create_users_00001.sql": migration})}
+	objects, err := source.List(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []SourceObject{{Name: "v20230101_create_users_00001.sql", ETag: "sha256:" + hashHex([]byte(migration))}}, objects)
+}
+
+func TestParseMigrationsSource(t *testing.T) {
+	source, err := parseMigrationsSource("releases/migrations.tar.gz", "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, &BundleSource{Path: "releases/migrations.tar.gz"}, source)
+	source, err = parseMigrationsSource("file:///releases/migrations.zip", "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, &BundleSource{Path: "/releases/migrations.zip"}, source)
+	_, err = parseMigrationsSource("file:///releases/migrations", "", "")
+	assert.ErrorContains(t, err, "unsupported bundle")
+}
@@ -0,0 +1,46 @@
+package gosmm
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// Check is a CI gate for a deployed database, e.g. staging before a release. In addition to the issues
+// reported by Validate, such as checksum drift and missing files, it reports the migrations that were not
+// applied and the failed migrations. Like Validate, it never modifies the database.
+// It returns a *ValidationError listing every issue found, or nil when the database is up to date.
+func Check(db *sql.DB, config MigrationConfig) error {
+	var issues []ValidationIssue
+	var validationErr *ValidationError
+	if err := Validate(db, config); errors.As(err, &validationErr) {
+		issues = append(issues, validationErr.Issues...)
+	} else if err != nil {
+		return err
+	}
+
+	report, err := Status(db, config)
+	if err != nil {
+		return err
+	}
+	for _, migration := range report.Migrations {
+		switch migration.State {
+		case MigrationPending:
+			issues = append(issues, ValidationIssue{
+				Kind:     IssuePendingMigration,
+				Filename: migration.Filename,
+				Message:  "migration was not applied",
+			})
+		case MigrationFailed:
+			issues = append(issues, ValidationIssue{
+				Kind:     IssueFailedMigration,
+				Filename: migration.Filename,
+				Message:  "migration failed and the database is dirty",
+			})
+		}
+	}
+
+	if len(issues) > 0 {
+		return &ValidationError{Issues: issues}
+	}
+	return nil
+}
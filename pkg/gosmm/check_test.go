@@ -0,0 +1,64 @@
+package gosmm
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheck(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	file1 := filepath.Join(dir, "v20230101_create_users_00001.sql")
+	if err := ioutil.WriteFile(file1, []byte("CREATE TABLE users (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}
+
+	// A migration that was not applied fails the check
+	err := Check(db, config)
+	var validationErr *ValidationError
+	assert.True(t, errors.As(err, &validationErr))
+	assert.True(t, errors.Is(err, ErrPendingMigrations))
+	assert.Equal(t, []ValidationIssue{{Kind: IssuePendingMigration, Filename: "v20230101_create_users_00001.sql", Message: "migration was not applied"}}, validationErr.Issues)
+
+	err = MigrateWithConfig(db, config)
+	assert.NoError(t, err)
+	assert.NoError(t, Check(db, config))
+
+	// Drift and missing files fail the check
+	if err := ioutil.WriteFile(file1, []byte("CREATE TABLE users (id INTEGER, name TEXT);"), 0644); err != nil {
+		t.Fatalf("Failed to modify test migration file: %v", err)
+	}
+	err = Check(db, config)
+	assert.True(t, errors.Is(err, ErrChecksumMismatch))
+	assert.False(t, errors.Is(err, ErrPendingMigrations))
+
+	if err := os.Remove(file1); err != nil {
+		t.Fatalf("Failed to delete test migration file: %v", err)
+	}
+	err = Check(db, config)
+	assert.True(t, errors.Is(err, ErrMissingFile))
+}
+
+func TestCheckWithFailedMigration(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("ALTER TABLE missing_table ADD COLUMN email TEXT;"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}
+	assert.Error(t, MigrateWithConfig(db, config))
+
+	err := Check(db, config)
+	assert.True(t, errors.Is(err, ErrDirtyState))
+	assert.False(t, errors.Is(err, ErrPendingMigrations))
+}
@@ -0,0 +1,167 @@
+package gosmm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ChecksumAlgorithm is the algorithm of the checksums of the migration files recorded in the history table
+type ChecksumAlgorithm string
+
+const (
+	// ChecksumSHA256 is the hex encoded SHA-256 of the file, the default
+	ChecksumSHA256 ChecksumAlgorithm = "sha256"
+	// ChecksumCRC32 is the CRC32 of the lines of the file without their line endings, as the signed decimal
+	// recorded by Flyway, so that the checksums of a history imported from Flyway keep matching
+	ChecksumCRC32 ChecksumAlgorithm = "crc32"
+)
+
+// ChecksumConfig configures the checksums of the migration files. The normalizations are applied to the file
+// before its checksum is computed, so that reformatting an applied migration, e.g. its line endings converted
+// by git on Windows, is not reported as a modification by Validate. Changing the checksums of a database whose
+// migrations are applied makes Validate report all of them.
+type ChecksumConfig struct {
+	// Algorithm is ChecksumSHA256 when empty
+	Algorithm ChecksumAlgorithm
+	// Func computes the checksum instead of Algorithm
+	Func func(data []byte) string
+	// IgnoreLineEndings converts the CRLF and CR line endings into LF
+	IgnoreLineEndings bool
+	// IgnoreWhitespace collapses every run of whitespace, including line endings, into a single space
+	IgnoreWhitespace bool
+	// IgnoreComments removes the -- and /* */ comments, including the header and the gosmm directives
+	IgnoreComments bool
+}
+
+// ParseChecksumAlgorithm returns the algorithm named s, e.g. "crc32"
+func ParseChecksumAlgorithm(s string) (ChecksumAlgorithm, error) {
+	switch algorithm := ChecksumAlgorithm(s); algorithm {
+	case ChecksumSHA256, ChecksumCRC32:
+		return algorithm, nil
+	default:
+		return "", fmt.Errorf("unsupported checksum algorithm: %s", s)
+	}
+}
+
+// commentOrStringPattern matches the SQL strings, kept by IgnoreComments, and comments
+var commentOrStringPattern = regexp.MustCompile(`(?s)'(?:[^']|'')*'|--[^\n]*|/\*.*?\*/`)
+
+// calculateChecksum returns the hex encoded SHA-256 checksum of a migration file's content
+func calculateChecksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// checksum returns the checksum of a migration file's content recorded in the history table, see ChecksumConfig
+func (c MigrationConfig) checksum(data []byte) (string, error) {
+	data = c.Checksum.normalize(data)
+	if c.Checksum.Func != nil {
+		return c.Checksum.Func(data), nil
+	}
+	switch c.Checksum.Algorithm {
+	case "", ChecksumSHA256:
+		return calculateChecksum(data), nil
+	case ChecksumCRC32:
+		return flywayChecksum(data), nil
+	default:
+		return "", fmt.Errorf("unsupported checksum algorithm: %s", c.Checksum.Algorithm)
+	}
+}
+
+// normalize applies the normalizations of c to data
+func (c ChecksumConfig) normalize(data []byte) []byte {
+	if c.IgnoreComments {
+		data = commentOrStringPattern.ReplaceAllFunc(data, func(match []byte) []byte {
+			if match[0] == '\'' {
+				return match
+			}
+			return nil
+		})
+	}
+	if c.IgnoreWhitespace {
+		data = bytes.Join(bytes.Fields(data), []byte(" "))
+	} else if c.IgnoreLineEndings {
+		data = bytes.ReplaceAll(bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n")), []byte("\r"), []byte("\n"))
+	}
+	return data
+}
+
+// checksumWriter returns a writer computing the checksum of the content written to it and a function returning
+// the checksum, for the checksums of the files which are streamed rather than normalized in memory
+func (c MigrationConfig) checksumWriter() (io.Writer, func() string, error) {
+	switch c.Checksum.Algorithm {
+	case "", ChecksumSHA256:
+		hash := sha256.New()
+		return hash, func() string { return hex.EncodeToString(hash.Sum(nil)) }, nil
+	case ChecksumCRC32:
+		writer := &flywayChecksumWriter{crc: crc32.NewIEEE()}
+		return writer, writer.checksum, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported checksum algorithm: %s", c.Checksum.Algorithm)
+	}
+}
+
+// flywayChecksumWriter computes the checksum of flywayChecksum from the content written to it
+type flywayChecksumWriter struct {
+	crc hash.Hash32
+	// head holds the first bytes, which may be a byte order mark, until there are enough of them to tell
+	head    []byte
+	started bool
+}
+
+func (w *flywayChecksumWriter) Write(p []byte) (int, error) {
+	if w.started {
+		w.write(p)
+		return len(p), nil
+	}
+	w.head = append(w.head, p...)
+	if len(w.head) >= len(byteOrderMark) {
+		w.start()
+	}
+	return len(p), nil
+}
+
+// start writes the head of the content without its byte order mark
+func (w *flywayChecksumWriter) start() {
+	w.write(bytes.TrimPrefix(w.head, []byte(byteOrderMark)))
+	w.head, w.started = nil, true
+}
+
+// write adds p to the checksum without its line endings
+func (w *flywayChecksumWriter) write(p []byte) {
+	for len(p) > 0 {
+		i := bytes.IndexAny(p, "\r\n")
+		if i == -1 {
+			w.crc.Write(p)
+			return
+		}
+		w.crc.Write(p[:i])
+		p = p[i+1:]
+	}
+}
+
+func (w *flywayChecksumWriter) checksum() string {
+	if !w.started {
+		w.start()
+	}
+	return strconv.FormatInt(int64(int32(w.crc.Sum32())), 10)
+}
+
+// byteOrderMark is the UTF-8 byte order mark ignored by flywayChecksum
+const byteOrderMark = "\ufeff"
+
+// flywayChecksum returns the CRC32 of the lines of data, without the byte order mark and the line endings,
+// as a signed 32-bit decimal like Flyway
+func flywayChecksum(data []byte) string {
+	text := strings.TrimPrefix(string(data), byteOrderMark)
+	text = strings.NewReplacer("\r", "", "\n", "").Replace(text)
+	return strconv.FormatInt(int64(int32(crc32.ChecksumIEEE([]byte(text)))), 10)
+}
@@ -0,0 +1,86 @@
+package gosmm
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChecksum(t *testing.T) {
+	data := []byte("CREATE TABLE users (id INT);\n")
+
+	checksum, err := MigrationConfig{}.checksum(data)
+	assert.NoError(t, err)
+	assert.Equal(t, calculateChecksum(data), checksum)
+
+	// Flyway ignores the byte order mark and the line endings
+	crc32 := MigrationConfig{Checksum: ChecksumConfig{Algorithm: ChecksumCRC32}}
+	checksum, err = crc32.checksum(data)
+	assert.NoError(t, err)
+	assert.Equal(t, "1303508785", checksum)
+	checksum, err = crc32.checksum([]byte("\ufeffCREATE TABLE users (id INT);\r\nINSERT INTO users VALUES (1);\r\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "-1782375719", checksum)
+
+	custom := MigrationConfig{Checksum: ChecksumConfig{Func: func(data []byte) string { return string(data) }, IgnoreWhitespace: true}}
+	checksum, err = custom.checksum([]byte("CREATE TABLE users (\n    id INT\n);\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "CREATE TABLE users ( id INT );", checksum)
+
+	_, err = MigrationConfig{Checksum: ChecksumConfig{Algorithm: "md5"}}.checksum(data)
+	assert.EqualError(t, err, "unsupported checksum algorithm: md5")
+}
+
+func TestChecksumNormalization(t *testing.T) {
+	original := []byte("-- create the users\nCREATE TABLE users (id INT);\nINSERT INTO users VALUES ('-- not a comment');\n")
+	for _, tt := range []struct {
+		name        string
+		config      ChecksumConfig
+		reformatted string
+		same        bool
+	}{
+		{"line endings", ChecksumConfig{IgnoreLineEndings: true}, "-- create the users\r\nCREATE TABLE users (id INT);\r\nINSERT INTO users VALUES ('-- not a comment');\r\n", true},
+		{"line endings not ignored", ChecksumConfig{}, "-- create the users\r\nCREATE TABLE users (id INT);\r\nINSERT INTO users VALUES ('-- not a comment');\r\n", false},
+		{"whitespace", ChecksumConfig{IgnoreWhitespace: true}, "-- create the users\nCREATE TABLE  users (id INT);\n\n  INSERT INTO users VALUES ('-- not a comment');", true},
+		{"comments", ChecksumConfig{IgnoreComments: true}, "CREATE TABLE users (id INT); /* users */\nINSERT INTO users VALUES ('-- not a comment');\n", false},
+		{"comments and whitespace", ChecksumConfig{IgnoreComments: true, IgnoreWhitespace: true}, "CREATE TABLE users (id INT); /* users */\nINSERT INTO users VALUES ('-- not a comment');\n", true},
+		{"string", ChecksumConfig{IgnoreComments: true, IgnoreWhitespace: true}, "CREATE TABLE users (id INT);\nINSERT INTO users VALUES ('-- a comment');\n", false},
+	} {
+		config := MigrationConfig{Checksum: tt.config}
+		expected, err := config.checksum(original)
+		assert.NoError(t, err)
+		actual, err := config.checksum([]byte(tt.reformatted))
+		assert.NoError(t, err)
+		assert.Equal(t, tt.same, expected == actual, tt.name)
+	}
+}
+
+func TestValidateNormalizedChecksum(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "v20230101_create_users_00001.sql")
+	if err := ioutil.WriteFile(path, []byte("CREATE TABLE users (id INTEGER);\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3", Checksum: ChecksumConfig{Algorithm: ChecksumCRC32}}
+	assert.NoError(t, MigrateWithConfig(db, config))
+
+	history, err := GetHistory(db, config)
+	assert.NoError(t, err)
+	if assert.Len(t, history, 1) {
+		assert.Equal(t, flywayChecksum([]byte("CREATE TABLE users (id INTEGER);")), history[0].Checksum)
+	}
+
+	// the line endings converted on checkout
+	if err := ioutil.WriteFile(path, []byte("CREATE TABLE users (id INTEGER);\r\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, Validate(db, config))
+
+	config.Checksum = ChecksumConfig{}
+	assert.ErrorIs(t, Validate(db, config), ErrChecksumMismatch)
+}
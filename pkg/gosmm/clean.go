@@ -0,0 +1,126 @@
+package gosmm
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Clean drops all views, tables and sequences in the configured schema (the connection's default
+// schema when empty), including the migration history table.
+// It refuses to run unless config.AllowClean is set, since the data cannot be recovered.
+func Clean(db *sql.DB, config MigrationConfig) error {
+	if !config.AllowClean {
+		return ErrCleanNotAllowed
+	}
+	if !isSupportedDriver(config.Driver) {
+		return fmt.Errorf("unsupported driver: %s", config.Driver)
+	}
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	statements, err := cleanStatements(ctx, conn, config.Driver, config.Schema)
+	if err != nil {
+		return fmt.Errorf("failed to list schema objects: %w", err)
+	}
+	statements = append(statements, `DROP TABLE IF EXISTS `+historyTableName(config.Driver, config.Schema))
+
+	// Foreign keys would otherwise require dropping the tables in dependency order
+	switch config.Driver {
+	case "mysql":
+		if _, err := conn.ExecContext(ctx, `SET FOREIGN_KEY_CHECKS = 0`); err != nil {
+			return fmt.Errorf("failed to disable foreign key checks: %w", err)
+		}
+		defer conn.ExecContext(ctx, `SET FOREIGN_KEY_CHECKS = 1`)
+	case "sqlite3":
+		if _, err := conn.ExecContext(ctx, `PRAGMA foreign_keys = OFF`); err != nil {
+			return fmt.Errorf("failed to disable foreign key checks: %w", err)
+		}
+		defer conn.ExecContext(ctx, `PRAGMA foreign_keys = ON`)
+	}
+
+	for _, statement := range statements {
+		if _, err := conn.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("failed to execute %s: %w", statement, err)
+		}
+		config.LogLevel.printf(LogInfo, "OK    %s\n", statement)
+	}
+	return nil
+}
+
+// cleanStatements returns the statements dropping every view, table and sequence in the schema
+func cleanStatements(ctx context.Context, conn *sql.Conn, driver string, schema string) ([]string, error) {
+	var queries []string
+	var cascade string
+	switch driver {
+	case "postgres":
+		queries = []string{
+			`SELECT 'VIEW', table_schema, table_name FROM information_schema.views WHERE table_schema = COALESCE(NULLIF($1, ''), current_schema())`,
+			`SELECT 'TABLE', table_schema, table_name FROM information_schema.tables WHERE table_type = 'BASE TABLE' AND table_schema = COALESCE(NULLIF($1, ''), current_schema())`,
+			`SELECT 'SEQUENCE', sequence_schema, sequence_name FROM information_schema.sequences WHERE sequence_schema = COALESCE(NULLIF($1, ''), current_schema())`,
+		}
+		cascade = " CASCADE"
+	case "mysql":
+		queries = []string{
+			`SELECT 'VIEW', table_schema, table_name FROM information_schema.views WHERE table_schema = COALESCE(NULLIF(?, ''), DATABASE())`,
+			`SELECT 'TABLE', table_schema, table_name FROM information_schema.tables WHERE table_type = 'BASE TABLE' AND table_schema = COALESCE(NULLIF(?, ''), DATABASE())`,
+		}
+	case "sqlite3":
+		queries = []string{
+			`SELECT 'VIEW', '', name FROM sqlite_master WHERE type = 'view'`,
+			`SELECT 'TABLE', '', name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'`,
+		}
+	case "sqlserver":
+		queries = []string{
+			`SELECT 'CONSTRAINT', QUOTENAME(SCHEMA_NAME(t.schema_id)) + '.' + QUOTENAME(t.name), fk.name FROM sys.foreign_keys fk JOIN sys.tables t ON t.object_id = fk.parent_object_id WHERE SCHEMA_NAME(fk.schema_id) = COALESCE(NULLIF(@p1, ''), SCHEMA_NAME())`,
+			`SELECT 'VIEW', SCHEMA_NAME(schema_id), name FROM sys.views WHERE SCHEMA_NAME(schema_id) = COALESCE(NULLIF(@p1, ''), SCHEMA_NAME())`,
+			`SELECT 'TABLE', SCHEMA_NAME(schema_id), name FROM sys.tables WHERE SCHEMA_NAME(schema_id) = COALESCE(NULLIF(@p1, ''), SCHEMA_NAME())`,
+			`SELECT 'SEQUENCE', SCHEMA_NAME(schema_id), name FROM sys.sequences WHERE SCHEMA_NAME(schema_id) = COALESCE(NULLIF(@p1, ''), SCHEMA_NAME())`,
+		}
+	default:
+		return nil, fmt.Errorf("unsupported driver: %s", driver)
+	}
+
+	var statements []string
+	for _, query := range queries {
+		args := []interface{}{schema}
+		if driver == "sqlite3" {
+			args = nil // sqlite has no schemas
+		}
+		rows, err := conn.QueryContext(ctx, query, args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var kind, objectSchema, name string
+			if err := rows.Scan(&kind, &objectSchema, &name); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			statements = append(statements, dropStatement(driver, kind, objectSchema, name, cascade))
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return statements, nil
+}
+
+// dropStatement returns the statement dropping a schema object. For a CONSTRAINT, objectSchema
+// holds the quoted, schema qualified table the constraint belongs to.
+func dropStatement(driver string, kind string, objectSchema string, name string, cascade string) string {
+	if kind == "CONSTRAINT" {
+		return `ALTER TABLE ` + objectSchema + ` DROP CONSTRAINT ` + quoteIdentifier(driver, name)
+	}
+	qualified := quoteIdentifier(driver, name)
+	if objectSchema != "" {
+		qualified = quoteIdentifier(driver, objectSchema) + "." + qualified
+	}
+	return `DROP ` + kind + ` IF EXISTS ` + qualified + cascade
+}
@@ -0,0 +1,65 @@
+package gosmm
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCleanWithoutAllowClean(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	_, err := db.Exec("CREATE TABLE test_table (id INTEGER)")
+	if err != nil {
+		t.Fatalf("Failed to create test_table: %v", err)
+	}
+
+	err = Clean(db, MigrationConfig{Driver: "sqlite3"})
+	assert.ErrorIs(t, err, ErrCleanNotAllowed)
+
+	// Check test_table still exists
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'test_table'").Scan(&count)
+	if err != nil {
+		t.Fatalf("Failed to query sqlite_master: %v", err)
+	}
+	assert.Equal(t, 1, count)
+}
+
+func TestClean(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	// Create tables referencing each other, a view and the history table
+	for _, statement := range []string{
+		"PRAGMA foreign_keys = ON",
+		"CREATE TABLE parent (id INTEGER PRIMARY KEY)",
+		"CREATE TABLE child (id INTEGER PRIMARY KEY, parent_id INTEGER REFERENCES parent (id))",
+		"INSERT INTO parent VALUES (1)",
+		"INSERT INTO child VALUES (1, 1)",
+		"CREATE VIEW child_view AS SELECT * FROM child",
+		historyTableDDL("sqlite3", migrationHistoryTable),
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatalf("Failed to execute %s: %v", statement, err)
+		}
+	}
+
+	err := Clean(db, MigrationConfig{Driver: "sqlite3", AllowClean: true})
+	assert.NoError(t, err)
+
+	// Check every object was dropped
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type IN ('table', 'view')").Scan(&count)
+	if err != nil {
+		t.Fatalf("Failed to query sqlite_master: %v", err)
+	}
+	assert.Equal(t, 0, count)
+}
+
+func TestDropStatement(t *testing.T) {
+	assert.Equal(t, `DROP TABLE IF EXISTS "public"."users" CASCADE`, dropStatement("postgres", "TABLE", "public", "users", " CASCADE"))
+	assert.Equal(t, "DROP VIEW IF EXISTS `app`.`active_users`", dropStatement("mysql", "VIEW", "app", "active_users", ""))
+	assert.Equal(t, `DROP TABLE IF EXISTS "users"`, dropStatement("sqlite3", "TABLE", "", "users", ""))
+	assert.Equal(t, "ALTER TABLE [dbo].[orders] DROP CONSTRAINT [fk_orders_users]", dropStatement("sqlserver", "CONSTRAINT", "[dbo].[orders]", "fk_orders_users", ""))
+}
@@ -0,0 +1,75 @@
+package gosmm
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lib/pq"
+)
+
+const (
+	// cockroachMaxAttempts is the number of times a migration is attempted on CockroachDB
+	// before a serialization failure is reported
+	cockroachMaxAttempts = 5
+	// cockroachRetryBackoff is the delay before the first retry, doubled on every attempt
+	cockroachRetryBackoff = 100 * time.Millisecond
+	// schemaChangeJobTimeout bounds how long a retry waits for in-flight schema change jobs
+	schemaChangeJobTimeout = 5 * time.Minute
+	// schemaChangeJobPollInterval is the interval between SHOW JOBS polls
+	schemaChangeJobPollInterval = 500 * time.Millisecond
+)
+
+// isCockroachDB reports whether a postgres driver connection is served by CockroachDB
+func isCockroachDB(db *sql.DB, driver string) (bool, error) {
+	if driver != "postgres" {
+		return false, nil
+	}
+	var version string
+	if err := db.QueryRow(`SELECT version()`).Scan(&version); err != nil {
+		return false, err
+	}
+	return strings.Contains(version, "CockroachDB"), nil
+}
+
+// isSerializationFailure reports whether err is a transaction aborted with SQLSTATE 40001,
+// which CockroachDB expects clients to retry
+func isSerializationFailure(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "40001"
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "40001"
+	}
+	return false
+}
+
+// retryBackoff returns the delay before the given retry attempt, starting at 1
+func retryBackoff(attempt int) time.Duration {
+	return cockroachRetryBackoff << (attempt - 1)
+}
+
+// waitForSchemaChangeJobs blocks until CockroachDB reports no running schema change jobs,
+// so a retried migration does not race the background job of an aborted attempt
+func waitForSchemaChangeJobs(db *sql.DB) error {
+	deadline := time.Now().Add(schemaChangeJobTimeout)
+	for {
+		var running int
+		err := db.QueryRow(`SELECT COUNT(*) FROM [SHOW JOBS] WHERE job_type = 'SCHEMA CHANGE' AND status IN ('pending', 'running', 'reverting')`).Scan(&running)
+		if err != nil {
+			return fmt.Errorf("failed to query schema change jobs: %w", err)
+		}
+		if running == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for %d schema change job(s) to finish", running)
+		}
+		time.Sleep(schemaChangeJobPollInterval)
+	}
+}
@@ -0,0 +1,65 @@
+//go:build integration
+
+package gosmm
+
+import (
+	"database/sql"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// setupCockroachDB connects to the CockroachDB database given by GOSMM_TEST_COCKROACH_DSN
+func setupCockroachDB(t *testing.T) (*sql.DB, func()) {
+	dsn := os.Getenv("GOSMM_TEST_COCKROACH_DSN")
+	if dsn == "" {
+		t.Skip("GOSMM_TEST_COCKROACH_DSN is not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+
+	return db, func() {
+		db.Close()
+	}
+}
+
+func TestCockroachMigrate(t *testing.T) {
+	db, teardown := setupCockroachDB(t)
+	defer teardown()
+
+	schema := "gosmm_it_cockroach"
+	defer db.Exec(`DROP SCHEMA IF EXISTS ` + schema + ` CASCADE`)
+
+	cockroach, err := isCockroachDB(db, "postgres")
+	if err != nil {
+		t.Fatalf("Failed to detect CockroachDB: %v", err)
+	}
+	assert.True(t, cockroach)
+
+	dir := t.TempDir()
+	migrationFile := filepath.Join(dir, "v20230101_create_test_data_00001.sql")
+	migration := `
+CREATE TABLE test_table (id INT PRIMARY KEY, name STRING);
+INSERT INTO test_table VALUES (1, 'a');
+`
+	if err := ioutil.WriteFile(migrationFile, []byte(migration), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+
+	err = MigrateWithConfig(db, MigrationConfig{MigrationsDir: dir, Driver: "postgres", Schema: schema})
+	assert.NoError(t, err)
+
+	var count int
+	err = db.QueryRow(`SELECT COUNT(*) FROM ` + historyTableName("postgres", schema) + ` WHERE success = TRUE`).Scan(&count)
+	if err != nil {
+		t.Fatalf("Failed to count history rows: %v", err)
+	}
+	assert.Equal(t, 1, count)
+
+	// No schema change job is left running after the migration
+	assert.NoError(t, waitForSchemaChangeJobs(db))
+}
@@ -0,0 +1,744 @@
+package gosmm
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultMigrationsDir = "./migrations"
+	defaultSeedsDir      = "./seeds"
+	envPrefix            = "GOSMM_"
+	placeholderEnvPrefix = envPrefix + "PLACEHOLDER_"
+
+	// passwordProviderSecretsManager and passwordProviderRDSIAM are the password_provider values
+	passwordProviderSecretsManager = "aws-secrets-manager"
+	passwordProviderRDSIAM         = "rds-iam"
+	passwordProviderVault          = "vault"
+
+	// webhookPresetSlack is the preset of the webhooks posting to Slack
+	webhookPresetSlack = "slack"
+)
+
+// envVarPattern matches the ${NAME} environment variable references interpolated by LoadConfig
+var envVarPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Config is the configuration shared by the CLI and the library, see LoadConfig
+type Config struct {
+	DB        DBConfig
+	Migration MigrationConfig
+	// Tenants lists the tenant schemas migrated by the CLI, when set
+	Tenants TenantsConfig
+	// Confirm makes `gosmm migrate` show the plan and ask for confirmation, e.g. for production databases
+	Confirm bool
+}
+
+// fileConfig is the layout of the gosmm.yaml and gosmm.toml configuration files
+type fileConfig struct {
+	Driver             string            `yaml:"driver" toml:"driver"`
+	DSN                string            `yaml:"dsn" toml:"dsn"`
+	PrimaryDSN         string            `yaml:"primary_dsn" toml:"primary_dsn"`
+	Host               string            `yaml:"host" toml:"host"`
+	Port               int               `yaml:"port" toml:"port"`
+	User               string            `yaml:"user" toml:"user"`
+	Password           string            `yaml:"password" toml:"password"`
+	DBName             string            `yaml:"dbname" toml:"dbname"`
+	SSLMode            string            `yaml:"ssl_mode" toml:"ssl_mode"`
+	SSLRootCert        string            `yaml:"ssl_root_cert" toml:"ssl_root_cert"`
+	SSLCert            string            `yaml:"ssl_cert" toml:"ssl_cert"`
+	SSLKey             string            `yaml:"ssl_key" toml:"ssl_key"`
+	SSLServerName      string            `yaml:"ssl_server_name" toml:"ssl_server_name"`
+	Params             map[string]string `yaml:"params" toml:"params"`
+	PasswordProvider   string            `yaml:"password_provider" toml:"password_provider"`
+	AWSRegion          string            `yaml:"aws_region" toml:"aws_region"`
+	AWSSecretID        string            `yaml:"aws_secret_id" toml:"aws_secret_id"`
+	VaultRole          string            `yaml:"vault_role" toml:"vault_role"`
+	VaultMount         string            `yaml:"vault_mount" toml:"vault_mount"`
+	MigrationsDir      string            `yaml:"migrations_dir" toml:"migrations_dir"`
+	MigrationsDirs     []string          `yaml:"migrations_dirs" toml:"migrations_dirs"`
+	FilenamePattern    string            `yaml:"filename_pattern" toml:"filename_pattern"`
+	Ignore             []string          `yaml:"ignore" toml:"ignore"`
+	NestedDirs         bool              `yaml:"nested_dirs" toml:"nested_dirs"`
+	SeedsDir           string            `yaml:"seeds_dir" toml:"seeds_dir"`
+	Schema             string            `yaml:"schema" toml:"schema"`
+	Environment        string            `yaml:"environment" toml:"environment"`
+	Skip               []string          `yaml:"skip" toml:"skip"`
+	Labels             []string          `yaml:"labels" toml:"labels"`
+	AllowOutOfOrder    bool              `yaml:"allow_out_of_order" toml:"allow_out_of_order"`
+	StrictOrdering     bool              `yaml:"strict_ordering" toml:"strict_ordering"`
+	AllowClean         bool              `yaml:"allow_clean" toml:"allow_clean"`
+	Resume             bool              `yaml:"resume" toml:"resume"`
+	Idempotent         bool              `yaml:"idempotent" toml:"idempotent"`
+	Placeholders       map[string]string `yaml:"placeholders" toml:"placeholders"`
+	RetryAttempts      int               `yaml:"retry_attempts" toml:"retry_attempts"`
+	RetryBackoff       string            `yaml:"retry_backoff" toml:"retry_backoff"`
+	TenantSchemas      []string          `yaml:"tenant_schemas" toml:"tenant_schemas"`
+	TenantSchemasQuery string            `yaml:"tenant_schemas_query" toml:"tenant_schemas_query"`
+	Confirm            bool              `yaml:"confirm" toml:"confirm"`
+	LogLevel           string            `yaml:"log_level" toml:"log_level"`
+	WaitForLock        string            `yaml:"wait_for_lock" toml:"wait_for_lock"`
+	Lease              string            `yaml:"lease" toml:"lease"`
+	LockTimeout        string            `yaml:"lock_timeout" toml:"lock_timeout"`
+	StatementTimeout   string            `yaml:"statement_timeout" toml:"statement_timeout"`
+	SchemaFile         string            `yaml:"schema_file" toml:"schema_file"`
+	ReportFile         string            `yaml:"report_file" toml:"report_file"`
+	Lint               lintFileConfig    `yaml:"lint" toml:"lint"`
+	ZeroDowntime       bool              `yaml:"zero_downtime" toml:"zero_downtime"`
+	VerifyIndexes      bool              `yaml:"verify_indexes" toml:"verify_indexes"`
+	Analyze            bool              `yaml:"analyze" toml:"analyze"`
+	Parallelism        int               `yaml:"parallelism" toml:"parallelism"`
+	BackupCommand      string            `yaml:"backup_command" toml:"backup_command"`
+	AuditHost          bool              `yaml:"audit_host" toml:"audit_host"`
+	Context            string            `yaml:"context" toml:"context"`
+	OnlineSchemaChange onlineFileConfig  `yaml:"online_schema_change" toml:"online_schema_change"`
+	Webhooks           []webhookConfig   `yaml:"webhooks" toml:"webhooks"`
+
+	// SignatureKeys are the paths of the PEM files holding the public keys of Signatures
+	SignatureKeys []string `yaml:"signature_keys" toml:"signature_keys"`
+	// Checksum holds the algorithm and the normalizations of the checksums
+	Checksum checksumFileConfig `yaml:"checksum" toml:"checksum"`
+	// MigrationsSource is the URL of the remote migration files, e.g. s3://bucket/prefix
+	MigrationsSource string `yaml:"migrations_source" toml:"migrations_source"`
+	// MigrationsSourceCache is the directory the remote migration files are fetched into
+	MigrationsSourceCache string `yaml:"migrations_source_cache" toml:"migrations_source_cache"`
+	// S3Endpoint is the endpoint of an S3-compatible store, e.g. MinIO
+	S3Endpoint string `yaml:"s3_endpoint" toml:"s3_endpoint"`
+
+	// TemplateData holds the values of the migration templates, e.g. lists of shard names
+	TemplateData map[string]interface{} `yaml:"template_data" toml:"template_data"`
+
+	// Account, Warehouse and Role are the Snowflake account identifier, warehouse and role
+	Account   string `yaml:"account" toml:"account"`
+	Warehouse string `yaml:"warehouse" toml:"warehouse"`
+	Role      string `yaml:"role" toml:"role"`
+
+	// MaxOpenConns, MaxIdleConns, ConnMaxLifetime and ConnMaxIdleTime tune the connection pool
+	MaxOpenConns    int    `yaml:"max_open_conns" toml:"max_open_conns"`
+	MaxIdleConns    int    `yaml:"max_idle_conns" toml:"max_idle_conns"`
+	ConnMaxLifetime string `yaml:"conn_max_lifetime" toml:"conn_max_lifetime"`
+	ConnMaxIdleTime string `yaml:"conn_max_idle_time" toml:"conn_max_idle_time"`
+	ConnectTimeout  string `yaml:"connect_timeout" toml:"connect_timeout"`
+	// Session holds the settings of the database sessions
+	Session sessionFileConfig `yaml:"session" toml:"session"`
+
+	// StreamThreshold is the size in bytes from which the migration files are streamed
+	StreamThreshold int64 `yaml:"stream_threshold" toml:"stream_threshold"`
+
+	// StableListings and ListingInterval configure the listings of the migration directories on network filesystems
+	StableListings  int    `yaml:"stable_listings" toml:"stable_listings"`
+	ListingInterval string `yaml:"listing_interval" toml:"listing_interval"`
+
+	// Approval is the change approval the runs wait for
+	Approval approvalFileConfig `yaml:"approval" toml:"approval"`
+}
+
+// approvalFileConfig is the layout of the change approval in the configuration files
+type approvalFileConfig struct {
+	URL          string            `yaml:"url" toml:"url"`
+	Headers      map[string]string `yaml:"headers" toml:"headers"`
+	Environments []string          `yaml:"environments" toml:"environments"`
+	PollInterval string            `yaml:"poll_interval" toml:"poll_interval"`
+	Timeout      string            `yaml:"timeout" toml:"timeout"`
+}
+
+// approval converts the file layout to an Approval, nil without URL
+func (c approvalFileConfig) approval() (*Approval, error) {
+	if c.URL == "" {
+		return nil, nil
+	}
+	approval := &Approval{URL: c.URL, Headers: c.Headers, Environments: c.Environments}
+	for name, value := range map[string]struct {
+		raw      string
+		duration *time.Duration
+	}{
+		"poll_interval": {c.PollInterval, &approval.PollInterval},
+		"timeout":       {c.Timeout, &approval.Timeout},
+	} {
+		if value.raw == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(value.raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid approval %s: %w", name, err)
+		}
+		*value.duration = duration
+	}
+	return approval, nil
+}
+
+// sessionFileConfig is the layout of the session settings in the configuration files
+type sessionFileConfig struct {
+	ApplicationName string `yaml:"application_name" toml:"application_name"`
+	SearchPath      string `yaml:"search_path" toml:"search_path"`
+	SQLMode         string `yaml:"sql_mode" toml:"sql_mode"`
+	TimeZone        string `yaml:"time_zone" toml:"time_zone"`
+}
+
+// lintFileConfig is the layout of the lint rules in the configuration files
+type lintFileConfig struct {
+	Disable      []string `yaml:"disable" toml:"disable"`
+	BigTableRows int64    `yaml:"big_table_rows" toml:"big_table_rows"`
+}
+
+// checksumFileConfig is the layout of the checksums in the configuration files
+type checksumFileConfig struct {
+	Algorithm string `yaml:"algorithm" toml:"algorithm"`
+	// Ignore lists the normalizations, "line-endings", "whitespace" and "comments"
+	Ignore []string `yaml:"ignore" toml:"ignore"`
+}
+
+// checksum converts the file layout to a ChecksumConfig
+func (c checksumFileConfig) checksum() (ChecksumConfig, error) {
+	var checksum ChecksumConfig
+	if c.Algorithm != "" {
+		algorithm, err := ParseChecksumAlgorithm(c.Algorithm)
+		if err != nil {
+			return ChecksumConfig{}, err
+		}
+		checksum.Algorithm = algorithm
+	}
+	for _, ignore := range c.Ignore {
+		switch ignore {
+		case "line-endings":
+			checksum.IgnoreLineEndings = true
+		case "whitespace":
+			checksum.IgnoreWhitespace = true
+		case "comments":
+			checksum.IgnoreComments = true
+		default:
+			return ChecksumConfig{}, fmt.Errorf("unsupported checksum normalization: %s", ignore)
+		}
+	}
+	return checksum, nil
+}
+
+// onlineFileConfig is the layout of the online schema change tool in the configuration files
+type onlineFileConfig struct {
+	Tool string   `yaml:"tool" toml:"tool"`
+	Path string   `yaml:"path" toml:"path"`
+	Args []string `yaml:"args" toml:"args"`
+}
+
+// webhookConfig is the layout of a webhook in the configuration files
+type webhookConfig struct {
+	URL string `yaml:"url" toml:"url"`
+	// Preset is "slack" to post the summary of the run to a Slack incoming webhook
+	Preset   string            `yaml:"preset" toml:"preset"`
+	Events   []string          `yaml:"events" toml:"events"`
+	Template string            `yaml:"template" toml:"template"`
+	Headers  map[string]string `yaml:"headers" toml:"headers"`
+}
+
+// webhook converts the file layout to a Webhook
+func (c webhookConfig) webhook() (Webhook, error) {
+	if c.URL == "" {
+		return Webhook{}, fmt.Errorf("missing url of webhook")
+	}
+	webhook := Webhook{URL: c.URL, Template: c.Template, Headers: c.Headers}
+	switch c.Preset {
+	case "":
+	case webhookPresetSlack:
+		if c.Template != "" {
+			return Webhook{}, fmt.Errorf("the %s webhook preset cannot have a template", c.Preset)
+		}
+		webhook.Template = SlackTemplate
+	default:
+		return Webhook{}, fmt.Errorf("unsupported webhook preset: %s", c.Preset)
+	}
+	for _, event := range c.Events {
+		switch NotificationEvent(event) {
+		case NotifyStarted, NotifySucceeded, NotifyFailed:
+			webhook.Events = append(webhook.Events, NotificationEvent(event))
+		default:
+			return Webhook{}, fmt.Errorf("unsupported webhook event: %s", event)
+		}
+	}
+	return webhook, nil
+}
+
+// LoadConfig loads the configuration from a gosmm.yaml (or .yml) or gosmm.toml file.
+// ${NAME} references in the string values of the file are replaced with the value of the environment variable
+// NAME once the file is parsed, so that secrets such as ${DB_PASSWORD} can be kept out of the file whatever
+// characters they hold. References in comments are ignored.
+// When path is empty, the configuration is read from the GOSMM_* environment variables instead.
+func LoadConfig(path string) (Config, error) {
+	if path == "" {
+		return configFromEnv(os.Environ())
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to read config file: %w", err)
+	}
+	content := string(data)
+
+	var file fileConfig
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewBufferString(content))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&file); err != nil {
+			return Config{}, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	case ".toml":
+		metadata, err := toml.Decode(content, &file)
+		if err != nil {
+			return Config{}, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		if undecoded := metadata.Undecoded(); len(undecoded) > 0 {
+			return Config{}, fmt.Errorf("failed to parse %s: unknown key %s", path, undecoded[0])
+		}
+	default:
+		return Config{}, fmt.Errorf("unsupported config file format: %s", path)
+	}
+	// the references are interpolated in the decoded values, so that the values of the variables are not parsed
+	if err := interpolateEnv(reflect.ValueOf(&file).Elem()); err != nil {
+		return Config{}, fmt.Errorf("failed to load %s: %w", path, err)
+	}
+	return file.config()
+}
+
+// config converts the file layout to a Config, applying the default directories
+func (f fileConfig) config() (Config, error) {
+	config := Config{
+		DB: DBConfig{
+			Driver:        f.Driver,
+			DSN:           f.DSN,
+			PrimaryDSN:    f.PrimaryDSN,
+			Host:          f.Host,
+			Port:          f.Port,
+			User:          f.User,
+			Password:      f.Password,
+			DBName:        f.DBName,
+			SSLMode:       f.SSLMode,
+			SSLRootCert:   f.SSLRootCert,
+			SSLCert:       f.SSLCert,
+			SSLKey:        f.SSLKey,
+			SSLServerName: f.SSLServerName,
+			Params:        f.Params,
+			Account:       f.Account,
+			Warehouse:     f.Warehouse,
+			Role:          f.Role,
+			MaxOpenConns:  f.MaxOpenConns,
+			MaxIdleConns:  f.MaxIdleConns,
+			Session:       SessionSettings(f.Session),
+		},
+		Migration: MigrationConfig{
+			MigrationsDir:   f.MigrationsDir,
+			MigrationsDirs:  f.MigrationsDirs,
+			FilenamePattern: filenamePattern(f.FilenamePattern),
+			Ignore:          f.Ignore,
+			NestedDirs:      f.NestedDirs,
+			SeedsDir:        f.SeedsDir,
+			Driver:          f.Driver,
+			Schema:          f.Schema,
+			Environment:     f.Environment,
+			Skip:            f.Skip,
+			Labels:          f.Labels,
+			AllowOutOfOrder: f.AllowOutOfOrder,
+			StrictOrdering:  f.StrictOrdering,
+			AllowClean:      f.AllowClean,
+			ResumeMode:      f.Resume,
+			Idempotent:      f.Idempotent,
+			Placeholders:    f.Placeholders,
+			TemplateData:    f.TemplateData,
+			SchemaFile:      f.SchemaFile,
+			ReportFile:      f.ReportFile,
+			Lint:            LintConfig{Disable: f.Lint.Disable, BigTableRows: f.Lint.BigTableRows},
+			ZeroDowntime:    f.ZeroDowntime,
+			VerifyIndexes:   f.VerifyIndexes,
+			Analyze:         f.Analyze,
+			Parallelism:     f.Parallelism,
+			AuditHost:       f.AuditHost,
+			Context:         f.Context,
+			StreamThreshold: f.StreamThreshold,
+			StableListings:  f.StableListings,
+		},
+		Tenants: TenantsConfig{Schemas: f.TenantSchemas, Query: f.TenantSchemasQuery},
+		Confirm: f.Confirm,
+	}
+	if f.Driver == "snowflake" {
+		// the schema of the history table is the default schema of the connection
+		config.DB.Schema = f.Schema
+	}
+	if f.MigrationsSource != "" {
+		source, err := parseMigrationsSource(f.MigrationsSource, f.AWSRegion, f.S3Endpoint)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid migrations_source: %w", err)
+		}
+		config.Migration.Source, config.Migration.SourceCacheDir = source, f.MigrationsSourceCache
+		if config.Migration.SourceCacheDir == "" {
+			config.Migration.SourceCacheDir = defaultSourceCacheDir(f.MigrationsSource)
+		}
+	}
+	if config.Migration.MigrationsDir == "" && len(config.Migration.MigrationsDirs) == 0 && config.Migration.Source == nil {
+		config.Migration.MigrationsDir = defaultMigrationsDir
+	}
+	if config.Migration.SeedsDir == "" {
+		config.Migration.SeedsDir = defaultSeedsDir
+	}
+	if config.Migration.Placeholders == nil {
+		config.Migration.Placeholders = make(map[string]string)
+	}
+
+	if f.RetryAttempts > 1 {
+		retry := &RetryPolicy{MaxAttempts: f.RetryAttempts}
+		if f.RetryBackoff != "" {
+			backoff, err := time.ParseDuration(f.RetryBackoff)
+			if err != nil {
+				return Config{}, fmt.Errorf("invalid retry_backoff: %w", err)
+			}
+			retry.InitialBackoff = backoff
+		}
+		config.DB.Retry, config.Migration.Retry = retry, retry
+	}
+
+	for name, value := range map[string]struct {
+		raw      string
+		duration *time.Duration
+	}{
+		"wait_for_lock":      {f.WaitForLock, &config.Migration.WaitForLock},
+		"lease":              {f.Lease, &config.Migration.Lease},
+		"lock_timeout":       {f.LockTimeout, &config.Migration.LockTimeout},
+		"statement_timeout":  {f.StatementTimeout, &config.Migration.StatementTimeout},
+		"conn_max_lifetime":  {f.ConnMaxLifetime, &config.DB.ConnMaxLifetime},
+		"conn_max_idle_time": {f.ConnMaxIdleTime, &config.DB.ConnMaxIdleTime},
+		"connect_timeout":    {f.ConnectTimeout, &config.DB.ConnectTimeout},
+		"listing_interval":   {f.ListingInterval, &config.Migration.ListingInterval},
+	} {
+		if value.raw == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(value.raw)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid %s: %w", name, err)
+		}
+		*value.duration = duration
+	}
+
+	if f.BackupCommand != "" {
+		config.Migration.Backup = &Backup{Command: f.BackupCommand}
+	}
+
+	approval, err := f.Approval.approval()
+	if err != nil {
+		return Config{}, err
+	}
+	config.Migration.Approval = approval
+
+	if len(f.SignatureKeys) > 0 {
+		signatures := &Signatures{}
+		for _, path := range f.SignatureKeys {
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return Config{}, fmt.Errorf("invalid signature_keys: %w", err)
+			}
+			keys, err := ParsePublicKeys(data)
+			if err != nil {
+				return Config{}, fmt.Errorf("invalid signature_keys: %s: %w", path, err)
+			}
+			signatures.PublicKeys = append(signatures.PublicKeys, keys...)
+		}
+		config.Migration.Signatures = signatures
+	}
+
+	checksum, err := f.Checksum.checksum()
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid checksum: %w", err)
+	}
+	config.Migration.Checksum = checksum
+
+	if f.LogLevel != "" {
+		level, err := ParseLogLevel(f.LogLevel)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid log_level: %w", err)
+		}
+		config.DB.LogLevel, config.Migration.LogLevel = level, level
+	}
+
+	for _, webhookConfig := range f.Webhooks {
+		webhook, err := webhookConfig.webhook()
+		if err != nil {
+			return Config{}, err
+		}
+		config.Migration.Webhooks = append(config.Migration.Webhooks, webhook)
+	}
+
+	switch f.PasswordProvider {
+	case "":
+	case passwordProviderSecretsManager:
+		if f.AWSSecretID == "" {
+			return Config{}, fmt.Errorf("missing aws_secret_id for the %s password provider", f.PasswordProvider)
+		}
+		config.DB.PasswordProvider = SecretsManagerPassword{SecretID: f.AWSSecretID, Region: f.AWSRegion}
+	case passwordProviderRDSIAM:
+		config.DB.PasswordProvider = RDSIAMAuth{Region: f.AWSRegion}
+	case passwordProviderVault:
+		if f.VaultRole == "" {
+			return Config{}, fmt.Errorf("missing vault_role for the %s password provider", f.PasswordProvider)
+		}
+		config.DB.PasswordProvider = &VaultCredentials{Role: f.VaultRole, Mount: f.VaultMount}
+	default:
+		return Config{}, fmt.Errorf("unsupported password provider: %s", f.PasswordProvider)
+	}
+
+	// the tool connects with the settings of the run, including its password provider
+	switch tool := OnlineSchemaChangeTool(f.OnlineSchemaChange.Tool); tool {
+	case "":
+	case GhOst, PtOnlineSchemaChange:
+		config.Migration.OnlineSchemaChange = &OnlineSchemaChange{Tool: tool, Path: f.OnlineSchemaChange.Path, DB: config.DB, Args: f.OnlineSchemaChange.Args}
+	default:
+		return Config{}, fmt.Errorf("unsupported online schema change tool: %s", tool)
+	}
+	return config, nil
+}
+
+// interpolateEnv replaces the ${NAME} references in the strings of the decoded value v with the environment
+// variables they name. A reference to an undefined variable is an error, so that a missing secret is not silently
+// replaced with "".
+func interpolateEnv(v reflect.Value) error {
+	var missing []string
+	interpolateValue(v, func(value string) string {
+		return envVarPattern.ReplaceAllStringFunc(value, func(reference string) string {
+			name := envVarPattern.FindStringSubmatch(reference)[1]
+			value, ok := os.LookupEnv(name)
+			if !ok {
+				missing = append(missing, name)
+			}
+			return value
+		})
+	})
+	if len(missing) > 0 {
+		return fmt.Errorf("undefined environment variable(s): %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// interpolateValue replaces the strings of v, at any depth of its structs, slices and maps, with their
+// replacement by interpolate
+func interpolateValue(v reflect.Value, interpolate func(string) string) {
+	switch v.Kind() {
+	case reflect.String:
+		if v.CanSet() {
+			v.SetString(interpolate(v.String()))
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			interpolateValue(v.Field(i), interpolate)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			interpolateValue(v.Index(i), interpolate)
+		}
+	case reflect.Ptr:
+		if !v.IsNil() {
+			interpolateValue(v.Elem(), interpolate)
+		}
+	case reflect.Map:
+		// map values are not addressable, each one is copied, interpolated and stored back
+		for _, key := range v.MapKeys() {
+			value := reflect.New(v.Type().Elem()).Elem()
+			value.Set(v.MapIndex(key))
+			interpolateValue(value, interpolate)
+			v.SetMapIndex(key, value)
+		}
+	case reflect.Interface:
+		if v.IsNil() {
+			return
+		}
+		// the value held by an interface is not addressable either, e.g. a string of TemplateData
+		value := reflect.New(v.Elem().Type()).Elem()
+		value.Set(v.Elem())
+		interpolateValue(value, interpolate)
+		if v.CanSet() {
+			v.Set(value)
+		}
+	}
+}
+
+// configFromEnv builds the configuration from the GOSMM_* variables in environ
+func configFromEnv(environ []string) (Config, error) {
+	env := make(map[string]string)
+	for _, kv := range environ {
+		if key, value, ok := strings.Cut(kv, "="); ok && strings.HasPrefix(key, envPrefix) {
+			env[strings.TrimPrefix(key, envPrefix)] = value
+		}
+	}
+
+	file := fileConfig{
+		Driver:             env["DRIVER"],
+		DSN:                env["DSN"],
+		PrimaryDSN:         env["PRIMARY_DSN"],
+		Host:               env["HOST"],
+		User:               env["USER"],
+		Password:           env["PASSWORD"],
+		DBName:             env["DBNAME"],
+		SSLMode:            env["SSL_MODE"],
+		SSLRootCert:        env["SSL_ROOT_CERT"],
+		SSLCert:            env["SSL_CERT"],
+		SSLKey:             env["SSL_KEY"],
+		SSLServerName:      env["SSL_SERVER_NAME"],
+		PasswordProvider:   env["PASSWORD_PROVIDER"],
+		AWSRegion:          env["AWS_REGION"],
+		AWSSecretID:        env["AWS_SECRET_ID"],
+		VaultRole:          env["VAULT_ROLE"],
+		VaultMount:         env["VAULT_MOUNT"],
+		SeedsDir:           env["SEEDS_DIR"],
+		FilenamePattern:    env["FILENAME_PATTERN"],
+		Schema:             env["SCHEMA"],
+		Environment:        env["ENVIRONMENT"],
+		Placeholders:       placeholdersFromEnv(environ),
+		RetryBackoff:       env["RETRY_BACKOFF"],
+		TenantSchemasQuery: env["TENANT_SCHEMAS_QUERY"],
+		WaitForLock:        env["WAIT_FOR_LOCK"],
+		Lease:              env["LEASE"],
+		LockTimeout:        env["LOCK_TIMEOUT"],
+		StatementTimeout:   env["STATEMENT_TIMEOUT"],
+		SchemaFile:         env["SCHEMA_FILE"],
+		ReportFile:         env["REPORT_FILE"],
+		BackupCommand:      env["BACKUP_COMMAND"],
+		Context:            env["CONTEXT"],
+		LogLevel:           env["LOG_LEVEL"],
+		OnlineSchemaChange: onlineFileConfig{Tool: env["ONLINE_SCHEMA_CHANGE_TOOL"], Path: env["ONLINE_SCHEMA_CHANGE_PATH"]},
+	}
+	for name, value := range map[string]*int{
+		"PORT":            &file.Port,
+		"RETRY_ATTEMPTS":  &file.RetryAttempts,
+		"PARALLELISM":     &file.Parallelism,
+		"MAX_OPEN_CONNS":  &file.MaxOpenConns,
+		"MAX_IDLE_CONNS":  &file.MaxIdleConns,
+		"STABLE_LISTINGS": &file.StableListings,
+	} {
+		if env[name] == "" {
+			continue
+		}
+		i, err := strconv.Atoi(env[name])
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid %s%s: %w", envPrefix, name, err)
+		}
+		*value = i
+	}
+	if url := env["WEBHOOK_URL"]; url != "" {
+		file.Webhooks = append(file.Webhooks, webhookConfig{URL: url})
+	}
+	if url := env["SLACK_WEBHOOK_URL"]; url != "" {
+		file.Webhooks = append(file.Webhooks, webhookConfig{URL: url, Preset: webhookPresetSlack})
+	}
+	if rules := env["LINT_DISABLE"]; rules != "" {
+		file.Lint.Disable = strings.Split(rules, ",")
+	}
+	file.Checksum.Algorithm = env["CHECKSUM_ALGORITHM"]
+	file.MigrationsSource, file.MigrationsSourceCache = env["MIGRATIONS_SOURCE"], env["MIGRATIONS_SOURCE_CACHE"]
+	file.S3Endpoint = env["S3_ENDPOINT"]
+	file.Account, file.Warehouse, file.Role = env["ACCOUNT"], env["WAREHOUSE"], env["ROLE"]
+	file.ConnMaxLifetime, file.ConnMaxIdleTime = env["CONN_MAX_LIFETIME"], env["CONN_MAX_IDLE_TIME"]
+	file.ConnectTimeout = env["CONNECT_TIMEOUT"]
+	file.ListingInterval = env["LISTING_INTERVAL"]
+	file.Session = sessionFileConfig{ApplicationName: env["APPLICATION_NAME"], SearchPath: env["SEARCH_PATH"],
+		SQLMode: env["SQL_MODE"], TimeZone: env["TIME_ZONE"]}
+	file.Approval = approvalFileConfig{URL: env["APPROVAL_URL"], PollInterval: env["APPROVAL_POLL_INTERVAL"],
+		Timeout: env["APPROVAL_TIMEOUT"]}
+	if token := env["APPROVAL_TOKEN"]; token != "" {
+		file.Approval.Headers = map[string]string{"Authorization": "Bearer " + token}
+	}
+	if environments := env["APPROVAL_ENVIRONMENTS"]; environments != "" {
+		file.Approval.Environments = strings.Split(environments, ",")
+	}
+	if keys := env["SIGNATURE_KEYS"]; keys != "" {
+		file.SignatureKeys = strings.Split(keys, ",")
+	}
+	if ignore := env["CHECKSUM_IGNORE"]; ignore != "" {
+		file.Checksum.Ignore = strings.Split(ignore, ",")
+	}
+	if rows := env["LINT_BIG_TABLE_ROWS"]; rows != "" {
+		n, err := strconv.ParseInt(rows, 10, 64)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid %sLINT_BIG_TABLE_ROWS: %w", envPrefix, err)
+		}
+		file.Lint.BigTableRows = n
+	}
+	if threshold := env["STREAM_THRESHOLD"]; threshold != "" {
+		n, err := strconv.ParseInt(threshold, 10, 64)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid %sSTREAM_THRESHOLD: %w", envPrefix, err)
+		}
+		file.StreamThreshold = n
+	}
+	if ignore := env["IGNORE"]; ignore != "" {
+		file.Ignore = strings.Split(ignore, ",")
+	}
+	if skip := env["SKIP"]; skip != "" {
+		file.Skip = strings.Split(skip, ",")
+	}
+	if labels := env["LABELS"]; labels != "" {
+		file.Labels = strings.Split(labels, ",")
+	}
+	if schemas := env["TENANT_SCHEMAS"]; schemas != "" {
+		file.TenantSchemas = strings.Split(schemas, ",")
+	}
+	// Several migrations directories are separated by commas, the first one being MigrationsDir
+	if dirs := env["MIGRATIONS_DIR"]; dirs != "" {
+		migrationsDirs := strings.Split(dirs, ",")
+		file.MigrationsDir, file.MigrationsDirs = migrationsDirs[0], migrationsDirs[1:]
+	}
+	for name, value := range map[string]*bool{
+		"ALLOW_OUT_OF_ORDER": &file.AllowOutOfOrder,
+		"STRICT_ORDERING":    &file.StrictOrdering,
+		"NESTED_DIRS":        &file.NestedDirs,
+		"ALLOW_CLEAN":        &file.AllowClean,
+		"RESUME":             &file.Resume,
+		"IDEMPOTENT":         &file.Idempotent,
+		"CONFIRM":            &file.Confirm,
+		"ZERO_DOWNTIME":      &file.ZeroDowntime,
+		"VERIFY_INDEXES":     &file.VerifyIndexes,
+		"ANALYZE":            &file.Analyze,
+		"AUDIT_HOST":         &file.AuditHost,
+	} {
+		if env[name] == "" {
+			continue
+		}
+		b, err := strconv.ParseBool(env[name])
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid %s%s: %w", envPrefix, name, err)
+		}
+		*value = b
+	}
+	return file.config()
+}
+
+// placeholdersFromEnv returns the placeholder values set through GOSMM_PLACEHOLDER_<NAME> variables
+func placeholdersFromEnv(environ []string) map[string]string {
+	placeholders := make(map[string]string)
+	for _, kv := range environ {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(key, placeholderEnvPrefix) {
+			continue
+		}
+		placeholders[strings.TrimPrefix(key, placeholderEnvPrefix)] = value
+	}
+	return placeholders
+}
+
+// filenamePattern returns the filename pattern of the configuration files, where "flyway" and "timestamp"
+// stand for FlywayFilenamePattern and TimestampFilenamePattern
+func filenamePattern(pattern string) string {
+	switch pattern {
+	case "flyway":
+		return FlywayFilenamePattern
+	case "timestamp":
+		return TimestampFilenamePattern
+	default:
+		return pattern
+	}
+}
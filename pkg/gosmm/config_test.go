@@ -0,0 +1,426 @@
+package gosmm
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlaceholdersFromEnv(t *testing.T) {
+	placeholders := placeholdersFromEnv([]string{
+		"GOSMM_PLACEHOLDER_schema=tenant_a",
+		"GOSMM_PLACEHOLDER_dsn=a=b",
+		"GOSMM_DRIVER=sqlite3",
+	})
+	assert.Equal(t, map[string]string{"schema": "tenant_a", "dsn": "a=b"}, placeholders)
+}
+
+func TestLoadConfigYAML(t *testing.T) {
+	os.Setenv("TEST_DB_PASSWORD", "s3cret")
+	defer os.Unsetenv("TEST_DB_PASSWORD")
+
+	path := filepath.Join(t.TempDir(), "gosmm.yaml")
+	content := `driver: postgres
+host: localhost
+port: 5432
+user: gosmm
+password: ${TEST_DB_PASSWORD}
+dbname: app
+ssl_mode: verify-full
+ssl_root_cert: /etc/ssl/ca.pem
+migrations_dirs:
+  - ./migrations
+  - ./billing/migrations
+schema: app
+allow_out_of_order: true
+placeholders:
+  tenant: tenant_a
+template_data:
+  shards: [eu, us]
+lint:
+  disable: [drop-column]
+  big_table_rows: 50000
+webhooks:
+  - url: https://hooks.slack.com/services/T0/B0/x
+    preset: slack
+    events: [failed]
+  - url: https://ops.example.com/hooks/gosmm
+    template: '{"message": {{json .Summary}}}'
+    headers:
+      Authorization: Bearer token
+`
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+
+	config, err := LoadConfig(path)
+	assert.NoError(t, err)
+	assert.Equal(t, DBConfig{Driver: "postgres", Host: "localhost", Port: 5432, User: "gosmm", Password: "s3cret", DBName: "app", SSLMode: "verify-full", SSLRootCert: "/etc/ssl/ca.pem"}, config.DB)
+	assert.Equal(t, []Webhook{
+		{URL: "https://hooks.slack.com/services/T0/B0/x", Template: SlackTemplate, Events: []NotificationEvent{NotifyFailed}},
+		{URL: "https://ops.example.com/hooks/gosmm", Template: `{"message": {{json .Summary}}}`, Headers: map[string]string{"Authorization": "Bearer token"}},
+	}, config.Migration.Webhooks)
+	assert.Equal(t, "", config.Migration.MigrationsDir)
+	assert.Equal(t, []string{"./migrations", "./billing/migrations"}, config.Migration.MigrationsDirs)
+	assert.Equal(t, "./seeds", config.Migration.SeedsDir)
+	assert.Equal(t, "postgres", config.Migration.Driver)
+	assert.Equal(t, "app", config.Migration.Schema)
+	assert.True(t, config.Migration.AllowOutOfOrder)
+	assert.Equal(t, map[string]string{"tenant": "tenant_a"}, config.Migration.Placeholders)
+	assert.Equal(t, map[string]interface{}{"shards": []interface{}{"eu", "us"}}, config.Migration.TemplateData)
+	assert.Equal(t, LintConfig{Disable: []string{"drop-column"}, BigTableRows: 50000}, config.Migration.Lint)
+}
+
+func TestLoadConfigTOML(t *testing.T) {
+	os.Setenv("TEST_DATABASE_URL", "postgres://gosmm@localhost/app")
+	defer os.Unsetenv("TEST_DATABASE_URL")
+
+	path := filepath.Join(t.TempDir(), "gosmm.toml")
+	content := `driver = "postgres"
+dsn = "${TEST_DATABASE_URL}"
+migrations_dir = "db/migrations"
+resume = true
+
+[[webhooks]]
+url = "https://hooks.slack.com/services/T0/B0/x"
+preset = "slack"
+`
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+
+	config, err := LoadConfig(path)
+	assert.NoError(t, err)
+	assert.Equal(t, DBConfig{Driver: "postgres", DSN: "postgres://gosmm@localhost/app"}, config.DB)
+	assert.Equal(t, "db/migrations", config.Migration.MigrationsDir)
+	assert.True(t, config.Migration.ResumeMode)
+	assert.Equal(t, []Webhook{SlackWebhook("https://hooks.slack.com/services/T0/B0/x")}, config.Migration.Webhooks)
+}
+
+func TestLoadConfigInterpolatesParsedValues(t *testing.T) {
+	// The values of the variables are not parsed, whatever characters they hold
+	secrets := []string{"a: b", "*star", `quote"back\slash`, "multi\nline", "# not a comment", "&anchor [x]"}
+	for _, secret := range secrets {
+		os.Setenv("TEST_DB_PASSWORD", secret)
+		for name, content := range map[string]string{
+			"gosmm.yaml": "# ${TEST_COMMENTED_VARIABLE} is not set\npassword: ${TEST_DB_PASSWORD}\nparams:\n  application_name: app-${TEST_DB_PASSWORD}\n",
+			"gosmm.toml": "# ${TEST_COMMENTED_VARIABLE} is not set\npassword = \"${TEST_DB_PASSWORD}\"\n[params]\napplication_name = \"app-${TEST_DB_PASSWORD}\"\n",
+		} {
+			path := filepath.Join(t.TempDir(), name)
+			if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatalf("Failed to create config file: %v", err)
+			}
+
+			config, err := LoadConfig(path)
+			if assert.NoError(t, err, "%s with %q", name, secret) {
+				assert.Equal(t, secret, config.DB.Password, name)
+				assert.Equal(t, map[string]string{"application_name": "app-" + secret}, config.DB.Params, name)
+			}
+		}
+	}
+	os.Unsetenv("TEST_DB_PASSWORD")
+
+	// Template data is interpolated at any depth
+	os.Setenv("TEST_SHARD", "eu")
+	defer os.Unsetenv("TEST_SHARD")
+	path := filepath.Join(t.TempDir(), "gosmm.yaml")
+	if err := ioutil.WriteFile(path, []byte("template_data:\n  shards:\n    - ${TEST_SHARD}\n    - us\n"), 0644); err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	config, err := LoadConfig(path)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"shards": []interface{}{"eu", "us"}}, config.Migration.TemplateData)
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"undefined.yaml": "password: ${TEST_UNDEFINED_VARIABLE}\n",
+		"unknown.yaml":   "drvier: postgres\n",
+		"unknown.toml":   "drvier = \"postgres\"\n",
+		"gosmm.json":     "{}",
+		"provider.yaml":  "password_provider: vault\n",
+		"secret.yaml":    "password_provider: aws-secrets-manager\n",
+		"vault.yaml":     "password_provider: vault\n",
+		"retry.yaml":     "retry_attempts: 3\nretry_backoff: soon\n",
+		"webhook.yaml":   "webhooks:\n  - preset: slack\n",
+		"preset.yaml":    "webhooks:\n  - url: https://example.com\n    preset: teams\n",
+		"event.yaml":     "webhooks:\n  - url: https://example.com\n    events: [finished]\n",
+		"lease.yaml":     "lease: forever\n",
+		"log.yaml":       "log_level: verbose\n",
+		"checksum.yaml":  "checksum:\n  algorithm: md5\n",
+		"ignore.yaml":    "checksum:\n  ignore: [tabs]\n",
+		"keys.yaml":      "signature_keys: [missing.pem]\n",
+		"source.yaml":    "migrations_source: gs://releases/app\n",
+		"bucket.yaml":    "migrations_source: s3:///app\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create config file: %v", err)
+		}
+		_, err := LoadConfig(path)
+		assert.Error(t, err, name)
+	}
+
+	_, err := LoadConfig(filepath.Join(dir, "missing.yaml"))
+	assert.Error(t, err)
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	config, err := configFromEnv([]string{
+		"GOSMM_DRIVER=mysql",
+		"GOSMM_DSN=gosmm:password@tcp(localhost:3306)/app",
+		"GOSMM_MIGRATIONS_DIR=./migrations,./billing/migrations",
+		"GOSMM_ALLOW_CLEAN=true",
+		"GOSMM_SSL_MODE=verify-ca",
+		"GOSMM_SSL_ROOT_CERT=/etc/ssl/ca.pem",
+		"GOSMM_PLACEHOLDER_schema=tenant_a",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, DBConfig{Driver: "mysql", DSN: "gosmm:password@tcp(localhost:3306)/app", SSLMode: "verify-ca", SSLRootCert: "/etc/ssl/ca.pem"}, config.DB)
+	assert.Equal(t, "./migrations", config.Migration.MigrationsDir)
+	assert.Equal(t, []string{"./billing/migrations"}, config.Migration.MigrationsDirs)
+	assert.True(t, config.Migration.AllowClean)
+	assert.Equal(t, map[string]string{"schema": "tenant_a"}, config.Migration.Placeholders)
+
+	config, err = configFromEnv([]string{"GOSMM_DRIVER=postgres", "GOSMM_DSN=postgres://replica/app", "GOSMM_PRIMARY_DSN=postgres://primary/app"})
+	assert.NoError(t, err)
+	assert.Equal(t, "postgres://primary/app", config.DB.PrimaryDSN)
+
+	config, err = configFromEnv([]string{"GOSMM_DRIVER=postgres", "GOSMM_BACKUP_COMMAND=pg_dump -f /backups/{{.Name}}.sql app"})
+	assert.NoError(t, err)
+	assert.Equal(t, &Backup{Command: "pg_dump -f /backups/{{.Name}}.sql app"}, config.Migration.Backup)
+
+	config, err = configFromEnv([]string{"GOSMM_LOG_LEVEL=debug"})
+	assert.NoError(t, err)
+	assert.Equal(t, LogDebug, config.Migration.LogLevel)
+	assert.Equal(t, LogDebug, config.DB.LogLevel)
+
+	// Defaults
+	config, err = configFromEnv(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "./migrations", config.Migration.MigrationsDir)
+	assert.Equal(t, "./seeds", config.Migration.SeedsDir)
+
+	// Password providers
+	config, err = configFromEnv([]string{"GOSMM_PASSWORD_PROVIDER=aws-secrets-manager", "GOSMM_AWS_SECRET_ID=prod/app/db", "GOSMM_AWS_REGION=eu-west-1"})
+	assert.NoError(t, err)
+	assert.Equal(t, SecretsManagerPassword{SecretID: "prod/app/db", Region: "eu-west-1"}, config.DB.PasswordProvider)
+	config, err = configFromEnv([]string{"GOSMM_PASSWORD_PROVIDER=rds-iam"})
+	assert.NoError(t, err)
+	assert.Equal(t, RDSIAMAuth{}, config.DB.PasswordProvider)
+	config, err = configFromEnv([]string{"GOSMM_PASSWORD_PROVIDER=vault", "GOSMM_VAULT_ROLE=migrator"})
+	assert.NoError(t, err)
+	assert.Equal(t, &VaultCredentials{Role: "migrator"}, config.DB.PasswordProvider)
+
+	// Tenant schemas
+	config, err = configFromEnv([]string{"GOSMM_TENANT_SCHEMAS=tenant_a,tenant_b", "GOSMM_TENANT_SCHEMAS_QUERY=SELECT schema_name FROM tenants"})
+	assert.NoError(t, err)
+	assert.Equal(t, TenantsConfig{Schemas: []string{"tenant_a", "tenant_b"}, Query: "SELECT schema_name FROM tenants"}, config.Tenants)
+
+	// Webhooks
+	config, err = configFromEnv([]string{"GOSMM_SLACK_WEBHOOK_URL=https://hooks.slack.com/services/T0/B0/x", "GOSMM_WEBHOOK_URL=https://ops.example.com/hooks/gosmm"})
+	assert.NoError(t, err)
+	assert.Equal(t, []Webhook{{URL: "https://ops.example.com/hooks/gosmm"}, SlackWebhook("https://hooks.slack.com/services/T0/B0/x")}, config.Migration.Webhooks)
+
+	// Confirmation of gosmm migrate
+	config, err = configFromEnv([]string{"GOSMM_CONFIRM=true"})
+	assert.NoError(t, err)
+	assert.True(t, config.Confirm)
+
+	// Retries apply to the connection and the migrations
+	config, err = configFromEnv([]string{"GOSMM_RETRY_ATTEMPTS=3", "GOSMM_RETRY_BACKOFF=2s"})
+	assert.NoError(t, err)
+	assert.Equal(t, &RetryPolicy{MaxAttempts: 3, InitialBackoff: 2 * time.Second}, config.DB.Retry)
+	assert.Equal(t, config.DB.Retry, config.Migration.Retry)
+
+	// Waiting for the migration lock
+	config, err = configFromEnv([]string{"GOSMM_WAIT_FOR_LOCK=5m", "GOSMM_LEASE=30s"})
+	assert.NoError(t, err)
+	assert.Equal(t, 5*time.Minute, config.Migration.WaitForLock)
+	assert.Equal(t, 30*time.Second, config.Migration.Lease)
+
+	// Lock and statement timeouts
+	config, err = configFromEnv([]string{"GOSMM_LOCK_TIMEOUT=5s", "GOSMM_STATEMENT_TIMEOUT=10m"})
+	assert.NoError(t, err)
+	assert.Equal(t, 5*time.Second, config.Migration.LockTimeout)
+	assert.Equal(t, 10*time.Minute, config.Migration.StatementTimeout)
+	_, err = configFromEnv([]string{"GOSMM_LOCK_TIMEOUT=5"})
+	assert.Error(t, err)
+
+	// Online schema changes connect like the run
+	config, err = configFromEnv([]string{"GOSMM_DRIVER=mysql", "GOSMM_DSN=root@tcp(localhost:3306)/app", "GOSMM_ONLINE_SCHEMA_CHANGE_TOOL=gh-ost", "GOSMM_ONLINE_SCHEMA_CHANGE_PATH=/usr/local/bin/gh-ost"})
+	assert.NoError(t, err)
+	assert.Equal(t, &OnlineSchemaChange{Tool: GhOst, Path: "/usr/local/bin/gh-ost", DB: config.DB}, config.Migration.OnlineSchemaChange)
+	_, err = configFromEnv([]string{"GOSMM_ONLINE_SCHEMA_CHANGE_TOOL=lhm"})
+	assert.EqualError(t, err, "unsupported online schema change tool: lhm")
+
+	// Checksums
+	config, err = configFromEnv([]string{"GOSMM_CHECKSUM_ALGORITHM=crc32", "GOSMM_CHECKSUM_IGNORE=line-endings,comments"})
+	assert.NoError(t, err)
+	assert.Equal(t, ChecksumConfig{Algorithm: ChecksumCRC32, IgnoreLineEndings: true, IgnoreComments: true}, config.Migration.Checksum)
+	_, err = configFromEnv([]string{"GOSMM_CHECKSUM_ALGORITHM=md5"})
+	assert.EqualError(t, err, "invalid checksum: unsupported checksum algorithm: md5")
+
+	// Signed migrations
+	publicKey, _ := generateSigningKey(t)
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	assert.NoError(t, err)
+	keyPath := filepath.Join(t.TempDir(), "release.pem")
+	if err := ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, err = configFromEnv([]string{"GOSMM_SIGNATURE_KEYS=" + keyPath})
+	assert.NoError(t, err)
+	assert.Equal(t, &Signatures{PublicKeys: []ed25519.PublicKey{publicKey}}, config.Migration.Signatures)
+
+	// Remote migrations source
+	config, err = configFromEnv([]string{"GOSMM_MIGRATIONS_SOURCE=s3://releases/app/1.4.0", "GOSMM_MIGRATIONS_SOURCE_CACHE=/var/cache/gosmm",
+		"GOSMM_S3_ENDPOINT=http://localhost:9000", "GOSMM_AWS_REGION=eu-west-1"})
+	assert.NoError(t, err)
+	assert.Equal(t, S3Source{Bucket: "releases", Prefix: "app/1.4.0", Region: "eu-west-1", Endpoint: "http://localhost:9000"}, config.Migration.Source)
+	assert.Equal(t, "/var/cache/gosmm", config.Migration.SourceCacheDir)
+	assert.Equal(t, "", config.Migration.MigrationsDir)
+	config, err = configFromEnv([]string{"GOSMM_MIGRATIONS_SOURCE=s3://releases"})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, config.Migration.SourceCacheDir)
+
+	// Snowflake
+	config, err = configFromEnv([]string{"GOSMM_DRIVER=snowflake", "GOSMM_ACCOUNT=myorg-analytics", "GOSMM_USER=gosmm", "GOSMM_PASSWORD=secret",
+		"GOSMM_DBNAME=ANALYTICS", "GOSMM_SCHEMA=MARTS", "GOSMM_WAREHOUSE=MIGRATIONS_WH", "GOSMM_ROLE=TRANSFORMER"})
+	assert.NoError(t, err)
+	assert.Equal(t, DBConfig{Driver: "snowflake", User: "gosmm", Password: "secret", DBName: "ANALYTICS", Account: "myorg-analytics",
+		Warehouse: "MIGRATIONS_WH", Role: "TRANSFORMER", Schema: "MARTS"}, config.DB)
+	assert.Equal(t, "MARTS", config.Migration.Schema)
+
+	// Connection pool and session settings
+	config, err = configFromEnv([]string{"GOSMM_DRIVER=postgres", "GOSMM_MAX_OPEN_CONNS=4", "GOSMM_CONN_MAX_LIFETIME=5m", "GOSMM_CONNECT_TIMEOUT=10s",
+		"GOSMM_SEARCH_PATH=app, public", "GOSMM_TIME_ZONE=UTC"})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, config.DB.MaxOpenConns)
+	assert.Equal(t, 5*time.Minute, config.DB.ConnMaxLifetime)
+	assert.Equal(t, 10*time.Second, config.DB.ConnectTimeout)
+	assert.Equal(t, SessionSettings{SearchPath: "app, public", TimeZone: "UTC"}, config.DB.Session)
+	_, err = configFromEnv([]string{"GOSMM_CONN_MAX_IDLE_TIME=soon"})
+	assert.Error(t, err)
+
+	// Streaming large migration files
+	config, err = configFromEnv([]string{"GOSMM_DRIVER=postgres", "GOSMM_STREAM_THRESHOLD=104857600"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(100<<20), config.Migration.StreamThreshold)
+	_, err = configFromEnv([]string{"GOSMM_STREAM_THRESHOLD=100MB"})
+	assert.Error(t, err)
+
+	// Network filesystems
+	config, err = configFromEnv([]string{"GOSMM_STABLE_LISTINGS=3", "GOSMM_LISTING_INTERVAL=200ms"})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, config.Migration.StableListings)
+	assert.Equal(t, 200*time.Millisecond, config.Migration.ListingInterval)
+	_, err = configFromEnv([]string{"GOSMM_LISTING_INTERVAL=soon"})
+	assert.Error(t, err)
+
+	// Idempotency assist
+	config, err = configFromEnv([]string{"GOSMM_IDEMPOTENT=true"})
+	assert.NoError(t, err)
+	assert.True(t, config.Migration.Idempotent)
+
+	// Audit columns
+	config, err = configFromEnv([]string{"GOSMM_AUDIT_HOST=true", "GOSMM_CONTEXT=CHG-1234"})
+	assert.NoError(t, err)
+	assert.True(t, config.Migration.AuditHost)
+	assert.Equal(t, "CHG-1234", config.Migration.Context)
+	_, err = configFromEnv([]string{"GOSMM_AUDIT_HOST=sometimes"})
+	assert.Error(t, err)
+
+	// Zero-downtime mode
+	config, err = configFromEnv([]string{"GOSMM_ZERO_DOWNTIME=true"})
+	assert.NoError(t, err)
+	assert.True(t, config.Migration.ZeroDowntime)
+
+	// Index verification
+	config, err = configFromEnv([]string{"GOSMM_VERIFY_INDEXES=true"})
+	assert.NoError(t, err)
+	assert.True(t, config.Migration.VerifyIndexes)
+
+	// Statistics refresh
+	config, err = configFromEnv([]string{"GOSMM_ANALYZE=true"})
+	assert.NoError(t, err)
+	assert.True(t, config.Migration.Analyze)
+
+	// Lint rules
+	config, err = configFromEnv([]string{"GOSMM_LINT_DISABLE=drop-column,concurrent-index", "GOSMM_LINT_BIG_TABLE_ROWS=1000"})
+	assert.NoError(t, err)
+	assert.Equal(t, LintConfig{Disable: []string{"drop-column", "concurrent-index"}, BigTableRows: 1000}, config.Migration.Lint)
+	_, err = configFromEnv([]string{"GOSMM_LINT_BIG_TABLE_ROWS=many"})
+	assert.Error(t, err)
+
+	// Schema snapshots
+	config, err = configFromEnv([]string{"GOSMM_SCHEMA_FILE=schema.sql"})
+	assert.NoError(t, err)
+	assert.Equal(t, "schema.sql", config.Migration.SchemaFile)
+
+	// Run reports
+	config, err = configFromEnv([]string{"GOSMM_REPORT_FILE=report.html"})
+	assert.NoError(t, err)
+	assert.Equal(t, "report.html", config.Migration.ReportFile)
+
+	// Change approvals
+	config, err = configFromEnv([]string{"GOSMM_APPROVAL_URL=https://changes.example.com/approvals", "GOSMM_APPROVAL_TOKEN=secret",
+		"GOSMM_APPROVAL_ENVIRONMENTS=production", "GOSMM_APPROVAL_TIMEOUT=1h"})
+	assert.NoError(t, err)
+	assert.Equal(t, &Approval{URL: "https://changes.example.com/approvals", Headers: map[string]string{"Authorization": "Bearer secret"},
+		Environments: []string{"production"}, Timeout: time.Hour}, config.Migration.Approval)
+	_, err = configFromEnv([]string{"GOSMM_APPROVAL_URL=https://changes.example.com/approvals", "GOSMM_APPROVAL_POLL_INTERVAL=often"})
+	assert.EqualError(t, err, `invalid approval poll_interval: time: invalid duration "often"`)
+
+	// Strict ordering
+	config, err = configFromEnv([]string{"GOSMM_STRICT_ORDERING=true"})
+	assert.NoError(t, err)
+	assert.True(t, config.Migration.StrictOrdering)
+
+	// Filename pattern
+	config, err = configFromEnv([]string{"GOSMM_FILENAME_PATTERN=flyway"})
+	assert.NoError(t, err)
+	assert.Equal(t, FlywayFilenamePattern, config.Migration.FilenamePattern)
+	config, err = configFromEnv([]string{`GOSMM_FILENAME_PATTERN=^(?P<version>\d+)-.+\.sql$`})
+	assert.NoError(t, err)
+	assert.Equal(t, `^(?P<version>\d+)-.+\.sql$`, config.Migration.FilenamePattern)
+
+	// Parallel migrations
+	config, err = configFromEnv([]string{"GOSMM_PARALLELISM=4"})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, config.Migration.Parallelism)
+
+	// Skipped migrations
+	config, err = configFromEnv([]string{"GOSMM_SKIP=v20230101_create_fdw_00001.sql,v20230102_seed_users_00002"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"v20230101_create_fdw_00001.sql", "v20230102_seed_users_00002"}, config.Migration.Skip)
+
+	// Nested directories
+	config, err = configFromEnv([]string{"GOSMM_NESTED_DIRS=true"})
+	assert.NoError(t, err)
+	assert.True(t, config.Migration.NestedDirs)
+
+	// Ignored files
+	config, err = configFromEnv([]string{"GOSMM_IGNORE=*.md,archive/**"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"*.md", "archive/**"}, config.Migration.Ignore)
+
+	// Labels
+	config, err = configFromEnv([]string{"GOSMM_LABELS=billing,critical"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"billing", "critical"}, config.Migration.Labels)
+
+	_, err = configFromEnv([]string{"GOSMM_RESUME=maybe"})
+	assert.Error(t, err)
+	_, err = configFromEnv([]string{"GOSMM_PORT=abc"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,111 @@
+package gosmm
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// passwordRefreshMargin is how long before its expiry a provided password is fetched again,
+// so that a connection is never opened with a password about to expire
+const passwordRefreshMargin = time.Minute
+
+// PasswordProvider provides the database password at connect time, instead of a static DBConfig.Password.
+// See SecretsManagerPassword and RDSIAMAuth.
+type PasswordProvider interface {
+	// Password returns the password of the connection described by config and when it expires.
+	// A zero expiry means the password does not expire.
+	Password(ctx context.Context, config DBConfig) (password string, expiresAt time.Time, err error)
+}
+
+// CredentialsProvider is implemented by the PasswordProviders that also provide the user,
+// such as the dynamic credentials of VaultCredentials. The user replaces DBConfig.User.
+type CredentialsProvider interface {
+	// Credentials returns the user and password of the connection described by config and when they expire
+	Credentials(ctx context.Context, config DBConfig) (user string, password string, expiresAt time.Time, err error)
+}
+
+// providerConnector is a driver.Connector fetching the password from a PasswordProvider,
+// again when the previous one expired, before opening each connection
+type providerConnector struct {
+	driver driver.Driver
+	config DBConfig
+
+	mu        sync.Mutex
+	dsn       string
+	expiresAt time.Time
+}
+
+// openWithPasswordProvider opens a database whose connections use the password of config.PasswordProvider
+func openWithPasswordProvider(config DBConfig) (*sql.DB, error) {
+	// the driver is looked up through a database that is never connected
+	db, err := sql.Open(config.Driver, "")
+	if err != nil {
+		return nil, err
+	}
+	d := db.Driver()
+	if err := db.Close(); err != nil {
+		return nil, err
+	}
+	return sql.OpenDB(&providerConnector{driver: d, config: config}), nil
+}
+
+// Connect implements driver.Connector
+func (c *providerConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	dsn, err := c.currentDSN(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if driverContext, ok := c.driver.(driver.DriverContext); ok {
+		connector, err := driverContext.OpenConnector(dsn)
+		if err != nil {
+			return nil, err
+		}
+		return connector.Connect(ctx)
+	}
+	return c.driver.Open(dsn)
+}
+
+// Driver implements driver.Connector
+func (c *providerConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// Close implements io.Closer, called by sql.DB.Close, closing the PasswordProvider when it is an io.Closer
+func (c *providerConnector) Close() error {
+	if closer, ok := c.config.PasswordProvider.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// currentDSN returns the DSN with the current password, fetching a new one when it expired
+func (c *providerConnector) currentDSN(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.dsn != "" && (c.expiresAt.IsZero() || time.Now().Add(passwordRefreshMargin).Before(c.expiresAt)) {
+		return c.dsn, nil
+	}
+	config := c.config
+	var expiresAt time.Time
+	var err error
+	if provider, ok := c.config.PasswordProvider.(CredentialsProvider); ok {
+		config.User, config.Password, expiresAt, err = provider.Credentials(ctx, c.config)
+	} else {
+		config.Password, expiresAt, err = c.config.PasswordProvider.Password(ctx, c.config)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get database credentials: %w", err)
+	}
+	dsn, err := buildDSN(config)
+	if err != nil {
+		return "", err
+	}
+	c.dsn, c.expiresAt = dsn, expiresAt
+	return dsn, nil
+}
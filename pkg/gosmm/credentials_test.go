@@ -0,0 +1,69 @@
+package gosmm
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingPasswordProvider provides passwords expiring after ttl and counts the calls
+type countingPasswordProvider struct {
+	calls int
+	ttl   time.Duration
+	err   error
+}
+
+func (p *countingPasswordProvider) Password(context.Context, DBConfig) (string, time.Time, error) {
+	p.calls++
+	if p.ttl == 0 {
+		return "password", time.Time{}, p.err
+	}
+	return "password", time.Now().Add(p.ttl), p.err
+}
+
+func TestConnectDBWithPasswordProvider(t *testing.T) {
+	provider := &countingPasswordProvider{}
+	config := DBConfig{Driver: "sqlite3", Host: "localhost", Port: 1, User: "app", DBName: filepath.Join(t.TempDir(), "test.db"), PasswordProvider: provider}
+
+	db, err := Connect(config)
+	assert.NoError(t, err)
+	defer db.Close()
+	assert.Equal(t, 1, provider.calls)
+
+	config.DSN = "file:test.db"
+	_, err = ConnectDB(config)
+	assert.Error(t, err)
+}
+
+func TestProviderConnectorRefresh(t *testing.T) {
+	config := DBConfig{Driver: "sqlite3", DBName: ":memory:"}
+
+	// A password that does not expire is fetched once
+	provider := &countingPasswordProvider{}
+	config.PasswordProvider = provider
+	connector := &providerConnector{config: config}
+	for i := 0; i < 2; i++ {
+		_, err := connector.currentDSN(context.Background())
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 1, provider.calls)
+
+	// A password expiring within the refresh margin is fetched again
+	provider = &countingPasswordProvider{ttl: 30 * time.Second}
+	config.PasswordProvider = provider
+	connector = &providerConnector{config: config}
+	for i := 0; i < 2; i++ {
+		_, err := connector.currentDSN(context.Background())
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 2, provider.calls)
+
+	provider = &countingPasswordProvider{err: errors.New("denied")}
+	config.PasswordProvider = provider
+	_, err := (&providerConnector{config: config}).currentDSN(context.Background())
+	assert.Error(t, err)
+}
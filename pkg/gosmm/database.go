@@ -3,36 +3,116 @@ package gosmm
 import (
 	"database/sql"
 	"fmt"
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
+	_ "github.com/microsoft/go-mssqldb"
+	"math"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // DBConfig holds the database configuration information
 type DBConfig struct {
-	Driver   string
+	Driver string
+	// DSN is the data source name passed to the driver as is. When set, the other fields are ignored.
+	DSN      string
 	Host     string
 	Port     int
 	User     string
 	Password string
 	DBName   string
+	// SSLMode is the TLS mode of the connection: SSLModeDisable, SSLModeRequire, SSLModeVerifyCA or SSLModeVerifyFull.
+	// When empty, postgres connections do not use TLS and the other drivers use their default.
+	SSLMode string
+	// SSLRootCert is the path of the CA certificate verifying the server certificate (system roots when empty)
+	SSLRootCert string
+	// SSLCert and SSLKey are the paths of the client certificate and its key
+	SSLCert string
+	SSLKey  string
+	// SSLServerName is the name verified against the server certificate when it differs from Host
+	// (mysql and sqlserver only)
+	SSLServerName string
+	// Params holds additional driver parameters added to the DSN built from the fields above,
+	// e.g. {"sslmode": "require"} for postgres or {"tls": "true"} for mysql
+	Params map[string]string
+	// PasswordProvider provides the password when each connection is opened, instead of Password
+	PasswordProvider PasswordProvider
+	// Retry retries the initial connection of Connect when it fails with a transient error
+	Retry *RetryPolicy
+	// PrimaryDSN is the data source name of the primary, connected to by Connect instead when the node
+	// described by the other fields is a read replica, e.g. one handed out by a load balancer
+	PrimaryDSN string
+	// LogLevel is the verbosity of the messages of Connect, LogInfo when empty
+	LogLevel LogLevel
+
+	// Account is the Snowflake account identifier, e.g. myorg-myaccount, connected to instead of Host and Port
+	Account string
+	// Warehouse is the Snowflake warehouse running the migrations
+	Warehouse string
+	// Role is the Snowflake role the migrations run as, the default role of the user when empty
+	Role string
+	// Schema is the default schema of the Snowflake connection, where the migrations create unqualified objects
+	Schema string
+
+	// MaxOpenConns, MaxIdleConns, ConnMaxLifetime and ConnMaxIdleTime tune the connection pool, see the sql.DB
+	// methods of the same names. The defaults of database/sql are kept when zero.
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+	// ConnectTimeout bounds the opening of each connection (postgres, mysql and sqlserver), unbounded when zero
+	ConnectTimeout time.Duration
+	// Session holds the settings of the sessions, set when each connection is opened so that the migrations
+	// do not depend on the defaults of the server. They are parameters of the DSN built from the fields above,
+	// ignored with DSN.
+	Session SessionSettings
 }
 
+// SessionSettings holds the settings of the database sessions, see DBConfig.Session
+type SessionSettings struct {
+	// ApplicationName names the connections in the session list of the server, e.g. pg_stat_activity,
+	// "gosmm" when empty (postgres and sqlserver)
+	ApplicationName string
+	// SearchPath is the search_path of the sessions, e.g. "app, public" (postgres)
+	SearchPath string
+	// SQLMode is the sql_mode of the sessions, e.g. TRADITIONAL (mysql)
+	SQLMode string
+	// TimeZone is the time zone of the sessions, e.g. UTC (postgres and mysql)
+	TimeZone string
+}
+
+// defaultApplicationName is the application name of the sessions when SessionSettings.ApplicationName is empty
+const defaultApplicationName = "gosmm"
+
 // Validate validates the DBConfig
 func validateDBConfig(config *DBConfig) error {
 	if config.Driver == "" {
 		return fmt.Errorf("missing driver")
 	}
-	if config.Host == "" {
-		return fmt.Errorf("missing host")
+	if config.DSN != "" {
+		return nil
 	}
-	if config.Port == 0 || config.Port > 65535 {
-		return fmt.Errorf("invalid port")
+	if config.Driver == "snowflake" {
+		if config.Account == "" {
+			return fmt.Errorf("missing account")
+		}
+	} else {
+		if config.Host == "" {
+			return fmt.Errorf("missing host")
+		}
+		if config.Port == 0 || config.Port > 65535 {
+			return fmt.Errorf("invalid port")
+		}
 	}
-	if config.User == "" {
+	if _, ok := config.PasswordProvider.(CredentialsProvider); config.User == "" && !ok {
 		return fmt.Errorf("missing user")
 	}
-	if config.Password == "" {
+	if config.Password == "" && config.PasswordProvider == nil {
 		return fmt.Errorf("missing password")
 	}
 	if config.DBName == "" {
@@ -41,23 +121,36 @@ func validateDBConfig(config *DBConfig) error {
 	return nil
 }
 
-// ConnectDB connects to the database based on the given DBConfig
+// ConnectDB connects to the database based on the given DBConfig.
+// The connection is not checked until it is used, see Connect.
+// With a PasswordProvider, the password is fetched when a connection is opened and it has expired.
 func ConnectDB(config DBConfig) (*sql.DB, error) {
 	err := validateDBConfig(&config)
 	if err != nil {
 		return nil, err
 	}
-	var dsn string
-	switch config.Driver {
-	case "postgres":
-		dsn = fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
-			config.Host, config.Port, config.User, config.Password, config.DBName)
-	case "mysql":
-		dsn = fmt.Sprintf("%s:%s@tcp(%s:%d)/%s",
-			config.User, config.Password, config.Host, config.Port, config.DBName)
-	case "sqlite3":
-		dsn = config.DBName // for SQLite, DBName is the file path
-	default:
+	if config.PasswordProvider != nil {
+		if config.DSN != "" {
+			return nil, fmt.Errorf("a password provider cannot be used with a DSN")
+		}
+		if !isSupportedDriver(config.Driver) {
+			return nil, fmt.Errorf("unsupported driver: %s", config.Driver)
+		}
+		db, err := openWithPasswordProvider(config)
+		if err != nil {
+			return nil, err
+		}
+		configurePool(db, config)
+		return db, nil
+	}
+
+	dsn := config.DSN
+	if dsn == "" {
+		dsn, err = buildDSN(config)
+		if err != nil {
+			return nil, err
+		}
+	} else if !isSupportedDriver(config.Driver) {
 		return nil, fmt.Errorf("unsupported driver: %s", config.Driver)
 	}
 
@@ -65,10 +158,236 @@ func ConnectDB(config DBConfig) (*sql.DB, error) {
 	if err != nil {
 		return nil, err
 	}
+	configurePool(db, config)
 
 	return db, nil
 }
 
+// configurePool applies the pool settings of config to db
+func configurePool(db *sql.DB, config DBConfig) {
+	if config.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(config.MaxOpenConns)
+	}
+	if config.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(config.MaxIdleConns)
+	}
+	if config.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(config.ConnMaxLifetime)
+	}
+	if config.ConnMaxIdleTime > 0 {
+		db.SetConnMaxIdleTime(config.ConnMaxIdleTime)
+	}
+}
+
+// Connect connects to the database based on the given DBConfig and checks that it is reachable,
+// retrying as config.Retry allows. With a PrimaryDSN, a read replica is left for the primary.
+func Connect(config DBConfig) (*sql.DB, error) {
+	db, err := ConnectDB(config)
+	if err != nil {
+		return nil, err
+	}
+	for attempt := 1; ; attempt++ {
+		err := db.Ping()
+		if err == nil {
+			if config.PrimaryDSN != "" {
+				return followPrimary(db, config)
+			}
+			return db, nil
+		}
+		if !config.Retry.allows(attempt, err) {
+			db.Close()
+			return nil, fmt.Errorf("failed to connect to database: %w", err)
+		}
+		config.LogLevel.printf(LogWarn, "RETRY connection (attempt %d): %v\n", attempt+1, err)
+		time.Sleep(config.Retry.backoff(attempt))
+	}
+}
+
+// followPrimary returns db, or a connection to config.PrimaryDSN when db is read-only
+func followPrimary(db *sql.DB, config DBConfig) (*sql.DB, error) {
+	cockroach, err := isCockroachDB(db, config.Driver)
+	if err == nil {
+		var reason string
+		if reason, err = readOnlyReason(db, config.Driver, cockroach); err == nil && reason == "" {
+			return db, nil
+		}
+		if err == nil {
+			config.LogLevel.printf(LogInfo, "Connecting to the primary: %s\n", reason)
+		}
+	}
+	db.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to check whether the database is read-only: %w", err)
+	}
+	// the credentials of the primary are those of its DSN
+	primary := DBConfig{Driver: config.Driver, DSN: config.PrimaryDSN, Retry: config.Retry, LogLevel: config.LogLevel,
+		MaxOpenConns: config.MaxOpenConns, MaxIdleConns: config.MaxIdleConns,
+		ConnMaxLifetime: config.ConnMaxLifetime, ConnMaxIdleTime: config.ConnMaxIdleTime}
+	return Connect(primary)
+}
+
+// WithConnection connects to the database with Connect, calls fn and closes the connection,
+// for callers that leave the connection lifecycle to gosmm
+func WithConnection(config DBConfig, fn func(db *sql.DB) error) (err error) {
+	db, err := Connect(config)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := db.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("failed to close database: %w", closeErr)
+		}
+	}()
+	return fn(db)
+}
+
+// MigrateDB connects to the database described by dbConfig, executes the migrations and closes
+// the connection. When config.Driver is empty, the driver of dbConfig is used.
+func MigrateDB(dbConfig DBConfig, config MigrationConfig) error {
+	if config.Driver == "" {
+		config.Driver = dbConfig.Driver
+	}
+	return WithConnection(dbConfig, func(db *sql.DB) error {
+		return MigrateWithConfig(db, config)
+	})
+}
+
+// buildDSN builds the data source name of the driver from the connection settings.
+// The TLS settings, the session settings and Params are added as driver parameters, Params taking precedence.
+func buildDSN(config DBConfig) (string, error) {
+	params, err := tlsParams(config)
+	if err != nil {
+		return "", err
+	}
+	if params == nil {
+		params = make(map[string]string)
+	}
+	for key, value := range sessionParams(config) {
+		params[key] = value
+	}
+	for key, value := range config.Params {
+		params[key] = value
+	}
+
+	switch config.Driver {
+	case "postgres":
+		params["host"] = config.Host
+		params["port"] = strconv.Itoa(config.Port)
+		params["user"] = config.User
+		params["password"] = config.Password
+		params["dbname"] = config.DBName
+		keys := make([]string, 0, len(params))
+		for key := range params {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		pairs := make([]string, 0, len(keys))
+		for _, key := range keys {
+			pairs = append(pairs, key+"="+quotePostgresValue(params[key]))
+		}
+		return strings.Join(pairs, " "), nil
+	case "mysql":
+		mysqlConfig := mysql.NewConfig()
+		mysqlConfig.User = config.User
+		mysqlConfig.Passwd = config.Password
+		mysqlConfig.Net = "tcp"
+		mysqlConfig.Addr = net.JoinHostPort(config.Host, strconv.Itoa(config.Port))
+		mysqlConfig.DBName = config.DBName
+		mysqlConfig.Timeout = config.ConnectTimeout
+		if tlsName, ok := params["tls"]; ok {
+			// FormatDSN writes the TLS setting from TLSConfig, not Params
+			mysqlConfig.TLSConfig = tlsName
+			delete(params, "tls")
+		}
+		if len(params) > 0 {
+			mysqlConfig.Params = params
+		}
+		return mysqlConfig.FormatDSN(), nil
+	case "sqlite3":
+		// for SQLite, DBName is the file path
+		if len(params) == 0 {
+			return config.DBName, nil
+		}
+		return "file:" + config.DBName + "?" + encodeParams(params), nil
+	case "sqlserver":
+		query := url.Values{"database": {config.DBName}}
+		for key, value := range params {
+			query.Set(key, value)
+		}
+		return (&url.URL{
+			Scheme:   "sqlserver",
+			User:     url.UserPassword(config.User, config.Password),
+			Host:     net.JoinHostPort(config.Host, strconv.Itoa(config.Port)),
+			RawQuery: query.Encode(),
+		}).String(), nil
+	case "snowflake":
+		return snowflakeDSN(config, params), nil
+	default:
+		return "", fmt.Errorf("unsupported driver: %s", config.Driver)
+	}
+}
+
+// sessionParams returns the driver parameters applying the session settings and the connect timeout of config
+func sessionParams(config DBConfig) map[string]string {
+	session := config.Session
+	applicationName := session.ApplicationName
+	if applicationName == "" {
+		applicationName = defaultApplicationName
+	}
+	// the timeouts of the drivers are in seconds, rounded up so that a short timeout doesn't disable it
+	timeout := ""
+	if config.ConnectTimeout > 0 {
+		timeout = strconv.Itoa(int(math.Ceil(config.ConnectTimeout.Seconds())))
+	}
+
+	params := make(map[string]string)
+	set := func(key, value string) {
+		if value != "" {
+			params[key] = value
+		}
+	}
+	switch config.Driver {
+	case "postgres":
+		// lib/pq sends the parameters it doesn't know to the server as run-time parameters
+		set("application_name", applicationName)
+		set("search_path", session.SearchPath)
+		set("timezone", session.TimeZone)
+		set("connect_timeout", timeout)
+	case "mysql":
+		// go-sql-driver/mysql sets the parameters it doesn't know as system variables with SET name=value
+		quote := func(value string) string {
+			if value == "" {
+				return ""
+			}
+			return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+		}
+		set("sql_mode", quote(session.SQLMode))
+		set("time_zone", quote(session.TimeZone))
+	case "sqlserver":
+		set("app name", applicationName)
+		set("connection timeout", timeout)
+	}
+	return params
+}
+
+// quotePostgresValue quotes a keyword/value connection string value when it is empty or contains
+// spaces, quotes or backslashes
+func quotePostgresValue(value string) string {
+	if value != "" && !strings.ContainsAny(value, ` '\`) {
+		return value
+	}
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
+
+// encodeParams encodes params as a URL query in key order
+func encodeParams(params map[string]string) string {
+	values := url.Values{}
+	for key, value := range params {
+		values.Set(key, value)
+	}
+	return values.Encode()
+}
+
 // CloseDB closes the database connection
 func CloseDB(db *sql.DB) error {
 	return db.Close()
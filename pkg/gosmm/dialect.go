@@ -0,0 +1,113 @@
+package gosmm
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Dialect adds support for a database to gosmm, e.g. DuckDB or Firebird, without changes to gosmm itself.
+// A dialect is registered with RegisterDialect under the name of its database/sql driver, which is then used as
+// the driver of the configs. The connections of a registered dialect are given by a DSN.
+type Dialect interface {
+	// HistoryTableDDL returns the statement creating the history table if it doesn't exist. The table holds the
+	// columns installed_rank, filename, installed_on, execution_time, success, checksum, failed_statement,
+	// committed_statements, author, ticket, description, backup, applied_by, context, rows_affected, approval and ahead.
+	HistoryTableDDL(table string) string
+	// HistoryVersionTableDDL returns the statement creating the table recording the versions of the history
+	// table if it doesn't exist, with the columns version (an integer primary key) and upgraded_on (a timestamp)
+	HistoryVersionTableDDL(table string) string
+	// LockTableDDL returns the statement creating the lock table holding the leases of MigrationConfig.Lease if
+	// it doesn't exist, with the columns lock_name (a string primary key), owner (a string) and expires_at
+	// (a 64-bit integer)
+	LockTableDDL(table string) string
+	// Lock takes a database level lock named after the history table, so that concurrent runs do not apply
+	// migrations twice, and returns a function releasing it. It fails with ErrLockTimeout when the lock was not
+	// granted within wait, unless wait is zero. Databases without locks return a no-op function.
+	Lock(ctx context.Context, db *sql.DB, table string, wait time.Duration) (func() error, error)
+	// QuoteIdentifier quotes an identifier such as a schema name
+	QuoteIdentifier(name string) string
+	// BindParam returns the placeholder of the i-th (1-based) parameter of a statement
+	BindParam(i int) string
+	// TransactionalDDL reports whether DDL statements can be rolled back. Without transactional DDL, the DDL
+	// statements are taken to commit implicitly, like those of MySQL, and the migrations are recorded as failed
+	// before they start so that a crash does not leave them applied but unrecorded.
+	TransactionalDDL() bool
+	// SplitStatements splits the content of a migration file into the statements executed one by one
+	SplitStatements(sql string) []string
+}
+
+// nonTransactionalDialect is implemented by the built-in dialects of the databases rejecting DDL in
+// transactions, whose migrations are applied as with a "gosmm:transactional false" header
+type nonTransactionalDialect interface {
+	nonTransactional()
+}
+
+// numericBooleanDialect is implemented by the built-in dialects of the databases without a boolean type,
+// whose history table holds the success of the migrations as 1 or 0
+type numericBooleanDialect interface {
+	numericBooleans()
+}
+
+// externalHistoryDialect is implemented by the built-in dialects of the engines unable to host the history
+// table, whose history is kept in MigrationConfig.HistoryDB
+type externalHistoryDialect interface {
+	externalHistory()
+}
+
+// batchingDialect is implemented by the built-in dialects grouping statements into batches executed at once,
+// outside of a transaction
+type batchingDialect interface {
+	// batchStatements returns the statements of a migration file with the statements of each batch joined
+	batchStatements(statements []string) []string
+	// execStatement executes a statement returned by batchStatements on conn
+	execStatement(ctx context.Context, conn *sql.Conn, statement string) (sql.Result, error)
+}
+
+var (
+	dialectsMu sync.RWMutex
+	dialects   = make(map[string]Dialect)
+)
+
+// RegisterDialect makes the dialect available under the driver name. Like sql.Register, it panics when the
+// dialect is nil, when it is registered twice, or when the name is one of the built-in drivers.
+func RegisterDialect(driver string, dialect Dialect) {
+	dialectsMu.Lock()
+	defer dialectsMu.Unlock()
+	if dialect == nil {
+		panic("gosmm: RegisterDialect dialect is nil")
+	}
+	if isBuiltinDriver(driver) {
+		panic("gosmm: RegisterDialect called for the built-in driver " + driver)
+	}
+	if _, dup := dialects[driver]; dup {
+		panic("gosmm: RegisterDialect called twice for driver " + driver)
+	}
+	dialects[driver] = dialect
+}
+
+// Dialects returns the sorted names of the registered dialects
+func Dialects() []string {
+	dialectsMu.RLock()
+	defer dialectsMu.RUnlock()
+	names := make([]string, 0, len(dialects))
+	for name := range dialects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LookupDialect returns the dialect registered for the driver, or nil for the built-in and unknown drivers
+func LookupDialect(driver string) Dialect {
+	return dialectFor(driver)
+}
+
+// dialectFor returns the dialect registered for the driver, or nil for the built-in and unknown drivers
+func dialectFor(driver string) Dialect {
+	dialectsMu.RLock()
+	defer dialectsMu.RUnlock()
+	return dialects[driver]
+}
@@ -0,0 +1,122 @@
+package gosmm
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+)
+
+// testDialectDriver is the driver of testDialect, SQLite registered under another name
+const testDialectDriver = "gosmm-test-dialect"
+
+// testDialect is a registered dialect of SQLite splitting the statements on semicolons only
+type testDialect struct {
+	locks   int
+	unlocks int
+}
+
+func (d *testDialect) HistoryTableDDL(table string) string {
+	return `CREATE TABLE IF NOT EXISTS ` + table + ` (
+		installed_rank INTEGER PRIMARY KEY, filename TEXT, installed_on TIMESTAMP, execution_time INTEGER,
+		success BOOLEAN, checksum TEXT, failed_statement INTEGER, committed_statements INTEGER, author TEXT,
+		ticket TEXT, description TEXT, backup TEXT, applied_by TEXT, context TEXT
+	)`
+}
+
+func (d *testDialect) HistoryVersionTableDDL(table string) string {
+	return `CREATE TABLE IF NOT EXISTS ` + table + ` (version INTEGER PRIMARY KEY, upgraded_on TIMESTAMP NOT NULL)`
+}
+
+func (d *testDialect) LockTableDDL(table string) string {
+	return `CREATE TABLE IF NOT EXISTS ` + table + ` (lock_name TEXT PRIMARY KEY, owner TEXT NOT NULL, expires_at INTEGER NOT NULL)`
+}
+
+func (d *testDialect) Lock(context.Context, *sql.DB, string, time.Duration) (func() error, error) {
+	d.locks++
+	return func() error {
+		d.unlocks++
+		return nil
+	}, nil
+}
+
+func (d *testDialect) QuoteIdentifier(name string) string {
+	return "<" + name + ">"
+}
+
+func (d *testDialect) BindParam(int) string {
+	return "?"
+}
+
+func (d *testDialect) TransactionalDDL() bool {
+	return false
+}
+
+func (d *testDialect) SplitStatements(sql string) []string {
+	var statements []string
+	for _, statement := range strings.Split(sql, ";") {
+		if statement = strings.TrimSpace(statement); statement != "" {
+			statements = append(statements, statement)
+		}
+	}
+	return statements
+}
+
+var registeredTestDialect = &testDialect{}
+
+func init() {
+	sql.Register(testDialectDriver, &sqlite3.SQLiteDriver{})
+	RegisterDialect(testDialectDriver, registeredTestDialect)
+}
+
+func TestMigrateWithRegisteredDialect(t *testing.T) {
+	dir := t.TempDir()
+	err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER PRIMARY KEY); INSERT INTO users (id) VALUES (1);"), 0644)
+	assert.NoError(t, err)
+	db, err := ConnectDB(DBConfig{Driver: testDialectDriver, DSN: filepath.Join(t.TempDir(), "test.db")})
+	assert.NoError(t, err)
+	defer db.Close()
+
+	config := MigrationConfig{MigrationsDir: dir, Driver: testDialectDriver}
+	locks, unlocks := registeredTestDialect.locks, registeredTestDialect.unlocks
+	assert.NoError(t, MigrateWithConfig(db, config))
+	assert.Equal(t, locks+1, registeredTestDialect.locks)
+	assert.Equal(t, unlocks+1, registeredTestDialect.unlocks)
+	var count int
+	assert.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&count))
+	assert.Equal(t, 1, count)
+	assert.NoError(t, Validate(db, config))
+	report, err := Status(db, config)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, report.Pending)
+
+	assert.Equal(t, "<app>.gosmm_migration_history", historyTableName(testDialectDriver, "app"))
+	assert.False(t, transactionalDDL(testDialectDriver))
+	assert.True(t, causesImplicitCommit(testDialectDriver, "CREATE TABLE users (id INTEGER)"))
+	assert.False(t, causesImplicitCommit(testDialectDriver, "INSERT INTO users (id) VALUES (1)"))
+	// the statements are split by the dialect, which ignores the quotes
+	assert.Equal(t, []string{"SELECT '", "'"}, splitStatements("SELECT ';'", testDialectDriver))
+}
+
+func TestRegisterDialect(t *testing.T) {
+	assert.Contains(t, Dialects(), testDialectDriver)
+	assert.Equal(t, Dialect(registeredTestDialect), LookupDialect(testDialectDriver))
+	assert.Nil(t, LookupDialect("postgres"))
+	assert.True(t, isSupportedDriver(testDialectDriver))
+	assert.False(t, isSupportedDriver("duckdb"))
+	assert.PanicsWithValue(t, "gosmm: RegisterDialect called twice for driver "+testDialectDriver, func() {
+		RegisterDialect(testDialectDriver, &testDialect{})
+	})
+	assert.PanicsWithValue(t, "gosmm: RegisterDialect called for the built-in driver postgres", func() {
+		RegisterDialect("postgres", &testDialect{})
+	})
+	assert.PanicsWithValue(t, "gosmm: RegisterDialect dialect is nil", func() {
+		RegisterDialect("duckdb", nil)
+	})
+}
@@ -0,0 +1,164 @@
+package gosmm
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// DriftChange is the way an object of the database differs from the schema snapshot
+type DriftChange string
+
+const (
+	// DriftAdded is an object of the database missing from the snapshot, e.g. an index created by hand
+	DriftAdded DriftChange = "added"
+	// DriftRemoved is an object of the snapshot missing from the database
+	DriftRemoved DriftChange = "removed"
+	// DriftChanged is an object whose definition differs from the snapshot
+	DriftChanged DriftChange = "changed"
+)
+
+var (
+	// schemaHeaderPattern matches the first line of a dump, capturing the latest applied migration
+	schemaHeaderPattern = regexp.MustCompile(`^-- Schema after (.+), generated by gosmm\.`)
+	// schemaObjectPattern matches the line preceding the statement of an object in a dump
+	schemaObjectPattern = regexp.MustCompile(`^-- (table|index): (.+)$`)
+)
+
+// SchemaSnapshot is a schema dump read back by ParseSchema
+type SchemaSnapshot struct {
+	// Version is the latest migration applied when the dump was written, "no migration" when none was
+	Version string
+	Objects []SchemaObject
+}
+
+// SchemaDrift is an object of the database differing from the schema snapshot
+type SchemaDrift struct {
+	Change DriftChange      `json:"change"`
+	Kind   SchemaObjectKind `json:"kind"`
+	// Name identifies the object, e.g. "users" for a table or "users.users_email" for an index
+	Name string `json:"name"`
+	// Expected is the definition in the snapshot, empty when the object was added
+	Expected string `json:"expected,omitempty"`
+	// Actual is the definition in the database, empty when the object was removed
+	Actual string `json:"actual,omitempty"`
+}
+
+// ParseSchema reads a schema dump written by DumpSchema, e.g. the committed MigrationConfig.SchemaFile
+func ParseSchema(r io.Reader) (SchemaSnapshot, error) {
+	var snapshot SchemaSnapshot
+	var current *SchemaObject
+	var definition strings.Builder
+	flush := func() {
+		if current != nil {
+			current.Definition = strings.TrimSuffix(strings.TrimSpace(definition.String()), ";")
+			snapshot.Objects = append(snapshot.Objects, *current)
+		}
+		definition.Reset()
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if line == 1 {
+			match := schemaHeaderPattern.FindStringSubmatch(text)
+			if match == nil {
+				return SchemaSnapshot{}, fmt.Errorf("not a schema dump of gosmm, the first line is %q", text)
+			}
+			snapshot.Version = match[1]
+			continue
+		}
+		if match := schemaObjectPattern.FindStringSubmatch(text); match != nil {
+			flush()
+			current = &SchemaObject{Kind: SchemaObjectKind(match[1]), Name: match[2], Table: match[2]}
+			if current.Kind == SchemaIndex {
+				table, name, ok := strings.Cut(match[2], ".")
+				if !ok {
+					return SchemaSnapshot{}, fmt.Errorf("line %d: index %s has no table", line, match[2])
+				}
+				current.Table, current.Name = table, name
+			}
+			continue
+		}
+		if current != nil {
+			definition.WriteString(text)
+			definition.WriteByte('\n')
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return SchemaSnapshot{}, fmt.Errorf("failed to read schema dump: %w", err)
+	}
+	if snapshot.Version == "" {
+		return SchemaSnapshot{}, fmt.Errorf("empty schema dump")
+	}
+	flush()
+	return snapshot, nil
+}
+
+// Drift compares the schema of the database with the snapshot read from r, e.g. the committed
+// MigrationConfig.SchemaFile, and returns the objects added, removed or changed outside of the migrations,
+// such as hotfixes applied directly in production. The snapshot must have been written after the latest
+// migration applied to the database, otherwise the changes of the migrations would be reported as drift.
+func Drift(db *sql.DB, config MigrationConfig, r io.Reader) ([]SchemaDrift, error) {
+	snapshot, err := ParseSchema(r)
+	if err != nil {
+		return nil, err
+	}
+	version, err := schemaVersion(db, config)
+	if err != nil {
+		return nil, err
+	}
+	if version != snapshot.Version {
+		return nil, fmt.Errorf("the schema snapshot is of %s but the database is at %s, dump it again after migrating", snapshot.Version, version)
+	}
+	objects, err := InspectSchema(db, config)
+	if err != nil {
+		return nil, err
+	}
+	return DiffSchema(snapshot.Objects, objects), nil
+}
+
+// DiffSchema returns the differences between the expected and actual objects, in the order of their names, e.g.
+// between the objects of a snapshot read by ParseSchema and those of InspectSchema
+func DiffSchema(expected []SchemaObject, actual []SchemaObject) []SchemaDrift {
+	expectedByKey := make(map[string]SchemaObject, len(expected))
+	for _, object := range expected {
+		expectedByKey[object.key()] = object
+	}
+	actualByKey := make(map[string]SchemaObject, len(actual))
+	for _, object := range actual {
+		actualByKey[object.key()] = object
+	}
+
+	var drifts []SchemaDrift
+	for key, object := range actualByKey {
+		drift := SchemaDrift{Kind: object.Kind, Name: strings.TrimPrefix(key, string(object.Kind)+": "), Actual: object.Definition}
+		want, ok := expectedByKey[key]
+		switch {
+		case !ok:
+			drift.Change = DriftAdded
+		case strings.TrimSpace(want.Definition) != strings.TrimSpace(object.Definition):
+			drift.Change, drift.Expected = DriftChanged, want.Definition
+		default:
+			continue
+		}
+		drifts = append(drifts, drift)
+	}
+	for key, object := range expectedByKey {
+		if _, ok := actualByKey[key]; !ok {
+			drifts = append(drifts, SchemaDrift{Change: DriftRemoved, Kind: object.Kind, Name: strings.TrimPrefix(key, string(object.Kind)+": "), Expected: object.Definition})
+		}
+	}
+	sort.Slice(drifts, func(i, j int) bool {
+		if drifts[i].Name != drifts[j].Name {
+			return drifts[i].Name < drifts[j].Name
+		}
+		return drifts[i].Kind > drifts[j].Kind // a table before its index of the same name
+	})
+	return drifts
+}
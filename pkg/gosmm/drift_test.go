@@ -0,0 +1,87 @@
+package gosmm
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSchema(t *testing.T) {
+	snapshot, err := ParseSchema(strings.NewReader(`-- Schema after v20230101_create_users_00001.sql, generated by gosmm. Do not edit.
+
+-- table: users
+CREATE TABLE users (
+    id integer NOT NULL
+);
+
+-- index: users.users_id
+CREATE INDEX users_id ON users (id);
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, SchemaSnapshot{
+		Version: "v20230101_create_users_00001.sql",
+		Objects: []SchemaObject{
+			{Kind: SchemaTable, Name: "users", Table: "users", Definition: "CREATE TABLE users (\n    id integer NOT NULL\n)"},
+			{Kind: SchemaIndex, Name: "users_id", Table: "users", Definition: "CREATE INDEX users_id ON users (id)"},
+		},
+	}, snapshot)
+
+	_, err = ParseSchema(strings.NewReader("CREATE TABLE users (id INTEGER);\n"))
+	assert.EqualError(t, err, `not a schema dump of gosmm, the first line is "CREATE TABLE users (id INTEGER);"`)
+	_, err = ParseSchema(strings.NewReader(""))
+	assert.EqualError(t, err, "empty schema dump")
+}
+
+func TestDrift(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	migrations := map[string]string{
+		"v20230101_create_users_00001.sql": "CREATE TABLE users (id INTEGER, email TEXT);",
+		"v20230102_create_posts_00002.sql": "CREATE TABLE posts (id INTEGER);",
+	}
+	for name, content := range migrations {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test migration file: %v", err)
+		}
+	}
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3", SchemaFile: filepath.Join(t.TempDir(), "schema.sql")}
+	assert.NoError(t, MigrateWithConfig(db, config))
+	snapshot, err := ioutil.ReadFile(config.SchemaFile)
+	assert.NoError(t, err)
+
+	drifts, err := Drift(db, config, strings.NewReader(string(snapshot)))
+	assert.NoError(t, err)
+	assert.Empty(t, drifts)
+
+	// Hotfixes applied directly to the database are reported
+	for _, statement := range []string{
+		"CREATE INDEX users_email ON users (email)",
+		"DROP TABLE posts",
+		"ALTER TABLE users ADD COLUMN name TEXT",
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatalf("Failed to apply hotfix: %v", err)
+		}
+	}
+	drifts, err = Drift(db, config, strings.NewReader(string(snapshot)))
+	assert.NoError(t, err)
+	assert.Equal(t, []SchemaDrift{
+		{Change: DriftRemoved, Kind: SchemaTable, Name: "posts", Expected: "CREATE TABLE posts (id INTEGER)"},
+		{Change: DriftChanged, Kind: SchemaTable, Name: "users", Expected: "CREATE TABLE users (id INTEGER, email TEXT)", Actual: "CREATE TABLE users (id INTEGER, email TEXT, name TEXT)"},
+		{Change: DriftAdded, Kind: SchemaIndex, Name: "users.users_email", Actual: "CREATE INDEX users_email ON users (email)"},
+	}, drifts)
+
+	// A snapshot of another version cannot be compared
+	config.SchemaFile = ""
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230103_create_tags_00003.sql"), []byte("CREATE TABLE tags (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	assert.NoError(t, MigrateWithConfig(db, config))
+	_, err = Drift(db, config, strings.NewReader(string(snapshot)))
+	assert.EqualError(t, err, "the schema snapshot is of v20230102_create_posts_00002.sql but the database is at v20230103_create_tags_00003.sql, dump it again after migrating")
+}
@@ -0,0 +1,397 @@
+package gosmm
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// isSupportedDriver reports whether gosmm supports the given driver, built in or by a registered dialect
+func isSupportedDriver(driver string) bool {
+	return isBuiltinDriver(driver) || dialectFor(driver) != nil
+}
+
+// isBuiltinDriver reports whether the driver is supported by gosmm itself
+func isBuiltinDriver(driver string) bool {
+	switch driver {
+	case "postgres", "mysql", "sqlite3", "sqlserver":
+		return true
+	default:
+		return false
+	}
+}
+
+// quoteIdentifier quotes an identifier such as a schema name for the given driver
+func quoteIdentifier(driver string, name string) string {
+	if dialect := dialectFor(driver); dialect != nil {
+		return dialect.QuoteIdentifier(name)
+	}
+	switch driver {
+	case "mysql":
+		return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+	case "sqlserver":
+		return "[" + strings.ReplaceAll(name, "]", "]]") + "]"
+	default:
+		return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+	}
+}
+
+// unquotedNamePattern matches the names Oracle and Snowflake fold to upper case when they are not quoted
+var unquotedNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_$#]*$`)
+
+// quoteFoldedIdentifier quotes a name for the databases folding unquoted names to upper case. A name written
+// in a single case, e.g. the schema app, is quoted in upper case to name the APP schema created without quotes,
+// while a mixed case name, which can only have been created quoted, keeps its case.
+func quoteFoldedIdentifier(name string) string {
+	if unquotedNamePattern.MatchString(name) && (name == strings.ToLower(name) || name == strings.ToUpper(name)) {
+		name = strings.ToUpper(name)
+	}
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// historyTableName returns the history table name, qualified with the schema if one is given
+func historyTableName(driver string, schema string) string {
+	if schema == "" {
+		return migrationHistoryTable
+	}
+	return quoteIdentifier(driver, schema) + "." + migrationHistoryTable
+}
+
+// historyTableDDL returns the statement creating the history table for the given driver
+func historyTableDDL(driver string, table string) string {
+	if dialect := dialectFor(driver); dialect != nil {
+		return dialect.HistoryTableDDL(table)
+	}
+	switch driver {
+	case "postgres":
+		return `CREATE TABLE IF NOT EXISTS ` + table + ` (
+			installed_rank INTEGER NOT NULL PRIMARY KEY,
+			filename VARCHAR(255) NOT NULL,
+			installed_on TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+			execution_time INTEGER NOT NULL,
+			success BOOLEAN NOT NULL,
+			checksum VARCHAR(64),
+			failed_statement INTEGER,
+			committed_statements INTEGER,
+			author VARCHAR(255),
+			ticket VARCHAR(255),
+			description VARCHAR(1000),
+			backup VARCHAR(1000),
+			applied_by VARCHAR(255),
+			context VARCHAR(1000),
+			rows_affected BIGINT,
+			approval VARCHAR(255),
+			ahead INTEGER
+		)`
+	case "mysql":
+		return `CREATE TABLE IF NOT EXISTS ` + table + ` (
+			installed_rank INT NOT NULL PRIMARY KEY,
+			filename VARCHAR(255) NOT NULL,
+			installed_on DATETIME(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+			execution_time INT NOT NULL,
+			success BOOLEAN NOT NULL,
+			checksum VARCHAR(64),
+			failed_statement INT,
+			committed_statements INT,
+			author VARCHAR(255),
+			ticket VARCHAR(255),
+			description VARCHAR(1000),
+			backup VARCHAR(1000),
+			applied_by VARCHAR(255),
+			context VARCHAR(1000),
+			rows_affected BIGINT,
+			approval VARCHAR(255),
+			ahead INT
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`
+	case "sqlserver":
+		return `IF OBJECT_ID(N'` + strings.ReplaceAll(table, "'", "''") + `', N'U') IS NULL
+		CREATE TABLE ` + table + ` (
+			installed_rank INT NOT NULL PRIMARY KEY,
+			filename NVARCHAR(255) NOT NULL,
+			installed_on DATETIME2(3) NOT NULL DEFAULT SYSUTCDATETIME(),
+			execution_time INT NOT NULL,
+			success BIT NOT NULL,
+			checksum NVARCHAR(64),
+			failed_statement INT,
+			committed_statements INT,
+			author NVARCHAR(255),
+			ticket NVARCHAR(255),
+			description NVARCHAR(1000),
+			backup NVARCHAR(1000),
+			applied_by NVARCHAR(255),
+			context NVARCHAR(1000),
+			rows_affected BIGINT,
+			approval NVARCHAR(255),
+			ahead INT
+		)`
+	default:
+		return `CREATE TABLE IF NOT EXISTS ` + table + ` (
+			installed_rank INTEGER,
+			filename TEXT,
+			installed_on TIMESTAMP,
+			execution_time INTEGER,
+			success BOOLEAN,
+			checksum TEXT,
+			failed_statement INTEGER,
+			committed_statements INTEGER,
+			author TEXT,
+			ticket TEXT,
+			description TEXT,
+			backup TEXT,
+			applied_by TEXT,
+			context TEXT,
+			rows_affected INTEGER,
+			approval TEXT,
+			ahead INTEGER
+		)`
+	}
+}
+
+// seedHistoryTableDDL returns the statement creating the seed history table for the given driver
+func seedHistoryTableDDL(driver string, table string) string {
+	switch driver {
+	case "postgres":
+		return `CREATE TABLE IF NOT EXISTS ` + table + ` (
+			filename VARCHAR(255) NOT NULL PRIMARY KEY,
+			checksum VARCHAR(64) NOT NULL,
+			applied_on TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+			execution_time INTEGER NOT NULL
+		)`
+	case "mysql":
+		return `CREATE TABLE IF NOT EXISTS ` + table + ` (
+			filename VARCHAR(255) NOT NULL PRIMARY KEY,
+			checksum VARCHAR(64) NOT NULL,
+			applied_on DATETIME(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+			execution_time INT NOT NULL
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`
+	case "sqlserver":
+		return `IF OBJECT_ID(N'` + strings.ReplaceAll(table, "'", "''") + `', N'U') IS NULL
+		CREATE TABLE ` + table + ` (
+			filename NVARCHAR(255) NOT NULL PRIMARY KEY,
+			checksum NVARCHAR(64) NOT NULL,
+			applied_on DATETIME2(3) NOT NULL DEFAULT SYSUTCDATETIME(),
+			execution_time INT NOT NULL
+		)`
+	default:
+		return `CREATE TABLE IF NOT EXISTS ` + table + ` (
+			filename TEXT PRIMARY KEY,
+			checksum TEXT,
+			applied_on TIMESTAMP,
+			execution_time INTEGER
+		)`
+	}
+}
+
+// lockTableDDL returns the statement creating the lock table holding the leases for the given driver
+func lockTableDDL(driver string, table string) string {
+	if dialect := dialectFor(driver); dialect != nil {
+		return dialect.LockTableDDL(table)
+	}
+	switch driver {
+	case "postgres":
+		return `CREATE TABLE IF NOT EXISTS ` + table + ` (
+			lock_name VARCHAR(255) NOT NULL PRIMARY KEY,
+			owner VARCHAR(255) NOT NULL,
+			expires_at BIGINT NOT NULL
+		)`
+	case "mysql":
+		return `CREATE TABLE IF NOT EXISTS ` + table + ` (
+			lock_name VARCHAR(255) NOT NULL PRIMARY KEY,
+			owner VARCHAR(255) NOT NULL,
+			expires_at BIGINT NOT NULL
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`
+	case "sqlserver":
+		return `IF OBJECT_ID(N'` + strings.ReplaceAll(table, "'", "''") + `', N'U') IS NULL
+		CREATE TABLE ` + table + ` (
+			lock_name NVARCHAR(255) NOT NULL PRIMARY KEY,
+			owner NVARCHAR(255) NOT NULL,
+			expires_at BIGINT NOT NULL
+		)`
+	default:
+		return `CREATE TABLE IF NOT EXISTS ` + table + ` (
+			lock_name TEXT PRIMARY KEY,
+			owner TEXT NOT NULL,
+			expires_at INTEGER NOT NULL
+		)`
+	}
+}
+
+// historyVersionTableDDL returns the statement creating the table recording the versions of the history table
+// for the given driver
+func historyVersionTableDDL(driver string, table string) string {
+	if dialect := dialectFor(driver); dialect != nil {
+		return dialect.HistoryVersionTableDDL(table)
+	}
+	switch driver {
+	case "postgres":
+		return `CREATE TABLE IF NOT EXISTS ` + table + ` (
+			version INTEGER NOT NULL PRIMARY KEY,
+			upgraded_on TIMESTAMP WITH TIME ZONE NOT NULL
+		)`
+	case "mysql":
+		return `CREATE TABLE IF NOT EXISTS ` + table + ` (
+			version INT NOT NULL PRIMARY KEY,
+			upgraded_on DATETIME(3) NOT NULL
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`
+	case "sqlserver":
+		return `IF OBJECT_ID(N'` + strings.ReplaceAll(table, "'", "''") + `', N'U') IS NULL
+		CREATE TABLE ` + table + ` (
+			version INT NOT NULL PRIMARY KEY,
+			upgraded_on DATETIME2(3) NOT NULL
+		)`
+	default:
+		return `CREATE TABLE IF NOT EXISTS ` + table + ` (
+			version INTEGER PRIMARY KEY,
+			upgraded_on TIMESTAMP NOT NULL
+		)`
+	}
+}
+
+// createSchemaDDL returns the statement creating the schema if it doesn't exist, or "" if the driver
+// does not need one
+func createSchemaDDL(driver string, schema string) string {
+	switch driver {
+	case "postgres":
+		return `CREATE SCHEMA IF NOT EXISTS ` + quoteIdentifier(driver, schema)
+	case "sqlserver":
+		return `IF SCHEMA_ID(N'` + strings.ReplaceAll(schema, "'", "''") + `') IS NULL EXEC('CREATE SCHEMA ` +
+			strings.ReplaceAll(quoteIdentifier(driver, schema), "'", "''") + `')`
+	default:
+		return ""
+	}
+}
+
+// addColumnDDL returns the statement adding a column to the given table
+func addColumnDDL(driver string, table string, column string, columnType string) string {
+	if driver == "sqlserver" {
+		return `ALTER TABLE ` + table + ` ADD ` + column + ` ` + columnType
+	}
+	return `ALTER TABLE ` + table + ` ADD COLUMN ` + column + ` ` + columnType
+}
+
+// boolLiteral returns the SQL literal for the given boolean
+func boolLiteral(driver string, b bool) string {
+	if _, numeric := dialectFor(driver).(numericBooleanDialect); numeric || driver == "sqlserver" {
+		if b {
+			return "1"
+		}
+		return "0"
+	}
+	if b {
+		return "TRUE"
+	}
+	return "FALSE"
+}
+
+// boolValue returns the value bound for the given boolean, 1 or 0 for the drivers without a boolean type
+func boolValue(driver string, b bool) interface{} {
+	if _, numeric := dialectFor(driver).(numericBooleanDialect); !numeric {
+		return b
+	}
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// bindParams returns a comma separated list of n bind parameters in the driver's syntax
+func bindParams(driver string, n int) string {
+	params := make([]string, n)
+	for i := range params {
+		params[i] = bindParam(driver, i+1)
+	}
+	return strings.Join(params, ", ")
+}
+
+// bindParam returns the placeholder of the i-th (1-based) parameter of a statement
+func bindParam(driver string, i int) string {
+	if dialect := dialectFor(driver); dialect != nil {
+		return dialect.BindParam(i)
+	}
+	switch driver {
+	case "postgres":
+		return fmt.Sprintf("$%d", i)
+	case "sqlserver":
+		return fmt.Sprintf("@p%d", i)
+	default:
+		return "?"
+	}
+}
+
+// setSearchPath points the postgres search_path at the configured schema for the rest of the transaction
+func setSearchPath(tx *sql.Tx, driver string, schema string) error {
+	if driver != "postgres" || schema == "" {
+		return nil
+	}
+	if _, err := tx.Exec(`SET LOCAL search_path TO ` + quoteIdentifier(driver, schema)); err != nil {
+		return fmt.Errorf("failed to set search_path to %s: %w", schema, err)
+	}
+	return nil
+}
+
+// implicitCommitPattern matches the statements that cause an implicit commit in MySQL, and in the databases of
+// the dialects without transactional DDL
+var implicitCommitPattern = regexp.MustCompile(`(?i)^\s*(CREATE|ALTER|DROP|RENAME|TRUNCATE)\b`)
+
+// transactionalDDL reports whether the DDL statements of the driver can be rolled back,
+// unlike those of MySQL which commit the current transaction implicitly
+func transactionalDDL(driver string) bool {
+	if dialect := dialectFor(driver); dialect != nil {
+		return dialect.TransactionalDDL()
+	}
+	return driver != "mysql"
+}
+
+// transactionalMigrations reports whether the migrations of the driver run in a transaction, unless their header
+// says otherwise
+func transactionalMigrations(driver string) bool {
+	_, ok := dialectFor(driver).(nonTransactionalDialect)
+	return !ok
+}
+
+// externalHistory reports whether the history of the driver must be kept in MigrationConfig.HistoryDB
+func externalHistory(driver string) bool {
+	_, ok := dialectFor(driver).(externalHistoryDialect)
+	return ok
+}
+
+// batchStatements groups the statements of a migration file into the batches of the driver, if it batches them
+func batchStatements(driver string, statements []string) []string {
+	if dialect, ok := dialectFor(driver).(batchingDialect); ok {
+		return dialect.batchStatements(statements)
+	}
+	return statements
+}
+
+// causesImplicitCommit reports whether the statement commits the current transaction implicitly,
+// meaning it cannot be rolled back if a later statement of the same migration fails
+func causesImplicitCommit(driver string, statement string) bool {
+	if transactionalDDL(driver) {
+		return false
+	}
+	return implicitCommitPattern.MatchString(stripLeadingComments(statement))
+}
+
+// stripLeadingComments removes the comments preceding the first keyword of a statement
+func stripLeadingComments(statement string) string {
+	for {
+		statement = strings.TrimSpace(statement)
+		switch {
+		case strings.HasPrefix(statement, "--"), strings.HasPrefix(statement, "#"):
+			end := strings.IndexByte(statement, '\n')
+			if end == -1 {
+				return ""
+			}
+			statement = statement[end+1:]
+		case strings.HasPrefix(statement, "/*"):
+			end := strings.Index(statement, "*/")
+			if end == -1 {
+				return ""
+			}
+			statement = statement[end+2:]
+		default:
+			return statement
+		}
+	}
+}
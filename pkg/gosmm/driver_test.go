@@ -0,0 +1,56 @@
+package gosmm
+
+import (
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestHistoryTableName(t *testing.T) {
+	assert.Equal(t, "gosmm_migration_history", historyTableName("postgres", ""))
+	assert.Equal(t, `"tenant_a".gosmm_migration_history`, historyTableName("postgres", "tenant_a"))
+	assert.Equal(t, "`app`.gosmm_migration_history", historyTableName("mysql", "app"))
+}
+
+func TestCausesImplicitCommit(t *testing.T) {
+	assert.True(t, causesImplicitCommit("mysql", "CREATE TABLE test_table (id INT)"))
+	assert.True(t, causesImplicitCommit("mysql", "-- add a column\nalter table test_table ADD name TEXT"))
+	assert.True(t, causesImplicitCommit("mysql", "/* cleanup */ DROP TABLE test_table"))
+	assert.False(t, causesImplicitCommit("mysql", "INSERT INTO test_table VALUES (1)"))
+	assert.False(t, causesImplicitCommit("postgres", "CREATE TABLE test_table (id INT)"))
+}
+
+func TestBindParams(t *testing.T) {
+	assert.Equal(t, "$1, $2", bindParams("postgres", 2))
+	assert.Equal(t, "?, ?", bindParams("mysql", 2))
+	assert.Equal(t, "@p1, @p2", bindParams("sqlserver", 2))
+}
+
+func TestQuoteIdentifierForSQLServer(t *testing.T) {
+	assert.Equal(t, "[dbo]", quoteIdentifier("sqlserver", "dbo"))
+	assert.Equal(t, "[we]]ird]", quoteIdentifier("sqlserver", "we]ird"))
+}
+
+func TestIsSerializationFailure(t *testing.T) {
+	assert.True(t, isSerializationFailure(&pq.Error{Code: "40001"}))
+	assert.True(t, isSerializationFailure(fmt.Errorf("failed to execute: %w", &pq.Error{Code: "40001"})))
+	assert.False(t, isSerializationFailure(&pq.Error{Code: "42P01"}))
+	assert.False(t, isSerializationFailure(errors.New("40001")))
+}
+
+func TestRetryBackoff(t *testing.T) {
+	assert.Equal(t, 100*time.Millisecond, retryBackoff(1))
+	assert.Equal(t, 400*time.Millisecond, retryBackoff(3))
+}
+
+func TestIsCockroachDBWithSQLite(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	cockroach, err := isCockroachDB(db, "sqlite3")
+	assert.NoError(t, err)
+	assert.False(t, cockroach)
+}
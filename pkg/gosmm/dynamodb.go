@@ -0,0 +1,439 @@
+package gosmm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// dynamoDBLockKey is the filename of the item holding the lease of DynamoDBHistoryStore, which no migration
+	// file is named after
+	dynamoDBLockKey = "#lock"
+	// defaultDynamoDBLease is the lease of DynamoDBHistoryStore when its Lease is zero
+	defaultDynamoDBLease = 30 * time.Second
+	// dynamoDBTablePollInterval is the delay between two checks of a table being created
+	dynamoDBTablePollInterval = time.Second
+)
+
+// DynamoDBHistoryStore is a HistoryStore keeping the history in a DynamoDB table, created on demand with the
+// filename as its partition key, so that a migration has a single entry. The runs are serialized by a lease
+// held in an item of the table, renewed while the run is in progress.
+type DynamoDBHistoryStore struct {
+	// Table is the name of the table
+	Table string
+	// Region of the table, AWS_REGION or AWS_DEFAULT_REGION when empty
+	Region string
+	// Credentials signing the requests, the AWS_* environment variables when nil
+	Credentials AWSCredentialsFunc
+	// Endpoint overrides the DynamoDB endpoint, e.g. http://localhost:8000 for DynamoDB Local
+	Endpoint string
+	// Lease is how long the lease outlives the last renewal, e.g. of a killed run, 30 seconds when zero
+	Lease time.Duration
+	// Client sends the requests, http.DefaultClient when nil
+	Client *http.Client
+	// LogLevel is the verbosity of the messages printed while the lease is held, LogInfo when empty
+	LogLevel LogLevel
+}
+
+// dynamoDBValue is an attribute value of DynamoDB
+type dynamoDBValue struct {
+	S    *string `json:"S,omitempty"`
+	N    *string `json:"N,omitempty"`
+	BOOL *bool   `json:"BOOL,omitempty"`
+}
+
+// dynamoDBItem is an item of DynamoDB keyed by attribute name
+type dynamoDBItem map[string]dynamoDBValue
+
+func dynamoDBString(s string) dynamoDBValue {
+	return dynamoDBValue{S: &s}
+}
+
+func dynamoDBNumber(n int64) dynamoDBValue {
+	s := strconv.FormatInt(n, 10)
+	return dynamoDBValue{N: &s}
+}
+
+func dynamoDBBool(b bool) dynamoDBValue {
+	return dynamoDBValue{BOOL: &b}
+}
+
+// string returns the string attribute name of the item, "" when it is missing
+func (item dynamoDBItem) string(name string) string {
+	if value := item[name].S; value != nil {
+		return *value
+	}
+	return ""
+}
+
+// number returns the number attribute name of the item, zero when it is missing
+func (item dynamoDBItem) number(name string) (int64, error) {
+	value := item[name].N
+	if value == nil {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(*value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return n, nil
+}
+
+// dynamoDBError is an error returned by DynamoDB, e.g. ConditionalCheckFailedException
+type dynamoDBError struct {
+	Type    string
+	Message string
+}
+
+func (e *dynamoDBError) Error() string {
+	return e.Type + ": " + e.Message
+}
+
+// isDynamoDBError reports whether err is the DynamoDB error of the type
+func isDynamoDBError(err error, errorType string) bool {
+	var e *dynamoDBError
+	return errors.As(err, &e) && e.Type == errorType
+}
+
+// call sends the DynamoDB operation with input, decoding its response into output unless nil
+func (s DynamoDBHistoryStore) call(ctx context.Context, operation string, input interface{}, output interface{}) error {
+	region, err := awsRegion(s.Region)
+	if err != nil {
+		return err
+	}
+	credentials, err := awsCredentials(ctx, s.Credentials)
+	if err != nil {
+		return err
+	}
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = "https://dynamodb." + region + ".amazonaws.com"
+	}
+	body, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(endpoint, "/")+"/", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("invalid DynamoDB endpoint: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.0")
+	req.Header.Set("X-Amz-Target", "DynamoDB_20120810."+operation)
+	awsSigner{credentials: credentials, region: region, service: "dynamodb", now: time.Now().UTC()}.sign(req, body)
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("DynamoDB %s failed: %w", operation, err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read DynamoDB %s response: %w", operation, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		// the message is named message or Message depending on the error, which Unmarshal both matches
+		var failure struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(data, &failure) != nil || failure.Type == "" {
+			return fmt.Errorf("DynamoDB %s failed: %s: %s", operation, resp.Status, strings.TrimSpace(string(data)))
+		}
+		// the type is prefixed with the namespace of the service, e.g. com.amazonaws.dynamodb.v20120810#
+		errorType := failure.Type[strings.LastIndex(failure.Type, "#")+1:]
+		return fmt.Errorf("DynamoDB %s failed: %w", operation, &dynamoDBError{Type: errorType, Message: failure.Message})
+	}
+	if output == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, output); err != nil {
+		return fmt.Errorf("failed to parse DynamoDB %s response: %w", operation, err)
+	}
+	return nil
+}
+
+// Init implements HistoryStore, creating the table with on-demand capacity and waiting for it to be active
+func (s DynamoDBHistoryStore) Init(ctx context.Context) error {
+	if s.Table == "" {
+		return errors.New("missing DynamoDB table")
+	}
+	created := false
+	for {
+		var described struct {
+			Table struct {
+				TableStatus string
+			}
+		}
+		err := s.call(ctx, "DescribeTable", map[string]interface{}{"TableName": s.Table}, &described)
+		switch {
+		case err == nil && described.Table.TableStatus == "ACTIVE":
+			return nil
+		case isDynamoDBError(err, "ResourceNotFoundException") && !created:
+			err = s.call(ctx, "CreateTable", map[string]interface{}{
+				"TableName":            s.Table,
+				"AttributeDefinitions": []map[string]string{{"AttributeName": "filename", "AttributeType": "S"}},
+				"KeySchema":            []map[string]string{{"AttributeName": "filename", "KeyType": "HASH"}},
+				"BillingMode":          "PAY_PER_REQUEST",
+			}, nil)
+			// another run may have created the table in the meantime
+			if err != nil && !isDynamoDBError(err, "ResourceInUseException") {
+				return fmt.Errorf("failed to create history table: %w", err)
+			}
+			created = true
+			continue
+		case err != nil && !isDynamoDBError(err, "ResourceNotFoundException"):
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(dynamoDBTablePollInterval):
+		}
+	}
+}
+
+// Lock implements HistoryStore with a lease in the item named dynamoDBLockKey, taken with a conditional write
+// when it is free or expired. Expiry is computed with the clock of each process, so their clocks must be
+// roughly in sync.
+func (s DynamoDBHistoryStore) Lock(ctx context.Context, wait time.Duration) (func() error, error) {
+	lease := s.Lease
+	if lease == 0 {
+		lease = defaultDynamoDBLease
+	}
+	owner, err := leaseOwner()
+	if err != nil {
+		return nil, err
+	}
+	key := dynamoDBItem{"filename": dynamoDBString(dynamoDBLockKey)}
+
+	var deadline time.Time
+	if wait > 0 {
+		deadline = time.Now().Add(wait)
+	}
+	for {
+		now := time.Now()
+		err := s.call(ctx, "PutItem", map[string]interface{}{
+			"TableName": s.Table,
+			"Item": dynamoDBItem{
+				"filename":   dynamoDBString(dynamoDBLockKey),
+				"owner":      dynamoDBString(owner),
+				"expires_at": dynamoDBNumber(now.Add(lease).UnixMilli()),
+			},
+			"ConditionExpression":       "attribute_not_exists(#filename) OR #expires_at < :now",
+			"ExpressionAttributeNames":  map[string]string{"#filename": "filename", "#expires_at": "expires_at"},
+			"ExpressionAttributeValues": dynamoDBItem{":now": dynamoDBNumber(now.UnixMilli())},
+		}, nil)
+		if err == nil {
+			break
+		}
+		if !isDynamoDBError(err, "ConditionalCheckFailedException") {
+			return nil, fmt.Errorf("failed to take lease: %w", err)
+		}
+
+		var held struct {
+			Item dynamoDBItem
+		}
+		if err := s.call(ctx, "GetItem", map[string]interface{}{"TableName": s.Table, "Key": key, "ConsistentRead": true}, &held); err != nil {
+			return nil, fmt.Errorf("failed to get lease owner: %w", err)
+		}
+		delay := leasePollInterval
+		if !deadline.IsZero() {
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				return nil, fmt.Errorf("%w after %s, held by %s", ErrLockTimeout, wait, held.Item.string("owner"))
+			}
+			if remaining < delay {
+				delay = remaining
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(lease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				err := s.call(context.Background(), "UpdateItem", map[string]interface{}{
+					"TableName":                 s.Table,
+					"Key":                       key,
+					"UpdateExpression":          "SET #expires_at = :expires_at",
+					"ConditionExpression":       "#owner = :owner",
+					"ExpressionAttributeNames":  map[string]string{"#owner": "owner", "#expires_at": "expires_at"},
+					"ExpressionAttributeValues": dynamoDBItem{":owner": dynamoDBString(owner), ":expires_at": dynamoDBNumber(time.Now().Add(lease).UnixMilli())},
+				}, nil)
+				if err != nil {
+					s.LogLevel.printf(LogWarn, "WARNING: failed to renew the migration lease: %v\n", err)
+				}
+			}
+		}
+	}()
+
+	return func() error {
+		close(stop)
+		<-stopped
+		err := s.call(context.Background(), "DeleteItem", map[string]interface{}{
+			"TableName":                 s.Table,
+			"Key":                       key,
+			"ConditionExpression":       "#owner = :owner",
+			"ExpressionAttributeNames":  map[string]string{"#owner": "owner"},
+			"ExpressionAttributeValues": dynamoDBItem{":owner": dynamoDBString(owner)},
+		}, nil)
+		if err != nil {
+			return fmt.Errorf("failed to release lease: %w", err)
+		}
+		return nil
+	}, nil
+}
+
+// Entries implements HistoryStore with a consistent scan of the table
+func (s DynamoDBHistoryStore) Entries(ctx context.Context) ([]HistoryEntry, error) {
+	entries := make([]HistoryEntry, 0)
+	var startKey dynamoDBItem
+	for {
+		input := map[string]interface{}{"TableName": s.Table, "ConsistentRead": true}
+		if startKey != nil {
+			input["ExclusiveStartKey"] = startKey
+		}
+		var page struct {
+			Items            []dynamoDBItem
+			LastEvaluatedKey dynamoDBItem
+		}
+		if err := s.call(ctx, "Scan", input, &page); err != nil {
+			return nil, err
+		}
+		for _, item := range page.Items {
+			if item.string("filename") == dynamoDBLockKey {
+				continue
+			}
+			entry, err := dynamoDBEntry(item)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		}
+		if len(page.LastEvaluatedKey) == 0 {
+			break
+		}
+		startKey = page.LastEvaluatedKey
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].InstalledRank < entries[j].InstalledRank
+	})
+	return entries, nil
+}
+
+// Record implements HistoryStore, replacing the entry of the migration
+func (s DynamoDBHistoryStore) Record(ctx context.Context, entry HistoryEntry) error {
+	item := dynamoDBItem{
+		"filename":       dynamoDBString(entry.Filename),
+		"installed_rank": dynamoDBNumber(int64(entry.InstalledRank)),
+		"installed_on":   dynamoDBString(entry.InstalledOn.UTC().Format(time.RFC3339Nano)),
+		"execution_time": dynamoDBNumber(entry.ExecutionTime),
+		"success":        dynamoDBBool(entry.Success),
+	}
+	for name, value := range map[string]string{
+		"checksum":    entry.Checksum,
+		"author":      entry.Author,
+		"ticket":      entry.Ticket,
+		"description": entry.Description,
+		"backup":      entry.Backup,
+		"applied_by":  entry.AppliedBy,
+		"context":     entry.Context,
+		"approval":    entry.Approval,
+	} {
+		if value != "" {
+			item[name] = dynamoDBString(value)
+		}
+	}
+	if entry.FailedStatement > 0 {
+		item["failed_statement"] = dynamoDBNumber(int64(entry.FailedStatement))
+	}
+	if entry.CommittedStatements > 0 {
+		item["committed_statements"] = dynamoDBNumber(int64(entry.CommittedStatements))
+	}
+	if entry.RowsAffected > 0 {
+		item["rows_affected"] = dynamoDBNumber(entry.RowsAffected)
+	}
+	if entry.Ahead {
+		item["ahead"] = dynamoDBBool(true)
+	}
+	if err := s.call(ctx, "PutItem", map[string]interface{}{"TableName": s.Table, "Item": item}, nil); err != nil {
+		return fmt.Errorf("failed to record migration in history table, error: %w, filename: %s", err, entry.Filename)
+	}
+	return nil
+}
+
+// RemoveFailed implements HistoryStore with a delete conditioned on the failure of the migration
+func (s DynamoDBHistoryStore) RemoveFailed(ctx context.Context, filename string) error {
+	err := s.call(ctx, "DeleteItem", map[string]interface{}{
+		"TableName":                 s.Table,
+		"Key":                       dynamoDBItem{"filename": dynamoDBString(filename)},
+		"ConditionExpression":       "#success = :false",
+		"ExpressionAttributeNames":  map[string]string{"#success": "success"},
+		"ExpressionAttributeValues": dynamoDBItem{":false": dynamoDBBool(false)},
+	}, nil)
+	if err != nil && !isDynamoDBError(err, "ConditionalCheckFailedException") {
+		return err
+	}
+	return nil
+}
+
+// dynamoDBEntry returns the history entry of an item written by Record
+func dynamoDBEntry(item dynamoDBItem) (HistoryEntry, error) {
+	entry := HistoryEntry{
+		Filename:    item.string("filename"),
+		Checksum:    item.string("checksum"),
+		Author:      item.string("author"),
+		Ticket:      item.string("ticket"),
+		Description: item.string("description"),
+		Backup:      item.string("backup"),
+		AppliedBy:   item.string("applied_by"),
+		Context:     item.string("context"),
+		Approval:    item.string("approval"),
+	}
+	if success := item["success"].BOOL; success != nil {
+		entry.Success = *success
+	}
+	if ahead := item["ahead"].BOOL; ahead != nil {
+		entry.Ahead = *ahead
+	}
+	installedOn, err := time.Parse(time.RFC3339Nano, item.string("installed_on"))
+	if err != nil {
+		return entry, fmt.Errorf("invalid installed_on of %s: %w", entry.Filename, err)
+	}
+	entry.InstalledOn = installedOn
+	numbers := map[string]int64{}
+	for _, name := range []string{"installed_rank", "execution_time", "failed_statement", "committed_statements", "rows_affected"} {
+		if numbers[name], err = item.number(name); err != nil {
+			return entry, fmt.Errorf("%s of %s", err, entry.Filename)
+		}
+	}
+	entry.InstalledRank, entry.ExecutionTime = int(numbers["installed_rank"]), numbers["execution_time"]
+	entry.FailedStatement, entry.CommittedStatements = int(numbers["failed_statement"]), int(numbers["committed_statements"])
+	entry.RowsAffected = numbers["rows_affected"]
+	return entry, nil
+}
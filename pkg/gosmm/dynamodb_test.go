@@ -0,0 +1,188 @@
+package gosmm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeDynamoDB serves the operations of DynamoDBHistoryStore on a single table, scanned two items per page
+type fakeDynamoDB struct {
+	mu      sync.Mutex
+	created bool
+	items   map[string]dynamoDBItem
+}
+
+func (f *fakeDynamoDB) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+		http.Error(w, "unsigned request", http.StatusForbidden)
+		return
+	}
+	var input struct {
+		Item                      dynamoDBItem
+		Key                       dynamoDBItem
+		ConditionExpression       string
+		ExpressionAttributeValues dynamoDBItem
+		ExclusiveStartKey         dynamoDBItem
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fail := func(errorType string) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"__type":"com.amazonaws.dynamodb.v20120810#%s","message":"failed"}`, errorType)
+	}
+	values := input.ExpressionAttributeValues
+
+	switch strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "DynamoDB_20120810.") {
+	case "DescribeTable":
+		if !f.created {
+			fail("ResourceNotFoundException")
+			return
+		}
+		fmt.Fprint(w, `{"Table":{"TableStatus":"ACTIVE"}}`)
+	case "CreateTable":
+		f.created = true
+		fmt.Fprint(w, `{}`)
+	case "PutItem":
+		key := input.Item.string("filename")
+		if existing, ok := f.items[key]; ok && input.ConditionExpression != "" {
+			expiresAt, _ := existing.number("expires_at")
+			now, _ := values.number(":now")
+			if expiresAt >= now {
+				fail("ConditionalCheckFailedException")
+				return
+			}
+		}
+		f.items[key] = input.Item
+		fmt.Fprint(w, `{}`)
+	case "GetItem":
+		json.NewEncoder(w).Encode(map[string]interface{}{"Item": f.items[input.Key.string("filename")]})
+	case "UpdateItem", "DeleteItem":
+		key := input.Key.string("filename")
+		existing, ok := f.items[key]
+		switch {
+		case strings.Contains(input.ConditionExpression, "#owner") && (!ok || existing.string("owner") != values.string(":owner")):
+			fail("ConditionalCheckFailedException")
+			return
+		case strings.Contains(input.ConditionExpression, "#success") && (!ok || *existing["success"].BOOL):
+			fail("ConditionalCheckFailedException")
+			return
+		}
+		if r.Header.Get("X-Amz-Target") == "DynamoDB_20120810.DeleteItem" {
+			delete(f.items, key)
+		} else {
+			existing["expires_at"] = values[":expires_at"]
+		}
+		fmt.Fprint(w, `{}`)
+	case "Scan":
+		keys := make([]string, 0, len(f.items))
+		for key := range f.items {
+			if input.ExclusiveStartKey == nil || key > input.ExclusiveStartKey.string("filename") {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		page := map[string]interface{}{}
+		if len(keys) > 2 {
+			keys = keys[:2]
+			page["LastEvaluatedKey"] = dynamoDBItem{"filename": dynamoDBString(keys[1])}
+		}
+		items := make([]dynamoDBItem, 0, len(keys))
+		for _, key := range keys {
+			items = append(items, f.items[key])
+		}
+		page["Items"] = items
+		json.NewEncoder(w).Encode(page)
+	default:
+		fail("UnknownOperationException")
+	}
+}
+
+func setupFakeDynamoDB(t *testing.T) (*fakeDynamoDB, DynamoDBHistoryStore) {
+	fake := &fakeDynamoDB{items: make(map[string]dynamoDBItem)}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return fake, DynamoDBHistoryStore{Table: "gosmm_history", Region: "eu-west-1", Endpoint: server.URL,
+		Credentials: staticAWSCredentials(testAWSCredentials)}
+}
+
+func TestDynamoDBHistoryStore(t *testing.T) {
+	fake, store := setupFakeDynamoDB(t)
+	dir := t.TempDir()
+	err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER PRIMARY KEY);"), 0644)
+	assert.NoError(t, err)
+	err = ioutil.WriteFile(filepath.Join(dir, "v20230102_seed_users_00002.sql"), []byte("INSERT INTO users (id) VALUES (1);\nINSERT INTO missing (id) VALUES (1);"), 0644)
+	assert.NoError(t, err)
+	db, teardown := setupTestDB(t)
+	defer teardown()
+	db.SetMaxOpenConns(1)
+
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3", HistoryStore: store}
+	err = MigrateWithConfig(db, config)
+	var failure *ErrMigrationFailed
+	assert.ErrorAs(t, err, &failure)
+	assert.True(t, fake.created)
+	entries, err := store.Entries(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+	assert.True(t, entries[0].Success)
+	assert.False(t, entries[1].Success)
+	assert.Equal(t, 2, entries[1].FailedStatement)
+	assert.NotContains(t, fake.items, dynamoDBLockKey)
+	// the failed migration was rolled back
+	var count int
+	assert.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&count))
+	assert.Equal(t, 0, count)
+	// the history table of the migrated database is not used
+	exists, err := historyTableExists(db, "sqlite3", "")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	assert.ErrorIs(t, MigrateWithConfig(db, config), ErrDirtyState)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "v20230102_seed_users_00002.sql"), []byte("INSERT INTO users (id) VALUES (1);"), 0644)
+	assert.NoError(t, err)
+	config.ResumeMode = true
+	assert.NoError(t, MigrateWithConfig(db, config))
+	report, err := Status(db, config)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, report.Pending)
+	assert.Len(t, report.Migrations, 2)
+	assert.Equal(t, MigrationApplied, report.Migrations[1].State)
+	assert.Equal(t, 2, report.Migrations[1].InstalledRank)
+}
+
+func TestDynamoDBHistoryStoreLock(t *testing.T) {
+	fake, store := setupFakeDynamoDB(t)
+	fake.items[dynamoDBLockKey] = dynamoDBItem{
+		"filename":   dynamoDBString(dynamoDBLockKey),
+		"owner":      dynamoDBString("other:1:abcd"),
+		"expires_at": dynamoDBNumber(time.Now().Add(time.Minute).UnixMilli()),
+	}
+	_, err := store.Lock(context.Background(), 10*time.Millisecond)
+	assert.ErrorIs(t, err, ErrLockTimeout)
+	assert.Contains(t, err.Error(), "held by other:1:abcd")
+
+	// an expired lease is taken over
+	fake.items[dynamoDBLockKey]["expires_at"] = dynamoDBNumber(time.Now().Add(-time.Second).UnixMilli())
+	unlock, err := store.Lock(context.Background(), 10*time.Millisecond)
+	assert.NoError(t, err)
+	assert.NotEqual(t, "other:1:abcd", fake.items[dynamoDBLockKey].string("owner"))
+	assert.NoError(t, unlock())
+	assert.NotContains(t, fake.items, dynamoDBLockKey)
+}
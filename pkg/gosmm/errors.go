@@ -0,0 +1,75 @@
+package gosmm
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrDirtyState is returned when the history table holds a failed migration that must be restored first
+	ErrDirtyState = errors.New("cannot proceed, there is at least one failed migration")
+	// ErrChecksumMismatch is reported when an applied migration file was modified after it was applied
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	// ErrMissingFile is reported when a migration recorded in the history table no longer exists
+	ErrMissingFile = errors.New("executed migration file not found")
+	// ErrPendingMigrations is reported by Check when migrations were not applied
+	ErrPendingMigrations = errors.New("pending migrations")
+	// ErrLockTimeout is returned when the migration lock was not granted within MigrationConfig.WaitForLock
+	ErrLockTimeout = errors.New("timed out waiting for the migration lock")
+	// ErrUnsafeMigration is returned in MigrationConfig.ZeroDowntime mode for a pending migration taking long locks
+	ErrUnsafeMigration = errors.New("unsafe migration")
+	// ErrCleanNotAllowed is returned by Clean unless AllowClean is set
+	ErrCleanNotAllowed = errors.New("clean is disabled, set AllowClean to drop all objects")
+	// ErrReadOnlyDatabase is returned when the connected node is a read replica or otherwise read-only,
+	// before anything is applied
+	ErrReadOnlyDatabase = errors.New("cannot migrate a read-only database")
+	// ErrHistoryTableTooNew is returned when the history table was upgraded by a later version of gosmm,
+	// whose history records this version could not write correctly
+	ErrHistoryTableTooNew = errors.New("the history table was upgraded by a later version of gosmm")
+	// ErrUnsignedMigration is returned for a pending migration file not signed with a trusted key, see Signatures
+	ErrUnsignedMigration = errors.New("unsigned migration")
+	// ErrInterrupted is returned when a run was stopped by MigrationConfig.Stop or aborted by the cancellation
+	// of its context
+	ErrInterrupted = errors.New("migration run interrupted")
+	// ErrLeaseLost is returned when the lease of a run was taken by another run or could not be renewed before it
+	// expired, see MigrationConfig.Lease. The migration in progress is aborted, so that two runs never migrate
+	// concurrently.
+	ErrLeaseLost = errors.New("the migration lease was lost")
+	// ErrNotApproved is returned when the approval of a run was rejected or still pending after its timeout,
+	// see MigrationConfig.Approval
+	ErrNotApproved = errors.New("run not approved")
+	// ErrSchemaTooOld is returned by RequireVersion when the database lacks the migrations an application needs
+	ErrSchemaTooOld = errors.New("database schema is older than required")
+	// ErrInvalidIndex is reported for a migration leaving an invalid or disabled index, see MigrationConfig.VerifyIndexes
+	ErrInvalidIndex = errors.New("invalid index")
+)
+
+// ErrMigrationFailed is returned when a statement of a migration fails
+type ErrMigrationFailed struct {
+	// File is the name of the failed migration
+	File string
+	// Statement is the failed statement, empty for Go migrations
+	Statement string
+	// StatementIndex is the 1-based index of the failed statement in the file, zero for Go migrations
+	StatementIndex int
+	// CommittedStatements is the number of leading statements committed implicitly before the failure (MySQL)
+	CommittedStatements int
+	// Cause is the error returned by the database
+	Cause error
+}
+
+// Error returns the failed file and statement with the cause
+func (e *ErrMigrationFailed) Error() string {
+	if e.Statement == "" {
+		return fmt.Sprintf("failed to execute filename: %s, error: %v", e.File, e.Cause)
+	}
+	if e.CommittedStatements > 0 {
+		return fmt.Sprintf("failed to execute filename: %s, statement: %s, error: %v (statements 1-%d were committed implicitly and were not rolled back)", e.File, e.Statement, e.Cause, e.CommittedStatements)
+	}
+	return fmt.Sprintf("failed to execute filename: %s, statement: %s, error: %v", e.File, e.Statement, e.Cause)
+}
+
+// Unwrap returns the cause
+func (e *ErrMigrationFailed) Unwrap() error {
+	return e.Cause
+}
@@ -0,0 +1,26 @@
+package gosmm
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestErrMigrationFailed(t *testing.T) {
+	cause := errors.New("syntax error")
+	err := &ErrMigrationFailed{File: "v20230101_create_test_data_00001.sql", Statement: "CREATE TABLE", Cause: cause}
+	assert.Equal(t, "failed to execute filename: v20230101_create_test_data_00001.sql, statement: CREATE TABLE, error: syntax error", err.Error())
+	assert.ErrorIs(t, err, cause)
+
+	err.CommittedStatements = 2
+	assert.Contains(t, err.Error(), "(statements 1-2 were committed implicitly and were not rolled back)")
+}
+
+func TestValidationErrorIs(t *testing.T) {
+	err := &ValidationError{Issues: []ValidationIssue{{Kind: IssueOrderingGap}}}
+	assert.False(t, errors.Is(err, ErrChecksumMismatch))
+	assert.False(t, errors.Is(err, ErrMissingFile))
+
+	err.Issues = append(err.Issues, ValidationIssue{Kind: IssueMissingFile})
+	assert.True(t, errors.Is(err, ErrMissingFile))
+}
@@ -0,0 +1,193 @@
+package gosmm
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	updatePattern    = regexp.MustCompile(`(?is)^\s*UPDATE\s+(?:ONLY\s+)?([^\s(]+)`)
+	deletePattern    = regexp.MustCompile(`(?is)^\s*DELETE\s+FROM\s+(?:ONLY\s+)?([^\s(]+)`)
+	insertPattern    = regexp.MustCompile(`(?is)^\s*INSERT\s+(?:IGNORE\s+)?INTO\s+([^\s(]+)`)
+	truncatePattern  = regexp.MustCompile(`(?is)^\s*TRUNCATE\s+(?:TABLE\s+)?(?:ONLY\s+)?([^\s(,;]+)`)
+	dropTablePattern = regexp.MustCompile(`(?is)^\s*DROP\s+TABLE\s+(?:IF\s+EXISTS\s+)?([^\s(,;]+)`)
+)
+
+// MigrationEstimate describes the impact of a pending migration, see Estimate
+type MigrationEstimate struct {
+	Filename string `json:"filename"`
+	// Statements is the number of statements of the file, zero for Go migrations
+	Statements int `json:"statements"`
+	// Go is set for migrations written in Go, which cannot be estimated
+	Go bool `json:"go,omitempty"`
+	// Tables are the tables the statements create, alter, index, write or drop, in the order of the statements
+	Tables []TableEstimate `json:"tables,omitempty"`
+	// LongOperations describe the statements that may run long or block the traffic, e.g.
+	// "statement 2: UPDATE of the big table users (~2500000 rows)"
+	LongOperations []string `json:"long_operations,omitempty"`
+}
+
+// TableEstimate is the current size of a table referenced by a pending migration
+type TableEstimate struct {
+	Name string `json:"name"`
+	// New is set for a table created by a pending migration, which is empty: its Rows and Bytes are zero
+	New bool `json:"new,omitempty"`
+	// Rows is the estimated number of rows from the statistics of the database, counted on SQLite,
+	// -1 when unknown
+	Rows int64 `json:"rows"`
+	// Bytes is the size of the table with its indexes, -1 when unknown, e.g. on SQLite
+	Bytes int64 `json:"bytes"`
+}
+
+// Estimate returns, for each pending migration in the order MigrationConfig would apply them, its number of
+// statements, the tables it references with their current sizes read from the catalog of the database, and its
+// operations that may run long: those taking long locks, see ZeroDowntime, and the rewrites, index builds and
+// updates of big tables, see LintConfig.BigTableRows. It helps scheduling maintenance windows, and never modifies
+// the database.
+func Estimate(db *sql.DB, config MigrationConfig) ([]MigrationEstimate, error) {
+	plan, err := Plan(db, config)
+	if err != nil {
+		return nil, err
+	}
+	files, err := config.migrationFiles()
+	if err != nil {
+		return nil, err
+	}
+	paths := make(map[string]string, len(files))
+	for _, file := range files {
+		if file.inEnvironment(config.Environment) {
+			paths[file.name] = file.path
+		}
+	}
+	threshold := config.Lint.BigTableRows
+	if threshold <= 0 {
+		threshold = defaultBigTableRows
+	}
+
+	estimates := make([]MigrationEstimate, 0, len(plan))
+	created := make(map[string]bool)
+	sizes := make(map[string]TableEstimate)
+	for _, planned := range plan {
+		estimate := MigrationEstimate{Filename: planned.Filename, Statements: planned.Statements, Go: planned.Go}
+		if planned.Go {
+			estimates = append(estimates, estimate)
+			continue
+		}
+		data, err := config.readMigrationFile(paths[planned.Filename])
+		if err != nil {
+			return nil, err
+		}
+		content, err := replacePlaceholders(string(data), config.Placeholders)
+		if err != nil {
+			return nil, fmt.Errorf("failed to replace placeholders in %s: %w", planned.Filename, err)
+		}
+		content, _ = splitDown(content) // the down section is only executed by Redo
+
+		referenced := make(map[string]bool)
+		for index, statement := range splitStatements(content, config.Driver) {
+			code := lintCode(statement)
+			operation, table := statementTable(code)
+			if table == "" {
+				continue
+			}
+			key := normalizeTableName(table)
+			size, ok := sizes[key]
+			if operation == "CREATE TABLE" {
+				size = TableEstimate{Name: table, New: true}
+				sizes[key] = size
+			} else if !ok {
+				size = TableEstimate{Name: table}
+				size.Rows, size.Bytes = estimateTableSize(db, config.Driver, config.Schema, table)
+				sizes[key] = size
+			}
+			if !referenced[key] {
+				referenced[key] = true
+				estimate.Tables = append(estimate.Tables, size)
+			}
+
+			if reason := unsafeReason(config.Driver, code, created); reason != "" {
+				estimate.LongOperations = append(estimate.LongOperations, fmt.Sprintf("statement %d: %s", index+1, reason))
+			} else if size.Rows >= threshold && scansTable(operation) {
+				estimate.LongOperations = append(estimate.LongOperations, fmt.Sprintf("statement %d: %s of the big table %s (~%d rows)", index+1, operation, table, size.Rows))
+			}
+			if operation == "CREATE TABLE" {
+				created[key] = true
+			}
+		}
+		estimates = append(estimates, estimate)
+	}
+	return estimates, nil
+}
+
+// statementTable returns the operation of a statement without its comments and the table it applies to,
+// or an empty table for the other statements
+func statementTable(code string) (operation string, table string) {
+	for _, candidate := range []struct {
+		operation string
+		pattern   *regexp.Regexp
+		group     int
+	}{
+		{"CREATE TABLE", createTablePattern, 1},
+		{"ALTER TABLE", alterTablePattern, 1},
+		{"CREATE INDEX", createIndexPattern, 2},
+		{"UPDATE", updatePattern, 1},
+		{"DELETE", deletePattern, 1},
+		{"INSERT", insertPattern, 1},
+		{"TRUNCATE", truncatePattern, 1},
+		{"DROP TABLE", dropTablePattern, 1},
+	} {
+		if match := candidate.pattern.FindStringSubmatch(code); match != nil {
+			return candidate.operation, match[candidate.group]
+		}
+	}
+	return "", ""
+}
+
+// scansTable reports whether the operation reads or rewrites every row of the table
+func scansTable(operation string) bool {
+	switch operation {
+	case "ALTER TABLE", "CREATE INDEX", "UPDATE", "DELETE":
+		return true
+	}
+	return false
+}
+
+// estimateTableSize returns the estimated number of rows of the table and its size with its indexes in bytes,
+// -1 when unknown. The rows of SQLite tables, which have no statistics, are counted.
+func estimateTableSize(db *sql.DB, driver string, schema string, table string) (rows int64, size int64) {
+	rows, size = -1, -1
+	if estimated, ok := estimateTableRows(db, driver, schema, table); ok {
+		rows = estimated
+	}
+	if schema != "" && !strings.Contains(table, ".") {
+		table = quoteIdentifier(driver, schema) + "." + table
+	}
+	var bytes sql.NullInt64
+	var err error
+	switch driver {
+	case "postgres":
+		err = db.QueryRow(`SELECT pg_total_relation_size(to_regclass($1))`, table).Scan(&bytes)
+	case "sqlserver":
+		err = db.QueryRow(`SELECT SUM(a.total_pages) * 8192 FROM sys.partitions p JOIN sys.allocation_units a ON a.container_id = p.partition_id WHERE p.object_id = OBJECT_ID(@p1)`, table).Scan(&bytes)
+	case "mysql":
+		query := `SELECT data_length + index_length FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?`
+		args := []interface{}{table}
+		if schemaName, name, ok := strings.Cut(table, "."); ok {
+			query = `SELECT data_length + index_length FROM information_schema.tables WHERE table_schema = ? AND table_name = ?`
+			args = []interface{}{strings.Trim(schemaName, "`"), strings.Trim(name, "`")}
+		}
+		err = db.QueryRow(query, args...).Scan(&bytes)
+	case "sqlite3":
+		var count int64
+		if db.QueryRow(`SELECT COUNT(*) FROM `+table).Scan(&count) == nil {
+			rows = count
+		}
+		return rows, size
+	}
+	if err == nil && bytes.Valid {
+		size = bytes.Int64
+	}
+	return rows, size
+}
@@ -0,0 +1,80 @@
+package gosmm
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEstimate(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER, name TEXT);\nINSERT INTO users (id, name) VALUES (1, 'a'), (2, 'b'), (3, 'c');"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}
+	assert.NoError(t, MigrateWithConfig(db, config))
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230102_backfill_users_00002.sql"), []byte("ALTER TABLE users ADD COLUMN email TEXT;\n-- UPDATE orders SET id = 1;\nUPDATE users SET email = name || '@example.com';\nCREATE TABLE orders (id INTEGER, user_id INTEGER);\nCREATE INDEX idx_orders_user_id ON orders (user_id);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	config.GoMigrations = map[string]GoMigrationFunc{
+		"v20230103_seed_orders_00003": func(ctx context.Context, conn *sql.Conn, tx *sql.Tx) error {
+			return nil
+		},
+	}
+	config.Lint.BigTableRows = 3
+
+	estimates, err := Estimate(db, config)
+	assert.NoError(t, err)
+	assert.Equal(t, []MigrationEstimate{
+		{
+			Filename:   "v20230102_backfill_users_00002.sql",
+			Statements: 4,
+			Tables: []TableEstimate{
+				{Name: "users", Rows: 3, Bytes: -1},
+				{Name: "orders", New: true},
+			},
+			LongOperations: []string{
+				"statement 1: ALTER TABLE of the big table users (~3 rows)",
+				"statement 2: UPDATE of the big table users (~3 rows)",
+			},
+		},
+		{Filename: "v20230103_seed_orders_00003", Go: true},
+	}, estimates)
+
+	// Tables below the threshold are not flagged
+	config.Lint.BigTableRows = 4
+	estimates, err = Estimate(db, config)
+	assert.NoError(t, err)
+	assert.Empty(t, estimates[0].LongOperations)
+}
+
+func TestStatementTable(t *testing.T) {
+	tests := []struct {
+		code      string
+		operation string
+		table     string
+	}{
+		{"CREATE TABLE IF NOT EXISTS users (id INTEGER)", "CREATE TABLE", "users"},
+		{"ALTER TABLE public.users ADD COLUMN email TEXT", "ALTER TABLE", "public.users"},
+		{"CREATE UNIQUE INDEX CONCURRENTLY idx_users_email ON users (email)", "CREATE INDEX", "users"},
+		{"UPDATE users SET email = ''", "UPDATE", "users"},
+		{"DELETE FROM users WHERE id = 1", "DELETE", "users"},
+		{"INSERT INTO users(id) VALUES (1)", "INSERT", "users"},
+		{"TRUNCATE TABLE users", "TRUNCATE", "users"},
+		{"DROP TABLE IF EXISTS users", "DROP TABLE", "users"},
+		{"SELECT 1", "", ""},
+	}
+	for _, test := range tests {
+		operation, table := statementTable(test.code)
+		assert.Equal(t, test.operation, operation, test.code)
+		assert.Equal(t, test.table, table, test.code)
+	}
+}
@@ -0,0 +1,546 @@
+package gosmm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultListingInterval is the wait between two listings of a migration directory when
+// MigrationConfig.ListingInterval is zero
+const defaultListingInterval = 100 * time.Millisecond
+
+// environmentSuffixPattern matches the environment suffix of a file such as v20230101_seed_users_00002.dev.sql
+var environmentSuffixPattern = regexp.MustCompile(`\.([A-Za-z][A-Za-z0-9_-]*)\.sql$`)
+
+// migrationFile is an entry of one of the migration directories
+type migrationFile struct {
+	name  string
+	path  string
+	isDir bool
+	// environment is the environment the file is restricted to, empty when it runs in every environment
+	environment string
+	// metadata is read from the header of the file, see MigrationMetadata
+	metadata MigrationMetadata
+}
+
+// inEnvironment reports whether the file runs in the given environment, restricted by its directory or suffix
+// and by the gosmm:only-env directive of its header
+func (f migrationFile) inEnvironment(environment string) bool {
+	if f.environment != "" && f.environment != environment {
+		return false
+	}
+	if len(f.metadata.OnlyEnvironments) == 0 {
+		return true
+	}
+	for _, only := range f.metadata.OnlyEnvironments {
+		if only == environment {
+			return true
+		}
+	}
+	return false
+}
+
+// sharesEnvironment reports whether the files run in a common environment, so that a run could apply both
+func (f migrationFile) sharesEnvironment(other migrationFile) bool {
+	if f.environment != "" {
+		return f.inEnvironment(f.environment) && other.inEnvironment(f.environment)
+	}
+	if other.environment != "" {
+		return f.inEnvironment(other.environment) && other.inEnvironment(other.environment)
+	}
+	if len(f.metadata.OnlyEnvironments) == 0 || len(other.metadata.OnlyEnvironments) == 0 {
+		return true
+	}
+	for _, environment := range f.metadata.OnlyEnvironments {
+		if other.inEnvironment(environment) {
+			return true
+		}
+	}
+	return false
+}
+
+// shadowEnvironmentFiles leaves out the files sharing the name of another file, which are the same migration
+// written for different environments: the file of environment is kept, or the first one when none runs in it
+func shadowEnvironmentFiles(files []migrationFile, environment string) []migrationFile {
+	kept := make(map[string]int, len(files))
+	var result []migrationFile
+	for _, file := range files {
+		if file.isDir {
+			result = append(result, file)
+			continue
+		}
+		i, ok := kept[file.name]
+		if !ok {
+			kept[file.name] = len(result)
+			result = append(result, file)
+		} else if file.inEnvironment(environment) {
+			result[i] = file
+		}
+	}
+	return result
+}
+
+// skips reports whether the migration is listed in Skip, by its filename or its name without extension
+func (c MigrationConfig) skips(name string) bool {
+	for _, skipped := range c.Skip {
+		if skipped == name || skipped == strings.TrimSuffix(name, sqlFileExtension) {
+			return true
+		}
+	}
+	return false
+}
+
+// selects reports whether a migration with the given labels is applied by a run restricted to Labels
+func (c MigrationConfig) selects(labels []string) bool {
+	if len(c.Labels) == 0 {
+		return true
+	}
+	for _, label := range labels {
+		for _, selected := range c.Labels {
+			if label == selected {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// labelsOrder reports whether an applied migration orders a pending migration sorting before it, which is then
+// out of order. An unlabeled migration orders every migration, while a labeled one, which may have been applied
+// by a run restricted to its labels, only orders the migrations sharing one of its labels: the others were left
+// pending by the labels of the run rather than by the order of the migrations.
+func labelsOrder(applied []string, pending []string) bool {
+	if len(applied) == 0 {
+		return true
+	}
+	for _, label := range applied {
+		for _, other := range pending {
+			if label == other {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkLabeledRequires fails when a pending migration of a run restricted to Labels requires a migration that
+// is neither applied nor pending in the run
+func checkLabeledRequires(config MigrationConfig, pending []MigrationInfo, requires map[string][]string, executed map[string]bool) error {
+	if len(config.Labels) == 0 {
+		return nil
+	}
+	selected := make(map[string]bool, len(pending))
+	for _, migration := range pending {
+		selected[migration.Filename] = true
+	}
+	for _, migration := range pending {
+		for _, required := range requires[migration.Filename] {
+			if !executed[required] && !selected[required] {
+				return fmt.Errorf("migration %s requires %s, which is not applied and not labeled %s", migration.Filename, required, strings.Join(config.Labels, " or "))
+			}
+		}
+	}
+	return nil
+}
+
+// migrationDirs returns the configured migration directories, MigrationsDir first and SourceCacheDir last
+func (c MigrationConfig) migrationDirs() []string {
+	if len(c.MigrationsDirs) == 0 && c.Source == nil {
+		return []string{c.MigrationsDir}
+	}
+	dirs := make([]string, 0, 2+len(c.MigrationsDirs))
+	if c.MigrationsDir != "" {
+		dirs = append(dirs, c.MigrationsDir)
+	}
+	dirs = append(dirs, c.MigrationsDirs...)
+	if c.Source != nil {
+		dirs = append(dirs, c.SourceCacheDir)
+	}
+	return dirs
+}
+
+// dirListing configures the listings of the migration directories, see MigrationConfig.StableListings and
+// MigrationConfig.Ignore
+type dirListing struct {
+	// attempts is the maximum number of listings of a directory, one when zero
+	attempts int
+	interval time.Duration
+	// ignore holds the globs of the entries left out of the listings
+	ignore []string
+	// nested lists the subdirectories at any depth as organizing the migrations, see MigrationConfig.NestedDirs
+	nested bool
+}
+
+// listing returns the listing of the migration directories of MigrationConfig.StableListings,
+// MigrationConfig.Ignore and MigrationConfig.NestedDirs
+func (c MigrationConfig) listing() dirListing {
+	return dirListing{attempts: c.StableListings, interval: c.ListingInterval, ignore: c.Ignore, nested: c.NestedDirs}
+}
+
+// ignored reports whether an entry of a migration directory matches one of the ignore globs, rel being its
+// slash-separated path relative to the migration directory
+func (l dirListing) ignored(rel string) bool {
+	for _, pattern := range l.ignore {
+		if matchGlob(pattern, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateIgnore returns an error for the malformed ignore globs
+func (l dirListing) validateIgnore() error {
+	for _, pattern := range l.ignore {
+		if _, err := path.Match(strings.ReplaceAll(pattern, "**", "*"), ""); err != nil {
+			return fmt.Errorf("invalid ignore pattern %s: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// matchGlob reports whether the slash-separated path rel matches pattern, whose segments are matched by
+// path.Match and where a ** segment matches any number of segments, e.g. archive/** matches the archive
+// directory and everything below it. A pattern without a slash matches the name of the entry in any
+// directory, e.g. *.md.
+func matchGlob(pattern string, rel string) bool {
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(rel))
+		return ok
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(rel, "/"))
+}
+
+// matchSegments matches the segments of a path against those of a pattern, see matchGlob
+func matchSegments(pattern []string, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+		return false
+	}
+	return matchSegments(pattern[1:], segments[1:])
+}
+
+// readDir returns the entries of dir sorted by name, listing it again until two consecutive listings hold
+// the same entries when the listing takes several attempts
+func (l dirListing) readDir(dir string) ([]os.FileInfo, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil || l.attempts <= 1 {
+		return entries, err
+	}
+	interval := l.interval
+	if interval == 0 {
+		interval = defaultListingInterval
+	}
+	for attempt := 2; attempt <= l.attempts; attempt++ {
+		time.Sleep(interval)
+		again, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		if sameEntries(entries, again) {
+			return again, nil
+		}
+		entries = again
+	}
+	return nil, fmt.Errorf("the listing of %s kept changing over %d listings", dir, l.attempts)
+}
+
+// sameEntries reports whether two listings of a directory hold the same entries with the same sizes and
+// modification times
+func sameEntries(a []os.FileInfo, b []os.FileInfo) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Name() != b[i].Name() || a[i].IsDir() != b[i].IsDir() || a[i].Size() != b[i].Size() || !a[i].ModTime().Equal(b[i].ModTime()) {
+			return false
+		}
+	}
+	return true
+}
+
+// isTemporaryFile reports whether the entry of a migration directory is a hidden file or directory or a
+// temporary file of an editor, e.g. .v20230101_create_users_00001.sql.swp or v20230101_create_users_00001.sql~,
+// which are not migrations. The files left by NFS clients for the deleted files still open, .nfsXXXX, are hidden.
+func isTemporaryFile(name string) bool {
+	if strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~") || strings.HasPrefix(name, "#") && strings.HasSuffix(name, "#") {
+		return true
+	}
+	switch filepath.Ext(name) {
+	case ".swp", ".swo", ".swx", ".tmp":
+		return true
+	}
+	return false
+}
+
+// readMigrationFiles lists the entries of the migration directories merged and sorted by name,
+// following the vYYYYMMDD_description_NNNNN.sql convention, see readNamedMigrationFiles
+func readMigrationFiles(dirs []string, listing dirListing) ([]migrationFile, error) {
+	return readNamedMigrationFiles(dirs, migrationNaming{}, listing)
+}
+
+// migrationFiles lists the migration files of the configured directories in the order of their versions,
+// see MigrationConfig.FilenamePattern
+func (c MigrationConfig) migrationFiles() ([]migrationFile, error) {
+	naming, err := c.naming()
+	if err != nil {
+		return nil, err
+	}
+	return readNamedMigrationFiles(c.migrationDirs(), naming, c.listing())
+}
+
+// readNamedMigrationFiles lists the entries of the migration directories merged and sorted in the order of naming.
+// Subdirectories are environment directories, whose files are restricted to the environment named
+// after the directory, or organize the migrations at any depth when the listing is nested. Files of every
+// environment are returned, see migrationFile.inEnvironment.
+// Files with the same version in different directories are rejected when they run in a common environment, since
+// their order would be ambiguous, e.g. the same version in the dev and prod directories is allowed.
+// Files not matching a custom filename pattern are not migrations and are left out.
+func readNamedMigrationFiles(dirs []string, naming migrationNaming, listing dirListing) ([]migrationFile, error) {
+	if err := listing.validateIgnore(); err != nil {
+		return nil, err
+	}
+	var files []migrationFile
+	versions := make(map[string][]migrationFile)
+	names := make(map[string][]migrationFile)
+	for _, dir := range dirs {
+		entries, err := readDirFiles(dir, "", "", listing)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range entries {
+			if file.isDir {
+				files = append(files, file)
+				continue
+			}
+			version, ok := naming.version(file.name)
+			if !ok && naming.pattern != nil {
+				continue
+			}
+			for _, other := range versions[version] {
+				if filepath.Dir(other.path) != filepath.Dir(file.path) && other.sharesEnvironment(file) {
+					return nil, fmt.Errorf("duplicate migration version %s: %s and %s", version, other.path, file.path)
+				}
+			}
+			// a migration file and its template would be the same migration
+			for _, other := range names[file.name] {
+				if other.sharesEnvironment(file) {
+					return nil, fmt.Errorf("duplicate migration %s: %s and %s", file.name, other.path, file.path)
+				}
+			}
+			versions[version] = append(versions[version], file)
+			names[file.name] = append(names[file.name], file)
+			files = append(files, file)
+		}
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return naming.less(files[i].name, files[j].name)
+	})
+	return files, nil
+}
+
+// readDirFiles lists the entries of dir, rel being its slash-separated path relative to the migration directory.
+// At the top level (rel is empty), the files of subdirectories are listed instead of the subdirectories,
+// restricted to their environment. When the listing is nested, the files of the subdirectories at any depth are
+// listed instead, restricted to an environment by their suffix only.
+// Hidden and temporary files are left out, see isTemporaryFile, as well as the entries matching the ignore globs.
+func readDirFiles(dir string, rel string, environment string, listing dirListing) ([]migrationFile, error) {
+	entries, err := listing.readDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations directory: %w", err)
+	}
+	var files []migrationFile
+	for _, entry := range entries {
+		entryRel := path.Join(rel, entry.Name())
+		if isTemporaryFile(entry.Name()) || listing.ignored(entryRel) {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), signatureFileExtension) {
+			continue // the detached signature of a migration file, see Signatures
+		}
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), csvFileExtension) {
+			continue // the data of a load directive, see bulkLoad
+		}
+		if !entry.IsDir() && entry.Name() == sourceManifestFile && rel == "" {
+			continue // the ETags of the files fetched from a Source
+		}
+		if entry.IsDir() && listing.nested {
+			nestedFiles, err := readDirFiles(path, entryRel, "", listing)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, nestedFiles...)
+			continue
+		}
+		if entry.IsDir() && rel == "" {
+			envFiles, err := readDirFiles(path, entryRel, entry.Name(), listing)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, envFiles...)
+			continue
+		}
+		file := migrationFile{name: entry.Name(), path: path, isDir: entry.IsDir(), environment: environment}
+		if !file.isDir && strings.HasSuffix(file.name, templateFileExtension) {
+			// a template is the migration named after the file without .tmpl
+			file.name = strings.TrimSuffix(file.name, ".tmpl")
+		}
+		if match := environmentSuffixPattern.FindStringSubmatch(file.name); match != nil && !file.isDir {
+			if environment != "" && match[1] != environment {
+				return nil, fmt.Errorf("migration %s is in the %s environment directory but has the %s environment suffix", path, environment, match[1])
+			}
+			file.environment = match[1]
+		}
+		if !file.isDir {
+			if file.metadata, err = migrationFileMetadata(path); err != nil {
+				return nil, fmt.Errorf("invalid header of %s: %w", path, err)
+			}
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// migrationVersion returns the version of a migration file: the date and sequence number for files
+// following the vYYYYMMDD_description_NNNNN[.environment].sql convention, the name without extension otherwise
+func migrationVersion(filename string) string {
+	if match := migrationFilenamePattern.FindStringSubmatch(filename); match != nil {
+		return "v" + match[1] + "_" + match[3]
+	}
+	return strings.TrimSuffix(filename, filepath.Ext(filename))
+}
+
+const (
+	// FlywayFilenamePattern matches the versioned migrations of Flyway such as V1__create_users.sql or V1.1__add_email.sql
+	FlywayFilenamePattern = `^V(?P<version>\d+(?:[._]\d+)*)__(?P<description>.+)\.sql$`
+	// TimestampFilenamePattern matches migrations named after their creation time such as 20230101120000_create_users.sql,
+	// the convention of goose
+	TimestampFilenamePattern = `^(?P<version>\d{14})_(?P<description>.+)\.sql$`
+)
+
+// migrationNaming orders the migration files by the versions parsed from their names
+type migrationNaming struct {
+	// pattern is MigrationConfig.FilenamePattern, nil for the vYYYYMMDD_description_NNNNN.sql convention
+	pattern *regexp.Regexp
+	// versionIndex is the index of the version group of pattern
+	versionIndex int
+}
+
+// naming returns the naming of the migration files of MigrationConfig.FilenamePattern
+func (c MigrationConfig) naming() (migrationNaming, error) {
+	if c.FilenamePattern == "" {
+		return migrationNaming{}, nil
+	}
+	pattern, err := regexp.Compile(c.FilenamePattern)
+	if err != nil {
+		return migrationNaming{}, fmt.Errorf("invalid filename pattern: %w", err)
+	}
+	index := pattern.SubexpIndex("version")
+	if index < 0 {
+		return migrationNaming{}, fmt.Errorf("invalid filename pattern %s: missing the version group (?P<version>...)", c.FilenamePattern)
+	}
+	return migrationNaming{pattern: pattern, versionIndex: index}, nil
+}
+
+// version returns the version of a migration, and whether its name matches the pattern. Go migrations are
+// named like the files without their extension, so the pattern is also matched against their name with .sql.
+func (n migrationNaming) version(name string) (string, bool) {
+	if n.pattern == nil {
+		return migrationVersion(name), migrationFilenamePattern.MatchString(name)
+	}
+	match := n.pattern.FindStringSubmatch(name)
+	if match == nil {
+		match = n.pattern.FindStringSubmatch(name + sqlFileExtension)
+	}
+	if match == nil {
+		return strings.TrimSuffix(name, filepath.Ext(name)), false
+	}
+	return match[n.versionIndex], true
+}
+
+// describe returns the convention the filenames must follow, for error messages
+func (n migrationNaming) describe() string {
+	if n.pattern == nil {
+		return "vYYYYMMDD_description_NNNNN.sql"
+	}
+	return n.pattern.String()
+}
+
+// less reports whether the migration a is applied before b. The filenames of the vYYYYMMDD_description_NNNNN.sql
+// convention sort by name, the others by their versions compared as in compareVersions.
+func (n migrationNaming) less(a string, b string) bool {
+	if n.pattern == nil {
+		return a < b
+	}
+	versionA, _ := n.version(a)
+	versionB, _ := n.version(b)
+	if c := compareVersions(versionA, versionB); c != 0 {
+		return c < 0
+	}
+	return a < b
+}
+
+// compareVersions compares two versions by their runs of digits and of other characters, the runs of
+// digits by their numeric value, so that 2 sorts before 10 and 1.9 before 1.10. It returns -1, 0 or 1.
+func compareVersions(a string, b string) int {
+	for a != "" && b != "" {
+		runA, runB := versionRun(a), versionRun(b)
+		a, b = a[len(runA):], b[len(runB):]
+		digitsA, digitsB := isDigit(runA[0]), isDigit(runB[0])
+		if digitsA && digitsB {
+			runA, runB = strings.TrimLeft(runA, "0"), strings.TrimLeft(runB, "0")
+			if len(runA) != len(runB) {
+				if len(runA) < len(runB) {
+					return -1
+				}
+				return 1
+			}
+		}
+		if c := strings.Compare(runA, runB); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case a == b:
+		return 0
+	case a == "":
+		return -1
+	default:
+		return 1
+	}
+}
+
+// versionRun returns the leading run of digits or of other characters of the non-empty s
+func versionRun(s string) string {
+	digits := isDigit(s[0])
+	for i := 1; i < len(s); i++ {
+		if isDigit(s[i]) != digits {
+			return s[:i]
+		}
+	}
+	return s
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
@@ -0,0 +1,580 @@
+package gosmm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrateWithMultipleMigrationsDirs(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	coreDir := t.TempDir()
+	billingDir := t.TempDir()
+	files := map[string]string{
+		filepath.Join(coreDir, "v20230101_create_users_00001.sql"):       "CREATE TABLE users (id INTEGER PRIMARY KEY);",
+		filepath.Join(billingDir, "v20230102_create_invoices_00002.sql"): "CREATE TABLE invoices (id INTEGER, user_id INTEGER REFERENCES users (id));",
+		filepath.Join(coreDir, "v20230103_add_email_00003.sql"):          "ALTER TABLE users ADD COLUMN email TEXT;",
+	}
+	for path, content := range files {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test migration file: %v", err)
+		}
+	}
+
+	err := MigrateWithConfig(db, MigrationConfig{
+		MigrationsDir:  coreDir,
+		MigrationsDirs: []string{billingDir},
+		Driver:         "sqlite3",
+	})
+	assert.NoError(t, err)
+
+	// The migrations are applied in version order across directories
+	history, err := GetHistory(db, MigrationConfig{Driver: "sqlite3"})
+	assert.NoError(t, err)
+	if assert.Len(t, history, 3) {
+		assert.Equal(t, "v20230101_create_users_00001.sql", history[0].Filename)
+		assert.Equal(t, "v20230102_create_invoices_00002.sql", history[1].Filename)
+		assert.Equal(t, "v20230103_add_email_00003.sql", history[2].Filename)
+	}
+
+	err = Validate(db, MigrationConfig{MigrationsDir: coreDir, MigrationsDirs: []string{billingDir}, Driver: "sqlite3"})
+	assert.NoError(t, err)
+}
+
+func TestMigrateWithDuplicateVersionInMigrationsDirs(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	coreDir := t.TempDir()
+	billingDir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(coreDir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(billingDir, "v20230101_create_invoices_00001.sql"), []byte("CREATE TABLE invoices (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+
+	err := MigrateWithConfig(db, MigrationConfig{
+		MigrationsDir:  coreDir,
+		MigrationsDirs: []string{billingDir},
+		Driver:         "sqlite3",
+	})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "duplicate migration version v20230101_00001")
+	}
+
+	// Nothing is applied
+	var exists bool
+	err = db.QueryRow("SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'users')").Scan(&exists)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestMigrateWithEnvironment(t *testing.T) {
+	migrationsDir := t.TempDir()
+	seedsDir := t.TempDir()
+	for _, env := range []string{"dev", "prod"} {
+		if err := os.Mkdir(filepath.Join(seedsDir, env), 0755); err != nil {
+			t.Fatalf("Failed to create environment directory: %v", err)
+		}
+	}
+	files := map[string]string{
+		filepath.Join(migrationsDir, "v20230101_create_users_00001.sql"):         "CREATE TABLE users (name TEXT);",
+		filepath.Join(migrationsDir, "v20230102_seed_test_users_00002.test.sql"): "INSERT INTO users (name) VALUES ('test');",
+		filepath.Join(seedsDir, "dev", "v20230103_seed_demo_users_00003.sql"):    "INSERT INTO users (name) VALUES ('demo');",
+		filepath.Join(seedsDir, "prod", "v20230104_seed_admin_user_00004.sql"):   "INSERT INTO users (name) VALUES ('admin');",
+		filepath.Join(migrationsDir, "v20230105_add_email_00005.sql"):            "ALTER TABLE users ADD COLUMN email TEXT;",
+	}
+	for path, content := range files {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test migration file: %v", err)
+		}
+	}
+
+	for _, tc := range []struct {
+		environment string
+		users       []string
+	}{
+		{environment: "", users: nil},
+		{environment: "dev", users: []string{"demo"}},
+		{environment: "prod", users: []string{"admin"}},
+		{environment: "test", users: []string{"test"}},
+	} {
+		db, teardown := setupTestDB(t)
+
+		config := MigrationConfig{
+			MigrationsDir:  migrationsDir,
+			MigrationsDirs: []string{seedsDir},
+			Driver:         "sqlite3",
+			Environment:    tc.environment,
+		}
+		err := MigrateWithConfig(db, config)
+		assert.NoError(t, err)
+
+		var users []string
+		rows, err := db.Query("SELECT name FROM users ORDER BY name")
+		if err != nil {
+			t.Fatalf("Failed to query users: %v", err)
+		}
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				t.Fatalf("Failed to read user: %v", err)
+			}
+			users = append(users, name)
+		}
+		rows.Close()
+		assert.Equal(t, tc.users, users, "environment %q", tc.environment)
+
+		// The migrations of other environments are neither missing nor gaps
+		err = Validate(db, config)
+		assert.NoError(t, err, "environment %q", tc.environment)
+
+		teardown()
+	}
+}
+
+func TestMigrateWithOnlyEnvironments(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"v20230101_create_users_00001.sql": "CREATE TABLE users (name TEXT);",
+		"v20230102_seed_admin_00002.sql":   "-- gosmm:only-env prod staging\nINSERT INTO users (name) VALUES ('admin');",
+		"v20230103_add_email_00003.sql":    "ALTER TABLE users ADD COLUMN email TEXT;",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test migration file: %v", err)
+		}
+	}
+
+	for _, tc := range []struct {
+		environment string
+		users       int
+	}{
+		{environment: "", users: 0},
+		{environment: "dev", users: 0},
+		{environment: "prod", users: 1},
+		{environment: "staging", users: 1},
+	} {
+		db, teardown := setupTestDB(t)
+
+		config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3", Environment: tc.environment}
+		assert.NoError(t, MigrateWithConfig(db, config))
+		var users int
+		assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM users").Scan(&users))
+		assert.Equal(t, tc.users, users, "environment %q", tc.environment)
+		assert.NoError(t, Validate(db, config), "environment %q", tc.environment)
+
+		teardown()
+	}
+}
+
+func TestMigrateWithSameVersionInEnvironments(t *testing.T) {
+	dir := t.TempDir()
+	for _, env := range []string{"dev", "prod"} {
+		if err := os.Mkdir(filepath.Join(dir, env), 0755); err != nil {
+			t.Fatalf("Failed to create environment directory: %v", err)
+		}
+	}
+	files := map[string]string{
+		"v20230101_create_users_00001.sql":                     "CREATE TABLE users (name TEXT);",
+		filepath.Join("dev", "v20230102_seed_00002.sql"):       "INSERT INTO users (name) VALUES ('demo');",
+		filepath.Join("prod", "v20230102_seed_00002.sql"):      "INSERT INTO users (name) VALUES ('admin');",
+		filepath.Join("dev", "v20230103_seed_dev_00003.sql"):   "INSERT INTO users (name) VALUES ('tester');",
+		filepath.Join("prod", "v20230103_seed_prod_00003.sql"): "INSERT INTO users (name) VALUES ('operator');",
+		"v20230104_add_email_00004.sql":                        "ALTER TABLE users ADD COLUMN email TEXT;",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test migration file: %v", err)
+		}
+	}
+
+	// The files of the dev and prod directories never run together, so they may share a version and a name
+	for _, tc := range []struct {
+		environment string
+		users       []string
+	}{
+		{environment: "", users: nil},
+		{environment: "dev", users: []string{"demo", "tester"}},
+		{environment: "prod", users: []string{"admin", "operator"}},
+	} {
+		db, teardown := setupTestDB(t)
+
+		config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3", Environment: tc.environment}
+		assert.NoError(t, MigrateWithConfig(db, config), "environment %q", tc.environment)
+		var users []string
+		rows, err := db.Query("SELECT name FROM users ORDER BY name")
+		if err != nil {
+			t.Fatalf("Failed to query users: %v", err)
+		}
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				t.Fatalf("Failed to read user: %v", err)
+			}
+			users = append(users, name)
+		}
+		rows.Close()
+		assert.Equal(t, tc.users, users, "environment %q", tc.environment)
+		assert.NoError(t, Validate(db, config), "environment %q", tc.environment)
+
+		renumbered, err := FixDuplicateSequences(db, config)
+		assert.NoError(t, err)
+		assert.Empty(t, renumbered, "environment %q", tc.environment)
+
+		teardown()
+	}
+
+	// A file without environment runs together with those of every environment
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230102_seed_all_00002.sql"), []byte("SELECT 1;"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	_, err := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}.migrationFiles()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "duplicate migration version v20230102_00002")
+	}
+}
+
+func TestMigrateWithSkip(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"v20230101_create_users_00001.sql": "CREATE TABLE users (name TEXT);",
+		"v20230102_create_fdw_00002.sql":   "CREATE EXTENSION postgres_fdw;",
+		"v20230103_add_email_00003.sql":    "ALTER TABLE users ADD COLUMN email TEXT;",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test migration file: %v", err)
+		}
+	}
+
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3", Skip: []string{"v20230102_create_fdw_00002"}}
+	assert.NoError(t, MigrateWithConfig(db, config))
+	_, err := db.Exec("SELECT email FROM users")
+	assert.NoError(t, err)
+
+	report, err := Status(db, config)
+	assert.NoError(t, err)
+	assert.Equal(t, StateUpToDate, report.State)
+	assert.Equal(t, 2, report.Applied)
+	assert.Equal(t, 1, report.Skipped)
+	if assert.Len(t, report.Migrations, 3) {
+		assert.Equal(t, "v20230102_create_fdw_00002.sql", report.Migrations[2].Filename)
+		assert.Equal(t, MigrationSkipped, report.Migrations[2].State)
+	}
+
+	// without the skip list, the migration is pending
+	report, err = Status(db, MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"})
+	assert.NoError(t, err)
+	assert.Equal(t, StatePending, report.State)
+	assert.Equal(t, 1, report.Pending)
+}
+
+func TestMigrateWithLabels(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"v20230101_create_users_00001.sql":    "-- gosmm:labels core\nCREATE TABLE users (id INTEGER);",
+		"v20230102_create_invoices_00002.sql": "-- gosmm:labels billing\nCREATE TABLE invoices (id INTEGER);",
+		"v20230103_create_orders_00003.sql":   "-- gosmm:labels shop\nCREATE TABLE orders (id INTEGER);",
+		"v20230104_add_total_00004.sql":       "-- gosmm:labels billing, critical\nALTER TABLE invoices ADD COLUMN total INTEGER;",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test migration file: %v", err)
+		}
+	}
+
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3", Labels: []string{"billing"}}
+	plan, err := Plan(db, config)
+	assert.NoError(t, err)
+	assert.Equal(t, []PlannedMigration{
+		{Filename: "v20230102_create_invoices_00002.sql", Statements: 1},
+		{Filename: "v20230104_add_total_00004.sql", Statements: 1},
+	}, plan)
+	assert.NoError(t, MigrateWithConfig(db, config))
+	report, err := Status(db, config)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, report.Applied)
+	assert.Equal(t, 2, report.Pending)
+	if assert.Len(t, report.Migrations, 4) {
+		assert.Equal(t, []string{"billing", "critical"}, report.Migrations[1].Labels)
+	}
+
+	// the migrations of another label sorting before those applied are not out of order
+	config.Labels = []string{"core", "shop"}
+	assert.NoError(t, MigrateWithConfig(db, config))
+	report, err = Status(db, config)
+	assert.NoError(t, err)
+	assert.Equal(t, StateUpToDate, report.State)
+	assert.NoError(t, Validate(db, config))
+}
+
+func TestMigrateWithLabelsThenUnrestricted(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"v20230101_create_users_00001.sql":    "CREATE TABLE users (id INTEGER);",
+		"v20230102_create_orders_00002.sql":   "-- gosmm:labels shop\nCREATE TABLE orders (id INTEGER);",
+		"v20230103_create_invoices_00003.sql": "-- gosmm:labels billing\nCREATE TABLE invoices (id INTEGER);",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test migration file: %v", err)
+		}
+	}
+	assert.NoError(t, MigrateWithConfig(db, MigrationConfig{MigrationsDir: dir, Driver: "sqlite3", Labels: []string{"billing"}}))
+
+	// the migrations left pending by the labels of the previous run are in order for an unrestricted run
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}
+	assert.NoError(t, MigrateWithConfig(db, config))
+	assert.NoError(t, Validate(db, config))
+
+	// a pending migration of the label of an applied one sorting after it is out of order
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230102_add_total_00004.sql"), []byte("-- gosmm:labels billing\nALTER TABLE invoices ADD COLUMN total INTEGER;"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	err := MigrateWithConfig(db, config)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "out-of-order migration detected: v20230102_add_total_00004.sql sorts before the latest applied migration v20230103_create_invoices_00003.sql")
+	}
+}
+
+func TestMigrateWithLabelsRequires(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"v20230101_create_users_00001.sql":    "CREATE TABLE users (id INTEGER);",
+		"v20230102_create_invoices_00002.sql": "-- gosmm:labels billing\n-- gosmm:requires v20230101_create_users_00001.sql\nCREATE TABLE invoices (user_id INTEGER REFERENCES users (id));",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test migration file: %v", err)
+		}
+	}
+
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3", Labels: []string{"billing"}}
+	assert.EqualError(t, MigrateWithConfig(db, config), "migration v20230102_create_invoices_00002.sql requires v20230101_create_users_00001.sql, which is not applied and not labeled billing")
+	report, err := Status(db, config)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, report.Applied)
+
+	// once the required migration is applied, the labeled one can be applied alone
+	assert.NoError(t, Apply(db, MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}, "v20230101_create_users_00001.sql", false))
+	assert.NoError(t, MigrateWithConfig(db, config))
+}
+
+func TestReadMigrationFilesWithConflictingEnvironment(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "dev"), 0755); err != nil {
+		t.Fatalf("Failed to create environment directory: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "dev", "v20230101_seed_users_00001.prod.sql"), []byte("SELECT 1;"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+
+	_, err := readMigrationFiles([]string{dir}, dirListing{})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "has the prod environment suffix")
+	}
+}
+
+func TestMigrationVersion(t *testing.T) {
+	assert.Equal(t, "v20230101_00001", migrationVersion("v20230101_create_users_00001.sql"))
+	assert.Equal(t, "v20230101_00002", migrationVersion("v20230101_seed_users_00002.dev.sql"))
+	assert.Equal(t, "seed_users", migrationVersion("seed_users.sql"))
+}
+
+func TestCompareVersions(t *testing.T) {
+	assert.Equal(t, -1, compareVersions("2", "10"))
+	assert.Equal(t, -1, compareVersions("1.9", "1.10"))
+	assert.Equal(t, 1, compareVersions("1.2.1", "1.2"))
+	assert.Equal(t, 0, compareVersions("01", "1"))
+	assert.Equal(t, -1, compareVersions("20230101120000", "20230102000000"))
+}
+
+func TestReadMigrationFilesWithFilenamePattern(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"V10__add_email.sql", "V2__add_name.sql", "V1__create_users.sql", "V1.1__index_users.sql", "R__views.sql"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("SELECT 1;"), 0644); err != nil {
+			t.Fatalf("Failed to create test migration file: %v", err)
+		}
+	}
+
+	files, err := MigrationConfig{MigrationsDir: dir, FilenamePattern: FlywayFilenamePattern}.migrationFiles()
+	assert.NoError(t, err)
+	var names []string
+	for _, file := range files {
+		names = append(names, file.name)
+	}
+	assert.Equal(t, []string{"V1__create_users.sql", "V1.1__index_users.sql", "V2__add_name.sql", "V10__add_email.sql"}, names)
+
+	_, err = MigrationConfig{MigrationsDir: dir, FilenamePattern: `^V\d+__.+\.sql$`}.migrationFiles()
+	assert.EqualError(t, err, `invalid filename pattern ^V\d+__.+\.sql$: missing the version group (?P<version>...)`)
+}
+
+func TestMigrateWithTemporaryFiles(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}
+	assert.NoError(t, MigrateWithConfig(db, config))
+
+	// an editor open on the migration files leaves hidden and temporary files next to them
+	for _, name := range []string{".v20230101_create_users_00001.sql.swp", "v20230101_create_users_00001.sql~", "#v20230102_add_email_00002.sql#", ".nfs000000000001", "v20230102_add_email_00002.sql.tmp"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("garbage"), 0644); err != nil {
+			t.Fatalf("Failed to create temporary file: %v", err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, ".snapshot"), 0755); err != nil {
+		t.Fatalf("Failed to create hidden directory: %v", err)
+	}
+	assert.NoError(t, MigrateWithConfig(db, config))
+	assert.NoError(t, Validate(db, config))
+}
+
+func TestReadMigrationFilesWithStableListings(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"v20230102_add_email_00002.sql", "v20230101_create_users_00001.sql"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("SELECT 1;"), 0644); err != nil {
+			t.Fatalf("Failed to create test migration file: %v", err)
+		}
+	}
+
+	files, err := readMigrationFiles([]string{dir}, dirListing{attempts: 3, interval: time.Millisecond})
+	assert.NoError(t, err)
+	if assert.Len(t, files, 2) {
+		assert.Equal(t, "v20230101_create_users_00001.sql", files[0].name)
+		assert.Equal(t, "v20230102_add_email_00002.sql", files[1].name)
+	}
+
+	// the listings of a directory being written to do not agree
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Failed to read directory: %v", err)
+	}
+	assert.True(t, sameEntries(entries, entries))
+	assert.False(t, sameEntries(entries, entries[:1]))
+}
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		rel     string
+		match   bool
+	}{
+		{"README.md", "README.md", true},
+		{"*.md", "README.md", true},
+		{"*.md", "dev/notes.md", true},
+		{"*.md", "v20230101_create_users_00001.sql", false},
+		{"archive/**", "archive", true},
+		{"archive/**", "archive/v20220101_create_users_00001.sql", true},
+		{"archive/**", "dev/archive", false},
+		{"**/notes.txt", "dev/notes.txt", true},
+		{"dev/*.txt", "dev/notes.txt", true},
+		{"dev/*.txt", "notes.txt", false},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.match, matchGlob(test.pattern, test.rel), test.pattern+" "+test.rel)
+	}
+}
+
+func TestMigrateWithIgnore(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "archive"), 0755); err != nil {
+		t.Fatalf("Failed to create archive directory: %v", err)
+	}
+	files := map[string]string{
+		filepath.Join(dir, "v20230101_create_users_00001.sql"):             "CREATE TABLE users (id INTEGER);",
+		filepath.Join(dir, "README.md"):                                    "# Migrations",
+		filepath.Join(dir, "archive", "v20220101_create_legacy_00001.sql"): "CREATE TABLE legacy (id INTEGER);",
+	}
+	for path, content := range files {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}
+	assert.Error(t, MigrateWithConfig(db, config))
+
+	config.Ignore = []string{"*.md", "archive/**"}
+	assert.NoError(t, MigrateWithConfig(db, config))
+	assert.NoError(t, Validate(db, config))
+	history, err := GetHistory(db, config)
+	assert.NoError(t, err)
+	if assert.Len(t, history, 1) {
+		assert.Equal(t, "v20230101_create_users_00001.sql", history[0].Filename)
+	}
+
+	config.Ignore = []string{"[.md"}
+	assert.EqualError(t, Validate(db, config), "invalid ignore pattern [.md: syntax error in pattern")
+}
+
+func TestMigrateWithNestedDirs(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	for _, sub := range []string{"2023", filepath.Join("2024", "billing")} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatalf("Failed to create subdirectory: %v", err)
+		}
+	}
+	files := map[string]string{
+		filepath.Join(dir, "2023", "v20230101_create_users_00001.sql"):               "CREATE TABLE users (id INTEGER PRIMARY KEY);",
+		filepath.Join(dir, "2024", "billing", "v20240102_create_invoices_00003.sql"): "CREATE TABLE invoices (id INTEGER, user_id INTEGER REFERENCES users (id));",
+		filepath.Join(dir, "2023", "v20231231_add_email_00002.sql"):                  "ALTER TABLE users ADD COLUMN email TEXT;",
+		filepath.Join(dir, "2024", "v20240103_seed_users_00004.dev.sql"):             "INSERT INTO users (id) VALUES (1);",
+	}
+	for path, content := range files {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test migration file: %v", err)
+		}
+	}
+
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3", NestedDirs: true}
+	assert.NoError(t, MigrateWithConfig(db, config))
+	assert.NoError(t, Validate(db, config))
+
+	// The migrations are applied in version order across subdirectories, the dev one being left out
+	history, err := GetHistory(db, config)
+	assert.NoError(t, err)
+	if assert.Len(t, history, 3) {
+		assert.Equal(t, "v20230101_create_users_00001.sql", history[0].Filename)
+		assert.Equal(t, "v20231231_add_email_00002.sql", history[1].Filename)
+		assert.Equal(t, "v20240102_create_invoices_00003.sql", history[2].Filename)
+	}
+
+	// The same version in two subdirectories is ambiguous
+	if err := ioutil.WriteFile(filepath.Join(dir, "2024", "v20231231_add_name_00002.sql"), []byte("ALTER TABLE users ADD COLUMN name TEXT;"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	_, err = config.migrationFiles()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "duplicate migration version v20231231_00002")
+	}
+}
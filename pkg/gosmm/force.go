@@ -0,0 +1,170 @@
+package gosmm
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+// DirtyMigrations returns the failed migrations recorded in the history table, which make the database dirty.
+// It does not create the history table, and returns none when it doesn't exist.
+func DirtyMigrations(db *sql.DB, config MigrationConfig) ([]string, error) {
+	exists, err := historyTableExists(db, config.Driver, config.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check history table: %w", err)
+	}
+	if !exists {
+		return nil, nil
+	}
+
+	rows, err := db.Query("SELECT filename FROM " + historyTableName(config.Driver, config.Schema) + " WHERE success = " + boolLiteral(config.Driver, false) + " ORDER BY installed_rank ASC")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query failed migrations: %w", err)
+	}
+	defer rows.Close()
+
+	var dirty []string
+	for rows.Next() {
+		var filename string
+		if err := rows.Scan(&filename); err != nil {
+			return nil, fmt.Errorf("failed to read row: %w", err)
+		}
+		dirty = append(dirty, filename)
+	}
+	return dirty, rows.Err()
+}
+
+// Force marks a migration as applied or not applied in the history table without executing it,
+// after an operator fixed the schema by hand. Marking it as applied replaces its failed record, if any,
+// with a successful one holding the checksum of its file. Marking it as not applied deletes its records,
+// so the next run executes it again.
+func Force(db *sql.DB, config MigrationConfig, filename string, applied bool) error {
+	return force(db, config, filename, applied, false)
+}
+
+// MarkApplied records a migration as applied without executing it, e.g. after a DBA applied its change by hand
+// during an incident, so that the history reflects the database. filename may be the path of the migration file.
+// Unlike Force, it refuses a migration already recorded in the history table, applied or failed.
+func MarkApplied(db *sql.DB, config MigrationConfig, filename string) error {
+	return force(db, config, filepath.Base(filename), true, true)
+}
+
+// force records a migration as applied or not applied, see Force. When unrecorded is set,
+// a migration already recorded in the history table is an error.
+func force(db *sql.DB, config MigrationConfig, filename string, applied bool, unrecorded bool) error {
+	if !isSupportedDriver(config.Driver) {
+		return fmt.Errorf("unsupported driver: %s", config.Driver)
+	}
+	table := historyTableName(config.Driver, config.Schema)
+
+	cockroach, err := isCockroachDB(db, config.Driver)
+	if err != nil {
+		return fmt.Errorf("failed to detect database version: %w", err)
+	}
+	unlock, err := lockRun(context.Background(), db, config, table, cockroach, nil)
+	if err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+	defer unlock()
+
+	if err := createHistoryTable(db, config.Driver, config.Schema); err != nil {
+		return fmt.Errorf("failed to create history table: %w", err)
+	}
+
+	if unrecorded {
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE filename = "+bindParams(config.Driver, 1), filename).Scan(&count); err != nil {
+			return fmt.Errorf("failed to query history of %s: %w", filename, err)
+		}
+		if count > 0 {
+			return fmt.Errorf("migration %s is already recorded in the history table, use force to replace its record", filename)
+		}
+	}
+
+	migration := MigrationInfo{Filename: filename}
+	if applied {
+		if _, ok := config.GoMigrations[filename]; !ok {
+			file, err := findMigrationFile(config, filename)
+			if err != nil {
+				return err
+			}
+			data, err := config.readMigrationFile(file.path)
+			if err != nil {
+				return err
+			}
+			if migration.Checksum, err = config.checksum(data); err != nil {
+				return err
+			}
+			migration.Metadata = file.metadata
+		}
+		migration.InstalledRank, err = forcedInstalledRank(db, config.Driver, table, filename)
+		if err != nil {
+			return fmt.Errorf("failed to determine installed_rank: %w", err)
+		}
+		migration.AppliedBy, migration.Context, err = auditMigrations(db, config)
+		if err != nil {
+			return err
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM "+table+" WHERE filename = "+bindParams(config.Driver, 1), filename); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("failed to delete history of %s: %w, and failed to rollback: %v", filename, err, rbErr)
+		}
+		return fmt.Errorf("failed to delete history of %s: %w", filename, err)
+	}
+	if !applied {
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit transaction: %w", err)
+		}
+		config.LogLevel.printf(LogInfo, "FORCE %s (not applied)\n", filename)
+		return nil
+	}
+	if err := recordMigration(tx, table, migration, time.Now(), true, nil, config.Driver); err != nil {
+		return err
+	}
+	if unrecorded {
+		config.LogLevel.printf(LogInfo, "MARK  %s (applied)\n", filename)
+	} else {
+		config.LogLevel.printf(LogInfo, "FORCE %s (applied)\n", filename)
+	}
+	return nil
+}
+
+// findMigrationFile returns the migration file named filename in the migration directories, that of
+// config.Environment when the file is written for several environments
+func findMigrationFile(config MigrationConfig, filename string) (migrationFile, error) {
+	files, err := config.migrationFiles()
+	if err != nil {
+		return migrationFile{}, err
+	}
+	for _, file := range shadowEnvironmentFiles(files, config.Environment) {
+		if file.name == filename && !file.isDir {
+			return file, nil
+		}
+	}
+	return migrationFile{}, fmt.Errorf("%w: %s", ErrMissingFile, filename)
+}
+
+// forcedInstalledRank returns the installed_rank of the failed record of the migration,
+// or the rank following the last recorded migration when there is none
+func forcedInstalledRank(db *sql.DB, driver string, table string, filename string) (int, error) {
+	var rank sql.NullInt64
+	err := db.QueryRow("SELECT MAX(installed_rank) FROM "+table+" WHERE filename = "+bindParams(driver, 1), filename).Scan(&rank)
+	if err != nil {
+		return 0, err
+	}
+	if rank.Valid {
+		return int(rank.Int64), nil
+	}
+	if err := db.QueryRow("SELECT MAX(installed_rank) FROM " + table).Scan(&rank); err != nil {
+		return 0, err
+	}
+	return int(rank.Int64) + 1, nil
+}
@@ -0,0 +1,145 @@
+package gosmm
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForceApplied(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230102_add_email_00002.sql"), []byte("ALTER TABLE users ADD COLUMN email TEXT; ALTER TABLE missing_table ADD COLUMN email TEXT;"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}
+
+	err := MigrateWithConfig(db, config)
+	assert.Error(t, err)
+
+	dirty, err := DirtyMigrations(db, config)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"v20230102_add_email_00002.sql"}, dirty)
+
+	// The operator fixes the schema by hand and marks the migration as applied
+	_, err = db.Exec("ALTER TABLE users ADD COLUMN email TEXT")
+	if err != nil {
+		t.Fatalf("Failed to fix the schema: %v", err)
+	}
+	err = Force(db, config, "v20230102_add_email_00002.sql", true)
+	assert.NoError(t, err)
+
+	dirty, err = DirtyMigrations(db, config)
+	assert.NoError(t, err)
+	assert.Empty(t, dirty)
+
+	var rank int
+	var success bool
+	var checksum string
+	err = db.QueryRow("SELECT installed_rank, success, checksum FROM gosmm_migration_history WHERE filename = 'v20230102_add_email_00002.sql'").Scan(&rank, &success, &checksum)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, rank)
+	assert.True(t, success)
+	data, _ := ioutil.ReadFile(filepath.Join(dir, "v20230102_add_email_00002.sql"))
+	assert.Equal(t, calculateChecksum(data), checksum)
+
+	// The forced migration is not executed again
+	err = MigrateWithConfig(db, config)
+	assert.NoError(t, err)
+	assert.NoError(t, Validate(db, config))
+}
+
+func TestForceNotApplied(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}
+
+	err := MigrateWithConfig(db, config)
+	assert.NoError(t, err)
+
+	// The operator drops the table by hand and marks the migration as not applied
+	_, err = db.Exec("DROP TABLE users")
+	if err != nil {
+		t.Fatalf("Failed to drop users table: %v", err)
+	}
+	err = Force(db, config, "v20230101_create_users_00001.sql", false)
+	assert.NoError(t, err)
+
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM gosmm_migration_history").Scan(&count)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	// The migration is executed again
+	err = MigrateWithConfig(db, config)
+	assert.NoError(t, err)
+	err = db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count)
+	assert.NoError(t, err)
+}
+
+func TestForceAppliedWithMissingFile(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	err := Force(db, MigrationConfig{MigrationsDir: t.TempDir(), Driver: "sqlite3"}, "v20230101_missing_00001.sql", true)
+	assert.ErrorIs(t, err, ErrMissingFile)
+}
+
+func TestMarkApplied(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230102_add_email_00002.sql"), []byte("-- gosmm:ticket INC-42\nALTER TABLE users ADD COLUMN email TEXT;"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}
+
+	// The DBA applied both changes by hand during an incident
+	if _, err := db.Exec("CREATE TABLE users (id INTEGER, email TEXT)"); err != nil {
+		t.Fatalf("Failed to apply the change: %v", err)
+	}
+	assert.NoError(t, MarkApplied(db, config, "v20230101_create_users_00001.sql"))
+	assert.NoError(t, MarkApplied(db, config, filepath.Join(dir, "v20230102_add_email_00002.sql")))
+
+	history, err := GetHistory(db, config)
+	assert.NoError(t, err)
+	if assert.Len(t, history, 2) {
+		assert.Equal(t, 2, history[1].InstalledRank)
+		assert.True(t, history[1].Success)
+		assert.Equal(t, "INC-42", history[1].Ticket)
+	}
+
+	// The marked migrations are not executed
+	assert.NoError(t, MigrateWithConfig(db, config))
+	assert.NoError(t, Validate(db, config))
+
+	err = MarkApplied(db, config, "v20230101_create_users_00001.sql")
+	assert.EqualError(t, err, "migration v20230101_create_users_00001.sql is already recorded in the history table, use force to replace its record")
+	err = MarkApplied(db, config, "v20230103_missing_00003.sql")
+	assert.ErrorIs(t, err, ErrMissingFile)
+}
+
+func TestDirtyMigrationsWithoutHistoryTable(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dirty, err := DirtyMigrations(db, MigrationConfig{Driver: "sqlite3"})
+	assert.NoError(t, err)
+	assert.Empty(t, dirty)
+}
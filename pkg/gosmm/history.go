@@ -0,0 +1,245 @@
+package gosmm
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// HistoryFormat is the output format of ExportHistory
+type HistoryFormat string
+
+const (
+	// HistoryFormatJSON writes the history as a JSON array
+	HistoryFormatJSON HistoryFormat = "json"
+	// HistoryFormatCSV writes the history as CSV with a header row
+	HistoryFormatCSV HistoryFormat = "csv"
+)
+
+// HistoryEntry is a row of the migration history table
+type HistoryEntry struct {
+	InstalledRank int       `json:"installed_rank"`
+	Filename      string    `json:"filename"`
+	InstalledOn   time.Time `json:"installed_on"`
+	// ExecutionTime is the execution time in milliseconds
+	ExecutionTime int64 `json:"execution_time"`
+	// RowsAffected is the number of rows affected by the statements of the migration, zero when unknown
+	RowsAffected int64  `json:"rows_affected,omitempty"`
+	Success      bool   `json:"success"`
+	Checksum     string `json:"checksum,omitempty"`
+	// FailedStatement is the 1-based index of the failed statement, zero when unknown or successful
+	FailedStatement     int `json:"failed_statement,omitempty"`
+	CommittedStatements int `json:"committed_statements,omitempty"`
+	// Author, Ticket and Description are read from the header of the migration file, see MigrationMetadata
+	Author      string `json:"author,omitempty"`
+	Ticket      string `json:"ticket,omitempty"`
+	Description string `json:"description,omitempty"`
+	// Backup is the reference of the backup taken before the migration, see MigrationConfig.Backup
+	Backup string `json:"backup,omitempty"`
+	// AppliedBy is the user who applied the migration, see MigrationConfig.AuditHost
+	AppliedBy string `json:"applied_by,omitempty"`
+	// Context is the free-form context the migration was applied in, see MigrationConfig.Context
+	Context string `json:"context,omitempty"`
+	// Approval is the id of the approval of the run, see MigrationConfig.Approval
+	Approval string `json:"approval,omitempty"`
+	// Ahead reports whether the migration was applied ahead of pending migrations sorting before it, see Apply
+	Ahead bool `json:"ahead,omitempty"`
+}
+
+// historyCSVHeader is the header row written by ExportHistory in CSV format
+var historyCSVHeader = []string{"installed_rank", "filename", "installed_on", "execution_time", "success", "checksum", "failed_statement", "committed_statements", "author", "ticket", "description", "backup", "applied_by", "context", "rows_affected", "approval", "ahead"}
+
+// GetHistory returns the rows of the migration history table ordered by installed_rank, or the entries of
+// config.HistoryStore. It does not create the history table, and returns no rows when it doesn't exist.
+func GetHistory(db *sql.DB, config MigrationConfig) ([]HistoryEntry, error) {
+	if config.HistoryStore != nil {
+		return config.HistoryStore.Entries(context.Background())
+	}
+	exists, err := historyTableExists(db, config.Driver, config.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check history table: %w", err)
+	}
+	if !exists {
+		return []HistoryEntry{}, nil
+	}
+
+	table := historyTableName(config.Driver, config.Schema)
+	query := `SELECT installed_rank, filename, installed_on, execution_time, success, ` +
+		historyColumnOrNull(db, table, "checksum") + `, ` +
+		historyColumnOrNull(db, table, "failed_statement") + `, ` +
+		historyColumnOrNull(db, table, "committed_statements") + `, ` +
+		historyColumnOrNull(db, table, "author") + `, ` +
+		historyColumnOrNull(db, table, "ticket") + `, ` +
+		historyColumnOrNull(db, table, "description") + `, ` +
+		historyColumnOrNull(db, table, "backup") + `, ` +
+		historyColumnOrNull(db, table, "applied_by") + `, ` +
+		historyColumnOrNull(db, table, "context") + `, ` +
+		historyColumnOrNull(db, table, "rows_affected") + `, ` +
+		historyColumnOrNull(db, table, "approval") + `, ` +
+		historyColumnOrNull(db, table, "ahead") +
+		` FROM ` + table + ` ORDER BY installed_rank ASC`
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query history table: %w", err)
+	}
+	defer rows.Close()
+
+	history := make([]HistoryEntry, 0)
+	for rows.Next() {
+		var (
+			entry               HistoryEntry
+			installedOn         timestamp
+			checksum            sql.NullString
+			failedStatement     sql.NullInt64
+			committedStatements sql.NullInt64
+			author              sql.NullString
+			ticket              sql.NullString
+			description         sql.NullString
+			backup              sql.NullString
+			appliedBy           sql.NullString
+			auditContext        sql.NullString
+			rowsAffected        sql.NullInt64
+			approval            sql.NullString
+			ahead               sql.NullInt64
+		)
+		err := rows.Scan(&entry.InstalledRank, &entry.Filename, &installedOn, &entry.ExecutionTime, &entry.Success,
+			&checksum, &failedStatement, &committedStatements, &author, &ticket, &description, &backup, &appliedBy, &auditContext, &rowsAffected, &approval, &ahead)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read row: %w", err)
+		}
+		entry.InstalledOn = installedOn.Time
+		entry.Checksum = checksum.String
+		entry.FailedStatement = int(failedStatement.Int64)
+		entry.CommittedStatements = int(committedStatements.Int64)
+		entry.Author, entry.Ticket, entry.Description = author.String, ticket.String, description.String
+		entry.Backup = backup.String
+		entry.AppliedBy, entry.Context = appliedBy.String, auditContext.String
+		entry.RowsAffected, entry.Approval = rowsAffected.Int64, approval.String
+		entry.Ahead = ahead.Int64 == 1
+		history = append(history, entry)
+	}
+	return history, rows.Err()
+}
+
+// ExportHistory writes the full migration history to w in the given format
+func ExportHistory(db *sql.DB, config MigrationConfig, w io.Writer, format HistoryFormat) error {
+	if format != HistoryFormatJSON && format != HistoryFormatCSV {
+		return fmt.Errorf("unsupported history format: %s", format)
+	}
+
+	history, err := GetHistory(db, config)
+	if err != nil {
+		return err
+	}
+	return writeHistory(w, history, format)
+}
+
+// writeHistory writes the history entries to w in the given format
+func writeHistory(w io.Writer, history []HistoryEntry, format HistoryFormat) error {
+	if format == HistoryFormatJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(history)
+	}
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(historyCSVHeader); err != nil {
+		return err
+	}
+	for _, entry := range history {
+		record := []string{
+			strconv.Itoa(entry.InstalledRank),
+			entry.Filename,
+			entry.InstalledOn.Format(time.RFC3339Nano),
+			strconv.FormatInt(entry.ExecutionTime, 10),
+			strconv.FormatBool(entry.Success),
+			entry.Checksum,
+			optionalInt(int64(entry.FailedStatement)),
+			optionalInt(int64(entry.CommittedStatements)),
+			entry.Author,
+			entry.Ticket,
+			entry.Description,
+			entry.Backup,
+			entry.AppliedBy,
+			entry.Context,
+			optionalInt(entry.RowsAffected),
+			entry.Approval,
+			optionalBool(entry.Ahead),
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// optionalInt formats n, or "" when it is zero
+func optionalInt(n int64) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// optionalBool formats b, or "" when it is false
+func optionalBool(b bool) string {
+	if !b {
+		return ""
+	}
+	return strconv.FormatBool(b)
+}
+
+// historyColumnOrNull returns column, or NULL if the history table was created before the column was added
+func historyColumnOrNull(db *sql.DB, table string, column string) string {
+	if historyColumnExists(db, table, column) {
+		return column
+	}
+	return "NULL"
+}
+
+// timestampLayouts are the text formats drivers return timestamps in
+var timestampLayouts = []string{
+	"2006-01-02 15:04:05.999999999-07:00",
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+}
+
+// timestamp scans an installed_on value, which drivers return either as time.Time or as text
+type timestamp struct {
+	time.Time
+}
+
+// Scan implements sql.Scanner
+func (t *timestamp) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		t.Time = time.Time{}
+		return nil
+	case time.Time:
+		t.Time = v
+		return nil
+	case []byte:
+		return t.parse(string(v))
+	case string:
+		return t.parse(v)
+	default:
+		return fmt.Errorf("unsupported timestamp value: %T", value)
+	}
+}
+
+func (t *timestamp) parse(s string) error {
+	for _, layout := range timestampLayouts {
+		if parsed, err := time.Parse(layout, s); err == nil {
+			t.Time = parsed
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported timestamp format: %s", s)
+}
@@ -0,0 +1,98 @@
+package gosmm
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExportHistoryAsJSON(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	// Create test_migrations directory if it doesn't exist
+	if _, err := os.Stat(migrationsDir); os.IsNotExist(err) {
+		err := os.Mkdir(migrationsDir, 0755)
+		if err != nil {
+			t.Fatalf("Failed to create test_migrations directory: %v", err)
+		}
+	}
+
+	// Create and apply a test migration file in the test_migrations directory
+	testMigrationFile := filepath.Join(migrationsDir, "v20230101_create_test_data_00001.sql")
+	if err := ioutil.WriteFile(testMigrationFile, []byte("CREATE TABLE test_table (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	defer os.Remove(testMigrationFile)
+
+	config := MigrationConfig{MigrationsDir: migrationsDir, Driver: "sqlite3"}
+	err := MigrateWithConfig(db, config)
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	err = ExportHistory(db, config, &buf, HistoryFormatJSON)
+	assert.NoError(t, err)
+
+	var history []HistoryEntry
+	if err := json.Unmarshal(buf.Bytes(), &history); err != nil {
+		t.Fatalf("Failed to decode history: %v", err)
+	}
+	if assert.Len(t, history, 1) {
+		assert.Equal(t, 1, history[0].InstalledRank)
+		assert.Equal(t, "v20230101_create_test_data_00001.sql", history[0].Filename)
+		assert.True(t, history[0].Success)
+		assert.Equal(t, calculateChecksum([]byte("CREATE TABLE test_table (id INTEGER);")), history[0].Checksum)
+		assert.WithinDuration(t, time.Now(), history[0].InstalledOn, time.Minute)
+	}
+}
+
+func TestHistoryRowsAffected(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+	dir := t.TempDir()
+	err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER, active BOOLEAN);\nINSERT INTO users (id, active) VALUES (1, FALSE), (2, FALSE), (3, TRUE);\nUPDATE users SET active = TRUE WHERE NOT active;"), 0644)
+	assert.NoError(t, err)
+
+	var afterEach MigrationInfo
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3", Hooks: Hooks{AfterEach: func(migration MigrationInfo) error {
+		afterEach = migration
+		return nil
+	}}}
+	assert.NoError(t, MigrateWithConfig(db, config))
+	assert.Equal(t, int64(5), afterEach.RowsAffected)
+	history, err := GetHistory(db, config)
+	assert.NoError(t, err)
+	if assert.Len(t, history, 1) {
+		assert.Equal(t, int64(5), history[0].RowsAffected)
+	}
+	report, err := Status(db, config)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), report.Migrations[0].RowsAffected)
+}
+
+func TestExportHistoryWithoutHistoryTable(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	var buf bytes.Buffer
+	err := ExportHistory(db, MigrationConfig{Driver: "sqlite3"}, &buf, HistoryFormatJSON)
+	assert.NoError(t, err)
+	assert.Equal(t, "[]\n", buf.String())
+
+	err = ExportHistory(db, MigrationConfig{Driver: "sqlite3"}, &buf, "xml")
+	assert.Error(t, err)
+}
+
+func TestTimestampScan(t *testing.T) {
+	var ts timestamp
+	assert.NoError(t, ts.Scan([]byte("2023-01-02 03:04:05.123")))
+	assert.Equal(t, time.Date(2023, 1, 2, 3, 4, 5, 123000000, time.UTC), ts.Time)
+	assert.NoError(t, ts.Scan("2023-01-02 03:04:05+09:00"))
+	assert.Equal(t, 18, ts.UTC().Hour())
+	assert.Error(t, ts.Scan("yesterday"))
+}
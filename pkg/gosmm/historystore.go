@@ -0,0 +1,178 @@
+package gosmm
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// HistoryStore keeps the migration history and serializes the runs outside of the migrated database, see
+// MigrationConfig.HistoryStore. SQLHistoryStore keeps them in the history table of another database, e.g. a
+// control-plane Postgres database, and DynamoDBHistoryStore in a DynamoDB table.
+type HistoryStore interface {
+	// Init creates the storage of the history if it doesn't exist
+	Init(ctx context.Context) error
+	// Lock takes a lock serializing the runs sharing the history and returns a function releasing it. It fails
+	// with ErrLockTimeout when the lock was not granted within wait, unless wait is zero.
+	Lock(ctx context.Context, wait time.Duration) (func() error, error)
+	// Entries returns the history ordered by installed_rank
+	Entries(ctx context.Context) ([]HistoryEntry, error)
+	// Record adds an entry to the history, replacing the failed entry of the same migration if there is one
+	Record(ctx context.Context, entry HistoryEntry) error
+	// RemoveFailed removes the failed entry of the migration if there is one
+	RemoveFailed(ctx context.Context, filename string) error
+}
+
+// SQLHistoryStore is a HistoryStore keeping the history in the history table of a database other than the
+// migrated one, e.g. a control-plane Postgres database shared by the pipelines migrating several engines
+type SQLHistoryStore struct {
+	// DB is the database holding the history table
+	DB *sql.DB
+	// Driver is the driver of DB
+	Driver string
+	// Schema is the schema holding the history table, the default schema of the connection when empty
+	Schema string
+	// Lease serializes the runs with a lease instead of a database lock, see MigrationConfig.Lease
+	Lease time.Duration
+}
+
+// config returns the configuration of the history table of s
+func (s SQLHistoryStore) config() MigrationConfig {
+	return MigrationConfig{Driver: s.Driver, Schema: s.Schema, Lease: s.Lease}
+}
+
+// Init implements HistoryStore
+func (s SQLHistoryStore) Init(context.Context) error {
+	if err := createHistoryTable(s.DB, s.Driver, s.Schema); err != nil {
+		return fmt.Errorf("failed to create history table: %w", err)
+	}
+	return nil
+}
+
+// Lock implements HistoryStore with the lock of the database, or a lease with Lease
+func (s SQLHistoryStore) Lock(ctx context.Context, wait time.Duration) (func() error, error) {
+	cockroach, err := isCockroachDB(s.DB, s.Driver)
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect database version: %w", err)
+	}
+	config := s.config()
+	config.WaitForLock = wait
+	return lockRun(ctx, s.DB, config, historyTableName(s.Driver, s.Schema), cockroach, nil)
+}
+
+// Entries implements HistoryStore
+func (s SQLHistoryStore) Entries(context.Context) ([]HistoryEntry, error) {
+	return GetHistory(s.DB, s.config())
+}
+
+// Record implements HistoryStore
+func (s SQLHistoryStore) Record(ctx context.Context, entry HistoryEntry) error {
+	table := historyTableName(s.Driver, s.Schema)
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, deleteStartQuery(table, s.Driver), entry.Filename); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to delete failed record of %s: %w", entry.Filename, err)
+	}
+	return recordHistoryEntry(tx, table, entry, s.Driver)
+}
+
+// RemoveFailed implements HistoryStore
+func (s SQLHistoryStore) RemoveFailed(ctx context.Context, filename string) error {
+	_, err := s.DB.ExecContext(ctx, deleteStartQuery(historyTableName(s.Driver, s.Schema), s.Driver), filename)
+	return err
+}
+
+// loadStoredHistory initializes config.HistoryStore and returns the state of its history, taking the failed
+// migrations out of it in ResumeMode. Squashed baselines are not adopted.
+func loadStoredHistory(ctx context.Context, config MigrationConfig) (historyState, error) {
+	store := config.HistoryStore
+	history := historyState{executed: make(map[string]bool), resumed: make(map[string]int), ahead: make(map[string]bool)}
+	if err := store.Init(ctx); err != nil {
+		return history, fmt.Errorf("failed to initialize history store: %w", err)
+	}
+	entries, err := store.Entries(ctx)
+	if err != nil {
+		return history, fmt.Errorf("failed to load migration history: %w", err)
+	}
+
+	// successful is consumed by checkMissingMigrations
+	successful := make(map[string]bool)
+	failed := make(map[string]int)
+	for _, entry := range entries {
+		if !entry.Success {
+			failed[entry.Filename] = entry.CommittedStatements
+			continue
+		}
+		history.executed[entry.Filename] = true
+		history.ahead[entry.Filename] = entry.Ahead
+		successful[entry.Filename] = true
+		if entry.InstalledRank > history.lastInstalledRank {
+			history.lastInstalledRank = entry.InstalledRank
+		}
+	}
+	if err := checkMissingMigrations(successful, config.migrationDirs(), config.listing(), config.GoMigrations); err != nil {
+		return history, fmt.Errorf("failed to check migration integrity: %w", err)
+	}
+
+	if len(failed) > 0 {
+		if !config.ResumeMode {
+			return history, ErrDirtyState
+		}
+		for filename := range failed {
+			if err := store.RemoveFailed(ctx, filename); err != nil {
+				return history, fmt.Errorf("failed to resume failed migration: %w", err)
+			}
+		}
+		history.resumed = failed
+	}
+	return history, nil
+}
+
+// executeAndRecordInStore executes the migration in tx, or outside of a transaction when tx is nil, and
+// records its outcome in store once tx is committed or rolled back, replacing the record of its start.
+// Failures for which retryable returns true are rolled back without recording a failed migration.
+func executeAndRecordInStore(ctx context.Context, conn *sql.Conn, tx *sql.Tx, store HistoryStore, migration *MigrationInfo, execute func(ctx context.Context, conn *sql.Conn, tx *sql.Tx) error, retryable func(error) bool) error {
+	startTime := time.Now()
+	if err := executeMigration(ctx, conn, tx, *migration, execute); err != nil {
+		if tx != nil {
+			tx.Rollback()
+		}
+		if ctx.Err() != nil {
+			return &abortedMigration{cause: err}
+		}
+		if retryable(err) {
+			store.RemoveFailed(ctx, migration.Filename)
+			return err // the caller retries the migration, so the failure is not recorded
+		}
+		var failure *ErrMigrationFailed
+		errors.As(err, &failure)
+		if e := store.Record(ctx, historyEntry(*migration, startTime, false, failure)); e != nil {
+			return fmt.Errorf("failed to record migration error: %w original error: %w", e, err)
+		}
+		return err
+	}
+
+	if tx != nil {
+		if err := tx.Commit(); err != nil {
+			if isConnectionError(err) {
+				return &commitUnknownError{File: migration.Filename, Cause: err}
+			}
+			if ctx.Err() != nil {
+				return &abortedMigration{cause: err}
+			}
+			return fmt.Errorf("failed to commit transaction: %w", err)
+		}
+	}
+	// the statements are committed, so they are recorded even when the run was aborted meanwhile
+	ctx, cancel := uncanceled(ctx)
+	defer cancel()
+	if err := store.Record(ctx, historyEntry(*migration, startTime, true, nil)); err != nil {
+		return fmt.Errorf("failed to record migration error: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package gosmm
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSQLHistoryStore(t *testing.T) {
+	dir := t.TempDir()
+	err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER PRIMARY KEY);"), 0644)
+	assert.NoError(t, err)
+	err = ioutil.WriteFile(filepath.Join(dir, "v20230102_seed_users_00002.sql"), []byte("-- gosmm:author alice\nINSERT INTO users (id) VALUES (1);\nINSERT INTO users (id) VALUES (1);"), 0644)
+	assert.NoError(t, err)
+	db, teardown := setupTestDB(t)
+	defer teardown()
+	db.SetMaxOpenConns(1)
+	controlDB, teardownControl := setupTestDB(t)
+	defer teardownControl()
+	controlDB.SetMaxOpenConns(1)
+
+	store := SQLHistoryStore{DB: controlDB, Driver: "sqlite3", Lease: time.Minute}
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3", HistoryStore: store}
+	var failure *ErrMigrationFailed
+	assert.ErrorAs(t, MigrateWithConfig(db, config), &failure)
+	// the transaction of the failed migration was rolled back, and its failure recorded in the store
+	var count int
+	assert.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&count))
+	assert.Equal(t, 0, count)
+	entries, err := store.Entries(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+	assert.False(t, entries[1].Success)
+	assert.Equal(t, "alice", entries[1].Author)
+	assert.Equal(t, 2, entries[1].FailedStatement)
+	exists, err := historyTableExists(db, "sqlite3", "")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	// the lease was released
+	assert.NoError(t, controlDB.QueryRow(`SELECT COUNT(*) FROM gosmm_migration_lock`).Scan(&count))
+	assert.Equal(t, 0, count)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "v20230102_seed_users_00002.sql"), []byte("INSERT INTO users (id) VALUES (1);"), 0644)
+	assert.NoError(t, err)
+	config.ResumeMode = true
+	assert.NoError(t, MigrateWithConfig(db, config))
+	history, err := GetHistory(db, config)
+	assert.NoError(t, err)
+	assert.Len(t, history, 2)
+	assert.True(t, history[1].Success)
+	assert.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&count))
+	assert.Equal(t, 1, count)
+}
@@ -0,0 +1,68 @@
+package gosmm
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const (
+	// historyVersionTable records the versions the history table was upgraded to, one row per upgrade
+	historyVersionTable = "gosmm_migration_history_version"
+	// historyTableVersion is the version of the history table created and upgraded to by this version of gosmm,
+	// the last version of historyColumnUpgrades
+	historyTableVersion = 12
+)
+
+// historyVersionTableName returns the history version table name, qualified with the schema if one is given
+func historyVersionTableName(driver string, schema string) string {
+	if schema == "" {
+		return historyVersionTable
+	}
+	return quoteIdentifier(driver, schema) + "." + historyVersionTable
+}
+
+// checkHistoryTableVersion upgrades the history table of schema to historyTableVersion and records it, or fails
+// with ErrHistoryTableTooNew when it was upgraded by a later version of gosmm. A history table without a recorded
+// version, created before the history table was versioned, is upgraded from version 1.
+func checkHistoryTableVersion(db *sql.DB, driver string, schema string) error {
+	table := historyVersionTableName(driver, schema)
+	if _, err := db.Exec(historyVersionTableDDL(driver, table)); err != nil {
+		return fmt.Errorf("failed to create history version table: %w", err)
+	}
+	version, err := readHistoryTableVersion(db, table)
+	if err != nil {
+		return err
+	}
+	if version > historyTableVersion {
+		return fmt.Errorf("%w: the history table is version %d, this version of gosmm supports version %d", ErrHistoryTableTooNew, version, historyTableVersion)
+	}
+	if version == historyTableVersion {
+		return nil
+	}
+
+	if err := upgradeHistoryTable(db, driver, historyTableName(driver, schema), version); err != nil {
+		return err
+	}
+	query := `INSERT INTO ` + table + ` (version, upgraded_on) VALUES (` + bindParams(driver, 2) + `)`
+	if _, err := db.Exec(query, historyTableVersion, time.Now().UTC()); err != nil {
+		// a concurrent run recorded the upgrade first
+		if recorded, readErr := readHistoryTableVersion(db, table); readErr == nil && recorded >= historyTableVersion {
+			return nil
+		}
+		return fmt.Errorf("failed to record history table version: %w", err)
+	}
+	return nil
+}
+
+// readHistoryTableVersion returns the version the history table was last upgraded to, 1 when none was recorded
+func readHistoryTableVersion(db *sql.DB, table string) (int, error) {
+	var version sql.NullInt64
+	if err := db.QueryRow(`SELECT MAX(version) FROM ` + table).Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read history table version: %w", err)
+	}
+	if !version.Valid {
+		return 1, nil
+	}
+	return int(version.Int64), nil
+}
@@ -0,0 +1,48 @@
+package gosmm
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistoryTableVersionIsLastUpgrade(t *testing.T) {
+	assert.Equal(t, historyTableVersion, historyColumnUpgrades[len(historyColumnUpgrades)-1].version)
+}
+
+func TestCheckHistoryTableVersionUpgradesLegacyTable(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	// the history table of the first version of gosmm
+	_, err := db.Exec(`CREATE TABLE gosmm_migration_history (filename VARCHAR(255) PRIMARY KEY, installed_on TIMESTAMP NOT NULL, execution_time INTEGER NOT NULL, success BOOLEAN NOT NULL)`)
+	assert.NoError(t, err)
+
+	assert.NoError(t, createHistoryTable(db, "sqlite3", ""))
+	for _, column := range historyColumnUpgrades {
+		assert.True(t, historyColumnExists(db, migrationHistoryTable, column.name), column.name)
+	}
+	version, err := readHistoryTableVersion(db, historyVersionTable)
+	assert.NoError(t, err)
+	assert.Equal(t, historyTableVersion, version)
+
+	// the version is only recorded once
+	assert.NoError(t, createHistoryTable(db, "sqlite3", ""))
+	var count int
+	assert.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM `+historyVersionTable).Scan(&count))
+	assert.Equal(t, 1, count)
+}
+
+func TestCheckHistoryTableVersionTooNew(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	assert.NoError(t, createHistoryTable(db, "sqlite3", ""))
+	_, err := db.Exec(`INSERT INTO `+historyVersionTable+` (version, upgraded_on) VALUES (?, CURRENT_TIMESTAMP)`, historyTableVersion+1)
+	assert.NoError(t, err)
+
+	err = createHistoryTable(db, "sqlite3", "")
+	assert.ErrorIs(t, err, ErrHistoryTableTooNew)
+	assert.Contains(t, err.Error(), fmt.Sprintf("the history table is version %d", historyTableVersion+1))
+}
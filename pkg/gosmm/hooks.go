@@ -0,0 +1,125 @@
+package gosmm
+
+import (
+	"sync"
+	"time"
+)
+
+// MigrationInfo holds the metadata of a migration passed to hooks
+type MigrationInfo struct {
+	// InstalledRank is the rank the migration is (or will be) recorded with
+	InstalledRank int
+	// Filename is the name of the migration file
+	Filename string
+	// Checksum is the SHA-256 checksum of the migration file. It is empty before the file has been read.
+	Checksum string
+	// ExecutionTime is how long the migration took. It is zero before the migration has run.
+	ExecutionTime time.Duration
+	// RowsAffected is the number of rows affected by the statements of the migration, as reported by the driver.
+	// It is zero before the migration has run, and for Go migrations and online schema changes.
+	RowsAffected int64
+	// Metadata is read from the header of the migration file. It is empty before the file has been read.
+	Metadata MigrationMetadata
+	// Backup is the reference of the backup taken before the migration, see MigrationConfig.Backup
+	Backup string
+	// AppliedBy and Context are recorded in the history table, see MigrationConfig.AuditHost and
+	// MigrationConfig.Context
+	AppliedBy string
+	Context   string
+	// Approval is the id of the approval of the run, see MigrationConfig.Approval
+	Approval string
+	// Ahead reports whether the migration is applied ahead of pending migrations sorting before it, see Apply
+	Ahead bool
+}
+
+// Hooks holds callbacks invoked around a migration run.
+// Every hook is optional. An error returned by a hook aborts the run.
+type Hooks struct {
+	// BeforeAll is called once with the pending migrations before any of them is executed
+	BeforeAll func(pending []MigrationInfo) error
+	// AfterAll is called once with the applied migrations after all of them succeeded
+	AfterAll func(applied []MigrationInfo) error
+	// BeforeEach is called before each migration is executed
+	BeforeEach func(migration MigrationInfo) error
+	// AfterEach is called after each migration has been executed and recorded
+	AfterEach func(migration MigrationInfo) error
+	// OnError is called once when the run fails, whatever the failure, e.g. on the migration lock, the history
+	// table, a check of the pending migrations or a hook. migration is the zero value if the failure
+	// is not related to a specific migration.
+	OnError func(migration MigrationInfo, err error)
+}
+
+func (h Hooks) beforeAll(pending []MigrationInfo) error {
+	if h.BeforeAll == nil {
+		return nil
+	}
+	return h.BeforeAll(pending)
+}
+
+func (h Hooks) afterAll(applied []MigrationInfo) error {
+	if h.AfterAll == nil {
+		return nil
+	}
+	return h.AfterAll(applied)
+}
+
+func (h Hooks) beforeEach(migration MigrationInfo) error {
+	if h.BeforeEach == nil {
+		return nil
+	}
+	return h.BeforeEach(migration)
+}
+
+func (h Hooks) afterEach(migration MigrationInfo) error {
+	if h.AfterEach == nil {
+		return nil
+	}
+	return h.AfterEach(migration)
+}
+
+func (h Hooks) onError(migration MigrationInfo, err error) {
+	if h.OnError != nil {
+		h.OnError(migration, err)
+	}
+}
+
+// serialized returns the hooks holding mu, for the migrations applied in parallel and the targets of MigrateAll
+// migrated concurrently
+func (h Hooks) serialized(mu *sync.Mutex) Hooks {
+	if beforeAll := h.BeforeAll; beforeAll != nil {
+		h.BeforeAll = func(pending []MigrationInfo) error {
+			mu.Lock()
+			defer mu.Unlock()
+			return beforeAll(pending)
+		}
+	}
+	if afterAll := h.AfterAll; afterAll != nil {
+		h.AfterAll = func(applied []MigrationInfo) error {
+			mu.Lock()
+			defer mu.Unlock()
+			return afterAll(applied)
+		}
+	}
+	if before := h.BeforeEach; before != nil {
+		h.BeforeEach = func(migration MigrationInfo) error {
+			mu.Lock()
+			defer mu.Unlock()
+			return before(migration)
+		}
+	}
+	if after := h.AfterEach; after != nil {
+		h.AfterEach = func(migration MigrationInfo) error {
+			mu.Lock()
+			defer mu.Unlock()
+			return after(migration)
+		}
+	}
+	if onError := h.OnError; onError != nil {
+		h.OnError = func(migration MigrationInfo, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			onError(migration, err)
+		}
+	}
+	return h
+}
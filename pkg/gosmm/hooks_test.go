@@ -0,0 +1,172 @@
+package gosmm
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrateWithHooks(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	// Create test_migrations directory if it doesn't exist
+	if _, err := os.Stat(migrationsDir); os.IsNotExist(err) {
+		err := os.Mkdir(migrationsDir, 0755)
+		if err != nil {
+			t.Fatalf("Failed to create test_migrations directory: %v", err)
+		}
+	}
+
+	// Create test migration files in the test_migrations directory
+	testMigrationFile1 := filepath.Join(migrationsDir, "v20230101_create_test_data_00001.sql")
+	if err := ioutil.WriteFile(testMigrationFile1, []byte("CREATE TABLE test_table (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	testMigrationFile2 := filepath.Join(migrationsDir, "v20230101_create_test_data_00002.sql")
+	if err := ioutil.WriteFile(testMigrationFile2, []byte("CREATE TABLE test_table_2 (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+
+	var calls []string
+	err := MigrateWithConfig(db, MigrationConfig{
+		MigrationsDir: migrationsDir,
+		Driver:        "sqlite3",
+		Hooks: Hooks{
+			BeforeAll: func(pending []MigrationInfo) error {
+				assert.Len(t, pending, 2)
+				calls = append(calls, "BeforeAll")
+				return nil
+			},
+			BeforeEach: func(migration MigrationInfo) error {
+				calls = append(calls, "BeforeEach "+migration.Filename)
+				return nil
+			},
+			AfterEach: func(migration MigrationInfo) error {
+				calls = append(calls, "AfterEach "+migration.Filename)
+				return nil
+			},
+			AfterAll: func(applied []MigrationInfo) error {
+				assert.Len(t, applied, 2)
+				assert.Equal(t, 2, applied[1].InstalledRank)
+				calls = append(calls, "AfterAll")
+				return nil
+			},
+			OnError: func(migration MigrationInfo, err error) {
+				calls = append(calls, "OnError")
+			},
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"BeforeAll",
+		"BeforeEach v20230101_create_test_data_00001.sql",
+		"AfterEach v20230101_create_test_data_00001.sql",
+		"BeforeEach v20230101_create_test_data_00002.sql",
+		"AfterEach v20230101_create_test_data_00002.sql",
+		"AfterAll",
+	}, calls)
+
+	// Delete the test migration files
+	if err := os.Remove(testMigrationFile1); err != nil {
+		t.Fatalf("Failed to delete test migration file: %v", err)
+	}
+	if err := os.Remove(testMigrationFile2); err != nil {
+		t.Fatalf("Failed to delete test migration file: %v", err)
+	}
+}
+
+func TestMigrateWithFailingBeforeEachHook(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	// Create test_migrations directory if it doesn't exist
+	if _, err := os.Stat(migrationsDir); os.IsNotExist(err) {
+		err := os.Mkdir(migrationsDir, 0755)
+		if err != nil {
+			t.Fatalf("Failed to create test_migrations directory: %v", err)
+		}
+	}
+
+	// Create a test migration file in the test_migrations directory
+	testMigrationFile := filepath.Join(migrationsDir, "v20230101_create_test_data_00001.sql")
+	if err := ioutil.WriteFile(testMigrationFile, []byte("CREATE TABLE test_table (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+
+	backupErr := errors.New("backup failed")
+	var failedMigration MigrationInfo
+	var hookErr error
+	err := MigrateWithConfig(db, MigrationConfig{
+		MigrationsDir: migrationsDir,
+		Driver:        "sqlite3",
+		Hooks: Hooks{
+			BeforeEach: func(migration MigrationInfo) error {
+				return backupErr
+			},
+			OnError: func(migration MigrationInfo, err error) {
+				failedMigration = migration
+				hookErr = err
+			},
+		},
+	})
+	assert.ErrorIs(t, err, backupErr)
+	assert.ErrorIs(t, hookErr, backupErr)
+	assert.Equal(t, "v20230101_create_test_data_00001.sql", failedMigration.Filename)
+
+	// Check test_table does not exist
+	var exists bool
+	err = db.QueryRow("SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'test_table')").Scan(&exists)
+	if err != nil {
+		t.Fatalf("Failed to check if test_table exists: %v", err)
+	}
+	if exists {
+		t.Fatalf("Migration should not have been executed")
+	}
+
+	// Delete the test migration file
+	if err := os.Remove(testMigrationFile); err != nil {
+		t.Fatalf("Failed to delete test migration file: %v", err)
+	}
+}
+
+func TestMigrateCallsOnErrorOnEveryFailure(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_add_email_00001.sql"), []byte("ALTER TABLE missing_table ADD COLUMN email TEXT;"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	var failures []MigrationInfo
+	var hookErrs []error
+	config := MigrationConfig{
+		MigrationsDir: dir,
+		Driver:        "sqlite3",
+		Hooks: Hooks{
+			OnError: func(migration MigrationInfo, err error) {
+				failures = append(failures, migration)
+				hookErrs = append(hookErrs, err)
+			},
+		},
+	}
+
+	// A failed migration is reported once
+	err := MigrateWithConfig(db, config)
+	assert.Error(t, err)
+	if assert.Len(t, failures, 1) {
+		assert.Equal(t, "v20230101_add_email_00001.sql", failures[0].Filename)
+		assert.Equal(t, err, hookErrs[0])
+	}
+
+	// A failure before any migration runs, here on the dirty history, is reported too
+	err = MigrateWithConfig(db, config)
+	assert.ErrorIs(t, err, ErrDirtyState)
+	if assert.Len(t, failures, 2) {
+		assert.Equal(t, MigrationInfo{}, failures[1])
+		assert.ErrorIs(t, hookErrs[1], ErrDirtyState)
+	}
+}
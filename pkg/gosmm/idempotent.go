@@ -0,0 +1,123 @@
+package gosmm
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// idempotentRewrite inserts clause after the match of pattern, unless the statement already has an IF [NOT] EXISTS
+type idempotentRewrite struct {
+	pattern *regexp.Regexp
+	clause  string
+}
+
+var (
+	ifExistsPattern = regexp.MustCompile(`(?i)^IF\s+(?:NOT\s+)?EXISTS\b`)
+
+	// idempotentRewrites are the statements made idempotent with IF [NOT] EXISTS, per driver
+	idempotentRewrites = map[string][]idempotentRewrite{
+		"sqlite3": {
+			{regexp.MustCompile(`(?is)^CREATE\s+(?:TEMP(?:ORARY)?\s+)?(?:TABLE|VIEW|TRIGGER)\s+`), "IF NOT EXISTS"},
+			{regexp.MustCompile(`(?is)^CREATE\s+(?:UNIQUE\s+)?INDEX\s+`), "IF NOT EXISTS"},
+			{regexp.MustCompile(`(?is)^DROP\s+(?:TABLE|VIEW|TRIGGER|INDEX)\s+`), "IF EXISTS"},
+		},
+		"mysql": {
+			{regexp.MustCompile(`(?is)^CREATE\s+(?:TEMPORARY\s+)?TABLE\s+`), "IF NOT EXISTS"},
+			{regexp.MustCompile(`(?is)^DROP\s+(?:TEMPORARY\s+)?(?:TABLE|VIEW)\s+`), "IF EXISTS"},
+		},
+	}
+
+	mysqlCreateIndexPattern = regexp.MustCompile(`(?is)^CREATE\s+(?:UNIQUE\s+|FULLTEXT\s+|SPATIAL\s+)?INDEX\s+([^\s(]+)\s+ON\s+([^\s(]+)`)
+	mysqlDropIndexPattern   = regexp.MustCompile(`(?is)^DROP\s+INDEX\s+([^\s(]+)\s+ON\s+([^\s(;]+)`)
+)
+
+// queryRowFunc queries a single row, the QueryRowContext method of the connection or transaction of a migration
+type queryRowFunc func(ctx context.Context, query string, args ...interface{}) *sql.Row
+
+// statementGuard reports whether a statement which cannot be rewritten to be idempotent was already applied,
+// e.g. an ADD COLUMN whose column exists
+type statementGuard func(ctx context.Context, queryRow queryRowFunc) (bool, error)
+
+// idempotentStatement rewrites statement to be idempotent for driver where possible, e.g. CREATE TABLE users
+// into CREATE TABLE IF NOT EXISTS users, and returns the guard of the statements that cannot be rewritten,
+// nil for the others. Only SQLite and MySQL statements are rewritten, the other drivers having transactional DDL
+// with IF [NOT] EXISTS clauses of their own.
+func idempotentStatement(driver string, statement string) (string, statementGuard) {
+	statement = strings.TrimSpace(statement)
+	code := stripLeadingComments(statement)
+	prefix := statement[:len(statement)-len(code)]
+
+	for _, rewrite := range idempotentRewrites[driver] {
+		loc := rewrite.pattern.FindStringIndex(code)
+		if loc == nil {
+			continue
+		}
+		if ifExistsPattern.MatchString(code[loc[1]:]) {
+			return statement, nil
+		}
+		return prefix + code[:loc[1]] + rewrite.clause + " " + code[loc[1]:], nil
+	}
+	if driver != "sqlite3" && driver != "mysql" {
+		return statement, nil
+	}
+
+	if match := alterTablePattern.FindStringSubmatch(lintCode(code)); match != nil {
+		clauses := splitTopLevel(match[2])
+		if len(clauses) != 1 {
+			return statement, nil // a guard could only skip all clauses
+		}
+		if add := addClausePattern.FindStringSubmatch(clauses[0]); add != nil && (add[1] != "" || !notColumnKeywords[strings.ToUpper(add[2])]) {
+			return statement, columnGuard(match[1], add[2], true)
+		}
+		if drop := dropClausePattern.FindStringSubmatch(clauses[0]); drop != nil && strings.HasPrefix(strings.ToUpper(strings.TrimSpace(clauses[0])), "DROP") &&
+			(drop[1] != "" || !notColumnKeywords[strings.ToUpper(drop[2])]) {
+			return statement, columnGuard(match[1], drop[2], false)
+		}
+		return statement, nil
+	}
+	if driver == "mysql" {
+		if match := mysqlCreateIndexPattern.FindStringSubmatch(code); match != nil {
+			return statement, mysqlIndexGuard(match[2], match[1], true)
+		}
+		if match := mysqlDropIndexPattern.FindStringSubmatch(code); match != nil {
+			return statement, mysqlIndexGuard(match[2], match[1], false)
+		}
+	}
+	return statement, nil
+}
+
+// columnGuard reports a statement as applied when the column of table exists, or when it does not exist
+// for a statement dropping it
+func columnGuard(table string, column string, add bool) statementGuard {
+	return func(ctx context.Context, queryRow queryRowFunc) (bool, error) {
+		var value interface{}
+		err := queryRow(ctx, `SELECT `+column+` FROM `+table+` WHERE 1 = 0`).Scan(&value)
+		exists := errors.Is(err, sql.ErrNoRows)
+		return exists == add, nil
+	}
+}
+
+// mysqlIndexGuard reports a statement as applied when the index of table exists, or when it does not exist
+// for a statement dropping it
+func mysqlIndexGuard(table string, index string, create bool) statementGuard {
+	return func(ctx context.Context, queryRow queryRowFunc) (bool, error) {
+		var count int
+		err := queryRow(ctx, `SELECT COUNT(*) FROM information_schema.statistics WHERE table_schema = DATABASE() AND table_name = ? AND index_name = ?`,
+			normalizeIdentifier(table), normalizeIdentifier(index)).Scan(&count)
+		if err != nil {
+			return false, err
+		}
+		return (count > 0) == create, nil
+	}
+}
+
+// normalizeIdentifier returns the unqualified name of an identifier without its quotes
+func normalizeIdentifier(name string) string {
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		name = name[i+1:]
+	}
+	return strings.Trim(name, "\"`[]")
+}
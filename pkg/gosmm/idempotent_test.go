@@ -0,0 +1,73 @@
+package gosmm
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotentStatement(t *testing.T) {
+	for _, tt := range []struct {
+		driver    string
+		statement string
+		expected  string
+		guarded   bool
+	}{
+		{"sqlite3", "CREATE TABLE users (id INTEGER)", "CREATE TABLE IF NOT EXISTS users (id INTEGER)", false},
+		{"sqlite3", "-- users\ncreate table users (id INTEGER)", "-- users\ncreate table IF NOT EXISTS users (id INTEGER)", false},
+		{"sqlite3", "CREATE TABLE IF NOT EXISTS users (id INTEGER)", "CREATE TABLE IF NOT EXISTS users (id INTEGER)", false},
+		{"sqlite3", "CREATE UNIQUE INDEX idx_users_email ON users (email)", "CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email ON users (email)", false},
+		{"sqlite3", "DROP VIEW active_users", "DROP VIEW IF EXISTS active_users", false},
+		{"sqlite3", "ALTER TABLE users ADD COLUMN email TEXT", "ALTER TABLE users ADD COLUMN email TEXT", true},
+		{"sqlite3", "ALTER TABLE users DROP COLUMN email", "ALTER TABLE users DROP COLUMN email", true},
+		{"sqlite3", "ALTER TABLE users RENAME TO people", "ALTER TABLE users RENAME TO people", false},
+		{"mysql", "CREATE TEMPORARY TABLE batch (id INT)", "CREATE TEMPORARY TABLE IF NOT EXISTS batch (id INT)", false},
+		{"mysql", "DROP TABLE users", "DROP TABLE IF EXISTS users", false},
+		{"mysql", "ALTER TABLE users ADD email VARCHAR(255)", "ALTER TABLE users ADD email VARCHAR(255)", true},
+		{"mysql", "ALTER TABLE users ADD price DECIMAL(10, 2)", "ALTER TABLE users ADD price DECIMAL(10, 2)", true},
+		{"mysql", "ALTER TABLE users ADD email VARCHAR(255), ADD name VARCHAR(255)", "ALTER TABLE users ADD email VARCHAR(255), ADD name VARCHAR(255)", false},
+		{"mysql", "ALTER TABLE users ADD INDEX idx_users_email (email)", "ALTER TABLE users ADD INDEX idx_users_email (email)", false},
+		{"mysql", "CREATE INDEX idx_users_email ON users (email)", "CREATE INDEX idx_users_email ON users (email)", true},
+		{"mysql", "DROP INDEX idx_users_email ON users", "DROP INDEX idx_users_email ON users", true},
+		{"postgres", "CREATE TABLE users (id INTEGER)", "CREATE TABLE users (id INTEGER)", false},
+		{"postgres", "ALTER TABLE users ADD COLUMN email TEXT", "ALTER TABLE users ADD COLUMN email TEXT", false},
+	} {
+		statement, guard := idempotentStatement(tt.driver, tt.statement)
+		assert.Equal(t, tt.expected, statement, tt.statement)
+		assert.Equal(t, tt.guarded, guard != nil, tt.statement)
+	}
+}
+
+func TestMigrateIdempotent(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	// the first statements of the file were applied by a previous run
+	_, err := db.Exec("CREATE TABLE users (id INTEGER)")
+	assert.NoError(t, err)
+	_, err = db.Exec("ALTER TABLE users ADD COLUMN email TEXT")
+	assert.NoError(t, err)
+
+	dir := t.TempDir()
+	content := "CREATE TABLE users (id INTEGER);\nALTER TABLE users ADD COLUMN email TEXT;\nALTER TABLE users DROP COLUMN missing;\nCREATE INDEX idx_users_email ON users (email);"
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}
+	err = MigrateWithConfig(db, config)
+	var failed *ErrMigrationFailed
+	if assert.ErrorAs(t, err, &failed) {
+		assert.Equal(t, 1, failed.StatementIndex)
+	}
+	_, err = db.Exec("DELETE FROM gosmm_migration_history")
+	assert.NoError(t, err)
+
+	config.Idempotent = true
+	assert.NoError(t, MigrateWithConfig(db, config))
+	var count int
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = 'idx_users_email'").Scan(&count))
+	assert.Equal(t, 1, count)
+}
@@ -0,0 +1,263 @@
+package gosmm
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ImportSource identifies the migration tool whose history is imported
+type ImportSource string
+
+const (
+	// ImportFromFlyway imports the flyway_schema_history table
+	ImportFromFlyway ImportSource = "flyway"
+	// ImportFromGolangMigrate imports the schema_migrations table of golang-migrate
+	ImportFromGolangMigrate ImportSource = "golang-migrate"
+	// ImportFromGoose imports the goose_db_version table
+	ImportFromGoose ImportSource = "goose"
+)
+
+var (
+	// golangMigrateFilenamePattern matches golang-migrate up migrations such as 1_create_users.up.sql
+	golangMigrateFilenamePattern = regexp.MustCompile(`^(\d+)_.*\.up\.sql$`)
+	// gooseFilenamePattern matches goose SQL migrations such as 20230101120000_create_users.sql
+	gooseFilenamePattern = regexp.MustCompile(`^(\d+)_.*\.sql$`)
+)
+
+// ImportConfig configures ImportHistory
+type ImportConfig struct {
+	// Source is the migration tool the history is imported from
+	Source ImportSource
+	// Table is the source history table. When empty, the tool's default table name is used.
+	Table string
+	// Rename maps a source migration filename to the gosmm migration filename,
+	// for migration files renamed when switching to gosmm. When nil, filenames are kept.
+	Rename func(filename string) string
+}
+
+// importedMigration is a migration read from the history of another tool
+type importedMigration struct {
+	filename      string
+	installedOn   time.Time
+	executionTime int64
+	success       bool
+}
+
+// ImportHistory populates the gosmm migration history table from the history table of another
+// migration tool, so that already applied migrations are not executed again.
+// The gosmm history table must be empty. It returns the number of imported migrations.
+func ImportHistory(db *sql.DB, config MigrationConfig, importConfig ImportConfig) (int, error) {
+	var migrations []importedMigration
+	var err error
+	switch importConfig.Source {
+	case ImportFromFlyway:
+		migrations, err = readFlywayHistory(db, tableOrDefault(importConfig.Table, "flyway_schema_history"))
+	case ImportFromGolangMigrate:
+		migrations, err = readGolangMigrateHistory(db, tableOrDefault(importConfig.Table, "schema_migrations"), config.MigrationsDir)
+	case ImportFromGoose:
+		migrations, err = readGooseHistory(db, tableOrDefault(importConfig.Table, "goose_db_version"), config.MigrationsDir)
+	default:
+		return 0, fmt.Errorf("unsupported import source: %s", importConfig.Source)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s history: %w", importConfig.Source, err)
+	}
+
+	if err := createHistoryTable(db, config.Driver, config.Schema); err != nil {
+		return 0, fmt.Errorf("failed to create history table: %w", err)
+	}
+	table := historyTableName(config.Driver, config.Schema)
+
+	var existing int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM ` + table).Scan(&existing); err != nil {
+		return 0, fmt.Errorf("failed to count history rows: %w", err)
+	}
+	if existing > 0 {
+		return 0, errors.New("cannot import, the migration history table is not empty")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	insert := `INSERT INTO ` + table + ` (installed_rank, filename, installed_on, execution_time, success, checksum) VALUES (` + bindParams(config.Driver, 6) + `)`
+	for i, migration := range migrations {
+		filename := migration.filename
+		if importConfig.Rename != nil {
+			filename = importConfig.Rename(filename)
+		}
+		// the checksum is recorded when the file exists, so that Validate detects later modifications
+		var checksum string
+		if data, err := ioutil.ReadFile(filepath.Join(config.MigrationsDir, filename)); err == nil {
+			if checksum, err = config.checksum(data); err != nil {
+				tx.Rollback()
+				return 0, err
+			}
+		}
+		if _, err := tx.Exec(insert, i+1, filename, migration.installedOn, migration.executionTime, boolValue(config.Driver, migration.success), checksum); err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				return 0, fmt.Errorf("failed to import %s: %w, and failed to rollback: %v", filename, err, rbErr)
+			}
+			return 0, fmt.Errorf("failed to import %s: %w", filename, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return len(migrations), nil
+}
+
+// tableOrDefault returns table, or defaultTable when it is empty
+func tableOrDefault(table string, defaultTable string) string {
+	if table == "" {
+		return defaultTable
+	}
+	return table
+}
+
+// readFlywayHistory reads the SQL migrations recorded by Flyway. Baseline and schema creation rows are skipped.
+func readFlywayHistory(db *sql.DB, table string) ([]importedMigration, error) {
+	rows, err := db.Query(`SELECT script, installed_on, execution_time, success FROM ` + table + ` WHERE type = 'SQL' ORDER BY installed_rank`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var migrations []importedMigration
+	for rows.Next() {
+		var migration importedMigration
+		var installedOn timestamp
+		if err := rows.Scan(&migration.filename, &installedOn, &migration.executionTime, &migration.success); err != nil {
+			return nil, err
+		}
+		migration.installedOn = installedOn.Time
+		migrations = append(migrations, migration)
+	}
+	return migrations, rows.Err()
+}
+
+// readGolangMigrateHistory reads the version recorded by golang-migrate. Since only the current
+// version is recorded, every up migration file up to it is treated as applied, and the current
+// one as failed when the database is marked dirty.
+func readGolangMigrateHistory(db *sql.DB, table string, migrationsDir string) ([]importedMigration, error) {
+	var current int64
+	var dirty bool
+	err := db.QueryRow(`SELECT version, dirty FROM `+table).Scan(&current, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := versionedFiles(migrationsDir, golangMigrateFilenamePattern)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := files[current]; !ok {
+		return nil, fmt.Errorf("no migration file found for version %d", current)
+	}
+
+	var migrations []importedMigration
+	for _, version := range sortedVersions(files) {
+		if version > current {
+			break
+		}
+		migrations = append(migrations, importedMigration{
+			filename:    files[version],
+			installedOn: time.Now(),
+			success:     version != current || !dirty,
+		})
+	}
+	return migrations, nil
+}
+
+// readGooseHistory reads the migrations recorded by goose. The latest row of each version decides
+// whether it is applied, since goose records rollbacks as rows with is_applied = false.
+func readGooseHistory(db *sql.DB, table string, migrationsDir string) ([]importedMigration, error) {
+	rows, err := db.Query(`SELECT version_id, is_applied, tstamp FROM ` + table + ` ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]time.Time)
+	for rows.Next() {
+		var version int64
+		var isApplied bool
+		var tstamp timestamp
+		if err := rows.Scan(&version, &isApplied, &tstamp); err != nil {
+			return nil, err
+		}
+		if version == 0 {
+			continue // the row goose creates with the table
+		}
+		if isApplied {
+			applied[version] = tstamp.Time
+		} else {
+			delete(applied, version)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	files, err := versionedFiles(migrationsDir, gooseFilenamePattern)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]int64, 0, len(applied))
+	for version := range applied {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+
+	migrations := make([]importedMigration, 0, len(versions))
+	for _, version := range versions {
+		filename, ok := files[version]
+		if !ok {
+			return nil, fmt.Errorf("no migration file found for version %d", version)
+		}
+		migrations = append(migrations, importedMigration{filename: filename, installedOn: applied[version], success: true})
+	}
+	return migrations, nil
+}
+
+// versionedFiles returns the files in migrationsDir matching pattern keyed by their leading version number
+func versionedFiles(migrationsDir string, pattern *regexp.Regexp) (map[int64]string, error) {
+	entries, err := ioutil.ReadDir(migrationsDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations directory: %w", err)
+	}
+	files := make(map[int64]string)
+	for _, entry := range entries {
+		match := pattern.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version in %s: %w", entry.Name(), err)
+		}
+		files[version] = entry.Name()
+	}
+	return files, nil
+}
+
+// sortedVersions returns the versions of files in ascending order
+func sortedVersions(files map[int64]string) []int64 {
+	versions := make([]int64, 0, len(files))
+	for version := range files {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+	return versions
+}
@@ -0,0 +1,126 @@
+package gosmm
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestImportHistoryFromFlyway(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "V1__create_users.sql"), []byte("CREATE TABLE users (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+
+	// Create a flyway_schema_history table with a baseline and an applied migration
+	for _, statement := range []string{
+		`CREATE TABLE flyway_schema_history (installed_rank INTEGER, version TEXT, description TEXT, type TEXT, script TEXT,
+			checksum INTEGER, installed_by TEXT, installed_on TIMESTAMP, execution_time INTEGER, success BOOLEAN)`,
+		`INSERT INTO flyway_schema_history VALUES (1, '0', '<< Flyway Baseline >>', 'BASELINE', '<< Flyway Baseline >>', NULL, 'app', '2023-01-01 00:00:00', 0, TRUE)`,
+		`INSERT INTO flyway_schema_history VALUES (2, '1', 'create users', 'SQL', 'V1__create_users.sql', 123, 'app', '2023-01-02 00:00:00', 15, TRUE)`,
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatalf("Failed to execute %s: %v", statement, err)
+		}
+	}
+
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}
+	imported, err := ImportHistory(db, config, ImportConfig{Source: ImportFromFlyway})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, imported)
+
+	history, err := GetHistory(db, config)
+	assert.NoError(t, err)
+	if assert.Len(t, history, 1) {
+		assert.Equal(t, 1, history[0].InstalledRank)
+		assert.Equal(t, "V1__create_users.sql", history[0].Filename)
+		assert.Equal(t, int64(15), history[0].ExecutionTime)
+		assert.Equal(t, calculateChecksum([]byte("CREATE TABLE users (id INTEGER);")), history[0].Checksum)
+	}
+
+	// Importing again is rejected since the history table is no longer empty
+	_, err = ImportHistory(db, config, ImportConfig{Source: ImportFromFlyway})
+	assert.Error(t, err)
+}
+
+func TestImportHistoryFromGolangMigrate(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	for _, name := range []string{"1_create_users.up.sql", "1_create_users.down.sql", "2_add_email.up.sql", "3_add_index.up.sql"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("SELECT 1;"), 0644); err != nil {
+			t.Fatalf("Failed to create test migration file: %v", err)
+		}
+	}
+
+	// golang-migrate only records the current version, which failed
+	for _, statement := range []string{
+		`CREATE TABLE schema_migrations (version INTEGER, dirty BOOLEAN)`,
+		`INSERT INTO schema_migrations VALUES (2, TRUE)`,
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatalf("Failed to execute %s: %v", statement, err)
+		}
+	}
+
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}
+	imported, err := ImportHistory(db, config, ImportConfig{
+		Source: ImportFromGolangMigrate,
+		Rename: func(filename string) string {
+			return strings.Replace(filename, ".up.sql", ".sql", 1)
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, imported)
+
+	history, err := GetHistory(db, config)
+	assert.NoError(t, err)
+	if assert.Len(t, history, 2) {
+		assert.Equal(t, "1_create_users.sql", history[0].Filename)
+		assert.True(t, history[0].Success)
+		assert.Equal(t, "2_add_email.sql", history[1].Filename)
+		assert.False(t, history[1].Success)
+	}
+}
+
+func TestImportHistoryFromGoose(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	for _, name := range []string{"20230101000000_create_users.sql", "20230102000000_add_email.sql"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("SELECT 1;"), 0644); err != nil {
+			t.Fatalf("Failed to create test migration file: %v", err)
+		}
+	}
+
+	// The second migration was applied and rolled back again
+	for _, statement := range []string{
+		`CREATE TABLE goose_db_version (id INTEGER PRIMARY KEY, version_id INTEGER, is_applied BOOLEAN, tstamp TIMESTAMP)`,
+		`INSERT INTO goose_db_version VALUES (1, 0, TRUE, '2023-01-01 00:00:00')`,
+		`INSERT INTO goose_db_version VALUES (2, 20230101000000, TRUE, '2023-01-01 00:00:00')`,
+		`INSERT INTO goose_db_version VALUES (3, 20230102000000, TRUE, '2023-01-02 00:00:00')`,
+		`INSERT INTO goose_db_version VALUES (4, 20230102000000, FALSE, '2023-01-03 00:00:00')`,
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatalf("Failed to execute %s: %v", statement, err)
+		}
+	}
+
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}
+	imported, err := ImportHistory(db, config, ImportConfig{Source: ImportFromGoose})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, imported)
+
+	history, err := GetHistory(db, config)
+	assert.NoError(t, err)
+	if assert.Len(t, history, 1) {
+		assert.Equal(t, "20230101000000_create_users.sql", history[0].Filename)
+	}
+}
@@ -0,0 +1,89 @@
+package gosmm
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// indexStatementPattern matches the statements creating, rebuilding or enabling indexes and constraints
+var indexStatementPattern = regexp.MustCompile(`(?is)^\s*(?:CREATE\s+(?:UNIQUE\s+)?(?:CLUSTERED\s+|NONCLUSTERED\s+|FULLTEXT\s+|SPATIAL\s+)?INDEX\b|ALTER\s+INDEX\b|REINDEX\b|ALTER\s+TABLE\b.*\b(?:ADD|ENABLE)\s+(?:CONSTRAINT|PRIMARY|UNIQUE|INDEX|KEY|FOREIGN)\b)`)
+
+// createsIndexes reports whether one of the statements creates, rebuilds or enables an index or a constraint
+func createsIndexes(statements []string) bool {
+	for _, statement := range statements {
+		if indexStatementPattern.MatchString(statement) {
+			return true
+		}
+	}
+	return false
+}
+
+// invalidIndexQueries are the queries listing the indexes and constraints of the schema, given as their
+// only parameter or the current schema when empty, left unusable by a migration: the invalid indexes of
+// Postgres, whose concurrent build failed, the invisible indexes of MySQL 8.0 and the disabled indexes and
+// constraints of SQL Server
+var invalidIndexQueries = map[string]string{
+	"postgres": `SELECT c.relname FROM pg_index i
+		JOIN pg_class c ON c.oid = i.indexrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE NOT i.indisvalid AND n.nspname = COALESCE(NULLIF($1, ''), current_schema())
+		ORDER BY c.relname`,
+	"mysql": `SELECT DISTINCT CONCAT(TABLE_NAME, '.', INDEX_NAME) FROM information_schema.STATISTICS
+		WHERE TABLE_SCHEMA = COALESCE(NULLIF(?, ''), DATABASE()) AND IS_VISIBLE = 'NO'
+		ORDER BY 1`,
+	"sqlserver": `SELECT name FROM (
+			SELECT OBJECT_NAME(i.object_id) + '.' + i.name AS name, t.schema_id FROM sys.indexes i
+			JOIN sys.tables t ON t.object_id = i.object_id WHERE i.is_disabled = 1
+			UNION ALL
+			SELECT OBJECT_NAME(parent_object_id) + '.' + name, schema_id FROM sys.foreign_keys WHERE is_disabled = 1
+			UNION ALL
+			SELECT OBJECT_NAME(parent_object_id) + '.' + name, schema_id FROM sys.check_constraints WHERE is_disabled = 1
+		) disabled
+		WHERE SCHEMA_NAME(schema_id) = COALESCE(NULLIF(@p1, ''), SCHEMA_NAME())
+		ORDER BY name`,
+}
+
+// verifyIndexes wraps the execution of a migration file so that it fails with ErrInvalidIndex when an index
+// of the schema is invalid after its statements, checked on the connection or in the transaction of the
+// migration. The failure is recorded like a failed statement, so the migration is run again from its first
+// statement once the index is dropped. The drivers without invalid indexes are not checked.
+func verifyIndexes(config MigrationConfig, filename string, execute func(ctx context.Context, conn *sql.Conn, tx *sql.Tx) error) func(ctx context.Context, conn *sql.Conn, tx *sql.Tx) error {
+	query, ok := invalidIndexQueries[config.Driver]
+	if !ok {
+		return execute
+	}
+	return func(ctx context.Context, conn *sql.Conn, tx *sql.Tx) error {
+		if err := execute(ctx, conn, tx); err != nil {
+			return err
+		}
+		var rows *sql.Rows
+		var err error
+		if tx != nil {
+			rows, err = tx.QueryContext(ctx, query, config.Schema)
+		} else {
+			rows, err = conn.QueryContext(ctx, query, config.Schema)
+		}
+		if err != nil {
+			return &ErrMigrationFailed{File: filename, Cause: fmt.Errorf("failed to verify indexes: %w", err)}
+		}
+		defer rows.Close()
+		var invalid []string
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				return &ErrMigrationFailed{File: filename, Cause: fmt.Errorf("failed to verify indexes: %w", err)}
+			}
+			invalid = append(invalid, name)
+		}
+		if err := rows.Err(); err != nil {
+			return &ErrMigrationFailed{File: filename, Cause: fmt.Errorf("failed to verify indexes: %w", err)}
+		}
+		if len(invalid) > 0 {
+			return &ErrMigrationFailed{File: filename, Cause: fmt.Errorf("%w: %s", ErrInvalidIndex, strings.Join(invalid, ", "))}
+		}
+		return nil
+	}
+}
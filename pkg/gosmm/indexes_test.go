@@ -0,0 +1,48 @@
+package gosmm
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreatesIndexes(t *testing.T) {
+	tests := []struct {
+		statement string
+		creates   bool
+	}{
+		{"CREATE INDEX users_email ON users (email)", true},
+		{"CREATE UNIQUE INDEX CONCURRENTLY IF NOT EXISTS users_email ON users (email)", true},
+		{"create nonclustered index users_email on users (email)", true},
+		{"ALTER TABLE posts ADD CONSTRAINT posts_user FOREIGN KEY (user_id) REFERENCES users (id)", true},
+		{"ALTER TABLE posts WITH NOCHECK ADD CONSTRAINT posts_user FOREIGN KEY (user_id) REFERENCES users (id)", true},
+		{"ALTER TABLE users ADD PRIMARY KEY (id)", true},
+		{"ALTER TABLE users ADD INDEX users_email (email), ALGORITHM=INPLACE", true},
+		{"REINDEX INDEX CONCURRENTLY users_email", true},
+		{"ALTER INDEX users_email ON users REBUILD", true},
+		{"ALTER TABLE users ADD COLUMN email TEXT", false},
+		{"CREATE TABLE users (id INTEGER PRIMARY KEY)", false},
+		{"INSERT INTO logs (message) VALUES ('CREATE INDEX')", false},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.creates, createsIndexes([]string{test.statement}), test.statement)
+	}
+}
+
+func TestVerifyIndexes(t *testing.T) {
+	failed := errors.New("failed")
+	execute := func(ctx context.Context, conn *sql.Conn, tx *sql.Tx) error {
+		return failed
+	}
+
+	// the drivers without invalid indexes are not checked
+	verified := verifyIndexes(MigrationConfig{Driver: "sqlite3", VerifyIndexes: true}, "v20230101_create_users_00001.sql", execute)
+	assert.Equal(t, failed, verified(context.Background(), nil, nil))
+
+	// the failure of the statements is returned before the indexes are checked
+	verified = verifyIndexes(MigrationConfig{Driver: "postgres", VerifyIndexes: true}, "v20230101_create_users_00001.sql", execute)
+	assert.Equal(t, failed, verified(context.Background(), nil, nil))
+}
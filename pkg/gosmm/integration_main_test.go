@@ -0,0 +1,31 @@
+//go:build integration
+
+package gosmm_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/k1e1n04/gosmm/v2/pkg/gosmm"
+	"github.com/k1e1n04/gosmm/v2/pkg/gosmmtest"
+)
+
+// TestMain lets the integration tests start a Postgres container with gosmmtest, and so testcontainers, when
+// GOSMM_TEST_POSTGRES_DSN is not set, and removes it after the tests
+func TestMain(m *testing.M) {
+	var postgres *gosmmtest.Container
+	gosmm.StartTestPostgres = func() (gosmm.DBConfig, error) {
+		var err error
+		postgres, err = gosmmtest.StartPostgres(context.Background(), gosmmtest.Options{})
+		if err != nil {
+			return gosmm.DBConfig{}, err
+		}
+		return postgres.Config, nil
+	}
+	code := m.Run()
+	if postgres != nil {
+		postgres.Close()
+	}
+	os.Exit(code)
+}
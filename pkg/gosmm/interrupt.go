@@ -0,0 +1,109 @@
+package gosmm
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// interruptionTimeout bounds the recording of an interrupted migration, whose run context is canceled
+const interruptionTimeout = 10 * time.Second
+
+// interrupted returns ErrInterrupted once stop is closed or ctx is canceled
+func interrupted(ctx context.Context, stop <-chan struct{}) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("%w: %v", ErrInterrupted, ctx.Err())
+	}
+	select {
+	case <-stop:
+		return fmt.Errorf("%w: stopped", ErrInterrupted)
+	default:
+		return nil
+	}
+}
+
+// abortedMigration is the error of a migration whose execution was aborted by the cancellation of the run
+// context, to be recorded by recordInterruption
+type abortedMigration struct {
+	cause error
+}
+
+func (e *abortedMigration) Error() string {
+	return e.cause.Error()
+}
+
+func (e *abortedMigration) Unwrap() error {
+	return e.cause
+}
+
+// uncanceled returns ctx, or a new context bounded by interruptionTimeout once ctx is canceled, so that the
+// statements already committed by an aborted migration are still recorded
+func uncanceled(ctx context.Context) (context.Context, context.CancelFunc) {
+	if ctx.Err() == nil {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(context.Background(), interruptionTimeout)
+}
+
+// recordInterruption records the outcome of an aborted migration on conn, or on a new connection when the driver
+// closed conn on the cancellation. A migration whose statements
+// were all rolled back is left pending, its record of recordMigrationStart deleted, while one with committed
+// statements is recorded as failed with their number, so that it can be resumed. With rolledBack false, a
+// migration that did not report its committed statements, e.g. a Go migration, is left failed.
+func recordInterruption(db *sql.DB, conn *sql.Conn, config MigrationConfig, migration *MigrationInfo, started bool, rolledBack bool, aborted *abortedMigration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), interruptionTimeout)
+	defer cancel()
+	if err := conn.PingContext(ctx); err != nil {
+		if conn, err = db.Conn(ctx); err != nil {
+			return fmt.Errorf("%w: the outcome of %s could not be recorded: %v", ErrInterrupted, migration.Filename, err)
+		}
+		defer conn.Close()
+	}
+
+	var failure *ErrMigrationFailed
+	if !errors.As(aborted.cause, &failure) && !rolledBack && started {
+		config.LogLevel.printf(LogWarn, "ABORT %s (left failed)\n", migration.Filename)
+		return fmt.Errorf("%w: %s was left failed, as some of its statements may have been committed: %v", ErrInterrupted, migration.Filename, aborted.cause)
+	}
+	if failure == nil || failure.CommittedStatements == 0 {
+		var err error
+		if config.HistoryStore != nil {
+			err = config.HistoryStore.RemoveFailed(ctx, migration.Filename)
+		} else if started {
+			_, err = conn.ExecContext(ctx, deleteStartQuery(historyTableName(config.Driver, config.Schema), config.Driver), migration.Filename)
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %s was rolled back, but its record could not be deleted: %v", ErrInterrupted, migration.Filename, err)
+		}
+		config.LogLevel.printf(LogWarn, "ABORT %s (rolled back)\n", migration.Filename)
+		return fmt.Errorf("%w: %s was rolled back", ErrInterrupted, migration.Filename)
+	}
+
+	var err error
+	if config.HistoryStore != nil {
+		err = config.HistoryStore.Record(ctx, historyEntry(*migration, time.Now(), false, failure))
+	} else {
+		err = recordFailure(ctx, conn, config, *migration, failure)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s stopped after %d committed statement(s), which could not be recorded: %v", ErrInterrupted, migration.Filename, failure.CommittedStatements, err)
+	}
+	config.LogLevel.printf(LogWarn, "ABORT %s (statements 1-%d committed)\n", migration.Filename, failure.CommittedStatements)
+	return fmt.Errorf("%w: %s stopped after %d committed statement(s), recorded as failed: %w", ErrInterrupted, migration.Filename, failure.CommittedStatements, failure)
+}
+
+// recordFailure replaces the record of recordMigrationStart of the migration with its failure
+func recordFailure(ctx context.Context, conn *sql.Conn, config MigrationConfig, migration MigrationInfo, failure *ErrMigrationFailed) error {
+	table := historyTableName(config.Driver, config.Schema)
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, deleteStartQuery(table, config.Driver), migration.Filename); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return recordMigration(tx, table, migration, time.Now(), false, failure, config.Driver)
+}
@@ -0,0 +1,107 @@
+package gosmm
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrateWithStop(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"v20230101_create_users_00001.sql":  "CREATE TABLE users (id INTEGER);",
+		"v20230102_create_orders_00001.sql": "CREATE TABLE orders (id INTEGER);",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create migration file: %v", err)
+		}
+	}
+
+	// The migration in progress completes, and the next one is not applied
+	stop := make(chan struct{})
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3", Stop: stop, Hooks: Hooks{AfterEach: func(MigrationInfo) error {
+		close(stop)
+		return nil
+	}}}
+	err := MigrateWithConfig(db, config)
+	assert.True(t, errors.Is(err, ErrInterrupted))
+	history, err := GetHistory(db, config)
+	assert.NoError(t, err)
+	if assert.Len(t, history, 1) {
+		assert.True(t, history[0].Success)
+	}
+
+	config = MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}
+	assert.NoError(t, MigrateWithConfig(db, config))
+}
+
+func TestMigrateAbortRollsBack(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	config := MigrationConfig{MigrationsDir: t.TempDir(), Driver: "sqlite3", GoMigrations: map[string]GoMigrationFunc{
+		"v20230101_create_users_00001": func(ctx context.Context, conn *sql.Conn, tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, "CREATE TABLE users (id INTEGER)"); err != nil {
+				return err
+			}
+			cancel()
+			_, err := tx.ExecContext(ctx, "INSERT INTO users VALUES (1)")
+			return err
+		},
+	}}
+	err := MigrateWithContext(ctx, db, config)
+	assert.True(t, errors.Is(err, ErrInterrupted))
+	assert.ErrorContains(t, err, "rolled back")
+
+	// The migration is left pending rather than failed
+	dirty, err := DirtyMigrations(db, config)
+	assert.NoError(t, err)
+	assert.Empty(t, dirty)
+	_, err = db.Exec("SELECT id FROM users")
+	assert.Error(t, err)
+}
+
+func TestMigrateAbortRecordsCommittedStatements(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	content := "-- gosmm:transactional false\nCREATE TABLE users (id INTEGER);\nINSERT INTO users VALUES (1);\nINSERT INTO users VALUES (2);"
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create migration file: %v", err)
+	}
+
+	// Aborted after its first statement was committed
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3", Progress: func(event Event) {
+		if event.Kind == EventStatementExecuted && event.StatementIndex == 1 {
+			cancel()
+		}
+	}}
+	err := MigrateWithContext(ctx, db, config)
+	assert.True(t, errors.Is(err, ErrInterrupted))
+	history, err := GetHistory(db, config)
+	assert.NoError(t, err)
+	if assert.Len(t, history, 1) {
+		assert.False(t, history[0].Success)
+		assert.Equal(t, 1, history[0].CommittedStatements)
+	}
+
+	// The run is resumed after the committed statement
+	config = MigrationConfig{MigrationsDir: dir, Driver: "sqlite3", ResumeMode: true}
+	assert.NoError(t, MigrateWithConfig(db, config))
+	var count int
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count))
+	assert.Equal(t, 2, count)
+}
@@ -0,0 +1,193 @@
+package gosmm
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	migrationLockTable = "gosmm_migration_lock"
+	// leasePollInterval is the delay between two attempts to take a lease held by another run
+	leasePollInterval = time.Second
+	// leaseInsertAttempts bounds the attempts to take a lease released between a failed insert and the lookup
+	// of its owner, after which the error of the insert is returned
+	leaseInsertAttempts = 3
+)
+
+// lockTableName returns the lock table name, qualified with the schema if one is given
+func lockTableName(driver string, schema string) string {
+	if schema == "" {
+		return migrationLockTable
+	}
+	return quoteIdentifier(driver, schema) + "." + migrationLockTable
+}
+
+// acquireLease takes the lease named name in the lock table, waiting for the run holding it to release it
+// or for its lease to expire, at most config.WaitForLock unless zero. The lease is renewed every third of
+// config.Lease until the returned function releases it, so that it expires after the process was killed.
+// Once the lease is taken by another run, or expired before it could be renewed, lost is called with an
+// ErrLeaseLost error and the renewals stop; a nil lost only prints a warning.
+// Expiry is computed with the clock of each process, so their clocks must be roughly in sync.
+func acquireLease(ctx context.Context, db *sql.DB, config MigrationConfig, name string, lost func(error)) (func() error, error) {
+	if ddl := createSchemaDDL(config.Driver, config.Schema); config.Schema != "" && ddl != "" {
+		if _, err := db.ExecContext(ctx, ddl); err != nil {
+			return nil, fmt.Errorf("failed to create schema: %w", err)
+		}
+	}
+	table := lockTableName(config.Driver, config.Schema)
+	if _, err := db.ExecContext(ctx, lockTableDDL(config.Driver, table)); err != nil {
+		return nil, fmt.Errorf("failed to create lock table: %w", err)
+	}
+
+	owner, err := leaseOwner()
+	if err != nil {
+		return nil, err
+	}
+	var deadline time.Time
+	if config.WaitForLock > 0 {
+		deadline = time.Now().Add(config.WaitForLock)
+	}
+	waiting := false
+	for {
+		holder, err := tryAcquireLease(ctx, db, config.Driver, table, name, owner, config.Lease)
+		if err != nil {
+			return nil, err
+		}
+		if holder == "" {
+			break
+		}
+		if !waiting {
+			config.LogLevel.printf(LogInfo, "Waiting for the migration lease held by %s\n", holder)
+			waiting = true
+		}
+		delay := leasePollInterval
+		if !deadline.IsZero() {
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				return nil, fmt.Errorf("%w after %s, held by %s", ErrLockTimeout, config.WaitForLock, holder)
+			}
+			if remaining < delay {
+				delay = remaining
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(config.Lease / 3)
+		defer ticker.Stop()
+		expiresAt := time.Now().Add(config.Lease)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				renewedAt := time.Now()
+				err := renewLease(db, config.Driver, table, name, owner, config.Lease)
+				if err == nil {
+					expiresAt = renewedAt.Add(config.Lease)
+					continue
+				}
+				config.LogLevel.printf(LogWarn, "WARNING: failed to renew the migration lease: %v\n", err)
+				if lost == nil {
+					continue
+				}
+				if !errors.Is(err, ErrLeaseLost) {
+					// a failed renewal is retried until the lease expires, after which another run may take it
+					if time.Now().Before(expiresAt) {
+						continue
+					}
+					err = fmt.Errorf("%w: it expired before it could be renewed: %v", ErrLeaseLost, err)
+				}
+				lost(err)
+				return
+			}
+		}
+	}()
+
+	return func() error {
+		close(stop)
+		<-stopped
+		query := `DELETE FROM ` + table + ` WHERE lock_name = ` + bindParam(config.Driver, 1) + ` AND owner = ` + bindParam(config.Driver, 2)
+		if _, err := db.Exec(query, name, owner); err != nil {
+			return fmt.Errorf("failed to release lease: %w", err)
+		}
+		return nil
+	}, nil
+}
+
+// tryAcquireLease takes the lease if it is free or expired. It returns the owner of the lease when another run holds it.
+func tryAcquireLease(ctx context.Context, db *sql.DB, driver string, table string, name string, owner string, lease time.Duration) (string, error) {
+	var insertErr error
+	for attempt := 0; attempt < leaseInsertAttempts; attempt++ {
+		now := time.Now()
+		expiresAt := now.Add(lease).UnixMilli()
+
+		// takes over an expired lease, e.g. of a pod killed during its run
+		result, err := db.ExecContext(ctx, `UPDATE `+table+` SET owner = `+bindParam(driver, 1)+`, expires_at = `+bindParam(driver, 2)+
+			` WHERE lock_name = `+bindParam(driver, 3)+` AND expires_at < `+bindParam(driver, 4), owner, expiresAt, name, now.UnixMilli())
+		if err != nil {
+			return "", fmt.Errorf("failed to take over expired lease: %w", err)
+		}
+		if n, err := result.RowsAffected(); err == nil && n == 1 {
+			return "", nil
+		}
+
+		_, insertErr = db.ExecContext(ctx, `INSERT INTO `+table+` (lock_name, owner, expires_at) VALUES (`+bindParams(driver, 3)+`)`, name, owner, expiresAt)
+		if insertErr == nil {
+			return "", nil
+		}
+
+		// the insert fails when another run holds the lease
+		var holder string
+		err = db.QueryRowContext(ctx, `SELECT owner FROM `+table+` WHERE lock_name = `+bindParam(driver, 1), name).Scan(&holder)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue // released in the meantime
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to get lease owner: %w", err)
+		}
+		return holder, nil
+	}
+	return "", fmt.Errorf("failed to take lease: %w", insertErr)
+}
+
+// renewLease extends the lease held by owner, failing with ErrLeaseLost when another run took it
+func renewLease(db *sql.DB, driver string, table string, name string, owner string, lease time.Duration) error {
+	result, err := db.Exec(`UPDATE `+table+` SET expires_at = `+bindParam(driver, 1)+
+		` WHERE lock_name = `+bindParam(driver, 2)+` AND owner = `+bindParam(driver, 3), time.Now().Add(lease).UnixMilli(), name, owner)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%w: it expired and was taken by another run", ErrLeaseLost)
+	}
+	return nil
+}
+
+// leaseOwner identifies this run in the lock table by the host name, which is the pod name in Kubernetes,
+// the process id and a random suffix
+func leaseOwner() (string, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", fmt.Errorf("failed to generate lease owner: %w", err)
+	}
+	return fmt.Sprintf("%s:%d:%s", host, os.Getpid(), hex.EncodeToString(suffix)), nil
+}
@@ -0,0 +1,149 @@
+package gosmm
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrateWithLease(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+	db.SetMaxOpenConns(1)
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3", Lease: time.Minute}
+
+	err := MigrateWithConfig(db, config)
+	assert.NoError(t, err)
+
+	// The lease is released after the run
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM gosmm_migration_lock").Scan(&count)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	// A lease held by another run makes the run fail after WaitForLock
+	expiresAt := time.Now().Add(time.Minute).UnixMilli()
+	if _, err := db.Exec("INSERT INTO gosmm_migration_lock (lock_name, owner, expires_at) VALUES ('gosmm_migration_history', 'pod-1', ?)", expiresAt); err != nil {
+		t.Fatalf("Failed to insert lease: %v", err)
+	}
+	config.WaitForLock = 50 * time.Millisecond
+	err = MigrateWithConfig(db, config)
+	assert.True(t, errors.Is(err, ErrLockTimeout))
+	assert.Contains(t, err.Error(), "pod-1")
+
+	// An expired lease, e.g. of a killed pod, is taken over
+	if _, err := db.Exec("UPDATE gosmm_migration_lock SET expires_at = ?", time.Now().Add(-time.Second).UnixMilli()); err != nil {
+		t.Fatalf("Failed to expire lease: %v", err)
+	}
+	err = MigrateWithConfig(db, config)
+	assert.NoError(t, err)
+	err = db.QueryRow("SELECT COUNT(*) FROM gosmm_migration_lock").Scan(&count)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestAcquireLeaseWaits(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+	db.SetMaxOpenConns(1)
+
+	config := MigrationConfig{Driver: "sqlite3", Lease: time.Minute, WaitForLock: 5 * time.Second}
+	release, err := acquireLease(context.Background(), db, config, "gosmm_migration_history", nil)
+	if err != nil {
+		t.Fatalf("Failed to acquire lease: %v", err)
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		release()
+	}()
+
+	// The second run blocks until the first one releases the lease
+	release, err = acquireLease(context.Background(), db, config, "gosmm_migration_history", nil)
+	assert.NoError(t, err)
+	assert.NoError(t, release())
+
+	// Cancelling the context stops the wait
+	release, err = acquireLease(context.Background(), db, config, "gosmm_migration_history", nil)
+	assert.NoError(t, err)
+	defer release()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = acquireLease(ctx, db, config, "gosmm_migration_history", nil)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+func TestRenewLease(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	config := MigrationConfig{Driver: "sqlite3", Lease: time.Minute}
+	release, err := acquireLease(context.Background(), db, config, "gosmm_migration_history", nil)
+	if err != nil {
+		t.Fatalf("Failed to acquire lease: %v", err)
+	}
+	defer release()
+
+	var owner string
+	var before int64
+	err = db.QueryRow("SELECT owner, expires_at FROM gosmm_migration_lock").Scan(&owner, &before)
+	assert.NoError(t, err)
+
+	time.Sleep(5 * time.Millisecond)
+	assert.NoError(t, renewLease(db, "sqlite3", "gosmm_migration_lock", "gosmm_migration_history", owner, time.Minute))
+	var after int64
+	err = db.QueryRow("SELECT expires_at FROM gosmm_migration_lock").Scan(&after)
+	assert.NoError(t, err)
+	assert.Greater(t, after, before)
+
+	// A lease taken by another run cannot be renewed
+	assert.Error(t, renewLease(db, "sqlite3", "gosmm_migration_lock", "gosmm_migration_history", "pod-2", time.Minute))
+}
+
+func TestMigrateAbortsOnLostLease(t *testing.T) {
+	// a file database, so that the lease is renewed on another connection while the migration runs
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "gosmm.db"))
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+
+	started := make(chan struct{})
+	config := MigrationConfig{MigrationsDir: t.TempDir(), Driver: "sqlite3", Lease: 150 * time.Millisecond, GoMigrations: map[string]GoMigrationFunc{
+		"v20230101_backfill_users_00001": func(ctx context.Context, conn *sql.Conn, tx *sql.Tx) error {
+			close(started)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(5 * time.Second):
+				return errors.New("the migration was not aborted")
+			}
+		},
+	}}
+	go func() {
+		<-started
+		// another run takes over the lease, e.g. after this one was paused longer than the lease
+		if _, err := db.Exec("UPDATE gosmm_migration_lock SET owner = 'pod-2'"); err != nil {
+			t.Errorf("Failed to take over lease: %v", err)
+		}
+	}()
+
+	err = MigrateWithConfig(db, config)
+	assert.True(t, errors.Is(err, ErrLeaseLost), "%v", err)
+	assert.True(t, errors.Is(err, ErrInterrupted), "%v", err)
+
+	// The migration is left pending for the run holding the lease
+	history, err := GetHistory(db, config)
+	assert.NoError(t, err)
+	assert.Empty(t, history)
+}
@@ -0,0 +1,311 @@
+package gosmm
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// lintIgnoreMarker starts a comment acknowledging the lint rules a statement breaks on purpose,
+	// e.g. "-- gosmm:lint-ignore drop-column"
+	lintIgnoreMarker = "-- gosmm:lint-ignore"
+	// defaultBigTableRows is the default of LintConfig.BigTableRows
+	defaultBigTableRows = 100000
+)
+
+var (
+	sqlStringPattern       = regexp.MustCompile(`'(?:[^']|'')*'`)
+	sqlCommentPattern      = regexp.MustCompile(`(?s)--[^\n]*|/\*.*?\*/`)
+	alterTablePattern      = regexp.MustCompile(`(?is)^\s*ALTER\s+TABLE\s+(?:IF\s+EXISTS\s+)?(?:ONLY\s+)?([^\s(]+)\s+(.*)$`)
+	dropClausePattern      = regexp.MustCompile(`(?i)\bDROP\s+(COLUMN\s+)?(?:IF\s+EXISTS\s+)?([^\s,;(]+)`)
+	addClausePattern       = regexp.MustCompile(`(?i)^\s*ADD\s+(COLUMN\s+)?(?:IF\s+NOT\s+EXISTS\s+)?([^\s,;(]+)`)
+	notNullPattern         = regexp.MustCompile(`(?i)\bNOT\s+NULL\b`)
+	columnValuePattern     = regexp.MustCompile(`(?i)\b(DEFAULT|IDENTITY|AUTO_INCREMENT|GENERATED|SERIAL|BIGSERIAL|SMALLSERIAL)\b`)
+	createIndexPattern     = regexp.MustCompile(`(?is)^\s*CREATE\s+(?:UNIQUE\s+)?(?:CLUSTERED\s+|NONCLUSTERED\s+)?INDEX\s+(CONCURRENTLY\s+)?(?:IF\s+NOT\s+EXISTS\s+)?(?:\S+\s+)?ON\s+(?:ONLY\s+)?([^\s(]+)`)
+	sqlServerOnlinePattern = regexp.MustCompile(`(?i)\bONLINE\s*=\s*ON\b`)
+)
+
+// notColumnKeywords follow DROP or ADD in an ALTER TABLE clause that does not drop or add a column
+var notColumnKeywords = map[string]bool{
+	"CONSTRAINT": true, "INDEX": true, "KEY": true, "PRIMARY": true, "FOREIGN": true, "UNIQUE": true, "CHECK": true,
+	"PARTITION": true, "DEFAULT": true, "NOT": true, "IDENTITY": true, "EXPRESSION": true, "FULLTEXT": true,
+	"SPATIAL": true, "PERIOD": true, "SYSTEM": true, "TRIGGER": true,
+}
+
+// LintConfig configures Lint
+type LintConfig struct {
+	// Rules are the rules checked, DefaultLintRules when nil
+	Rules []LintRule
+	// Disable lists the names of rules that are not checked, e.g. "drop-column"
+	Disable []string
+	// BigTableRows is the estimated number of rows from which a table is big, 100000 when zero
+	BigTableRows int64
+}
+
+// LintRule checks the statements of the pending migrations
+type LintRule struct {
+	// Name identifies the rule in LintConfig.Disable and in gosmm:lint-ignore comments
+	Name string
+	// Check returns why the statement breaks the rule, or an empty string
+	Check func(statement LintStatement) string
+}
+
+// LintStatement is a statement of a pending migration checked by a LintRule
+type LintStatement struct {
+	File string
+	// Index is the 1-based index of the statement in the file
+	Index int
+	// SQL is the statement, with its comments
+	SQL    string
+	Driver string
+	// BigTable reports whether the existing table is big, see LintConfig.BigTableRows. Tables that do not
+	// exist yet, e.g. created by a previous pending migration, are not big.
+	BigTable func(table string) bool
+}
+
+// LintIssue is a statement of a pending migration breaking a LintRule
+type LintIssue struct {
+	File string `json:"file"`
+	// Statement is the 1-based index of the statement in the file
+	Statement int    `json:"statement"`
+	Rule      string `json:"rule"`
+	Message   string `json:"message"`
+}
+
+// String returns the issue as "file:statement: rule: message"
+func (i LintIssue) String() string {
+	return fmt.Sprintf("%s:%d: %s: %s", i.File, i.Statement, i.Rule, i.Message)
+}
+
+// DefaultLintRules returns the built-in rules:
+//   - drop-column: dropping a column breaks the application still reading it and loses its data
+//   - not-null-without-default: adding a NOT NULL column without a default fails on a table with rows
+//   - concurrent-index: creating an index on a big table without CONCURRENTLY (Postgres) or ONLINE = ON
+//     (SQL Server) blocks its writes for the whole build
+func DefaultLintRules() []LintRule {
+	return []LintRule{
+		{Name: "drop-column", Check: lintDropColumn},
+		{Name: "not-null-without-default", Check: lintNotNullWithoutDefault},
+		{Name: "concurrent-index", Check: lintConcurrentIndex},
+	}
+}
+
+// Lint checks the statements of the pending migrations against config.Lint, so that risky statements are
+// caught in review rather than in production. A statement breaking a rule on purpose is acknowledged with a
+// "-- gosmm:lint-ignore <rule> ..." comment right before it. Go migrations are not checked, and the database
+// is only read, to find the pending migrations and the size of the tables.
+func Lint(db *sql.DB, config MigrationConfig) ([]LintIssue, error) {
+	report, err := Status(db, config)
+	if err != nil {
+		return nil, err
+	}
+	files, err := config.migrationFiles()
+	if err != nil {
+		return nil, err
+	}
+	paths := make(map[string]string, len(files))
+	for _, file := range files {
+		if file.inEnvironment(config.Environment) {
+			paths[file.name] = file.path
+		}
+	}
+
+	rules := config.Lint.Rules
+	if rules == nil {
+		rules = DefaultLintRules()
+	}
+	disabled := make(map[string]bool, len(config.Lint.Disable))
+	for _, name := range config.Lint.Disable {
+		disabled[name] = true
+	}
+	threshold := config.Lint.BigTableRows
+	if threshold <= 0 {
+		threshold = defaultBigTableRows
+	}
+	bigTables := make(map[string]bool)
+	bigTable := func(table string) bool {
+		big, ok := bigTables[table]
+		if !ok {
+			rows, known := estimateTableRows(db, config.Driver, config.Schema, table)
+			big = known && rows >= threshold
+			bigTables[table] = big
+		}
+		return big
+	}
+
+	var issues []LintIssue
+	for _, migration := range report.Migrations {
+		if migration.State != MigrationPending {
+			continue
+		}
+		if _, ok := config.GoMigrations[migration.Filename]; ok {
+			continue
+		}
+		data, err := config.readMigrationFile(paths[migration.Filename])
+		if err != nil {
+			return nil, err
+		}
+		content, err := replacePlaceholders(string(data), config.Placeholders)
+		if err != nil {
+			return nil, fmt.Errorf("failed to replace placeholders in %s: %w", migration.Filename, err)
+		}
+		content, _ = splitDown(content) // the down section is only executed by Redo
+		for index, statement := range splitStatements(content, config.Driver) {
+			ignored := lintIgnoredRules(statement)
+			for _, rule := range rules {
+				if disabled[rule.Name] || ignored[rule.Name] {
+					continue
+				}
+				message := rule.Check(LintStatement{File: migration.Filename, Index: index + 1, SQL: statement, Driver: config.Driver, BigTable: bigTable})
+				if message != "" {
+					issues = append(issues, LintIssue{File: migration.Filename, Statement: index + 1, Rule: rule.Name, Message: message})
+				}
+			}
+		}
+	}
+	return issues, nil
+}
+
+// lintIgnoredRules returns the rules acknowledged by the gosmm:lint-ignore comments of the statement
+func lintIgnoredRules(statement string) map[string]bool {
+	ignored := make(map[string]bool)
+	for _, line := range strings.Split(statement, "\n") {
+		rest, ok := strings.CutPrefix(strings.TrimSpace(line), lintIgnoreMarker)
+		if !ok {
+			continue
+		}
+		for _, name := range strings.FieldsFunc(rest, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' }) {
+			ignored[name] = true
+		}
+	}
+	return ignored
+}
+
+// lintCode returns the statement without its comments and with empty string literals, so that
+// keywords in them are not matched
+func lintCode(statement string) string {
+	return sqlStringPattern.ReplaceAllString(sqlCommentPattern.ReplaceAllString(statement, ""), "''")
+}
+
+// lintDropColumn reports the columns dropped by an ALTER TABLE statement
+func lintDropColumn(statement LintStatement) string {
+	match := alterTablePattern.FindStringSubmatch(lintCode(statement.SQL))
+	if match == nil {
+		return ""
+	}
+	var columns []string
+	for _, drop := range dropClausePattern.FindAllStringSubmatch(match[2], -1) {
+		if drop[1] != "" || !notColumnKeywords[strings.ToUpper(drop[2])] {
+			columns = append(columns, drop[2])
+		}
+	}
+	if len(columns) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("dropping column(s) %s of %s breaks the application still reading them and loses their data, "+
+		"deploy code that no longer uses them first", strings.Join(columns, ", "), match[1])
+}
+
+// lintNotNullWithoutDefault reports the NOT NULL columns added by an ALTER TABLE statement without a value
+// for the existing rows
+func lintNotNullWithoutDefault(statement LintStatement) string {
+	match := alterTablePattern.FindStringSubmatch(lintCode(statement.SQL))
+	if match == nil {
+		return ""
+	}
+	var columns []string
+	for _, clause := range splitTopLevel(match[2]) {
+		add := addClausePattern.FindStringSubmatch(clause)
+		if add == nil || (add[1] == "" && notColumnKeywords[strings.ToUpper(add[2])]) {
+			continue
+		}
+		if notNullPattern.MatchString(clause) && !columnValuePattern.MatchString(clause) {
+			columns = append(columns, add[2])
+		}
+	}
+	if len(columns) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("adding NOT NULL column(s) %s to %s without a DEFAULT fails when the table has rows", strings.Join(columns, ", "), match[1])
+}
+
+// lintConcurrentIndex reports the indexes created on big tables while blocking their writes
+func lintConcurrentIndex(statement LintStatement) string {
+	code := lintCode(statement.SQL)
+	match := createIndexPattern.FindStringSubmatch(code)
+	if match == nil {
+		return ""
+	}
+	var fix string
+	switch statement.Driver {
+	case "postgres":
+		if match[1] != "" {
+			return ""
+		}
+		fix = "CREATE INDEX CONCURRENTLY"
+	case "sqlserver":
+		if sqlServerOnlinePattern.MatchString(code) {
+			return ""
+		}
+		fix = "WITH (ONLINE = ON)"
+	default:
+		return ""
+	}
+	if !statement.BigTable(match[2]) {
+		return ""
+	}
+	return fmt.Sprintf("creating an index on the big table %s blocks its writes until the index is built, use %s", match[2], fix)
+}
+
+// splitTopLevel splits the clauses of a statement on the commas outside of parentheses
+func splitTopLevel(s string) []string {
+	var clauses []string
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				clauses = append(clauses, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(clauses, s[start:])
+}
+
+// estimateTableRows returns the estimated number of rows of the table from the statistics of the database,
+// false when the table does not exist or the driver keeps no estimate
+func estimateTableRows(db *sql.DB, driver string, schema string, table string) (int64, bool) {
+	if schema != "" && !strings.Contains(table, ".") {
+		table = quoteIdentifier(driver, schema) + "." + table
+	}
+	var rows sql.NullInt64
+	var err error
+	switch driver {
+	case "postgres":
+		// reltuples is -1 for a table that was never analyzed
+		err = db.QueryRow(`SELECT reltuples::bigint FROM pg_class WHERE oid = to_regclass($1)`, table).Scan(&rows)
+	case "sqlserver":
+		err = db.QueryRow(`SELECT SUM(rows) FROM sys.partitions WHERE object_id = OBJECT_ID(@p1) AND index_id IN (0, 1)`, table).Scan(&rows)
+	case "mysql":
+		query := `SELECT table_rows FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?`
+		args := []interface{}{table}
+		if schemaName, name, ok := strings.Cut(table, "."); ok {
+			query = `SELECT table_rows FROM information_schema.tables WHERE table_schema = ? AND table_name = ?`
+			args = []interface{}{strings.Trim(schemaName, "`"), strings.Trim(name, "`")}
+		}
+		err = db.QueryRow(query, args...).Scan(&rows)
+	default:
+		return 0, false
+	}
+	if err != nil || !rows.Valid || rows.Int64 < 0 {
+		return 0, false
+	}
+	return rows.Int64, true
+}
@@ -0,0 +1,87 @@
+package gosmm
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLint(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	migrations := map[string]string{
+		"v20230101_create_users_00001.sql": "CREATE TABLE users (id INTEGER, email TEXT, name TEXT);",
+		"v20230102_drop_name_00002.sql": `ALTER TABLE users DROP COLUMN name;
+-- the column was never read
+-- gosmm:lint-ignore drop-column
+ALTER TABLE users DROP COLUMN email;
+ALTER TABLE users ADD COLUMN status TEXT NOT NULL;
+ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'member';
+UPDATE users SET role = 'DROP COLUMN';`,
+	}
+	for name, content := range migrations {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test migration file: %v", err)
+		}
+	}
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}
+
+	issues, err := Lint(db, config)
+	assert.NoError(t, err)
+	assert.Equal(t, []LintIssue{
+		{File: "v20230102_drop_name_00002.sql", Statement: 1, Rule: "drop-column", Message: "dropping column(s) name of users breaks the application still reading them and loses their data, deploy code that no longer uses them first"},
+		{File: "v20230102_drop_name_00002.sql", Statement: 3, Rule: "not-null-without-default", Message: "adding NOT NULL column(s) status to users without a DEFAULT fails when the table has rows"},
+	}, issues)
+	assert.Equal(t, "v20230102_drop_name_00002.sql:3: not-null-without-default: adding NOT NULL column(s) status to users without a DEFAULT fails when the table has rows", issues[1].String())
+
+	// Rules can be disabled per project
+	config.Lint.Disable = []string{"drop-column", "not-null-without-default"}
+	issues, err = Lint(db, config)
+	assert.NoError(t, err)
+	assert.Empty(t, issues)
+
+	// Custom rules replace the built-in ones
+	config.Lint = LintConfig{Rules: []LintRule{{Name: "no-update", Check: func(statement LintStatement) string {
+		if statement.Index == 5 {
+			return "data migrations belong in Go migrations"
+		}
+		return ""
+	}}}}
+	issues, err = Lint(db, config)
+	assert.NoError(t, err)
+	assert.Equal(t, []LintIssue{{File: "v20230102_drop_name_00002.sql", Statement: 5, Rule: "no-update", Message: "data migrations belong in Go migrations"}}, issues)
+}
+
+func TestLintRules(t *testing.T) {
+	big := func(table string) bool { return table == "events" }
+	tests := []struct {
+		rule      func(LintStatement) string
+		driver    string
+		statement string
+		broken    bool
+	}{
+		{lintDropColumn, "mysql", "ALTER TABLE users DROP email, ADD COLUMN age INT", true},
+		{lintDropColumn, "postgres", "ALTER TABLE users DROP CONSTRAINT users_email_key", false},
+		{lintDropColumn, "postgres", "ALTER TABLE users ALTER COLUMN email DROP NOT NULL", false},
+		{lintDropColumn, "sqlserver", "ALTER TABLE users DROP COLUMN IF EXISTS email", true},
+		{lintDropColumn, "postgres", "DROP TABLE users", false},
+		{lintNotNullWithoutDefault, "sqlserver", "ALTER TABLE users ADD age INT NOT NULL", true},
+		{lintNotNullWithoutDefault, "postgres", "ALTER TABLE users ADD COLUMN id BIGINT GENERATED ALWAYS AS IDENTITY NOT NULL", false},
+		{lintNotNullWithoutDefault, "postgres", "ALTER TABLE users ADD CONSTRAINT users_age CHECK (age IS NOT NULL)", false},
+		{lintNotNullWithoutDefault, "mysql", "ALTER TABLE users ADD COLUMN a INT, ADD COLUMN b DECIMAL(10, 2) NOT NULL", true},
+		{lintConcurrentIndex, "postgres", "CREATE INDEX events_at ON events (created_at)", true},
+		{lintConcurrentIndex, "postgres", "CREATE INDEX CONCURRENTLY events_at ON events (created_at)", false},
+		{lintConcurrentIndex, "postgres", "CREATE UNIQUE INDEX users_email ON users (email)", false},
+		{lintConcurrentIndex, "sqlserver", "CREATE NONCLUSTERED INDEX events_at ON events (created_at)", true},
+		{lintConcurrentIndex, "sqlserver", "CREATE INDEX events_at ON events (created_at) WITH (ONLINE = ON)", false},
+		{lintConcurrentIndex, "mysql", "CREATE INDEX events_at ON events (created_at)", false},
+	}
+	for _, test := range tests {
+		message := test.rule(LintStatement{SQL: test.statement, Driver: test.driver, BigTable: big})
+		assert.Equal(t, test.broken, message != "", test.statement)
+	}
+}
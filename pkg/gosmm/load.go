@@ -0,0 +1,306 @@
+package gosmm
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/lib/pq"
+)
+
+// csvFileExtension is the extension of the CSV files loaded by the load directives, which are not migrations
+const csvFileExtension = ".csv"
+
+// loadDirectivePattern matches a "-- gosmm:load <table> <file.csv>" line, loading the rows of a CSV file into a
+// table through the bulk load of the driver
+var loadDirectivePattern = regexp.MustCompile(`^--\s*gosmm:load\s+(\S+)\s+(\S+\.csv)\s*$`)
+
+// loadBatchParams is the number of parameters of each INSERT statement loading the rows of a CSV file when the
+// driver has no bulk load, below the limits of SQLite and SQL Server
+var loadBatchParams = 900
+
+// loadReaderCount numbers the readers registered for the LOAD DATA LOCAL INFILE statements of MySQL
+var loadReaderCount int64
+
+// bulkLoad is a load directive: the rows of the CSV file at path are inserted into table. The first row of the file
+// holds the names of the columns.
+type bulkLoad struct {
+	table string
+	path  string
+}
+
+// parseLoadDirective returns the bulk load of a statement made of a load directive
+func parseLoadDirective(statement string) (bulkLoad, bool) {
+	match := loadDirectivePattern.FindStringSubmatch(strings.TrimSpace(statement))
+	if match == nil {
+		return bulkLoad{}, false
+	}
+	return bulkLoad{table: match[1], path: match[2]}, true
+}
+
+// resolveLoad makes the path of the CSV file of a load directive relative to dir, the directory of the migration
+// or seed file holding it. Other statements are returned unchanged.
+func resolveLoad(statement string, dir string) string {
+	load, ok := parseLoadDirective(statement)
+	if !ok || filepath.IsAbs(load.path) {
+		return statement
+	}
+	return "-- gosmm:load " + load.table + " " + filepath.Join(dir, load.path)
+}
+
+// resolveLoads applies resolveLoad to each statement
+func resolveLoads(statements []string, dir string) []string {
+	for i, statement := range statements {
+		statements[i] = resolveLoad(statement, dir)
+	}
+	return statements
+}
+
+// fileLoads returns the bulk loads of the migration or seed file at path, with their paths resolved
+func fileLoads(path string) ([]bulkLoad, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	defer file.Close()
+
+	var loads []bulkLoad
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadString('\n')
+		if load, ok := parseLoadDirective(resolveLoad(line, filepath.Dir(path))); ok {
+			loads = append(loads, load)
+		}
+		if err == io.EOF {
+			return loads, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file: %w", err)
+		}
+	}
+}
+
+// loadsChecksum returns checksum combined with the content of the CSV files loaded by the file at path, so that
+// a seed is applied again when one of them changes. The checksum of a file without load directives is unchanged.
+func loadsChecksum(checksum string, path string) (string, error) {
+	loads, err := fileLoads(path)
+	if err != nil || len(loads) == 0 {
+		return checksum, err
+	}
+	hash := sha256.New()
+	hash.Write([]byte(checksum))
+	for _, load := range loads {
+		file, err := os.Open(load.path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read CSV file: %w", err)
+		}
+		_, err = io.Copy(hash, file)
+		file.Close()
+		if err != nil {
+			return "", fmt.Errorf("failed to read CSV file: %w", err)
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// executeLoad inserts the rows of the CSV file of load into its table in tx, or on conn when tx is nil, returning
+// the number of rows inserted. Postgres copies them with COPY FROM STDIN and MySQL with LOAD DATA LOCAL INFILE; the
+// other drivers insert them with batches of INSERT statements. Empty fields are inserted as NULL.
+func executeLoad(ctx context.Context, driver string, conn *sql.Conn, tx *sql.Tx, load bulkLoad) (int64, error) {
+	if dialectFor(driver) != nil {
+		return 0, fmt.Errorf("bulk loads are not supported by the %s driver", driver)
+	}
+	file, err := os.Open(load.path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read CSV file: %w", err)
+	}
+	defer file.Close()
+	reader := csv.NewReader(bufio.NewReader(file))
+	reader.ReuseRecord = true
+	header, err := reader.Read()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read the header of %s: %w", load.path, err)
+	}
+	columns := make([]string, len(header))
+	for i, column := range header {
+		columns[i] = strings.TrimSpace(strings.TrimPrefix(column, byteOrderMark))
+	}
+
+	switch driver {
+	case "postgres":
+		if copied, ok, err := copyFromPgx(ctx, conn, load, columns); ok {
+			return copied, err
+		}
+		if tx != nil {
+			return copyInPq(ctx, tx, reader, load, columns)
+		}
+	case "mysql":
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return 0, fmt.Errorf("failed to read CSV file: %w", err)
+		}
+		return loadDataMySQL(ctx, conn, tx, file, load, columns)
+	}
+	return insertRows(ctx, driver, conn, tx, reader, load, columns)
+}
+
+// copyFromPgx copies the CSV file of load with COPY FROM STDIN on the pgx connection conn, reporting false when
+// conn is not a pgx connection
+func copyFromPgx(ctx context.Context, conn *sql.Conn, load bulkLoad, columns []string) (int64, bool, error) {
+	if conn == nil {
+		return 0, false, nil
+	}
+	var copied int64
+	isPgx := false
+	err := conn.Raw(func(driverConn interface{}) error {
+		pgxConn, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return nil
+		}
+		isPgx = true
+		file, err := os.Open(load.path)
+		if err != nil {
+			return fmt.Errorf("failed to read CSV file: %w", err)
+		}
+		defer file.Close()
+		list := strings.Join(columns, ", ")
+		tag, err := pgxConn.Conn().PgConn().CopyFrom(ctx, file,
+			"COPY "+load.table+" ("+list+") FROM STDIN WITH (FORMAT csv, HEADER true, FORCE_NULL ("+list+"))")
+		copied = tag.RowsAffected()
+		return err
+	})
+	return copied, isPgx, err
+}
+
+// copyInPq copies the rows of reader with the COPY FROM STDIN of lib/pq, which requires a transaction
+func copyInPq(ctx context.Context, tx *sql.Tx, reader *csv.Reader, load bulkLoad, columns []string) (int64, error) {
+	copyIn := pq.CopyIn(load.table, columns...)
+	if schema, table, ok := strings.Cut(load.table, "."); ok {
+		copyIn = pq.CopyInSchema(schema, table, columns...)
+	}
+	stmt, err := tx.PrepareContext(ctx, copyIn)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	var copied int64
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return copied, fmt.Errorf("failed to read %s: %w", load.path, err)
+		}
+		if _, err := stmt.ExecContext(ctx, recordValues(record)...); err != nil {
+			return copied, err
+		}
+		copied++
+	}
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		return copied, err
+	}
+	return copied, nil
+}
+
+// loadDataMySQL loads the CSV file with LOAD DATA LOCAL INFILE, which requires local_infile to be enabled on the
+// server. The file is read through a registered reader, so allowAllFiles is not needed.
+func loadDataMySQL(ctx context.Context, conn *sql.Conn, tx *sql.Tx, file io.Reader, load bulkLoad, columns []string) (int64, error) {
+	name := fmt.Sprintf("gosmm_load_%d", atomic.AddInt64(&loadReaderCount, 1))
+	mysql.RegisterReaderHandler(name, func() io.Reader { return file })
+	defer mysql.DeregisterReaderHandler(name)
+
+	variables, assignments := make([]string, len(columns)), make([]string, len(columns))
+	for i, column := range columns {
+		variables[i] = fmt.Sprintf("@c%d", i+1)
+		assignments[i] = fmt.Sprintf("%s = NULLIF(@c%d, '')", column, i+1)
+	}
+	statement := "LOAD DATA LOCAL INFILE 'Reader::" + name + "' INTO TABLE " + load.table + ` CHARACTER SET utf8mb4
+FIELDS TERMINATED BY ',' OPTIONALLY ENCLOSED BY '"' ESCAPED BY '' LINES TERMINATED BY '\n' IGNORE 1 LINES
+(` + strings.Join(variables, ", ") + ") SET " + strings.Join(assignments, ", ")
+
+	var result sql.Result
+	var err error
+	if tx != nil {
+		result, err = tx.ExecContext(ctx, statement)
+	} else {
+		result, err = conn.ExecContext(ctx, statement)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// insertRows inserts the rows of reader with INSERT statements of loadBatchParams parameters at most
+func insertRows(ctx context.Context, driver string, conn *sql.Conn, tx *sql.Tx, reader *csv.Reader, load bulkLoad, columns []string) (int64, error) {
+	exec := conn.ExecContext
+	if tx != nil {
+		exec = tx.ExecContext
+	}
+	batchRows := loadBatchParams / len(columns)
+	if batchRows == 0 {
+		batchRows = 1
+	}
+
+	var inserted int64
+	var rows []string
+	var args []interface{}
+	flush := func() error {
+		if len(rows) == 0 {
+			return nil
+		}
+		_, err := exec(ctx, "INSERT INTO "+load.table+" ("+strings.Join(columns, ", ")+") VALUES "+strings.Join(rows, ", "), args...)
+		if err != nil {
+			return err
+		}
+		inserted += int64(len(rows))
+		rows, args = rows[:0], args[:0]
+		return nil
+	}
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return inserted, fmt.Errorf("failed to read %s: %w", load.path, err)
+		}
+		params := make([]string, len(record))
+		for i := range record {
+			params[i] = bindParam(driver, len(args)+i+1)
+		}
+		rows = append(rows, "("+strings.Join(params, ", ")+")")
+		args = append(args, recordValues(record)...)
+		if len(rows) == batchRows {
+			if err := flush(); err != nil {
+				return inserted, err
+			}
+		}
+	}
+	return inserted, flush()
+}
+
+// recordValues returns the values of a CSV record, its empty fields being NULL
+func recordValues(record []string) []interface{} {
+	values := make([]interface{}, len(record))
+	for i, field := range record {
+		if field != "" {
+			values[i] = field
+		}
+	}
+	return values
+}
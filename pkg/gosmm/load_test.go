@@ -0,0 +1,83 @@
+package gosmm
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitStatementsWithLoadDirective(t *testing.T) {
+	statements := splitStatements("CREATE TABLE countries (code TEXT, name TEXT);\n-- gosmm:load countries data/countries.csv\nSELECT 1;", "sqlite3")
+	assert.Equal(t, []string{
+		"CREATE TABLE countries (code TEXT, name TEXT)",
+		"-- gosmm:load countries data/countries.csv",
+		"SELECT 1",
+	}, statements)
+
+	load, ok := parseLoadDirective(resolveLoad(statements[1], "/migrations"))
+	assert.True(t, ok)
+	assert.Equal(t, bulkLoad{table: "countries", path: "/migrations/data/countries.csv"}, load)
+	_, ok = parseLoadDirective("-- gosmm:load countries")
+	assert.False(t, ok)
+}
+
+func TestMigrateWithLoadDirective(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "countries.csv"), []byte("code,name,capital\nJP,Japan,Tokyo\nFR,\"France, Republic of\",\nUS,United States,Washington\n"), 0644); err != nil {
+		t.Fatalf("Failed to create CSV file: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_countries_00001.sql"), []byte("CREATE TABLE countries (code TEXT, name TEXT, capital TEXT);\n-- gosmm:load countries countries.csv\n"), 0644); err != nil {
+		t.Fatalf("Failed to create migration file: %v", err)
+	}
+
+	defer func(params int) { loadBatchParams = params }(loadBatchParams)
+	loadBatchParams = 6 // two rows per INSERT statement
+
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}
+	assert.NoError(t, MigrateWithConfig(db, config))
+
+	var name string
+	var capital sql.NullString
+	assert.NoError(t, db.QueryRow("SELECT name, capital FROM countries WHERE code = 'FR'").Scan(&name, &capital))
+	assert.Equal(t, "France, Republic of", name)
+	assert.False(t, capital.Valid)
+	history, err := GetHistory(db, config)
+	assert.NoError(t, err)
+	if assert.Len(t, history, 1) {
+		assert.Equal(t, int64(3), history[0].RowsAffected)
+	}
+}
+
+func TestSeedWithLoadDirective(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	if _, err := db.Exec("CREATE TABLE countries (code TEXT PRIMARY KEY, name TEXT)"); err != nil {
+		t.Fatalf("Failed to create countries table: %v", err)
+	}
+	seedsDir := t.TempDir()
+	csvFile := filepath.Join(seedsDir, "countries.csv")
+	if err := ioutil.WriteFile(csvFile, []byte("code,name\nJP,Japan\n"), 0644); err != nil {
+		t.Fatalf("Failed to create CSV file: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(seedsDir, "countries.sql"), []byte("DELETE FROM countries;\n-- gosmm:load countries countries.csv\n"), 0644); err != nil {
+		t.Fatalf("Failed to create seed file: %v", err)
+	}
+	config := MigrationConfig{SeedsDir: seedsDir, Driver: "sqlite3"}
+	assert.NoError(t, Seed(db, config))
+
+	// A changed CSV file applies the seed again
+	if err := ioutil.WriteFile(csvFile, []byte("code,name\nJP,Japan\nFR,France\n"), 0644); err != nil {
+		t.Fatalf("Failed to update CSV file: %v", err)
+	}
+	assert.NoError(t, Seed(db, config))
+	var count int
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM countries").Scan(&count))
+	assert.Equal(t, 2, count)
+}
@@ -0,0 +1,173 @@
+package gosmm
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"time"
+)
+
+// lockRun serializes the runs against table, with a lease of the lock table when config.Lease is set and
+// with a database lock otherwise, which CockroachDB does not implement. It waits config.WaitForLock at most,
+// and indefinitely when zero. It returns a function releasing the lock. lost is called when the lease is lost,
+// see acquireLease, e.g. to abort the run.
+func lockRun(ctx context.Context, db *sql.DB, config MigrationConfig, table string, cockroach bool, lost func(error)) (func() error, error) {
+	var unlock func() error
+	var err error
+	switch {
+	case config.Lease > 0:
+		unlock, err = acquireLease(ctx, db, config, table, lost)
+	case cockroach:
+		unlock = func() error { return nil }
+	default:
+		unlock, err = acquireLock(ctx, db, config.Driver, table, config.WaitForLock)
+	}
+	if err != nil {
+		return nil, err
+	}
+	config.LogLevel.printf(LogTrace, "LOCK  %s acquired\n", table)
+	return func() error {
+		config.LogLevel.printf(LogTrace, "LOCK  %s released\n", table)
+		return unlock()
+	}, nil
+}
+
+// leaseLostError returns err wrapped with the ErrLeaseLost cause of ctx when the run was aborted by the loss of
+// its lease, see lockRun
+func leaseLostError(ctx context.Context, err error) error {
+	if cause := context.Cause(ctx); err != nil && errors.Is(cause, ErrLeaseLost) && !errors.Is(err, ErrLeaseLost) {
+		return fmt.Errorf("%w: %w", cause, err)
+	}
+	return err
+}
+
+// acquireLock takes a database level lock so that concurrent runs against the same
+// history table do not apply migrations twice. It returns a function releasing the lock,
+// or ErrLockTimeout when the lock was not granted within wait (unless wait is zero).
+// Drivers without a lock implementation get a no-op lock.
+func acquireLock(ctx context.Context, db *sql.DB, driver string, table string, wait time.Duration) (func() error, error) {
+	if dialect := dialectFor(driver); dialect != nil {
+		return dialect.Lock(ctx, db, table, wait)
+	}
+	switch driver {
+	case "postgres":
+		return acquirePostgresAdvisoryLock(ctx, db, lockKey(table), wait)
+	case "mysql":
+		return acquireMySQLNamedLock(ctx, db, "gosmm:"+table, wait)
+	case "sqlserver":
+		return acquireSQLServerAppLock(ctx, db, "gosmm:"+table, wait)
+	default:
+		return func() error { return nil }, nil
+	}
+}
+
+// lockKey derives the advisory lock key from the history table name
+func lockKey(table string) int64 {
+	return int64(crc32.ChecksumIEEE([]byte("gosmm:" + table)))
+}
+
+// acquirePostgresAdvisoryLock blocks until the session level advisory lock is granted.
+// The lock is held by a dedicated connection, since advisory locks belong to a session.
+// The wait is bounded by cancelling the statement.
+func acquirePostgresAdvisoryLock(ctx context.Context, db *sql.DB, key int64, wait time.Duration) (func() error, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	lockCtx := ctx
+	if wait > 0 {
+		var cancel context.CancelFunc
+		lockCtx, cancel = context.WithTimeout(ctx, wait)
+		defer cancel()
+	}
+	if _, err := conn.ExecContext(lockCtx, `SELECT pg_advisory_lock($1)`, key); err != nil {
+		conn.Close()
+		if ctx.Err() == nil && errors.Is(lockCtx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%w after %s", ErrLockTimeout, wait)
+		}
+		return nil, fmt.Errorf("failed to acquire advisory lock: %w", err)
+	}
+
+	return func() error {
+		defer conn.Close()
+		if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, key); err != nil {
+			return fmt.Errorf("failed to release advisory lock: %w", err)
+		}
+		return nil
+	}, nil
+}
+
+// acquireMySQLNamedLock blocks until the named lock is granted with GET_LOCK.
+// The lock is held by a dedicated connection, since named locks belong to a session.
+// GET_LOCK waits whole seconds, so wait is rounded up.
+func acquireMySQLNamedLock(ctx context.Context, db *sql.DB, name string, wait time.Duration) (func() error, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	timeout := int64(-1)
+	if wait > 0 {
+		timeout = int64(math.Ceil(wait.Seconds()))
+	}
+	var acquired sql.NullInt64
+	if err := conn.QueryRowContext(ctx, `SELECT GET_LOCK(?, ?)`, name, timeout).Scan(&acquired); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to acquire named lock: %w", err)
+	}
+	if acquired.Valid && acquired.Int64 == 0 {
+		conn.Close()
+		return nil, fmt.Errorf("%w after %s", ErrLockTimeout, wait)
+	}
+	if !acquired.Valid || acquired.Int64 != 1 {
+		conn.Close()
+		return nil, fmt.Errorf("failed to acquire named lock: %s", name)
+	}
+
+	return func() error {
+		defer conn.Close()
+		if _, err := conn.ExecContext(context.Background(), `SELECT RELEASE_LOCK(?)`, name); err != nil {
+			return fmt.Errorf("failed to release named lock: %w", err)
+		}
+		return nil
+	}, nil
+}
+
+// acquireSQLServerAppLock blocks until the session owned application lock is granted with sp_getapplock
+func acquireSQLServerAppLock(ctx context.Context, db *sql.DB, resource string, wait time.Duration) (func() error, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	timeout := int64(-1)
+	if wait > 0 {
+		timeout = wait.Milliseconds()
+	}
+	var result int
+	err = conn.QueryRowContext(ctx, `DECLARE @result INT;
+		EXEC @result = sp_getapplock @Resource = @p1, @LockMode = 'Exclusive', @LockOwner = 'Session', @LockTimeout = @p2;
+		SELECT @result`, resource, timeout).Scan(&result)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to acquire application lock: %w", err)
+	}
+	// -1 is returned when the lock request timed out
+	if result == -1 {
+		conn.Close()
+		return nil, fmt.Errorf("%w after %s", ErrLockTimeout, wait)
+	}
+	if result < 0 {
+		conn.Close()
+		return nil, fmt.Errorf("failed to acquire application lock: sp_getapplock returned %d", result)
+	}
+
+	return func() error {
+		defer conn.Close()
+		if _, err := conn.ExecContext(context.Background(), `EXEC sp_releaseapplock @Resource = @p1, @LockOwner = 'Session'`, resource); err != nil {
+			return fmt.Errorf("failed to release application lock: %w", err)
+		}
+		return nil
+	}, nil
+}
@@ -0,0 +1,79 @@
+package gosmm
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// LogLevel is the verbosity of the messages printed while migrating. The zero value is LogInfo.
+type LogLevel string
+
+const (
+	// LogError only prints the failed targets of MigrateAll to stderr, the errors being returned to the caller
+	LogError LogLevel = "error"
+	// LogWarn prints the warnings to stderr, e.g. retries and failed notifications
+	LogWarn LogLevel = "warn"
+	// LogInfo also prints each applied, forced or rolled back migration to stdout
+	LogInfo LogLevel = "info"
+	// LogDebug also prints each executed statement
+	LogDebug LogLevel = "debug"
+	// LogTrace also prints the duration and the rows affected of each statement, and the migration lock
+	LogTrace LogLevel = "trace"
+)
+
+// logLevels orders the levels from the least to the most verbose
+var logLevels = []LogLevel{LogError, LogWarn, LogInfo, LogDebug, LogTrace}
+
+// ParseLogLevel returns the level named s, e.g. "debug"
+func ParseLogLevel(s string) (LogLevel, error) {
+	for _, level := range logLevels {
+		if string(level) == s {
+			return level, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported log level: %s", s)
+}
+
+// rank returns the position of the level in logLevels, the one of LogInfo for the zero value
+func (l LogLevel) rank() int {
+	if l == "" {
+		l = LogInfo
+	}
+	for i, level := range logLevels {
+		if level == l {
+			return i
+		}
+	}
+	return 2
+}
+
+// Enabled reports whether the messages of level are printed at the level l
+func (l LogLevel) Enabled(level LogLevel) bool {
+	return level.rank() <= l.rank()
+}
+
+// printf prints the message of level when it is enabled at the level l, to stderr for warnings and errors
+// and to stdout otherwise
+func (l LogLevel) printf(level LogLevel, format string, args ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	w := os.Stdout
+	if level.rank() <= LogWarn.rank() {
+		w = os.Stderr
+	}
+	fmt.Fprintf(w, format, args...)
+}
+
+// statementLogger returns a callback of executeStatements printing each executed statement of filename at
+// LogDebug, with its duration and the rows it affected at LogTrace
+func (l LogLevel) statementLogger(filename string) func(index int, statement string, duration time.Duration, rowsAffected int64) {
+	return func(index int, statement string, duration time.Duration, rowsAffected int64) {
+		if l.Enabled(LogTrace) {
+			l.printf(LogTrace, "EXEC  %s (statement %d, %s, %d row(s)): %s\n", filename, index, duration, rowsAffected, statement)
+		} else {
+			l.printf(LogDebug, "EXEC  %s (statement %d): %s\n", filename, index, statement)
+		}
+	}
+}
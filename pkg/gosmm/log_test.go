@@ -0,0 +1,89 @@
+package gosmm
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLogLevel(t *testing.T) {
+	for _, name := range []string{"error", "warn", "info", "debug", "trace"} {
+		level, err := ParseLogLevel(name)
+		assert.NoError(t, err)
+		assert.Equal(t, LogLevel(name), level)
+	}
+	_, err := ParseLogLevel("verbose")
+	assert.EqualError(t, err, "unsupported log level: verbose")
+}
+
+func TestLogLevelEnabled(t *testing.T) {
+	var level LogLevel // LogInfo
+	assert.True(t, level.Enabled(LogWarn))
+	assert.True(t, level.Enabled(LogInfo))
+	assert.False(t, level.Enabled(LogDebug))
+	assert.False(t, LogError.Enabled(LogWarn))
+	assert.True(t, LogTrace.Enabled(LogDebug))
+}
+
+// captureStdout returns what fn prints to stdout
+func captureStdout(t *testing.T, fn func()) string {
+	old := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Failed to create pipe: %v", err)
+	}
+	os.Stdout = w
+	fn()
+	w.Close()
+	os.Stdout = old
+	var buf bytes.Buffer
+	buf.ReadFrom(r)
+	return buf.String()
+}
+
+func TestMigrateLogLevel(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER);\nINSERT INTO users (id) VALUES (1);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+
+	// The applied migrations are printed by default
+	db, teardown := setupTestDB(t)
+	defer teardown()
+	output := captureStdout(t, func() {
+		assert.NoError(t, MigrateWithConfig(db, MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}))
+	})
+	assert.Equal(t, "OK    v20230101_create_users_00001.sql\n", output)
+
+	// Nothing is printed below LogInfo
+	db, teardown = setupTestDB(t)
+	defer teardown()
+	output = captureStdout(t, func() {
+		assert.NoError(t, MigrateWithConfig(db, MigrationConfig{MigrationsDir: dir, Driver: "sqlite3", LogLevel: LogWarn}))
+	})
+	assert.Empty(t, output)
+
+	// LogDebug echoes each executed statement
+	db, teardown = setupTestDB(t)
+	defer teardown()
+	output = captureStdout(t, func() {
+		assert.NoError(t, MigrateWithConfig(db, MigrationConfig{MigrationsDir: dir, Driver: "sqlite3", LogLevel: LogDebug}))
+	})
+	assert.Equal(t, "EXEC  v20230101_create_users_00001.sql (statement 1): CREATE TABLE users (id INTEGER)\n"+
+		"EXEC  v20230101_create_users_00001.sql (statement 2): INSERT INTO users (id) VALUES (1)\n"+
+		"OK    v20230101_create_users_00001.sql\n", output)
+
+	// LogTrace adds the rows affected and the lock
+	db, teardown = setupTestDB(t)
+	defer teardown()
+	output = captureStdout(t, func() {
+		assert.NoError(t, MigrateWithConfig(db, MigrationConfig{MigrationsDir: dir, Driver: "sqlite3", LogLevel: LogTrace}))
+	})
+	assert.Contains(t, output, "LOCK  gosmm_migration_history acquired\n")
+	assert.Contains(t, output, ", 1 row(s)): INSERT INTO users (id) VALUES (1)\n")
+	assert.Contains(t, output, "LOCK  gosmm_migration_history released\n")
+}
@@ -0,0 +1,188 @@
+package gosmm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxDescriptionLength is the size of the description column of the history table
+const maxDescriptionLength = 1000
+
+// metadataPattern matches a line of the header of a migration file, e.g. "-- gosmm:author Jane Doe"
+var metadataPattern = regexp.MustCompile(`^--\s*gosmm:([a-z-]+)(?:\s+(.*))?$`)
+
+// MigrationMetadata is read from the header of a migration file, the comment lines preceding its first
+// statement, e.g.
+//
+//	-- gosmm:author Jane Doe
+//	-- gosmm:ticket PROJ-123
+//	-- gosmm:description Index the emails of the users
+//	-- gosmm:transactional false
+//	-- gosmm:timeout 30m
+//	-- gosmm:requires v20230101_create_users_00001.sql
+//	-- gosmm:only-env prod staging
+//	-- gosmm:touches users
+//	-- gosmm:destructive true
+//	-- gosmm:labels billing critical
+//	-- gosmm:analyze users
+//
+// Author, Ticket and Description are recorded in the history table. Placeholders are not replaced in the header.
+type MigrationMetadata struct {
+	Author      string `json:"author,omitempty"`
+	Ticket      string `json:"ticket,omitempty"`
+	Description string `json:"description,omitempty"`
+	// NonTransactional is set by "gosmm:transactional false": the statements run outside of a transaction,
+	// e.g. CREATE INDEX CONCURRENTLY on Postgres, and each one is committed when it completes
+	NonTransactional bool `json:"non_transactional,omitempty"`
+	// Timeout bounds the execution of the statements, zero when unbounded
+	Timeout time.Duration `json:"timeout,omitempty"`
+	// Requires are the migrations applied before this one, whatever their names, see orderMigrations.
+	// A line can list several migrations separated by spaces or commas.
+	Requires []string `json:"requires,omitempty"`
+	// OnlyEnvironments restricts the migration to the listed environments, e.g. one creating a foreign data
+	// wrapper only available in production. It is not applied, and not listed, in the other environments.
+	OnlyEnvironments []string `json:"only_environments,omitempty"`
+	// Touches are the tables and other objects the migration changes, so that migrations touching disjoint
+	// objects can be applied in parallel, see MigrationConfig.Parallelism
+	Touches []string `json:"touches,omitempty"`
+	// Destructive is set by "gosmm:destructive true" on a migration losing data in a way that is not detected,
+	// e.g. a DELETE, so that MigrationConfig.Backup takes a backup before it
+	Destructive bool `json:"destructive,omitempty"`
+	// Labels name the domains of the migration, e.g. billing, so that a run can apply the migrations of some
+	// domains only, see MigrationConfig.Labels
+	Labels []string `json:"labels,omitempty"`
+	// Analyze is set by "gosmm:analyze", alone or followed by true or by tables, to refresh the statistics of
+	// the tables after the migration, see MigrationConfig.Analyze
+	Analyze bool `json:"analyze,omitempty"`
+	// AnalyzeTables are the tables listed by "gosmm:analyze", analyzed instead of the tables the migration writes to
+	AnalyzeTables []string `json:"analyze_tables,omitempty"`
+	// SkipAnalyze is set by "gosmm:analyze false", so that the migration is not analyzed even when
+	// MigrationConfig.Analyze is set
+	SkipAnalyze bool `json:"skip_analyze,omitempty"`
+}
+
+// parseMetadata returns the metadata of the header of a migration file. Unknown keys are ignored,
+// as are the gosmm annotations after the header, e.g. "-- gosmm:lint-ignore".
+func parseMetadata(content string) (MigrationMetadata, error) {
+	var metadata MigrationMetadata
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "--") {
+			break // the first statement ends the header
+		}
+		match := metadataPattern.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		key, value := match[1], strings.TrimSpace(match[2])
+		switch key {
+		case "author":
+			metadata.Author = value
+		case "ticket":
+			metadata.Ticket = value
+		case "description":
+			if len(value) > maxDescriptionLength {
+				return MigrationMetadata{}, fmt.Errorf("description longer than %d characters", maxDescriptionLength)
+			}
+			metadata.Description = value
+		case "transactional":
+			transactional, err := strconv.ParseBool(value)
+			if err != nil {
+				return MigrationMetadata{}, fmt.Errorf("invalid transactional %q: %w", value, err)
+			}
+			metadata.NonTransactional = !transactional
+		case "timeout":
+			timeout, err := time.ParseDuration(value)
+			if err != nil || timeout <= 0 {
+				return MigrationMetadata{}, fmt.Errorf("invalid timeout %q, expected a positive duration such as 30s", value)
+			}
+			metadata.Timeout = timeout
+		case "requires":
+			metadata.Requires = append(metadata.Requires, splitList(value)...)
+		case "only-env":
+			metadata.OnlyEnvironments = append(metadata.OnlyEnvironments, splitList(value)...)
+		case "touches":
+			metadata.Touches = append(metadata.Touches, splitList(value)...)
+		case "destructive":
+			destructive, err := strconv.ParseBool(value)
+			if err != nil {
+				return MigrationMetadata{}, fmt.Errorf("invalid destructive %q: %w", value, err)
+			}
+			metadata.Destructive = destructive
+		case "labels":
+			metadata.Labels = append(metadata.Labels, splitList(value)...)
+		case "analyze":
+			if analyze, err := strconv.ParseBool(value); err == nil {
+				metadata.Analyze, metadata.SkipAnalyze = analyze, !analyze
+				continue
+			}
+			metadata.Analyze = true
+			metadata.AnalyzeTables = append(metadata.AnalyzeTables, splitList(value)...)
+		}
+	}
+	if len(metadata.Author) > 255 || len(metadata.Ticket) > 255 {
+		return MigrationMetadata{}, fmt.Errorf("author or ticket longer than 255 characters")
+	}
+	return metadata, nil
+}
+
+// splitList splits a list of values separated by spaces or commas
+func splitList(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+}
+
+// migrationFileMetadata returns the metadata of the migration file at path, reading its header only
+func migrationFileMetadata(path string) (MigrationMetadata, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return MigrationMetadata{}, fmt.Errorf("failed to read file: %w", err)
+	}
+	defer file.Close()
+	header, err := readHeader(bufio.NewReader(file))
+	if err != nil {
+		return MigrationMetadata{}, fmt.Errorf("failed to read file: %w", err)
+	}
+	return parseMetadata(header)
+}
+
+// readHeader reads the blank and comment lines preceding the first statement of a file, which hold its metadata.
+// The first line of the statement is not read, so that a file made of a single huge line is not loaded in memory.
+func readHeader(reader *bufio.Reader) (string, error) {
+	var header strings.Builder
+	for {
+		c, err := reader.ReadByte()
+		if err == io.EOF {
+			return header.String(), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if c == ' ' || c == '\t' || c == '\r' || c == '\n' {
+			header.WriteByte(c)
+			continue
+		}
+		if next, err := reader.Peek(1); c != '-' || err != nil || next[0] != '-' {
+			return header.String(), nil
+		}
+		line, err := reader.ReadString('\n')
+		header.WriteByte(c)
+		header.WriteString(line)
+		if err == io.EOF {
+			return header.String(), nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package gosmm
+
+import (
+	"context"
+	"database/sql"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseMetadata(t *testing.T) {
+	metadata, err := parseMetadata(`-- Index the emails
+-- gosmm:author Jane Doe
+-- gosmm:ticket PROJ-123
+--gosmm:description Index the emails of the users
+
+-- gosmm:transactional false
+-- gosmm:timeout 30m
+-- gosmm:unknown ignored
+-- gosmm:requires v20230101_create_users_00001.sql, v20230102_add_email_00002.sql
+-- gosmm:requires v20230103_seed_users
+-- gosmm:only-env prod, staging
+-- gosmm:touches users
+-- gosmm:destructive true
+-- gosmm:labels billing,critical
+-- gosmm:analyze users
+CREATE INDEX CONCURRENTLY users_email ON users (email);
+-- gosmm:author after the header
+`)
+	assert.NoError(t, err)
+	assert.Equal(t, MigrationMetadata{
+		Author:           "Jane Doe",
+		Ticket:           "PROJ-123",
+		Description:      "Index the emails of the users",
+		NonTransactional: true,
+		Timeout:          30 * time.Minute,
+		Requires:         []string{"v20230101_create_users_00001.sql", "v20230102_add_email_00002.sql", "v20230103_seed_users"},
+		OnlyEnvironments: []string{"prod", "staging"},
+		Touches:          []string{"users"},
+		Destructive:      true,
+		Labels:           []string{"billing", "critical"},
+		Analyze:          true,
+		AnalyzeTables:    []string{"users"},
+	}, metadata)
+
+	metadata, err = parseMetadata("-- gosmm:analyze false\nUPDATE users SET email = NULL;")
+	assert.NoError(t, err)
+	assert.Equal(t, MigrationMetadata{SkipAnalyze: true}, metadata)
+
+	metadata, err = parseMetadata("-- gosmm:transactional true\nCREATE TABLE users (id INTEGER);")
+	assert.NoError(t, err)
+	assert.Equal(t, MigrationMetadata{}, metadata)
+
+	_, err = parseMetadata("-- gosmm:transactional no way\n")
+	assert.Error(t, err)
+	_, err = parseMetadata("-- gosmm:destructive maybe\n")
+	assert.Error(t, err)
+	_, err = parseMetadata("-- gosmm:timeout 10\n")
+	assert.EqualError(t, err, `invalid timeout "10", expected a positive duration such as 30s`)
+}
+
+func TestMigrateRecordsMetadata(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("-- gosmm:author Jane Doe\n-- gosmm:ticket PROJ-1\nCREATE TABLE users (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230102_add_name_00002.sql"), []byte("-- gosmm:description Add the name of the users\nALTER TABLE users ADD COLUMN name TEXT;"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+
+	var applied []MigrationInfo
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3", Hooks: Hooks{
+		AfterEach: func(migration MigrationInfo) error {
+			applied = append(applied, migration)
+			return nil
+		},
+	}}
+	report, err := Status(db, config)
+	assert.NoError(t, err)
+	if assert.Len(t, report.Migrations, 2) {
+		assert.Equal(t, "Jane Doe", report.Migrations[0].Author)
+		assert.Equal(t, "Add the name of the users", report.Migrations[1].Description)
+	}
+
+	assert.NoError(t, MigrateWithConfig(db, config))
+	if assert.Len(t, applied, 2) {
+		assert.Equal(t, "PROJ-1", applied[0].Metadata.Ticket)
+	}
+	history, err := GetHistory(db, config)
+	assert.NoError(t, err)
+	if assert.Len(t, history, 2) {
+		assert.Equal(t, "Jane Doe", history[0].Author)
+		assert.Equal(t, "PROJ-1", history[0].Ticket)
+		assert.Equal(t, "", history[0].Description)
+		assert.Equal(t, "Add the name of the users", history[1].Description)
+	}
+
+	report, err = Status(db, config)
+	assert.NoError(t, err)
+	if assert.Len(t, report.Migrations, 2) {
+		assert.Equal(t, MigrationApplied, report.Migrations[1].State)
+		assert.Equal(t, "Add the name of the users", report.Migrations[1].Description)
+	}
+}
+
+func TestMigrateNonTransactional(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "v20230101_create_users_00001.sql")
+	if err := ioutil.WriteFile(path, []byte("-- gosmm:transactional false\nCREATE TABLE users (id INTEGER); INSERT INTO missing_table VALUES (1);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3", ResumeMode: true}
+	var migrationErr *ErrMigrationFailed
+	if assert.ErrorAs(t, MigrateWithConfig(db, config), &migrationErr) {
+		assert.Equal(t, 2, migrationErr.StatementIndex)
+		assert.Equal(t, 1, migrationErr.CommittedStatements)
+	}
+
+	// the first statement was committed before the failure
+	_, err := db.Exec("SELECT id FROM users")
+	assert.NoError(t, err)
+
+	// the resumed run skips it
+	if err := ioutil.WriteFile(path, []byte("-- gosmm:transactional false\nCREATE TABLE users (id INTEGER); INSERT INTO users VALUES (1);"), 0644); err != nil {
+		t.Fatalf("Failed to modify test migration file: %v", err)
+	}
+	assert.NoError(t, MigrateWithConfig(db, config))
+	var count int
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count))
+	assert.Equal(t, 1, count)
+}
+
+func TestMigrateNonTransactionalRecordsStart(t *testing.T) {
+	// a file database, so that the history table is read on another connection while the migration runs
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("-- gosmm:transactional false\nCREATE TABLE users (id INTEGER); CREATE TABLE posts (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+
+	// while the migration runs, a crash would leave the database dirty rather than the migration unrecorded
+	var running []string
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3", Progress: func(event Event) {
+		if event.Kind == EventStatementExecuted {
+			dirty, err := DirtyMigrations(db, MigrationConfig{Driver: "sqlite3"})
+			assert.NoError(t, err)
+			running = append(running, dirty...)
+		}
+	}}
+	assert.NoError(t, MigrateWithConfig(db, config))
+	assert.Equal(t, []string{"v20230101_create_users_00001.sql", "v20230101_create_users_00001.sql"}, running)
+
+	history, err := GetHistory(db, config)
+	assert.NoError(t, err)
+	if assert.Len(t, history, 1) {
+		assert.True(t, history[0].Success)
+	}
+}
+
+func TestMigrateTimeout(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	slow := "WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM c WHERE x < 1000000000) SELECT COUNT(*) FROM c;"
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_slow_00001.sql"), []byte("-- gosmm:timeout 50ms\n"+slow), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+
+	start := time.Now()
+	err := MigrateWithContext(context.Background(), db, MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"})
+	assert.ErrorContains(t, err, "migration v20230101_slow_00001.sql exceeded its timeout of 50ms")
+	assert.Less(t, time.Since(start), 10*time.Second)
+
+	var success bool
+	assert.NoError(t, db.QueryRow("SELECT success FROM gosmm_migration_history").Scan(&success))
+	assert.False(t, success)
+}
@@ -0,0 +1,96 @@
+package gosmm
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "gosmm"
+
+// Metrics exposes Prometheus metrics of migration runs. Create it once with NewMetrics and set it
+// as MigrationConfig.Metrics for every run. A nil *Metrics records nothing.
+type Metrics struct {
+	applied     *prometheus.CounterVec
+	duration    *prometheus.HistogramVec
+	pending     *prometheus.GaugeVec
+	lastSuccess *prometheus.GaugeVec
+	// target labels the gauges of the runs of a target of MigrateAll, empty for the other runs
+	target string
+}
+
+// NewMetrics creates the migration metrics and registers them with registerer:
+//   - gosmm_migrations_applied_total: migrations executed, labelled by status ("success" or "failed")
+//   - gosmm_migration_duration_seconds: execution time of each migration, labelled by status
+//   - gosmm_pending_migrations: migrations not applied yet, updated by each run
+//   - gosmm_last_success_timestamp_seconds: when the last run completed without error
+//
+// The gauges of the targets of MigrateAll are labelled by target, the name of the target.
+func NewMetrics(registerer prometheus.Registerer) (*Metrics, error) {
+	m := &Metrics{
+		applied: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "migrations_applied_total",
+			Help:      "Number of migrations executed, by status.",
+		}, []string{"status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "migration_duration_seconds",
+			Help:      "Execution time of migrations, by status.",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 4, 10),
+		}, []string{"status"}),
+		pending: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "pending_migrations",
+			Help:      "Number of migrations not applied yet, by target of a multi-database run.",
+		}, []string{"target"}),
+		lastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "last_success_timestamp_seconds",
+			Help:      "Unix time of the last migration run completed without error, by target of a multi-database run.",
+		}, []string{"target"}),
+	}
+	for _, collector := range []prometheus.Collector{m.applied, m.duration, m.pending, m.lastSuccess} {
+		if err := registerer.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// forTarget returns the metrics of the runs of the target of MigrateAll named target
+func (m *Metrics) forTarget(target string) *Metrics {
+	if m == nil {
+		return nil
+	}
+	metrics := *m
+	metrics.target = target
+	return &metrics
+}
+
+// setPending records the number of pending migrations
+func (m *Metrics) setPending(pending int) {
+	if m != nil {
+		m.pending.WithLabelValues(m.target).Set(float64(pending))
+	}
+}
+
+// observe records an executed migration
+func (m *Metrics) observe(duration time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	status := "success"
+	if err != nil {
+		status = "failed"
+	}
+	m.applied.WithLabelValues(status).Inc()
+	m.duration.WithLabelValues(status).Observe(duration.Seconds())
+}
+
+// succeeded records the completion of a run without error
+func (m *Metrics) succeeded() {
+	if m != nil {
+		m.lastSuccess.WithLabelValues(m.target).SetToCurrentTime()
+	}
+}
@@ -0,0 +1,51 @@
+package gosmm
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrateWithMetrics(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	registry := prometheus.NewRegistry()
+	metrics, err := NewMetrics(registry)
+	if err != nil {
+		t.Fatalf("Failed to create metrics: %v", err)
+	}
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230102_create_orders_00002.sql"), []byte("CREATE TABLE orders (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3", Metrics: metrics}
+
+	err = MigrateWithConfig(db, config)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.applied.WithLabelValues("success")))
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.pending.WithLabelValues("")))
+	assert.Greater(t, testutil.ToFloat64(metrics.lastSuccess.WithLabelValues("")), float64(0))
+	assert.Equal(t, 1, testutil.CollectAndCount(metrics.duration))
+
+	// A failed migration is counted and stays pending
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230103_invalid_00003.sql"), []byte("CREATE TABLE;"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	err = MigrateWithConfig(db, config)
+	assert.Error(t, err)
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.applied.WithLabelValues("failed")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.pending.WithLabelValues("")))
+
+	// The metrics cannot be registered twice
+	_, err = NewMetrics(registry)
+	assert.Error(t, err)
+}
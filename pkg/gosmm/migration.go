@@ -1,14 +1,19 @@
 package gosmm
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
+	"sync"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -16,11 +21,14 @@ const (
 	migrationHistoryTable = "gosmm_migration_history"
 )
 
+// sqliteChangesPattern matches the statements whose changed rows SQLite counts
+var sqliteChangesPattern = regexp.MustCompile(`(?is)^\s*(?:INSERT|UPDATE|DELETE|REPLACE|WITH)\b`)
+
 // checkMigrationIntegrity checks the migration history table for inconsistencies
-func checkMigrationIntegrity(db *sql.DB, migrationsDir string) error {
+func checkMigrationIntegrity(db *sql.DB, driver string, table string, migrationsDirs []string, listing dirListing, goMigrations map[string]GoMigrationFunc) error {
 	// Load executed migrations from the history table
 	executedMigrations := make(map[string]bool)
-	rows, err := db.Query(`SELECT filename FROM gosmm_migration_history WHERE success = TRUE`)
+	rows, err := db.Query(`SELECT filename FROM ` + table + ` WHERE success = ` + boolLiteral(driver, true))
 	if err != nil {
 		return err
 	}
@@ -35,116 +43,819 @@ func checkMigrationIntegrity(db *sql.DB, migrationsDir string) error {
 	if err := rows.Err(); err != nil {
 		return err
 	}
+	return checkMissingMigrations(executedMigrations, migrationsDirs, listing, goMigrations)
+}
 
-	// Read all SQL files from the migration directory
-	files, err := ioutil.ReadDir(migrationsDir)
+// checkMissingMigrations checks that the successfully executed migrations exist in the migration directories
+// or the Go migrations, removing those found from executedMigrations
+func checkMissingMigrations(executedMigrations map[string]bool, migrationsDirs []string, listing dirListing, goMigrations map[string]GoMigrationFunc) error {
+	// Read all SQL files from the migration directories
+	files, err := readMigrationFiles(migrationsDirs, listing)
 	if err != nil {
 		return err
 	}
 
-	// Check each executed migration exists in the migration directory
+	// Check each executed migration exists in the migration directories
 	for _, file := range files {
-		if filepath.Ext(file.Name()) != sqlFileExtension {
-			return fmt.Errorf("invalid file extension: %s", file.Name())
+		if filepath.Ext(file.name) != sqlFileExtension {
+			return fmt.Errorf("invalid file extension: %s", file.name)
 		}
 
-		if executedMigrations[file.Name()] {
-			delete(executedMigrations, file.Name())
+		if executedMigrations[file.name] {
+			delete(executedMigrations, file.name)
 		}
 	}
 
+	for name := range goMigrations {
+		delete(executedMigrations, name)
+	}
+
 	// Any remaining executed migrations in the map are inconsistencies
 	for filename := range executedMigrations {
-		return fmt.Errorf("inconsistent migration state. executed migration file not found: %s", filename)
+		return fmt.Errorf("inconsistent migration state: %w: %s", ErrMissingFile, filename)
 	}
 
 	return nil
 }
 
+// MigrationConfig holds the migration configuration information
+type MigrationConfig struct {
+	// MigrationsDir is the directory containing the SQL migration files
+	MigrationsDir string
+	// MigrationsDirs holds additional migration directories, e.g. one per module. Their files are
+	// merged with those of MigrationsDir and ordered by name. The same version in two directories is an error.
+	MigrationsDirs []string
+	// Source is a remote location of migration files, e.g. an S3 bucket, fetched into SourceCacheDir by
+	// FetchSource. The files of SourceCacheDir are merged with those of the migration directories.
+	Source Source
+	// SourceCacheDir is the local directory the files of Source are fetched into
+	SourceCacheDir string
+	// FilenamePattern is the regular expression the names of the migration files match, with a version group
+	// (?P<version>...) ordering them, e.g. FlywayFilenamePattern, so that the files of another tool are adopted
+	// without renaming them. Versions are compared by their numbers, so that V2 sorts before V10, and files not
+	// matching the pattern are left out. When empty, the files follow the vYYYYMMDD_description_NNNNN.sql
+	// convention and are ordered by name.
+	FilenamePattern string
+	// Ignore holds the globs of the files and directories of the migration and seed directories which are not
+	// migrations, e.g. "README.md", "*.md" or "archive/**", relative to the directory with slashes. A ** segment
+	// matches any number of directories, and a glob without a slash matches the name of a file in any directory.
+	Ignore []string
+	// NestedDirs organizes the migrations in subdirectories at any depth, e.g. by year or by domain, instead of
+	// the environment directories: the files of all the subdirectories are ordered by version as if they were
+	// in one directory, and the same version in two subdirectories is an error. The environment-scoped
+	// migrations are then restricted by their suffix only, e.g. v20230101_seed_users_00002.dev.sql.
+	NestedDirs bool
+	// SeedsDir is the directory containing the seed files applied by Seed
+	SeedsDir string
+	// Environment selects the environment-scoped migrations to apply: the files in a subdirectory named after
+	// the environment (e.g. seeds/dev) or with its suffix (e.g. v20230101_seed_users_00002.dev.sql).
+	// When empty, only the migrations without environment are applied.
+	Environment string
+	// Driver is the database driver ("postgres", "mysql", or "sqlite3")
+	Driver string
+	// AllowOutOfOrder applies pending migrations that sort before the latest applied one.
+	// When false, such a migration makes Migrate fail instead of being skipped.
+	AllowOutOfOrder bool
+	// Skip lists migrations not applied, e.g. in the configuration of an environment lacking what they need.
+	// They are reported as skipped by Status and remain pending for the runs without them in Skip.
+	Skip []string
+	// Labels restricts the run to the pending migrations labeled with one of them in their header, e.g.
+	// "-- gosmm:labels billing", so that the domains of a modular schema are deployed separately from one
+	// directory. The other migrations remain pending. All pending migrations are applied when empty.
+	Labels []string
+	// StrictOrdering makes Validate fail on gaps between the sequence numbers of the migration files, which are
+	// otherwise printed as warnings
+	StrictOrdering bool
+	// Placeholders holds the values substituted for ${NAME} placeholders in migration files
+	Placeholders map[string]string
+	// TemplateData holds the values the migration templates (.sql.tmpl files) are rendered with, e.g. the
+	// names of the shards to create partitions for, see templateFuncs for the available functions
+	TemplateData map[string]interface{}
+	// Hooks holds the callbacks invoked around the migration run
+	Hooks Hooks
+	// Schema is the schema holding the history table. For postgres it is created if missing
+	// and used as the search_path while migrations are executed.
+	// When empty, the connection's default schema is used.
+	Schema string
+	// GoMigrations holds migrations written in Go keyed by their migration name (e.g. "v20230101_seed_users_00002").
+	// They are ordered together with the migration files by name.
+	GoMigrations map[string]GoMigrationFunc
+	// Progress receives progress events, e.g. to display a progress bar
+	Progress ProgressFunc
+	// LogLevel is the verbosity of the messages printed while migrating, LogInfo when empty.
+	// LogDebug prints each executed statement.
+	LogLevel LogLevel
+	// ResumeMode re-runs a failed migration instead of failing with ErrDirtyState.
+	// Statements committed implicitly before the failure (MySQL DDL) are skipped.
+	ResumeMode bool
+	// TracerProvider creates the OpenTelemetry spans of the run, one per run and a child span per migration.
+	// When nil, the global TracerProvider is used.
+	TracerProvider trace.TracerProvider
+	// Metrics records Prometheus metrics of the run when set, see NewMetrics
+	Metrics *Metrics
+	// AllowClean enables Clean, which drops every object in the schema. Never set it for production databases.
+	AllowClean bool
+	// Retry retries the migrations failing with transient errors when set, see RetryPolicy
+	Retry *RetryPolicy
+	// Webhooks are notified when the run starts, succeeds and fails, see Webhook
+	Webhooks []Webhook
+	// WaitForLock bounds the wait for the migration lock held by another run, after which ErrLockTimeout
+	// is returned. When zero, runs wait until the lock is released.
+	WaitForLock time.Duration
+	// Lease serializes the runs with a lease in the gosmm_migration_lock table instead of a database lock,
+	// e.g. for Kubernetes Jobs and init containers. The lease is renewed while the run is in progress and
+	// expires Lease after the last renewal, so that a killed run does not block the others. A run losing its
+	// lease aborts the migration in progress with ErrLeaseLost. It also serializes the runs against CockroachDB,
+	// which has no database lock.
+	Lease time.Duration
+	// SchemaFile receives the schema of the database after every successful run when set, e.g. schema.sql
+	// committed next to the migrations, so that reviews show the net schema effect of a migration, see DumpSchema.
+	// A failure to write it is reported as a warning and does not fail the run. It is ignored by MigrateAll
+	// and MigrateTenants, whose databases share the migrations.
+	SchemaFile string
+	// Approval blocks the runs applying migrations until an external change approval passes, and records its id
+	// with the applied migrations. The run fails with ErrNotApproved when the approval is rejected.
+	Approval *Approval
+	// ReportFile receives a report of every run when set, e.g. to attach it to a change-management ticket: the
+	// applied migrations with their durations, the failure, the lint warnings and the schema changes. It is
+	// written as HTML when its extension is .html, and as JSON otherwise, see RunReport. Like SchemaFile, it is
+	// ignored by MigrateAll and MigrateTenants.
+	ReportFile string
+	// Lint configures the rules Lint checks the pending migrations against
+	Lint LintConfig
+	// OnlineSchemaChange runs the MySQL migrations annotated with a "-- gosmm:online" line through gh-ost or
+	// pt-online-schema-change, see OnlineSchemaChange
+	OnlineSchemaChange *OnlineSchemaChange
+	// ZeroDowntime rejects the run with ErrUnsafeMigration before applying anything when a pending migration
+	// holds an operation known to take long locks on an existing table for the driver, e.g. ALTER COLUMN TYPE
+	// on Postgres, unless the migration is annotated with a "-- gosmm:allow-unsafe" line
+	ZeroDowntime bool
+	// VerifyIndexes fails a migration file creating indexes or constraints with ErrInvalidIndex when an index of
+	// the schema is left invalid after its statements, e.g. by a failed CREATE INDEX CONCURRENTLY on Postgres,
+	// see verifyIndexes
+	VerifyIndexes bool
+	// Analyze refreshes the statistics of the tables written by each migration file once it is applied, e.g.
+	// with ANALYZE on Postgres, so that the query plans do not degrade after a big backfill. A file opts in
+	// or out with a "-- gosmm:analyze" line of its header, see MigrationMetadata.Analyze.
+	Analyze bool
+	// Parallelism is the maximum number of migrations applied at once. Consecutive pending migrations declaring
+	// disjoint objects with a "-- gosmm:touches" line, and not requiring each other, are applied in parallel, e.g.
+	// independent index builds. The other migrations are applied alone. When zero or one, the migrations are
+	// applied one at a time. Hooks and Progress are not called concurrently.
+	Parallelism int
+	// LockTimeout bounds the wait of the migration statements for the locks held by other sessions, e.g. the
+	// application traffic, so that a blocked DDL statement fails fast instead of queueing and blocking the traffic
+	// behind it. It sets lock_timeout on Postgres, lock_wait_timeout and innodb_lock_wait_timeout on MySQL (in
+	// whole seconds), LOCK_TIMEOUT on SQL Server and busy_timeout on SQLite. Unbounded when zero.
+	LockTimeout time.Duration
+	// StatementTimeout bounds the execution of each migration statement. It sets statement_timeout on Postgres
+	// and max_execution_time on MySQL, which only bounds SELECT statements. It is not supported for SQL Server
+	// and SQLite, whose migrations can be bounded with a gosmm:timeout header. Unbounded when zero.
+	StatementTimeout time.Duration
+	// Backup takes a backup before each destructive migration file, whose reference is recorded in the
+	// history table, see Backup
+	Backup *Backup
+	// Signatures only lets the migration files signed with one of its public keys run, see Signatures. The
+	// pending migrations are verified before any of them is executed.
+	Signatures *Signatures
+	// Checksum configures the checksums of the migration files recorded in the history table and checked by
+	// Validate, SHA-256 by default
+	Checksum ChecksumConfig
+	// Idempotent rewrites the SQLite and MySQL statements to be idempotent where possible, e.g. CREATE TABLE into
+	// CREATE TABLE IF NOT EXISTS, and skips the ADD COLUMN, DROP COLUMN, CREATE INDEX and DROP INDEX statements
+	// already applied, so that a file half-applied by a failed run can be run again from its first statement,
+	// e.g. a MySQL file whose DDL statements committed implicitly or a non-transactional SQLite file.
+	Idempotent bool
+	// AuditHost records the OS user, the hostname and the id of the CI job running gosmm, e.g. GITHUB_RUN_ID,
+	// after the database user in the applied_by column of the history table
+	AuditHost bool
+	// Context is a free-form string recorded in the context column of the history table with every applied
+	// migration, e.g. the change request approving the deployment. It is at most 1000 characters long.
+	Context string
+	// HistoryStore keeps the history and the migration lock outside of the migrated database, e.g. in a
+	// control-plane Postgres database or in DynamoDB, for engines which cannot host them reliably such as Trino
+	// and Athena, see HistoryStore. The outcome of each migration is then recorded after its transaction is
+	// committed. When nil, the history table of the migrated database records the migrations in their
+	// transaction. It is supported by Migrate, Status and ExportHistory.
+	HistoryStore HistoryStore
+	// StreamThreshold is the size in bytes from which the migration and seed files are streamed: their statements
+	// are read, split and executed one at a time instead of reading the whole file in memory, e.g. for a file of
+	// several gigabytes of INSERT statements. Templates, the files of the drivers with a dialect and the checksums
+	// normalized or computed by a custom function are not streamed. Zero disables streaming.
+	StreamThreshold int64
+	// StableListings lists each migration directory again until two consecutive listings hold the same files,
+	// at most StableListings times, for the directories on network filesystems such as NFS whose listings may
+	// briefly miss or duplicate the files being written. ListingInterval is the wait between two listings,
+	// 100ms when zero. Zero or one lists the directories once.
+	StableListings  int
+	ListingInterval time.Duration
+	// Stop stops the run gracefully when it is closed, e.g. on a first SIGINT: the migrations in progress
+	// complete, and the run returns ErrInterrupted instead of applying the next ones. Canceling the context of
+	// MigrateWithContext aborts the migrations in progress instead: their statements are canceled and rolled back.
+	Stop <-chan struct{}
+}
+
+// GoMigrationFunc is a migration written in Go. It runs in the migration transaction tx,
+// which is open on conn, and must not commit or rollback tx.
+type GoMigrationFunc func(ctx context.Context, conn *sql.Conn, tx *sql.Tx) error
+
 // Migrate executes the SQL migrations in the given directory
 func Migrate(db *sql.DB, migrationsDir string, driver string) error {
-	if err := createHistoryTable(db); err != nil {
-		return fmt.Errorf("failed to create history table: %w", err)
+	return MigrateWithConfig(db, MigrationConfig{
+		MigrationsDir: migrationsDir,
+		Driver:        driver,
+	})
+}
+
+// MigrateWithConfig executes the SQL migrations based on the given MigrationConfig
+func MigrateWithConfig(db *sql.DB, config MigrationConfig) error {
+	return MigrateWithContext(context.Background(), db, config)
+}
+
+// MigrateWithContext executes the SQL migrations based on the given MigrationConfig.
+// The run is traced as a child span of the span in ctx, see MigrationConfig.TracerProvider.
+func MigrateWithContext(ctx context.Context, db *sql.DB, config MigrationConfig) error {
+	return migrate(ctx, db, config, nil)
+}
+
+// migrate executes the pending migrations, or only the one picked when pick is not nil, see Apply
+func migrate(ctx context.Context, db *sql.DB, config MigrationConfig, pick *cherryPick) (err error) {
+	ctx, span := startRunSpan(ctx, config)
+	defer func() { endSpan(span, err) }()
+
+	notifier := runNotifier{config: config, started: time.Now()}
+	// failed is the migration the run failed on, the zero value when the failure is not related to a migration
+	var failed MigrationInfo
+	defer func() {
+		if err != nil {
+			config.Hooks.onError(failed, err)
+			notifier.notify(Notification{Event: NotifyFailed, Migration: failed.Filename, Error: err.Error()})
+		}
+	}()
+
+	var reporter *runReporter
+	if config.ReportFile != "" {
+		reporter = newRunReporter(&config)
+		defer func() { reporter.write(db, config, err) }()
 	}
 
-	if err := checkMigrationIntegrity(db, migrationsDir); err != nil {
-		return fmt.Errorf("failed to check migration integrity: %w", err)
+	if config.HistoryStore == nil && externalHistory(config.Driver) {
+		return fmt.Errorf("the %s driver requires a HistoryStore", config.Driver)
 	}
+	table := historyTableName(config.Driver, config.Schema)
 
-	lastInstalledRank, err := getLastInstalledRank(db)
+	cockroach, err := isCockroachDB(db, config.Driver)
 	if err != nil {
-		return fmt.Errorf("failed to get last successful installed_rank: %w", err)
+		return fmt.Errorf("failed to detect database version: %w", err)
+	}
+
+	// a replica handed out by a load balancer would fail on the first write, or even take the lock
+	if err := checkWritable(db, config.Driver, cockroach); err != nil {
+		return err
 	}
 
-	failedMigrationExists, err := failedMigrationExists(db)
+	// the approval is awaited without the lock, which would block the other runs while it is pending
+	approved, err := awaitApproval(ctx, db, config, pick)
 	if err != nil {
-		return fmt.Errorf("failed to check if failed migration exists: %w", err)
+		return err
 	}
-	if failedMigrationExists {
-		return fmt.Errorf("cannot proceed, there is at least one failed migration")
+
+	// a lost lease aborts the migration in progress, so that two runs never migrate concurrently
+	ctx, abort := context.WithCancelCause(ctx)
+	defer abort(nil)
+	defer func() { err = leaseLostError(ctx, err) }()
+
+	// CockroachDB does not implement advisory locks, so runs against it are only serialized by a lease
+	var unlock func() error
+	if config.HistoryStore != nil {
+		unlock, err = config.HistoryStore.Lock(ctx, config.WaitForLock)
+	} else {
+		unlock, err = lockRun(ctx, db, config, table, cockroach, abort)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %w", err)
 	}
+	defer unlock()
 
-	lastSuccessfulMigrationFile, err := getLastSuccessfulMigrationFile(db)
+	// an unsupported timeout fails the run before any migration is applied
+	if _, _, err := timeoutStatements(config, false); err != nil {
+		return err
+	}
+
+	var history historyState
+	if config.HistoryStore != nil {
+		history, err = loadStoredHistory(ctx, config)
+	} else {
+		history, err = loadHistory(db, config, table)
+	}
 	if err != nil {
 		return err
 	}
+	run := migrationRun{cockroach: cockroach, resumed: history.resumed}
+	executedMigrations, lastInstalledRank := history.executed, history.lastInstalledRank
 
-	executedMigrations, err := getExecutedMigrations(db)
+	allFiles, err := config.migrationFiles()
 	if err != nil {
 		return err
 	}
+	files := make([]migrationFile, 0, len(allFiles))
+	run.paths = make(map[string]string, len(allFiles))
+	labels := make(map[string][]string)
+	for _, file := range allFiles {
+		if !file.inEnvironment(config.Environment) {
+			continue
+		}
+		files = append(files, file)
+		run.paths[file.name] = file.path
+		labels[file.name] = file.metadata.Labels
+	}
+	// a migration applied alone is picked whatever its labels
+	if pick != nil {
+		config.Labels = nil
+	}
+	naming, err := config.naming()
+	if err != nil {
+		return err
+	}
+	filenames, err := migrationNames(files, config.GoMigrations, naming)
+	if err != nil {
+		return err
+	}
+	// the migrations pruned from the history are applied
+	for _, filename := range filenames {
+		if pruneCovers(naming, history.prunedThrough, filename) {
+			executedMigrations[filename] = true
+		}
+	}
 
-	files, err := ioutil.ReadDir(migrationsDir)
+	appliedBy, auditContext, err := auditMigrations(db, config)
 	if err != nil {
-		return fmt.Errorf("failed to read migrations directory: %w", err)
+		return err
+	}
+	installedRank := lastInstalledRank
+	pending := make([]MigrationInfo, 0)
+	ahead := false
+	if pick != nil {
+		if ahead, err = pick.check(filenames, executedMigrations, config); err != nil {
+			return err
+		}
 	}
 
-	sort.Slice(files, func(i, j int) bool {
-		return files[i].Name() < files[j].Name()
-	})
+	for i, filename := range filenames {
+		if executedMigrations[filename] || config.skips(filename) {
+			continue // skip already executed migrations
+		}
+		if pick != nil && !pick.matches(filename) {
+			continue // another migration is applied alone
+		}
+		if !config.selects(labels[filename]) {
+			continue // a migration of another label
+		}
 
-	installedRank := lastInstalledRank
-	shouldExecute := lastSuccessfulMigrationFile == ""
+		// the latest applied migration ordering it, as the other labels are deployed on their own and the
+		// migrations applied ahead were let through on purpose
+		if !config.AllowOutOfOrder {
+			for j := len(filenames) - 1; j > i; j-- {
+				if executedMigrations[filenames[j]] && !history.ahead[filenames[j]] && labelsOrder(labels[filenames[j]], labels[filename]) {
+					return fmt.Errorf("out-of-order migration detected: %s sorts before the latest applied migration %s, enable AllowOutOfOrder to apply it", filename, filenames[j])
+				}
+			}
+		}
 
-	for _, file := range files {
-		filename := file.Name()
+		installedRank++
+		pending = append(pending, MigrationInfo{InstalledRank: installedRank, Filename: filename, AppliedBy: appliedBy, Context: auditContext, Ahead: ahead})
+	}
 
-		if executedMigrations[filename] {
-			continue // skip already executed migrations
+	if err := checkLabeledRequires(config, pending, migrationRequires(files), executedMigrations); err != nil {
+		return err
+	}
+	if config.Signatures != nil {
+		if err := verifySignatures(config, run.paths, pending); err != nil {
+			return err
+		}
+	}
+	if config.ZeroDowntime {
+		if err := checkZeroDowntime(config, run.paths, pending); err != nil {
+			return err
 		}
+	}
+
+	if err := approveRun(ctx, config, run.paths, pending, approved); err != nil {
+		return err
+	}
+	if reporter != nil {
+		reporter.start(db, config, pending, appliedBy, auditContext)
+	}
 
-		if !shouldExecute && filename > lastSuccessfulMigrationFile {
-			shouldExecute = true
+	config.Metrics.setPending(len(pending))
+	span.SetAttributes(attribute.Int("gosmm.migrations.pending", len(pending)))
+
+	if err := config.Hooks.beforeAll(pending); err != nil {
+		return fmt.Errorf("BeforeAll hook failed: %w", err)
+	}
+	if len(pending) > 0 {
+		notifier.notify(Notification{Event: NotifyStarted, Migrations: migrationFilenames(pending)})
+	}
+
+	// mu serializes the callbacks of the migrations applied in parallel
+	var mu sync.Mutex
+	applyConfig := config
+	applyConfig.Hooks = config.Hooks.serialized(&mu)
+	remaining := len(pending)
+	apply := func(i int) error {
+		migration := &pending[i]
+		index := i + 1
+		migrationCtx, migrationSpan := startMigrationSpan(ctx, config, *migration)
+		progress := func(event Event) {
+			event.Index, event.Total = index, len(pending)
+			migrationSpan.observe(event)
+			mu.Lock()
+			defer mu.Unlock()
+			config.Progress.emit(event)
 		}
 
-		if shouldExecute {
-			installedRank++
+		progress(Event{Kind: EventMigrationStarted, Migration: *migration})
+		startTime := time.Now()
+		err := applyMigration(migrationCtx, db, applyConfig, run, migration, progress)
+		migrationSpan.end(err)
+		if err != nil {
+			config.Metrics.observe(time.Since(startTime), err)
+			progress(Event{Kind: EventMigrationFailed, Migration: *migration, Duration: migration.ExecutionTime, Err: err})
+			return err
+		}
+		config.Metrics.observe(time.Since(startTime), nil)
+		mu.Lock()
+		remaining--
+		config.Metrics.setPending(remaining)
+		mu.Unlock()
+		progress(Event{Kind: EventMigrationFinished, Migration: *migration, Duration: migration.ExecutionTime})
+		return nil
+	}
 
-			data, err := ioutil.ReadFile(filepath.Join(migrationsDir, filename))
-			if err != nil {
-				return fmt.Errorf("failed to read file: %w", err)
+	applied := make([]MigrationInfo, 0, len(pending))
+	for _, wave := range parallelWaves(pending, migrationTouches(files), migrationRequires(files), config.Parallelism) {
+		if err := interrupted(ctx, config.Stop); err != nil {
+			config.LogLevel.printf(LogWarn, "STOP  %d migration(s) not applied: %v\n", remaining, err)
+			return err
+		}
+		errs := make([]error, len(wave))
+		if len(wave) == 1 {
+			errs[0] = apply(wave[0])
+		} else {
+			var wg sync.WaitGroup
+			for j, i := range wave {
+				wg.Add(1)
+				go func(j int, i int) {
+					defer wg.Done()
+					errs[j] = apply(i)
+				}(j, i)
+			}
+			wg.Wait()
+		}
+		// the migrations of the wave completed before a failure are recorded, so they are applied
+		for j, i := range wave {
+			if errs[j] == nil {
+				applied = append(applied, pending[i])
+			}
+		}
+		for j, i := range wave {
+			if errs[j] != nil {
+				failed = pending[i]
+				return errs[j]
 			}
+		}
+	}
+
+	if err := config.Hooks.afterAll(applied); err != nil {
+		return fmt.Errorf("AfterAll hook failed: %w", err)
+	}
+
+	if config.SchemaFile != "" {
+		if err := writeSchemaFile(db, config); err != nil {
+			config.LogLevel.printf(LogWarn, "WARNING: failed to write schema to %s: %v\n", config.SchemaFile, err)
+		}
+	}
+
+	config.Metrics.succeeded()
+	if len(applied) > 0 {
+		notifier.notify(Notification{Event: NotifySucceeded, Migrations: migrationFilenames(applied)})
+	}
+	return nil
+}
 
-			tx, err := db.Begin()
+// migrationRun holds the state shared by the migrations applied in one run
+type migrationRun struct {
+	// paths maps the migration filenames to their path in the migration directories
+	paths map[string]string
+	// resumed maps failed migrations being resumed to the number of their statements already committed
+	resumed   map[string]int
+	cockroach bool
+}
+
+// historyState is the state of the history at the start of a run
+type historyState struct {
+	// executed holds the migrations recorded in the history
+	executed map[string]bool
+	// lastInstalledRank is the highest rank of the successful migrations
+	lastInstalledRank int
+	// resumed maps the failed migrations taken out of the history to resume them to the number of their
+	// statements already committed
+	resumed map[string]int
+	// prunedThrough is the latest migration pruned from the history, see Prune
+	prunedThrough string
+	// ahead holds the migrations applied ahead of pending migrations sorting before them, see Apply
+	ahead map[string]bool
+}
+
+// loadHistory creates the history table of db if it doesn't exist, adopts the squashed baselines and returns
+// the state of the history, taking the failed migrations out of it in ResumeMode
+func loadHistory(db *sql.DB, config MigrationConfig, table string) (historyState, error) {
+	history := historyState{resumed: make(map[string]int)}
+	if err := createHistoryTable(db, config.Driver, config.Schema); err != nil {
+		return history, fmt.Errorf("failed to create history table: %w", err)
+	}
+
+	if err := adoptBaselines(db, config); err != nil {
+		return history, err
+	}
+
+	if err := checkMigrationIntegrity(db, config.Driver, table, config.migrationDirs(), config.listing(), config.GoMigrations); err != nil {
+		return history, fmt.Errorf("failed to check migration integrity: %w", err)
+	}
+
+	failedMigrationExists, err := failedMigrationExists(db, config.Driver, table)
+	if err != nil {
+		return history, fmt.Errorf("failed to check if failed migration exists: %w", err)
+	}
+	if failedMigrationExists {
+		if !config.ResumeMode {
+			return history, ErrDirtyState
+		}
+		history.resumed, err = takeFailedMigrations(db, config.Driver, table)
+		if err != nil {
+			return history, fmt.Errorf("failed to resume failed migration: %w", err)
+		}
+	}
+
+	history.lastInstalledRank, err = getLastInstalledRank(db, config.Driver, table)
+	if err != nil {
+		return history, fmt.Errorf("failed to get last successful installed_rank: %w", err)
+	}
+
+	naming, err := config.naming()
+	if err != nil {
+		return history, err
+	}
+	if history.prunedThrough, err = prunedThrough(db, config, naming); err != nil {
+		return history, err
+	}
+
+	if history.ahead, err = getAheadMigrations(db, table); err != nil {
+		return history, fmt.Errorf("failed to load migrations applied ahead: %w", err)
+	}
+
+	history.executed, err = getExecutedMigrations(db, table)
+	return history, err
+}
+
+// migrationNames returns the names of the migration files and the Go migrations in the order of naming,
+// each migration file moved after the migrations required by its header, see orderMigrations
+func migrationNames(files []migrationFile, goMigrations map[string]GoMigrationFunc, naming migrationNaming) ([]string, error) {
+	names := make([]string, 0, len(files)+len(goMigrations))
+	for _, file := range files {
+		if _, ok := goMigrations[file.name]; ok {
+			return nil, fmt.Errorf("go migration %s conflicts with a migration file of the same name", file.name)
+		}
+		names = append(names, file.name)
+	}
+	for name := range goMigrations {
+		names = append(names, name)
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		return naming.less(names[i], names[j])
+	})
+	return orderMigrations(names, migrationRequires(files))
+}
+
+// applyMigration executes a single pending migration and records it in the history table.
+// Statements committed by a previous failed run being resumed are not executed again.
+func applyMigration(ctx context.Context, db *sql.DB, config MigrationConfig, run migrationRun, migration *MigrationInfo, progress func(Event)) error {
+	if err := config.Hooks.beforeEach(*migration); err != nil {
+		return fmt.Errorf("BeforeEach hook failed for %s: %w", migration.Filename, err)
+	}
+
+	startTime := time.Now()
+
+	var execute func(ctx context.Context, conn *sql.Conn, tx *sql.Tx) error
+	var analyze []string // the tables analyzed once the migration is applied
+	if goMigration, ok := config.GoMigrations[migration.Filename]; ok {
+		execute = func(ctx context.Context, conn *sql.Conn, tx *sql.Tx) error {
+			if err := goMigration(ctx, conn, tx); err != nil {
+				return &ErrMigrationFailed{File: migration.Filename, Cause: err}
+			}
+			return nil
+		}
+	} else if size, ok := config.streamedFileSize(run.paths[migration.Filename]); ok {
+		var err error
+		execute, err = streamMigration(ctx, config, migration, run.paths[migration.Filename], size, run.resumed[migration.Filename], recordCommitted(db, config, migration), progress)
+		if err != nil {
+			return err
+		}
+	} else {
+		data, err := config.readMigrationFile(run.paths[migration.Filename])
+		if err != nil {
+			return err
+		}
+		if migration.Checksum, err = config.checksum(data); err != nil {
+			return err
+		}
+		if migration.Metadata, err = parseMetadata(string(data)); err != nil {
+			return fmt.Errorf("invalid header of %s: %w", migration.Filename, err)
+		}
+
+		content, err := replacePlaceholders(string(data), config.Placeholders)
+		if err != nil {
+			return fmt.Errorf("failed to replace placeholders in %s: %w", migration.Filename, err)
+		}
+		content, _ = splitDown(content) // the down section is only executed by Redo
+		statements := resolveLoads(splitStatements(content, config.Driver), filepath.Dir(run.paths[migration.Filename]))
+		if config.Backup != nil && isDestructive(migration.Metadata, statements) {
+			if err := backupMigration(ctx, config, migration); err != nil {
+				return err
+			}
+		}
+		if hasMarkerLine(content, onlineMarker) {
+			execute, err = executeOnline(config, migration.Filename, statements, run.resumed[migration.Filename])
 			if err != nil {
-				return fmt.Errorf("failed to begin transaction: %w", err)
+				return err
 			}
+		} else {
+			statements = batchStatements(config.Driver, statements)
+			logStatement := config.LogLevel.statementLogger(migration.Filename)
+			execute = executeStatements(migration.Filename, statements, run.resumed[migration.Filename], config.Driver, config.Idempotent, recordCommitted(db, config, migration), func(index int, statement string, duration time.Duration, rowsAffected int64) {
+				logStatement(index, statement, duration, rowsAffected)
+				migration.RowsAffected += rowsAffected
+				progress(Event{
+					Kind:           EventStatementExecuted,
+					Migration:      *migration,
+					StatementIndex: index,
+					StatementCount: len(statements),
+					Statement:      statement,
+					Duration:       duration,
+					RowsAffected:   rowsAffected,
+				})
+			})
+		}
+		if config.VerifyIndexes && createsIndexes(statements) {
+			execute = verifyIndexes(config, migration.Filename, execute)
+		}
+		analyze = analyzeTables(config, migration.Metadata, statements)
+	}
 
-			statements := strings.Split(string(data), ";")
+	err := runMigration(ctx, db, config, migration, execute, run.cockroach, run.resumed[migration.Filename])
+	migration.ExecutionTime = time.Since(startTime)
+	if err != nil {
+		return err
+	}
+	analyzeMigration(ctx, db, config, migration.Filename, analyze)
+
+	if err := config.Hooks.afterEach(*migration); err != nil {
+		return fmt.Errorf("AfterEach hook failed for %s: %w", migration.Filename, err)
+	}
+	return nil
+}
+
+// runMigration runs execute in a transaction on a dedicated connection and records the migration.
+// Serialization failures are retried up to cockroachMaxAttempts times on CockroachDB, and transient
+// failures as config.Retry allows. A retried failure is not recorded in the history table.
+func runMigration(ctx context.Context, db *sql.DB, config MigrationConfig, migration *MigrationInfo, execute func(ctx context.Context, conn *sql.Conn, tx *sql.Tx) error, cockroach bool, skip int) error {
+	for attempt := 1; ; attempt++ {
+		retryCockroach := func(err error) bool {
+			return cockroach && attempt < cockroachMaxAttempts && isSerializationFailure(err)
+		}
+		retryable := func(err error) bool {
+			if retryCockroach(err) {
+				return true
+			}
+			var unknown *commitUnknownError
+			if errors.As(err, &unknown) {
+				return false
+			}
+			var failure *ErrMigrationFailed
+			if errors.As(err, &failure) && (failure.CommittedStatements > skip ||
+				isConnectionError(err) && causesImplicitCommit(config.Driver, failure.Statement)) {
+				return false // statements committed implicitly would be executed twice
+			}
+			return config.Retry.allows(attempt, err)
+		}
 
-			if err := executeAndRecordMigration(db, tx, installedRank, filename, statements, driver); err != nil {
+		err := attemptMigration(ctx, db, config, migration, execute, skip, retryable)
+		if err == nil {
+			config.LogLevel.printf(LogInfo, "OK    %s\n", migration.Filename)
+			return nil
+		}
+		if errors.Is(err, ErrInterrupted) || !retryable(err) {
+			return err
+		}
+		config.LogLevel.printf(LogWarn, "RETRY %s (attempt %d): %v\n", migration.Filename, attempt+1, err)
+		var backoff time.Duration
+		if retryCockroach(err) {
+			if err := waitForSchemaChangeJobs(db); err != nil {
 				return err
 			}
+			backoff = retryBackoff(attempt)
+		} else {
+			backoff = config.Retry.backoff(attempt)
+		}
+		if err := sleep(ctx, backoff); err != nil {
+			return err
 		}
 	}
+}
 
-	return nil
+// attemptMigration makes a single attempt of runMigration on a new connection,
+// so that a retry does not reuse a broken connection. The first skip statements were committed by a failed run.
+func attemptMigration(ctx context.Context, db *sql.DB, config MigrationConfig, migration *MigrationInfo, execute func(ctx context.Context, conn *sql.Conn, tx *sql.Tx) error, skip int, retryable func(error) bool) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+	table := historyTableName(config.Driver, config.Schema)
+	store := config.HistoryStore
+
+	// statements committed before the migration is recorded, by the DDL of MySQL, by a non-transactional
+	// migration or by the commit preceding the record of a HistoryStore, would be left applied but unrecorded by
+	// a crash, so the migration is first recorded as failed
+	nonTransactional := migration.Metadata.NonTransactional || !transactionalMigrations(config.Driver)
+	started := nonTransactional || !transactionalDDL(config.Driver) || store != nil
+	migration.RowsAffected = 0 // counted again by each attempt
+	if store != nil {
+		if err := store.Record(ctx, historyEntry(*migration, time.Now(), false, &ErrMigrationFailed{CommittedStatements: skip})); err != nil {
+			return fmt.Errorf("failed to record start of %s: %w", migration.Filename, err)
+		}
+	} else if started {
+		if err := recordMigrationStart(ctx, conn, table, *migration, skip, config.Driver); err != nil {
+			return err
+		}
+	}
+	finish := func(tx *sql.Tx) error {
+		var err error
+		if store != nil {
+			err = executeAndRecordInStore(ctx, conn, tx, store, migration, execute, retryable)
+		} else {
+			err = executeAndRecordMigration(ctx, conn, tx, table, migration, execute, config.Driver, started, retryable)
+		}
+		var aborted *abortedMigration
+		if errors.As(err, &aborted) {
+			return recordInterruption(db, conn, config, migration, started, tx != nil && transactionalDDL(config.Driver), aborted)
+		}
+		return err
+	}
+
+	if nonTransactional || config.Driver != "postgres" {
+		// the timeouts of a Postgres transaction are set for the transaction only
+		restore, err := setSessionTimeouts(ctx, conn, config)
+		if err != nil {
+			return err
+		}
+		defer restore()
+	}
+
+	if nonTransactional {
+		// the statements are committed as they complete, so the search_path is set for the session
+		if config.Driver == "postgres" && config.Schema != "" {
+			if _, err := conn.ExecContext(ctx, `SET search_path TO `+quoteIdentifier(config.Driver, config.Schema)); err != nil {
+				return fmt.Errorf("failed to set search_path to %s: %w", config.Schema, err)
+			}
+			defer conn.ExecContext(context.Background(), `RESET search_path`)
+		}
+		return finish(nil)
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	if err := setSearchPath(tx, config.Driver, config.Schema); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("failed to set search_path: %w, and failed to rollback: %v", err, rbErr)
+		}
+		return fmt.Errorf("failed to set search_path: %w", err)
+	}
+	if config.Driver == "postgres" {
+		if err := setLocalTimeouts(ctx, tx, config); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return finish(tx)
 }
 
 // getExecutedMigrations returns a map of executed migrations
-func getExecutedMigrations(db *sql.DB) (map[string]bool, error) {
+func getExecutedMigrations(db *sql.DB, table string) (map[string]bool, error) {
 	executedMigrations := make(map[string]bool)
-	rows, err := db.Query(`SELECT filename FROM ` + migrationHistoryTable)
+	rows, err := db.Query(`SELECT filename FROM ` + table)
 	if err != nil {
 		return nil, err
 	}
@@ -158,91 +869,401 @@ func getExecutedMigrations(db *sql.DB) (map[string]bool, error) {
 	return executedMigrations, rows.Err()
 }
 
-// getLastInstalledRank returns the last successful installed_rank
-func getLastSuccessfulMigrationFile(db *sql.DB) (string, error) {
-	var lastSuccessfulMigrationFile string
-	err := db.QueryRow(`SELECT filename FROM ` + migrationHistoryTable + ` WHERE success = TRUE ORDER BY installed_rank DESC LIMIT 1`).Scan(&lastSuccessfulMigrationFile)
-	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+// getAheadMigrations returns the migrations recorded as applied ahead, none when the history table was created
+// before they were recorded
+func getAheadMigrations(db *sql.DB, table string) (map[string]bool, error) {
+	ahead := make(map[string]bool)
+	if !historyColumnExists(db, table, "ahead") {
+		return ahead, nil
+	}
+	rows, err := db.Query(`SELECT filename FROM ` + table + ` WHERE ahead = 1`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var filename string
+		if err := rows.Scan(&filename); err != nil {
+			return nil, err
+		}
+		ahead[filename] = true
+	}
+	return ahead, rows.Err()
+}
+
+// getLastSuccessfulMigrationFile returns the latest (in the order of MigrationConfig.FilenamePattern)
+// successfully applied migration file
+func getLastSuccessfulMigrationFile(db *sql.DB, config MigrationConfig, table string) (string, error) {
+	naming, err := config.naming()
+	if err != nil {
 		return "", err
 	}
-	return lastSuccessfulMigrationFile, nil
+	if naming.pattern == nil {
+		var lastSuccessfulMigrationFile sql.NullString
+		err := db.QueryRow(`SELECT MAX(filename) FROM ` + table + ` WHERE success = ` + boolLiteral(config.Driver, true)).Scan(&lastSuccessfulMigrationFile)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return "", err
+		}
+		return lastSuccessfulMigrationFile.String, nil
+	}
+
+	// the versions are not ordered like the filenames, so every applied migration is compared
+	rows, err := db.Query(`SELECT filename FROM ` + table + ` WHERE success = ` + boolLiteral(config.Driver, true))
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	var last string
+	for rows.Next() {
+		var filename string
+		if err := rows.Scan(&filename); err != nil {
+			return "", err
+		}
+		if last == "" || naming.less(last, filename) {
+			last = filename
+		}
+	}
+	return last, rows.Err()
 }
 
-// executeAndRecordMigration executes the migration and records it in the history table
-func executeAndRecordMigration(db *sql.DB, tx *sql.Tx, installedRank int, filename string, statements []string, driver string) error {
+// executeAndRecordMigration runs the migration with execute and records it in the history table.
+// Failures for which retryable returns true are rolled back without recording a failed migration.
+// A nil tx runs a non-transactional migration, which is recorded in a transaction begun after it completes.
+// When started is set, the record of recordMigrationStart is replaced in the transaction recording the outcome.
+func executeAndRecordMigration(ctx context.Context, conn *sql.Conn, tx *sql.Tx, table string, migration *MigrationInfo, execute func(ctx context.Context, conn *sql.Conn, tx *sql.Tx) error, driver string, started bool, retryable func(error) bool) error {
 	startTime := time.Now()
 	var success bool
 
-	for _, statement := range statements {
-		statement = strings.TrimSpace(statement) // Trim whitespace
-		if statement == "" {
-			continue // Skip empty statements
-		}
-
-		_, err := tx.Exec(statement)
-		if err != nil {
-			e := tx.Rollback()
-			if e != nil {
-				return fmt.Errorf("failed to rollback transaction error: %w original error: %w", e, err)
+	if err := executeMigration(ctx, conn, tx, *migration, execute); err != nil {
+		if ctx.Err() != nil {
+			if tx != nil {
+				tx.Rollback() // already rolled back by the cancellation of ctx
 			}
-
-			success = false
-			tx, e = db.Begin()
-			if e != nil {
-				return fmt.Errorf("failed to begin error record transaction error: %w original error: %w", e, err)
+			return &abortedMigration{cause: err}
+		}
+		var e error
+		if tx != nil {
+			e = tx.Rollback()
+		}
+		if retryable(err) {
+			if started {
+				conn.ExecContext(ctx, deleteStartQuery(table, driver), migration.Filename)
 			}
-			e = recordMigration(tx, installedRank, filename, startTime, success, driver)
-			if e != nil {
+			return err // the caller retries the migration, so the failure is not recorded
+		}
+		if e != nil {
+			return fmt.Errorf("failed to rollback transaction error: %w original error: %w", e, err)
+		}
+		success = false
+		tx, e = conn.BeginTx(ctx, nil)
+		if e != nil {
+			return fmt.Errorf("failed to begin error record transaction error: %w original error: %w", e, err)
+		}
+		if started {
+			if _, e = tx.ExecContext(ctx, deleteStartQuery(table, driver), migration.Filename); e != nil {
+				tx.Rollback()
 				return fmt.Errorf("failed to record migration error: %w original error: %w", e, err)
 			}
-			return fmt.Errorf("failed to execute filename: %s, statement: %s, error: %w", filename, statement, err)
 		}
+		var failure *ErrMigrationFailed
+		errors.As(err, &failure)
+		e = recordMigration(tx, table, *migration, startTime, success, failure, driver)
+		if e != nil {
+			return fmt.Errorf("failed to record migration error: %w original error: %w", e, err)
+		}
+		return err
 	}
 
 	success = true
-	err := recordMigration(tx, installedRank, filename, startTime, success, driver)
+	if tx == nil {
+		// the statements are committed, so they are recorded even when the run was aborted meanwhile
+		recordCtx, cancel := uncanceled(ctx)
+		defer cancel()
+		ctx = recordCtx
+		var err error
+		if tx, err = conn.BeginTx(ctx, nil); err != nil {
+			return fmt.Errorf("failed to begin record transaction: %w", err)
+		}
+	}
+	var err error
+	if started {
+		if _, err = tx.ExecContext(ctx, deleteStartQuery(table, driver), migration.Filename); err != nil {
+			tx.Rollback()
+		}
+	}
+	if err == nil {
+		err = recordMigration(tx, table, *migration, startTime, success, nil, driver)
+	}
 	if err != nil {
+		if isConnectionError(err) {
+			return &commitUnknownError{File: migration.Filename, Cause: err}
+		}
+		if ctx.Err() != nil {
+			return &abortedMigration{cause: err} // the transaction was rolled back by the cancellation of ctx
+		}
+		if retryable(err) {
+			tx.Rollback() // already finished when the commit itself was aborted
+		}
 		return fmt.Errorf("failed to record migration error: %w", err)
 	}
-	fmt.Printf("OK    %s\n", filename)
 	return nil
 }
 
-// recordMigration records the migration in the history table
-func recordMigration(tx *sql.Tx, installedRank int, filename string, startTime time.Time, success bool, driver string) error {
-	executionTime := time.Since(startTime).Milliseconds()
+// executeMigration executes the migration, bounded by the timeout of its header
+func executeMigration(ctx context.Context, conn *sql.Conn, tx *sql.Tx, migration MigrationInfo, execute func(ctx context.Context, conn *sql.Conn, tx *sql.Tx) error) error {
+	executeCtx := ctx
+	if timeout := migration.Metadata.Timeout; timeout > 0 {
+		var cancel context.CancelFunc
+		executeCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	err := execute(executeCtx, conn, tx)
+	if err != nil && errors.Is(executeCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+		err = fmt.Errorf("migration %s exceeded its timeout of %s: %w", migration.Filename, migration.Metadata.Timeout, err)
+	}
+	return err
+}
 
-	// プレースホルダをセットするSQLコマンドを生成
-	var sqlCmd string
-	switch driver {
-	case "postgres":
-		sqlCmd = `
-			INSERT INTO ` + migrationHistoryTable + ` (
-				installed_rank, 
-				filename, 
-				installed_on, 
-				execution_time, 
-				success
-			) VALUES ($1, $2, $3, $4, $5)
-		`
-	case "mysql", "sqlite3":
-		sqlCmd = `
-			INSERT INTO ` + migrationHistoryTable + ` (
-				installed_rank, 
-				filename, 
-				installed_on, 
-				execution_time, 
-				success
-			) VALUES (?, ?, ?, ?, ?)
-		`
-	default:
+// recordMigrationStart records the migration as failed before it runs, so that the database is left dirty
+// rather than the migration unrecorded when the run crashes after statements were committed. The record holds the
+// number of statements already committed, updated by recordCommitted as the migration commits more of them.
+func recordMigrationStart(ctx context.Context, conn *sql.Conn, table string, migration MigrationInfo, committed int, driver string) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	// the record of an attempt whose outcome could not be recorded, e.g. on a lost connection before a retry
+	if _, err := tx.ExecContext(ctx, deleteStartQuery(table, driver), migration.Filename); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to record start of %s: %w", migration.Filename, err)
+	}
+	if err := recordMigration(tx, table, migration, time.Now(), false, &ErrMigrationFailed{CommittedStatements: committed}, driver); err != nil {
+		return fmt.Errorf("failed to record start of %s: %w", migration.Filename, err)
+	}
+	return nil
+}
+
+// commitRecorder records that the first committed statements of a migration are committed, see recordCommitted.
+// conn is the connection executing the statements, in tx unless each one is committed when it completes.
+type commitRecorder func(ctx context.Context, conn *sql.Conn, tx *sql.Tx, committed int) error
+
+// recordCommitted returns the commitRecorder updating the record of recordMigrationStart of the migration, so that
+// ResumeMode skips the statements committed before a crash, e.g. by a non-transactional MySQL migration
+func recordCommitted(db *sql.DB, config MigrationConfig, migration *MigrationInfo) commitRecorder {
+	query := `UPDATE ` + historyTableName(config.Driver, config.Schema) + ` SET committed_statements = ` + bindParam(config.Driver, 1) +
+		` WHERE filename = ` + bindParam(config.Driver, 2) + ` AND success = ` + boolLiteral(config.Driver, false)
+	return func(ctx context.Context, conn *sql.Conn, tx *sql.Tx, committed int) error {
+		var err error
+		switch {
+		case config.HistoryStore != nil:
+			err = config.HistoryStore.Record(ctx, historyEntry(*migration, time.Now(), false, &ErrMigrationFailed{CommittedStatements: committed}))
+		case tx != nil && config.Driver == "mysql":
+			// the statement was committed implicitly by DDL and the following ones run in a new transaction, so
+			// the record is updated on another connection, InnoDB only locking the rows the migration writes
+			_, err = db.ExecContext(ctx, query, committed, migration.Filename)
+		case tx != nil:
+			// the databases of the registered dialects may lock the history table for the transaction
+			return nil
+		default:
+			_, err = conn.ExecContext(ctx, query, committed, migration.Filename)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to record the committed statements of %s: %w", migration.Filename, err)
+		}
+		return nil
+	}
+}
+
+// deleteStartQuery returns the query deleting the record of recordMigrationStart, bound to the filename
+func deleteStartQuery(table string, driver string) string {
+	return "DELETE FROM " + table + " WHERE filename = " + bindParams(driver, 1) + " AND success = " + boolLiteral(driver, false)
+}
+
+// executeStatements returns a function executing the statements of a migration file in order,
+// starting after the first skip statements and calling executed with the 1-based index of each executed statement
+// and the number of rows it affected, or zero when the driver does not report it.
+// Without tx, the statements are executed on conn and each one is committed when it completes. committed, when not
+// nil, records the number of statements committed after each commit.
+// With idempotent, the statements are rewritten to be idempotent, and those already applied are skipped,
+// see idempotentStatement.
+func executeStatements(filename string, statements []string, skip int, driver string, idempotent bool, committed commitRecorder, executed func(index int, statement string, duration time.Duration, rowsAffected int64)) func(ctx context.Context, conn *sql.Conn, tx *sql.Tx) error {
+	return executeStatementStream(filename, func() (statementStream, error) {
+		return &sliceStream{statements: statements}, nil
+	}, skip, driver, idempotent, committed, executed)
+}
+
+// executeStatementStream is executeStatements for the statements read from the stream returned by open, which
+// is opened again by each execution, e.g. when the migration is retried
+func executeStatementStream(filename string, open func() (statementStream, error), skip int, driver string, idempotent bool, committed commitRecorder, executed func(index int, statement string, duration time.Duration, rowsAffected int64)) func(ctx context.Context, conn *sql.Conn, tx *sql.Tx) error {
+	return func(ctx context.Context, conn *sql.Conn, tx *sql.Tx) error {
+		statements, err := open()
+		if err != nil {
+			return &ErrMigrationFailed{File: filename, CommittedStatements: skip, Cause: err}
+		}
+		defer statements.close()
+
+		// committedThrough is the number of leading statements committed implicitly by DDL (MySQL),
+		// which a rollback cannot undo
+		committedThrough := skip
+
+		commit := func(through int) error {
+			committedThrough = through
+			if committed == nil {
+				return nil
+			}
+			if err := committed(ctx, conn, tx, committedThrough); err != nil {
+				return &ErrMigrationFailed{File: filename, CommittedStatements: committedThrough, Cause: err}
+			}
+			return nil
+		}
+
+		exec, queryRow := conn.ExecContext, queryRowFunc(conn.QueryRowContext)
+		if tx != nil {
+			exec, queryRow = tx.ExecContext, tx.QueryRowContext
+		} else if dialect, ok := dialectFor(driver).(batchingDialect); ok {
+			exec = func(ctx context.Context, statement string, _ ...interface{}) (sql.Result, error) {
+				return dialect.execStatement(ctx, conn, statement)
+			}
+		}
+
+		for i := 0; ; i++ {
+			statement, ok, err := statements.next()
+			if err != nil {
+				return &ErrMigrationFailed{File: filename, StatementIndex: i + 1, CommittedStatements: committedThrough, Cause: err}
+			}
+			if !ok {
+				return nil
+			}
+			if i < skip {
+				continue // committed by the failed run being resumed
+			}
+			statement = strings.TrimSpace(statement) // Trim whitespace
+			if statement == "" {
+				continue // Skip empty statements
+			}
+			if err := ctx.Err(); err != nil {
+				return &ErrMigrationFailed{File: filename, Statement: statement, StatementIndex: i + 1, CommittedStatements: committedThrough, Cause: err}
+			}
+			if idempotent {
+				var guard statementGuard
+				statement, guard = idempotentStatement(driver, statement)
+				if guard != nil {
+					applied, err := guard(ctx, queryRow)
+					if err != nil {
+						return &ErrMigrationFailed{File: filename, Statement: statement, StatementIndex: i + 1, CommittedStatements: committedThrough, Cause: err}
+					}
+					if applied {
+						if tx == nil || causesImplicitCommit(driver, statement) {
+							if err := commit(i + 1); err != nil {
+								return err
+							}
+						}
+						continue // applied by a previous run of the file
+					}
+				}
+			}
+
+			startTime := time.Now()
+			var rowsAffected int64
+			if load, ok := parseLoadDirective(statement); ok {
+				if rowsAffected, err = executeLoad(ctx, driver, conn, tx, load); err != nil {
+					return &ErrMigrationFailed{File: filename, Statement: statement, StatementIndex: i + 1, CommittedStatements: committedThrough, Cause: err}
+				}
+			} else {
+				result, err := exec(ctx, statement)
+				if err != nil {
+					return &ErrMigrationFailed{File: filename, Statement: statement, StatementIndex: i + 1, CommittedStatements: committedThrough, Cause: err}
+				}
+				rowsAffected, err = result.RowsAffected()
+				if err != nil || driver == "sqlite3" && !sqliteChangesPattern.MatchString(lintCode(statement)) {
+					// SQLite reports the rows of the previous INSERT, UPDATE or DELETE for the other statements
+					rowsAffected = 0
+				}
+			}
+			executed(i+1, statement, time.Since(startTime), rowsAffected)
+
+			if tx == nil || causesImplicitCommit(driver, statement) {
+				if err := commit(i + 1); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// recordMigration records the migration in the history table, see historyEntry
+func recordMigration(tx *sql.Tx, table string, migration MigrationInfo, startTime time.Time, success bool, failure *ErrMigrationFailed, driver string) error {
+	return recordHistoryEntry(tx, table, historyEntry(migration, startTime, success, failure), driver)
+}
+
+// historyEntry returns the history entry of the migration started at startTime.
+// For a failed migration, failure holds the failed statement when it is known.
+func historyEntry(migration MigrationInfo, startTime time.Time, success bool, failure *ErrMigrationFailed) HistoryEntry {
+	metadata := migration.Metadata
+	entry := HistoryEntry{
+		InstalledRank: migration.InstalledRank,
+		Filename:      migration.Filename,
+		InstalledOn:   startTime,
+		ExecutionTime: time.Since(startTime).Milliseconds(),
+		RowsAffected:  migration.RowsAffected,
+		Success:       success,
+		Checksum:      migration.Checksum,
+		Author:        metadata.Author,
+		Ticket:        metadata.Ticket,
+		Description:   metadata.Description,
+		Backup:        migration.Backup,
+		AppliedBy:     migration.AppliedBy,
+		Context:       migration.Context,
+		Approval:      migration.Approval,
+		Ahead:         migration.Ahead,
+	}
+	if failure != nil {
+		entry.FailedStatement, entry.CommittedStatements = failure.StatementIndex, failure.CommittedStatements
+	}
+	return entry
+}
+
+// recordHistoryEntry inserts the entry in the history table and commits tx
+func recordHistoryEntry(tx *sql.Tx, table string, entry HistoryEntry, driver string) error {
+	if !isSupportedDriver(driver) {
 		return fmt.Errorf("unsupported driver: %s", driver)
 	}
 
+	failedStatement := sql.NullInt64{Int64: int64(entry.FailedStatement), Valid: entry.FailedStatement > 0}
+	committedStatements := sql.NullInt64{Int64: int64(entry.CommittedStatements), Valid: entry.FailedStatement > 0 || entry.CommittedStatements > 0}
+	rowsAffected := sql.NullInt64{Int64: entry.RowsAffected, Valid: entry.RowsAffected > 0}
+	ahead := sql.NullInt64{Int64: 1, Valid: entry.Ahead}
+
+	// プレースホルダをセットするSQLコマンドを生成
+	sqlCmd := `
+		INSERT INTO ` + table + ` (
+			installed_rank, 
+			filename, 
+			installed_on, 
+			execution_time, 
+			success,
+			checksum,
+			failed_statement,
+			committed_statements,
+			author,
+			ticket,
+			description,
+			backup,
+			applied_by,
+			context,
+			rows_affected,
+			approval,
+			ahead
+		) VALUES (` + bindParams(driver, 17) + `)
+	`
+
 	// プレースホルダを使ってSQLコマンドを実行
-	_, err := tx.Exec(sqlCmd, installedRank, filename, startTime, executionTime, success)
+	_, err := tx.Exec(sqlCmd, entry.InstalledRank, entry.Filename, entry.InstalledOn, entry.ExecutionTime, boolValue(driver, entry.Success), entry.Checksum, failedStatement, committedStatements,
+		nullString(entry.Author), nullString(entry.Ticket), nullString(entry.Description), nullString(entry.Backup),
+		nullString(entry.AppliedBy), nullString(entry.Context), rowsAffected, nullString(entry.Approval), ahead)
 	if err != nil {
-		return fmt.Errorf("failed to record migration in history table, error: %w, filename: %s", err, filename)
+		tx.Rollback()
+		return fmt.Errorf("failed to record migration in history table, error: %w, filename: %s", err, entry.Filename)
 	}
 
 	// トランザクションをコミット
@@ -257,25 +1278,73 @@ func recordMigration(tx *sql.Tx, installedRank int, filename string, startTime t
 	return nil
 }
 
-// createHistoryTable creates the migration history table if it doesn't exist
-func createHistoryTable(db *sql.DB) error {
-	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS gosmm_migration_history (
-		installed_rank INTEGER,
-		filename TEXT,
-		installed_on TIMESTAMP,
-		execution_time INTEGER,
-		success BOOLEAN
-	)`)
+// nullString returns s, or NULL when it is empty
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// createHistoryTable creates the migration history table (and for postgres its schema) if it doesn't exist
+func createHistoryTable(db *sql.DB, driver string, schema string) error {
+	if ddl := createSchemaDDL(driver, schema); schema != "" && ddl != "" {
+		if _, err := db.Exec(ddl); err != nil {
+			return fmt.Errorf("failed to create schema: %w", err)
+		}
+	}
+
+	table := historyTableName(driver, schema)
+	_, err := db.Exec(historyTableDDL(driver, table))
 	if err != nil {
 		return err
 	}
+	return checkHistoryTableVersion(db, driver, schema)
+}
+
+// historyColumnUpgrades lists the columns added after the history table was first created, with the version of
+// the history table adding them, the original table being version 1
+var historyColumnUpgrades = []struct {
+	version    int
+	name       string
+	columnType string
+}{
+	{version: 2, name: "checksum", columnType: "VARCHAR(64)"},
+	{version: 3, name: "failed_statement", columnType: "INTEGER"},
+	{version: 4, name: "committed_statements", columnType: "INTEGER"},
+	{version: 5, name: "author", columnType: "VARCHAR(255)"},
+	{version: 6, name: "ticket", columnType: "VARCHAR(255)"},
+	{version: 7, name: "description", columnType: "VARCHAR(1000)"},
+	{version: 8, name: "backup", columnType: "VARCHAR(1000)"},
+	{version: 9, name: "applied_by", columnType: "VARCHAR(255)"},
+	{version: 9, name: "context", columnType: "VARCHAR(1000)"},
+	{version: 10, name: "rows_affected", columnType: "BIGINT"},
+	{version: 11, name: "approval", columnType: "VARCHAR(255)"},
+	{version: 12, name: "ahead", columnType: "INTEGER"},
+}
+
+// upgradeHistoryTable adds the columns introduced after version of the history table. The columns already
+// added, e.g. by a concurrent run, are skipped.
+func upgradeHistoryTable(db *sql.DB, driver string, table string, version int) error {
+	for _, column := range historyColumnUpgrades {
+		if column.version <= version || historyColumnExists(db, table, column.name) {
+			continue
+		}
+		_, err := db.Exec(addColumnDDL(driver, table, column.name, column.columnType))
+		if err != nil {
+			return fmt.Errorf("failed to add %s column: %w", column.name, err)
+		}
+	}
 	return nil
 }
 
+// historyColumnExists reports whether the history table has the given column
+func historyColumnExists(db *sql.DB, table string, column string) bool {
+	_, err := db.Exec(`SELECT ` + column + ` FROM ` + table + ` WHERE 1 = 0`)
+	return err == nil
+}
+
 // getLastInstalledRank returns the last successful installed_rank
-func getLastInstalledRank(db *sql.DB) (int, error) {
+func getLastInstalledRank(db *sql.DB, driver string, table string) (int, error) {
 	var lastInstalledRank sql.NullInt64
-	err := db.QueryRow("SELECT MAX(installed_rank) FROM gosmm_migration_history WHERE success = TRUE").Scan(&lastInstalledRank)
+	err := db.QueryRow("SELECT MAX(installed_rank) FROM " + table + " WHERE success = " + boolLiteral(driver, true)).Scan(&lastInstalledRank)
 	if err != nil {
 		return 0, err
 	}
@@ -288,11 +1357,39 @@ func getLastInstalledRank(db *sql.DB) (int, error) {
 }
 
 // failedMigrationExists returns true if there is at least one failed migration
-func failedMigrationExists(db *sql.DB) (bool, error) {
-	var failedMigrationExists bool
-	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM gosmm_migration_history WHERE success = FALSE)").Scan(&failedMigrationExists)
+func failedMigrationExists(db *sql.DB, driver string, table string) (bool, error) {
+	var failedMigrations int
+	err := db.QueryRow("SELECT COUNT(*) FROM " + table + " WHERE success = " + boolLiteral(driver, false)).Scan(&failedMigrations)
 	if err != nil {
 		return false, err
 	}
-	return failedMigrationExists, nil
+	return failedMigrations > 0, nil
+}
+
+// takeFailedMigrations removes the failed migrations from the history table so they can be re-run.
+// It returns the number of statements committed before each failure, keyed by filename.
+func takeFailedMigrations(db *sql.DB, driver string, table string) (map[string]int, error) {
+	rows, err := db.Query("SELECT filename, committed_statements FROM " + table + " WHERE success = " + boolLiteral(driver, false))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	failed := make(map[string]int)
+	for rows.Next() {
+		var filename string
+		var committedStatements sql.NullInt64
+		if err := rows.Scan(&filename, &committedStatements); err != nil {
+			return nil, err
+		}
+		failed[filename] = int(committedStatements.Int64)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if _, err := db.Exec("DELETE FROM " + table + " WHERE success = " + boolLiteral(driver, false)); err != nil {
+		return nil, err
+	}
+	return failed, nil
 }
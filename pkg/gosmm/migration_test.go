@@ -56,7 +56,7 @@ func TestCheckMigrationIntegrity(t *testing.T) {
 		t.Fatalf("Failed to create gosmm_migration_history table: %v", err)
 	}
 
-	err = checkMigrationIntegrity(db, migrationsDir)
+	err = checkMigrationIntegrity(db, "sqlite3", migrationHistoryTable, []string{migrationsDir}, dirListing{}, nil)
 	assert.NoError(t, err)
 
 	// Delete the test migration file
@@ -102,8 +102,9 @@ func TestCheckMigrationIntegrityWithMissingMigrationFile(t *testing.T) {
 		t.Fatalf("Failed to insert gosmm_migration_history entry: %v", err)
 	}
 
-	err = checkMigrationIntegrity(db, migrationsDir)
+	err = checkMigrationIntegrity(db, "sqlite3", migrationHistoryTable, []string{migrationsDir}, dirListing{}, nil)
 	assert.Error(t, err)
+	assert.ErrorIs(t, err, ErrMissingFile)
 }
 
 func TestCheckMigrationIntegrityWithInvalidExtension(t *testing.T) {
@@ -136,7 +137,7 @@ func TestCheckMigrationIntegrityWithInvalidExtension(t *testing.T) {
 		t.Fatalf("Failed to create gosmm_migration_history table: %v", err)
 	}
 
-	err = checkMigrationIntegrity(db, migrationsDir)
+	err = checkMigrationIntegrity(db, "sqlite3", migrationHistoryTable, []string{migrationsDir}, dirListing{}, nil)
 	assert.Error(t, err)
 
 	// Delete the test migration file
@@ -297,6 +298,7 @@ func TestMigrateWithSuccessFlagIsFalse(t *testing.T) {
 
 	err = Migrate(db, migrationsDir, "sqlite3")
 	assert.Error(t, err)
+	assert.ErrorIs(t, err, ErrDirtyState)
 
 	// Delete the test migration file
 	if err := os.Remove(testMigrationFile); err != nil {
@@ -328,6 +330,11 @@ func TestMigrateWithSecondFileHasInvalidSQL(t *testing.T) {
 
 	err := Migrate(db, migrationsDir, "sqlite3")
 	assert.Error(t, err)
+	var migrationErr *ErrMigrationFailed
+	if assert.ErrorAs(t, err, &migrationErr) {
+		assert.Equal(t, "v20230101_create_test_data_00002.sql", migrationErr.File)
+		assert.Equal(t, "TABLE test_table_2 (id INTEGER)", migrationErr.Statement)
+	}
 
 	// Check test_table exists
 	var exists bool
@@ -372,3 +379,142 @@ func TestMigrateWithSecondFileHasInvalidSQL(t *testing.T) {
 		t.Fatalf("Failed to delete test migration file: %v", err)
 	}
 }
+
+func TestMigrateWithOutOfOrderFileIsRejected(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	// Create test_migrations directory if it doesn't exist
+	if _, err := os.Stat(migrationsDir); os.IsNotExist(err) {
+		err := os.Mkdir(migrationsDir, 0755)
+		if err != nil {
+			t.Fatalf("Failed to create test_migrations directory: %v", err)
+		}
+	}
+
+	// Apply the newer migration first
+	testMigrationFile2 := filepath.Join(migrationsDir, "v20230101_create_test_data_00002.sql")
+	if err := ioutil.WriteFile(testMigrationFile2, []byte("CREATE TABLE test_table_2 (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	err := Migrate(db, migrationsDir, "sqlite3")
+	assert.NoError(t, err)
+
+	// Add a migration that sorts before the applied one (e.g. merged from an older branch)
+	testMigrationFile1 := filepath.Join(migrationsDir, "v20230101_create_test_data_00001.sql")
+	if err := ioutil.WriteFile(testMigrationFile1, []byte("CREATE TABLE test_table (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+
+	err = Migrate(db, migrationsDir, "sqlite3")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "out-of-order migration detected")
+
+	// Check test_table does not exist
+	var exists bool
+	err = db.QueryRow("SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'test_table')").Scan(&exists)
+	if err != nil {
+		t.Fatalf("Failed to check if test_table exists: %v", err)
+	}
+	if exists {
+		t.Fatalf("Out-of-order migration should not have been applied")
+	}
+
+	// Delete the test migration files
+	if err := os.Remove(testMigrationFile1); err != nil {
+		t.Fatalf("Failed to delete test migration file: %v", err)
+	}
+	if err := os.Remove(testMigrationFile2); err != nil {
+		t.Fatalf("Failed to delete test migration file: %v", err)
+	}
+}
+
+func TestMigrateWithOutOfOrderFileIsAllowed(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	// Create test_migrations directory if it doesn't exist
+	if _, err := os.Stat(migrationsDir); os.IsNotExist(err) {
+		err := os.Mkdir(migrationsDir, 0755)
+		if err != nil {
+			t.Fatalf("Failed to create test_migrations directory: %v", err)
+		}
+	}
+
+	config := MigrationConfig{
+		MigrationsDir:   migrationsDir,
+		Driver:          "sqlite3",
+		AllowOutOfOrder: true,
+	}
+
+	// Apply the newer migration first
+	testMigrationFile2 := filepath.Join(migrationsDir, "v20230101_create_test_data_00002.sql")
+	if err := ioutil.WriteFile(testMigrationFile2, []byte("CREATE TABLE test_table_2 (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	err := MigrateWithConfig(db, config)
+	assert.NoError(t, err)
+
+	// Add a migration that sorts before the applied one (e.g. merged from an older branch)
+	testMigrationFile1 := filepath.Join(migrationsDir, "v20230101_create_test_data_00001.sql")
+	if err := ioutil.WriteFile(testMigrationFile1, []byte("CREATE TABLE test_table (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+
+	err = MigrateWithConfig(db, config)
+	assert.NoError(t, err)
+
+	// Check the out-of-order migration was recorded with the next installed_rank
+	var installedRank int
+	err = db.QueryRow("SELECT installed_rank FROM gosmm_migration_history WHERE filename = 'v20230101_create_test_data_00001.sql'").Scan(&installedRank)
+	if err != nil {
+		t.Fatalf("Failed to check if gosmm_migration_history entry exists: %v", err)
+	}
+	if installedRank != 2 {
+		t.Fatalf("Expected installed_rank 2, got %d", installedRank)
+	}
+
+	// Delete the test migration files
+	if err := os.Remove(testMigrationFile1); err != nil {
+		t.Fatalf("Failed to delete test migration file: %v", err)
+	}
+	if err := os.Remove(testMigrationFile2); err != nil {
+		t.Fatalf("Failed to delete test migration file: %v", err)
+	}
+}
+
+func TestMigrateWithFilenamePattern(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	write := func(name string, content string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test migration file: %v", err)
+		}
+	}
+	write("V1__create_users.sql", "CREATE TABLE users (id INTEGER);")
+	write("V2__add_name.sql", "ALTER TABLE users ADD COLUMN name TEXT;")
+	write("V10__add_email.sql", "ALTER TABLE users ADD COLUMN email TEXT;")
+
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3", FilenamePattern: FlywayFilenamePattern}
+	assert.NoError(t, MigrateWithConfig(db, config))
+	history, err := GetHistory(db, config)
+	assert.NoError(t, err)
+	var applied []string
+	for _, entry := range history {
+		applied = append(applied, entry.Filename)
+	}
+	assert.Equal(t, []string{"V1__create_users.sql", "V2__add_name.sql", "V10__add_email.sql"}, applied)
+
+	// V3 sorts before the latest applied V10 by version, although it sorts after it by name
+	write("V3__add_age.sql", "ALTER TABLE users ADD COLUMN age INTEGER;")
+	err = MigrateWithConfig(db, config)
+	assert.EqualError(t, err, "out-of-order migration detected: V3__add_age.sql sorts before the latest applied migration V10__add_email.sql, enable AllowOutOfOrder to apply it")
+
+	assert.NoError(t, os.Remove(filepath.Join(dir, "V3__add_age.sql")))
+	write("V11__add_age.sql", "ALTER TABLE users ADD COLUMN age INTEGER;")
+	assert.NoError(t, MigrateWithConfig(db, config))
+
+	assert.NoError(t, Validate(db, config))
+}
@@ -0,0 +1,201 @@
+//go:build integration
+
+package gosmm
+
+import (
+	"database/sql"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// setupMySQLDB connects to the MySQL database given by GOSMM_TEST_MYSQL_DSN
+func setupMySQLDB(t *testing.T) (*sql.DB, func()) {
+	dsn := os.Getenv("GOSMM_TEST_MYSQL_DSN")
+	if dsn == "" {
+		t.Skip("GOSMM_TEST_MYSQL_DSN is not set")
+	}
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+
+	return db, func() {
+		db.Exec(`DROP TABLE IF EXISTS ` + migrationHistoryTable)
+		db.Close()
+	}
+}
+
+func TestMySQLMigrateWithProcedure(t *testing.T) {
+	db, teardown := setupMySQLDB(t)
+	defer teardown()
+	defer db.Exec(`DROP TABLE IF EXISTS test_table`)
+	defer db.Exec(`DROP PROCEDURE IF EXISTS seed_test_table`)
+
+	dir := t.TempDir()
+	migration := `
+CREATE TABLE test_table (id INT, note TEXT);
+DELIMITER //
+CREATE PROCEDURE seed_test_table()
+BEGIN
+	INSERT INTO test_table VALUES (1, 'a;b');
+	INSERT INTO test_table VALUES (2, 'it\'s');
+END //
+DELIMITER ;
+CALL seed_test_table();
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_test_data_00001.sql"), []byte(migration), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+
+	err := MigrateWithConfig(db, MigrationConfig{MigrationsDir: dir, Driver: "mysql"})
+	assert.NoError(t, err)
+
+	var count int
+	err = db.QueryRow(`SELECT COUNT(*) FROM test_table`).Scan(&count)
+	if err != nil {
+		t.Fatalf("Failed to count test_table rows: %v", err)
+	}
+	assert.Equal(t, 2, count)
+}
+
+func TestMySQLMigrateReportsImplicitCommit(t *testing.T) {
+	db, teardown := setupMySQLDB(t)
+	defer teardown()
+	defer db.Exec(`DROP TABLE IF EXISTS test_table`)
+
+	dir := t.TempDir()
+	migration := `
+CREATE TABLE test_table (id INT);
+INSERT INTO missing_table VALUES (1);
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_test_data_00001.sql"), []byte(migration), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+
+	err := MigrateWithConfig(db, MigrationConfig{MigrationsDir: dir, Driver: "mysql"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "statements 1-1 were committed implicitly")
+	var migrationErr *ErrMigrationFailed
+	if assert.ErrorAs(t, err, &migrationErr) {
+		assert.Equal(t, 1, migrationErr.CommittedStatements)
+	}
+}
+
+func TestMySQLConcurrentMigrate(t *testing.T) {
+	db, teardown := setupMySQLDB(t)
+	defer teardown()
+	defer db.Exec(`DROP TABLE IF EXISTS test_table_a`)
+	defer db.Exec(`DROP TABLE IF EXISTS test_table_b`)
+
+	dir := t.TempDir()
+	for i, name := range []string{"v20230101_create_test_data_00001.sql", "v20230101_create_test_data_00002.sql"} {
+		content := []byte("CREATE TABLE test_table_" + string(rune('a'+i)) + " (id INT);")
+		if err := ioutil.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+			t.Fatalf("Failed to create test migration file: %v", err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = MigrateWithConfig(db, MigrationConfig{MigrationsDir: dir, Driver: "mysql"})
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		assert.NoError(t, err)
+	}
+
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM ` + migrationHistoryTable).Scan(&count)
+	if err != nil {
+		t.Fatalf("Failed to count history rows: %v", err)
+	}
+	assert.Equal(t, 2, count)
+}
+
+func TestMySQLResumeAfterImplicitCommit(t *testing.T) {
+	db, teardown := setupMySQLDB(t)
+	defer teardown()
+	defer db.Exec(`DROP TABLE IF EXISTS test_table`)
+
+	dir := t.TempDir()
+	migrationFile := filepath.Join(dir, "v20230101_create_test_data_00001.sql")
+	if err := ioutil.WriteFile(migrationFile, []byte("CREATE TABLE test_table (id INT);\nINSERT INTO missing_table VALUES (1);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+
+	config := MigrationConfig{MigrationsDir: dir, Driver: "mysql", ResumeMode: true}
+	err := MigrateWithConfig(db, config)
+	assert.Error(t, err)
+
+	// Fix the failed statement; resuming must not re-run the committed CREATE TABLE
+	if err := ioutil.WriteFile(migrationFile, []byte("CREATE TABLE test_table (id INT);\nINSERT INTO test_table VALUES (1);"), 0644); err != nil {
+		t.Fatalf("Failed to modify test migration file: %v", err)
+	}
+	err = MigrateWithConfig(db, config)
+	assert.NoError(t, err)
+
+	var count int
+	err = db.QueryRow(`SELECT COUNT(*) FROM test_table`).Scan(&count)
+	if err != nil {
+		t.Fatalf("Failed to count test_table rows: %v", err)
+	}
+	assert.Equal(t, 1, count)
+}
+
+func TestMySQLRecordsImplicitCommits(t *testing.T) {
+	db, teardown := setupMySQLDB(t)
+	defer teardown()
+	defer db.Exec(`DROP TABLE IF EXISTS observed`)
+
+	// The second statement reads the record of the running migration after the implicit commit of the first one
+	dir := t.TempDir()
+	migration := `
+CREATE TABLE observed (committed INT);
+INSERT INTO observed SELECT committed_statements FROM ` + migrationHistoryTable + ` WHERE success = FALSE;
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_observed_00001.sql"), []byte(migration), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	assert.NoError(t, MigrateWithConfig(db, MigrationConfig{MigrationsDir: dir, Driver: "mysql"}))
+
+	var committed int
+	if err := db.QueryRow(`SELECT committed FROM observed`).Scan(&committed); err != nil {
+		t.Fatalf("Failed to query observed: %v", err)
+	}
+	assert.Equal(t, 1, committed)
+}
+
+func TestMySQLBulkLoad(t *testing.T) {
+	db, teardown := setupMySQLDB(t)
+	defer teardown()
+	defer db.Exec(`DROP TABLE IF EXISTS countries`)
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "countries.csv"), []byte("code,name\nJP,Japan\nFR,\"France, Republic of\"\nXX,\n"), 0644); err != nil {
+		t.Fatalf("Failed to create CSV file: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_countries_00001.sql"), []byte("CREATE TABLE countries (code VARCHAR(2), name VARCHAR(255));\n-- gosmm:load countries countries.csv\n"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+
+	err := MigrateWithConfig(db, MigrationConfig{MigrationsDir: dir, Driver: "mysql"})
+	assert.NoError(t, err)
+	var name string
+	err = db.QueryRow(`SELECT name FROM countries WHERE code = 'FR'`).Scan(&name)
+	assert.NoError(t, err)
+	assert.Equal(t, "France, Republic of", name)
+	var nulls int
+	err = db.QueryRow(`SELECT COUNT(*) FROM countries WHERE name IS NULL`).Scan(&nulls)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, nulls)
+}
@@ -0,0 +1,212 @@
+package gosmm
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// defaultWebhookTimeout bounds the delivery of a notification when Webhook.Client is nil
+const defaultWebhookTimeout = 10 * time.Second
+
+// NotificationEvent is the outcome of a migration run a notification is sent for
+type NotificationEvent string
+
+const (
+	// NotifyStarted is sent before the pending migrations are applied, only when there are some
+	NotifyStarted NotificationEvent = "started"
+	// NotifySucceeded is sent after the pending migrations were applied, only when there were some
+	NotifySucceeded NotificationEvent = "succeeded"
+	// NotifyFailed is sent when the run fails
+	NotifyFailed NotificationEvent = "failed"
+)
+
+// SlackTemplate is the Template of the webhooks returned by SlackWebhook, posting the summary of the run
+const SlackTemplate = `{"text": {{json .Summary}}}`
+
+// Notification describes a migration run to the webhooks. It is sent as JSON unless Webhook.Template is set.
+type Notification struct {
+	Event       NotificationEvent `json:"event"`
+	Driver      string            `json:"driver"`
+	Schema      string            `json:"schema,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	// Migrations holds the pending migrations when the run starts, and the applied migrations when it succeeds
+	Migrations []string `json:"migrations,omitempty"`
+	// Migration is the failed migration, empty when the failure is not related to a specific migration
+	Migration string `json:"migration,omitempty"`
+	Error     string `json:"error,omitempty"`
+	// Duration is the time since the run started in milliseconds
+	Duration int64     `json:"duration_ms"`
+	Time     time.Time `json:"time"`
+}
+
+// Summary returns a one-line description of the run, e.g. for chat messages
+func (n Notification) Summary() string {
+	where := n.Driver
+	if n.Environment != "" {
+		where += ", environment " + n.Environment
+	}
+	if n.Schema != "" {
+		where += ", schema " + n.Schema
+	}
+	duration := (time.Duration(n.Duration) * time.Millisecond).String()
+	switch n.Event {
+	case NotifyStarted:
+		return fmt.Sprintf("gosmm: applying %d migration(s) (%s)", len(n.Migrations), where)
+	case NotifySucceeded:
+		return fmt.Sprintf("gosmm: applied %d migration(s) in %s (%s)", len(n.Migrations), duration, where)
+	default:
+		if n.Migration != "" {
+			return fmt.Sprintf("gosmm: migration %s failed after %s (%s): %s", n.Migration, duration, where, n.Error)
+		}
+		return fmt.Sprintf("gosmm: migration run failed after %s (%s): %s", duration, where, n.Error)
+	}
+}
+
+// Webhook posts a notification to URL on the outcomes of migration runs, e.g. so that on-call engineers
+// hear about a failed production migration right away. A notification that cannot be delivered is reported
+// on stderr and does not fail the run.
+type Webhook struct {
+	URL string
+	// Events selects the notifications sent, all of them when empty
+	Events []NotificationEvent
+	// Template is a text/template rendering the body from the Notification, which is sent as JSON when empty.
+	// The json function encodes a value as JSON, e.g. {"text": {{json .Summary}}}.
+	Template string
+	// Headers are added to the request, e.g. an Authorization header. Content-Type is application/json by default.
+	Headers map[string]string
+	// Client sends the request, an http.Client with a 10s timeout when nil
+	Client *http.Client
+}
+
+// SlackWebhook returns a Webhook posting the summary of every run to a Slack incoming webhook URL
+func SlackWebhook(url string) Webhook {
+	return Webhook{URL: url, Template: SlackTemplate}
+}
+
+// accepts reports whether the webhook is sent the given event
+func (w Webhook) accepts(event NotificationEvent) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// body renders the request body of the notification
+func (w Webhook) body(notification Notification) ([]byte, error) {
+	if w.Template == "" {
+		return json.Marshal(notification)
+	}
+	tmpl, err := template.New("webhook").Funcs(template.FuncMap{"json": marshalJSON}).Parse(w.Template)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template: %w", err)
+	}
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, notification); err != nil {
+		return nil, fmt.Errorf("failed to render template: %w", err)
+	}
+	return body.Bytes(), nil
+}
+
+// Send posts the notification to the webhook
+func (w Webhook) Send(notification Notification) error {
+	body, err := w.body(notification)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return withoutURL(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range w.Headers {
+		req.Header.Set(name, value)
+	}
+
+	client := w.Client
+	if client == nil {
+		client = &http.Client{Timeout: defaultWebhookTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return withoutURL(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook responded with %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// withoutURL removes the URL from a *url.Error, since it may hold a secret such as a Slack token
+func withoutURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return fmt.Errorf("%s: %w", urlErr.Op, urlErr.Err)
+	}
+	return err
+}
+
+// marshalJSON encodes v as JSON for the templates
+func marshalJSON(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	return string(data), err
+}
+
+// runNotifier sends the notifications of a migration run
+type runNotifier struct {
+	config  MigrationConfig
+	started time.Time
+}
+
+// notify sends the notification of the event to the webhooks accepting it
+func (n runNotifier) notify(notification Notification) {
+	if len(n.config.Webhooks) == 0 {
+		return
+	}
+	notification.Driver = n.config.Driver
+	notification.Schema = n.config.Schema
+	notification.Environment = n.config.Environment
+	notification.Duration = time.Since(n.started).Milliseconds()
+	notification.Time = time.Now()
+	for _, webhook := range n.config.Webhooks {
+		if !webhook.accepts(notification.Event) {
+			continue
+		}
+		if err := webhook.Send(notification); err != nil {
+			n.config.LogLevel.printf(LogWarn, "WARNING: failed to send the %s notification to %s: %v\n", notification.Event, webhookHost(webhook.URL), err)
+		}
+	}
+}
+
+// webhookHost returns the host of a webhook URL, whose path may hold a secret such as a Slack token
+func webhookHost(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" {
+		return "webhook"
+	}
+	return parsed.Host
+}
+
+// migrationFilenames returns the filenames of the migrations
+func migrationFilenames(migrations []MigrationInfo) []string {
+	filenames := make([]string, len(migrations))
+	for i, migration := range migrations {
+		filenames[i] = migration.Filename
+	}
+	return filenames
+}
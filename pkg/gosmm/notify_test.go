@@ -0,0 +1,143 @@
+package gosmm
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// webhookRecorder records the bodies posted to a webhook
+type webhookRecorder struct {
+	mu     sync.Mutex
+	bodies []string
+}
+
+func (r *webhookRecorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.bodies = append(r.bodies, string(body))
+}
+
+func (r *webhookRecorder) notifications(t *testing.T) []Notification {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	notifications := make([]Notification, len(r.bodies))
+	for i, body := range r.bodies {
+		if err := json.Unmarshal([]byte(body), &notifications[i]); err != nil {
+			t.Fatalf("Failed to decode notification: %v", err)
+		}
+	}
+	return notifications
+}
+
+func TestWebhookNotifications(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	recorder := &webhookRecorder{}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3", Environment: "production", Webhooks: []Webhook{{URL: server.URL}}}
+
+	assert.NoError(t, MigrateWithConfig(db, config))
+	notifications := recorder.notifications(t)
+	if assert.Len(t, notifications, 2) {
+		assert.Equal(t, NotifyStarted, notifications[0].Event)
+		assert.Equal(t, []string{"v20230101_create_users_00001.sql"}, notifications[0].Migrations)
+		assert.Equal(t, "production", notifications[0].Environment)
+		assert.Equal(t, NotifySucceeded, notifications[1].Event)
+		assert.Equal(t, []string{"v20230101_create_users_00001.sql"}, notifications[1].Migrations)
+	}
+
+	// Nothing is sent when there is nothing to apply
+	assert.NoError(t, MigrateWithConfig(db, config))
+	assert.Len(t, recorder.notifications(t), 2)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230102_add_email_00002.sql"), []byte("ALTER TABLE missing_table ADD COLUMN email TEXT;"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	assert.Error(t, MigrateWithConfig(db, config))
+	notifications = recorder.notifications(t)
+	if assert.Len(t, notifications, 4) {
+		assert.Equal(t, NotifyFailed, notifications[3].Event)
+		assert.Equal(t, "v20230102_add_email_00002.sql", notifications[3].Migration)
+		assert.Contains(t, notifications[3].Error, "no such table: missing_table")
+	}
+
+	// A failure before any migration is executed, here the dirty database, is notified too
+	assert.ErrorIs(t, MigrateWithConfig(db, config), ErrDirtyState)
+	notifications = recorder.notifications(t)
+	if assert.Len(t, notifications, 5) {
+		assert.Equal(t, NotifyFailed, notifications[4].Event)
+		assert.Empty(t, notifications[4].Migration)
+	}
+}
+
+func TestWebhookEventsAndTemplate(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	recorder := &webhookRecorder{}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("ALTER TABLE missing_table ADD COLUMN email TEXT;"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	slack := SlackWebhook(server.URL)
+	slack.Events = []NotificationEvent{NotifyFailed}
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3", Webhooks: []Webhook{slack}}
+
+	assert.Error(t, MigrateWithConfig(db, config))
+	if assert.Len(t, recorder.bodies, 1) {
+		var message struct{ Text string }
+		assert.NoError(t, json.Unmarshal([]byte(recorder.bodies[0]), &message))
+		assert.True(t, strings.HasPrefix(message.Text, "gosmm: migration v20230101_create_users_00001.sql failed after "), message.Text)
+		assert.Contains(t, message.Text, "(sqlite3): ")
+	}
+}
+
+func TestWebhookFailureDoesNotFailRun(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid_token", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	webhook := Webhook{URL: server.URL + "/services/secret"}
+	err := webhook.Send(Notification{Event: NotifyStarted})
+	assert.EqualError(t, err, "webhook responded with 403 Forbidden: invalid_token")
+
+	config := MigrationConfig{MigrationsDir: t.TempDir(), Driver: "sqlite3", Webhooks: []Webhook{webhook}}
+	assert.NoError(t, MigrateWithConfig(db, config))
+
+	// The URL, which may hold a secret, is not part of the errors
+	err = Webhook{URL: "http://127.0.0.1:0/services/secret"}.Send(Notification{Event: NotifyStarted})
+	assert.Error(t, err)
+	assert.NotContains(t, err.Error(), "secret")
+}
+
+func TestNotificationSummary(t *testing.T) {
+	assert.Equal(t, "gosmm: applying 2 migration(s) (postgres, environment production, schema app)",
+		Notification{Event: NotifyStarted, Driver: "postgres", Environment: "production", Schema: "app", Migrations: []string{"a", "b"}}.Summary())
+	assert.Equal(t, "gosmm: applied 1 migration(s) in 1.5s (mysql)",
+		Notification{Event: NotifySucceeded, Driver: "mysql", Migrations: []string{"a"}, Duration: 1500}.Summary())
+	assert.Equal(t, "gosmm: migration run failed after 0s (mysql): boom",
+		Notification{Event: NotifyFailed, Driver: "mysql", Error: "boom"}.Summary())
+}
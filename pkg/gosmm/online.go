@@ -0,0 +1,163 @@
+package gosmm
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// onlineMarker annotates a MySQL migration whose ALTER TABLE statements run through OnlineSchemaChange
+const onlineMarker = "-- gosmm:online"
+
+// OnlineSchemaChangeTool is a tool altering MySQL tables without blocking their writes
+type OnlineSchemaChangeTool string
+
+const (
+	// GhOst is GitHub's gh-ost
+	GhOst OnlineSchemaChangeTool = "gh-ost"
+	// PtOnlineSchemaChange is Percona's pt-online-schema-change
+	PtOnlineSchemaChange OnlineSchemaChangeTool = "pt-online-schema-change"
+)
+
+// OnlineSchemaChange runs the ALTER TABLE statements of the MySQL migrations annotated with a
+// "-- gosmm:online" line through gh-ost or pt-online-schema-change instead of executing them, so that
+// huge tables are altered by copying their rows in the background without blocking writes
+type OnlineSchemaChange struct {
+	Tool OnlineSchemaChangeTool
+	// Path is the executable of the tool, its name looked up in PATH when empty
+	Path string
+	// DB is the connection of the tool, usually the DBConfig of the run. A DSN is parsed for its
+	// address, user, password and database, and a PasswordProvider is asked for the password.
+	DB DBConfig
+	// Args are appended to the generated arguments, e.g. "--allow-on-master" or "--chunk-size=2000" for gh-ost
+	Args []string
+}
+
+// command returns the executable and the arguments altering table with the ALTER TABLE specification alter
+func (o OnlineSchemaChange) command(ctx context.Context, database string, table string, alter string) (string, []string, error) {
+	host, port, user, password, dbName, err := o.connection(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	if database != "" {
+		dbName = database
+	}
+	if dbName == "" {
+		return "", nil, fmt.Errorf("missing database of table %s", table)
+	}
+
+	var args []string
+	switch o.Tool {
+	case GhOst:
+		args = []string{
+			"--host=" + host,
+			"--port=" + strconv.Itoa(port),
+			"--user=" + user,
+			"--password=" + password,
+			"--database=" + dbName,
+			"--table=" + table,
+			"--alter=" + alter,
+			"--execute",
+		}
+	case PtOnlineSchemaChange:
+		dsn := fmt.Sprintf("h=%s,P=%d,u=%s,D=%s,t=%s", host, port, user, dbName, table)
+		if password != "" {
+			dsn += ",p=" + password
+		}
+		args = []string{"--alter", alter, "--execute", dsn}
+	default:
+		return "", nil, fmt.Errorf("unsupported online schema change tool: %q", o.Tool)
+	}
+	path := o.Path
+	if path == "" {
+		path = string(o.Tool)
+	}
+	return path, append(args, o.Args...), nil
+}
+
+// connection returns the address and credentials of the tool's connection
+func (o OnlineSchemaChange) connection(ctx context.Context) (host string, port int, user string, password string, dbName string, err error) {
+	config := o.DB
+	host, port, user, password, dbName = config.Host, config.Port, config.User, config.Password, config.DBName
+	if config.DSN != "" {
+		parsed, err := mysql.ParseDSN(config.DSN)
+		if err != nil {
+			return "", 0, "", "", "", fmt.Errorf("invalid mysql DSN: %w", err)
+		}
+		user, password, dbName = parsed.User, parsed.Passwd, parsed.DBName
+		h, p, err := net.SplitHostPort(parsed.Addr)
+		if err != nil {
+			return "", 0, "", "", "", fmt.Errorf("invalid mysql address %q: %w", parsed.Addr, err)
+		}
+		host = h
+		if port, err = strconv.Atoi(p); err != nil {
+			return "", 0, "", "", "", fmt.Errorf("invalid mysql address %q: %w", parsed.Addr, err)
+		}
+	}
+	if port == 0 {
+		port = 3306
+	}
+	switch provider := config.PasswordProvider.(type) {
+	case nil:
+	case CredentialsProvider:
+		user, password, _, err = provider.Credentials(ctx, config)
+	default:
+		password, _, err = provider.Password(ctx, config)
+	}
+	if err != nil {
+		return "", 0, "", "", "", fmt.Errorf("failed to get password: %w", err)
+	}
+	return host, port, user, password, dbName, nil
+}
+
+// executeOnline returns the execution of an online migration, running the tool for each of its statements,
+// which must all be ALTER TABLE statements. Like the DDL of MySQL, each completed change is committed, so the
+// first skip statements completed by the failed run being resumed are not run again.
+func executeOnline(config MigrationConfig, filename string, statements []string, skip int) (func(ctx context.Context, conn *sql.Conn, tx *sql.Tx) error, error) {
+	if config.Driver != "mysql" {
+		return nil, fmt.Errorf("migration %s is annotated with %s, which is only supported for mysql", filename, onlineMarker)
+	}
+	if config.OnlineSchemaChange == nil {
+		return nil, fmt.Errorf("migration %s is annotated with %s but OnlineSchemaChange is not configured", filename, onlineMarker)
+	}
+	type alteration struct{ database, table, alter string }
+	alterations := make([]alteration, 0, len(statements))
+	for index, statement := range statements {
+		// the string literals of the specification are kept, e.g. of a DEFAULT
+		match := alterTablePattern.FindStringSubmatch(sqlCommentPattern.ReplaceAllString(statement, ""))
+		if match == nil {
+			return nil, fmt.Errorf("statement %d of online migration %s is not an ALTER TABLE statement", index+1, filename)
+		}
+		database, table, ok := strings.Cut(match[1], ".")
+		if !ok {
+			database, table = "", match[1]
+		}
+		alterations = append(alterations, alteration{strings.Trim(database, "`"), strings.Trim(table, "`"), strings.TrimSpace(match[2])})
+	}
+
+	return func(ctx context.Context, _ *sql.Conn, _ *sql.Tx) error {
+		for index, a := range alterations {
+			if index < skip {
+				continue
+			}
+			path, args, err := config.OnlineSchemaChange.command(ctx, a.database, a.table, a.alter)
+			if err == nil {
+				config.LogLevel.printf(LogInfo, "Running %s on %s for %s\n", config.OnlineSchemaChange.Tool, a.table, filename)
+				cmd := exec.CommandContext(ctx, path, args...)
+				cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+				err = cmd.Run()
+			}
+			if err != nil {
+				return &ErrMigrationFailed{File: filename, Statement: statements[index], StatementIndex: index + 1, CommittedStatements: index, Cause: err}
+			}
+		}
+		return nil
+	}, nil
+}
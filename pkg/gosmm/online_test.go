@@ -0,0 +1,88 @@
+package gosmm
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOnlineSchemaChangeCommand(t *testing.T) {
+	ghost := OnlineSchemaChange{
+		Tool: GhOst,
+		DB:   DBConfig{Driver: "mysql", Host: "db.internal", User: "gosmm", Password: "s3cret", DBName: "app"},
+		Args: []string{"--allow-on-master"},
+	}
+	path, args, err := ghost.command(context.Background(), "", "users", "ADD COLUMN age INT DEFAULT 0")
+	assert.NoError(t, err)
+	assert.Equal(t, "gh-ost", path)
+	assert.Equal(t, []string{"--host=db.internal", "--port=3306", "--user=gosmm", "--password=s3cret", "--database=app", "--table=users", "--alter=ADD COLUMN age INT DEFAULT 0", "--execute", "--allow-on-master"}, args)
+
+	ptosc := OnlineSchemaChange{
+		Tool: PtOnlineSchemaChange,
+		Path: "/opt/percona/bin/pt-online-schema-change",
+		DB:   DBConfig{Driver: "mysql", DSN: "gosmm:s3cret@tcp(db.internal:3307)/app"},
+	}
+	path, args, err = ptosc.command(context.Background(), "billing", "invoices", "ADD INDEX invoices_at (created_at)")
+	assert.NoError(t, err)
+	assert.Equal(t, "/opt/percona/bin/pt-online-schema-change", path)
+	assert.Equal(t, []string{"--alter", "ADD INDEX invoices_at (created_at)", "--execute", "h=db.internal,P=3307,u=gosmm,D=billing,t=invoices,p=s3cret"}, args)
+
+	_, _, err = OnlineSchemaChange{Tool: "lhm", DB: ghost.DB}.command(context.Background(), "", "users", "ADD COLUMN age INT")
+	assert.EqualError(t, err, `unsupported online schema change tool: "lhm"`)
+	_, _, err = OnlineSchemaChange{Tool: GhOst, DB: DBConfig{Host: "db.internal"}}.command(context.Background(), "", "users", "ADD COLUMN age INT")
+	assert.EqualError(t, err, "missing database of table users")
+}
+
+func TestExecuteOnline(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake tool is a shell script")
+	}
+	dir := t.TempDir()
+	output := filepath.Join(dir, "args.txt")
+	tool := filepath.Join(dir, "gh-ost")
+	if err := ioutil.WriteFile(tool, []byte("#!/bin/sh\necho \"$@\" >> "+output+"\n"), 0755); err != nil {
+		t.Fatalf("Failed to create fake tool: %v", err)
+	}
+	config := MigrationConfig{Driver: "mysql", OnlineSchemaChange: &OnlineSchemaChange{
+		Tool: GhOst,
+		Path: tool,
+		DB:   DBConfig{Host: "localhost", User: "root", DBName: "app"},
+	}}
+	statements := []string{
+		"-- gosmm:online\nALTER TABLE users ADD COLUMN age INT DEFAULT 0",
+		"ALTER TABLE `app`.`posts` ADD INDEX posts_at (created_at)",
+	}
+
+	execute, err := executeOnline(config, "v20230101_alter_users_00001.sql", statements, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, execute(context.Background(), nil, nil))
+	data, err := ioutil.ReadFile(output)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"--host=localhost --port=3306 --user=root --password= --database=app --table=users --alter=ADD COLUMN age INT DEFAULT 0 --execute",
+		"--host=localhost --port=3306 --user=root --password= --database=app --table=posts --alter=ADD INDEX posts_at (created_at) --execute",
+	}, strings.Split(strings.TrimSpace(string(data)), "\n"))
+
+	// A failure of the tool fails the migration, the previous changes being completed
+	config.OnlineSchemaChange.Path = filepath.Join(dir, "missing")
+	execute, err = executeOnline(config, "v20230101_alter_users_00001.sql", statements, 1)
+	assert.NoError(t, err)
+	err = execute(context.Background(), nil, nil)
+	var migrationErr *ErrMigrationFailed
+	assert.True(t, errors.As(err, &migrationErr))
+	assert.Equal(t, 2, migrationErr.StatementIndex)
+	assert.Equal(t, 1, migrationErr.CommittedStatements)
+
+	_, err = executeOnline(config, "v20230101_seed_users_00001.sql", []string{"INSERT INTO users (id) VALUES (1)"}, 0)
+	assert.EqualError(t, err, "statement 1 of online migration v20230101_seed_users_00001.sql is not an ALTER TABLE statement")
+	_, err = executeOnline(MigrationConfig{Driver: "mysql"}, "v20230101_alter_users_00001.sql", statements, 0)
+	assert.EqualError(t, err, "migration v20230101_alter_users_00001.sql is annotated with -- gosmm:online but OnlineSchemaChange is not configured")
+	_, err = executeOnline(MigrationConfig{Driver: "sqlite3"}, "v20230101_alter_users_00001.sql", statements, 0)
+	assert.EqualError(t, err, "migration v20230101_alter_users_00001.sql is annotated with -- gosmm:online, which is only supported for mysql")
+}
@@ -0,0 +1,154 @@
+package gosmm
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+	// oracleNameExists is the SQLCODE of ORA-00955, raised when creating a table that already exists
+	oracleNameExists = -955
+	// oracleMaxWait is the DBMS_LOCK.MAXWAIT timeout, waiting for the lock indefinitely
+	oracleMaxWait = 32767
+)
+
+func init() {
+	// go-ora registers its driver as "oracle" and godror as "godror", both with :1 bind parameters
+	RegisterDialect("oracle", oracleDialect{})
+	RegisterDialect("godror", oracleDialect{})
+}
+
+// oracleDialect supports Oracle Database through the database/sql drivers of github.com/sijms/go-ora and
+// github.com/godror/godror. Like MySQL, Oracle commits the current transaction implicitly on DDL statements.
+type oracleDialect struct{}
+
+// HistoryTableDDL implements Dialect
+func (oracleDialect) HistoryTableDDL(table string) string {
+	return oracleCreateTable(table, `
+		installed_rank NUMBER(10) NOT NULL PRIMARY KEY,
+		filename VARCHAR2(255) NOT NULL,
+		installed_on TIMESTAMP WITH TIME ZONE DEFAULT SYSTIMESTAMP NOT NULL,
+		execution_time NUMBER(19) NOT NULL,
+		success NUMBER(1) NOT NULL,
+		checksum VARCHAR2(64),
+		failed_statement NUMBER(10),
+		committed_statements NUMBER(10),
+		author VARCHAR2(255),
+		ticket VARCHAR2(255),
+		description VARCHAR2(1000),
+		backup VARCHAR2(1000),
+		applied_by VARCHAR2(255),
+		context VARCHAR2(1000),
+		rows_affected NUMBER(19),
+		approval VARCHAR2(255),
+		ahead NUMBER(1)`)
+}
+
+// HistoryVersionTableDDL implements Dialect
+func (oracleDialect) HistoryVersionTableDDL(table string) string {
+	return oracleCreateTable(table, `
+		version NUMBER(10) NOT NULL PRIMARY KEY,
+		upgraded_on TIMESTAMP WITH TIME ZONE NOT NULL`)
+}
+
+// LockTableDDL implements Dialect
+func (oracleDialect) LockTableDDL(table string) string {
+	return oracleCreateTable(table, `
+		lock_name VARCHAR2(255) NOT NULL PRIMARY KEY,
+		owner VARCHAR2(255) NOT NULL,
+		expires_at NUMBER(19) NOT NULL`)
+}
+
+// oracleCreateTable returns a PL/SQL block creating the table with the columns unless it exists, Oracle
+// having no CREATE TABLE IF NOT EXISTS before 23ai
+func oracleCreateTable(table string, columns string) string {
+	ddl := `CREATE TABLE ` + table + ` (` + columns + `
+	)`
+	return `BEGIN
+	EXECUTE IMMEDIATE '` + strings.ReplaceAll(ddl, "'", "''") + `';
+EXCEPTION
+	WHEN OTHERS THEN
+		IF SQLCODE != ` + fmt.Sprint(oracleNameExists) + ` THEN
+			RAISE;
+		END IF;
+END;`
+}
+
+// Lock implements Dialect with an exclusive DBMS_LOCK lock held by a dedicated connection, since the lock
+// belongs to the session. DBMS_LOCK waits whole seconds, so wait is rounded up.
+func (oracleDialect) Lock(ctx context.Context, db *sql.DB, table string, wait time.Duration) (func() error, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	name := "gosmm:" + table
+	timeout := int64(oracleMaxWait)
+	if wait > 0 {
+		timeout = int64(math.Ceil(wait.Seconds()))
+	}
+	var result int64
+	_, err = conn.ExecContext(ctx, `DECLARE
+	handle VARCHAR2(128);
+BEGIN
+	DBMS_LOCK.ALLOCATE_UNIQUE(:1, handle);
+	:2 := DBMS_LOCK.REQUEST(handle, DBMS_LOCK.X_MODE, :3, FALSE);
+END;`, name, sql.Out{Dest: &result}, timeout)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to acquire lock: %w", err)
+	}
+	// 1 is returned when the request timed out, 4 when the session already holds the lock
+	if result == 1 {
+		conn.Close()
+		return nil, fmt.Errorf("%w after %s", ErrLockTimeout, wait)
+	}
+	if result != 0 && result != 4 {
+		conn.Close()
+		return nil, fmt.Errorf("failed to acquire lock: DBMS_LOCK.REQUEST returned %d", result)
+	}
+
+	return func() error {
+		defer conn.Close()
+		_, err := conn.ExecContext(context.Background(), `DECLARE
+	handle VARCHAR2(128);
+	result INTEGER;
+BEGIN
+	DBMS_LOCK.ALLOCATE_UNIQUE(:1, handle);
+	result := DBMS_LOCK.RELEASE(handle);
+END;`, name)
+		if err != nil {
+			return fmt.Errorf("failed to release lock: %w", err)
+		}
+		return nil
+	}, nil
+}
+
+// QuoteIdentifier implements Dialect. Oracle folds unquoted names to upper case.
+func (oracleDialect) QuoteIdentifier(name string) string {
+	return quoteFoldedIdentifier(name)
+}
+
+// BindParam implements Dialect
+func (oracleDialect) BindParam(i int) string {
+	return fmt.Sprintf(":%d", i)
+}
+
+// TransactionalDDL implements Dialect
+func (oracleDialect) TransactionalDDL() bool {
+	return false
+}
+
+// SplitStatements implements Dialect like SQL*Plus: statements end with a semicolon, which is dropped, or a
+// line holding a single "/". Anonymous blocks and the statements creating stored procedures, functions,
+// packages, triggers and types are PL/SQL blocks, which end with a "/" line only and keep their semicolons.
+func (oracleDialect) SplitStatements(sql string) []string {
+	s := &statementSplitter{driver: "oracle", input: sql, delimiter: defaultDelimiter}
+	s.split()
+	return s.statements
+}
+
+func (oracleDialect) numericBooleans() {}
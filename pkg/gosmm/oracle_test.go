@@ -0,0 +1,57 @@
+package gosmm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOracleSplitStatements(t *testing.T) {
+	sql := `CREATE TABLE users (id NUMBER(10) PRIMARY KEY, name VARCHAR2(255));
+INSERT INTO users (id, name) VALUES (1, q'[it's; fine]');
+
+CREATE OR REPLACE PROCEDURE add_user(p_name IN VARCHAR2) AS
+BEGIN
+	INSERT INTO users (id, name) VALUES (users_seq.NEXTVAL, p_name);
+END;
+/
+
+BEGIN
+	add_user('admin');
+END;
+/
+COMMENT ON TABLE users IS 'the users'
+/
+`
+	assert.Equal(t, []string{
+		"CREATE TABLE users (id NUMBER(10) PRIMARY KEY, name VARCHAR2(255))",
+		"INSERT INTO users (id, name) VALUES (1, q'[it's; fine]')",
+		"CREATE OR REPLACE PROCEDURE add_user(p_name IN VARCHAR2) AS\nBEGIN\n\tINSERT INTO users (id, name) VALUES (users_seq.NEXTVAL, p_name);\nEND;",
+		"BEGIN\n\tadd_user('admin');\nEND;",
+		"COMMENT ON TABLE users IS 'the users'",
+	}, splitStatements(sql, "oracle"))
+}
+
+func TestOracleQuoteIdentifier(t *testing.T) {
+	assert.Equal(t, `"APP"`, quoteIdentifier("oracle", "app"))
+	assert.Equal(t, `"TENANT_A"`, quoteIdentifier("godror", "TENANT_A"))
+	assert.Equal(t, `"MyApp"`, quoteIdentifier("oracle", "MyApp"))
+	assert.Equal(t, `"my app"`, quoteIdentifier("oracle", "my app"))
+	assert.Equal(t, `"APP".gosmm_migration_history`, historyTableName("oracle", "app"))
+}
+
+func TestOracleHistory(t *testing.T) {
+	assert.Equal(t, ":1, :2", bindParams("oracle", 2))
+	assert.Equal(t, "1", boolLiteral("oracle", true))
+	assert.Equal(t, 0, boolValue("oracle", false))
+	assert.Equal(t, true, boolValue("postgres", true))
+	assert.False(t, transactionalDDL("oracle"))
+	assert.True(t, transactionalMigrations("oracle"))
+
+	ddl := historyTableDDL("oracle", "gosmm_migration_history")
+	assert.Contains(t, ddl, "EXECUTE IMMEDIATE 'CREATE TABLE gosmm_migration_history (")
+	assert.Contains(t, ddl, "installed_on TIMESTAMP WITH TIME ZONE DEFAULT SYSTIMESTAMP NOT NULL")
+	assert.Contains(t, ddl, "IF SQLCODE != -955 THEN")
+	// the block is executed as a single statement
+	assert.Equal(t, []string{ddl}, splitStatements(ddl, "oracle"))
+}
@@ -0,0 +1,61 @@
+package gosmm
+
+import "strings"
+
+// parallelWaves groups the pending migrations, by index, into waves applied one after the other. The migrations
+// of a wave are applied in parallel: consecutive migrations declaring the objects they touch (see
+// MigrationMetadata.Touches), which touch disjoint objects and do not require each other, up to parallelism of
+// them. The other migrations are waves of their own, so that they are applied alone, in order.
+func parallelWaves(pending []MigrationInfo, touches map[string][]string, requires map[string][]string, parallelism int) [][]int {
+	waves := make([][]int, 0, len(pending))
+	var wave []int
+	touched := make(map[string]bool)
+	for i, migration := range pending {
+		objects := touches[migration.Filename]
+		if len(wave) > 0 && !joinsWave(pending, wave, touched, objects, requires[migration.Filename], parallelism) {
+			waves = append(waves, wave)
+			wave, touched = nil, make(map[string]bool)
+		}
+		wave = append(wave, i)
+		for _, object := range objects {
+			touched[strings.ToLower(object)] = true
+		}
+	}
+	if len(wave) > 0 {
+		waves = append(waves, wave)
+	}
+	return waves
+}
+
+// joinsWave reports whether a migration touching objects and requiring required can be applied in parallel
+// with the migrations of wave, which touch the objects of touched
+func joinsWave(pending []MigrationInfo, wave []int, touched map[string]bool, objects []string, required []string, parallelism int) bool {
+	// a migration without declared objects may touch anything
+	if len(objects) == 0 || len(touched) == 0 || len(wave) >= parallelism {
+		return false
+	}
+	for _, object := range objects {
+		if touched[strings.ToLower(object)] {
+			return false
+		}
+	}
+	for _, name := range required {
+		for _, i := range wave {
+			if pending[i].Filename == name {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// migrationTouches returns the objects touched by the migration files declared in their headers, keyed by filename
+func migrationTouches(files []migrationFile) map[string][]string {
+	touches := make(map[string][]string)
+	for _, file := range files {
+		if len(file.metadata.Touches) > 0 {
+			touches[file.name] = file.metadata.Touches
+		}
+	}
+	return touches
+}
@@ -0,0 +1,95 @@
+package gosmm
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParallelWaves(t *testing.T) {
+	pending := []MigrationInfo{
+		{Filename: "v20230101_create_users_00001.sql"},
+		{Filename: "v20230102_index_users_00002.sql"},
+		{Filename: "v20230103_index_posts_00003.sql"},
+		{Filename: "v20230104_index_tags_00004.sql"},
+		{Filename: "v20230105_index_users_name_00005.sql"},
+		{Filename: "v20230106_index_comments_00006.sql"},
+		{Filename: "v20230107_index_likes_00007.sql"},
+	}
+	touches := map[string][]string{
+		"v20230102_index_users_00002.sql":      {"users"},
+		"v20230103_index_posts_00003.sql":      {"posts"},
+		"v20230104_index_tags_00004.sql":       {"tags"},
+		"v20230105_index_users_name_00005.sql": {"USERS"},
+		"v20230106_index_comments_00006.sql":   {"comments"},
+		"v20230107_index_likes_00007.sql":      {"likes"},
+	}
+	requires := map[string][]string{
+		"v20230107_index_likes_00007.sql": {"v20230106_index_comments_00006.sql"},
+	}
+
+	assert.Equal(t, [][]int{{0}, {1, 2, 3}, {4, 5}, {6}}, parallelWaves(pending, touches, requires, 4))
+	assert.Equal(t, [][]int{{0}, {1, 2}, {3, 4}, {5}, {6}}, parallelWaves(pending, touches, requires, 2))
+	assert.Equal(t, [][]int{{0}, {1}, {2}, {3}, {4}, {5}, {6}}, parallelWaves(pending, touches, requires, 0))
+}
+
+func TestMigrateParallel(t *testing.T) {
+	// a file database shared by the connections of the migrations applied in parallel, which wait for each other
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "test.db")+"?_busy_timeout=10000&_txlock=immediate")
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+
+	dir := t.TempDir()
+	// the index builds are slow, so that both are started before the first completes
+	slow := " WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM c WHERE x < 300000) SELECT COUNT(*) FROM c;"
+	files := map[string]string{
+		"v20230101_create_tables_00001.sql": "CREATE TABLE users (id INTEGER, name TEXT); CREATE TABLE posts (id INTEGER, title TEXT);",
+		"v20230102_index_users_00002.sql":   "-- gosmm:touches users\nCREATE INDEX users_name ON users (name);" + slow,
+		"v20230103_index_posts_00003.sql":   "-- gosmm:touches posts\nCREATE INDEX posts_title ON posts (title);" + slow,
+		"v20230104_seed_users_00004.sql":    "INSERT INTO users (id, name) VALUES (1, 'admin');",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test migration file: %v", err)
+		}
+	}
+
+	running, maxRunning := 0, 0
+	var afterEach []string
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3", Parallelism: 4,
+		Progress: func(event Event) {
+			switch event.Kind {
+			case EventMigrationStarted:
+				running++
+				if running > maxRunning {
+					maxRunning = running
+				}
+			case EventMigrationFinished, EventMigrationFailed:
+				running--
+			}
+		},
+		Hooks: Hooks{AfterEach: func(migration MigrationInfo) error {
+			afterEach = append(afterEach, migration.Filename)
+			return nil
+		}},
+	}
+	assert.NoError(t, MigrateWithConfig(db, config))
+	assert.Equal(t, 2, maxRunning)
+	assert.Len(t, afterEach, 4)
+
+	history, err := GetHistory(db, config)
+	assert.NoError(t, err)
+	if assert.Len(t, history, 4) {
+		for i, entry := range history {
+			assert.Equal(t, i+1, entry.InstalledRank)
+			assert.True(t, entry.Success)
+		}
+		assert.Equal(t, "v20230104_seed_users_00004.sql", history[3].Filename)
+	}
+	assert.NoError(t, Validate(db, config))
+}
@@ -0,0 +1,49 @@
+package gosmm
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// PgxMigrationFunc is a migration written in Go that runs on the pgx connection holding the
+// migration transaction, giving access to pgx features such as CopyFrom.
+// It must not commit or rollback the transaction.
+type PgxMigrationFunc func(ctx context.Context, conn *pgx.Conn) error
+
+// PgxMigration adapts a PgxMigrationFunc to a GoMigrationFunc.
+// The migrations must be run over pgx, e.g. with MigratePgxPool or MigratePgxConn.
+func PgxMigration(fn PgxMigrationFunc) GoMigrationFunc {
+	return func(ctx context.Context, conn *sql.Conn, tx *sql.Tx) error {
+		return conn.Raw(func(driverConn interface{}) error {
+			pgxConn, ok := driverConn.(*stdlib.Conn)
+			if !ok {
+				return fmt.Errorf("pgx migration requires a pgx connection, got %T", driverConn)
+			}
+			return fn(ctx, pgxConn.Conn())
+		})
+	}
+}
+
+// MigratePgxPool executes the migrations over a pgx connection pool
+func MigratePgxPool(pool *pgxpool.Pool, config MigrationConfig) error {
+	db := stdlib.OpenDBFromPool(pool)
+	defer db.Close()
+
+	config.Driver = "postgres"
+	return MigrateWithConfig(db, config)
+}
+
+// MigratePgxConn executes the migrations over pgx using the connection settings of conn.
+// Since a migration run needs more than one connection, conn itself is not used.
+func MigratePgxConn(conn *pgx.Conn, config MigrationConfig) error {
+	db := stdlib.OpenDB(*conn.Config())
+	defer db.Close()
+
+	config.Driver = "postgres"
+	return MigrateWithConfig(db, config)
+}
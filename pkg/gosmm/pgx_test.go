@@ -0,0 +1,117 @@
+package gosmm
+
+import (
+	"context"
+	"database/sql"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrateWithGoMigration(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	// Create test_migrations directory if it doesn't exist
+	if _, err := os.Stat(migrationsDir); os.IsNotExist(err) {
+		err := os.Mkdir(migrationsDir, 0755)
+		if err != nil {
+			t.Fatalf("Failed to create test_migrations directory: %v", err)
+		}
+	}
+
+	// Create a test migration file in the test_migrations directory
+	testMigrationFile := filepath.Join(migrationsDir, "v20230101_create_test_data_00001.sql")
+	if err := ioutil.WriteFile(testMigrationFile, []byte("CREATE TABLE test_table (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	defer os.Remove(testMigrationFile)
+
+	config := MigrationConfig{
+		MigrationsDir: migrationsDir,
+		Driver:        "sqlite3",
+		GoMigrations: map[string]GoMigrationFunc{
+			"v20230101_seed_test_data_00002": func(ctx context.Context, conn *sql.Conn, tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx, "INSERT INTO test_table VALUES (1)")
+				return err
+			},
+		},
+	}
+	err := MigrateWithConfig(db, config)
+	assert.NoError(t, err)
+
+	// Check the Go migration ran after the migration file and was recorded
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM test_table").Scan(&count)
+	if err != nil {
+		t.Fatalf("Failed to query test_table: %v", err)
+	}
+	assert.Equal(t, 1, count)
+
+	var installedRank int
+	err = db.QueryRow("SELECT installed_rank FROM gosmm_migration_history WHERE filename = 'v20230101_seed_test_data_00002'").Scan(&installedRank)
+	if err != nil {
+		t.Fatalf("Failed to query gosmm_migration_history: %v", err)
+	}
+	assert.Equal(t, 2, installedRank)
+
+	// Running again applies nothing and does not report the Go migration as a missing file
+	err = MigrateWithConfig(db, config)
+	assert.NoError(t, err)
+	err = Validate(db, config)
+	assert.NoError(t, err)
+}
+
+func TestMigrateWithFailingGoMigration(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	// Create test_migrations directory if it doesn't exist
+	if _, err := os.Stat(migrationsDir); os.IsNotExist(err) {
+		err := os.Mkdir(migrationsDir, 0755)
+		if err != nil {
+			t.Fatalf("Failed to create test_migrations directory: %v", err)
+		}
+	}
+
+	config := MigrationConfig{
+		MigrationsDir: migrationsDir,
+		Driver:        "sqlite3",
+		GoMigrations: map[string]GoMigrationFunc{
+			"v20230101_seed_test_data_00001": func(ctx context.Context, conn *sql.Conn, tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx, "INSERT INTO missing_table VALUES (1)")
+				return err
+			},
+		},
+	}
+	err := MigrateWithConfig(db, config)
+	assert.Error(t, err)
+
+	// Check the failure was recorded
+	var success bool
+	err = db.QueryRow("SELECT success FROM gosmm_migration_history WHERE filename = 'v20230101_seed_test_data_00001'").Scan(&success)
+	if err != nil {
+		t.Fatalf("Failed to query gosmm_migration_history: %v", err)
+	}
+	assert.False(t, success)
+}
+
+func TestPgxMigrationWithoutPgxConnection(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	config := MigrationConfig{
+		MigrationsDir: t.TempDir(),
+		Driver:        "sqlite3",
+		GoMigrations: map[string]GoMigrationFunc{
+			"v20230101_seed_test_data_00001": PgxMigration(func(ctx context.Context, conn *pgx.Conn) error {
+				return nil
+			}),
+		},
+	}
+	err := MigrateWithConfig(db, config)
+	assert.ErrorContains(t, err, "pgx migration requires a pgx connection")
+}
@@ -0,0 +1,40 @@
+package gosmm
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// placeholderPattern matches ${NAME} placeholders in migration files
+var placeholderPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_.]*)\}`)
+
+// replacePlaceholders replaces ${NAME} placeholders in the given SQL with the configured values.
+// When no placeholders are configured the SQL is returned unchanged.
+func replacePlaceholders(sql string, placeholders map[string]string) (string, error) {
+	if len(placeholders) == 0 {
+		return sql, nil
+	}
+
+	missing := make(map[string]bool)
+	replaced := placeholderPattern.ReplaceAllStringFunc(sql, func(match string) string {
+		name := placeholderPattern.FindStringSubmatch(match)[1]
+		value, ok := placeholders[name]
+		if !ok {
+			missing[name] = true
+			return match
+		}
+		return value
+	})
+
+	if len(missing) > 0 {
+		names := make([]string, 0, len(missing))
+		for name := range missing {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return "", fmt.Errorf("no value provided for placeholder(s): %s", strings.Join(names, ", "))
+	}
+	return replaced, nil
+}
@@ -0,0 +1,73 @@
+package gosmm
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReplacePlaceholders(t *testing.T) {
+	replaced, err := replacePlaceholders("CREATE TABLE ${schema}.users (id INTEGER) TABLESPACE ${tablespace}", map[string]string{
+		"schema":     "tenant_a",
+		"tablespace": "fast_ssd",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "CREATE TABLE tenant_a.users (id INTEGER) TABLESPACE fast_ssd", replaced)
+}
+
+func TestReplacePlaceholdersWithMissingValue(t *testing.T) {
+	_, err := replacePlaceholders("CREATE TABLE ${schema}.${table} (id INTEGER)", map[string]string{
+		"schema": "tenant_a",
+	})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "table")
+}
+
+func TestReplacePlaceholdersWithoutPlaceholders(t *testing.T) {
+	replaced, err := replacePlaceholders("SELECT '${not_a_placeholder}'", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT '${not_a_placeholder}'", replaced)
+}
+
+func TestMigrateWithPlaceholders(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	// Create test_migrations directory if it doesn't exist
+	if _, err := os.Stat(migrationsDir); os.IsNotExist(err) {
+		err := os.Mkdir(migrationsDir, 0755)
+		if err != nil {
+			t.Fatalf("Failed to create test_migrations directory: %v", err)
+		}
+	}
+
+	// Create a test migration file using a placeholder in the test_migrations directory
+	testMigrationFile := filepath.Join(migrationsDir, "v20230101_create_test_data_00001.sql")
+	if err := ioutil.WriteFile(testMigrationFile, []byte("CREATE TABLE ${table_name} (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+
+	err := MigrateWithConfig(db, MigrationConfig{
+		MigrationsDir: migrationsDir,
+		Driver:        "sqlite3",
+		Placeholders:  map[string]string{"table_name": "placeholder_table"},
+	})
+	assert.NoError(t, err)
+
+	// Check placeholder_table exists
+	var exists bool
+	err = db.QueryRow("SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'placeholder_table')").Scan(&exists)
+	if err != nil {
+		t.Fatalf("Failed to check if placeholder_table exists: %v", err)
+	}
+	if !exists {
+		t.Fatalf("Failed to create placeholder_table")
+	}
+
+	// Delete the test migration file
+	if err := os.Remove(testMigrationFile); err != nil {
+		t.Fatalf("Failed to delete test migration file: %v", err)
+	}
+}
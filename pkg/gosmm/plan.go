@@ -0,0 +1,57 @@
+package gosmm
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// PlannedMigration is a pending migration of a Plan
+type PlannedMigration struct {
+	Filename string `json:"filename"`
+	// Statements is the number of statements of the file, zero for Go migrations
+	Statements int `json:"statements"`
+	// Go is set for migrations written in Go, whose statements cannot be counted
+	Go bool `json:"go,omitempty"`
+}
+
+// Plan returns the pending migrations in the order MigrateWithConfig would apply them, restricted to Labels,
+// with the number of statements of each file, so that a run can be previewed before it is executed. It never
+// modifies the database.
+func Plan(db *sql.DB, config MigrationConfig) ([]PlannedMigration, error) {
+	report, err := Status(db, config)
+	if err != nil {
+		return nil, err
+	}
+	files, err := config.migrationFiles()
+	if err != nil {
+		return nil, err
+	}
+	paths := make(map[string]string, len(files))
+	for _, file := range files {
+		if file.inEnvironment(config.Environment) {
+			paths[file.name] = file.path
+		}
+	}
+
+	plan := make([]PlannedMigration, 0, report.Pending)
+	for _, migration := range report.Migrations {
+		if migration.State != MigrationPending || !config.selects(migration.Labels) {
+			continue
+		}
+		if _, ok := config.GoMigrations[migration.Filename]; ok {
+			plan = append(plan, PlannedMigration{Filename: migration.Filename, Go: true})
+			continue
+		}
+		data, err := config.readMigrationFile(paths[migration.Filename])
+		if err != nil {
+			return nil, err
+		}
+		content, err := replacePlaceholders(string(data), config.Placeholders)
+		if err != nil {
+			return nil, fmt.Errorf("failed to replace placeholders in %s: %w", migration.Filename, err)
+		}
+		content, _ = splitDown(content) // the down section is only executed by Redo
+		plan = append(plan, PlannedMigration{Filename: migration.Filename, Statements: len(splitStatements(content, config.Driver))})
+	}
+	return plan, nil
+}
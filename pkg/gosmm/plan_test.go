@@ -0,0 +1,51 @@
+package gosmm
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlan(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}
+	assert.NoError(t, MigrateWithConfig(db, config))
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230102_add_columns_00002.sql"), []byte("ALTER TABLE users ADD COLUMN email TEXT;\nALTER TABLE users ADD COLUMN ${column} TEXT;"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	config.Placeholders = map[string]string{"column": "name"}
+	config.GoMigrations = map[string]GoMigrationFunc{
+		"v20230103_seed_users_00003": func(ctx context.Context, conn *sql.Conn, tx *sql.Tx) error {
+			return nil
+		},
+	}
+
+	plan, err := Plan(db, config)
+	assert.NoError(t, err)
+	assert.Equal(t, []PlannedMigration{
+		{Filename: "v20230102_add_columns_00002.sql", Statements: 2},
+		{Filename: "v20230103_seed_users_00003", Go: true},
+	}, plan)
+
+	// The plan does not modify the database
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM gosmm_migration_history").Scan(&count)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	// A missing placeholder fails the plan like it would fail the run
+	config.Placeholders = map[string]string{"schema": "public"}
+	_, err = Plan(db, config)
+	assert.Error(t, err)
+}
@@ -0,0 +1,382 @@
+//go:build integration
+
+package gosmm
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// StartTestPostgres starts the Postgres database of the tests, or connects to the one given by
+// GOSMM_TEST_POSTGRES_DSN. It is set by the TestMain of the gosmm_test package, since the gosmm package cannot
+// import gosmmtest.
+var StartTestPostgres func() (DBConfig, error)
+
+var (
+	postgresOnce sync.Once
+	postgresDSN  string
+	postgresErr  error
+)
+
+// postgresTestDSN returns the DSN of the Postgres database of the tests, started on first use
+func postgresTestDSN(t *testing.T) string {
+	postgresOnce.Do(func() {
+		config, err := StartTestPostgres()
+		if err != nil {
+			postgresErr = err
+			return
+		}
+		postgresDSN = config.DSN
+		if postgresDSN == "" {
+			postgresDSN, postgresErr = buildDSN(config)
+		}
+	})
+	if postgresErr != nil {
+		t.Fatalf("Failed to start Postgres: %v", postgresErr)
+	}
+	return postgresDSN
+}
+
+// setupPostgresDB connects to the Postgres database of the tests, a container unless GOSMM_TEST_POSTGRES_DSN
+// is set
+func setupPostgresDB(t *testing.T) (*sql.DB, func()) {
+	db, err := sql.Open("postgres", postgresTestDSN(t))
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+
+	return db, func() {
+		db.Close()
+	}
+}
+
+func TestPostgresMigrateWithSchema(t *testing.T) {
+	db, teardown := setupPostgresDB(t)
+	defer teardown()
+
+	schema := "gosmm_it_schema"
+	defer db.Exec(`DROP SCHEMA IF EXISTS ` + schema + ` CASCADE`)
+
+	dir := t.TempDir()
+	migrationFile := filepath.Join(dir, "v20230101_create_test_data_00001.sql")
+	migration := `
+CREATE TABLE test_table (id INTEGER, updated_at TIMESTAMP);
+CREATE FUNCTION touch() RETURNS trigger AS $$
+BEGIN
+	NEW.updated_at := now();
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+`
+	if err := ioutil.WriteFile(migrationFile, []byte(migration), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+
+	err := MigrateWithConfig(db, MigrationConfig{MigrationsDir: dir, Driver: "postgres", Schema: schema})
+	assert.NoError(t, err)
+
+	// Check the migration ran inside the configured schema
+	var exists bool
+	err = db.QueryRow(`SELECT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_schema = $1 AND table_name = 'test_table')`, schema).Scan(&exists)
+	if err != nil {
+		t.Fatalf("Failed to check if test_table exists: %v", err)
+	}
+	assert.True(t, exists)
+
+	// Check the history table uses the postgres column types
+	var dataType string
+	err = db.QueryRow(`SELECT data_type FROM information_schema.columns WHERE table_schema = $1 AND table_name = $2 AND column_name = 'installed_on'`, schema, migrationHistoryTable).Scan(&dataType)
+	if err != nil {
+		t.Fatalf("Failed to read history table columns: %v", err)
+	}
+	assert.Equal(t, "timestamp with time zone", dataType)
+
+	err = Validate(db, MigrationConfig{MigrationsDir: dir, Driver: "postgres", Schema: schema})
+	assert.NoError(t, err)
+
+	// Check the schema is inspected without the history table
+	objects, err := InspectSchema(db, MigrationConfig{Driver: "postgres", Schema: schema})
+	assert.NoError(t, err)
+	assert.Equal(t, []SchemaObject{{
+		Kind:       SchemaTable,
+		Name:       "test_table",
+		Table:      "test_table",
+		Definition: "CREATE TABLE test_table (\n    id integer,\n    updated_at timestamp without time zone\n)",
+	}}, objects)
+}
+
+func TestPostgresConcurrentMigrate(t *testing.T) {
+	db, teardown := setupPostgresDB(t)
+	defer teardown()
+
+	schema := "gosmm_it_concurrent"
+	defer db.Exec(`DROP SCHEMA IF EXISTS ` + schema + ` CASCADE`)
+
+	dir := t.TempDir()
+	for i, name := range []string{"v20230101_create_test_data_00001.sql", "v20230101_create_test_data_00002.sql"} {
+		content := []byte("CREATE TABLE test_table_" + string(rune('a'+i)) + " (id INTEGER);")
+		if err := ioutil.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+			t.Fatalf("Failed to create test migration file: %v", err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = MigrateWithConfig(db, MigrationConfig{MigrationsDir: dir, Driver: "postgres", Schema: schema})
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		assert.NoError(t, err)
+	}
+
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM ` + historyTableName("postgres", schema)).Scan(&count)
+	if err != nil {
+		t.Fatalf("Failed to count history rows: %v", err)
+	}
+	assert.Equal(t, 2, count)
+}
+
+func TestPostgresMigratePgxPool(t *testing.T) {
+	pool, err := pgxpool.New(context.Background(), postgresTestDSN(t))
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer pool.Close()
+
+	schema := "gosmm_it_pgx"
+	defer pool.Exec(context.Background(), `DROP SCHEMA IF EXISTS `+schema+` CASCADE`)
+
+	dir := t.TempDir()
+	migrationFile := filepath.Join(dir, "v20230101_create_test_data_00001.sql")
+	if err := ioutil.WriteFile(migrationFile, []byte("CREATE TABLE test_table (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+
+	err = MigratePgxPool(pool, MigrationConfig{
+		MigrationsDir: dir,
+		Schema:        schema,
+		GoMigrations: map[string]GoMigrationFunc{
+			"v20230101_seed_test_data_00002": PgxMigration(func(ctx context.Context, conn *pgx.Conn) error {
+				_, err := conn.CopyFrom(ctx, pgx.Identifier{"test_table"}, []string{"id"}, pgx.CopyFromRows([][]interface{}{{1}, {2}, {3}}))
+				return err
+			}),
+		},
+	})
+	assert.NoError(t, err)
+
+	// Check the rows copied by the Go migration were committed
+	var count int
+	err = pool.QueryRow(context.Background(), `SELECT COUNT(*) FROM `+schema+`.test_table`).Scan(&count)
+	if err != nil {
+		t.Fatalf("Failed to count test_table rows: %v", err)
+	}
+	assert.Equal(t, 3, count)
+}
+
+func TestPostgresClean(t *testing.T) {
+	db, teardown := setupPostgresDB(t)
+	defer teardown()
+
+	schema := "gosmm_it_clean"
+	defer db.Exec(`DROP SCHEMA IF EXISTS ` + schema + ` CASCADE`)
+
+	dir := t.TempDir()
+	migration := `
+CREATE TABLE parent (id SERIAL PRIMARY KEY);
+CREATE TABLE child (id INTEGER REFERENCES parent (id));
+CREATE VIEW child_view AS SELECT * FROM child;
+CREATE SEQUENCE counter;
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_test_data_00001.sql"), []byte(migration), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	config := MigrationConfig{MigrationsDir: dir, Driver: "postgres", Schema: schema, AllowClean: true}
+	err := MigrateWithConfig(db, config)
+	assert.NoError(t, err)
+
+	err = Clean(db, config)
+	assert.NoError(t, err)
+
+	var count int
+	err = db.QueryRow(`SELECT COUNT(*) FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace WHERE n.nspname = $1`, schema).Scan(&count)
+	if err != nil {
+		t.Fatalf("Failed to count schema objects: %v", err)
+	}
+	assert.Equal(t, 0, count)
+}
+
+func TestPostgresZeroDowntime(t *testing.T) {
+	db, teardown := setupPostgresDB(t)
+	defer teardown()
+
+	schema := "gosmm_it_zero_downtime"
+	defer db.Exec(`DROP SCHEMA IF EXISTS ` + schema + ` CASCADE`)
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER, age INTEGER);\nCREATE INDEX users_age ON users (age);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	config := MigrationConfig{MigrationsDir: dir, Driver: "postgres", Schema: schema, ZeroDowntime: true}
+	assert.NoError(t, MigrateWithConfig(db, config))
+
+	// Nothing is applied when a migration is unsafe
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230102_alter_age_00002.sql"), []byte("ALTER TABLE users ALTER COLUMN age TYPE BIGINT;"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	err := MigrateWithConfig(db, config)
+	assert.True(t, errors.Is(err, ErrUnsafeMigration))
+	report, err := Status(db, config)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Pending)
+}
+
+func TestPostgresLockTimeout(t *testing.T) {
+	db, teardown := setupPostgresDB(t)
+	defer teardown()
+
+	schema := "gosmm_it_lock_timeout"
+	defer db.Exec(`DROP SCHEMA IF EXISTS ` + schema + ` CASCADE`)
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	config := MigrationConfig{MigrationsDir: dir, Driver: "postgres", Schema: schema, LockTimeout: 100 * time.Millisecond, StatementTimeout: time.Minute}
+	assert.NoError(t, MigrateWithConfig(db, config))
+
+	// The application holds a lock on the table
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`LOCK TABLE ` + schema + `.users IN ACCESS SHARE MODE`); err != nil {
+		t.Fatalf("Failed to lock table: %v", err)
+	}
+
+	// The DDL fails fast instead of queueing behind it
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230102_add_email_00002.sql"), []byte("ALTER TABLE users ADD COLUMN email TEXT;"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	start := time.Now()
+	err = MigrateWithConfig(db, config)
+	assert.ErrorContains(t, err, "lock timeout")
+	assert.Less(t, time.Since(start), 10*time.Second)
+}
+
+func TestPostgresSchemaAt(t *testing.T) {
+	db, teardown := setupPostgresDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"v20230101_create_users_00001.sql": "CREATE TABLE users (id INTEGER PRIMARY KEY);",
+		"v20230102_add_email_00002.sql":    "ALTER TABLE users ADD COLUMN email TEXT;",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test migration file: %v", err)
+		}
+	}
+
+	var schema string
+	dbConfig := DBConfig{Driver: "postgres", DSN: postgresTestDSN(t)}
+	err := WithScratchDatabase(dbConfig, MigrationConfig{MigrationsDir: dir}, func(scratch *sql.DB, config MigrationConfig) error {
+		schema = config.Schema
+		var b bytes.Buffer
+		if err := SchemaAt(scratch, config, "v20230101_create_users_00001", &b); err != nil {
+			return err
+		}
+		assert.Contains(t, b.String(), "-- table: users\n")
+		assert.NotContains(t, b.String(), "email")
+		return nil
+	})
+	assert.NoError(t, err)
+
+	// The scratch schema was dropped
+	var exists bool
+	assert.NoError(t, db.QueryRow("SELECT EXISTS (SELECT 1 FROM information_schema.schemata WHERE schema_name = $1)", schema).Scan(&exists))
+	assert.False(t, exists)
+}
+
+func TestPostgresBulkLoad(t *testing.T) {
+	db, teardown := setupPostgresDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "countries.csv"), []byte("code,name\nJP,Japan\nFR,\"France, Republic of\"\nXX,\n"), 0644); err != nil {
+		t.Fatalf("Failed to create CSV file: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_countries_00001.sql"), []byte("CREATE TABLE countries (code TEXT, name TEXT);\n-- gosmm:load countries countries.csv\n"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+
+	// COPY row by row with lib/pq
+	schema := "gosmm_it_load"
+	defer db.Exec(`DROP SCHEMA IF EXISTS ` + schema + ` CASCADE`)
+	err := MigrateWithConfig(db, MigrationConfig{MigrationsDir: dir, Driver: "postgres", Schema: schema})
+	assert.NoError(t, err)
+	var count, nulls int
+	err = db.QueryRow(`SELECT COUNT(*), COUNT(*) FILTER (WHERE name IS NULL) FROM `+schema+`.countries`).Scan(&count, &nulls)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+	assert.Equal(t, 1, nulls)
+
+	// COPY of the CSV file over pgx
+	pool, err := pgxpool.New(context.Background(), postgresTestDSN(t))
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer pool.Close()
+	pgxSchema := "gosmm_it_load_pgx"
+	defer db.Exec(`DROP SCHEMA IF EXISTS ` + pgxSchema + ` CASCADE`)
+	err = MigratePgxPool(pool, MigrationConfig{MigrationsDir: dir, Schema: pgxSchema})
+	assert.NoError(t, err)
+	err = db.QueryRow(`SELECT COUNT(*), COUNT(*) FILTER (WHERE name IS NULL) FROM `+pgxSchema+`.countries`).Scan(&count, &nulls)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+	assert.Equal(t, 1, nulls)
+}
+
+func TestPostgresVerifyIndexes(t *testing.T) {
+	db, teardown := setupPostgresDB(t)
+	defer teardown()
+
+	schema := "gosmm_it_indexes"
+	defer db.Exec(`DROP SCHEMA IF EXISTS ` + schema + ` CASCADE`)
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (email TEXT);\nINSERT INTO users VALUES ('a@example.com'), ('a@example.com');"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	config := MigrationConfig{MigrationsDir: dir, Driver: "postgres", Schema: schema, VerifyIndexes: true}
+	assert.NoError(t, MigrateWithConfig(db, config))
+
+	// a failed concurrent build leaves an invalid index, which IF NOT EXISTS silently keeps
+	_, err := db.Exec(`CREATE UNIQUE INDEX CONCURRENTLY users_email ON ` + schema + `.users (email)`)
+	assert.Error(t, err)
+	migration := "-- gosmm:transactional false\nCREATE UNIQUE INDEX CONCURRENTLY IF NOT EXISTS users_email ON users (email);"
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230102_index_users_email_00002.sql"), []byte(migration), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	err = MigrateWithConfig(db, config)
+	assert.True(t, errors.Is(err, ErrInvalidIndex))
+	assert.Contains(t, err.Error(), "users_email")
+}
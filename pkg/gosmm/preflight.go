@@ -0,0 +1,235 @@
+package gosmm
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// preflightProbeTable is the table created and dropped to check the privileges of the user
+	preflightProbeTable = "gosmm_preflight_probe"
+	// minFreeSpace is the free space under which Preflight warns about a file system
+	minFreeSpace = 100 << 20
+)
+
+// CheckStatus is the outcome of a preflight check
+type CheckStatus string
+
+const (
+	// CheckPassed means the check succeeded
+	CheckPassed CheckStatus = "passed"
+	// CheckWarning means the check found a condition that may make the run fail
+	CheckWarning CheckStatus = "warning"
+	// CheckFailed means the run would fail
+	CheckFailed CheckStatus = "failed"
+	// CheckSkipped means the check could not be performed, e.g. free space on a remote database
+	CheckSkipped CheckStatus = "skipped"
+)
+
+// PreflightCheck is the result of a single preflight check
+type PreflightCheck struct {
+	Name    string      `json:"name"`
+	Status  CheckStatus `json:"status"`
+	Message string      `json:"message"`
+}
+
+// PreflightReport holds the results of the preflight checks, in the order they were performed
+type PreflightReport struct {
+	Checks []PreflightCheck `json:"checks"`
+}
+
+// Passed reports whether no check failed. Warnings and skipped checks do not fail the report.
+func (r PreflightReport) Passed() bool {
+	for _, check := range r.Checks {
+		if check.Status == CheckFailed {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns one line per check
+func (r PreflightReport) String() string {
+	var b strings.Builder
+	for _, check := range r.Checks {
+		fmt.Fprintf(&b, "%-8s %s", strings.ToUpper(string(check.Status)), check.Name)
+		if check.Message != "" {
+			fmt.Fprintf(&b, ": %s", check.Message)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// add appends a check to the report
+func (r *PreflightReport) add(name string, status CheckStatus, format string, args ...interface{}) {
+	r.Checks = append(r.Checks, PreflightCheck{Name: name, Status: status, Message: fmt.Sprintf(format, args...)})
+}
+
+// Preflight checks that a migration run can succeed before anything is executed: the database is reachable,
+// the migration directories exist, it is not a read replica, the user can create, alter and insert into tables and write to the
+// history table, and the file systems have free space where it can be detected.
+// The checks do not change the schema, except for a probe table that is dropped right away.
+// The error is only set when the checks cannot be performed at all; see PreflightReport.Passed.
+func Preflight(db *sql.DB, config MigrationConfig) (PreflightReport, error) {
+	var report PreflightReport
+	if !isSupportedDriver(config.Driver) {
+		return report, fmt.Errorf("unsupported driver: %s", config.Driver)
+	}
+
+	connected := checkConnectivity(db, &report)
+	checkMigrationDirs(config, &report)
+	if connected {
+		checkPrimary(db, config, &report)
+		checkProbeTable(db, config, &report)
+		checkHistoryTablePrivileges(db, config, &report)
+	}
+	checkFreeSpace("temp directory", os.TempDir(), &report)
+	if connected && config.Driver == "sqlite3" {
+		checkSQLiteFreeSpace(db, &report)
+	}
+	return report, nil
+}
+
+// checkConnectivity checks that the database is reachable
+func checkConnectivity(db *sql.DB, report *PreflightReport) bool {
+	if err := db.Ping(); err != nil {
+		report.add("connectivity", CheckFailed, "%v", err)
+		return false
+	}
+	report.add("connectivity", CheckPassed, "")
+	return true
+}
+
+// checkPrimary checks that the database is not a read replica
+func checkPrimary(db *sql.DB, config MigrationConfig, report *PreflightReport) {
+	cockroach, err := isCockroachDB(db, config.Driver)
+	if err != nil {
+		report.add("primary", CheckWarning, "failed to detect database version: %v", err)
+		return
+	}
+	reason, err := readOnlyReason(db, config.Driver, cockroach)
+	if err != nil {
+		report.add("primary", CheckWarning, "failed to check whether the database is read-only: %v", err)
+	} else if reason != "" {
+		report.add("primary", CheckFailed, "%s", reason)
+	} else {
+		report.add("primary", CheckPassed, "")
+	}
+}
+
+// checkMigrationDirs checks that the migration directories exist and their files can be read
+func checkMigrationDirs(config MigrationConfig, report *PreflightReport) {
+	for _, dir := range config.migrationDirs() {
+		info, err := os.Stat(dir)
+		if err != nil {
+			report.add("migrations directory", CheckFailed, "%v", err)
+			return
+		}
+		if !info.IsDir() {
+			report.add("migrations directory", CheckFailed, "%s is not a directory", dir)
+			return
+		}
+	}
+	files, err := config.migrationFiles()
+	if err != nil {
+		report.add("migrations directory", CheckFailed, "%v", err)
+		return
+	}
+	report.add("migrations directory", CheckPassed, "%d migration file(s) in %s", len(files), strings.Join(config.migrationDirs(), ", "))
+}
+
+// checkProbeTable checks the CREATE, ALTER and INSERT privileges by creating a probe table next to
+// the history table, altering it, inserting a row and dropping it
+func checkProbeTable(db *sql.DB, config MigrationConfig, report *PreflightReport) {
+	table := preflightProbeTable
+	if config.Schema != "" {
+		table = quoteIdentifier(config.Driver, config.Schema) + "." + preflightProbeTable
+	}
+	// a probe table left by an interrupted check is dropped first
+	if _, err := db.Exec("DROP TABLE IF EXISTS " + table); err != nil {
+		report.add("create/alter/insert privileges", CheckFailed, "failed to drop %s: %v", table, err)
+		return
+	}
+	if _, err := db.Exec("CREATE TABLE " + table + " (id INTEGER)"); err != nil {
+		report.add("create/alter/insert privileges", CheckFailed, "CREATE TABLE: %v", err)
+		return
+	}
+	defer db.Exec("DROP TABLE " + table)
+
+	for _, statement := range []struct{ privilege, sql string }{
+		{"ALTER TABLE", "ALTER TABLE " + table + " ADD note VARCHAR(10)"},
+		{"INSERT", "INSERT INTO " + table + " (id, note) VALUES (1, 'gosmm')"},
+	} {
+		if _, err := db.Exec(statement.sql); err != nil {
+			report.add("create/alter/insert privileges", CheckFailed, "%s: %v", statement.privilege, err)
+			return
+		}
+	}
+	if _, err := db.Exec("DROP TABLE " + table); err != nil {
+		report.add("create/alter/insert privileges", CheckWarning, "failed to drop %s: %v", table, err)
+		return
+	}
+	report.add("create/alter/insert privileges", CheckPassed, "")
+}
+
+// checkHistoryTablePrivileges checks that the rows of an existing history table can be inserted,
+// updated and deleted, with statements that match no row
+func checkHistoryTablePrivileges(db *sql.DB, config MigrationConfig, report *PreflightReport) {
+	exists, err := historyTableExists(db, config.Driver, config.Schema)
+	if err != nil {
+		report.add("history table privileges", CheckFailed, "failed to check history table: %v", err)
+		return
+	}
+	if !exists {
+		report.add("history table privileges", CheckSkipped, "the history table does not exist yet and will be created")
+		return
+	}
+
+	table := historyTableName(config.Driver, config.Schema)
+	for _, statement := range []struct{ privilege, sql string }{
+		{"SELECT", "SELECT COUNT(*) FROM " + table},
+		{"INSERT", "INSERT INTO " + table + " SELECT * FROM " + table + " WHERE 1 = 0"},
+		{"UPDATE", "UPDATE " + table + " SET filename = filename WHERE 1 = 0"},
+		{"DELETE", "DELETE FROM " + table + " WHERE 1 = 0"},
+	} {
+		if _, err := db.Exec(statement.sql); err != nil {
+			report.add("history table privileges", CheckFailed, "%s on %s: %v", statement.privilege, table, err)
+			return
+		}
+	}
+	report.add("history table privileges", CheckPassed, "")
+}
+
+// checkSQLiteFreeSpace checks the free space of the file system holding the SQLite database file
+func checkSQLiteFreeSpace(db *sql.DB, report *PreflightReport) {
+	var seq int
+	var name, file string
+	if err := db.QueryRow("PRAGMA database_list").Scan(&seq, &name, &file); err != nil {
+		report.add("database free space", CheckSkipped, "%v", err)
+		return
+	}
+	if file == "" {
+		report.add("database free space", CheckSkipped, "in-memory database")
+		return
+	}
+	checkFreeSpace("database free space", filepath.Dir(file), report)
+}
+
+// checkFreeSpace warns when the file system holding path has less than minFreeSpace available
+func checkFreeSpace(name string, path string, report *PreflightReport) {
+	available, ok, err := freeSpace(path)
+	switch {
+	case err != nil:
+		report.add(name, CheckWarning, "failed to get free space of %s: %v", path, err)
+	case !ok:
+		report.add(name, CheckSkipped, "free space cannot be detected on this platform")
+	case available < minFreeSpace:
+		report.add(name, CheckWarning, "only %d MiB available in %s", available>>20, path)
+	default:
+		report.add(name, CheckPassed, "%d MiB available in %s", available>>20, path)
+	}
+}
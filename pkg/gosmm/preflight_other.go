@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package gosmm
+
+// freeSpace reports that the free space cannot be detected on this platform
+func freeSpace(string) (uint64, bool, error) {
+	return 0, false, nil
+}
@@ -0,0 +1,79 @@
+package gosmm
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// checkStatuses returns the status of each check of report by name
+func checkStatuses(report PreflightReport) map[string]CheckStatus {
+	statuses := make(map[string]CheckStatus)
+	for _, check := range report.Checks {
+		statuses[check.Name] = check.Status
+	}
+	return statuses
+}
+
+func TestPreflight(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}
+
+	report, err := Preflight(db, config)
+	assert.NoError(t, err)
+	assert.True(t, report.Passed(), report.String())
+	statuses := checkStatuses(report)
+	assert.Equal(t, CheckPassed, statuses["connectivity"])
+	assert.Equal(t, CheckPassed, statuses["migrations directory"])
+	assert.Equal(t, CheckPassed, statuses["primary"])
+	assert.Equal(t, CheckPassed, statuses["create/alter/insert privileges"])
+	assert.Equal(t, CheckSkipped, statuses["history table privileges"])
+	assert.Contains(t, []CheckStatus{CheckPassed, CheckWarning, CheckSkipped}, statuses["database free space"])
+
+	// The probe table was dropped
+	exists := true
+	err = db.QueryRow("SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE name = 'gosmm_preflight_probe')").Scan(&exists)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	// Once the history table exists, its privileges are checked
+	assert.NoError(t, MigrateWithConfig(db, config))
+	report, err = Preflight(db, config)
+	assert.NoError(t, err)
+	assert.Equal(t, CheckPassed, checkStatuses(report)["history table privileges"])
+}
+
+func TestPreflightFailures(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	report, err := Preflight(db, MigrationConfig{MigrationsDir: filepath.Join(t.TempDir(), "missing"), Driver: "sqlite3"})
+	assert.NoError(t, err)
+	assert.False(t, report.Passed())
+	assert.Equal(t, CheckFailed, checkStatuses(report)["migrations directory"])
+	assert.Contains(t, report.String(), "FAILED   migrations directory")
+
+	// The database checks are skipped when it is not reachable
+	db.Close()
+	report, err = Preflight(db, MigrationConfig{MigrationsDir: t.TempDir(), Driver: "sqlite3"})
+	assert.NoError(t, err)
+	assert.False(t, report.Passed())
+	statuses := checkStatuses(report)
+	assert.Equal(t, CheckFailed, statuses["connectivity"])
+	assert.NotContains(t, statuses, "create/alter/insert privileges")
+
+	_, err = Preflight(db, MigrationConfig{Driver: "db2"})
+	assert.Error(t, err)
+}
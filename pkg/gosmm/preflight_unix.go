@@ -0,0 +1,14 @@
+//go:build linux || darwin
+
+package gosmm
+
+import "syscall"
+
+// freeSpace returns the bytes available to the user on the file system holding path
+func freeSpace(path string) (uint64, bool, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, true, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), true, nil
+}
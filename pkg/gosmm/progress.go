@@ -0,0 +1,56 @@
+package gosmm
+
+import "time"
+
+// EventKind identifies a progress event emitted during a migration run
+type EventKind string
+
+const (
+	// EventMigrationStarted is emitted before a migration is executed
+	EventMigrationStarted EventKind = "migration_started"
+	// EventStatementExecuted is emitted after each statement of a migration file has been executed
+	EventStatementExecuted EventKind = "statement_executed"
+	// EventMigrationFinished is emitted after a migration has been executed and recorded
+	EventMigrationFinished EventKind = "migration_finished"
+	// EventMigrationFailed is emitted when a migration fails
+	EventMigrationFailed EventKind = "migration_failed"
+)
+
+// Event describes the progress of a migration run
+type Event struct {
+	Kind      EventKind
+	Migration MigrationInfo
+	// Index is the 1-based position of the migration among the pending migrations
+	Index int
+	// Total is the number of pending migrations
+	Total int
+	// StatementIndex is the 1-based index of the executed statement (EventStatementExecuted only)
+	StatementIndex int
+	// StatementCount is the number of statements in the migration file (EventStatementExecuted only), zero when
+	// the file is streamed, see MigrationConfig.StreamThreshold
+	StatementCount int
+	// Statement is the executed statement (EventStatementExecuted only)
+	Statement string
+	// Duration is how long the statement or the migration took
+	Duration time.Duration
+	// RowsAffected is the number of rows affected by the statement when the driver reports it (EventStatementExecuted only)
+	RowsAffected int64
+	// BytesRead and FileSize are the number of bytes of a streamed migration file read so far and its size
+	// (EventStatementExecuted only)
+	BytesRead int64
+	FileSize  int64
+	// Err is the error the migration failed with (EventMigrationFailed only)
+	Err error
+	// Target is the name of the target of MigrateAll the event is of, empty for the other runs
+	Target string
+}
+
+// ProgressFunc receives progress events. It is called synchronously from the migration run,
+// so it should return quickly, e.g. by sending the event to a buffered channel.
+type ProgressFunc func(event Event)
+
+func (f ProgressFunc) emit(event Event) {
+	if f != nil {
+		f(event)
+	}
+}
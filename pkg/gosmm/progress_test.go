@@ -0,0 +1,74 @@
+package gosmm
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrateWithProgress(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	// Create test_migrations directory if it doesn't exist
+	if _, err := os.Stat(migrationsDir); os.IsNotExist(err) {
+		err := os.Mkdir(migrationsDir, 0755)
+		if err != nil {
+			t.Fatalf("Failed to create test_migrations directory: %v", err)
+		}
+	}
+
+	// Create test migration files in the test_migrations directory
+	testMigrationFile1 := filepath.Join(migrationsDir, "v20230101_create_test_data_00001.sql")
+	if err := ioutil.WriteFile(testMigrationFile1, []byte("CREATE TABLE test_table (id INTEGER); INSERT INTO test_table VALUES (1);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	testMigrationFile2 := filepath.Join(migrationsDir, "v20230101_create_test_data_00002.sql")
+	if err := ioutil.WriteFile(testMigrationFile2, []byte("INSERT INTO missing_table VALUES (1);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+
+	events := make(chan Event, 16)
+	err := MigrateWithConfig(db, MigrationConfig{
+		MigrationsDir: migrationsDir,
+		Driver:        "sqlite3",
+		Progress: func(event Event) {
+			events <- event
+		},
+	})
+	assert.Error(t, err)
+	close(events)
+
+	var kinds []EventKind
+	var statements []int
+	for event := range events {
+		kinds = append(kinds, event.Kind)
+		assert.Equal(t, 2, event.Total)
+		if event.Kind == EventStatementExecuted {
+			statements = append(statements, event.StatementIndex)
+			assert.Equal(t, 2, event.StatementCount)
+		}
+		if event.Kind == EventMigrationFailed {
+			assert.Equal(t, 2, event.Index)
+			assert.Error(t, event.Err)
+		}
+	}
+	assert.Equal(t, []EventKind{
+		EventMigrationStarted,
+		EventStatementExecuted,
+		EventStatementExecuted,
+		EventMigrationFinished,
+		EventMigrationStarted,
+		EventMigrationFailed,
+	}, kinds)
+	assert.Equal(t, []int{1, 2}, statements)
+
+	// Delete the test migration files
+	for _, file := range []string{testMigrationFile1, testMigrationFile2} {
+		if err := os.Remove(file); err != nil {
+			t.Fatalf("Failed to delete test migration file: %v", err)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package gosmm
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// historyPrunedTable records the prunes of the history table, see Prune
+	historyPrunedTable = "gosmm_migration_history_pruned"
+	// pruneDeleteBatch is the number of history rows deleted by a statement of Prune
+	pruneDeleteBatch = 500
+)
+
+// PruneOptions configures Prune
+type PruneOptions struct {
+	// Keep is the number of latest applied migrations whose history rows are kept, at least 1
+	Keep int
+	// Archive is the file the pruned rows are written to before they are deleted, gzip-compressed, as CSV when it
+	// ends with .csv.gz and as JSON otherwise. It must not exist.
+	Archive string
+}
+
+// PruneResult is the outcome of Prune
+type PruneResult struct {
+	// Pruned is the number of history rows deleted
+	Pruned int `json:"pruned"`
+	// PrunedThrough is the latest pruned migration, empty when none was pruned
+	PrunedThrough string `json:"pruned_through,omitempty"`
+	// Archive is the file holding the pruned rows, empty when none was pruned
+	Archive string `json:"archive,omitempty"`
+}
+
+// Prune deletes the history rows of the applied migrations but the latest opts.Keep, in the order the migrations
+// are applied in, after writing them to opts.Archive, so that the history of a database with many migrations
+// stays small. The latest pruned migration is recorded in the gosmm_migration_history_pruned table, and the
+// migrations sorting up to it without a history row are considered applied from then on, so every known
+// migration sorting before it must be applied. A database with a failed migration is not pruned.
+// The dialect drivers and HistoryStore are not supported.
+func Prune(db *sql.DB, config MigrationConfig, opts PruneOptions) (PruneResult, error) {
+	if config.HistoryStore != nil || dialectFor(config.Driver) != nil || !isSupportedDriver(config.Driver) {
+		return PruneResult{}, fmt.Errorf("pruning the history is not supported by the %s driver", config.Driver)
+	}
+	if opts.Keep < 1 {
+		return PruneResult{}, fmt.Errorf("invalid keep %d, at least one migration must be kept", opts.Keep)
+	}
+	if opts.Archive == "" {
+		return PruneResult{}, fmt.Errorf("missing archive of the pruned history")
+	}
+	naming, err := config.naming()
+	if err != nil {
+		return PruneResult{}, err
+	}
+	table := historyTableName(config.Driver, config.Schema)
+
+	cockroach, err := isCockroachDB(db, config.Driver)
+	if err != nil {
+		return PruneResult{}, fmt.Errorf("failed to detect database version: %w", err)
+	}
+	unlock, err := lockRun(context.Background(), db, config, table, cockroach, nil)
+	if err != nil {
+		return PruneResult{}, fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+	defer unlock()
+
+	history, err := GetHistory(db, config)
+	if err != nil {
+		return PruneResult{}, err
+	}
+	applied := make(map[string]bool, len(history))
+	for _, entry := range history {
+		if !entry.Success {
+			return PruneResult{}, ErrDirtyState
+		}
+		applied[entry.Filename] = true
+	}
+	if len(history) <= opts.Keep {
+		return PruneResult{}, nil
+	}
+	sort.SliceStable(history, func(i, j int) bool {
+		return naming.less(history[i].Filename, history[j].Filename)
+	})
+	pruned := history[:len(history)-opts.Keep]
+	through := pruned[len(pruned)-1].Filename
+
+	// the migrations up to the pruned ones must be applied, as they are considered applied once pruned
+	previous, err := prunedThrough(db, config, naming)
+	if err != nil {
+		return PruneResult{}, err
+	}
+	files, err := config.migrationFiles()
+	if err != nil {
+		return PruneResult{}, err
+	}
+	names := make([]string, 0, len(files)+len(config.GoMigrations))
+	for _, file := range files {
+		if file.inEnvironment(config.Environment) {
+			names = append(names, file.name)
+		}
+	}
+	for name := range config.GoMigrations {
+		names = append(names, name)
+	}
+	for _, name := range names {
+		if !naming.less(through, name) && !applied[name] && !pruneCovers(naming, previous, name) {
+			return PruneResult{}, fmt.Errorf("migration %s sorts before the pruned migration %s and is not applied, apply it before pruning", name, through)
+		}
+	}
+
+	if err := writeHistoryArchive(opts.Archive, pruned); err != nil {
+		return PruneResult{}, err
+	}
+	if err := deletePrunedHistory(db, config, table, pruned, through, opts.Archive); err != nil {
+		return PruneResult{}, fmt.Errorf("%w, the pruned rows are archived in %s", err, opts.Archive)
+	}
+	config.LogLevel.printf(LogInfo, "Pruned %d history row(s) through %s, archived in %s\n", len(pruned), through, opts.Archive)
+	return PruneResult{Pruned: len(pruned), PrunedThrough: through, Archive: opts.Archive}, nil
+}
+
+// pruneCovers reports whether the migration name is considered applied after a prune through the migration
+// through, empty when the history was never pruned
+func pruneCovers(naming migrationNaming, through string, name string) bool {
+	return through != "" && !naming.less(through, name)
+}
+
+// prunedThrough returns the latest migration pruned from the history table, empty when it was never pruned
+func prunedThrough(db *sql.DB, config MigrationConfig, naming migrationNaming) (string, error) {
+	if config.HistoryStore != nil || dialectFor(config.Driver) != nil {
+		return "", nil
+	}
+	table := historyPrunedTableName(config.Driver, config.Schema)
+	if !historyColumnExists(db, table, "pruned_through") {
+		return "", nil
+	}
+	rows, err := db.Query(`SELECT pruned_through FROM ` + table)
+	if err != nil {
+		return "", fmt.Errorf("failed to read pruned history: %w", err)
+	}
+	defer rows.Close()
+	var through string
+	for rows.Next() {
+		var filename string
+		if err := rows.Scan(&filename); err != nil {
+			return "", fmt.Errorf("failed to read pruned history: %w", err)
+		}
+		if through == "" || naming.less(through, filename) {
+			through = filename
+		}
+	}
+	return through, rows.Err()
+}
+
+// writeHistoryArchive writes the history entries to the gzip-compressed file path, replacing the file only once
+// the archive is complete
+func writeHistoryArchive(path string, history []HistoryEntry) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("archive %s already exists", path)
+	}
+	format := HistoryFormatJSON
+	if strings.HasSuffix(strings.ToLower(path), ".csv.gz") {
+		format = HistoryFormatCSV
+	}
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("failed to create archive: %w", err)
+	}
+	compressed := gzip.NewWriter(file)
+	err = writeHistory(compressed, history, format)
+	if closeErr := compressed.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	return nil
+}
+
+// deletePrunedHistory deletes the history rows of the pruned migrations and records the prune, in one transaction
+func deletePrunedHistory(db *sql.DB, config MigrationConfig, table string, pruned []HistoryEntry, through string, archive string) error {
+	prunedTable := historyPrunedTableName(config.Driver, config.Schema)
+	if _, err := db.Exec(historyPrunedTableDDL(config.Driver, prunedTable)); err != nil {
+		return fmt.Errorf("failed to create pruned history table: %w", err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	for start := 0; start < len(pruned); start += pruneDeleteBatch {
+		batch := pruned[start:]
+		if len(batch) > pruneDeleteBatch {
+			batch = batch[:pruneDeleteBatch]
+		}
+		args := make([]interface{}, len(batch))
+		for i, entry := range batch {
+			args[i] = entry.Filename
+		}
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE filename IN (`+bindParams(config.Driver, len(batch))+`)`, args...); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to delete history rows: %w", err)
+		}
+	}
+	query := `INSERT INTO ` + prunedTable + ` (pruned_through, pruned_rows, archive, pruned_on) VALUES (` + bindParams(config.Driver, 4) + `)`
+	if _, err := tx.Exec(query, through, len(pruned), archive, time.Now().UTC()); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to record prune: %w", err)
+	}
+	return tx.Commit()
+}
+
+// historyPrunedTableName returns the pruned history table name, qualified with the schema if one is given
+func historyPrunedTableName(driver string, schema string) string {
+	if schema == "" {
+		return historyPrunedTable
+	}
+	return quoteIdentifier(driver, schema) + "." + historyPrunedTable
+}
+
+// historyPrunedTableDDL returns the statement creating the table recording the prunes of the history table for
+// the given driver
+func historyPrunedTableDDL(driver string, table string) string {
+	switch driver {
+	case "postgres":
+		return `CREATE TABLE IF NOT EXISTS ` + table + ` (
+			pruned_through VARCHAR(255) NOT NULL,
+			pruned_rows INTEGER NOT NULL,
+			archive VARCHAR(1000) NOT NULL,
+			pruned_on TIMESTAMP WITH TIME ZONE NOT NULL
+		)`
+	case "mysql":
+		return `CREATE TABLE IF NOT EXISTS ` + table + ` (
+			pruned_through VARCHAR(255) NOT NULL,
+			pruned_rows INT NOT NULL,
+			archive VARCHAR(1000) NOT NULL,
+			pruned_on DATETIME(3) NOT NULL
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`
+	case "sqlserver":
+		return `IF OBJECT_ID(N'` + strings.ReplaceAll(table, "'", "''") + `', N'U') IS NULL
+		CREATE TABLE ` + table + ` (
+			pruned_through NVARCHAR(255) NOT NULL,
+			pruned_rows INT NOT NULL,
+			archive NVARCHAR(1000) NOT NULL,
+			pruned_on DATETIME2(3) NOT NULL
+		)`
+	default:
+		return `CREATE TABLE IF NOT EXISTS ` + table + ` (
+			pruned_through VARCHAR(255) NOT NULL,
+			pruned_rows INTEGER NOT NULL,
+			archive VARCHAR(1000) NOT NULL,
+			pruned_on TIMESTAMP NOT NULL
+		)`
+	}
+}
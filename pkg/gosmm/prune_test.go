@@ -0,0 +1,105 @@
+package gosmm
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrune(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"v20230101_create_users_00001.sql":  "CREATE TABLE users (id INTEGER);",
+		"v20230102_add_email_00002.sql":     "ALTER TABLE users ADD COLUMN email TEXT;",
+		"v20230103_create_orders_00003.sql": "CREATE TABLE orders (id INTEGER);",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create migration file: %v", err)
+		}
+	}
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}
+	assert.NoError(t, MigrateWithConfig(db, config))
+
+	archive := filepath.Join(t.TempDir(), "history.json.gz")
+	result, err := Prune(db, config, PruneOptions{Keep: 1, Archive: archive})
+	assert.NoError(t, err)
+	assert.Equal(t, PruneResult{Pruned: 2, PrunedThrough: "v20230102_add_email_00002.sql", Archive: archive}, result)
+
+	history, err := GetHistory(db, config)
+	assert.NoError(t, err)
+	assert.Len(t, history, 1)
+	assert.Equal(t, "v20230103_create_orders_00003.sql", history[0].Filename)
+
+	// the pruned rows are archived
+	file, err := os.Open(archive)
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	defer file.Close()
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	var archived []HistoryEntry
+	assert.NoError(t, json.NewDecoder(reader).Decode(&archived))
+	assert.Len(t, archived, 2)
+	assert.Equal(t, "v20230101_create_users_00001.sql", archived[0].Filename)
+
+	// the pruned migrations stay applied
+	report, err := Status(db, config)
+	assert.NoError(t, err)
+	assert.Equal(t, StateUpToDate, report.State)
+	assert.Equal(t, 3, report.Applied)
+	assert.True(t, report.Migrations[0].Pruned)
+	assert.False(t, report.Migrations[2].Pruned)
+	assert.NoError(t, MigrateWithConfig(db, config))
+
+	// an existing archive is not overwritten
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230104_create_items_00004.sql"), []byte("CREATE TABLE items (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create migration file: %v", err)
+	}
+	assert.NoError(t, MigrateWithConfig(db, config))
+	_, err = Prune(db, config, PruneOptions{Keep: 1, Archive: archive})
+	assert.EqualError(t, err, "archive "+archive+" already exists")
+
+	result, err = Prune(db, config, PruneOptions{Keep: 5, Archive: archive})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.Pruned)
+}
+
+func TestPruneUnappliedMigration(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create migration file: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230103_create_orders_00003.sql"), []byte("CREATE TABLE orders (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create migration file: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230104_create_items_00004.sql"), []byte("CREATE TABLE items (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create migration file: %v", err)
+	}
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}
+	assert.NoError(t, MigrateWithConfig(db, config))
+
+	// a migration skipped by an out-of-order deploy would be considered applied once pruned
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230102_add_email_00002.sql"), []byte("ALTER TABLE users ADD COLUMN email TEXT;"), 0644); err != nil {
+		t.Fatalf("Failed to create migration file: %v", err)
+	}
+	archive := filepath.Join(t.TempDir(), "history.csv.gz")
+	_, err := Prune(db, config, PruneOptions{Keep: 0, Archive: archive})
+	assert.EqualError(t, err, "invalid keep 0, at least one migration must be kept")
+	_, err = Prune(db, config, PruneOptions{Keep: 1, Archive: archive})
+	assert.EqualError(t, err, "migration v20230102_add_email_00002.sql sorts before the pruned migration v20230103_create_orders_00003.sql and is not applied, apply it before pruning")
+}
@@ -0,0 +1,68 @@
+package gosmm
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// readOnlyReason returns why the connected node cannot apply migrations, e.g. a read replica handed out by a
+// load balancer, or "" when it accepts writes
+func readOnlyReason(db *sql.DB, driver string, cockroach bool) (string, error) {
+	switch driver {
+	case "postgres":
+		// CockroachDB has no standbys
+		if !cockroach {
+			var recovery bool
+			if err := db.QueryRow(`SELECT pg_is_in_recovery()`).Scan(&recovery); err != nil {
+				return "", err
+			}
+			if recovery {
+				return "the server is a standby (pg_is_in_recovery() is true)", nil
+			}
+		}
+		var readOnly string
+		if err := db.QueryRow(`SHOW transaction_read_only`).Scan(&readOnly); err != nil {
+			return "", err
+		}
+		if readOnly == "on" {
+			return "transactions are read-only (transaction_read_only is on)", nil
+		}
+	case "mysql":
+		var readOnly bool
+		if err := db.QueryRow(`SELECT @@read_only`).Scan(&readOnly); err != nil {
+			return "", err
+		}
+		if readOnly {
+			return "the server is read-only (@@read_only is 1)", nil
+		}
+	case "sqlserver":
+		var updateability string
+		if err := db.QueryRow(`SELECT CAST(DATABASEPROPERTYEX(DB_NAME(), 'Updateability') AS NVARCHAR(128))`).Scan(&updateability); err != nil {
+			return "", err
+		}
+		if updateability == "READ_ONLY" {
+			return "the database is read-only (Updateability is READ_ONLY)", nil
+		}
+	case "sqlite3":
+		var queryOnly bool
+		if err := db.QueryRow(`PRAGMA query_only`).Scan(&queryOnly); err != nil {
+			return "", err
+		}
+		if queryOnly {
+			return "the connection is read-only (query_only is on)", nil
+		}
+	}
+	return "", nil
+}
+
+// checkWritable returns ErrReadOnlyDatabase when the connected node cannot apply migrations
+func checkWritable(db *sql.DB, driver string, cockroach bool) error {
+	reason, err := readOnlyReason(db, driver, cockroach)
+	if err != nil {
+		return fmt.Errorf("failed to check whether the database is read-only: %w", err)
+	}
+	if reason != "" {
+		return fmt.Errorf("%w: %s", ErrReadOnlyDatabase, reason)
+	}
+	return nil
+}
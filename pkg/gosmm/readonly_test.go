@@ -0,0 +1,42 @@
+package gosmm
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrateReadOnlyDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := sql.Open("sqlite3", "file:"+path+"?_query_only=true")
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}
+
+	err = MigrateWithConfig(db, config)
+	assert.ErrorIs(t, err, ErrReadOnlyDatabase)
+	assert.Contains(t, err.Error(), "query_only")
+
+	report, err := Preflight(db, config)
+	assert.NoError(t, err)
+	assert.False(t, report.Passed())
+	assert.Equal(t, CheckFailed, checkStatuses(report)["primary"])
+
+	// A writable connection to the same database migrates it
+	writable, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer writable.Close()
+	assert.NoError(t, MigrateWithConfig(writable, config))
+}
@@ -0,0 +1,26 @@
+package gosmm
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Ready reports whether every known migration is applied, e.g. for the readiness probe of an application
+// that must not serve traffic against an outdated schema. It returns ErrDirtyState when a migration failed,
+// an error wrapping ErrPendingMigrations when migrations were not applied, and nil otherwise. Migrations
+// applied by a newer release of the application are ignored, so that the pods of the previous release stay
+// ready during a rolling update. Like Status, it never modifies the database.
+func Ready(db *sql.DB, config MigrationConfig) error {
+	report, err := Status(db, config)
+	if err != nil {
+		return err
+	}
+	switch report.State {
+	case StateDirty:
+		return ErrDirtyState
+	case StatePending:
+		return fmt.Errorf("%w: %d migration(s) not applied", ErrPendingMigrations, report.Pending)
+	default:
+		return nil
+	}
+}
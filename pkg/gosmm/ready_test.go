@@ -0,0 +1,52 @@
+package gosmm
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReady(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}
+
+	// Not ready before the history table exists
+	err := Ready(db, config)
+	assert.True(t, errors.Is(err, ErrPendingMigrations))
+	assert.EqualError(t, err, "pending migrations: 1 migration(s) not applied")
+
+	err = MigrateWithConfig(db, config)
+	assert.NoError(t, err)
+	assert.NoError(t, Ready(db, config))
+
+	// A failed migration makes the database dirty
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230102_add_email_00002.sql"), []byte("ALTER TABLE missing_table ADD COLUMN email TEXT;"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	err = MigrateWithConfig(db, config)
+	assert.Error(t, err)
+	assert.True(t, errors.Is(Ready(db, config), ErrDirtyState))
+
+	// Migrations applied by a newer release do not make the previous one unready
+	_, err = db.Exec("DELETE FROM gosmm_migration_history WHERE success = false")
+	assert.NoError(t, err)
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230102_add_email_00002.sql"), []byte("ALTER TABLE users ADD COLUMN email TEXT;"), 0644); err != nil {
+		t.Fatalf("Failed to fix test migration file: %v", err)
+	}
+	err = MigrateWithConfig(db, config)
+	assert.NoError(t, err)
+	if err := os.Remove(filepath.Join(dir, "v20230102_add_email_00002.sql")); err != nil {
+		t.Fatalf("Failed to remove test migration file: %v", err)
+	}
+	assert.NoError(t, Ready(db, config))
+}
@@ -0,0 +1,188 @@
+package gosmm
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// downMarker starts the section of a migration file rolling it back, which is only executed by Redo
+const downMarker = "-- gosmm:down"
+
+// splitDown returns the content of a migration file before and after its "-- gosmm:down" line,
+// the whole content and an empty down section when there is none
+func splitDown(content string) (up string, down string) {
+	offset := 0
+	for _, line := range strings.SplitAfter(content, "\n") {
+		if strings.TrimSpace(line) == downMarker {
+			return content[:offset], content[offset+len(line):]
+		}
+		offset += len(line)
+	}
+	return content, ""
+}
+
+// Redo rolls back the most recently applied migration with the "-- gosmm:down" section of its file, then applies
+// it again, e.g. while iterating on a migration locally. The section is read from the current file, so an edited
+// migration is rolled back and applied in its new version. Redo refuses to run on a dirty database and while
+// migrations are pending, so that only the rolled back migration is applied.
+func Redo(db *sql.DB, config MigrationConfig) error {
+	return RedoWithContext(context.Background(), db, config)
+}
+
+// RedoWithContext is Redo with a context cancelling the rollback and the run
+func RedoWithContext(ctx context.Context, db *sql.DB, config MigrationConfig) error {
+	if !isSupportedDriver(config.Driver) {
+		return fmt.Errorf("unsupported driver: %s", config.Driver)
+	}
+	report, err := Status(db, config)
+	if err != nil {
+		return err
+	}
+	if report.Failed > 0 {
+		return ErrDirtyState
+	}
+	if report.Pending > 0 {
+		return fmt.Errorf("%d migration(s) pending, apply them before redoing the last one", report.Pending)
+	}
+
+	filename, err := lastAppliedMigration(db, config)
+	if err != nil {
+		return err
+	}
+	if filename == "" {
+		return fmt.Errorf("no applied migration to redo")
+	}
+	if err := rollbackMigration(ctx, db, config, filename); err != nil {
+		return err
+	}
+	// the lock of the rollback is released, as the run takes it again
+	return MigrateWithContext(ctx, db, config)
+}
+
+// Rollback rolls back the most recently applied migration with the "-- gosmm:down" section of its file, like
+// Redo without applying it again, and returns its name. The migration is then pending. Rollback refuses to run
+// on a dirty database.
+func Rollback(db *sql.DB, config MigrationConfig) (string, error) {
+	return RollbackWithContext(context.Background(), db, config)
+}
+
+// RollbackWithContext is Rollback with a context cancelling the rollback
+func RollbackWithContext(ctx context.Context, db *sql.DB, config MigrationConfig) (string, error) {
+	if !isSupportedDriver(config.Driver) {
+		return "", fmt.Errorf("unsupported driver: %s", config.Driver)
+	}
+	report, err := Status(db, config)
+	if err != nil {
+		return "", err
+	}
+	if report.Failed > 0 {
+		return "", ErrDirtyState
+	}
+
+	filename, err := lastAppliedMigration(db, config)
+	if err != nil {
+		return "", err
+	}
+	if filename == "" {
+		return "", fmt.Errorf("no applied migration to roll back")
+	}
+	if err := rollbackMigration(ctx, db, config, filename); err != nil {
+		return "", err
+	}
+	return filename, nil
+}
+
+// lastAppliedMigration returns the most recently applied migration, "" when none is
+func lastAppliedMigration(db *sql.DB, config MigrationConfig) (string, error) {
+	history, err := GetHistory(db, config)
+	if err != nil {
+		return "", err
+	}
+	if len(history) == 0 {
+		return "", nil
+	}
+	return history[len(history)-1].Filename, nil
+}
+
+// rollbackMigration executes the down section of the migration file and deletes its history record
+func rollbackMigration(ctx context.Context, db *sql.DB, config MigrationConfig, filename string) (err error) {
+	if _, ok := config.GoMigrations[filename]; ok {
+		return fmt.Errorf("migration %s is a Go migration, which cannot be rolled back", filename)
+	}
+	file, err := findMigrationFile(config, filename)
+	if err != nil {
+		return err
+	}
+	if config.Signatures != nil {
+		if err := config.Signatures.verify(filename, file.path); err != nil {
+			return err
+		}
+	}
+	data, err := config.readMigrationFile(file.path)
+	if err != nil {
+		return err
+	}
+	content, err := replacePlaceholders(string(data), config.Placeholders)
+	if err != nil {
+		return fmt.Errorf("failed to replace placeholders in %s: %w", filename, err)
+	}
+	_, down := splitDown(content)
+	if strings.TrimSpace(down) == "" {
+		return fmt.Errorf("migration %s has no %s section", filename, downMarker)
+	}
+	statements := resolveLoads(splitStatements(down, config.Driver), filepath.Dir(file.path))
+
+	table := historyTableName(config.Driver, config.Schema)
+	cockroach, err := isCockroachDB(db, config.Driver)
+	if err != nil {
+		return fmt.Errorf("failed to detect database version: %w", err)
+	}
+	// a lost lease aborts the rollback, like a migration
+	ctx, abort := context.WithCancelCause(ctx)
+	defer abort(nil)
+	defer func() { err = leaseLostError(ctx, err) }()
+	unlock, err := lockRun(ctx, db, config, table, cockroach, abort)
+	if err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+	defer unlock()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	executed := config.LogLevel.statementLogger(filename)
+	deleteRecord := "DELETE FROM " + table + " WHERE filename = " + bindParams(config.Driver, 1)
+	if file.metadata.NonTransactional || !transactionalMigrations(config.Driver) {
+		// like its up section, each statement is committed when it completes
+		if err := executeStatements(filename, statements, 0, config.Driver, config.Idempotent, nil, executed)(ctx, conn, nil); err != nil {
+			return err
+		}
+		if _, err := conn.ExecContext(ctx, deleteRecord, filename); err != nil {
+			return fmt.Errorf("failed to delete history of %s: %w", filename, err)
+		}
+	} else {
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		if err := executeStatements(filename, statements, 0, config.Driver, config.Idempotent, nil, executed)(ctx, conn, tx); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, deleteRecord, filename); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to delete history of %s: %w", filename, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit transaction: %w", err)
+		}
+	}
+	config.LogLevel.printf(LogInfo, "UNDO  %s\n", filename)
+	return nil
+}
@@ -0,0 +1,96 @@
+package gosmm
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitDown(t *testing.T) {
+	up, down := splitDown("CREATE TABLE users (id INTEGER);\n  -- gosmm:down\nDROP TABLE users;\n")
+	assert.Equal(t, "CREATE TABLE users (id INTEGER);\n", up)
+	assert.Equal(t, "DROP TABLE users;\n", down)
+
+	up, down = splitDown("CREATE TABLE users (id INTEGER); -- gosmm:down")
+	assert.Equal(t, "CREATE TABLE users (id INTEGER); -- gosmm:down", up)
+	assert.Equal(t, "", down)
+}
+
+func TestRedo(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	path := filepath.Join(dir, "v20230102_create_posts_00002.sql")
+	if err := ioutil.WriteFile(path, []byte("CREATE TABLE posts (id INTEGER);\n-- gosmm:down\nDROP TABLE posts;\n"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}
+
+	// the down section is not executed by the run
+	assert.NoError(t, MigrateWithConfig(db, config))
+	_, err := db.Exec("SELECT id FROM posts")
+	assert.NoError(t, err)
+
+	// the edited migration is rolled back and applied again
+	if err := ioutil.WriteFile(path, []byte("CREATE TABLE posts (id INTEGER, title TEXT);\n-- gosmm:down\nDROP TABLE posts;\n"), 0644); err != nil {
+		t.Fatalf("Failed to modify test migration file: %v", err)
+	}
+	assert.NoError(t, Redo(db, config))
+	_, err = db.Exec("SELECT title FROM posts")
+	assert.NoError(t, err)
+	assert.NoError(t, Validate(db, config))
+	history, err := GetHistory(db, config)
+	assert.NoError(t, err)
+	if assert.Len(t, history, 2) {
+		assert.Equal(t, "v20230102_create_posts_00002.sql", history[1].Filename)
+	}
+
+	// pending migrations are applied first
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230103_add_email_00003.sql"), []byte("ALTER TABLE users ADD COLUMN email TEXT;"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	assert.EqualError(t, Redo(db, config), "1 migration(s) pending, apply them before redoing the last one")
+
+	// a migration without down section cannot be rolled back
+	assert.NoError(t, MigrateWithConfig(db, config))
+	assert.EqualError(t, Redo(db, config), "migration v20230103_add_email_00003.sql has no -- gosmm:down section")
+}
+
+func TestRedoWithoutMigrations(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	assert.EqualError(t, Redo(db, MigrationConfig{MigrationsDir: t.TempDir(), Driver: "sqlite3"}), "no applied migration to redo")
+}
+
+func TestRollback(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER);\n-- gosmm:down\nDROP TABLE users;\n"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}
+
+	_, err := Rollback(db, config)
+	assert.EqualError(t, err, "no applied migration to roll back")
+
+	assert.NoError(t, MigrateWithConfig(db, config))
+	filename, err := Rollback(db, config)
+	assert.NoError(t, err)
+	assert.Equal(t, "v20230101_create_users_00001.sql", filename)
+	_, err = db.Exec("SELECT id FROM users")
+	assert.Error(t, err)
+
+	// the rolled back migration is pending
+	report, err := Status(db, config)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Pending)
+}
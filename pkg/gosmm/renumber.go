@@ -0,0 +1,203 @@
+package gosmm
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Renumbering is the renaming of a migration file sharing the sequence number of another, see FixDuplicateSequences
+type Renumbering struct {
+	// From and To are the paths of the file before and after its renaming
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Requirers are the paths of the migration files whose gosmm:requires header was updated with the new name
+	Requirers []string `json:"requirers,omitempty"`
+}
+
+// FixDuplicateSequences renames the migration files sharing the sequence number of another, reported by
+// Validate as IssueDuplicateSequence, e.g. after two branches each added a _00042 migration. Of two such files,
+// the one sorting last is renamed, unless it is applied to db and the other one is not. The renamed file takes
+// the sequence number following the highest one and a date sorting it after the latest migration, so that it is
+// applied last, as it would have been had it been added after the merge. The files applied to db are never
+// renamed, since their history entries would no longer match them, so that the fix must run before the
+// duplicates are applied anywhere. The detached signature of a renamed file is renamed with it, and the
+// gosmm:requires headers naming it are updated, unless they are of applied or signed migrations, whose checksums
+// or signatures would no longer match, in which case nothing is renamed. Only the files of config.Environment are
+// renumbered, the same sequence number may be used in another environment.
+func FixDuplicateSequences(db *sql.DB, config MigrationConfig) ([]Renumbering, error) {
+	if config.FilenamePattern != "" {
+		return nil, fmt.Errorf("renumbering requires the vYYYYMMDD_description_NNNNN.sql convention, FilenamePattern is set")
+	}
+	files, err := config.migrationFiles()
+	if err != nil {
+		return nil, err
+	}
+	var filenames []string
+	paths := make(map[string]string, len(files))
+	for _, file := range files {
+		if file.isDir || !file.inEnvironment(config.Environment) || !migrationFilenamePattern.MatchString(file.name) {
+			continue
+		}
+		filenames = append(filenames, file.name)
+		paths[file.name] = file.path
+	}
+
+	applied := make(map[string]appliedMigration)
+	exists, err := historyTableExists(db, config.Driver, config.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check history table: %w", err)
+	}
+	if exists {
+		applied, err = getAppliedMigrations(db, config.Driver, historyTableName(config.Driver, config.Schema))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load migration history: %w", err)
+		}
+	}
+
+	renames := renumberDuplicates(filenames, applied)
+	// the migrations requiring a renamed file, of any environment, are checked before anything is renamed
+	requirers := make(map[string][]migrationFile)
+	for _, file := range files {
+		for _, required := range file.metadata.Requires {
+			if _, ok := renames[required]; !ok {
+				continue
+			}
+			if _, ok := applied[file.name]; ok {
+				return nil, fmt.Errorf("cannot renumber %s: it is required by %s, which is applied", required, file.path)
+			}
+			if _, err := os.Stat(file.path + signatureFileExtension); err == nil {
+				return nil, fmt.Errorf("cannot renumber %s: it is required by %s, whose signature would no longer match", required, file.path)
+			}
+			requirers[required] = append(requirers[required], file)
+		}
+	}
+
+	var renumbered []Renumbering
+	// moved holds the new paths of the renamed files, some of which may require another one
+	moved := make(map[string]string)
+	for _, filename := range filenames {
+		name, ok := renames[filename]
+		if !ok {
+			continue
+		}
+		from := paths[filename]
+		// a template keeps its .tmpl extension
+		to := filepath.Join(filepath.Dir(from), name+strings.TrimPrefix(filepath.Base(from), filename))
+		if _, err := os.Stat(to); err == nil {
+			return renumbered, fmt.Errorf("cannot renumber %s: %s already exists", from, to)
+		}
+		if err := os.Rename(from, to); err != nil {
+			return renumbered, fmt.Errorf("failed to renumber %s: %w", from, err)
+		}
+		if _, err := os.Stat(from + signatureFileExtension); err == nil {
+			if err := os.Rename(from+signatureFileExtension, to+signatureFileExtension); err != nil {
+				return renumbered, fmt.Errorf("failed to renumber the signature of %s: %w", from, err)
+			}
+		}
+		moved[from] = to
+		renumbering := Renumbering{From: from, To: to}
+		for _, requirer := range requirers[filename] {
+			path := requirer.path
+			if to, ok := moved[path]; ok {
+				path = to
+			}
+			if err := rewriteRequires(path, filename, name); err != nil {
+				return append(renumbered, renumbering), fmt.Errorf("failed to update the requirement of %s on %s: %w", path, filename, err)
+			}
+			renumbering.Requirers = append(renumbering.Requirers, path)
+		}
+		renumbered = append(renumbered, renumbering)
+	}
+	return renumbered, nil
+}
+
+// rewriteRequires replaces from with to in the gosmm:requires lines of the header of the migration file at path
+func rewriteRequires(path string, from string, to string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	reference := regexp.MustCompile(`(^|[\s,])` + regexp.QuoteMeta(from) + `($|[\s,])`)
+	lines := strings.SplitAfter(string(data), "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			continue
+		}
+		if !strings.HasPrefix(trimmed, "--") {
+			break // the first statement ends the header
+		}
+		if match := metadataPattern.FindStringSubmatch(trimmed); match != nil && match[1] == "requires" {
+			lines[i] = reference.ReplaceAllString(line, "${1}"+to+"${2}")
+		}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, []byte(strings.Join(lines, "")), info.Mode())
+}
+
+// renumberDuplicates returns the new names of the files sharing the sequence number of another, keyed by their
+// current names, see FixDuplicateSequences. The filenames follow the vYYYYMMDD_description_NNNNN.sql convention
+// and are sorted by name.
+func renumberDuplicates(sortedFilenames []string, applied map[string]appliedMigration) map[string]string {
+	renames := make(map[string]string)
+	if len(sortedFilenames) == 0 {
+		return renames
+	}
+	latest := sortedFilenames[len(sortedFilenames)-1]
+	highest := 0
+	for _, filename := range sortedFilenames {
+		if sequence, err := strconv.Atoi(migrationFilenamePattern.FindStringSubmatch(filename)[3]); err == nil && sequence > highest {
+			highest = sequence
+		}
+	}
+	first := make(map[string]string)
+	for _, filename := range sortedFilenames {
+		sequence := migrationFilenamePattern.FindStringSubmatch(filename)[3]
+		other, ok := first[sequence]
+		if !ok {
+			first[sequence] = filename
+			continue
+		}
+		renamed := filename
+		if _, ok := applied[filename]; ok {
+			if _, ok := applied[other]; ok {
+				continue // both were applied, renaming either would break the history
+			}
+			renamed, first[sequence] = other, filename
+		}
+		highest++
+		latest = renumberedName(renamed, latest, highest)
+		renames[renamed] = latest
+	}
+	return renames
+}
+
+// renumberedName returns the name of the migration file with the given sequence number and a date sorting it
+// after latest: its own date or that of latest, or the day after latest when the name would not sort after it
+func renumberedName(filename string, latest string, sequence int) string {
+	match := migrationFilenamePattern.FindStringSubmatch(filename)
+	// the environment suffix and the extension
+	suffix := filename[len("v"+match[1]+"_"+match[2]+"_"+match[3]):]
+	date, latestDate := match[1], migrationFilenamePattern.FindStringSubmatch(latest)[1]
+	if latestDate > date {
+		date = latestDate
+	}
+	name := fmt.Sprintf("v%s_%s_%05d%s", date, match[2], sequence, suffix)
+	if name <= latest {
+		day, err := time.Parse("20060102", latestDate)
+		if err == nil {
+			name = fmt.Sprintf("v%s_%s_%05d%s", day.AddDate(0, 0, 1).Format("20060102"), match[2], sequence, suffix)
+		}
+	}
+	return name
+}
@@ -0,0 +1,111 @@
+package gosmm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenumberDuplicates(t *testing.T) {
+	filenames := []string{"v20230101_create_users_00001.sql", "v20230102_add_email_00001.sql", "v20230103_add_name_00002.dev.sql"}
+	assert.Equal(t, map[string]string{"v20230102_add_email_00001.sql": "v20230104_add_email_00003.sql"}, renumberDuplicates(filenames, nil))
+
+	// the file applied is kept, the other one is renumbered
+	applied := map[string]appliedMigration{"v20230102_add_email_00001.sql": {}}
+	assert.Equal(t, map[string]string{"v20230101_create_users_00001.sql": "v20230103_create_users_00003.sql"}, renumberDuplicates(filenames, applied))
+
+	applied["v20230101_create_users_00001.sql"] = appliedMigration{}
+	assert.Empty(t, renumberDuplicates(filenames, applied))
+
+	assert.Equal(t, "v20230103_add_name_00004.dev.sql", renumberedName("v20230103_add_name_00002.dev.sql", "v20230102_add_email_00003.sql", 4))
+	// the day after the latest file when the date of the latest file would sort before it
+	assert.Equal(t, "v20230104_add_email_00003.sql", renumberedName("v20230101_add_email_00001.sql", "v20230103_create_users_00002.sql", 3))
+}
+
+func TestFixDuplicateSequences(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	write := func(name string, content string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test migration file: %v", err)
+		}
+	}
+	write("v20230101_create_users_00001.sql", "CREATE TABLE users (id INTEGER);")
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}
+	assert.NoError(t, MigrateWithConfig(db, config))
+
+	// two branches add the same sequence number
+	write("v20230102_add_email_00002.sql", "ALTER TABLE users ADD COLUMN email TEXT;")
+	write("v20230103_add_name_00002.sql", "ALTER TABLE users ADD COLUMN name TEXT;")
+	write("v20230103_add_name_00002.sql.sig", "c2lnbmF0dXJl\n")
+	var validationErr *ValidationError
+	if assert.ErrorAs(t, Validate(db, config), &validationErr) && assert.Len(t, validationErr.Issues, 1) {
+		assert.Equal(t, "sequence number 00002 is also used by v20230102_add_email_00002.sql, renumber it to v20230103_add_name_00003.sql", validationErr.Issues[0].Message)
+	}
+
+	renumbered, err := FixDuplicateSequences(db, config)
+	assert.NoError(t, err)
+	assert.Equal(t, []Renumbering{{From: filepath.Join(dir, "v20230103_add_name_00002.sql"), To: filepath.Join(dir, "v20230103_add_name_00003.sql")}}, renumbered)
+	assert.FileExists(t, filepath.Join(dir, "v20230103_add_name_00003.sql.sig"))
+	assert.NoError(t, Validate(db, config))
+	assert.NoError(t, MigrateWithConfig(db, config))
+
+	// nothing is left to renumber
+	renumbered, err = FixDuplicateSequences(db, config)
+	assert.NoError(t, err)
+	assert.Empty(t, renumbered)
+
+	_, err = FixDuplicateSequences(db, MigrationConfig{MigrationsDir: dir, Driver: "sqlite3", FilenamePattern: FlywayFilenamePattern})
+	assert.EqualError(t, err, "renumbering requires the vYYYYMMDD_description_NNNNN.sql convention, FilenamePattern is set")
+}
+
+func TestFixDuplicateSequencesUpdatesRequires(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	write := func(name string, content string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test migration file: %v", err)
+		}
+	}
+	write("v20230101_create_users_00001.sql", "CREATE TABLE users (id INTEGER);")
+	write("v20230102_add_email_00002.sql", "ALTER TABLE users ADD COLUMN email TEXT;")
+	write("v20230103_add_name_00002.sql", "ALTER TABLE users ADD COLUMN name TEXT;")
+	write("v20230104_fill_names_00003.sql", "-- gosmm:author Jane Doe\n-- gosmm:requires v20230101_create_users_00001.sql, v20230103_add_name_00002.sql\nUPDATE users SET name = 'unknown';\n-- gosmm:requires v20230103_add_name_00002.sql is not in the header\n")
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}
+
+	// A signed migration requiring the file cannot be updated, so nothing is renamed
+	write("v20230104_fill_names_00003.sql.sig", "c2lnbmF0dXJl\n")
+	_, err := FixDuplicateSequences(db, config)
+	assert.ErrorContains(t, err, "cannot renumber v20230103_add_name_00002.sql: it is required by")
+	assert.FileExists(t, filepath.Join(dir, "v20230103_add_name_00002.sql"))
+	if err := os.Remove(filepath.Join(dir, "v20230104_fill_names_00003.sql.sig")); err != nil {
+		t.Fatalf("Failed to remove signature: %v", err)
+	}
+
+	renumbered, err := FixDuplicateSequences(db, config)
+	assert.NoError(t, err)
+	assert.Equal(t, []Renumbering{{
+		From:      filepath.Join(dir, "v20230103_add_name_00002.sql"),
+		To:        filepath.Join(dir, "v20230105_add_name_00004.sql"),
+		Requirers: []string{filepath.Join(dir, "v20230104_fill_names_00003.sql")},
+	}}, renumbered)
+	content, err := ioutil.ReadFile(filepath.Join(dir, "v20230104_fill_names_00003.sql"))
+	assert.NoError(t, err)
+	assert.Equal(t, "-- gosmm:author Jane Doe\n-- gosmm:requires v20230101_create_users_00001.sql, v20230105_add_name_00004.sql\nUPDATE users SET name = 'unknown';\n-- gosmm:requires v20230103_add_name_00002.sql is not in the header\n", string(content))
+
+	// The requirement still orders the migrations
+	assert.NoError(t, MigrateWithConfig(db, config))
+	history, err := GetHistory(db, config)
+	assert.NoError(t, err)
+	if assert.Len(t, history, 4) {
+		assert.Equal(t, "v20230105_add_name_00004.sql", history[2].Filename)
+		assert.Equal(t, "v20230104_fill_names_00003.sql", history[3].Filename)
+	}
+}
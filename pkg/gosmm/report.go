@@ -0,0 +1,218 @@
+package gosmm
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RunReport summarizes a migration run for a change-management ticket, see MigrationConfig.ReportFile
+type RunReport struct {
+	StartedAt  time.Time `json:"started_at"`
+	DurationMs int64     `json:"duration_ms"`
+	Success    bool      `json:"success"`
+	// Error is the error the run failed with
+	Error  string `json:"error,omitempty"`
+	Driver string `json:"driver"`
+	Schema string `json:"schema,omitempty"`
+	// AppliedBy and Context are the audit columns recorded with the applied migrations
+	AppliedBy string `json:"applied_by,omitempty"`
+	Context   string `json:"context,omitempty"`
+	// Approval is the id of the approval of the run, see MigrationConfig.Approval
+	Approval string `json:"approval,omitempty"`
+	// Applied are the migrations applied by the run, in the order they completed
+	Applied []ReportedMigration `json:"applied"`
+	// Failed is the migration the run failed on
+	Failed *ReportedMigration `json:"failed,omitempty"`
+	// Warnings are the lint issues of the pending migrations and the parts of the report that could not be built
+	Warnings []string `json:"warnings"`
+	// SchemaChanges is the difference between the schema before and after the run, as drifts whose Expected
+	// definition is the one before the run
+	SchemaChanges []SchemaDrift `json:"schema_changes"`
+}
+
+// ReportedMigration is a migration of a RunReport
+type ReportedMigration struct {
+	Filename     string `json:"filename"`
+	DurationMs   int64  `json:"duration_ms"`
+	RowsAffected int64  `json:"rows_affected"`
+	Error        string `json:"error,omitempty"`
+}
+
+// runReporter collects the RunReport of a run while it progresses
+type runReporter struct {
+	mu     sync.Mutex
+	report RunReport
+	// before is the schema before the run, nil when it could not be inspected
+	before []SchemaObject
+}
+
+// newRunReporter starts the report of a run of config, collecting its applied migrations from the progress
+// events, which config is changed to emit to the reporter too
+func newRunReporter(config *MigrationConfig) *runReporter {
+	r := &runReporter{report: RunReport{StartedAt: time.Now(), Driver: config.Driver, Schema: config.Schema,
+		Applied: []ReportedMigration{}, Warnings: []string{}, SchemaChanges: []SchemaDrift{}}}
+	progress := config.Progress
+	config.Progress = func(event Event) {
+		r.observe(event)
+		progress.emit(event)
+	}
+	return r
+}
+
+// observe records the migrations finished or failed
+func (r *runReporter) observe(event Event) {
+	migration := ReportedMigration{
+		Filename:     event.Migration.Filename,
+		DurationMs:   event.Duration.Milliseconds(),
+		RowsAffected: event.Migration.RowsAffected,
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch event.Kind {
+	case EventMigrationFinished:
+		r.report.Applied = append(r.report.Applied, migration)
+	case EventMigrationFailed:
+		migration.Error = event.Err.Error()
+		r.report.Failed = &migration
+	}
+}
+
+// warn adds a warning to the report
+func (r *runReporter) warn(format string, args ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.report.Warnings = append(r.report.Warnings, fmt.Sprintf(format, args...))
+}
+
+// start records the audit columns and the lint issues of the pending migrations, and inspects the schema
+// before they are applied
+func (r *runReporter) start(db *sql.DB, config MigrationConfig, pending []MigrationInfo, appliedBy string, auditContext string) {
+	r.report.AppliedBy, r.report.Context = appliedBy, auditContext
+	if len(pending) > 0 {
+		r.report.Approval = pending[0].Approval
+	}
+	issues, err := Lint(db, config)
+	if err != nil {
+		r.warn("failed to lint the pending migrations: %v", err)
+	}
+	filenames := make(map[string]bool, len(pending))
+	for _, migration := range pending {
+		filenames[migration.Filename] = true
+	}
+	for _, issue := range issues {
+		if filenames[issue.File] {
+			r.warn("%s", issue)
+		}
+	}
+	if r.before, err = InspectSchema(db, config); err != nil {
+		r.warn("schema changes not reported: %v", err)
+	}
+}
+
+// write completes the report with the outcome err of the run and writes it to config.ReportFile, as HTML when
+// its extension is .html and as JSON otherwise. A failure to write it is printed as a warning.
+func (r *runReporter) write(db *sql.DB, config MigrationConfig, err error) {
+	report := &r.report
+	report.DurationMs = time.Since(report.StartedAt).Milliseconds()
+	report.Success = err == nil
+	if err != nil {
+		report.Error = err.Error()
+	}
+	if r.before != nil {
+		after, err := InspectSchema(db, config)
+		if err != nil {
+			r.warn("schema changes not reported: %v", err)
+		} else {
+			report.SchemaChanges = append(report.SchemaChanges, DiffSchema(r.before, after)...)
+		}
+	}
+
+	var content []byte
+	if ext := strings.ToLower(filepath.Ext(config.ReportFile)); ext == ".html" || ext == ".htm" {
+		var b strings.Builder
+		if err = reportTemplate.Execute(&b, report); err == nil {
+			content = []byte(b.String())
+		}
+	} else if content, err = json.MarshalIndent(report, "", "  "); err == nil {
+		content = append(content, '\n')
+	}
+	if err == nil {
+		err = os.WriteFile(config.ReportFile, content, 0644)
+	}
+	if err != nil {
+		config.LogLevel.printf(LogWarn, "WARNING: failed to write the run report to %s: %v\n", config.ReportFile, err)
+	}
+}
+
+// reportTemplate renders a RunReport as a standalone HTML page
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>gosmm run report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; vertical-align: top; }
+pre { margin: 0; white-space: pre-wrap; }
+.failed { color: #b00020; }
+</style>
+</head>
+<body>
+<h1>gosmm run report</h1>
+<table>
+<tr><th>Started</th><td>{{.StartedAt.Format "2006-01-02 15:04:05 MST"}}</td></tr>
+<tr><th>Duration</th><td>{{.DurationMs}} ms</td></tr>
+<tr><th>Outcome</th><td>{{if .Success}}succeeded{{else}}<span class="failed">failed: {{.Error}}</span>{{end}}</td></tr>
+<tr><th>Driver</th><td>{{.Driver}}{{if .Schema}} (schema {{.Schema}}){{end}}</td></tr>
+{{- if .AppliedBy}}
+<tr><th>Applied by</th><td>{{.AppliedBy}}</td></tr>
+{{- end}}
+{{- if .Context}}
+<tr><th>Context</th><td>{{.Context}}</td></tr>
+{{- end}}
+{{- if .Approval}}
+<tr><th>Approval</th><td>{{.Approval}}</td></tr>
+{{- end}}
+</table>
+<h2>Applied migrations</h2>
+<table>
+<tr><th>Migration</th><th>Duration</th><th>Rows affected</th></tr>
+{{- range .Applied}}
+<tr><td>{{.Filename}}</td><td>{{.DurationMs}} ms</td><td>{{.RowsAffected}}</td></tr>
+{{- end}}
+{{- with .Failed}}
+<tr class="failed"><td>{{.Filename}}</td><td>{{.DurationMs}} ms</td><td>failed: {{.Error}}</td></tr>
+{{- end}}
+</table>
+<h2>Warnings</h2>
+{{- if .Warnings}}
+<ul>
+{{- range .Warnings}}
+<li>{{.}}</li>
+{{- end}}
+</ul>
+{{- else}}
+<p>None</p>
+{{- end}}
+<h2>Schema changes</h2>
+{{- if .SchemaChanges}}
+<table>
+<tr><th>Change</th><th>Object</th><th>Before</th><th>After</th></tr>
+{{- range .SchemaChanges}}
+<tr><td>{{.Change}}</td><td>{{.Kind}} {{.Name}}</td><td><pre>{{.Expected}}</pre></td><td><pre>{{.Actual}}</pre></td></tr>
+{{- end}}
+</table>
+{{- else}}
+<p>None</p>
+{{- end}}
+</body>
+</html>
+`))
@@ -0,0 +1,60 @@
+package gosmm
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrateWithReportFile(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER, email TEXT);\nINSERT INTO users VALUES (1, 'a@example.com');"), 0644); err != nil {
+		t.Fatalf("Failed to create migration file: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230102_drop_email_00001.sql"), []byte("ALTER TABLE users DROP COLUMN email;"), 0644); err != nil {
+		t.Fatalf("Failed to create migration file: %v", err)
+	}
+	reportFile := filepath.Join(t.TempDir(), "report.json")
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3", ReportFile: reportFile, Context: "CHG-1234"}
+	assert.NoError(t, MigrateWithConfig(db, config))
+
+	data, err := ioutil.ReadFile(reportFile)
+	if err != nil {
+		t.Fatalf("Failed to read report: %v", err)
+	}
+	var report RunReport
+	assert.NoError(t, json.Unmarshal(data, &report))
+	assert.True(t, report.Success)
+	assert.Equal(t, "CHG-1234", report.Context)
+	if assert.Len(t, report.Applied, 2) {
+		assert.Equal(t, "v20230101_create_users_00001.sql", report.Applied[0].Filename)
+		assert.Equal(t, int64(1), report.Applied[0].RowsAffected)
+	}
+	if assert.Len(t, report.Warnings, 1) {
+		assert.Contains(t, report.Warnings[0], "v20230102_drop_email_00001.sql:1: drop-column:")
+	}
+	if assert.Len(t, report.SchemaChanges, 1) {
+		assert.Equal(t, SchemaDrift{Change: DriftAdded, Kind: SchemaTable, Name: "users", Actual: report.SchemaChanges[0].Actual}, report.SchemaChanges[0])
+	}
+
+	// A failed run is reported as HTML
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230103_create_orders_00001.sql"), []byte("CREATE TABLE orders (id INTEGER);\nINSERT INTO missing VALUES (1);"), 0644); err != nil {
+		t.Fatalf("Failed to create migration file: %v", err)
+	}
+	config.ReportFile = filepath.Join(t.TempDir(), "report.html")
+	assert.Error(t, MigrateWithConfig(db, config))
+	data, err = ioutil.ReadFile(config.ReportFile)
+	if err != nil {
+		t.Fatalf("Failed to read report: %v", err)
+	}
+	assert.Contains(t, string(data), "<h1>gosmm run report</h1>")
+	assert.Contains(t, string(data), "v20230103_create_orders_00001.sql")
+	assert.Contains(t, string(data), "no such table: missing")
+	assert.Contains(t, string(data), "<h2>Schema changes</h2>\n<p>None</p>")
+}
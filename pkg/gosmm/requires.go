@@ -0,0 +1,101 @@
+package gosmm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// orderMigrations returns the migrations in the order they are applied: each one after the migrations it
+// requires (see MigrationMetadata.Requires), and otherwise in their order in names. A migration is only moved
+// after the migrations it requires, never before them, so that the order of the migrations without requirements
+// is kept. A requirement missing from names or a cycle of requirements is an error.
+func orderMigrations(names []string, requires map[string][]string) ([]string, error) {
+	if len(requires) == 0 {
+		return names, nil
+	}
+	index := make(map[string]int, len(names))
+	for i, name := range names {
+		index[name] = i
+	}
+	waiting := make(map[string]int, len(requires))
+	dependents := make(map[string][]string)
+	for _, name := range names {
+		for _, required := range requires[name] {
+			if _, ok := index[required]; !ok {
+				return nil, fmt.Errorf("migration %s requires %s, which is not a migration", name, required)
+			}
+			if required == name {
+				return nil, fmt.Errorf("migration %s requires itself", name)
+			}
+			waiting[name]++
+			dependents[required] = append(dependents[required], name)
+		}
+	}
+
+	// ready holds the indexes of the migrations whose requirements are all ordered, the first one is next
+	var ready []int
+	for i, name := range names {
+		if waiting[name] == 0 {
+			ready = append(ready, i)
+		}
+	}
+	ordered := make([]string, 0, len(names))
+	for len(ready) > 0 {
+		name := names[ready[0]]
+		ready = ready[1:]
+		ordered = append(ordered, name)
+		for _, dependent := range dependents[name] {
+			if waiting[dependent]--; waiting[dependent] == 0 {
+				i := index[dependent]
+				position := sort.SearchInts(ready, i)
+				ready = append(ready, 0)
+				copy(ready[position+1:], ready[position:])
+				ready[position] = i
+			}
+		}
+	}
+	if len(ordered) < len(names) {
+		return nil, fmt.Errorf("cycle in the requirements of the migrations: %s", strings.Join(findRequiresCycle(names, requires, waiting), " -> "))
+	}
+	return ordered, nil
+}
+
+// findRequiresCycle returns a cycle of requirements among the migrations left waiting by orderMigrations,
+// starting and ending with the same migration
+func findRequiresCycle(names []string, requires map[string][]string, waiting map[string]int) []string {
+	// every waiting migration requires another waiting one, so following them from any of them loops
+	var start string
+	for _, name := range names {
+		if waiting[name] > 0 {
+			start = name
+			break
+		}
+	}
+	seen := make(map[string]int)
+	var path []string
+	for name := start; ; {
+		if i, ok := seen[name]; ok {
+			return append(path[i:], name)
+		}
+		seen[name] = len(path)
+		path = append(path, name)
+		for _, required := range requires[name] {
+			if waiting[required] > 0 {
+				name = required
+				break
+			}
+		}
+	}
+}
+
+// migrationRequires returns the requirements of the migration files declared in their headers, keyed by filename
+func migrationRequires(files []migrationFile) map[string][]string {
+	requires := make(map[string][]string)
+	for _, file := range files {
+		if len(file.metadata.Requires) > 0 {
+			requires[file.name] = file.metadata.Requires
+		}
+	}
+	return requires
+}
@@ -0,0 +1,71 @@
+package gosmm
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestOrderMigrations(t *testing.T) {
+	names := []string{"a", "b", "c", "d"}
+
+	ordered, err := orderMigrations(names, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, names, ordered)
+
+	// a is moved after c, b and d keep their order
+	ordered, err = orderMigrations(names, map[string][]string{"a": {"c"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b", "c", "a", "d"}, ordered)
+
+	ordered, err = orderMigrations(names, map[string][]string{"a": {"d", "b"}, "b": {"c"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"c", "b", "d", "a"}, ordered)
+
+	_, err = orderMigrations(names, map[string][]string{"a": {"e"}})
+	assert.EqualError(t, err, "migration a requires e, which is not a migration")
+	_, err = orderMigrations(names, map[string][]string{"b": {"b"}})
+	assert.EqualError(t, err, "migration b requires itself")
+	_, err = orderMigrations(names, map[string][]string{"a": {"d"}, "b": {"a"}, "d": {"b"}})
+	assert.EqualError(t, err, "cycle in the requirements of the migrations: a -> d -> b -> a")
+}
+
+func TestMigrateWithRequires(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	write := func(name string, content string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test migration file: %v", err)
+		}
+	}
+	// the billing team's migration requires the users table of another stream, created with a later date
+	write("v20230101_create_invoices_00001.sql", "-- gosmm:requires v20230102_create_users_00002.sql\nCREATE TABLE invoices (user_id INTEGER REFERENCES users (id));")
+	write("v20230102_create_users_00002.sql", "CREATE TABLE users (id INTEGER PRIMARY KEY);")
+	write("v20230103_create_orders_00003.sql", "CREATE TABLE orders (id INTEGER);")
+
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}
+	report, err := Status(db, config)
+	assert.NoError(t, err)
+	var pending []string
+	for _, migration := range report.Migrations {
+		pending = append(pending, migration.Filename)
+	}
+	assert.Equal(t, []string{"v20230102_create_users_00002.sql", "v20230101_create_invoices_00001.sql", "v20230103_create_orders_00003.sql"}, pending)
+
+	assert.NoError(t, MigrateWithConfig(db, config))
+	history, err := GetHistory(db, config)
+	assert.NoError(t, err)
+	if assert.Len(t, history, 3) {
+		assert.Equal(t, "v20230102_create_users_00002.sql", history[0].Filename)
+		assert.Equal(t, "v20230101_create_invoices_00001.sql", history[1].Filename)
+	}
+	assert.NoError(t, Validate(db, config))
+
+	write("v20230104_cycle_00004.sql", "-- gosmm:requires v20230105_cycle_00005.sql\nSELECT 1;")
+	write("v20230105_cycle_00005.sql", "-- gosmm:requires v20230104_cycle_00004.sql\nSELECT 1;")
+	err = MigrateWithConfig(db, config)
+	assert.EqualError(t, err, "cycle in the requirements of the migrations: v20230104_cycle_00004.sql -> v20230105_cycle_00005.sql -> v20230104_cycle_00004.sql")
+}
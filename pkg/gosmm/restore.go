@@ -7,11 +7,17 @@ import (
 
 // Restore cleans up the migration history by deleting records with success = false
 func Restore(db *sql.DB) error {
+	return RestoreWithConfig(db, MigrationConfig{})
+}
+
+// RestoreWithConfig cleans up the history table described by the given MigrationConfig
+// by deleting records with success = false
+func RestoreWithConfig(db *sql.DB, config MigrationConfig) error {
 	// Create history table if it doesn't exist
-	err := createHistoryTable(db)
+	err := createHistoryTable(db, config.Driver, config.Schema)
 
 	// SQL query to delete records where success is false from the migration history table
-	query := "DELETE FROM gosmm_migration_history WHERE success = FALSE"
+	query := "DELETE FROM " + historyTableName(config.Driver, config.Schema) + " WHERE success = " + boolLiteral(config.Driver, false)
 
 	// Execute the DELETE query
 	result, err := db.Exec(query)
@@ -26,9 +32,9 @@ func Restore(db *sql.DB) error {
 	}
 
 	if rowsDeleted == 0 {
-		fmt.Println("No records to restore.")
+		config.LogLevel.printf(LogInfo, "No records to restore.\n")
 	} else {
-		fmt.Printf("%d record(s) restored.\n", rowsDeleted)
+		config.LogLevel.printf(LogInfo, "%d record(s) restored.\n", rowsDeleted)
 	}
 
 	return nil
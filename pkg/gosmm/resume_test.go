@@ -0,0 +1,140 @@
+package gosmm
+
+import (
+	"context"
+	"database/sql"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMigrateWithResumeMode(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	// Create test_migrations directory if it doesn't exist
+	if _, err := os.Stat(migrationsDir); os.IsNotExist(err) {
+		err := os.Mkdir(migrationsDir, 0755)
+		if err != nil {
+			t.Fatalf("Failed to create test_migrations directory: %v", err)
+		}
+	}
+
+	// Create a test migration file whose second statement fails
+	testMigrationFile := filepath.Join(migrationsDir, "v20230101_create_test_data_00001.sql")
+	if err := ioutil.WriteFile(testMigrationFile, []byte("CREATE TABLE test_table (id INTEGER); INSERT INTO missing_table VALUES (1);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	defer os.Remove(testMigrationFile)
+
+	config := MigrationConfig{MigrationsDir: migrationsDir, Driver: "sqlite3", ResumeMode: true}
+	err := MigrateWithConfig(db, config)
+	var migrationErr *ErrMigrationFailed
+	if assert.ErrorAs(t, err, &migrationErr) {
+		assert.Equal(t, 2, migrationErr.StatementIndex)
+	}
+
+	// Check the failed statement was recorded
+	var failedStatement, committedStatements int
+	err = db.QueryRow("SELECT failed_statement, committed_statements FROM gosmm_migration_history WHERE success = FALSE").Scan(&failedStatement, &committedStatements)
+	if err != nil {
+		t.Fatalf("Failed to query gosmm_migration_history: %v", err)
+	}
+	assert.Equal(t, 2, failedStatement)
+	assert.Equal(t, 0, committedStatements)
+
+	// Without ResumeMode the failed migration blocks the run
+	err = MigrateWithConfig(db, MigrationConfig{MigrationsDir: migrationsDir, Driver: "sqlite3"})
+	assert.ErrorIs(t, err, ErrDirtyState)
+
+	// Fix the migration file and resume
+	if err := ioutil.WriteFile(testMigrationFile, []byte("CREATE TABLE test_table (id INTEGER); INSERT INTO test_table VALUES (1);"), 0644); err != nil {
+		t.Fatalf("Failed to modify test migration file: %v", err)
+	}
+	err = MigrateWithConfig(db, config)
+	assert.NoError(t, err)
+
+	var installedRank int
+	var success bool
+	err = db.QueryRow("SELECT installed_rank, success FROM gosmm_migration_history WHERE filename = 'v20230101_create_test_data_00001.sql'").Scan(&installedRank, &success)
+	if err != nil {
+		t.Fatalf("Failed to query gosmm_migration_history: %v", err)
+	}
+	assert.Equal(t, 1, installedRank)
+	assert.True(t, success)
+}
+
+func TestExecuteStatementsWithSkip(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get connection: %v", err)
+	}
+	defer conn.Close()
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("Failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var executed []int
+	execute := executeStatements("v20230101_create_test_data_00001.sql", []string{
+		"CREATE TABLE already_committed (id INTEGER)",
+		"CREATE TABLE test_table (id INTEGER)",
+		"INSERT INTO missing_table VALUES (1)",
+	}, 1, "sqlite3", false, nil, func(index int, statement string, duration time.Duration, rowsAffected int64) {
+		executed = append(executed, index)
+	})
+
+	err = execute(ctx, conn, tx)
+	var migrationErr *ErrMigrationFailed
+	if assert.ErrorAs(t, err, &migrationErr) {
+		assert.Equal(t, 3, migrationErr.StatementIndex)
+		assert.Equal(t, 1, migrationErr.CommittedStatements)
+	}
+	assert.Equal(t, []int{2}, executed)
+
+	// The skipped statement was not executed
+	var count int
+	err = tx.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'already_committed'").Scan(&count)
+	if err != nil && err != sql.ErrNoRows {
+		t.Fatalf("Failed to query sqlite_master: %v", err)
+	}
+	assert.Equal(t, 0, count)
+}
+
+func TestMigrateRecordsCommittedStatements(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	// The third statement reads the record of the running migration
+	dir := t.TempDir()
+	migration := `-- gosmm:transactional false
+CREATE TABLE observed (committed INTEGER);
+INSERT INTO observed VALUES (0);
+INSERT INTO observed SELECT committed_statements FROM gosmm_migration_history WHERE success = FALSE;
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_observed_00001.sql"), []byte(migration), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	assert.NoError(t, MigrateWithConfig(db, MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}))
+
+	var committed []int
+	rows, err := db.Query("SELECT committed FROM observed ORDER BY committed")
+	if err != nil {
+		t.Fatalf("Failed to query observed: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var n int
+		assert.NoError(t, rows.Scan(&n))
+		committed = append(committed, n)
+	}
+	assert.Equal(t, []int{0, 2}, committed)
+}
@@ -0,0 +1,155 @@
+package gosmm
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lib/pq"
+	mssql "github.com/microsoft/go-mssqldb"
+)
+
+// defaultRetryBackoff is the delay before the first retry when RetryPolicy.InitialBackoff is zero
+const defaultRetryBackoff = 100 * time.Millisecond
+
+// RetryPolicy configures the retries of transient failures, such as a connection reset, a deadlock
+// or a lock wait timeout. A migration failing with a transient error is rolled back and attempted
+// again without recording the failure, so the history is not left dirty by a network blip.
+// SQL errors, such as a syntax error, are never retried.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts including the first one, 1 or less disables retries
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry, doubled on every retry (100ms when zero)
+	InitialBackoff time.Duration
+	// MaxBackoff bounds the delay between attempts, unbounded when zero
+	MaxBackoff time.Duration
+	// IsRetryable reports whether an error is transient, IsRetryableError when nil
+	IsRetryable func(err error) bool
+}
+
+// allows reports whether an operation failing with err on the given attempt, starting at 1, is retried
+func (p *RetryPolicy) allows(attempt int, err error) bool {
+	if p == nil || attempt >= p.MaxAttempts {
+		return false
+	}
+	if p.IsRetryable != nil {
+		return p.IsRetryable(err)
+	}
+	return IsRetryableError(err)
+}
+
+// backoff returns the delay before the retry following the given attempt, starting at 1
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	backoff := p.InitialBackoff
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+	for i := 1; i < attempt; i++ {
+		backoff *= 2
+		if p.MaxBackoff > 0 && backoff >= p.MaxBackoff {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+	return backoff
+}
+
+// commitUnknownError is returned when the connection was lost while a migration was recorded,
+// so whether it was committed is unknown and it must not be retried
+type commitUnknownError struct {
+	File  string
+	Cause error
+}
+
+// Error returns the migration and the cause
+func (e *commitUnknownError) Error() string {
+	return fmt.Sprintf("failed to record migration %s, the connection was lost and the migration may have been committed: %v", e.File, e.Cause)
+}
+
+// Unwrap returns the cause
+func (e *commitUnknownError) Unwrap() error {
+	return e.Cause
+}
+
+// sleep waits for d or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// IsRetryableError reports whether err is a transient failure worth retrying:
+// a lost connection, a deadlock, a serialization failure or a lock wait timeout
+func IsRetryableError(err error) bool {
+	return isConnectionError(err) || isTransientSQLError(err)
+}
+
+// isConnectionError reports whether err is a lost or refused connection
+func isConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	// SQLSTATE class 08 (connection exception) and the shutdown errors of the server
+	code := sqlState(err)
+	return len(code) == 5 && (code[:2] == "08" || code == "57P01" || code == "57P02" || code == "57P03")
+}
+
+// isTransientSQLError reports whether err is a deadlock, a serialization failure or a lock wait timeout
+func isTransientSQLError(err error) bool {
+	if err == nil {
+		return false
+	}
+	switch sqlState(err) {
+	case "40001", "40P01", "55P03": // serialization_failure, deadlock_detected, lock_not_available
+		return true
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == 1213 || mysqlErr.Number == 1205 // deadlock, lock wait timeout
+	}
+	// SQLITE_BUSY and SQLITE_LOCKED, matched on their messages since sqlite3.Error requires cgo
+	if message := err.Error(); strings.Contains(message, "database is locked") || strings.Contains(message, "database table is locked") {
+		return true
+	}
+	var mssqlErr mssql.Error
+	if errors.As(err, &mssqlErr) {
+		return mssqlErr.Number == 1205 || mssqlErr.Number == 1222 // deadlock victim, lock request timeout
+	}
+	return false
+}
+
+// sqlState returns the SQLSTATE of a postgres error, or "" for other errors
+func sqlState(err error) string {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return string(pqErr.Code)
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code
+	}
+	return ""
+}
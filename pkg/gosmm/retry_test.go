@@ -0,0 +1,140 @@
+package gosmm
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	mssql "github.com/microsoft/go-mssqldb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsRetryableError(t *testing.T) {
+	retryable := []error{
+		driver.ErrBadConn,
+		fmt.Errorf("failed to begin transaction: %w", mysql.ErrInvalidConn),
+		&net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET},
+		syscall.ECONNREFUSED,
+		&pq.Error{Code: "40P01"},
+		&pq.Error{Code: "08006"},
+		&pq.Error{Code: "55P03"},
+		&mysql.MySQLError{Number: 1213},
+		&mysql.MySQLError{Number: 1205},
+		fmt.Errorf("exec: %w", errors.New("database is locked")),
+		mssql.Error{Number: 1205},
+		&ErrMigrationFailed{File: "v20230101_create_users_00001.sql", Cause: &pq.Error{Code: "40001"}},
+	}
+	for _, err := range retryable {
+		assert.True(t, IsRetryableError(err), "%#v", err)
+	}
+
+	notRetryable := []error{
+		nil,
+		errors.New("boom"),
+		&pq.Error{Code: "42601"},
+		&mysql.MySQLError{Number: 1064},
+		errors.New("UNIQUE constraint failed: users.id"),
+		mssql.Error{Number: 102},
+	}
+	for _, err := range notRetryable {
+		assert.False(t, IsRetryableError(err), "%#v", err)
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := &RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	assert.Equal(t, time.Second, policy.backoff(1))
+	assert.Equal(t, 2*time.Second, policy.backoff(2))
+	assert.Equal(t, 4*time.Second, policy.backoff(3))
+	assert.Equal(t, 5*time.Second, policy.backoff(4))
+	assert.Equal(t, defaultRetryBackoff, (&RetryPolicy{}).backoff(1))
+
+	assert.True(t, policy.allows(4, driver.ErrBadConn))
+	assert.False(t, policy.allows(5, driver.ErrBadConn))
+	assert.False(t, policy.allows(1, errors.New("syntax error")))
+
+	var noPolicy *RetryPolicy
+	assert.False(t, noPolicy.allows(1, driver.ErrBadConn))
+}
+
+// flakyMigration returns a Go migration failing with err the first failures times
+func flakyMigration(failures int, err error, calls *int) GoMigrationFunc {
+	return func(ctx context.Context, conn *sql.Conn, tx *sql.Tx) error {
+		*calls++
+		if *calls <= failures {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, "CREATE TABLE users (id INTEGER)")
+		return err
+	}
+}
+
+func TestMigrateWithRetry(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	var calls int
+	config := MigrationConfig{
+		MigrationsDir: t.TempDir(),
+		Driver:        "sqlite3",
+		GoMigrations:  map[string]GoMigrationFunc{"v20230101_create_users_00001": flakyMigration(2, fmt.Errorf("exec: %w", errors.New("database is locked")), &calls)},
+		Retry:         &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+	}
+	err := MigrateWithConfig(db, config)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+
+	// The retried failures were not recorded
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM gosmm_migration_history WHERE success = 0").Scan(&count)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+	err = db.QueryRow("SELECT COUNT(*) FROM gosmm_migration_history WHERE success = 1").Scan(&count)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestMigrateWithRetryExhausted(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	var calls int
+	config := MigrationConfig{
+		MigrationsDir: t.TempDir(),
+		Driver:        "sqlite3",
+		GoMigrations:  map[string]GoMigrationFunc{"v20230101_create_users_00001": flakyMigration(5, fmt.Errorf("exec: %w", errors.New("database is locked")), &calls)},
+		Retry:         &RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond},
+	}
+	err := MigrateWithConfig(db, config)
+	assert.Error(t, err)
+	assert.Equal(t, 2, calls)
+
+	// The last failure is recorded
+	dirty, err := DirtyMigrations(db, config)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"v20230101_create_users_00001"}, dirty)
+}
+
+func TestMigrateWithRetryDoesNotRetrySQLErrors(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	var calls int
+	config := MigrationConfig{
+		MigrationsDir: t.TempDir(),
+		Driver:        "sqlite3",
+		GoMigrations:  map[string]GoMigrationFunc{"v20230101_create_users_00001": flakyMigration(1, errors.New("syntax error"), &calls)},
+		Retry:         &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+	}
+	err := MigrateWithConfig(db, config)
+	assert.Error(t, err)
+	assert.Equal(t, 1, calls)
+}
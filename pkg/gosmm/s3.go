@@ -0,0 +1,156 @@
+package gosmm
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// S3Source is a Source listing the objects of an S3 bucket under Prefix, e.g. the migrations published by CI,
+// or of an S3-compatible store such as MinIO with Endpoint. Directory marker objects are left out, and the
+// objects of a "directory" under Prefix are the files of the environment directory named after it.
+type S3Source struct {
+	// Bucket is the name of the bucket
+	Bucket string
+	// Prefix is the key prefix of the migration files, e.g. releases/1.4.0, the whole bucket when empty
+	Prefix string
+	// Region of the bucket, AWS_REGION or AWS_DEFAULT_REGION when empty
+	Region string
+	// Credentials signing the requests, the AWS_* environment variables when nil
+	Credentials AWSCredentialsFunc
+	// Endpoint overrides the S3 endpoint, e.g. http://localhost:9000 for MinIO. The bucket is then addressed
+	// in the path of the requests instead of the host.
+	Endpoint string
+	// Client sends the requests, http.DefaultClient when nil
+	Client *http.Client
+}
+
+// s3ListResult is the response of ListObjectsV2
+type s3ListResult struct {
+	IsTruncated           bool
+	NextContinuationToken string
+	Contents              []struct {
+		Key  string
+		ETag string
+	}
+}
+
+// List implements Source with ListObjectsV2, following its continuation tokens
+func (s S3Source) List(ctx context.Context) ([]SourceObject, error) {
+	prefix := s.prefix()
+	var objects []SourceObject
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		data, _, err := s.do(ctx, "", query)
+		if err != nil {
+			return nil, err
+		}
+		var result s3ListResult
+		if err := xml.Unmarshal(data, &result); err != nil {
+			return nil, fmt.Errorf("failed to parse the objects of s3://%s/%s: %w", s.Bucket, prefix, err)
+		}
+		for _, content := range result.Contents {
+			if strings.HasSuffix(content.Key, "/") {
+				continue
+			}
+			objects = append(objects, SourceObject{Name: strings.TrimPrefix(content.Key, prefix), ETag: content.ETag})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// Get implements Source with GetObject, checking the ETag of the object and its MD5 when its ETag is one
+func (s S3Source) Get(ctx context.Context, object SourceObject) ([]byte, error) {
+	data, header, err := s.do(ctx, s.prefix()+object.Name, nil)
+	if err != nil {
+		return nil, err
+	}
+	if etag := header.Get("ETag"); object.ETag != "" && etag != "" && etag != object.ETag {
+		return nil, fmt.Errorf("the ETag of %s changed from %s to %s while fetching it", object.Name, object.ETag, etag)
+	}
+	if err := verifyETag(data, object.ETag); err != nil {
+		return nil, fmt.Errorf("corrupted %s: %w", object.Name, err)
+	}
+	return data, nil
+}
+
+// prefix returns Prefix ending with a slash, so that it does not match the keys of sibling prefixes
+func (s S3Source) prefix() string {
+	prefix := strings.TrimPrefix(s.Prefix, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return prefix
+}
+
+// do sends a signed GET request for the object key of the bucket, the bucket itself when key is empty,
+// and returns the body of the response and its headers
+func (s S3Source) do(ctx context.Context, key string, query url.Values) ([]byte, http.Header, error) {
+	if s.Bucket == "" {
+		return nil, nil, errors.New("missing S3 bucket")
+	}
+	region, err := awsRegion(s.Region)
+	if err != nil {
+		return nil, nil, err
+	}
+	credentials, err := awsCredentials(ctx, s.Credentials)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	endpoint, path := "https://"+s.Bucket+".s3."+region+".amazonaws.com", "/"+key
+	if s.Endpoint != "" {
+		endpoint, path = strings.TrimSuffix(s.Endpoint, "/"), "/"+s.Bucket+"/"+key
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid S3 endpoint: %w", err)
+	}
+	u.Path, u.RawPath, u.RawQuery = path, s3EscapePath(path), awsQuery(query)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid S3 endpoint: %w", err)
+	}
+	req.Header.Set("X-Amz-Content-Sha256", hashHex(nil))
+	awsSigner{credentials: credentials, region: region, service: "s3", now: time.Now().UTC()}.sign(req, nil)
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get s3://%s/%s: %w", s.Bucket, key, err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read s3://%s/%s: %w", s.Bucket, key, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("failed to get s3://%s/%s: %s: %s", s.Bucket, key, resp.Status, strings.TrimSpace(string(data)))
+	}
+	return data, resp.Header, nil
+}
+
+// s3EscapePath percent-encodes the segments of path as the S3 signature requires
+func s3EscapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = awsEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,165 @@
+package gosmm
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// allowUnsafeMarker annotates a migration whose operations taking long locks are applied on purpose
+const allowUnsafeMarker = "-- gosmm:allow-unsafe"
+
+// unsafeOperation is an operation taking a long lock, matched in the clauses of an ALTER TABLE statement or
+// in a whole statement, unless the statement also matches safeWith
+type unsafeOperation struct {
+	pattern  *regexp.Regexp
+	safeWith *regexp.Regexp
+	reason   string
+}
+
+var (
+	createTablePattern = regexp.MustCompile(`(?is)^\s*CREATE\s+(?:(?:GLOBAL\s+|LOCAL\s+)?TEMP(?:ORARY)?\s+|UNLOGGED\s+)?TABLE\s+(?:IF\s+NOT\s+EXISTS\s+)?([^\s(]+)`)
+	mysqlOnlinePattern = regexp.MustCompile(`(?i)\bALGORITHM\s*=\s*(INPLACE|INSTANT)\b`)
+
+	// unsafeAlterTable are the operations of ALTER TABLE statements taking long locks, per driver
+	unsafeAlterTable = map[string][]unsafeOperation{
+		"postgres": {
+			{regexp.MustCompile(`(?i)\bALTER\s+(?:COLUMN\s+)?\S+\s+(?:SET\s+DATA\s+)?TYPE\b`), nil,
+				"changing the type of a column rewrites the table under an ACCESS EXCLUSIVE lock, add a new column and backfill it instead"},
+			{regexp.MustCompile(`(?i)\bSET\s+NOT\s+NULL\b`), nil,
+				"SET NOT NULL scans the table under an ACCESS EXCLUSIVE lock, validate a CHECK (column IS NOT NULL) NOT VALID constraint first"},
+			{regexp.MustCompile(`(?i)\bADD\s+(?:CONSTRAINT\s+\S+\s+)?(?:FOREIGN\s+KEY|CHECK)\b`), regexp.MustCompile(`(?i)\bNOT\s+VALID\b`),
+				"adding a constraint scans the table while blocking writes, add it NOT VALID and VALIDATE CONSTRAINT it in another migration"},
+			{regexp.MustCompile(`(?i)\bADD\s+(?:CONSTRAINT\s+\S+\s+)?(?:PRIMARY\s+KEY|UNIQUE)\b`), regexp.MustCompile(`(?i)\bUSING\s+INDEX\b`),
+				"adding a key builds its index while blocking writes, create the index CONCURRENTLY and add the constraint USING INDEX"},
+			{regexp.MustCompile(`(?i)\bSET\s+(?:TABLESPACE|LOGGED|UNLOGGED)\b`), nil,
+				"moving a table rewrites it under an ACCESS EXCLUSIVE lock"},
+		},
+		"mysql": {
+			{regexp.MustCompile(`(?i)\bALGORITHM\s*=\s*COPY\b`), nil,
+				"ALGORITHM=COPY copies the table while blocking writes"},
+			{regexp.MustCompile(`(?i)\bLOCK\s*=\s*(?:EXCLUSIVE|SHARED)\b`), nil,
+				"the ALTER TABLE blocks writes until it completes"},
+			{regexp.MustCompile(`(?i)\b(?:MODIFY|CHANGE)\b`), mysqlOnlinePattern,
+				"changing a column definition may copy the table while blocking writes, add ALGORITHM=INPLACE or ALGORITHM=INSTANT so that MySQL refuses it instead"},
+			{regexp.MustCompile(`(?i)\bCONVERT\s+TO\s+CHARACTER\s+SET\b`), nil,
+				"converting the character set copies the table while blocking writes"},
+		},
+		"sqlserver": {
+			{regexp.MustCompile(`(?i)\bALTER\s+COLUMN\b`), nil,
+				"altering a column may update every row under a schema modification lock, add a new column and backfill it instead"},
+			{regexp.MustCompile(`(?i)\bADD\s+(?:CONSTRAINT\s+\S+\s+)?(?:FOREIGN\s+KEY|CHECK)\b`), regexp.MustCompile(`(?i)\bWITH\s+NOCHECK\b`),
+				"adding a constraint scans the table under a schema modification lock, add it WITH NOCHECK and check it in another migration"},
+			{regexp.MustCompile(`(?i)\bADD\s+(?:CONSTRAINT\s+\S+\s+)?(?:PRIMARY\s+KEY|UNIQUE)\b`), sqlServerOnlinePattern,
+				"adding a key builds its index while blocking writes, add WITH (ONLINE = ON)"},
+		},
+	}
+
+	// unsafeStatements are the other statements taking long locks, per driver
+	unsafeStatements = map[string][]unsafeOperation{
+		"postgres": {
+			{regexp.MustCompile(`(?is)^\s*VACUUM\s+(?:\(\s*)?FULL\b`), nil, "VACUUM FULL rewrites the table under an ACCESS EXCLUSIVE lock"},
+			{regexp.MustCompile(`(?is)^\s*CLUSTER\b`), nil, "CLUSTER rewrites the table under an ACCESS EXCLUSIVE lock"},
+			{regexp.MustCompile(`(?is)^\s*REINDEX\b`), regexp.MustCompile(`(?i)\bCONCURRENTLY\b`), "REINDEX blocks the writes of the table, use REINDEX CONCURRENTLY"},
+		},
+		"sqlserver": {
+			{regexp.MustCompile(`(?is)^\s*ALTER\s+INDEX\b.*\bREBUILD\b`), sqlServerOnlinePattern, "rebuilding an index blocks the writes of the table, add WITH (ONLINE = ON)"},
+		},
+	}
+)
+
+// checkZeroDowntime returns an error wrapping ErrUnsafeMigration for the first pending migration with an
+// operation taking a long lock on an existing table, unless the migration is annotated with gosmm:allow-unsafe.
+// Tables created by the pending migrations are empty, so their operations are safe.
+func checkZeroDowntime(config MigrationConfig, paths map[string]string, pending []MigrationInfo) error {
+	created := make(map[string]bool)
+	for _, migration := range pending {
+		if _, ok := config.GoMigrations[migration.Filename]; ok {
+			continue
+		}
+		data, err := config.readMigrationFile(paths[migration.Filename])
+		if err != nil {
+			return err
+		}
+		content, err := replacePlaceholders(string(data), config.Placeholders)
+		if err != nil {
+			return fmt.Errorf("failed to replace placeholders in %s: %w", migration.Filename, err)
+		}
+		content, _ = splitDown(content) // the down section is only executed by Redo
+		// online schema changes do not block writes
+		allowed := hasMarkerLine(content, allowUnsafeMarker) || (config.Driver == "mysql" && hasMarkerLine(content, onlineMarker))
+		for index, statement := range splitStatements(content, config.Driver) {
+			code := lintCode(statement)
+			if match := createTablePattern.FindStringSubmatch(code); match != nil {
+				created[normalizeTableName(match[1])] = true
+				continue
+			}
+			if allowed {
+				continue
+			}
+			if reason := unsafeReason(config.Driver, code, created); reason != "" {
+				return fmt.Errorf("%w: %s (statement %d): %s, or annotate the migration with %s", ErrUnsafeMigration, migration.Filename, index+1, reason, allowUnsafeMarker)
+			}
+		}
+	}
+	return nil
+}
+
+// unsafeReason returns why the statement, without its comments, takes a long lock on an existing table,
+// or an empty string
+func unsafeReason(driver string, code string, created map[string]bool) string {
+	if match := alterTablePattern.FindStringSubmatch(code); match != nil {
+		if created[normalizeTableName(match[1])] {
+			return ""
+		}
+		for _, operation := range unsafeAlterTable[driver] {
+			if operation.safeWith != nil && operation.safeWith.MatchString(code) {
+				continue
+			}
+			for _, clause := range splitTopLevel(match[2]) {
+				if operation.pattern.MatchString(clause) {
+					return operation.reason
+				}
+			}
+		}
+		return ""
+	}
+
+	if match := createIndexPattern.FindStringSubmatch(code); match != nil {
+		if created[normalizeTableName(match[2])] {
+			return ""
+		}
+		switch {
+		case driver == "postgres" && match[1] == "":
+			return "creating an index blocks the writes of the table until it is built, use CREATE INDEX CONCURRENTLY"
+		case driver == "sqlserver" && !sqlServerOnlinePattern.MatchString(code):
+			return "creating an index blocks the writes of the table until it is built, add WITH (ONLINE = ON)"
+		}
+		return ""
+	}
+
+	for _, operation := range unsafeStatements[driver] {
+		if operation.pattern.MatchString(code) && (operation.safeWith == nil || !operation.safeWith.MatchString(code)) {
+			return operation.reason
+		}
+	}
+	return ""
+}
+
+// hasMarkerLine reports whether a line of the migration is the annotation marker, e.g. gosmm:allow-unsafe
+func hasMarkerLine(content string, marker string) bool {
+	for _, line := range strings.Split(content, "\n") {
+		if strings.TrimSpace(line) == marker {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeTableName returns the unquoted table name without its schema, in lower case
+func normalizeTableName(name string) string {
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		name = name[i+1:]
+	}
+	return strings.ToLower(strings.Trim(name, "\"`[]"))
+}
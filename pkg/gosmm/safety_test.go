@@ -0,0 +1,82 @@
+package gosmm
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckZeroDowntime(t *testing.T) {
+	dir := t.TempDir()
+	migrations := map[string]string{
+		"v20230101_create_events_00001.sql": "CREATE TABLE events (id INTEGER, kind TEXT);\nCREATE INDEX events_kind ON events (kind);\nALTER TABLE events ALTER COLUMN kind SET NOT NULL;",
+		"v20230102_alter_users_00002.sql":   "-- widen the column\nALTER TABLE users ALTER COLUMN name TYPE TEXT;",
+		"v20230103_index_users_00003.sql":   "-- gosmm:allow-unsafe\nCREATE INDEX users_name ON users (name);",
+	}
+	paths := make(map[string]string)
+	for name, content := range migrations {
+		paths[name] = filepath.Join(dir, name)
+		if err := ioutil.WriteFile(paths[name], []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test migration file: %v", err)
+		}
+	}
+	config := MigrationConfig{Driver: "postgres", ZeroDowntime: true}
+
+	// Operations on tables created by the pending migrations are safe
+	err := checkZeroDowntime(config, paths, []MigrationInfo{{Filename: "v20230101_create_events_00001.sql"}})
+	assert.NoError(t, err)
+
+	err = checkZeroDowntime(config, paths, []MigrationInfo{{Filename: "v20230101_create_events_00001.sql"}, {Filename: "v20230102_alter_users_00002.sql"}})
+	assert.True(t, errors.Is(err, ErrUnsafeMigration))
+	assert.EqualError(t, err, "unsafe migration: v20230102_alter_users_00002.sql (statement 1): changing the type of a column rewrites the table under an ACCESS EXCLUSIVE lock, add a new column and backfill it instead, or annotate the migration with -- gosmm:allow-unsafe")
+
+	// Online schema changes of MySQL do not block writes
+	if err := ioutil.WriteFile(paths["v20230102_alter_users_00002.sql"], []byte("-- gosmm:online\nALTER TABLE users MODIFY COLUMN name TEXT;"), 0644); err != nil {
+		t.Fatalf("Failed to update test migration file: %v", err)
+	}
+	err = checkZeroDowntime(MigrationConfig{Driver: "mysql", ZeroDowntime: true}, paths, []MigrationInfo{{Filename: "v20230102_alter_users_00002.sql"}})
+	assert.NoError(t, err)
+
+	// Annotated migrations are applied anyway
+	err = checkZeroDowntime(config, paths, []MigrationInfo{{Filename: "v20230103_index_users_00003.sql"}})
+	assert.NoError(t, err)
+}
+
+func TestUnsafeReason(t *testing.T) {
+	tests := []struct {
+		driver    string
+		statement string
+		unsafe    bool
+	}{
+		{"postgres", "ALTER TABLE users ALTER COLUMN age SET DATA TYPE bigint", true},
+		{"postgres", "ALTER TABLE users ALTER COLUMN age SET DEFAULT 0", false},
+		{"postgres", "ALTER TABLE users ADD COLUMN age INTEGER DEFAULT 0", false},
+		{"postgres", "ALTER TABLE posts ADD CONSTRAINT posts_user FOREIGN KEY (user_id) REFERENCES users (id)", true},
+		{"postgres", "ALTER TABLE posts ADD CONSTRAINT posts_user FOREIGN KEY (user_id) REFERENCES users (id) NOT VALID", false},
+		{"postgres", "ALTER TABLE users ADD CONSTRAINT users_email UNIQUE USING INDEX users_email_idx", false},
+		{"postgres", "CREATE INDEX users_email ON public.users (email)", true},
+		{"postgres", "CREATE INDEX CONCURRENTLY users_email ON users (email)", false},
+		{"postgres", "VACUUM FULL users", true},
+		{"postgres", "REINDEX INDEX CONCURRENTLY users_email", false},
+		{"mysql", "ALTER TABLE users MODIFY COLUMN name VARCHAR(500)", true},
+		{"mysql", "ALTER TABLE users MODIFY COLUMN name VARCHAR(500), ALGORITHM=INPLACE, LOCK=NONE", false},
+		{"mysql", "ALTER TABLE users ADD COLUMN age INT, ALGORITHM=COPY", true},
+		{"mysql", "ALTER TABLE users ADD COLUMN age INT", false},
+		{"mysql", "ALTER TABLE users CONVERT TO CHARACTER SET utf8mb4", true},
+		{"sqlserver", "ALTER TABLE users ALTER COLUMN name NVARCHAR(500)", true},
+		{"sqlserver", "ALTER TABLE posts WITH NOCHECK ADD CONSTRAINT posts_user FOREIGN KEY (user_id) REFERENCES users (id)", false},
+		{"sqlserver", "CREATE INDEX users_email ON users (email) WITH (ONLINE = ON)", false},
+		{"sqlserver", "ALTER INDEX users_email ON users REBUILD", true},
+		{"sqlite3", "ALTER TABLE users DROP COLUMN name", false},
+	}
+	for _, test := range tests {
+		reason := unsafeReason(test.driver, lintCode(test.statement), map[string]bool{})
+		assert.Equal(t, test.unsafe, reason != "", test.statement)
+	}
+
+	// Tables created in the same run are empty
+	assert.Equal(t, "", unsafeReason("postgres", `ALTER TABLE "Users" ALTER COLUMN age TYPE bigint`, map[string]bool{"users": true}))
+}
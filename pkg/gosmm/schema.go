@@ -0,0 +1,499 @@
+package gosmm
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// SchemaObjectKind is the kind of a SchemaObject
+type SchemaObjectKind string
+
+const (
+	// SchemaTable is a table with its columns and constraints
+	SchemaTable SchemaObjectKind = "table"
+	// SchemaIndex is an index that does not back a constraint
+	SchemaIndex SchemaObjectKind = "index"
+)
+
+const (
+	// schemaHeader is the first line of a dump, with the latest applied migration
+	schemaHeader = "-- Schema after %s, generated by gosmm. Do not edit."
+	// noSchemaVersion is the version of the dump of a database without applied migrations
+	noSchemaVersion = "no migration"
+)
+
+// mysqlAutoIncrementPattern matches the AUTO_INCREMENT counter of SHOW CREATE TABLE, which changes with the data
+var mysqlAutoIncrementPattern = regexp.MustCompile(` AUTO_INCREMENT=\d+`)
+
+// SchemaObject is a table or an index of a schema, see InspectSchema
+type SchemaObject struct {
+	Kind SchemaObjectKind
+	// Name is the name of the table or index
+	Name string
+	// Table is the table of an index, and the name of a table
+	Table string
+	// Definition is the statement creating the object, without the trailing semicolon
+	Definition string
+}
+
+// key identifies the object in a schema dump, e.g. "table: users" or "index: users.users_email"
+func (o SchemaObject) key() string {
+	if o.Kind == SchemaIndex {
+		return fmt.Sprintf("%s: %s.%s", o.Kind, o.Table, o.Name)
+	}
+	return fmt.Sprintf("%s: %s", o.Kind, o.Name)
+}
+
+// InspectSchema returns the tables of the configured schema (the connection's default schema when empty),
+// with their columns and constraints, followed by their indexes, in the order of the table names.
+// The definitions are built with introspection queries of the driver, so they are not necessarily the
+// statements of the migrations. The tables of gosmm, such as the history table, are left out.
+func InspectSchema(db *sql.DB, config MigrationConfig) ([]SchemaObject, error) {
+	ctx := context.Background()
+	tables := make(schemaTables)
+	var err error
+	switch config.Driver {
+	case "postgres":
+		err = inspectPostgresSchema(ctx, db, config.Schema, tables)
+	case "mysql":
+		err = inspectMySQLSchema(ctx, db, config.Schema, tables)
+	case "sqlite3":
+		err = inspectSQLiteSchema(ctx, db, config.Schema, tables)
+	case "sqlserver":
+		err = inspectSQLServerSchema(ctx, db, config.Schema, tables)
+	default:
+		return nil, fmt.Errorf("unsupported driver: %s", config.Driver)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect schema: %w", err)
+	}
+	return tables.objects(), nil
+}
+
+// DumpSchema writes the statements creating the tables and indexes of the schema to w, preceded by the latest
+// applied migration, see InspectSchema. It is written to MigrationConfig.SchemaFile after every migration run.
+func DumpSchema(db *sql.DB, config MigrationConfig, w io.Writer) error {
+	objects, err := InspectSchema(db, config)
+	if err != nil {
+		return err
+	}
+	version, err := schemaVersion(db, config)
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, schemaHeader+"\n", version)
+	for _, object := range objects {
+		fmt.Fprintf(&b, "\n-- %s\n%s;\n", object.key(), object.Definition)
+	}
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// schemaVersion returns the latest applied migration, which the schema of a dump results from
+func schemaVersion(db *sql.DB, config MigrationConfig) (string, error) {
+	exists, err := historyTableExists(db, config.Driver, config.Schema)
+	if err != nil {
+		return "", fmt.Errorf("failed to check history table: %w", err)
+	}
+	if !exists {
+		return noSchemaVersion, nil
+	}
+	last, err := getLastSuccessfulMigrationFile(db, config, historyTableName(config.Driver, config.Schema))
+	if err != nil {
+		return "", err
+	}
+	if last == "" {
+		return noSchemaVersion, nil
+	}
+	return last, nil
+}
+
+// writeSchemaFile dumps the schema to config.SchemaFile, replacing the file only once the dump is complete
+func writeSchemaFile(db *sql.DB, config MigrationConfig) error {
+	var b strings.Builder
+	if err := DumpSchema(db, config, &b); err != nil {
+		return err
+	}
+	tmp := config.SchemaFile + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, config.SchemaFile)
+}
+
+// schemaTables collects the tables of a schema by name while it is inspected
+type schemaTables map[string]*schemaTable
+
+// schemaTable is a table being inspected. Drivers returning the statement creating the table set definition,
+// the others set columns and constraints.
+type schemaTable struct {
+	quotedName  string
+	definition  string
+	columns     []string
+	constraints []string
+	indexes     []SchemaObject
+}
+
+// table returns the table of the given name, adding it when it was not seen yet
+func (t schemaTables) table(name string, quotedName string) *schemaTable {
+	table, ok := t[name]
+	if !ok {
+		table = &schemaTable{quotedName: quotedName}
+		t[name] = table
+	}
+	return table
+}
+
+// objects returns the tables followed by their indexes, in the order of the table names, without the gosmm tables
+func (t schemaTables) objects() []SchemaObject {
+	names := make([]string, 0, len(t))
+	for name := range t {
+		switch name {
+		case migrationHistoryTable, historyVersionTable, seedHistoryTable, migrationLockTable, batchCheckpointTable, historyPrunedTable:
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	objects := make([]SchemaObject, 0, len(names))
+	for _, name := range names {
+		table := t[name]
+		definition := table.definition
+		if definition == "" {
+			definition = "CREATE TABLE " + table.quotedName + " (\n    " + strings.Join(append(table.columns, table.constraints...), ",\n    ") + "\n)"
+		}
+		objects = append(objects, SchemaObject{Kind: SchemaTable, Name: name, Table: name, Definition: definition})
+		sort.Slice(table.indexes, func(i, j int) bool { return table.indexes[i].Name < table.indexes[j].Name })
+		objects = append(objects, table.indexes...)
+	}
+	return objects
+}
+
+// inspectPostgresSchema inspects the tables of the schema with the pg_catalog functions printing definitions
+func inspectPostgresSchema(ctx context.Context, db *sql.DB, schema string, tables schemaTables) error {
+	rows, err := db.QueryContext(ctx, `SELECT c.relname, quote_ident(c.relname), quote_ident(a.attname), format_type(a.atttypid, a.atttypmod), a.attnotnull, COALESCE(pg_get_expr(d.adbin, d.adrelid), '')
+		FROM pg_attribute a
+		JOIN pg_class c ON c.oid = a.attrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		LEFT JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+		WHERE n.nspname = COALESCE(NULLIF($1, ''), current_schema()) AND c.relkind IN ('r', 'p') AND a.attnum > 0 AND NOT a.attisdropped
+		ORDER BY c.relname, a.attnum`, schema)
+	if err != nil {
+		return err
+	}
+	err = scanRows(rows, func() error {
+		var table, quotedTable, column, columnType, defaultValue string
+		var notNull bool
+		if err := rows.Scan(&table, &quotedTable, &column, &columnType, &notNull, &defaultValue); err != nil {
+			return err
+		}
+		t := tables.table(table, quotedTable)
+		t.columns = append(t.columns, columnDefinition(column, columnType, !notNull, defaultValue))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	rows, err = db.QueryContext(ctx, `SELECT c.relname, quote_ident(c.relname), quote_ident(con.conname), pg_get_constraintdef(con.oid)
+		FROM pg_constraint con
+		JOIN pg_class c ON c.oid = con.conrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE n.nspname = COALESCE(NULLIF($1, ''), current_schema()) AND c.relkind IN ('r', 'p') AND con.contype IN ('p', 'u', 'f', 'c', 'x')
+		ORDER BY c.relname, con.conname`, schema)
+	if err != nil {
+		return err
+	}
+	err = scanRows(rows, func() error {
+		var table, quotedTable, name, definition string
+		if err := rows.Scan(&table, &quotedTable, &name, &definition); err != nil {
+			return err
+		}
+		t := tables.table(table, quotedTable)
+		t.constraints = append(t.constraints, "CONSTRAINT "+name+" "+definition)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// the indexes backing primary key, unique and exclusion constraints are part of the constraints
+	rows, err = db.QueryContext(ctx, `SELECT c.relname, quote_ident(c.relname), i.relname, pg_get_indexdef(x.indexrelid), quote_ident(n.nspname)
+		FROM pg_index x
+		JOIN pg_class i ON i.oid = x.indexrelid
+		JOIN pg_class c ON c.oid = x.indrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE n.nspname = COALESCE(NULLIF($1, ''), current_schema()) AND c.relkind IN ('r', 'p')
+			AND NOT EXISTS (SELECT 1 FROM pg_constraint con WHERE con.conindid = x.indexrelid AND con.contype IN ('p', 'u', 'x'))`, schema)
+	if err != nil {
+		return err
+	}
+	return scanRows(rows, func() error {
+		var table, quotedTable, name, definition, quotedSchema string
+		if err := rows.Scan(&table, &quotedTable, &name, &definition, &quotedSchema); err != nil {
+			return err
+		}
+		// the definitions do not depend on the schema, e.g. of a tenant
+		definition = strings.Replace(definition, " ON "+quotedSchema+".", " ON ", 1)
+		definition = strings.Replace(definition, " ON ONLY "+quotedSchema+".", " ON ONLY ", 1)
+		t := tables.table(table, quotedTable)
+		t.indexes = append(t.indexes, SchemaObject{Kind: SchemaIndex, Name: name, Table: table, Definition: definition})
+		return nil
+	})
+}
+
+// inspectMySQLSchema inspects the tables of the schema with SHOW CREATE TABLE, which includes their indexes
+func inspectMySQLSchema(ctx context.Context, db *sql.DB, schema string, tables schemaTables) error {
+	rows, err := db.QueryContext(ctx, `SELECT table_name FROM information_schema.tables WHERE table_type = 'BASE TABLE' AND table_schema = COALESCE(NULLIF(?, ''), DATABASE())`, schema)
+	if err != nil {
+		return err
+	}
+	var names []string
+	err = scanRows(rows, func() error {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		names = append(names, name)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		qualified := quoteIdentifier("mysql", name)
+		if schema != "" {
+			qualified = quoteIdentifier("mysql", schema) + "." + qualified
+		}
+		var table, definition string
+		if err := db.QueryRowContext(ctx, `SHOW CREATE TABLE `+qualified).Scan(&table, &definition); err != nil {
+			return err
+		}
+		tables.table(name, quoteIdentifier("mysql", name)).definition = mysqlAutoIncrementPattern.ReplaceAllString(definition, "")
+	}
+	return nil
+}
+
+// inspectSQLiteSchema inspects the tables of the schema with the statements recorded in sqlite_master
+func inspectSQLiteSchema(ctx context.Context, db *sql.DB, schema string, tables schemaTables) error {
+	master := "sqlite_master"
+	if schema != "" {
+		master = quoteIdentifier("sqlite3", schema) + "." + master
+	}
+	// indexes created implicitly for constraints have no statement
+	rows, err := db.QueryContext(ctx, `SELECT type, name, tbl_name, sql FROM `+master+` WHERE type IN ('table', 'index') AND sql IS NOT NULL AND name NOT LIKE 'sqlite_%'`)
+	if err != nil {
+		return err
+	}
+	return scanRows(rows, func() error {
+		var kind, name, table, definition string
+		if err := rows.Scan(&kind, &name, &table, &definition); err != nil {
+			return err
+		}
+		t := tables.table(table, quoteIdentifier("sqlite3", table))
+		if kind == "table" {
+			t.definition = definition
+		} else {
+			t.indexes = append(t.indexes, SchemaObject{Kind: SchemaIndex, Name: name, Table: table, Definition: definition})
+		}
+		return nil
+	})
+}
+
+// inspectSQLServerSchema inspects the tables of the schema with the sys catalog views, since SQL Server
+// has no function printing their definitions
+func inspectSQLServerSchema(ctx context.Context, db *sql.DB, schema string, tables schemaTables) error {
+	const inSchema = `SCHEMA_NAME(t.schema_id) = COALESCE(NULLIF(@p1, ''), SCHEMA_NAME())`
+	rows, err := db.QueryContext(ctx, `SELECT t.name, c.name, TYPE_NAME(c.user_type_id), c.max_length, c.precision, c.scale, c.is_nullable, c.is_identity, COALESCE(OBJECT_DEFINITION(c.default_object_id), '')
+		FROM sys.columns c
+		JOIN sys.tables t ON t.object_id = c.object_id
+		WHERE `+inSchema+`
+		ORDER BY t.name, c.column_id`, schema)
+	if err != nil {
+		return err
+	}
+	err = scanRows(rows, func() error {
+		var table, column, columnType, defaultValue string
+		var maxLength, precision, scale int
+		var nullable, identity bool
+		if err := rows.Scan(&table, &column, &columnType, &maxLength, &precision, &scale, &nullable, &identity, &defaultValue); err != nil {
+			return err
+		}
+		columnType = sqlServerColumnType(columnType, maxLength, precision, scale)
+		if identity {
+			columnType += " IDENTITY"
+		}
+		t := tables.table(table, quoteIdentifier("sqlserver", table))
+		t.columns = append(t.columns, columnDefinition(quoteIdentifier("sqlserver", column), columnType, nullable, defaultValue))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// key constraints and foreign keys have a row per column, grouped by groupedRows
+	rows, err = db.QueryContext(ctx, `SELECT t.name, k.name, CASE k.type WHEN 'PK' THEN 'PRIMARY KEY' ELSE 'UNIQUE' END, col.name, ''
+		FROM sys.key_constraints k
+		JOIN sys.tables t ON t.object_id = k.parent_object_id
+		JOIN sys.index_columns ic ON ic.object_id = k.parent_object_id AND ic.index_id = k.unique_index_id
+		JOIN sys.columns col ON col.object_id = ic.object_id AND col.column_id = ic.column_id
+		WHERE `+inSchema+`
+		ORDER BY t.name, k.name, ic.key_ordinal`, schema)
+	if err != nil {
+		return err
+	}
+	err = groupedRows(rows, func(table, name, kind string, columns, _ []string) {
+		t := tables.table(table, quoteIdentifier("sqlserver", table))
+		t.constraints = append(t.constraints, fmt.Sprintf("CONSTRAINT %s %s (%s)", quoteIdentifier("sqlserver", name), kind, strings.Join(columns, ", ")))
+	})
+	if err != nil {
+		return err
+	}
+
+	rows, err = db.QueryContext(ctx, `SELECT t.name, fk.name, rt.name, pc.name, rc.name
+		FROM sys.foreign_keys fk
+		JOIN sys.tables t ON t.object_id = fk.parent_object_id
+		JOIN sys.foreign_key_columns fkc ON fkc.constraint_object_id = fk.object_id
+		JOIN sys.columns pc ON pc.object_id = fkc.parent_object_id AND pc.column_id = fkc.parent_column_id
+		JOIN sys.tables rt ON rt.object_id = fkc.referenced_object_id
+		JOIN sys.columns rc ON rc.object_id = fkc.referenced_object_id AND rc.column_id = fkc.referenced_column_id
+		WHERE `+inSchema+`
+		ORDER BY t.name, fk.name, fkc.constraint_column_id`, schema)
+	if err != nil {
+		return err
+	}
+	err = groupedRows(rows, func(table, name, referenced string, columns, referencedColumns []string) {
+		t := tables.table(table, quoteIdentifier("sqlserver", table))
+		t.constraints = append(t.constraints, fmt.Sprintf("CONSTRAINT %s FOREIGN KEY (%s) REFERENCES %s (%s)",
+			quoteIdentifier("sqlserver", name), strings.Join(columns, ", "), quoteIdentifier("sqlserver", referenced), strings.Join(referencedColumns, ", ")))
+	})
+	if err != nil {
+		return err
+	}
+
+	rows, err = db.QueryContext(ctx, `SELECT t.name, cc.name, cc.definition
+		FROM sys.check_constraints cc
+		JOIN sys.tables t ON t.object_id = cc.parent_object_id
+		WHERE `+inSchema+`
+		ORDER BY t.name, cc.name`, schema)
+	if err != nil {
+		return err
+	}
+	err = scanRows(rows, func() error {
+		var table, name, definition string
+		if err := rows.Scan(&table, &name, &definition); err != nil {
+			return err
+		}
+		t := tables.table(table, quoteIdentifier("sqlserver", table))
+		t.constraints = append(t.constraints, fmt.Sprintf("CONSTRAINT %s CHECK %s", quoteIdentifier("sqlserver", name), definition))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// the indexes backing primary key and unique constraints are part of the constraints
+	rows, err = db.QueryContext(ctx, `SELECT t.name, i.name, CASE WHEN i.is_unique = 1 THEN 'UNIQUE ' ELSE '' END + i.type_desc, col.name, CASE WHEN ic.is_descending_key = 1 THEN ' DESC' ELSE '' END
+		FROM sys.indexes i
+		JOIN sys.tables t ON t.object_id = i.object_id
+		JOIN sys.index_columns ic ON ic.object_id = i.object_id AND ic.index_id = i.index_id AND ic.is_included_column = 0
+		JOIN sys.columns col ON col.object_id = ic.object_id AND col.column_id = ic.column_id
+		WHERE `+inSchema+` AND i.is_primary_key = 0 AND i.is_unique_constraint = 0 AND i.type > 0
+		ORDER BY t.name, i.name, ic.key_ordinal`, schema)
+	if err != nil {
+		return err
+	}
+	return groupedRows(rows, func(table, name, kind string, columns, orders []string) {
+		for i := range columns {
+			columns[i] += orders[i]
+		}
+		t := tables.table(table, quoteIdentifier("sqlserver", table))
+		t.indexes = append(t.indexes, SchemaObject{
+			Kind:       SchemaIndex,
+			Name:       name,
+			Table:      table,
+			Definition: fmt.Sprintf("CREATE %s INDEX %s ON %s (%s)", kind, quoteIdentifier("sqlserver", name), quoteIdentifier("sqlserver", table), strings.Join(columns, ", ")),
+		})
+	})
+}
+
+// sqlServerColumnType returns the type of a column with its length, precision or scale
+func sqlServerColumnType(columnType string, maxLength int, precision int, scale int) string {
+	switch columnType {
+	case "varchar", "char", "varbinary", "binary", "nvarchar", "nchar":
+		if maxLength == -1 {
+			return columnType + "(max)"
+		}
+		if strings.HasPrefix(columnType, "n") {
+			maxLength /= 2 // max_length is in bytes
+		}
+		return fmt.Sprintf("%s(%d)", columnType, maxLength)
+	case "decimal", "numeric":
+		return fmt.Sprintf("%s(%d, %d)", columnType, precision, scale)
+	case "datetime2", "datetimeoffset", "time":
+		return fmt.Sprintf("%s(%d)", columnType, scale)
+	default:
+		return columnType
+	}
+}
+
+// columnDefinition returns the definition of a column in a CREATE TABLE statement
+func columnDefinition(quotedName string, columnType string, nullable bool, defaultValue string) string {
+	definition := quotedName + " " + columnType
+	if !nullable {
+		definition += " NOT NULL"
+	}
+	if defaultValue != "" {
+		definition += " DEFAULT " + defaultValue
+	}
+	return definition
+}
+
+// scanRows calls scan for every row and closes rows
+func scanRows(rows *sql.Rows, scan func() error) error {
+	defer rows.Close()
+	for rows.Next() {
+		if err := scan(); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// groupedRows reads rows of (table, name, detail, value, other value) ordered by table and name, and calls add
+// once per table and name with the values of its rows, e.g. the columns of a constraint
+func groupedRows(rows *sql.Rows, add func(table, name, detail string, values, others []string)) error {
+	var table, name, detail string
+	var values, others []string
+	err := scanRows(rows, func() error {
+		var rowTable, rowName, rowDetail, value, other string
+		if err := rows.Scan(&rowTable, &rowName, &rowDetail, &value, &other); err != nil {
+			return err
+		}
+		if values != nil && (rowTable != table || rowName != name) {
+			add(table, name, detail, values, others)
+			values, others = nil, nil
+		}
+		table, name, detail = rowTable, rowName, rowDetail
+		values = append(values, quoteIdentifier("sqlserver", value))
+		others = append(others, other)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if values != nil {
+		add(table, name, detail, values, others)
+	}
+	return nil
+}
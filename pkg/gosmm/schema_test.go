@@ -0,0 +1,73 @@
+package gosmm
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInspectSchema(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	migrations := map[string]string{
+		"v20230101_create_users_00001.sql": "CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT UNIQUE);",
+		"v20230102_create_posts_00002.sql": "CREATE TABLE posts (id INTEGER PRIMARY KEY, user_id INTEGER REFERENCES users (id));\nCREATE INDEX posts_user_id ON posts (user_id);",
+	}
+	for name, content := range migrations {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test migration file: %v", err)
+		}
+	}
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}
+	assert.NoError(t, MigrateWithConfig(db, config))
+
+	// The gosmm tables and the implicit indexes of constraints are left out
+	objects, err := InspectSchema(db, config)
+	assert.NoError(t, err)
+	assert.Equal(t, []SchemaObject{
+		{Kind: SchemaTable, Name: "posts", Table: "posts", Definition: "CREATE TABLE posts (id INTEGER PRIMARY KEY, user_id INTEGER REFERENCES users (id))"},
+		{Kind: SchemaIndex, Name: "posts_user_id", Table: "posts", Definition: "CREATE INDEX posts_user_id ON posts (user_id)"},
+		{Kind: SchemaTable, Name: "users", Table: "users", Definition: "CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT UNIQUE)"},
+	}, objects)
+
+	_, err = InspectSchema(db, MigrationConfig{Driver: "oracle"})
+	assert.EqualError(t, err, "unsupported driver: oracle")
+}
+
+func TestDumpSchema(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	var b strings.Builder
+	config := MigrationConfig{Driver: "sqlite3"}
+	assert.NoError(t, DumpSchema(db, config, &b))
+	assert.Equal(t, "-- Schema after no migration, generated by gosmm. Do not edit.\n", b.String())
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER);\nCREATE INDEX users_id ON users (id);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	config = MigrationConfig{MigrationsDir: dir, Driver: "sqlite3", SchemaFile: filepath.Join(t.TempDir(), "schema.sql")}
+	assert.NoError(t, MigrateWithConfig(db, config))
+
+	// The run writes the schema file
+	schema, err := ioutil.ReadFile(config.SchemaFile)
+	assert.NoError(t, err)
+	assert.Equal(t, `-- Schema after v20230101_create_users_00001.sql, generated by gosmm. Do not edit.
+
+-- table: users
+CREATE TABLE users (id INTEGER);
+
+-- index: users.users_id
+CREATE INDEX users_id ON users (id);
+`, string(schema))
+
+	// A failure to write the schema file does not fail the run
+	config.SchemaFile = filepath.Join(t.TempDir(), "missing", "schema.sql")
+	assert.NoError(t, MigrateWithConfig(db, config))
+}
@@ -0,0 +1,166 @@
+package gosmm
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// scratchPrefix starts the names of the schemas and databases created by WithScratchDatabase
+const scratchPrefix = "gosmm_scratch_"
+
+// SchemaAt replays the migrations up to version, included, on the empty database db, then writes the resulting
+// schema to w like DumpSchema, e.g. to inspect the schema of a past release. version is the filename of a
+// migration, its name without .sql or its version, e.g. v20230101_00001 or the version of FilenamePattern.
+// The hooks, notifications, backups and schema file of config are ignored. See WithScratchDatabase for a
+// database to replay the migrations on.
+func SchemaAt(db *sql.DB, config MigrationConfig, version string, w io.Writer) error {
+	history, err := GetHistory(db, config)
+	if err != nil {
+		return err
+	}
+	if len(history) > 0 {
+		return fmt.Errorf("the database to replay the migrations on already holds %d migration(s)", len(history))
+	}
+
+	naming, err := config.naming()
+	if err != nil {
+		return err
+	}
+	allFiles, err := config.migrationFiles()
+	if err != nil {
+		return err
+	}
+	var files []migrationFile
+	for _, file := range allFiles {
+		if file.inEnvironment(config.Environment) {
+			files = append(files, file)
+		}
+	}
+	names, err := migrationNames(files, config.GoMigrations, naming)
+	if err != nil {
+		return err
+	}
+	last := -1
+	for i, name := range names {
+		if v, _ := naming.version(name); name == version || strings.TrimSuffix(name, sqlFileExtension) == version || v == version {
+			last = i
+			break
+		}
+	}
+	if last < 0 {
+		return fmt.Errorf("no migration %s", version)
+	}
+
+	replay := MigrationConfig{
+		MigrationsDir:   config.MigrationsDir,
+		MigrationsDirs:  config.MigrationsDirs,
+		FilenamePattern: config.FilenamePattern,
+		Environment:     config.Environment,
+		Driver:          config.Driver,
+		AllowOutOfOrder: true,
+		Skip:            append(append([]string{}, config.Skip...), names[last+1:]...),
+		Placeholders:    config.Placeholders,
+		TemplateData:    config.TemplateData,
+		Schema:          config.Schema,
+		GoMigrations:    config.GoMigrations,
+		LogLevel:        config.LogLevel,
+	}
+	if err := MigrateWithConfig(db, replay); err != nil {
+		return fmt.Errorf("failed to replay the migrations up to %s: %w", names[last], err)
+	}
+	return DumpSchema(db, replay, w)
+}
+
+// WithScratchDatabase runs fn with an empty database of the driver of dbConfig, dropped when fn returns:
+// a temporary SQLite file, a schema of the database of dbConfig on Postgres, whose name fn receives in
+// config.Schema, and a database next to the one of dbConfig on MySQL and SQL Server, which requires the
+// privilege to create databases. Unqualified names of the migrations resolve to the scratch schema or database.
+func WithScratchDatabase(dbConfig DBConfig, config MigrationConfig, fn func(db *sql.DB, config MigrationConfig) error) (err error) {
+	config.Driver = dbConfig.Driver
+	// the scratch database is created on the node dbConfig connects to, failing on a read replica
+	dbConfig.PrimaryDSN = ""
+	name := scratchPrefix + strconv.FormatInt(time.Now().UnixNano(), 36)
+	switch dbConfig.Driver {
+	case "sqlite3":
+		dir, err := os.MkdirTemp("", "gosmm-scratch-")
+		if err != nil {
+			return fmt.Errorf("failed to create scratch database: %w", err)
+		}
+		defer os.RemoveAll(dir)
+		return WithConnection(DBConfig{Driver: "sqlite3", DSN: filepath.Join(dir, "scratch.db")}, func(db *sql.DB) error {
+			return fn(db, config)
+		})
+	case "postgres":
+		return WithConnection(dbConfig, func(db *sql.DB) (err error) {
+			schema := quoteIdentifier("postgres", name)
+			if _, err := db.Exec("CREATE SCHEMA " + schema); err != nil {
+				return fmt.Errorf("failed to create scratch schema: %w", err)
+			}
+			defer func() {
+				if _, dropErr := db.Exec("DROP SCHEMA " + schema + " CASCADE"); dropErr != nil && err == nil {
+					err = fmt.Errorf("failed to drop scratch schema %s: %w", name, dropErr)
+				}
+			}()
+			config.Schema = name
+			return fn(db, config)
+		})
+	case "mysql", "sqlserver":
+		scratch, err := scratchDBConfig(dbConfig, name)
+		if err != nil {
+			return err
+		}
+		return WithConnection(dbConfig, func(db *sql.DB) (err error) {
+			database := quoteIdentifier(dbConfig.Driver, name)
+			if _, err := db.Exec("CREATE DATABASE " + database); err != nil {
+				return fmt.Errorf("failed to create scratch database: %w", err)
+			}
+			defer func() {
+				if _, dropErr := db.Exec("DROP DATABASE " + database); dropErr != nil && err == nil {
+					err = fmt.Errorf("failed to drop scratch database %s: %w", name, dropErr)
+				}
+			}()
+			// the connections to the scratch database are closed before it is dropped
+			return WithConnection(scratch, func(db *sql.DB) error {
+				return fn(db, config)
+			})
+		})
+	default:
+		return fmt.Errorf("unsupported driver: %s", dbConfig.Driver)
+	}
+}
+
+// scratchDBConfig returns dbConfig connecting to the database name instead of its own
+func scratchDBConfig(dbConfig DBConfig, name string) (DBConfig, error) {
+	if dbConfig.DSN == "" {
+		dbConfig.DBName = name
+		return dbConfig, nil
+	}
+	switch dbConfig.Driver {
+	case "mysql":
+		parsed, err := mysql.ParseDSN(dbConfig.DSN)
+		if err != nil {
+			return DBConfig{}, fmt.Errorf("invalid mysql DSN: %w", err)
+		}
+		parsed.DBName = name
+		dbConfig.DSN = parsed.FormatDSN()
+	default:
+		parsed, err := url.Parse(dbConfig.DSN)
+		if err != nil || parsed.Scheme != "sqlserver" {
+			return DBConfig{}, fmt.Errorf("a scratch database requires a sqlserver:// DSN")
+		}
+		query := parsed.Query()
+		query.Set("database", name)
+		parsed.RawQuery = query.Encode()
+		dbConfig.DSN = parsed.String()
+	}
+	return dbConfig, nil
+}
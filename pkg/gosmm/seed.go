@@ -0,0 +1,186 @@
+package gosmm
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+)
+
+const seedHistoryTable = "gosmm_seed_history"
+
+// seedTableName returns the seed history table name, qualified with the schema if one is given
+func seedTableName(driver string, schema string) string {
+	if schema == "" {
+		return seedHistoryTable
+	}
+	return quoteIdentifier(driver, schema) + "." + seedHistoryTable
+}
+
+// Seed applies the seed files in config.SeedsDir in filename order. Unlike migrations, seeds load
+// reference data and must be idempotent (e.g. upserts): a seed is applied again whenever its
+// checksum changes, and a failed seed is not recorded, so it is simply retried by the next run.
+// Subdirectories of SeedsDir are environment directories, as for migrations.
+func Seed(db *sql.DB, config MigrationConfig) error {
+	if config.SeedsDir == "" {
+		return errors.New("no seeds directory configured")
+	}
+	if !isSupportedDriver(config.Driver) {
+		return fmt.Errorf("unsupported driver: %s", config.Driver)
+	}
+
+	cockroach, err := isCockroachDB(db, config.Driver)
+	if err != nil {
+		return err
+	}
+	table := seedTableName(config.Driver, config.Schema)
+	release, err := lockRun(context.Background(), db, config, table, cockroach, nil)
+	if err != nil {
+		return fmt.Errorf("failed to acquire seed lock: %w", err)
+	}
+	defer release()
+
+	if err := createSeedHistoryTable(db, config.Driver, config.Schema); err != nil {
+		return fmt.Errorf("failed to create seed history table: %w", err)
+	}
+	applied, err := getSeedChecksums(db, table)
+	if err != nil {
+		return fmt.Errorf("failed to load seed history: %w", err)
+	}
+
+	files, err := readMigrationFiles([]string{config.SeedsDir}, config.listing())
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if file.isDir || !file.inEnvironment(config.Environment) {
+			continue
+		}
+		if filepath.Ext(file.name) != sqlFileExtension {
+			return fmt.Errorf("invalid file extension: %s", file.name)
+		}
+
+		checksum, open, err := seedStatements(config, file)
+		if err != nil {
+			return err
+		}
+		if applied[file.name] == checksum {
+			continue // unchanged since it was last applied
+		}
+		if err := applySeed(db, config, table, file.name, open, checksum); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seedStatements returns the checksum of the seed file and the function opening the stream of its statements,
+// which reads the file as they are executed when it is streamed, see MigrationConfig.StreamThreshold
+func seedStatements(config MigrationConfig, file migrationFile) (string, func() (statementStream, error), error) {
+	if _, ok := config.streamedFileSize(file.path); ok {
+		checksum, _, err := config.scanStreamedFile(file.path)
+		if err != nil {
+			return "", nil, err
+		}
+		if checksum, err = loadsChecksum(checksum, file.path); err != nil {
+			return "", nil, err
+		}
+		return checksum, func() (statementStream, error) {
+			return openStatementReader(file.path, config.Driver, config.Placeholders)
+		}, nil
+	}
+
+	data, err := ioutil.ReadFile(file.path)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	checksum, err := config.checksum(data)
+	if err != nil {
+		return "", nil, err
+	}
+	if checksum, err = loadsChecksum(checksum, file.path); err != nil {
+		return "", nil, err
+	}
+	return checksum, func() (statementStream, error) {
+		content, err := replacePlaceholders(string(data), config.Placeholders)
+		if err != nil {
+			return nil, fmt.Errorf("failed to replace placeholders in %s: %w", file.name, err)
+		}
+		return &sliceStream{statements: resolveLoads(splitStatements(content, config.Driver), filepath.Dir(file.path))}, nil
+	}, nil
+}
+
+// applySeed executes the statements of a seed file in a transaction and records its checksum in the seed history
+// table
+func applySeed(db *sql.DB, config MigrationConfig, table string, filename string, open func() (statementStream, error), checksum string) error {
+	ctx := context.Background()
+	startTime := time.Now()
+	// the connection of the transaction runs the bulk loads of pgx, see executeLoad
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	if err := setSearchPath(tx, config.Driver, config.Schema); err != nil {
+		tx.Rollback()
+		return err
+	}
+	execute := executeStatementStream(filename, open, 0, config.Driver, false, nil, config.LogLevel.statementLogger(filename))
+	if err := execute(ctx, conn, tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM `+table+` WHERE filename = `+bindParams(config.Driver, 1), filename); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to record seed %s: %w", filename, err)
+	}
+	_, err = tx.Exec(`INSERT INTO `+table+` (filename, checksum, applied_on, execution_time) VALUES (`+bindParams(config.Driver, 4)+`)`,
+		filename, checksum, startTime, time.Since(startTime).Milliseconds())
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to record seed %s: %w", filename, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	config.LogLevel.printf(LogInfo, "OK    %s\n", filename)
+	return nil
+}
+
+// createSeedHistoryTable creates the seed history table (and its schema) if it doesn't exist
+func createSeedHistoryTable(db *sql.DB, driver string, schema string) error {
+	if ddl := createSchemaDDL(driver, schema); schema != "" && ddl != "" {
+		if _, err := db.Exec(ddl); err != nil {
+			return fmt.Errorf("failed to create schema: %w", err)
+		}
+	}
+	_, err := db.Exec(seedHistoryTableDDL(driver, seedTableName(driver, schema)))
+	return err
+}
+
+// getSeedChecksums returns the checksums of the applied seeds keyed by filename
+func getSeedChecksums(db *sql.DB, table string) (map[string]string, error) {
+	rows, err := db.Query(`SELECT filename, checksum FROM ` + table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	checksums := make(map[string]string)
+	for rows.Next() {
+		var filename, checksum string
+		if err := rows.Scan(&filename, &checksum); err != nil {
+			return nil, err
+		}
+		checksums[filename] = checksum
+	}
+	return checksums, rows.Err()
+}
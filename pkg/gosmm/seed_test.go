@@ -0,0 +1,122 @@
+package gosmm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeed(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	_, err := db.Exec("CREATE TABLE countries (code TEXT PRIMARY KEY, name TEXT)")
+	if err != nil {
+		t.Fatalf("Failed to create countries table: %v", err)
+	}
+	_, err = db.Exec("CREATE TABLE seed_runs (id INTEGER)")
+	if err != nil {
+		t.Fatalf("Failed to create seed_runs table: %v", err)
+	}
+
+	seedsDir := t.TempDir()
+	countriesFile := filepath.Join(seedsDir, "countries.sql")
+	if err := ioutil.WriteFile(countriesFile, []byte("INSERT OR REPLACE INTO countries VALUES ('JP', 'Japan'); INSERT INTO seed_runs VALUES (1);"), 0644); err != nil {
+		t.Fatalf("Failed to create seed file: %v", err)
+	}
+	config := MigrationConfig{SeedsDir: seedsDir, Driver: "sqlite3"}
+
+	err = Seed(db, config)
+	assert.NoError(t, err)
+
+	// An unchanged seed is not applied again
+	err = Seed(db, config)
+	assert.NoError(t, err)
+	var runs int
+	err = db.QueryRow("SELECT COUNT(*) FROM seed_runs").Scan(&runs)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, runs)
+
+	// A changed seed is applied again
+	if err := ioutil.WriteFile(countriesFile, []byte("INSERT OR REPLACE INTO countries VALUES ('JP', 'Nippon'); INSERT INTO seed_runs VALUES (2);"), 0644); err != nil {
+		t.Fatalf("Failed to update seed file: %v", err)
+	}
+	err = Seed(db, config)
+	assert.NoError(t, err)
+
+	var name string
+	err = db.QueryRow("SELECT name FROM countries WHERE code = 'JP'").Scan(&name)
+	assert.NoError(t, err)
+	assert.Equal(t, "Nippon", name)
+	err = db.QueryRow("SELECT COUNT(*) FROM seed_runs").Scan(&runs)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, runs)
+
+	var checksum string
+	err = db.QueryRow("SELECT checksum FROM gosmm_seed_history WHERE filename = 'countries.sql'").Scan(&checksum)
+	assert.NoError(t, err)
+	data, _ := ioutil.ReadFile(countriesFile)
+	assert.Equal(t, calculateChecksum(data), checksum)
+}
+
+func TestSeedWithFailure(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	seedsDir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(seedsDir, "users.sql"), []byte("INSERT INTO missing_table VALUES (1);"), 0644); err != nil {
+		t.Fatalf("Failed to create seed file: %v", err)
+	}
+
+	err := Seed(db, MigrationConfig{SeedsDir: seedsDir, Driver: "sqlite3"})
+	var failed *ErrMigrationFailed
+	if assert.ErrorAs(t, err, &failed) {
+		assert.Equal(t, "users.sql", failed.File)
+	}
+
+	// The failed seed is not recorded, so the next run retries it
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM gosmm_seed_history").Scan(&count)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestSeedWithEnvironment(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	_, err := db.Exec("CREATE TABLE users (name TEXT)")
+	if err != nil {
+		t.Fatalf("Failed to create users table: %v", err)
+	}
+
+	seedsDir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(seedsDir, "dev"), 0755); err != nil {
+		t.Fatalf("Failed to create environment directory: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(seedsDir, "dev", "demo_users.sql"), []byte("INSERT INTO users VALUES ('demo');"), 0644); err != nil {
+		t.Fatalf("Failed to create seed file: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(seedsDir, "admin_user.prod.sql"), []byte("INSERT INTO users VALUES ('admin');"), 0644); err != nil {
+		t.Fatalf("Failed to create seed file: %v", err)
+	}
+
+	err = Seed(db, MigrationConfig{SeedsDir: seedsDir, Driver: "sqlite3", Environment: "dev"})
+	assert.NoError(t, err)
+
+	var name string
+	err = db.QueryRow("SELECT name FROM users").Scan(&name)
+	assert.NoError(t, err)
+	assert.Equal(t, "demo", name)
+}
+
+func TestSeedWithoutSeedsDir(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	err := Seed(db, MigrationConfig{Driver: "sqlite3"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,207 @@
+package gosmm
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// defaultConcurrency is the number of targets migrated at the same time when MigrateAllOptions.Concurrency is zero
+const defaultConcurrency = 4
+
+// Target is a database migrated by MigrateAll, such as a shard. Each target has its own history table.
+type Target struct {
+	// Name identifies the target in the output and errors
+	Name string
+	// DB is the connection to the target. When nil, MigrateAll connects with DBConfig and closes the connection.
+	DB *sql.DB
+	// DBConfig describes the connection to the target when DB is nil
+	DBConfig DBConfig
+	// Schema overrides Config.Schema for the target, e.g. the schema of a tenant, see MigrateTenants
+	Schema string
+}
+
+// MigrateAllOptions configures MigrateAll
+type MigrateAllOptions struct {
+	// Config is the migration configuration applied to every target.
+	// When Config.Driver is empty, the driver of each target's DBConfig is used.
+	Config MigrationConfig
+	// Concurrency is the number of targets migrated at the same time, 4 when zero
+	Concurrency int
+	// ContinueOnError migrates the remaining targets after a target failed. By default, no target
+	// is started after the first failure, while the targets already being migrated are completed.
+	ContinueOnError bool
+}
+
+// TargetError is the failure of a target of MigrateAll
+type TargetError struct {
+	Target string
+	Err    error
+}
+
+// Error returns the target with its error
+func (e TargetError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Target, e.Err)
+}
+
+// Unwrap returns the error of the target
+func (e TargetError) Unwrap() error {
+	return e.Err
+}
+
+// ErrMigrateAllFailed is returned by MigrateAll when targets failed
+type ErrMigrateAllFailed struct {
+	// Failed holds the failed targets in the order of the targets
+	Failed []TargetError
+	// Skipped holds the targets that were not migrated after a failure, without ContinueOnError
+	Skipped []string
+	// Total is the number of targets
+	Total int
+}
+
+// Error lists the failed and skipped targets
+func (e *ErrMigrateAllFailed) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d of %d target(s) failed", len(e.Failed), e.Total)
+	if len(e.Skipped) > 0 {
+		fmt.Fprintf(&b, ", %d skipped", len(e.Skipped))
+	}
+	for _, failure := range e.Failed {
+		b.WriteString("\n  " + failure.Error())
+	}
+	return b.String()
+}
+
+// Unwrap returns the errors of the failed targets
+func (e *ErrMigrateAllFailed) Unwrap() []error {
+	errs := make([]error, len(e.Failed))
+	for i, failure := range e.Failed {
+		errs[i] = failure
+	}
+	return errs
+}
+
+// MigrateAll applies the same migrations to many databases, such as the shards of a fleet,
+// with a pool of opts.Concurrency workers. When targets fail, it returns an *ErrMigrateAllFailed
+// holding the error of every failed target. The hooks and Progress of opts.Config are not called concurrently,
+// the events naming their target, and the gauges of opts.Config.Metrics are labelled by target.
+func MigrateAll(targets []Target, opts MigrateAllOptions) error {
+	return MigrateAllWithContext(context.Background(), targets, opts)
+}
+
+// MigrateAllWithContext is MigrateAll with a context. When ctx is done, no more targets are started
+// and the targets being migrated are cancelled.
+func MigrateAllWithContext(ctx context.Context, targets []Target, opts MigrateAllOptions) error {
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultConcurrency
+	}
+
+	errs := make([]error, len(targets))
+	started := make([]bool, len(targets))
+	var mu sync.Mutex
+	failed := false
+
+	// callbacks serializes the hooks and the progress events of the targets migrated concurrently
+	var callbacks sync.Mutex
+	config := opts.Config
+	config.Hooks = config.Hooks.serialized(&callbacks)
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				mu.Lock()
+				// another target may have failed while this one was waiting for a worker
+				if failed && !opts.ContinueOnError || ctx.Err() != nil {
+					mu.Unlock()
+					continue
+				}
+				started[i] = true
+				mu.Unlock()
+
+				err := migrateTarget(ctx, targets[i], config, &callbacks)
+				mu.Lock()
+				errs[i] = err
+				if err != nil {
+					failed = true
+				}
+				mu.Unlock()
+				if err != nil {
+					opts.Config.LogLevel.printf(LogError, "FAIL  %s: %v\n", targets[i].Name, err)
+				} else {
+					opts.Config.LogLevel.printf(LogInfo, "DONE  %s\n", targets[i].Name)
+				}
+			}
+		}()
+	}
+
+	for i := range targets {
+		mu.Lock()
+		stop := failed && !opts.ContinueOnError
+		mu.Unlock()
+		if stop || ctx.Err() != nil {
+			break
+		}
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	result := &ErrMigrateAllFailed{Total: len(targets)}
+	for i, target := range targets {
+		switch {
+		case !started[i]:
+			result.Skipped = append(result.Skipped, target.Name)
+		case errs[i] != nil:
+			result.Failed = append(result.Failed, TargetError{Target: target.Name, Err: errs[i]})
+		}
+	}
+	if len(result.Failed) > 0 {
+		return result
+	}
+	if len(result.Skipped) > 0 {
+		return fmt.Errorf("%d target(s) not migrated: %w", len(result.Skipped), ctx.Err())
+	}
+	return nil
+}
+
+// migrateTarget migrates a single target, connecting to it when it has no connection. Its progress events,
+// holding callbacks, name the target, and its gauges are labelled with it.
+func migrateTarget(ctx context.Context, target Target, config MigrationConfig, callbacks *sync.Mutex) (err error) {
+	// the targets would overwrite each other's schema file and report
+	config.SchemaFile, config.ReportFile = "", ""
+	if progress := config.Progress; progress != nil {
+		config.Progress = func(event Event) {
+			event.Target = target.Name
+			callbacks.Lock()
+			defer callbacks.Unlock()
+			progress(event)
+		}
+	}
+	config.Metrics = config.Metrics.forTarget(target.Name)
+	if target.Schema != "" {
+		config = config.withTenantSchema(target.Schema)
+	}
+	db := target.DB
+	if db == nil {
+		if config.Driver == "" {
+			config.Driver = target.DBConfig.Driver
+		}
+		db, err = Connect(target.DBConfig)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if closeErr := db.Close(); closeErr != nil && err == nil {
+				err = fmt.Errorf("failed to close database: %w", closeErr)
+			}
+		}()
+	}
+	return MigrateWithContext(ctx, db, config)
+}
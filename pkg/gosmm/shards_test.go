@@ -0,0 +1,161 @@
+package gosmm
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// shardTargets returns n targets on SQLite files, connected by MigrateAll, with a migrations directory
+// creating a users table. The shards listed in broken already have a users table, so their migration fails.
+func shardTargets(t *testing.T, n int, broken ...int) ([]Target, string) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+
+	dbDir := t.TempDir()
+	targets := make([]Target, n)
+	for i := range targets {
+		path := filepath.Join(dbDir, fmt.Sprintf("shard%02d.db", i))
+		targets[i] = Target{Name: fmt.Sprintf("shard%02d", i), DBConfig: DBConfig{Driver: "sqlite3", DSN: path}}
+	}
+	for _, i := range broken {
+		db, err := sql.Open("sqlite3", targets[i].DBConfig.DSN)
+		if err != nil {
+			t.Fatalf("Failed to open shard: %v", err)
+		}
+		if _, err := db.Exec("CREATE TABLE users (id INTEGER)"); err != nil {
+			t.Fatalf("Failed to create users table: %v", err)
+		}
+		db.Close()
+	}
+	return targets, dir
+}
+
+// appliedMigrations returns the number of successful migrations recorded on a target, or -1 without history table
+func appliedMigrations(t *testing.T, target Target) int {
+	db, err := sql.Open("sqlite3", target.DBConfig.DSN)
+	if err != nil {
+		t.Fatalf("Failed to open shard: %v", err)
+	}
+	defer db.Close()
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM gosmm_migration_history WHERE success = 1").Scan(&count); err != nil {
+		return -1
+	}
+	return count
+}
+
+func TestMigrateAll(t *testing.T) {
+	targets, dir := shardTargets(t, 8)
+
+	err := MigrateAll(targets, MigrateAllOptions{Config: MigrationConfig{MigrationsDir: dir}, Concurrency: 3})
+	assert.NoError(t, err)
+	for _, target := range targets {
+		assert.Equal(t, 1, appliedMigrations(t, target), target.Name)
+	}
+}
+
+func TestMigrateAllContinueOnError(t *testing.T) {
+	targets, dir := shardTargets(t, 6, 1, 4)
+
+	err := MigrateAll(targets, MigrateAllOptions{Config: MigrationConfig{MigrationsDir: dir}, Concurrency: 2, ContinueOnError: true})
+	var failed *ErrMigrateAllFailed
+	assert.True(t, errors.As(err, &failed))
+	assert.Equal(t, 6, failed.Total)
+	assert.Empty(t, failed.Skipped)
+	if assert.Len(t, failed.Failed, 2) {
+		assert.Equal(t, "shard01", failed.Failed[0].Target)
+		assert.Equal(t, "shard04", failed.Failed[1].Target)
+	}
+	var migrationFailed *ErrMigrationFailed
+	assert.True(t, errors.As(err, &migrationFailed))
+
+	for i, target := range targets {
+		if i == 1 || i == 4 {
+			continue
+		}
+		assert.Equal(t, 1, appliedMigrations(t, target), target.Name)
+	}
+}
+
+func TestMigrateAllFailFast(t *testing.T) {
+	targets, dir := shardTargets(t, 5, 1)
+
+	err := MigrateAll(targets, MigrateAllOptions{Config: MigrationConfig{MigrationsDir: dir}, Concurrency: 1})
+	var failed *ErrMigrateAllFailed
+	assert.True(t, errors.As(err, &failed))
+	assert.Len(t, failed.Failed, 1)
+	assert.Equal(t, []string{"shard02", "shard03", "shard04"}, failed.Skipped)
+	assert.Contains(t, err.Error(), "1 of 5 target(s) failed, 3 skipped")
+
+	assert.Equal(t, 1, appliedMigrations(t, targets[0]))
+	assert.Equal(t, -1, appliedMigrations(t, targets[3]))
+}
+
+func TestMigrateAllWithCancelledContext(t *testing.T) {
+	targets, dir := shardTargets(t, 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := MigrateAllWithContext(ctx, targets, MigrateAllOptions{Config: MigrationConfig{MigrationsDir: dir}})
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestMigrateAllSerializesCallbacks(t *testing.T) {
+	targets, dir := shardTargets(t, 6)
+	metrics, err := NewMetrics(prometheus.NewRegistry())
+	if err != nil {
+		t.Fatalf("Failed to create metrics: %v", err)
+	}
+
+	// the callbacks record whether another one was running at the same time
+	var inside, overlaps int32
+	enter := func() {
+		if atomic.AddInt32(&inside, 1) > 1 {
+			atomic.AddInt32(&overlaps, 1)
+		}
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&inside, -1)
+	}
+	finished := make(map[string]bool)
+	config := MigrationConfig{
+		MigrationsDir: dir,
+		Metrics:       metrics,
+		Progress: func(event Event) {
+			enter()
+			if event.Kind == EventMigrationFinished {
+				finished[event.Target] = true // not concurrent, so not guarded
+			}
+		},
+		Hooks: Hooks{
+			BeforeAll:  func([]MigrationInfo) error { enter(); return nil },
+			BeforeEach: func(MigrationInfo) error { enter(); return nil },
+			AfterEach:  func(MigrationInfo) error { enter(); return nil },
+			AfterAll:   func([]MigrationInfo) error { enter(); return nil },
+		},
+	}
+	err = MigrateAll(targets, MigrateAllOptions{Config: config, Concurrency: 3})
+	assert.NoError(t, err)
+	assert.Zero(t, atomic.LoadInt32(&overlaps))
+
+	// The events and the gauges name their target
+	assert.Len(t, finished, len(targets))
+	for _, target := range targets {
+		assert.True(t, finished[target.Name], target.Name)
+		assert.Equal(t, float64(0), testutil.ToFloat64(metrics.pending.WithLabelValues(target.Name)), target.Name)
+		assert.Greater(t, testutil.ToFloat64(metrics.lastSuccess.WithLabelValues(target.Name)), float64(0), target.Name)
+	}
+	assert.Equal(t, len(targets), testutil.CollectAndCount(metrics.pending))
+}
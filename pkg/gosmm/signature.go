@@ -0,0 +1,175 @@
+package gosmm
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const (
+	// signatureFileExtension is the extension of the detached signature of a migration file, e.g.
+	// v20230101_create_users_00001.sql.sig
+	signatureFileExtension = ".sig"
+	// signatureMarker starts the line of a migration file holding its signature, which covers the file without it
+	signatureMarker = "-- gosmm:signature"
+)
+
+// Signatures only lets the migration files signed with one of PublicKeys run, e.g. those produced by a release
+// pipeline. The Ed25519 signature of a file is either detached, the base64 or raw content of the file named after
+// it with the .sig extension, or held by a "-- gosmm:signature <base64>" line of the file, covering the file
+// without that line. The signature covers the file as it is written, before its template is rendered and its
+// placeholders are replaced. Go migrations are compiled into the application and are not signed.
+type Signatures struct {
+	PublicKeys []ed25519.PublicKey
+}
+
+// ParsePublicKeys returns the Ed25519 public keys of the PEM "PUBLIC KEY" blocks of data, e.g. written by
+// openssl pkey -pubout
+func ParsePublicKeys(data []byte) ([]ed25519.PublicKey, error) {
+	var keys []ed25519.PublicKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "PUBLIC KEY" {
+			continue
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		publicKey, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("unsupported public key type %T, only Ed25519 keys are supported", key)
+		}
+		keys = append(keys, publicKey)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no PEM encoded public key found")
+	}
+	return keys, nil
+}
+
+// ParsePrivateKey returns the Ed25519 private key of the PEM "PRIVATE KEY" block of data, e.g. written by
+// openssl genpkey -algorithm ed25519
+func ParsePrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, errors.New("no PEM encoded private key found")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T, only Ed25519 keys are supported", key)
+	}
+	return privateKey, nil
+}
+
+// verify checks that the migration file at path is signed with one of the public keys
+func (s *Signatures) verify(filename string, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+	signed, signature, err := readSignature(path, data)
+	if err != nil {
+		return fmt.Errorf("invalid signature of %s: %w", filename, err)
+	}
+	if signature == nil {
+		return fmt.Errorf("%w: %s has no signature", ErrUnsignedMigration, filename)
+	}
+	for _, key := range s.PublicKeys {
+		if ed25519.Verify(key, signed, signature) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s is not signed with a trusted key", ErrUnsignedMigration, filename)
+}
+
+// readSignature returns the signature of the migration file at path with content data, and the content it
+// covers, or a nil signature when the file is not signed
+func readSignature(path string, data []byte) (signed []byte, signature []byte, err error) {
+	offset := 0
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		if value := strings.TrimSpace(line); strings.HasPrefix(value, signatureMarker+" ") {
+			signature, err = decodeSignature([]byte(strings.TrimPrefix(value, signatureMarker+" ")))
+			return append(data[:offset:offset], data[offset+len(line):]...), signature, err
+		}
+		offset += len(line)
+	}
+
+	detached, err := ioutil.ReadFile(path + signatureFileExtension)
+	if errors.Is(err, os.ErrNotExist) {
+		return data, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	signature, err = decodeSignature(detached)
+	return data, signature, err
+}
+
+// decodeSignature returns the signature of its raw or base64 encoding
+func decodeSignature(data []byte) ([]byte, error) {
+	if len(data) == ed25519.SignatureSize {
+		return data, nil
+	}
+	signature, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data)))
+	if err != nil {
+		return nil, err
+	}
+	if len(signature) != ed25519.SignatureSize {
+		return nil, fmt.Errorf("signature of %d bytes instead of %d", len(signature), ed25519.SignatureSize)
+	}
+	return signature, nil
+}
+
+// verifySignatures checks the signatures of the pending migration files before any of them is executed
+func verifySignatures(config MigrationConfig, paths map[string]string, pending []MigrationInfo) error {
+	for _, migration := range pending {
+		if _, ok := config.GoMigrations[migration.Filename]; ok {
+			continue
+		}
+		if err := config.Signatures.verify(migration.Filename, paths[migration.Filename]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SignMigrations writes the detached signature of every file of the migration directories signed with key,
+// e.g. in a release pipeline, and returns their paths
+func SignMigrations(config MigrationConfig, key ed25519.PrivateKey) ([]string, error) {
+	files, err := config.migrationFiles()
+	if err != nil {
+		return nil, err
+	}
+	var signed []string
+	for _, file := range files {
+		if file.isDir {
+			continue
+		}
+		data, err := ioutil.ReadFile(file.path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file: %w", err)
+		}
+		signature := base64.StdEncoding.EncodeToString(ed25519.Sign(key, data)) + "\n"
+		if err := ioutil.WriteFile(file.path+signatureFileExtension, []byte(signature), 0644); err != nil {
+			return nil, fmt.Errorf("failed to write signature of %s: %w", file.name, err)
+		}
+		signed = append(signed, file.path+signatureFileExtension)
+	}
+	return signed, nil
+}
@@ -0,0 +1,105 @@
+package gosmm
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// generateSigningKey returns a new Ed25519 key pair for the tests
+func generateSigningKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return publicKey, privateKey
+}
+
+func TestParseKeys(t *testing.T) {
+	publicKey, privateKey := generateSigningKey(t)
+	publicDER, err := x509.MarshalPKIXPublicKey(publicKey)
+	assert.NoError(t, err)
+	privateDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	assert.NoError(t, err)
+
+	keys, err := ParsePublicKeys(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}))
+	assert.NoError(t, err)
+	assert.Equal(t, []ed25519.PublicKey{publicKey}, keys)
+	parsed, err := ParsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}))
+	assert.NoError(t, err)
+	assert.Equal(t, privateKey, parsed)
+
+	_, err = ParsePublicKeys([]byte("not a key"))
+	assert.EqualError(t, err, "no PEM encoded public key found")
+	_, err = ParsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}))
+	assert.EqualError(t, err, "no PEM encoded private key found")
+}
+
+func TestMigrateSignedMigrations(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+	publicKey, privateKey := generateSigningKey(t)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "v20230101_create_users_00001.sql")
+	if err := ioutil.WriteFile(path, []byte("CREATE TABLE users (id INTEGER);"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3", Signatures: &Signatures{PublicKeys: []ed25519.PublicKey{publicKey}}}
+	err := MigrateWithConfig(db, config)
+	assert.ErrorIs(t, err, ErrUnsignedMigration)
+	assert.EqualError(t, err, "unsigned migration: v20230101_create_users_00001.sql has no signature")
+
+	signed, err := SignMigrations(config, privateKey)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{path + ".sig"}, signed)
+
+	// a file modified after it was signed
+	if err := ioutil.WriteFile(path, []byte("CREATE TABLE users (id INTEGER, email TEXT);"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err = MigrateWithConfig(db, config)
+	assert.EqualError(t, err, "unsigned migration: v20230101_create_users_00001.sql is not signed with a trusted key")
+
+	if err := ioutil.WriteFile(path, []byte("CREATE TABLE users (id INTEGER);"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, MigrateWithConfig(db, config))
+	assert.NoError(t, Validate(db, config))
+}
+
+func TestVerifyInFileSignature(t *testing.T) {
+	publicKey, privateKey := generateSigningKey(t)
+	signatures := &Signatures{PublicKeys: []ed25519.PublicKey{publicKey}}
+	content := "-- gosmm:author alice\nCREATE TABLE users (id INTEGER);\n"
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, []byte(content)))
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "v20230101_create_users_00001.sql")
+	if err := ioutil.WriteFile(path, []byte("-- gosmm:signature "+signature+"\n"+content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, signatures.verify("v20230101_create_users_00001.sql", path))
+
+	// a raw detached signature, e.g. written by openssl pkeyutl -sign
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path+".sig", ed25519.Sign(privateKey, []byte(content)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, signatures.verify("v20230101_create_users_00001.sql", path))
+
+	if err := ioutil.WriteFile(path+".sig", []byte("c2lnbmF0dXJl\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	assert.EqualError(t, signatures.verify("v20230101_create_users_00001.sql", path),
+		"invalid signature of v20230101_create_users_00001.sql: signature of 9 bytes instead of 64")
+}
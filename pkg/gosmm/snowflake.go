@@ -0,0 +1,108 @@
+package gosmm
+
+import (
+	"context"
+	"database/sql"
+	"net/url"
+	"time"
+)
+
+func init() {
+	RegisterDialect("snowflake", snowflakeDialect{})
+}
+
+// snowflakeDialect supports Snowflake through the database/sql driver of github.com/snowflakedb/gosnowflake,
+// registered as "snowflake". Snowflake commits the current transaction before and after each DDL statement,
+// so like those of MySQL the DDL statements of a migration cannot be rolled back.
+type snowflakeDialect struct{}
+
+// HistoryTableDDL implements Dialect
+func (snowflakeDialect) HistoryTableDDL(table string) string {
+	return `CREATE TABLE IF NOT EXISTS ` + table + ` (
+		installed_rank NUMBER(10) NOT NULL PRIMARY KEY,
+		filename VARCHAR(255) NOT NULL,
+		installed_on TIMESTAMP_TZ NOT NULL DEFAULT CURRENT_TIMESTAMP(),
+		execution_time NUMBER(19) NOT NULL,
+		success BOOLEAN NOT NULL,
+		checksum VARCHAR(64),
+		failed_statement NUMBER(10),
+		committed_statements NUMBER(10),
+		author VARCHAR(255),
+		ticket VARCHAR(255),
+		description VARCHAR(1000),
+		backup VARCHAR(1000),
+		applied_by VARCHAR(255),
+		context VARCHAR(1000),
+		rows_affected NUMBER(19),
+		approval VARCHAR(255),
+		ahead INTEGER
+	)`
+}
+
+// HistoryVersionTableDDL implements Dialect
+func (snowflakeDialect) HistoryVersionTableDDL(table string) string {
+	return `CREATE TABLE IF NOT EXISTS ` + table + ` (
+		version NUMBER(10) NOT NULL PRIMARY KEY,
+		upgraded_on TIMESTAMP_TZ NOT NULL
+	)`
+}
+
+// LockTableDDL implements Dialect. Snowflake does not enforce primary keys, so the leases of the table do not
+// serialize the runs either.
+func (snowflakeDialect) LockTableDDL(table string) string {
+	return `CREATE TABLE IF NOT EXISTS ` + table + ` (
+		lock_name VARCHAR(255) NOT NULL PRIMARY KEY,
+		owner VARCHAR(255) NOT NULL,
+		expires_at NUMBER(19) NOT NULL
+	)`
+}
+
+// Lock implements Dialect with a no-op lock, since Snowflake has no locks held by a session
+func (snowflakeDialect) Lock(context.Context, *sql.DB, string, time.Duration) (func() error, error) {
+	return func() error { return nil }, nil
+}
+
+// QuoteIdentifier implements Dialect. Like Oracle, Snowflake folds unquoted names to upper case.
+func (snowflakeDialect) QuoteIdentifier(name string) string {
+	return quoteFoldedIdentifier(name)
+}
+
+// BindParam implements Dialect
+func (snowflakeDialect) BindParam(int) string {
+	return "?"
+}
+
+// TransactionalDDL implements Dialect
+func (snowflakeDialect) TransactionalDDL() bool {
+	return false
+}
+
+// SplitStatements implements Dialect. Semicolons inside quoted strings, identifiers, comments and the $$
+// quoted bodies of procedures and functions do not end a statement.
+func (snowflakeDialect) SplitStatements(sql string) []string {
+	s := &statementSplitter{driver: "snowflake", input: sql, delimiter: defaultDelimiter}
+	s.split()
+	return s.statements
+}
+
+// snowflakeDSN builds the gosnowflake data source name, user:password@account/database/schema?warehouse=...
+func snowflakeDSN(config DBConfig, params map[string]string) string {
+	query := url.Values{}
+	for key, value := range params {
+		query.Set(key, value)
+	}
+	if config.Warehouse != "" {
+		query.Set("warehouse", config.Warehouse)
+	}
+	if config.Role != "" {
+		query.Set("role", config.Role)
+	}
+	dsn := url.UserPassword(config.User, config.Password).String() + "@" + config.Account + "/" + url.PathEscape(config.DBName)
+	if config.Schema != "" {
+		dsn += "/" + url.PathEscape(config.Schema)
+	}
+	if len(query) > 0 {
+		dsn += "?" + query.Encode()
+	}
+	return dsn
+}
@@ -0,0 +1,53 @@
+package gosmm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnowflakeDSN(t *testing.T) {
+	config := DBConfig{Driver: "snowflake", Account: "myorg-analytics", User: "gosmm", Password: "p@ss word", DBName: "ANALYTICS",
+		Schema: "MARTS", Warehouse: "MIGRATIONS_WH", Role: "TRANSFORMER", Params: map[string]string{"application": "gosmm"}}
+	assert.NoError(t, validateDBConfig(&config))
+	dsn, err := buildDSN(config)
+	assert.NoError(t, err)
+	assert.Equal(t, "gosmm:p%40ss%20word@myorg-analytics/ANALYTICS/MARTS?application=gosmm&role=TRANSFORMER&warehouse=MIGRATIONS_WH", dsn)
+
+	config = DBConfig{Driver: "snowflake", Account: "myorg-analytics", User: "gosmm", Password: "secret", DBName: "ANALYTICS"}
+	dsn, err = buildDSN(config)
+	assert.NoError(t, err)
+	assert.Equal(t, "gosmm:secret@myorg-analytics/ANALYTICS", dsn)
+
+	config.Account = ""
+	assert.EqualError(t, validateDBConfig(&config), "missing account")
+}
+
+func TestSnowflakeSplitStatements(t *testing.T) {
+	sql := `// the revenue procedure
+CREATE OR REPLACE PROCEDURE refresh_revenue()
+RETURNS VARCHAR
+LANGUAGE SQL
+AS
+$$
+BEGIN
+	DELETE FROM revenue;
+	INSERT INTO revenue SELECT day, SUM(amount) FROM orders GROUP BY day;
+	RETURN 'done';
+END;
+$$;
+CALL refresh_revenue();`
+	assert.Equal(t, []string{
+		"// the revenue procedure\nCREATE OR REPLACE PROCEDURE refresh_revenue()\nRETURNS VARCHAR\nLANGUAGE SQL\nAS\n$$\nBEGIN\n\tDELETE FROM revenue;\n\tINSERT INTO revenue SELECT day, SUM(amount) FROM orders GROUP BY day;\n\tRETURN 'done';\nEND;\n$$",
+		"CALL refresh_revenue()",
+	}, splitStatements(sql, "snowflake"))
+}
+
+func TestSnowflakeDialect(t *testing.T) {
+	assert.Equal(t, `"MARTS".gosmm_migration_history`, historyTableName("snowflake", "marts"))
+	assert.Equal(t, "?, ?", bindParams("snowflake", 2))
+	assert.Equal(t, "TRUE", boolLiteral("snowflake", true))
+	assert.False(t, transactionalDDL("snowflake"))
+	assert.True(t, causesImplicitCommit("snowflake", "CREATE TABLE revenue (day DATE, amount NUMBER)"))
+	assert.True(t, transactionalMigrations("snowflake"))
+}
@@ -0,0 +1,202 @@
+package gosmm
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// sourceManifestFile is the file of the source cache directory recording the ETags of the cached files
+const sourceManifestFile = ".gosmm-source.json"
+
+// SourceObject is a file of a Source
+type SourceObject struct {
+	// Name is the path of the file relative to the root of the source, e.g. v20230101_create_users_00001.sql
+	// or dev/v20230101_seed_users_00002.sql for the file of an environment directory
+	Name string
+	// ETag identifies the content of the file, e.g. the MD5 of an S3 object uploaded in a single part
+	ETag string
+}
+
+// Source is a remote location of migration files, e.g. the bundle published by CI to an S3 bucket, fetched
+// into MigrationConfig.SourceCacheDir by FetchSource before the migrations are read
+type Source interface {
+	// List returns the files of the source
+	List(ctx context.Context) ([]SourceObject, error)
+	// Get returns the content of object, failing when it does not match its ETag
+	Get(ctx context.Context, object SourceObject) ([]byte, error)
+}
+
+// parseMigrationsSource returns the Source of rawURL, e.g. s3://bucket/prefix, or a bundle such as
+// releases/migrations.tar.gz
+func parseMigrationsSource(rawURL string, region string, s3Endpoint string) (Source, error) {
+	if isBundle(rawURL) && !strings.Contains(rawURL, "://") {
+		return &BundleSource{Path: rawURL}, nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "file":
+		if !isBundle(u.Path) {
+			return nil, fmt.Errorf("unsupported bundle %s, only .zip, .tar.gz and .tgz archives are supported", u.Path)
+		}
+		return &BundleSource{Path: u.Path}, nil
+	case "s3":
+		if u.Host == "" {
+			return nil, fmt.Errorf("missing bucket in %s", rawURL)
+		}
+		return S3Source{Bucket: u.Host, Prefix: strings.TrimPrefix(u.Path, "/"), Region: region, Endpoint: s3Endpoint}, nil
+	default:
+		return nil, fmt.Errorf("unsupported scheme %q, only s3 and bundles are supported", u.Scheme)
+	}
+}
+
+// defaultSourceCacheDir returns the cache directory of the source at rawURL in the user cache directory
+func defaultSourceCacheDir(rawURL string) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	sum := md5.Sum([]byte(rawURL))
+	return filepath.Join(dir, "gosmm", "sources", hex.EncodeToString(sum[:]))
+}
+
+// FetchSource fetches the files of config.Source into config.SourceCacheDir, whose files are then read with
+// those of the migration directories. Only the files whose ETag changed since the last fetch, or whose cached
+// content no longer matches their ETag, are downloaded, and the cached files removed from the source are
+// deleted. It does nothing when config.Source is nil.
+func FetchSource(ctx context.Context, config MigrationConfig) error {
+	if config.Source == nil {
+		return nil
+	}
+	if config.SourceCacheDir == "" {
+		return errors.New("missing source cache directory")
+	}
+	objects, err := config.Source.List(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list migrations source: %w", err)
+	}
+	if err := os.MkdirAll(config.SourceCacheDir, 0755); err != nil {
+		return fmt.Errorf("failed to create source cache directory: %w", err)
+	}
+
+	manifest := readSourceManifest(config.SourceCacheDir)
+	fetched := make(map[string]string, len(objects))
+	for _, object := range objects {
+		target, err := sourceCachePath(config.SourceCacheDir, object.Name)
+		if err != nil {
+			return err
+		}
+		if manifest[object.Name] != object.ETag || !cachedObjectValid(target, object.ETag) {
+			data, err := config.Source.Get(ctx, object)
+			if err != nil {
+				return fmt.Errorf("failed to fetch %s: %w", object.Name, err)
+			}
+			if err := writeFileAtomic(target, data); err != nil {
+				return fmt.Errorf("failed to cache %s: %w", object.Name, err)
+			}
+		}
+		fetched[object.Name] = object.ETag
+	}
+
+	for name := range manifest {
+		if _, ok := fetched[name]; ok {
+			continue
+		}
+		if target, err := sourceCachePath(config.SourceCacheDir, name); err == nil {
+			if err := os.Remove(target); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("failed to remove %s from the source cache: %w", name, err)
+			}
+		}
+	}
+	data, err := json.MarshalIndent(fetched, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(config.SourceCacheDir, sourceManifestFile), data)
+}
+
+// readSourceManifest returns the ETags of the files cached in dir by name, empty when the cache is new or unreadable
+func readSourceManifest(dir string) map[string]string {
+	manifest := make(map[string]string)
+	data, err := ioutil.ReadFile(filepath.Join(dir, sourceManifestFile))
+	if err != nil || json.Unmarshal(data, &manifest) != nil {
+		return make(map[string]string)
+	}
+	return manifest
+}
+
+// sourceCachePath returns the path of the file name of a source in the cache directory dir, rejecting the names
+// escaping it
+func sourceCachePath(dir string, name string) (string, error) {
+	clean := path.Clean(name)
+	if clean == "." || clean == sourceManifestFile || path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("invalid migrations source file name: %s", name)
+	}
+	return filepath.Join(dir, filepath.FromSlash(clean)), nil
+}
+
+// cachedObjectValid reports whether the file at path exists and, when etag is the MD5 of its content, matches it
+func cachedObjectValid(path string, etag string) bool {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	return verifyETag(data, etag) == nil
+}
+
+// verifyETag checks that data matches etag when it is a content hash: the SHA-256 of the files of a bundle,
+// or an MD5 as the ETags of the S3 objects uploaded in a single part are. The ETags of multipart uploads and of
+// other stores cannot be checked against the content.
+func verifyETag(data []byte, etag string) error {
+	if strings.HasPrefix(etag, bundleETagPrefix) {
+		if actual := hashHex(data); !strings.EqualFold(actual, strings.TrimPrefix(etag, bundleETagPrefix)) {
+			return fmt.Errorf("content SHA-256 %s does not match ETag %s", actual, etag)
+		}
+		return nil
+	}
+	etag = strings.Trim(etag, `"`)
+	if len(etag) != md5.Size*2 {
+		return nil
+	}
+	if _, err := hex.DecodeString(etag); err != nil {
+		return nil
+	}
+	sum := md5.Sum(data)
+	if actual := hex.EncodeToString(sum[:]); !strings.EqualFold(actual, etag) {
+		return fmt.Errorf("content MD5 %s does not match ETag %s", actual, etag)
+	}
+	return nil
+}
+
+// writeFileAtomic writes data to path through a temporary file renamed over it, so that an interrupted fetch
+// never leaves a truncated migration in the cache
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".gosmm-fetch-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
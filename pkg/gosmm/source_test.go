@@ -0,0 +1,186 @@
+package gosmm
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeS3 serves the objects of a bucket with ListObjectsV2, two keys per page, and GetObject
+type fakeS3 struct {
+	mu      sync.Mutex
+	bucket  string
+	objects map[string]string
+	// corrupt serves the objects with a content not matching their ETag
+	corrupt bool
+	gets    []string
+}
+
+func (f *fakeS3) put(key string, content string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[key] = content
+}
+
+func (f *fakeS3) etag(content string) string {
+	sum := md5.Sum([]byte(content))
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") || r.Header.Get("X-Amz-Content-Sha256") == "" {
+		http.Error(w, "unsigned request", http.StatusForbidden)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/"+f.bucket+"/")
+	if key != "" {
+		content, ok := f.objects[key]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		f.gets = append(f.gets, key)
+		w.Header().Set("ETag", f.etag(content))
+		if f.corrupt {
+			content += "-- tampered"
+		}
+		fmt.Fprint(w, content)
+		return
+	}
+
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, r.URL.Query().Get("prefix")) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	start, _ := strconv.Atoi(r.URL.Query().Get("continuation-token"))
+	end := start + 2
+	if end > len(keys) {
+		end = len(keys)
+	}
+	fmt.Fprint(w, `<ListBucketResult>`)
+	for _, key := range keys[start:end] {
+		fmt.Fprintf(w, `<Contents><Key>%s</Key><ETag>%s</ETag></Contents>`, key, strings.ReplaceAll(f.etag(f.objects[key]), `"`, "&quot;"))
+	}
+	if end < len(keys) {
+		fmt.Fprintf(w, `<IsTruncated>true</IsTruncated><NextContinuationToken>%d</NextContinuationToken>`, end)
+	}
+	fmt.Fprint(w, `</ListBucketResult>`)
+}
+
+func setupFakeS3(t *testing.T) (*fakeS3, S3Source) {
+	store := &fakeS3{bucket: "releases", objects: map[string]string{
+		"app/1.4.0/": "",
+		"app/1.4.0/v20230101_create_users_00001.sql":     "CREATE TABLE users (id INTEGER PRIMARY KEY);",
+		"app/1.4.0/v20230102_add_email_00002.sql":        "ALTER TABLE users ADD COLUMN email TEXT;",
+		"app/1.4.0/dev/v20230103_seed_users_00003.sql":   "INSERT INTO users (id) VALUES (1);",
+		"app/1.4.0-rc1/v20230101_create_users_00001.sql": "CREATE TABLE users (id INTEGER);",
+	}}
+	server := httptest.NewServer(store)
+	t.Cleanup(server.Close)
+	return store, S3Source{Bucket: "releases", Prefix: "app/1.4.0", Region: "eu-west-1", Endpoint: server.URL,
+		Credentials: staticAWSCredentials(testAWSCredentials)}
+}
+
+func TestS3SourceList(t *testing.T) {
+	store, source := setupFakeS3(t)
+
+	objects, err := source.List(context.Background())
+	assert.NoError(t, err)
+	var names []string
+	for _, object := range objects {
+		names = append(names, object.Name)
+		assert.Equal(t, store.etag(store.objects[source.Prefix+"/"+object.Name]), object.ETag)
+	}
+	// the directory marker and the sibling prefix are left out
+	assert.Equal(t, []string{"dev/v20230103_seed_users_00003.sql", "v20230101_create_users_00001.sql", "v20230102_add_email_00002.sql"}, names)
+
+	source.Bucket = "missing"
+	_, err = source.List(context.Background())
+	assert.Error(t, err)
+}
+
+func TestS3SourceGetCorrupted(t *testing.T) {
+	store, source := setupFakeS3(t)
+	store.corrupt = true
+
+	objects, err := source.List(context.Background())
+	assert.NoError(t, err)
+	_, err = source.Get(context.Background(), objects[0])
+	assert.ErrorContains(t, err, "does not match ETag")
+}
+
+func TestFetchSource(t *testing.T) {
+	store, source := setupFakeS3(t)
+	config := MigrationConfig{Source: source, SourceCacheDir: t.TempDir()}
+
+	assert.NoError(t, FetchSource(context.Background(), config))
+	assert.Len(t, store.gets, 3)
+	data, err := ioutil.ReadFile(filepath.Join(config.SourceCacheDir, "dev", "v20230103_seed_users_00003.sql"))
+	assert.NoError(t, err)
+	assert.Equal(t, "INSERT INTO users (id) VALUES (1);", string(data))
+
+	// the cached files are read with the migration directories, without the manifest
+	files, err := config.migrationFiles()
+	assert.NoError(t, err)
+	var names []string
+	for _, file := range files {
+		names = append(names, file.name)
+	}
+	assert.Equal(t, []string{"v20230101_create_users_00001.sql", "v20230102_add_email_00002.sql", "v20230103_seed_users_00003.sql"}, names)
+
+	// unchanged files are not downloaded again
+	store.gets = nil
+	assert.NoError(t, FetchSource(context.Background(), config))
+	assert.Empty(t, store.gets)
+
+	// changed, corrupted and removed files are
+	store.put("app/1.4.0/v20230102_add_email_00002.sql", "ALTER TABLE users ADD COLUMN email VARCHAR(255);")
+	delete(store.objects, "app/1.4.0/dev/v20230103_seed_users_00003.sql")
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(config.SourceCacheDir, "v20230101_create_users_00001.sql"), []byte("DROP TABLE users;"), 0644))
+	assert.NoError(t, FetchSource(context.Background(), config))
+	assert.ElementsMatch(t, []string{"app/1.4.0/v20230101_create_users_00001.sql", "app/1.4.0/v20230102_add_email_00002.sql"}, store.gets)
+	data, err = ioutil.ReadFile(filepath.Join(config.SourceCacheDir, "v20230101_create_users_00001.sql"))
+	assert.NoError(t, err)
+	assert.Equal(t, "CREATE TABLE users (id INTEGER PRIMARY KEY);", string(data))
+	assert.NoFileExists(t, filepath.Join(config.SourceCacheDir, "dev", "v20230103_seed_users_00003.sql"))
+
+	// a corrupted download is not cached
+	store.put("app/1.4.0/v20230104_add_name_00004.sql", "ALTER TABLE users ADD COLUMN name TEXT;")
+	store.corrupt = true
+	assert.ErrorContains(t, FetchSource(context.Background(), config), "failed to fetch v20230104_add_name_00004.sql")
+	assert.NoFileExists(t, filepath.Join(config.SourceCacheDir, "v20230104_add_name_00004.sql"))
+}
+
+func TestFetchSourceRejectsEscapingNames(t *testing.T) {
+	store, source := setupFakeS3(t)
+	store.put("app/1.4.0/../../escape.sql", "DROP TABLE users;")
+
+	err := FetchSource(context.Background(), MigrationConfig{Source: source, SourceCacheDir: t.TempDir()})
+	assert.ErrorContains(t, err, "invalid migrations source file name")
+}
+
+func TestVerifyETag(t *testing.T) {
+	data := []byte("CREATE TABLE users (id INTEGER);")
+	sum := md5.Sum(data)
+	assert.NoError(t, verifyETag(data, `"`+hex.EncodeToString(sum[:])+`"`))
+	assert.Error(t, verifyETag([]byte("DROP TABLE users;"), `"`+hex.EncodeToString(sum[:])+`"`))
+	// the ETag of a multipart upload is not a content hash
+	assert.NoError(t, verifyETag(data, `"`+hex.EncodeToString(sum[:])+`-2"`))
+}
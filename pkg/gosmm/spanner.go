@@ -0,0 +1,142 @@
+package gosmm
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// spannerLease is the lease taken by the runs against Spanner, which has no database locks
+const spannerLease = 30 * time.Second
+
+// spannerDDLPattern matches the DDL statements of Spanner, executed through the database admin API
+var spannerDDLPattern = regexp.MustCompile(`(?i)^\s*(CREATE|ALTER|DROP|RENAME|GRANT|REVOKE|ANALYZE)\b`)
+
+func init() {
+	RegisterDialect("spanner", spannerDialect{})
+}
+
+// spannerDialect supports Google Cloud Spanner through the database/sql driver of
+// github.com/googleapis/go-sql-spanner, registered as "spanner". Spanner rejects DDL in transactions, so the
+// migrations are applied statement by statement, with the consecutive DDL statements sent to the admin API in
+// a single batch since each schema update takes a while.
+type spannerDialect struct{}
+
+// HistoryTableDDL implements Dialect
+func (spannerDialect) HistoryTableDDL(table string) string {
+	return `CREATE TABLE IF NOT EXISTS ` + table + ` (
+		installed_rank INT64 NOT NULL,
+		filename STRING(255) NOT NULL,
+		installed_on TIMESTAMP NOT NULL,
+		execution_time INT64 NOT NULL,
+		success BOOL NOT NULL,
+		checksum STRING(64),
+		failed_statement INT64,
+		committed_statements INT64,
+		author STRING(255),
+		ticket STRING(255),
+		description STRING(1000),
+		backup STRING(1000),
+		applied_by STRING(255),
+		context STRING(1000),
+		rows_affected INT64,
+		approval STRING(255),
+		ahead INT64
+	) PRIMARY KEY (installed_rank)`
+}
+
+// HistoryVersionTableDDL implements Dialect
+func (spannerDialect) HistoryVersionTableDDL(table string) string {
+	return `CREATE TABLE IF NOT EXISTS ` + table + ` (
+		version INT64 NOT NULL,
+		upgraded_on TIMESTAMP NOT NULL
+	) PRIMARY KEY (version)`
+}
+
+// LockTableDDL implements Dialect
+func (spannerDialect) LockTableDDL(table string) string {
+	return `CREATE TABLE IF NOT EXISTS ` + table + ` (
+		lock_name STRING(255) NOT NULL,
+		owner STRING(255) NOT NULL,
+		expires_at INT64 NOT NULL
+	) PRIMARY KEY (lock_name)`
+}
+
+// Lock implements Dialect with a lease of the lock table, renewed until it is released
+func (spannerDialect) Lock(ctx context.Context, db *sql.DB, table string, wait time.Duration) (func() error, error) {
+	return acquireLease(ctx, db, MigrationConfig{Driver: "spanner", Lease: spannerLease, WaitForLock: wait}, table, nil)
+}
+
+// QuoteIdentifier implements Dialect
+func (spannerDialect) QuoteIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "\\`") + "`"
+}
+
+// BindParam implements Dialect with the positional parameters of go-sql-spanner
+func (spannerDialect) BindParam(int) string {
+	return "?"
+}
+
+// TransactionalDDL implements Dialect
+func (spannerDialect) TransactionalDDL() bool {
+	return false
+}
+
+// SplitStatements implements Dialect. Semicolons inside quoted strings, identifiers and comments do not end
+// a statement.
+func (spannerDialect) SplitStatements(sql string) []string {
+	s := &statementSplitter{driver: "spanner", input: sql, delimiter: defaultDelimiter}
+	s.split()
+	return s.statements
+}
+
+func (spannerDialect) nonTransactional() {}
+
+// batchStatements joins the consecutive DDL statements, executed by execStatement in a single batch
+func (d spannerDialect) batchStatements(statements []string) []string {
+	var batched []string
+	var batch []string
+	flush := func() {
+		if len(batch) > 0 {
+			batched = append(batched, strings.Join(batch, ";\n"))
+			batch = nil
+		}
+	}
+	for _, statement := range statements {
+		if !spannerDDLPattern.MatchString(stripLeadingComments(statement)) {
+			flush()
+			batched = append(batched, statement)
+			continue
+		}
+		batch = append(batch, statement)
+	}
+	flush()
+	return batched
+}
+
+// execStatement executes a statement, or the DDL statements of a batch with the START BATCH DDL and RUN BATCH
+// statements of go-sql-spanner, which sends them to the admin API in a single request. Spanner applies the
+// statements of a batch in order, so those preceding a failed one stay applied.
+func (d spannerDialect) execStatement(ctx context.Context, conn *sql.Conn, statement string) (sql.Result, error) {
+	statements := d.SplitStatements(statement)
+	if len(statements) <= 1 {
+		return conn.ExecContext(ctx, statement)
+	}
+	if _, err := conn.ExecContext(ctx, `START BATCH DDL`); err != nil {
+		return nil, fmt.Errorf("failed to start DDL batch: %w", err)
+	}
+	for _, ddl := range statements {
+		if _, err := conn.ExecContext(ctx, ddl); err != nil {
+			conn.ExecContext(context.Background(), `ABORT BATCH`)
+			return nil, fmt.Errorf("failed to add %q to the DDL batch: %w", ddl, err)
+		}
+	}
+	if _, err := conn.ExecContext(ctx, `RUN BATCH`); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(0), nil
+}
@@ -0,0 +1,107 @@
+package gosmm
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingDriver is a database/sql driver recording the executed statements, standing in for go-sql-spanner
+type recordingDriver struct {
+	statements []string
+	// fail makes the execution of the statement fail
+	fail string
+}
+
+func (d *recordingDriver) Open(string) (driver.Conn, error) {
+	return &recordingConn{driver: d}, nil
+}
+
+type recordingConn struct {
+	driver *recordingDriver
+}
+
+func (c *recordingConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (c *recordingConn) Close() error {
+	return nil
+}
+
+func (c *recordingConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (c *recordingConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	c.driver.statements = append(c.driver.statements, query)
+	if query == c.driver.fail {
+		return nil, errors.New("failed")
+	}
+	return driver.RowsAffected(1), nil
+}
+
+var spannerTestDriver = &recordingDriver{}
+
+func init() {
+	sql.Register("gosmm-test-spanner", spannerTestDriver)
+}
+
+func TestSpannerSplitStatements(t *testing.T) {
+	statements := spannerDialect{}.SplitStatements("# users\nCREATE TABLE `my;table` (id INT64) PRIMARY KEY (id);\n" +
+		"INSERT INTO `my;table` (id, name) VALUES (1, 'it\\'s; fine');")
+	assert.Equal(t, []string{
+		"# users\nCREATE TABLE `my;table` (id INT64) PRIMARY KEY (id)",
+		"INSERT INTO `my;table` (id, name) VALUES (1, 'it\\'s; fine')",
+	}, statements)
+	assert.Equal(t, "`we\\`ird`", spannerDialect{}.QuoteIdentifier("we`ird"))
+}
+
+func TestSpannerBatchStatements(t *testing.T) {
+	batched := spannerDialect{}.batchStatements([]string{
+		"CREATE TABLE users (id INT64) PRIMARY KEY (id)",
+		"-- lookups by name\nCREATE INDEX users_name ON users (name)",
+		"INSERT INTO users (id) VALUES (1)",
+		"ALTER TABLE users ADD COLUMN email STRING(255)",
+	})
+	assert.Equal(t, []string{
+		"CREATE TABLE users (id INT64) PRIMARY KEY (id);\n-- lookups by name\nCREATE INDEX users_name ON users (name)",
+		"INSERT INTO users (id) VALUES (1)",
+		"ALTER TABLE users ADD COLUMN email STRING(255)",
+	}, batched)
+	assert.True(t, causesImplicitCommit("spanner", batched[0]))
+	assert.False(t, transactionalMigrations("spanner"))
+	assert.True(t, transactionalMigrations("postgres"))
+}
+
+func TestSpannerExecStatementRunsBatches(t *testing.T) {
+	db, err := sql.Open("gosmm-test-spanner", "")
+	assert.NoError(t, err)
+	defer db.Close()
+	conn, err := db.Conn(context.Background())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	spannerTestDriver.statements = nil
+	statements := []string{"CREATE TABLE users (id INT64) PRIMARY KEY (id)", "CREATE INDEX users_name ON users (name)", "INSERT INTO users (id) VALUES (1)"}
+	executed := 0
+	execute := executeStatements("v20230101_create_users_00001.sql", spannerDialect{}.batchStatements(statements), 0, "spanner", false, nil,
+		func(int, string, time.Duration, int64) { executed++ })
+	assert.NoError(t, execute(context.Background(), conn, nil))
+	assert.Equal(t, []string{"START BATCH DDL", statements[0], statements[1], "RUN BATCH", statements[2]}, spannerTestDriver.statements)
+	assert.Equal(t, 2, executed)
+
+	spannerTestDriver.statements, spannerTestDriver.fail = nil, statements[1]
+	defer func() { spannerTestDriver.fail = "" }()
+	err = execute(context.Background(), conn, nil)
+	var failure *ErrMigrationFailed
+	assert.ErrorAs(t, err, &failure)
+	assert.Equal(t, 1, failure.StatementIndex)
+	assert.Equal(t, 0, failure.CommittedStatements)
+	assert.Equal(t, []string{"START BATCH DDL", statements[0], statements[1], "ABORT BATCH"}, spannerTestDriver.statements)
+}
@@ -0,0 +1,374 @@
+package gosmm
+
+import (
+	"strings"
+	"unicode"
+)
+
+const defaultDelimiter = ";"
+
+// statementSplitter splits the content of a migration file into individual SQL statements
+type statementSplitter struct {
+	driver     string
+	input      string
+	pos        int
+	delimiter  string
+	current    strings.Builder
+	hasContent bool
+	// leadingWords holds the first keywords of the current statement, used to detect SQLite triggers
+	leadingWords []string
+	// blockDepth tracks BEGIN ... END nesting inside SQLite trigger bodies and Postgres BEGIN ATOMIC bodies
+	blockDepth int
+	// lastWord is the previous keyword of a Postgres statement, used to detect BEGIN ATOMIC
+	lastWord   string
+	statements []string
+	// boundary is the position following the last statement flushed, and boundaryDelimiter the delimiter there
+	boundary          int
+	boundaryDelimiter string
+}
+
+// splitStatements splits the given SQL into statements.
+// Semicolons inside quoted strings, identifiers, comments, Postgres dollar-quoted and BEGIN ATOMIC bodies and
+// SQLite trigger bodies do not end a statement, and MySQL DELIMITER directives are honored.
+// For SQL Server the SQL is split into batches on GO lines instead of semicolons.
+// Statements are trimmed and comment-only statements are dropped.
+// The SQL of a registered dialect is split by the dialect.
+func splitStatements(sql string, driver string) []string {
+	if dialect := dialectFor(driver); dialect != nil {
+		return dialect.SplitStatements(sql)
+	}
+	s := &statementSplitter{
+		driver:    driver,
+		input:     sql,
+		delimiter: defaultDelimiter,
+	}
+	if driver == "sqlserver" {
+		s.delimiter = "" // batches are separated by GO lines only
+	}
+	s.split()
+	return s.statements
+}
+
+func (s *statementSplitter) split() {
+	s.handleLineDirective()
+	s.scan()
+	s.flush()
+}
+
+// scan splits the input from the current position, leaving its last statement in current
+func (s *statementSplitter) scan() {
+	for s.pos < len(s.input) {
+		rest := s.input[s.pos:]
+		c := s.input[s.pos]
+
+		switch {
+		case strings.HasPrefix(rest, "--") || (c == '#' && s.lexesLikeMySQL()) || (strings.HasPrefix(rest, "//") && s.driver == "snowflake"):
+			s.consumeLineComment()
+		case strings.HasPrefix(rest, "/*"):
+			s.consumeBlockComment()
+		case c == '\'' || c == '"' || (c == '`' && s.lexesLikeMySQL()) || (c == '[' && s.driver == "sqlserver"):
+			s.consumeQuoted(s.lexesLikeMySQL() && c != '`')
+		case (c == 'E' || c == 'e') && s.driver == "postgres" && strings.HasPrefix(rest[1:], "'"):
+			// a Postgres escape string such as E'it\'s', at the start of a word since words are consumed whole
+			s.current.WriteByte(c)
+			s.pos++
+			s.consumeQuoted(true)
+		case c == '$' && (s.driver == "postgres" || s.driver == "snowflake") && s.dollarTag() != "":
+			s.consumeDollarQuoted()
+		case (c == 'q' || c == 'Q') && s.driver == "oracle" && strings.HasPrefix(rest[1:], "'") && len(rest) > 2:
+			s.consumeAlternativeQuoted()
+		case s.blockDepth == 0 && s.delimiter != "" && strings.HasPrefix(rest, s.delimiter):
+			s.pos += len(s.delimiter)
+			s.flush()
+		case isIdentifierStart(rune(c)):
+			s.consumeWord()
+		default:
+			s.current.WriteByte(c)
+			s.pos++
+			if c == '\n' {
+				s.handleLineDirective()
+			} else if !unicode.IsSpace(rune(c)) {
+				s.hasContent = true
+			}
+		}
+	}
+}
+
+// flush appends the current statement to the result if it has any content
+func (s *statementSplitter) flush() {
+	statement := strings.TrimSpace(s.current.String())
+	if s.hasContent && statement != "" {
+		s.statements = append(s.statements, statement)
+	}
+	s.reset()
+	s.boundary, s.boundaryDelimiter = s.pos, s.delimiter
+}
+
+// reset discards the current statement
+func (s *statementSplitter) reset() {
+	s.current.Reset()
+	s.hasContent = false
+	s.leadingWords = nil
+	s.blockDepth = 0
+	s.lastWord = ""
+}
+
+// lexesLikeMySQL reports whether the driver starts comments with #, quotes identifiers with backticks and
+// escapes quotes with backslashes, as MySQL and the GoogleSQL of Spanner do
+func (s *statementSplitter) lexesLikeMySQL() bool {
+	return s.driver == "mysql" || s.driver == "spanner"
+}
+
+// handleLineDirective consumes a MySQL "DELIMITER <token>" line, a SQL Server "GO" batch
+// separator line or an Oracle "/" line ending a PL/SQL block at the current position. A
+// "-- gosmm:load" line is a statement of its own, whatever the driver, see bulkLoad.
+func (s *statementSplitter) handleLineDirective() {
+	end := strings.IndexByte(s.input[s.pos:], '\n')
+	if end == -1 {
+		end = len(s.input)
+	} else {
+		end += s.pos
+	}
+	if s.blockDepth == 0 && loadDirectivePattern.MatchString(strings.TrimSpace(s.input[s.pos:end])) {
+		s.flush()
+		s.current.WriteString(s.input[s.pos:end])
+		s.hasContent = true
+		s.pos = end
+		s.flush()
+		return
+	}
+	if s.driver != "mysql" && s.driver != "sqlserver" && s.driver != "oracle" {
+		return
+	}
+	fields := strings.Fields(s.input[s.pos:end])
+
+	switch {
+	case s.driver == "mysql" && len(fields) == 2 && strings.EqualFold(fields[0], "DELIMITER"):
+		s.delimiter = fields[1]
+		s.pos = end
+	case s.driver == "sqlserver" && len(fields) == 1 && strings.EqualFold(fields[0], "GO"):
+		s.pos = end
+		s.flush()
+	case s.driver == "oracle" && len(fields) == 1 && fields[0] == "/":
+		s.pos = end
+		s.flush()
+	}
+}
+
+// consumeLineComment copies a comment running until the end of the line
+func (s *statementSplitter) consumeLineComment() {
+	end := strings.IndexByte(s.input[s.pos:], '\n')
+	if end == -1 {
+		end = len(s.input)
+	} else {
+		end += s.pos
+	}
+	s.current.WriteString(s.input[s.pos:end])
+	s.pos = end
+}
+
+// consumeBlockComment copies a /* ... */ comment, nesting as Postgres does
+func (s *statementSplitter) consumeBlockComment() {
+	start := s.pos
+	depth := 0
+	for s.pos < len(s.input) {
+		rest := s.input[s.pos:]
+		if strings.HasPrefix(rest, "/*") && (depth == 0 || s.driver == "postgres") {
+			depth++
+			s.pos += 2
+			continue
+		}
+		if strings.HasPrefix(rest, "*/") {
+			depth--
+			s.pos += 2
+			if depth == 0 {
+				break
+			}
+			continue
+		}
+		s.pos++
+	}
+	s.current.WriteString(s.input[start:s.pos])
+}
+
+// consumeQuoted copies a quoted string or identifier, honoring doubled quotes, and backslash escapes when
+// backslashEscapes is set, as in the strings of MySQL and the escape strings of Postgres
+func (s *statementSplitter) consumeQuoted(backslashEscapes bool) {
+	start := s.pos
+	quote := s.input[s.pos]
+	if quote == '[' {
+		quote = ']' // SQL Server bracketed identifier
+	}
+	s.pos++
+	for s.pos < len(s.input) {
+		c := s.input[s.pos]
+		if c == '\\' && backslashEscapes {
+			s.pos += 2
+			continue
+		}
+		s.pos++
+		if c == quote {
+			if s.pos < len(s.input) && s.input[s.pos] == quote {
+				s.pos++ // doubled quote is an escaped quote
+				continue
+			}
+			break
+		}
+	}
+	if s.pos > len(s.input) {
+		s.pos = len(s.input)
+	}
+	s.current.WriteString(s.input[start:s.pos])
+	s.hasContent = true
+}
+
+// dollarTag returns the Postgres dollar-quote tag (e.g. "$body$"), or the $$ of Snowflake, at the current
+// position, or ""
+func (s *statementSplitter) dollarTag() string {
+	for i := s.pos + 1; i < len(s.input); i++ {
+		c := rune(s.input[i])
+		if c == '$' {
+			return s.input[s.pos : i+1]
+		}
+		if !(c == '_' || unicode.IsLetter(c) || (i > s.pos+1 && unicode.IsDigit(c))) {
+			return ""
+		}
+	}
+	return ""
+}
+
+// consumeDollarQuoted copies a Postgres or Snowflake dollar-quoted string such as a function body
+func (s *statementSplitter) consumeDollarQuoted() {
+	tag := s.dollarTag()
+	start := s.pos
+	end := strings.Index(s.input[s.pos+len(tag):], tag)
+	if end == -1 {
+		s.pos = len(s.input)
+	} else {
+		s.pos += len(tag) + end + len(tag)
+	}
+	s.current.WriteString(s.input[start:s.pos])
+	s.hasContent = true
+}
+
+// consumeAlternativeQuoted copies an Oracle q'[...]' string, which ends with the closing counterpart of its
+// opening delimiter followed by a quote
+func (s *statementSplitter) consumeAlternativeQuoted() {
+	start := s.pos
+	closing := s.input[s.pos+2]
+	switch closing {
+	case '[':
+		closing = ']'
+	case '{':
+		closing = '}'
+	case '(':
+		closing = ')'
+	case '<':
+		closing = '>'
+	}
+	end := strings.Index(s.input[s.pos+3:], string(closing)+"'")
+	if end == -1 {
+		s.pos = len(s.input)
+	} else {
+		s.pos += 3 + end + 2
+	}
+	s.current.WriteString(s.input[start:s.pos])
+	s.hasContent = true
+}
+
+// consumeWord copies a keyword or identifier and tracks SQLite trigger bodies, Postgres BEGIN ATOMIC bodies
+// and Oracle PL/SQL blocks
+func (s *statementSplitter) consumeWord() {
+	start := s.pos
+	for s.pos < len(s.input) && isIdentifierPart(rune(s.input[s.pos])) {
+		s.pos++
+	}
+	word := strings.ToUpper(s.input[start:s.pos])
+	s.current.WriteString(s.input[start:s.pos])
+	s.hasContent = true
+
+	if s.driver == "postgres" {
+		// the SQL-standard body of a function or procedure, whose CASE expressions also end with END
+		previous := s.lastWord
+		s.lastWord = word
+		switch {
+		case word == "ATOMIC" && previous == "BEGIN":
+			s.blockDepth++
+		case word == "CASE" && s.blockDepth > 0:
+			s.blockDepth++
+		case word == "END" && s.blockDepth > 0:
+			s.blockDepth--
+		}
+		return
+	}
+
+	if s.driver == "oracle" {
+		if len(s.leadingWords) < 5 {
+			s.leadingWords = append(s.leadingWords, word)
+		}
+		if s.isPLSQLBlock() {
+			s.blockDepth = 1 // the block ends with a "/" line only
+		}
+		return
+	}
+	if s.driver != "sqlite3" {
+		return
+	}
+	if len(s.leadingWords) < 3 {
+		s.leadingWords = append(s.leadingWords, word)
+	}
+	if !s.isTrigger() {
+		return
+	}
+	switch word {
+	case "BEGIN":
+		s.blockDepth++
+	case "CASE":
+		if s.blockDepth > 0 {
+			s.blockDepth++
+		}
+	case "END":
+		if s.blockDepth > 0 {
+			s.blockDepth--
+		}
+	}
+}
+
+// isTrigger reports whether the current statement is a CREATE [TEMP] TRIGGER statement
+func (s *statementSplitter) isTrigger() bool {
+	if len(s.leadingWords) < 2 || s.leadingWords[0] != "CREATE" {
+		return false
+	}
+	return s.leadingWords[1] == "TRIGGER" || (len(s.leadingWords) > 2 && s.leadingWords[2] == "TRIGGER")
+}
+
+// isPLSQLBlock reports whether the current statement is an Oracle anonymous block or creates a stored
+// procedure, function, package, trigger or type, whose semicolons do not end the statement
+func (s *statementSplitter) isPLSQLBlock() bool {
+	words := s.leadingWords
+	if len(words) > 0 && (words[0] == "DECLARE" || words[0] == "BEGIN") {
+		return true
+	}
+	if len(words) == 0 || words[0] != "CREATE" {
+		return false
+	}
+	for _, word := range words[1:] {
+		switch word {
+		case "OR", "REPLACE", "EDITIONABLE", "NONEDITIONABLE", "EDITIONING":
+			continue
+		case "PROCEDURE", "FUNCTION", "PACKAGE", "TRIGGER", "TYPE":
+			return true
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+func isIdentifierStart(c rune) bool {
+	return c == '_' || unicode.IsLetter(c)
+}
+
+func isIdentifierPart(c rune) bool {
+	return c == '_' || unicode.IsLetter(c) || unicode.IsDigit(c)
+}
@@ -0,0 +1,185 @@
+package gosmm
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	statements := splitStatements(`
+		CREATE TABLE test_table (id INTEGER);
+		INSERT INTO test_table VALUES (1);
+	`, "sqlite3")
+	assert.Equal(t, []string{
+		"CREATE TABLE test_table (id INTEGER)",
+		"INSERT INTO test_table VALUES (1)",
+	}, statements)
+}
+
+func TestSplitStatementsWithSemicolonInString(t *testing.T) {
+	statements := splitStatements(`INSERT INTO notes VALUES ('a;b', 'it''s; fine');INSERT INTO notes VALUES ("c;d")`, "postgres")
+	assert.Equal(t, []string{
+		"INSERT INTO notes VALUES ('a;b', 'it''s; fine')",
+		`INSERT INTO notes VALUES ("c;d")`,
+	}, statements)
+}
+
+func TestSplitStatementsWithMySQLBackslashEscape(t *testing.T) {
+	statements := splitStatements(`INSERT INTO notes VALUES ('it\'s; fine');SELECT 1`, "mysql")
+	assert.Equal(t, []string{
+		`INSERT INTO notes VALUES ('it\'s; fine')`,
+		"SELECT 1",
+	}, statements)
+}
+
+func TestSplitStatementsWithPostgresEscapeString(t *testing.T) {
+	statements := splitStatements(`INSERT INTO notes VALUES (E'\'', e'it\'s; here', 'C:\');SELECT 1`, "postgres")
+	assert.Equal(t, []string{
+		`INSERT INTO notes VALUES (E'\'', e'it\'s; here', 'C:\')`,
+		"SELECT 1",
+	}, statements)
+	// a backslash does not escape the quote of a standard string
+	statements = splitStatements(`SELECT 'a\';SELECT 1`, "postgres")
+	assert.Equal(t, []string{`SELECT 'a\'`, "SELECT 1"}, statements)
+}
+
+func TestSplitStatementsWithComments(t *testing.T) {
+	statements := splitStatements(`
+		-- create the table; then seed it
+		CREATE TABLE test_table (id INTEGER); /* trailing; comment */
+		-- only a comment;
+	`, "sqlite3")
+	assert.Equal(t, []string{
+		"-- create the table; then seed it\n\t\tCREATE TABLE test_table (id INTEGER)",
+	}, statements)
+}
+
+func TestSplitStatementsWithDollarQuotedBody(t *testing.T) {
+	statements := splitStatements(`
+CREATE FUNCTION touch() RETURNS trigger AS $body$
+BEGIN
+	NEW.updated_at := now();
+	RETURN NEW;
+END;
+$body$ LANGUAGE plpgsql;
+SELECT $1;
+`, "postgres")
+	assert.Equal(t, []string{
+		"CREATE FUNCTION touch() RETURNS trigger AS $body$\nBEGIN\n\tNEW.updated_at := now();\n\tRETURN NEW;\nEND;\n$body$ LANGUAGE plpgsql",
+		"SELECT $1",
+	}, statements)
+}
+
+func TestSplitStatementsWithBeginAtomicBody(t *testing.T) {
+	statements := splitStatements(`
+CREATE FUNCTION sign_of(n integer) RETURNS text LANGUAGE sql
+BEGIN ATOMIC
+	SELECT CASE WHEN n > 0 THEN 'positive' ELSE 'other' END;
+END;
+CREATE PROCEDURE seed() LANGUAGE sql begin atomic INSERT INTO test_table VALUES (1); INSERT INTO test_table VALUES (2); end;
+BEGIN;
+SELECT 1;
+`, "postgres")
+	assert.Equal(t, []string{
+		"CREATE FUNCTION sign_of(n integer) RETURNS text LANGUAGE sql\nBEGIN ATOMIC\n\tSELECT CASE WHEN n > 0 THEN 'positive' ELSE 'other' END;\nEND",
+		"CREATE PROCEDURE seed() LANGUAGE sql begin atomic INSERT INTO test_table VALUES (1); INSERT INTO test_table VALUES (2); end",
+		"BEGIN",
+		"SELECT 1",
+	}, statements)
+}
+
+func TestSplitStatementsWithMySQLDelimiter(t *testing.T) {
+	statements := splitStatements(`
+DELIMITER //
+CREATE PROCEDURE seed()
+BEGIN
+	INSERT INTO test_table VALUES (1);
+	INSERT INTO test_table VALUES (2);
+END //
+DELIMITER ;
+CALL seed();
+`, "mysql")
+	assert.Equal(t, []string{
+		"CREATE PROCEDURE seed()\nBEGIN\n\tINSERT INTO test_table VALUES (1);\n\tINSERT INTO test_table VALUES (2);\nEND",
+		"CALL seed()",
+	}, statements)
+}
+
+func TestSplitStatementsWithSQLiteTrigger(t *testing.T) {
+	statements := splitStatements(`
+CREATE TRIGGER audit AFTER INSERT ON test_table
+BEGIN
+	INSERT INTO audit_log VALUES (CASE WHEN NEW.id > 0 THEN 'positive' ELSE 'other' END);
+	UPDATE counters SET n = n + 1;
+END;
+SELECT 1;
+`, "sqlite3")
+	assert.Equal(t, []string{
+		"CREATE TRIGGER audit AFTER INSERT ON test_table\nBEGIN\n\tINSERT INTO audit_log VALUES (CASE WHEN NEW.id > 0 THEN 'positive' ELSE 'other' END);\n\tUPDATE counters SET n = n + 1;\nEND",
+		"SELECT 1",
+	}, statements)
+}
+
+func TestMigrateWithTrigger(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	// Create test_migrations directory if it doesn't exist
+	if _, err := os.Stat(migrationsDir); os.IsNotExist(err) {
+		err := os.Mkdir(migrationsDir, 0755)
+		if err != nil {
+			t.Fatalf("Failed to create test_migrations directory: %v", err)
+		}
+	}
+
+	// Create a test migration file containing a trigger in the test_migrations directory
+	testMigrationFile := filepath.Join(migrationsDir, "v20230101_create_test_data_00001.sql")
+	migration := `
+CREATE TABLE test_table (id INTEGER);
+CREATE TABLE audit_log (id INTEGER);
+CREATE TRIGGER test_table_audit AFTER INSERT ON test_table
+BEGIN
+	INSERT INTO audit_log VALUES (NEW.id);
+END;
+INSERT INTO test_table VALUES (1);
+`
+	if err := ioutil.WriteFile(testMigrationFile, []byte(migration), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+
+	err := Migrate(db, migrationsDir, "sqlite3")
+	assert.NoError(t, err)
+
+	// Check the trigger fired
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM audit_log").Scan(&count)
+	if err != nil {
+		t.Fatalf("Failed to query audit_log: %v", err)
+	}
+	assert.Equal(t, 1, count)
+
+	// Delete the test migration file
+	if err := os.Remove(testMigrationFile); err != nil {
+		t.Fatalf("Failed to delete test migration file: %v", err)
+	}
+}
+
+func TestSplitStatementsWithSQLServerBatches(t *testing.T) {
+	statements := splitStatements(`
+CREATE TABLE [order;items] (id INT);
+INSERT INTO [order;items] VALUES (1);
+GO
+CREATE PROCEDURE seed AS
+BEGIN
+	INSERT INTO [order;items] VALUES (2);
+END
+go
+`, "sqlserver")
+	assert.Equal(t, []string{
+		"CREATE TABLE [order;items] (id INT);\nINSERT INTO [order;items] VALUES (1);",
+		"CREATE PROCEDURE seed AS\nBEGIN\n\tINSERT INTO [order;items] VALUES (2);\nEND",
+	}, statements)
+}
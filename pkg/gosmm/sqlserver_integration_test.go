@@ -0,0 +1,73 @@
+//go:build integration
+
+package gosmm
+
+import (
+	"database/sql"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// setupSQLServerDB connects to the SQL Server database given by GOSMM_TEST_SQLSERVER_DSN
+func setupSQLServerDB(t *testing.T) (*sql.DB, func()) {
+	dsn := os.Getenv("GOSMM_TEST_SQLSERVER_DSN")
+	if dsn == "" {
+		t.Skip("GOSMM_TEST_SQLSERVER_DSN is not set")
+	}
+	db, err := sql.Open("sqlserver", dsn)
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+
+	return db, func() {
+		db.Close()
+	}
+}
+
+func TestSQLServerMigrateWithBatches(t *testing.T) {
+	db, teardown := setupSQLServerDB(t)
+	defer teardown()
+
+	schema := "gosmm_it"
+	table := historyTableName("sqlserver", schema)
+	defer db.Exec(`DROP SCHEMA IF EXISTS [gosmm_it]`)
+	defer db.Exec(`DROP TABLE IF EXISTS ` + table)
+	defer db.Exec(`DROP TABLE IF EXISTS [order items]`)
+	defer db.Exec(`DROP PROCEDURE IF EXISTS seed_order_items`)
+
+	dir := t.TempDir()
+	migration := `
+CREATE TABLE [order items] (id INT, note NVARCHAR(20));
+GO
+CREATE PROCEDURE seed_order_items AS
+BEGIN
+	INSERT INTO [order items] VALUES (1, 'a;b');
+	INSERT INTO [order items] VALUES (2, 'c');
+END
+GO
+EXEC seed_order_items;
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_test_data_00001.sql"), []byte(migration), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlserver", Schema: schema}
+	err := MigrateWithConfig(db, config)
+	assert.NoError(t, err)
+
+	var count int
+	err = db.QueryRow(`SELECT COUNT(*) FROM [order items]`).Scan(&count)
+	if err != nil {
+		t.Fatalf("Failed to count rows: %v", err)
+	}
+	assert.Equal(t, 2, count)
+
+	// Running again applies nothing and validation passes
+	err = MigrateWithConfig(db, config)
+	assert.NoError(t, err)
+	err = Validate(db, config)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,279 @@
+package gosmm
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// squashedMarker starts the header lines of a baseline, one per migration squashed into it
+	squashedMarker = "-- gosmm:squashed "
+	// archiveDirSuffix is appended to the migrations directory to name the default archive directory
+	archiveDirSuffix = "_archive"
+)
+
+// SquashOptions configures Squash
+type SquashOptions struct {
+	// Before selects the migrations squashed: those sorting before it, e.g. "v20230101"
+	Before string
+	// ArchiveDir receives the squashed files. When empty, it is the migrations directory with an "_archive"
+	// suffix, e.g. ./migrations_archive. It must not be a subdirectory of the migrations directory, whose
+	// subdirectories are environment directories.
+	ArchiveDir string
+}
+
+// SquashResult describes a squash
+type SquashResult struct {
+	// Baseline is the migration file consolidating the squashed migrations
+	Baseline string `json:"baseline"`
+	// Squashed holds the squashed migrations in the order they were applied
+	Squashed []string `json:"squashed"`
+	// ArchiveDir is the directory the squashed files were moved to
+	ArchiveDir string `json:"archive_dir"`
+}
+
+// Squash consolidates the migrations sorting before opts.Before into a single baseline migration and moves
+// them to opts.ArchiveDir, e.g. when the migrations directory has grown so large that startup checks are slow.
+// The baseline holds the statements of the squashed files in order, rather than a dump of the current schema,
+// so that it reproduces the schema as of opts.Before including its data migrations. It is named after the
+// last squashed migration, e.g. v20221231_baseline_00042.sql, so that the following migrations keep their order.
+//
+// The squashed migrations must be applied to db, whose history is rewritten: their records are replaced with
+// a record of the baseline. The history of the other databases is rewritten the same way by their next
+// migration run, while new databases execute the baseline. Environment-scoped and Go migrations cannot be
+// squashed, and only MigrationsDir is supported.
+func Squash(db *sql.DB, config MigrationConfig, opts SquashOptions) (SquashResult, error) {
+	if opts.Before == "" {
+		return SquashResult{}, fmt.Errorf("missing version to squash the migrations before")
+	}
+	if len(config.MigrationsDirs) > 0 {
+		return SquashResult{}, fmt.Errorf("squash supports a single migrations directory")
+	}
+	if !isSupportedDriver(config.Driver) {
+		return SquashResult{}, fmt.Errorf("unsupported driver: %s", config.Driver)
+	}
+	if config.FilenamePattern != "" {
+		return SquashResult{}, fmt.Errorf("squashing requires the vYYYYMMDD_description_NNNNN.sql convention, FilenamePattern is set")
+	}
+	for name := range config.GoMigrations {
+		if name < opts.Before {
+			return SquashResult{}, fmt.Errorf("go migration %s cannot be squashed", name)
+		}
+	}
+	archiveDir := opts.ArchiveDir
+	if archiveDir == "" {
+		archiveDir = filepath.Clean(config.MigrationsDir) + archiveDirSuffix
+	}
+
+	cockroach, err := isCockroachDB(db, config.Driver)
+	if err != nil {
+		return SquashResult{}, fmt.Errorf("failed to detect database version: %w", err)
+	}
+	unlock, err := lockRun(context.Background(), db, config, historyTableName(config.Driver, config.Schema), cockroach, nil)
+	if err != nil {
+		return SquashResult{}, fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+	defer unlock()
+
+	report, err := Status(db, config)
+	if err != nil {
+		return SquashResult{}, err
+	}
+	if report.State == StateDirty {
+		return SquashResult{}, ErrDirtyState
+	}
+	states := make(map[string]MigrationState, len(report.Migrations))
+	for _, migration := range report.Migrations {
+		states[migration.Filename] = migration.State
+	}
+
+	files, err := readMigrationFiles([]string{config.MigrationsDir}, config.listing())
+	if err != nil {
+		return SquashResult{}, err
+	}
+	var squashed []migrationFile
+	for _, file := range files {
+		if file.isDir || file.name >= opts.Before {
+			continue
+		}
+		if file.environment != "" {
+			return SquashResult{}, fmt.Errorf("environment-scoped migration %s cannot be squashed", file.name)
+		}
+		if !migrationFilenamePattern.MatchString(file.name) {
+			return SquashResult{}, fmt.Errorf("migration %s does not match vYYYYMMDD_description_NNNNN.sql", file.name)
+		}
+		if states[file.name] != MigrationApplied {
+			return SquashResult{}, fmt.Errorf("migration %s is not applied, apply it before squashing", file.name)
+		}
+		if _, err := os.Stat(filepath.Join(archiveDir, file.name)); err == nil {
+			return SquashResult{}, fmt.Errorf("migration %s is already archived in %s", file.name, archiveDir)
+		}
+		squashed = append(squashed, file)
+	}
+	if len(squashed) < 2 {
+		return SquashResult{}, fmt.Errorf("less than two migrations sort before %s, nothing to squash", opts.Before)
+	}
+
+	match := migrationFilenamePattern.FindStringSubmatch(squashed[len(squashed)-1].name)
+	result := SquashResult{Baseline: fmt.Sprintf("v%s_baseline_%s.sql", match[1], match[3]), ArchiveDir: archiveDir}
+	var header, body strings.Builder
+	for _, file := range squashed {
+		data, err := config.readMigrationFile(file.path)
+		if err != nil {
+			return SquashResult{}, err
+		}
+		// a previous baseline is squashed with its statements, its own header is superseded,
+		// as are the down sections of the squashed migrations
+		content, _ := splitDown(stripSquashedHeader(string(data)))
+		fmt.Fprintf(&header, "%s%s\n", squashedMarker, file.name)
+		fmt.Fprintf(&body, "\n-- %s\n%s\n", file.name, strings.TrimSpace(content))
+		result.Squashed = append(result.Squashed, file.name)
+	}
+
+	if err := os.MkdirAll(archiveDir, 0755); err != nil {
+		return SquashResult{}, fmt.Errorf("failed to create archive directory: %w", err)
+	}
+	for _, file := range squashed {
+		if err := os.Rename(file.path, filepath.Join(archiveDir, file.name)); err != nil {
+			return SquashResult{}, fmt.Errorf("failed to archive %s: %w", file.name, err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(config.MigrationsDir, result.Baseline), []byte(header.String()+body.String()), 0644); err != nil {
+		return SquashResult{}, fmt.Errorf("failed to write baseline: %w", err)
+	}
+
+	// a failure here is recovered by the next migration run, which adopts the baseline
+	if err := adoptBaselines(db, config); err != nil {
+		return SquashResult{}, err
+	}
+	return result, nil
+}
+
+// adoptBaselines replaces the records of the migrations squashed into a baseline with a record of the baseline,
+// on a database where they were applied before the squash. A database where none of them was applied executes
+// the baseline instead.
+func adoptBaselines(db *sql.DB, config MigrationConfig) error {
+	table := historyTableName(config.Driver, config.Schema)
+	var applied map[string]appliedMigration
+	files, err := config.migrationFiles()
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if file.isDir || !file.inEnvironment(config.Environment) {
+			continue
+		}
+		squashed, err := readSquashedMigrations(file.path)
+		if err != nil {
+			return err
+		}
+		if len(squashed) == 0 {
+			continue
+		}
+		if applied == nil {
+			applied, err = getAppliedMigrations(db, config.Driver, table)
+			if err != nil {
+				return fmt.Errorf("failed to load migration history: %w", err)
+			}
+		}
+		if _, ok := applied[file.name]; ok {
+			continue
+		}
+		count := 0
+		for _, name := range squashed {
+			if _, ok := applied[name]; ok {
+				count++
+			}
+		}
+		switch count {
+		case 0:
+			continue
+		case len(squashed):
+		default:
+			return fmt.Errorf("only %d of the %d migrations squashed into %s are applied, apply the others from the archive first", count, len(squashed), file.name)
+		}
+		if err := replaceSquashedHistory(db, config, table, file, squashed); err != nil {
+			return fmt.Errorf("failed to adopt baseline %s: %w", file.name, err)
+		}
+		config.LogLevel.printf(LogInfo, "ADOPT %s (%d squashed migrations)\n", file.name, len(squashed))
+	}
+	return nil
+}
+
+// replaceSquashedHistory replaces the records of the squashed migrations with a successful record of the
+// baseline, ranked like the first squashed migration
+func replaceSquashedHistory(db *sql.DB, config MigrationConfig, table string, baseline migrationFile, squashed []string) error {
+	data, err := ioutil.ReadFile(baseline.path)
+	if err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+	checksum, err := config.checksum(data)
+	if err != nil {
+		return err
+	}
+	migration := MigrationInfo{Filename: baseline.name, Checksum: checksum}
+	driver := config.Driver
+
+	ctx := context.Background()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	for _, name := range squashed {
+		var rank sql.NullInt64
+		if err := tx.QueryRow("SELECT MIN(installed_rank) FROM "+table+" WHERE filename = "+bindParams(driver, 1), name).Scan(&rank); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if rank.Valid && (migration.InstalledRank == 0 || int(rank.Int64) < migration.InstalledRank) {
+			migration.InstalledRank = int(rank.Int64)
+		}
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE filename = "+bindParams(driver, 1), name); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to delete history of %s: %w", name, err)
+		}
+	}
+	return recordMigration(tx, table, migration, time.Now(), true, nil, driver)
+}
+
+// readSquashedMigrations returns the migrations listed in the header of a baseline, none for other files
+func readSquashedMigrations(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	defer f.Close()
+
+	var squashed []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name, ok := strings.CutPrefix(strings.TrimRight(scanner.Text(), "\r"), squashedMarker)
+		if !ok {
+			break
+		}
+		squashed = append(squashed, strings.TrimSpace(name))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	return squashed, nil
+}
+
+// stripSquashedHeader removes the header of a baseline from its content
+func stripSquashedHeader(content string) string {
+	for strings.HasPrefix(content, squashedMarker) {
+		end := strings.IndexByte(content, '\n')
+		if end < 0 {
+			return ""
+		}
+		content = content[end+1:]
+	}
+	return content
+}
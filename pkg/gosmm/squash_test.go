@@ -0,0 +1,133 @@
+package gosmm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSquash(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+	otherDB, teardownOther := setupTestDB(t)
+	defer teardownOther()
+	newDB, teardownNew := setupTestDB(t)
+	defer teardownNew()
+
+	dir := t.TempDir()
+	migrations := map[string]string{
+		"v20230101_create_users_00001.sql": "CREATE TABLE users (id INTEGER);",
+		"v20230102_add_email_00002.sql":    "ALTER TABLE users ADD COLUMN email TEXT;\nINSERT INTO users (id, email) VALUES (1, 'admin@example.com');",
+		"v20230201_create_posts_00003.sql": "CREATE TABLE posts (id INTEGER, user_id INTEGER);",
+	}
+	for name, content := range migrations {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test migration file: %v", err)
+		}
+	}
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}
+	assert.NoError(t, MigrateWithConfig(db, config))
+	assert.NoError(t, MigrateWithConfig(otherDB, config))
+
+	archiveDir := filepath.Join(t.TempDir(), "archive")
+	result, err := Squash(db, config, SquashOptions{Before: "v20230201", ArchiveDir: archiveDir})
+	assert.NoError(t, err)
+	assert.Equal(t, SquashResult{
+		Baseline:   "v20230102_baseline_00002.sql",
+		Squashed:   []string{"v20230101_create_users_00001.sql", "v20230102_add_email_00002.sql"},
+		ArchiveDir: archiveDir,
+	}, result)
+
+	// The squashed files are archived and consolidated into the baseline
+	for _, name := range result.Squashed {
+		_, err := os.Stat(filepath.Join(archiveDir, name))
+		assert.NoError(t, err)
+		_, err = os.Stat(filepath.Join(dir, name))
+		assert.True(t, os.IsNotExist(err))
+	}
+	baseline, err := ioutil.ReadFile(filepath.Join(dir, result.Baseline))
+	assert.NoError(t, err)
+	assert.Equal(t, `-- gosmm:squashed v20230101_create_users_00001.sql
+-- gosmm:squashed v20230102_add_email_00002.sql
+
+-- v20230101_create_users_00001.sql
+CREATE TABLE users (id INTEGER);
+
+-- v20230102_add_email_00002.sql
+ALTER TABLE users ADD COLUMN email TEXT;
+INSERT INTO users (id, email) VALUES (1, 'admin@example.com');
+`, string(baseline))
+
+	// The history is rewritten consistently
+	history, err := GetHistory(db, config)
+	assert.NoError(t, err)
+	assert.Len(t, history, 2)
+	assert.Equal(t, 1, history[0].InstalledRank)
+	assert.Equal(t, result.Baseline, history[0].Filename)
+	assert.Equal(t, calculateChecksum(baseline), history[0].Checksum)
+	assert.Equal(t, "v20230201_create_posts_00003.sql", history[1].Filename)
+	assert.NoError(t, Validate(db, config))
+	assert.NoError(t, MigrateWithConfig(db, config))
+
+	// Databases migrated before the squash adopt the baseline on their next run
+	assert.NoError(t, MigrateWithConfig(otherDB, config))
+	otherHistory, err := GetHistory(otherDB, config)
+	assert.NoError(t, err)
+	assert.Len(t, otherHistory, 2)
+	assert.Equal(t, result.Baseline, otherHistory[0].Filename)
+	assert.NoError(t, Validate(otherDB, config))
+
+	// New databases execute the baseline
+	assert.NoError(t, MigrateWithConfig(newDB, config))
+	var email string
+	err = newDB.QueryRow("SELECT email FROM users WHERE id = 1").Scan(&email)
+	assert.NoError(t, err)
+	assert.Equal(t, "admin@example.com", email)
+	report, err := Status(newDB, config)
+	assert.NoError(t, err)
+	assert.Equal(t, StateUpToDate, report.State)
+	assert.Equal(t, 2, report.Applied)
+}
+
+func TestSquashErrors(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230102_create_posts_00002.sql"), []byte("CREATE TABLE posts (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}
+
+	_, err := Squash(db, config, SquashOptions{})
+	assert.Error(t, err)
+
+	// Only applied migrations can be squashed
+	_, err = Squash(db, config, SquashOptions{Before: "v20230201"})
+	assert.EqualError(t, err, "migration v20230101_create_users_00001.sql is not applied, apply it before squashing")
+
+	assert.NoError(t, MigrateWithConfig(db, config))
+	_, err = Squash(db, config, SquashOptions{Before: "v20230102"})
+	assert.EqualError(t, err, "less than two migrations sort before v20230102, nothing to squash")
+
+	// A database that applied part of the squashed migrations cannot adopt the baseline
+	_, err = db.Exec("DELETE FROM gosmm_migration_history")
+	assert.NoError(t, err)
+	_, err = db.Exec("INSERT INTO gosmm_migration_history (installed_rank, filename, installed_on, execution_time, success) VALUES (1, 'v20230101_create_users_00001.sql', CURRENT_TIMESTAMP, 0, true)")
+	assert.NoError(t, err)
+	baseline := "-- gosmm:squashed v20230101_create_users_00001.sql\n-- gosmm:squashed v20230102_create_posts_00002.sql\n\nCREATE TABLE users (id INTEGER);\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230102_baseline_00002.sql"), []byte(baseline), 0644); err != nil {
+		t.Fatalf("Failed to create baseline: %v", err)
+	}
+	for _, name := range []string{"v20230101_create_users_00001.sql", "v20230102_create_posts_00002.sql"} {
+		os.Remove(filepath.Join(dir, name))
+	}
+	err = MigrateWithConfig(db, config)
+	assert.EqualError(t, err, "only 1 of the 2 migrations squashed into v20230102_baseline_00002.sql are applied, apply the others from the archive first")
+}
@@ -3,15 +3,21 @@ package gosmm
 import (
 	"database/sql"
 	"fmt"
+	"time"
 )
 
 // DisplayStatus displays the migration status
 func DisplayStatus(db *sql.DB) error {
+	return DisplayStatusWithConfig(db, MigrationConfig{})
+}
+
+// DisplayStatusWithConfig displays the migration status of the history table described by the given MigrationConfig
+func DisplayStatusWithConfig(db *sql.DB, config MigrationConfig) error {
 	// Create history table if it doesn't exist
-	err := createHistoryTable(db)
+	err := createHistoryTable(db, config.Driver, config.Schema)
 
 	// SQL query to fetch migration statuses from the migration history table
-	rows, err := db.Query("SELECT installed_rank, filename, installed_on, execution_time, success FROM gosmm_migration_history ORDER BY installed_rank ASC")
+	rows, err := db.Query("SELECT installed_rank, filename, installed_on, execution_time, success, failed_statement FROM " + historyTableName(config.Driver, config.Schema) + " ORDER BY installed_rank ASC")
 	if err != nil {
 		fmt.Printf("Error fetching migration status: %v\n", err)
 		return fmt.Errorf("failed to execute status query: %w", err)
@@ -34,9 +40,10 @@ func DisplayStatus(db *sql.DB) error {
 			installedOn   string
 			executionTime int
 			success       bool
+			failedAt      sql.NullInt64
 		)
 
-		if err := rows.Scan(&installedRank, &filename, &installedOn, &executionTime, &success); err != nil {
+		if err := rows.Scan(&installedRank, &filename, &installedOn, &executionTime, &success, &failedAt); err != nil {
 			fmt.Printf("Error reading row: %v\n", err)
 			return fmt.Errorf("failed to read row: %w", err)
 		}
@@ -45,6 +52,8 @@ func DisplayStatus(db *sql.DB) error {
 		successText := "No"
 		if success {
 			successText = "Yes"
+		} else if failedAt.Valid {
+			successText = fmt.Sprintf("No (statement %d)", failedAt.Int64)
 		}
 
 		// Print the migration status
@@ -59,3 +68,184 @@ func DisplayStatus(db *sql.DB) error {
 
 	return nil
 }
+
+// MigrationState is the state of a migration in a StatusReport
+type MigrationState string
+
+const (
+	// MigrationApplied means the migration was applied successfully
+	MigrationApplied MigrationState = "applied"
+	// MigrationPending means the migration was not applied yet
+	MigrationPending MigrationState = "pending"
+	// MigrationFailed means the migration failed and the database is dirty
+	MigrationFailed MigrationState = "failed"
+	// MigrationSkipped means the migration is not applied, as listed in MigrationConfig.Skip
+	MigrationSkipped MigrationState = "skipped"
+)
+
+// DatabaseState summarizes the migrations of a StatusReport
+type DatabaseState string
+
+const (
+	// StateUpToDate means every migration was applied
+	StateUpToDate DatabaseState = "up-to-date"
+	// StatePending means migrations are waiting to be applied
+	StatePending DatabaseState = "pending"
+	// StateDirty means a migration failed, see DirtyMigrations
+	StateDirty DatabaseState = "dirty"
+)
+
+// MigrationStatus is the state of a single migration
+type MigrationStatus struct {
+	Filename string         `json:"filename"`
+	State    MigrationState `json:"state"`
+	// InstalledRank, InstalledOn, ExecutionTime and RowsAffected are only set for applied and failed migrations
+	InstalledRank int        `json:"installed_rank,omitempty"`
+	InstalledOn   *time.Time `json:"installed_on,omitempty"`
+	// ExecutionTime is the execution time in milliseconds
+	ExecutionTime int64 `json:"execution_time,omitempty"`
+	// RowsAffected is the number of rows affected by the statements of the migration, zero when unknown
+	RowsAffected int64 `json:"rows_affected,omitempty"`
+	// FailedStatement is the 1-based index of the failed statement, zero when unknown or successful
+	FailedStatement int `json:"failed_statement,omitempty"`
+	// Author, Ticket and Description are read from the history table for the applied and failed migrations,
+	// and from the header of the file for the pending ones, see MigrationMetadata
+	Author      string `json:"author,omitempty"`
+	Ticket      string `json:"ticket,omitempty"`
+	Description string `json:"description,omitempty"`
+	// Labels are read from the header of the file, see MigrationMetadata.Labels
+	Labels []string `json:"labels,omitempty"`
+	// Pruned is set for the applied migrations whose history row was deleted by Prune
+	Pruned bool `json:"pruned,omitempty"`
+}
+
+// StatusReport holds the applied, failed and pending migrations of a database
+type StatusReport struct {
+	State   DatabaseState `json:"state"`
+	Applied int           `json:"applied"`
+	Pending int           `json:"pending"`
+	Failed  int           `json:"failed"`
+	Skipped int           `json:"skipped,omitempty"`
+	// Migrations holds the migrations pruned from the history table and the migrations of the history table
+	// in the order they were applied, followed by the pending migrations in the order they will be applied
+	Migrations []MigrationStatus `json:"migrations"`
+}
+
+// ExitCode returns the exit code of `gosmm status` for the report: 0 when the database is up to date,
+// 1 when migrations are pending and 2 when it is dirty
+func (r StatusReport) ExitCode() int {
+	switch r.State {
+	case StateDirty:
+		return 2
+	case StatePending:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Status returns the state of every migration, comparing the history table with the migration files
+// of config.Environment and the Go migrations. It never modifies the database, so it does not create
+// the history table.
+func Status(db *sql.DB, config MigrationConfig) (StatusReport, error) {
+	report := StatusReport{Migrations: make([]MigrationStatus, 0)}
+
+	history, err := GetHistory(db, config)
+	if err != nil {
+		return report, err
+	}
+	// a migration resumed or forced after a failure has several rows, the last one is its state
+	indexes := make(map[string]int, len(history))
+	for _, entry := range history {
+		installedOn := entry.InstalledOn
+		status := MigrationStatus{
+			Filename:        entry.Filename,
+			State:           MigrationApplied,
+			InstalledRank:   entry.InstalledRank,
+			InstalledOn:     &installedOn,
+			ExecutionTime:   entry.ExecutionTime,
+			RowsAffected:    entry.RowsAffected,
+			FailedStatement: entry.FailedStatement,
+			Author:          entry.Author,
+			Ticket:          entry.Ticket,
+			Description:     entry.Description,
+		}
+		if !entry.Success {
+			status.State = MigrationFailed
+		}
+		if i, ok := indexes[entry.Filename]; ok {
+			report.Migrations[i] = status
+			continue
+		}
+		indexes[entry.Filename] = len(report.Migrations)
+		report.Migrations = append(report.Migrations, status)
+	}
+
+	allFiles, err := config.migrationFiles()
+	if err != nil {
+		return report, err
+	}
+	files := make([]migrationFile, 0, len(allFiles))
+	metadata := make(map[string]MigrationMetadata, len(allFiles))
+	for _, file := range allFiles {
+		if file.inEnvironment(config.Environment) {
+			files = append(files, file)
+			metadata[file.name] = file.metadata
+		}
+	}
+	naming, err := config.naming()
+	if err != nil {
+		return report, err
+	}
+	filenames, err := migrationNames(files, config.GoMigrations, naming)
+	if err != nil {
+		return report, err
+	}
+	through, err := prunedThrough(db, config, naming)
+	if err != nil {
+		return report, err
+	}
+	var pruned []MigrationStatus
+	for _, filename := range filenames {
+		if _, ok := indexes[filename]; ok {
+			continue
+		}
+		if pruneCovers(naming, through, filename) {
+			pruned = append(pruned, MigrationStatus{Filename: filename, State: MigrationApplied, Pruned: true})
+			continue
+		}
+		status := MigrationStatus{Filename: filename, State: MigrationPending}
+		if config.skips(filename) {
+			status.State = MigrationSkipped
+		}
+		header := metadata[filename]
+		status.Author, status.Ticket, status.Description = header.Author, header.Ticket, header.Description
+		report.Migrations = append(report.Migrations, status)
+	}
+	report.Migrations = append(pruned, report.Migrations...)
+	for i := range report.Migrations {
+		report.Migrations[i].Labels = metadata[report.Migrations[i].Filename].Labels
+	}
+
+	for _, migration := range report.Migrations {
+		switch migration.State {
+		case MigrationApplied:
+			report.Applied++
+		case MigrationPending:
+			report.Pending++
+		case MigrationFailed:
+			report.Failed++
+		case MigrationSkipped:
+			report.Skipped++
+		}
+	}
+	switch {
+	case report.Failed > 0:
+		report.State = StateDirty
+	case report.Pending > 0:
+		report.State = StatePending
+	default:
+		report.State = StateUpToDate
+	}
+	return report, nil
+}
@@ -2,9 +2,13 @@ package gosmm
 
 import (
 	"bytes"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestDisplayStatusWithNoHistoryRecord(t *testing.T) {
@@ -238,3 +242,70 @@ func TestDisplayStatusWithMultipleHistoryRecords(t *testing.T) {
 		t.Errorf("Unexpected output: %s", output)
 	}
 }
+
+func TestStatus(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}
+
+	// Without a history table every migration is pending, and the table is not created
+	report, err := Status(db, config)
+	assert.NoError(t, err)
+	assert.Equal(t, StatePending, report.State)
+	assert.Equal(t, 1, report.ExitCode())
+	assert.Equal(t, []MigrationStatus{{Filename: "v20230101_create_users_00001.sql", State: MigrationPending}}, report.Migrations)
+	exists, err := historyTableExists(db, "sqlite3", "")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	err = MigrateWithConfig(db, config)
+	assert.NoError(t, err)
+	report, err = Status(db, config)
+	assert.NoError(t, err)
+	assert.Equal(t, StateUpToDate, report.State)
+	assert.Equal(t, 0, report.ExitCode())
+	assert.Equal(t, 1, report.Applied)
+	assert.Equal(t, MigrationApplied, report.Migrations[0].State)
+	assert.Equal(t, 1, report.Migrations[0].InstalledRank)
+
+	// A failed migration makes the database dirty, even with pending migrations after it
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230102_add_email_00002.sql"), []byte("ALTER TABLE missing_table ADD COLUMN email TEXT;"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230103_add_name_00003.sql"), []byte("ALTER TABLE users ADD COLUMN name TEXT;"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	err = MigrateWithConfig(db, config)
+	assert.Error(t, err)
+	report, err = Status(db, config)
+	assert.NoError(t, err)
+	assert.Equal(t, StateDirty, report.State)
+	assert.Equal(t, 2, report.ExitCode())
+	assert.Equal(t, 1, report.Applied)
+	assert.Equal(t, 1, report.Failed)
+	assert.Equal(t, 1, report.Pending)
+	assert.Equal(t, MigrationFailed, report.Migrations[1].State)
+	assert.Equal(t, 1, report.Migrations[1].FailedStatement)
+	assert.Equal(t, "v20230103_add_name_00003.sql", report.Migrations[2].Filename)
+
+	// Forcing the failed migration leaves the pending one
+	err = Force(db, config, "v20230102_add_email_00002.sql", true)
+	assert.NoError(t, err)
+	report, err = Status(db, config)
+	assert.NoError(t, err)
+	assert.Equal(t, StatePending, report.State)
+	assert.Equal(t, 2, report.Applied)
+}
+
+func TestStatusWithMissingMigrationsDir(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	_, err := Status(db, MigrationConfig{MigrationsDir: filepath.Join(t.TempDir(), "missing"), Driver: "sqlite3"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,225 @@
+package gosmm
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// streamChunkSize is the number of bytes of a streamed file split at once. A statement longer than that is
+// split again with twice as many bytes, so that splitting it stays linear in its length.
+var streamChunkSize = 1 << 20
+
+// statementStream yields the statements of a migration file one at a time
+type statementStream interface {
+	// next returns the next statement, or false after the last one
+	next() (string, bool, error)
+	close() error
+}
+
+// sliceStream is a statementStream over the statements of a file read in memory
+type sliceStream struct {
+	statements []string
+}
+
+func (s *sliceStream) next() (string, bool, error) {
+	if len(s.statements) == 0 {
+		return "", false, nil
+	}
+	statement := s.statements[0]
+	s.statements = s.statements[1:]
+	return statement, true, nil
+}
+
+func (s *sliceStream) close() error {
+	return nil
+}
+
+// statementReader is a statementStream reading the statements of a migration file as they are executed, so that
+// the memory it uses is bounded by its longest statement rather than its size. The placeholders are replaced in
+// each statement, and the file is read up to its "-- gosmm:down" line.
+type statementReader struct {
+	file         *os.File
+	reader       *bufio.Reader
+	splitter     *statementSplitter
+	placeholders map[string]string
+	// dir is the directory of the file, which the paths of its load directives are relative to
+	dir string
+	// buffer holds the lines read after the last complete statement, lineStart whether it starts a line
+	buffer     string
+	lineStart  bool
+	statements []string
+	eof        bool
+	// read is the number of bytes read from the file
+	read int64
+}
+
+// openStatementReader opens the migration file at path for a statementReader splitting it for driver
+func openStatementReader(path string, driver string, placeholders map[string]string) (*statementReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	splitter := &statementSplitter{driver: driver, delimiter: defaultDelimiter}
+	if driver == "sqlserver" {
+		splitter.delimiter = "" // batches are separated by GO lines only
+	}
+	return &statementReader{file: file, reader: bufio.NewReader(file), splitter: splitter, placeholders: placeholders,
+		dir: filepath.Dir(path), lineStart: true}, nil
+}
+
+func (r *statementReader) next() (string, bool, error) {
+	for len(r.statements) == 0 {
+		if r.eof {
+			return "", false, nil
+		}
+		if err := r.fill(); err != nil {
+			return "", false, err
+		}
+	}
+	statement := r.statements[0]
+	r.statements = r.statements[1:]
+	statement, err := replacePlaceholders(statement, r.placeholders)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to replace placeholders: %w", err)
+	}
+	return resolveLoad(statement, r.dir), true, nil
+}
+
+// fill reads the next lines of the file and splits the statements they complete. Whole lines are split, so that
+// the line directives and the tokens looked ahead by the splitter are never cut.
+func (r *statementReader) fill() error {
+	size := streamChunkSize
+	if 2*len(r.buffer) > size {
+		size = 2 * len(r.buffer)
+	}
+	var chunk strings.Builder
+	chunk.WriteString(r.buffer)
+	for chunk.Len() < size {
+		line, err := r.reader.ReadString('\n')
+		r.read += int64(len(line))
+		if strings.TrimSpace(line) == downMarker {
+			r.eof = true // the down section is only executed by Redo
+			break
+		}
+		chunk.WriteString(line)
+		if err == io.EOF {
+			r.eof = true
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read file: %w", err)
+		}
+	}
+
+	s := r.splitter
+	s.input, s.pos, s.statements = chunk.String(), 0, nil
+	s.boundary, s.boundaryDelimiter = 0, s.delimiter
+	if r.lineStart {
+		s.handleLineDirective()
+	}
+	s.scan()
+	if r.eof {
+		s.flush() // the last statement ends with the file
+		r.statements, r.buffer = s.statements, ""
+		return nil
+	}
+	// the statement after the boundary is split again with the next lines
+	s.reset()
+	s.delimiter = s.boundaryDelimiter
+	if s.boundary > 0 {
+		r.lineStart = s.input[s.boundary-1] == '\n'
+	}
+	r.statements, r.buffer = s.statements, strings.Clone(s.input[s.boundary:])
+	return nil
+}
+
+func (r *statementReader) close() error {
+	return r.file.Close()
+}
+
+// streamedFileSize returns the size of the migration or seed file at path and whether it is streamed, see
+// MigrationConfig.StreamThreshold
+func (c MigrationConfig) streamedFileSize(path string) (int64, bool) {
+	if c.StreamThreshold <= 0 || strings.HasSuffix(path, templateFileExtension) || dialectFor(c.Driver) != nil ||
+		c.Checksum.Func != nil || c.Checksum.IgnoreLineEndings || c.Checksum.IgnoreWhitespace || c.Checksum.IgnoreComments {
+		return 0, false
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Size() < c.StreamThreshold {
+		return 0, false
+	}
+	return info.Size(), true
+}
+
+// scanStreamedFile reads the migration or seed file at path once, returning its checksum and the metadata of its
+// header
+func (c MigrationConfig) scanStreamedFile(path string) (string, MigrationMetadata, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", MigrationMetadata{}, fmt.Errorf("failed to read file: %w", err)
+	}
+	defer file.Close()
+
+	hash, sum, err := c.checksumWriter()
+	if err != nil {
+		return "", MigrationMetadata{}, err
+	}
+	reader := bufio.NewReader(io.TeeReader(file, hash))
+	header, err := readHeader(reader)
+	if err != nil {
+		return "", MigrationMetadata{}, fmt.Errorf("failed to read file: %w", err)
+	}
+	metadata, err := parseMetadata(header)
+	if err != nil {
+		return "", MigrationMetadata{}, fmt.Errorf("invalid header of %s: %w", path, err)
+	}
+	if _, err := io.Copy(ioutil.Discard, reader); err != nil {
+		return "", MigrationMetadata{}, fmt.Errorf("failed to read file: %w", err)
+	}
+	return sum(), metadata, nil
+}
+
+// streamMigration returns the function executing the streamed migration file at path of the given size,
+// setting the checksum and the metadata of migration and recording its committed statements with committed. Only
+// its header marks a streamed file as destructive.
+func streamMigration(ctx context.Context, config MigrationConfig, migration *MigrationInfo, path string, size int64, skip int, committed commitRecorder, progress func(Event)) (func(ctx context.Context, conn *sql.Conn, tx *sql.Tx) error, error) {
+	var err error
+	if migration.Checksum, migration.Metadata, err = config.scanStreamedFile(path); err != nil {
+		return nil, err
+	}
+	if config.Backup != nil && isDestructive(migration.Metadata, nil) {
+		if err := backupMigration(ctx, config, migration); err != nil {
+			return nil, err
+		}
+	}
+	config.LogLevel.printf(LogDebug, "STREAM %s (%d bytes)\n", migration.Filename, size)
+
+	var reader *statementReader
+	logStatement := config.LogLevel.statementLogger(migration.Filename)
+	return executeStatementStream(migration.Filename, func() (statementStream, error) {
+		var err error
+		reader, err = openStatementReader(path, config.Driver, config.Placeholders)
+		return reader, err
+	}, skip, config.Driver, config.Idempotent, committed, func(index int, statement string, duration time.Duration, rowsAffected int64) {
+		logStatement(index, statement, duration, rowsAffected)
+		migration.RowsAffected += rowsAffected
+		progress(Event{
+			Kind:           EventStatementExecuted,
+			Migration:      *migration,
+			StatementIndex: index,
+			Statement:      statement,
+			Duration:       duration,
+			RowsAffected:   rowsAffected,
+			BytesRead:      reader.read,
+			FileSize:       size,
+		})
+	}), nil
+}
@@ -0,0 +1,150 @@
+package gosmm
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// readStatements returns the statements of the file at path read by a statementReader
+func readStatements(t *testing.T, path string, driver string) []string {
+	reader, err := openStatementReader(path, driver, nil)
+	if err != nil {
+		t.Fatalf("Failed to open statement reader: %v", err)
+	}
+	defer reader.close()
+
+	var statements []string
+	for {
+		statement, ok, err := reader.next()
+		assert.NoError(t, err)
+		if !ok {
+			return statements
+		}
+		statements = append(statements, statement)
+	}
+}
+
+func TestStatementReader(t *testing.T) {
+	defer func(size int) { streamChunkSize = size }(streamChunkSize)
+	streamChunkSize = 16 // statements span several chunks
+
+	for _, tc := range []struct {
+		driver  string
+		content string
+	}{
+		{"sqlite3", "CREATE TABLE test_table (id INTEGER, name TEXT);\nINSERT INTO test_table VALUES (1, 'a;b');\n-- a comment; with a semicolon\nINSERT INTO test_table VALUES (2, 'c');"},
+		{"sqlite3", "CREATE TRIGGER audit AFTER INSERT ON test_table\nBEGIN\n\tUPDATE counters SET n = n + 1;\nEND;\nSELECT 1;\n"},
+		{"postgres", "CREATE FUNCTION touch() RETURNS trigger AS $body$\nBEGIN\n\tNEW.updated_at := now();\n\tRETURN NEW;\nEND;\n$body$ LANGUAGE plpgsql;\nSELECT 1;"},
+		{"mysql", "DELIMITER //\nCREATE PROCEDURE seed()\nBEGIN\n\tINSERT INTO test_table VALUES (1);\nEND //\nDELIMITER ;\nCALL seed();\nCALL seed();\n"},
+		{"sqlserver", "CREATE TABLE [order;items] (id INT);\nINSERT INTO [order;items] VALUES (1);\nGO\nCREATE PROCEDURE seed AS\nBEGIN\n\tINSERT INTO [order;items] VALUES (2);\nEND\ngo\n"},
+	} {
+		path := filepath.Join(t.TempDir(), "v20230101_test_00001.sql")
+		if err := ioutil.WriteFile(path, []byte(tc.content), 0644); err != nil {
+			t.Fatalf("Failed to create migration file: %v", err)
+		}
+		assert.Equal(t, splitStatements(tc.content, tc.driver), readStatements(t, path, tc.driver), tc.driver)
+	}
+}
+
+func TestStatementReaderStopsAtDownMarker(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "v20230101_test_00001.sql")
+	if err := ioutil.WriteFile(path, []byte("CREATE TABLE test_table (id INTEGER);\n-- gosmm:down\nDROP TABLE test_table;\n"), 0644); err != nil {
+		t.Fatalf("Failed to create migration file: %v", err)
+	}
+	assert.Equal(t, []string{"CREATE TABLE test_table (id INTEGER)"}, readStatements(t, path, "sqlite3"))
+}
+
+func TestChecksumWriter(t *testing.T) {
+	data := []byte("\ufeffCREATE TABLE test_table (id INTEGER);\r\nINSERT INTO test_table VALUES (1);\n")
+	for _, algorithm := range []ChecksumAlgorithm{ChecksumSHA256, ChecksumCRC32} {
+		config := MigrationConfig{Checksum: ChecksumConfig{Algorithm: algorithm}}
+		expected, err := config.checksum(data)
+		assert.NoError(t, err)
+
+		// the content is written in pieces smaller than the byte order mark
+		writer, sum, err := config.checksumWriter()
+		assert.NoError(t, err)
+		for i := 0; i < len(data); i += 2 {
+			end := i + 2
+			if end > len(data) {
+				end = len(data)
+			}
+			writer.Write(data[i:end])
+		}
+		assert.Equal(t, expected, sum(), algorithm)
+	}
+
+	_, _, err := MigrationConfig{Checksum: ChecksumConfig{Algorithm: "md5"}}.checksumWriter()
+	assert.Error(t, err)
+}
+
+func TestMigrateWithStreaming(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+	defer func(size int) { streamChunkSize = size }(streamChunkSize)
+	streamChunkSize = 32
+
+	dir := t.TempDir()
+	content := []byte("-- gosmm:author Jane Doe\nCREATE TABLE users (id INTEGER, name TEXT);\nINSERT INTO users VALUES (1, '${name}');\nINSERT INTO users VALUES (2, 'b'), (3, 'c');\n")
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), content, 0644); err != nil {
+		t.Fatalf("Failed to create migration file: %v", err)
+	}
+
+	var events []Event
+	config := MigrationConfig{
+		MigrationsDir:   dir,
+		Driver:          "sqlite3",
+		Placeholders:    map[string]string{"name": "a"},
+		StreamThreshold: 1,
+		Progress: func(event Event) {
+			if event.Kind == EventStatementExecuted {
+				events = append(events, event)
+			}
+		},
+	}
+	assert.NoError(t, MigrateWithConfig(db, config))
+
+	var name string
+	assert.NoError(t, db.QueryRow("SELECT name FROM users WHERE id = 1").Scan(&name))
+	assert.Equal(t, "a", name)
+	if assert.Len(t, events, 3) {
+		assert.Equal(t, int64(len(content)), events[2].BytesRead)
+		assert.Equal(t, int64(len(content)), events[2].FileSize)
+		assert.Equal(t, 0, events[2].StatementCount)
+	}
+
+	history, err := GetHistory(db, config)
+	assert.NoError(t, err)
+	if assert.Len(t, history, 1) {
+		assert.Equal(t, calculateChecksum(content), history[0].Checksum)
+		assert.Equal(t, int64(3), history[0].RowsAffected)
+		assert.Equal(t, "Jane Doe", history[0].Author)
+	}
+	assert.NoError(t, Validate(db, config))
+}
+
+func TestSeedWithStreaming(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	if _, err := db.Exec("CREATE TABLE countries (code TEXT PRIMARY KEY, name TEXT)"); err != nil {
+		t.Fatalf("Failed to create countries table: %v", err)
+	}
+	seedsDir := t.TempDir()
+	content := []byte("INSERT OR REPLACE INTO countries VALUES ('JP', 'Japan');\nINSERT OR REPLACE INTO countries VALUES ('FR', 'France');\n")
+	if err := ioutil.WriteFile(filepath.Join(seedsDir, "countries.sql"), content, 0644); err != nil {
+		t.Fatalf("Failed to create seed file: %v", err)
+	}
+	config := MigrationConfig{SeedsDir: seedsDir, Driver: "sqlite3", StreamThreshold: 1}
+	assert.NoError(t, Seed(db, config))
+
+	var count int
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM countries").Scan(&count))
+	assert.Equal(t, 2, count)
+	var checksum string
+	assert.NoError(t, db.QueryRow("SELECT checksum FROM gosmm_seed_history WHERE filename = 'countries.sql'").Scan(&checksum))
+	assert.Equal(t, calculateChecksum(content), checksum)
+}
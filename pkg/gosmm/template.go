@@ -0,0 +1,70 @@
+package gosmm
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// templateFileExtension is the extension of the migration files rendered with text/template before they are
+// executed. The migration is named after the file without .tmpl, e.g. v20230101_create_partitions_00001.sql.
+const templateFileExtension = ".sql.tmpl"
+
+// templateFuncs are the functions available to the migration templates besides the built-in ones
+var templateFuncs = template.FuncMap{
+	// env returns the value of an environment variable, "" when it is not set
+	"env": os.Getenv,
+	// now returns the current time, e.g. {{ now.Format "2006_01" }}
+	"now": time.Now,
+	// seq returns the integers from first to last, e.g. {{ range seq 1 12 }}
+	"seq": func(first int, last int) []int {
+		var seq []int
+		for i := first; i <= last; i++ {
+			seq = append(seq, i)
+		}
+		return seq
+	},
+	// split splits s around each separator, dropping the empty values, e.g. {{ range split (env "SHARDS") "," }}
+	"split": func(s string, separator string) []string {
+		var values []string
+		for _, value := range strings.Split(s, separator) {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+		return values
+	},
+}
+
+// readMigrationFile returns the content of the migration file at path, rendered with TemplateData when it is
+// a template. The checksum of a template is the one of its rendered content.
+func (c MigrationConfig) readMigrationFile(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	if !strings.HasSuffix(path, templateFileExtension) {
+		return data, nil
+	}
+	return renderMigrationTemplate(path, string(data), c.TemplateData)
+}
+
+// renderMigrationTemplate renders the migration template content, failing on a missing value of data
+func renderMigrationTemplate(path string, content string, data map[string]interface{}) ([]byte, error) {
+	tmpl, err := template.New(path).Option("missingkey=error").Funcs(templateFuncs).Parse(content)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template %s: %w", path, err)
+	}
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, data); err != nil {
+		return nil, fmt.Errorf("failed to render template %s: %w", path, err)
+	}
+	return rendered.Bytes(), nil
+}
@@ -0,0 +1,77 @@
+package gosmm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderMigrationTemplate(t *testing.T) {
+	os.Setenv("GOSMM_TEST_REGIONS", "eu, us,")
+	defer os.Unsetenv("GOSMM_TEST_REGIONS")
+
+	rendered, err := renderMigrationTemplate("v20230101_create_partitions_00001.sql.tmpl", `{{range .shards}}CREATE TABLE events_{{.}} (id INTEGER);
+{{end}}{{range split (env "GOSMM_TEST_REGIONS") ","}}CREATE TABLE users_{{.}} (id INTEGER);
+{{end}}{{range seq 1 2}}CREATE TABLE logs_{{printf "%02d" .}} (id INTEGER);
+{{end}}`, map[string]interface{}{"shards": []string{"a", "b"}})
+	assert.NoError(t, err)
+	assert.Equal(t, `CREATE TABLE events_a (id INTEGER);
+CREATE TABLE events_b (id INTEGER);
+CREATE TABLE users_eu (id INTEGER);
+CREATE TABLE users_us (id INTEGER);
+CREATE TABLE logs_01 (id INTEGER);
+CREATE TABLE logs_02 (id INTEGER);
+`, string(rendered))
+
+	_, err = renderMigrationTemplate("v20230101_create_partitions_00001.sql.tmpl", "{{range .shards}}{{end}}", nil)
+	assert.ErrorContains(t, err, "failed to render template")
+	_, err = renderMigrationTemplate("v20230101_create_partitions_00001.sql.tmpl", "{{range .shards}", nil)
+	assert.ErrorContains(t, err, "invalid template")
+}
+
+func TestMigrateWithTemplate(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	template := "-- gosmm:author Jane Doe\n{{range .shards}}CREATE TABLE events_{{.}} (id INTEGER);\n{{end}}"
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_events_00001.sql.tmpl"), []byte(template), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3", TemplateData: map[string]interface{}{"shards": []string{"eu", "us"}}}
+
+	assert.NoError(t, MigrateWithConfig(db, config))
+	for _, table := range []string{"events_eu", "events_us"} {
+		_, err := db.Exec("SELECT id FROM " + table)
+		assert.NoError(t, err, table)
+	}
+
+	// The migration is named after the file without .tmpl, and its checksum is the one of the rendered content
+	history, err := GetHistory(db, config)
+	assert.NoError(t, err)
+	if assert.Len(t, history, 1) {
+		assert.Equal(t, "v20230101_create_events_00001.sql", history[0].Filename)
+		assert.Equal(t, "Jane Doe", history[0].Author)
+		assert.Equal(t, calculateChecksum([]byte("-- gosmm:author Jane Doe\nCREATE TABLE events_eu (id INTEGER);\nCREATE TABLE events_us (id INTEGER);\n")), history[0].Checksum)
+	}
+	assert.NoError(t, Validate(db, config))
+
+	// Rendered with other values, the applied migration was modified
+	config.TemplateData = map[string]interface{}{"shards": []string{"eu", "us", "ap"}}
+	assert.ErrorIs(t, Validate(db, config), ErrChecksumMismatch)
+}
+
+func TestMigrationTemplateConflictsWithFile(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"v20230101_create_events_00001.sql", "v20230101_create_events_00001.sql.tmpl"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("CREATE TABLE events (id INTEGER);"), 0644); err != nil {
+			t.Fatalf("Failed to create test migration file: %v", err)
+		}
+	}
+
+	_, err := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}.migrationFiles()
+	assert.ErrorContains(t, err, "duplicate migration v20230101_create_events_00001.sql")
+}
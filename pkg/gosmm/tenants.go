@@ -0,0 +1,76 @@
+package gosmm
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// tenantSchemaPlaceholder is the placeholder set to the schema of each tenant by MigrateTenants
+const tenantSchemaPlaceholder = "schema"
+
+// TenantsConfig lists the tenant schemas migrated by the CLI, see MigrateTenants
+type TenantsConfig struct {
+	// Schemas are the schemas of the tenants
+	Schemas []string
+	// Query returns the schemas of the tenants, one per row, in addition to Schemas
+	Query string
+}
+
+// TenantSchemas returns the tenant schemas returned by query, one per row in its first column,
+// e.g. SELECT schema_name FROM tenants WHERE active
+func TenantSchemas(db *sql.DB, query string) ([]string, error) {
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tenant schemas: %w", err)
+	}
+	defer rows.Close()
+
+	var schemas []string
+	for rows.Next() {
+		var schema string
+		if err := rows.Scan(&schema); err != nil {
+			return nil, fmt.Errorf("failed to read tenant schema: %w", err)
+		}
+		schemas = append(schemas, schema)
+	}
+	return schemas, rows.Err()
+}
+
+// Resolve returns the schemas of the tenants, running Query on db when it is set
+func (c TenantsConfig) Resolve(db *sql.DB) ([]string, error) {
+	schemas := append([]string(nil), c.Schemas...)
+	if c.Query != "" {
+		queried, err := TenantSchemas(db, c.Query)
+		if err != nil {
+			return nil, err
+		}
+		schemas = append(schemas, queried...)
+	}
+	return schemas, nil
+}
+
+// MigrateTenants applies the migrations to the schema of each tenant, for schema-per-tenant databases.
+// Each schema has its own history table, and is used as the search_path on Postgres while its migrations
+// are executed. The ${schema} placeholder is set to the schema unless opts.Config defines it.
+// The tenants are migrated like the targets of MigrateAll, opts.Concurrency at a time.
+func MigrateTenants(db *sql.DB, schemas []string, opts MigrateAllOptions) error {
+	targets := make([]Target, len(schemas))
+	for i, schema := range schemas {
+		targets[i] = Target{Name: schema, DB: db, Schema: schema}
+	}
+	return MigrateAll(targets, opts)
+}
+
+// withTenantSchema returns a copy of the config migrating the given schema
+func (c MigrationConfig) withTenantSchema(schema string) MigrationConfig {
+	c.Schema = schema
+	placeholders := make(map[string]string, len(c.Placeholders)+1)
+	for name, value := range c.Placeholders {
+		placeholders[name] = value
+	}
+	if _, ok := placeholders[tenantSchemaPlaceholder]; !ok {
+		placeholders[tenantSchemaPlaceholder] = schema
+	}
+	c.Placeholders = placeholders
+	return c
+}
@@ -0,0 +1,72 @@
+package gosmm
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrateTenants(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+	// SQLite schemas are attached databases, which only exist on the connection attaching them
+	db.SetMaxOpenConns(1)
+	for _, schema := range []string{"tenant_a", "tenant_b"} {
+		if _, err := db.Exec("ATTACH DATABASE ':memory:' AS " + schema); err != nil {
+			t.Fatalf("Failed to attach tenant schema: %v", err)
+		}
+	}
+	if _, err := db.Exec("CREATE TABLE tenants (schema_name TEXT)"); err != nil {
+		t.Fatalf("Failed to create tenants table: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO tenants VALUES ('tenant_a'), ('tenant_b')"); err != nil {
+		t.Fatalf("Failed to insert tenants: %v", err)
+	}
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte(`CREATE TABLE "${schema}".users (id INTEGER);`), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+
+	schemas, err := TenantsConfig{Query: "SELECT schema_name FROM tenants ORDER BY schema_name"}.Resolve(db)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"tenant_a", "tenant_b"}, schemas)
+
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}
+	err = MigrateTenants(db, schemas, MigrateAllOptions{Config: config, Concurrency: 1})
+	assert.NoError(t, err)
+
+	// Each tenant has its own history table and users table
+	for _, schema := range schemas {
+		var count int
+		err = db.QueryRow("SELECT COUNT(*) FROM " + schema + ".gosmm_migration_history WHERE success = 1").Scan(&count)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, count, schema)
+		err = db.QueryRow("SELECT COUNT(*) FROM " + schema + ".users").Scan(&count)
+		assert.NoError(t, err, schema)
+	}
+	// The config of the caller is not modified
+	assert.Empty(t, config.Schema)
+	assert.Nil(t, config.Placeholders)
+
+	// An unknown tenant fails without stopping the others with ContinueOnError
+	err = MigrateTenants(db, []string{"tenant_missing", "tenant_a"}, MigrateAllOptions{Config: config, Concurrency: 1, ContinueOnError: true})
+	var failed *ErrMigrateAllFailed
+	if assert.ErrorAs(t, err, &failed) {
+		assert.Len(t, failed.Failed, 1)
+		assert.Equal(t, "tenant_missing", failed.Failed[0].Target)
+	}
+}
+
+func TestWithTenantSchema(t *testing.T) {
+	config := MigrationConfig{Placeholders: map[string]string{"schema": "shared", "region": "eu"}}
+	tenant := config.withTenantSchema("tenant_a")
+	assert.Equal(t, "tenant_a", tenant.Schema)
+	// A placeholder defined by the config is kept
+	assert.Equal(t, map[string]string{"schema": "shared", "region": "eu"}, tenant.Placeholders)
+
+	tenant = MigrationConfig{}.withTenantSchema("tenant_b")
+	assert.Equal(t, map[string]string{"schema": "tenant_b"}, tenant.Placeholders)
+}
@@ -0,0 +1,104 @@
+package gosmm
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+)
+
+// sqliteDefaultBusyTimeout is the busy timeout of the connections of go-sqlite3, restored after a migration
+const sqliteDefaultBusyTimeout = 5 * time.Second
+
+// timeoutStatements returns the statements setting the LockTimeout and StatementTimeout of config for the
+// driver, and those restoring the defaults of the connection. When local is set, the Postgres settings only
+// last until the end of the current transaction, and need no restore.
+func timeoutStatements(config MigrationConfig, local bool) (set []string, reset []string, err error) {
+	lock, statement := config.LockTimeout, config.StatementTimeout
+	if lock < 0 || statement < 0 {
+		return nil, nil, fmt.Errorf("negative lock or statement timeout")
+	}
+	switch config.Driver {
+	case "postgres":
+		command := "SET "
+		if local {
+			command = "SET LOCAL "
+		}
+		if lock > 0 {
+			set = append(set, fmt.Sprintf("%slock_timeout = %d", command, lock.Milliseconds()))
+			reset = append(reset, "RESET lock_timeout")
+		}
+		if statement > 0 {
+			set = append(set, fmt.Sprintf("%sstatement_timeout = %d", command, statement.Milliseconds()))
+			reset = append(reset, "RESET statement_timeout")
+		}
+		if local {
+			reset = nil
+		}
+	case "mysql":
+		if lock > 0 {
+			// in whole seconds: the metadata locks taken by DDL and the row locks of InnoDB
+			seconds := int64(math.Max(1, math.Ceil(lock.Seconds())))
+			set = append(set, fmt.Sprintf("SET SESSION lock_wait_timeout = %d", seconds), fmt.Sprintf("SET SESSION innodb_lock_wait_timeout = %d", seconds))
+			reset = append(reset, "SET SESSION lock_wait_timeout = DEFAULT", "SET SESSION innodb_lock_wait_timeout = DEFAULT")
+		}
+		if statement > 0 {
+			// only bounds SELECT statements
+			set = append(set, fmt.Sprintf("SET SESSION max_execution_time = %d", statement.Milliseconds()))
+			reset = append(reset, "SET SESSION max_execution_time = DEFAULT")
+		}
+	case "sqlserver":
+		if statement > 0 {
+			return nil, nil, fmt.Errorf("StatementTimeout is not supported for sqlserver, bound the migrations with a gosmm:timeout header instead")
+		}
+		if lock > 0 {
+			set = append(set, fmt.Sprintf("SET LOCK_TIMEOUT %d", lock.Milliseconds()))
+			reset = append(reset, "SET LOCK_TIMEOUT -1")
+		}
+	case "sqlite3":
+		if statement > 0 {
+			return nil, nil, fmt.Errorf("StatementTimeout is not supported for sqlite3, bound the migrations with a gosmm:timeout header instead")
+		}
+		if lock > 0 {
+			set = append(set, fmt.Sprintf("PRAGMA busy_timeout = %d", lock.Milliseconds()))
+			reset = append(reset, fmt.Sprintf("PRAGMA busy_timeout = %d", sqliteDefaultBusyTimeout.Milliseconds()))
+		}
+	}
+	return set, reset, nil
+}
+
+// setSessionTimeouts sets the timeouts of config on conn, and returns the function restoring them before the
+// connection returns to the pool
+func setSessionTimeouts(ctx context.Context, conn *sql.Conn, config MigrationConfig) (func(), error) {
+	set, reset, err := timeoutStatements(config, false)
+	if err != nil {
+		return nil, err
+	}
+	restore := func() {
+		for _, statement := range reset {
+			conn.ExecContext(context.Background(), statement)
+		}
+	}
+	for _, statement := range set {
+		if _, err := conn.ExecContext(ctx, statement); err != nil {
+			restore()
+			return nil, fmt.Errorf("failed to set timeouts: %w", err)
+		}
+	}
+	return restore, nil
+}
+
+// setLocalTimeouts sets the timeouts of config for the transaction tx, for Postgres
+func setLocalTimeouts(ctx context.Context, tx *sql.Tx, config MigrationConfig) error {
+	set, _, err := timeoutStatements(config, true)
+	if err != nil {
+		return err
+	}
+	for _, statement := range set {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("failed to set timeouts: %w", err)
+		}
+	}
+	return nil
+}
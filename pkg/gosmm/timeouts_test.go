@@ -0,0 +1,95 @@
+package gosmm
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeoutStatements(t *testing.T) {
+	config := MigrationConfig{Driver: "postgres", LockTimeout: 5 * time.Second, StatementTimeout: time.Minute}
+	set, reset, err := timeoutStatements(config, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"SET lock_timeout = 5000", "SET statement_timeout = 60000"}, set)
+	assert.Equal(t, []string{"RESET lock_timeout", "RESET statement_timeout"}, reset)
+	set, reset, err = timeoutStatements(config, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"SET LOCAL lock_timeout = 5000", "SET LOCAL statement_timeout = 60000"}, set)
+	assert.Empty(t, reset)
+
+	config.Driver, config.LockTimeout = "mysql", 1500*time.Millisecond
+	set, _, err = timeoutStatements(config, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"SET SESSION lock_wait_timeout = 2", "SET SESSION innodb_lock_wait_timeout = 2", "SET SESSION max_execution_time = 60000"}, set)
+
+	config.Driver = "sqlserver"
+	_, _, err = timeoutStatements(config, false)
+	assert.Error(t, err)
+	config.StatementTimeout = 0
+	set, reset, err = timeoutStatements(config, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"SET LOCK_TIMEOUT 1500"}, set)
+	assert.Equal(t, []string{"SET LOCK_TIMEOUT -1"}, reset)
+
+	set, reset, err = timeoutStatements(MigrationConfig{Driver: "postgres"}, false)
+	assert.NoError(t, err)
+	assert.Empty(t, set)
+	assert.Empty(t, reset)
+}
+
+func TestMigrateLockTimeout(t *testing.T) {
+	// a file database, locked by another connection
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3", LockTimeout: 100 * time.Millisecond}
+	assert.NoError(t, MigrateWithConfig(db, config))
+
+	other, err := sql.Open("sqlite3", "file:"+path+"?_txlock=immediate")
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer other.Close()
+	tx, err := other.Begin()
+	if err != nil {
+		t.Fatalf("Failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("INSERT INTO users (id) VALUES (1)"); err != nil {
+		t.Fatalf("Failed to lock database: %v", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230102_add_email_00002.sql"), []byte("ALTER TABLE users ADD COLUMN email TEXT;"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	start := time.Now()
+	assert.ErrorContains(t, MigrateWithConfig(db, config), "database is locked")
+	assert.Less(t, time.Since(start), 4*time.Second)
+}
+
+func TestMigrateStatementTimeoutUnsupported(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	err := MigrateWithConfig(db, MigrationConfig{MigrationsDir: dir, Driver: "sqlite3", StatementTimeout: time.Second})
+	assert.EqualError(t, err, "StatementTimeout is not supported for sqlite3, bound the migrations with a gosmm:timeout header instead")
+	report, err := Status(db, MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Pending)
+}
@@ -0,0 +1,161 @@
+package gosmm
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// SSL modes of DBConfig.SSLMode, named after the Postgres sslmode values
+const (
+	// SSLModeDisable connects without TLS
+	SSLModeDisable = "disable"
+	// SSLModeRequire encrypts the connection without verifying the server certificate
+	SSLModeRequire = "require"
+	// SSLModeVerifyCA verifies that the server certificate is signed by a trusted CA
+	SSLModeVerifyCA = "verify-ca"
+	// SSLModeVerifyFull verifies the server certificate and that it matches the server name
+	SSLModeVerifyFull = "verify-full"
+)
+
+// tlsParams returns the driver parameters applying the TLS settings of config
+func tlsParams(config DBConfig) (map[string]string, error) {
+	switch config.SSLMode {
+	case "", SSLModeDisable, SSLModeRequire, SSLModeVerifyCA, SSLModeVerifyFull:
+	default:
+		return nil, fmt.Errorf("unsupported ssl mode: %s", config.SSLMode)
+	}
+	if (config.SSLCert == "") != (config.SSLKey == "") {
+		return nil, errors.New("ssl cert and ssl key must be set together")
+	}
+
+	switch config.Driver {
+	case "postgres":
+		if config.SSLServerName != "" {
+			return nil, errors.New("ssl server name is not supported by the postgres driver, the host is verified")
+		}
+		params := map[string]string{"sslmode": SSLModeDisable}
+		if config.SSLMode != "" {
+			params["sslmode"] = config.SSLMode
+		}
+		setIfNotEmpty(params, "sslrootcert", config.SSLRootCert)
+		setIfNotEmpty(params, "sslcert", config.SSLCert)
+		setIfNotEmpty(params, "sslkey", config.SSLKey)
+		return params, nil
+	case "mysql":
+		if config.SSLMode == "" || config.SSLMode == SSLModeDisable {
+			return nil, nil
+		}
+		name, err := registerMySQLTLSConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]string{"tls": name}, nil
+	case "sqlserver":
+		if config.SSLCert != "" {
+			return nil, errors.New("client certificates are not supported by the sqlserver driver")
+		}
+		params := make(map[string]string)
+		switch config.SSLMode {
+		case "":
+			return params, nil // the driver's default
+		case SSLModeDisable:
+			params["encrypt"] = "disable"
+		case SSLModeRequire:
+			params["encrypt"] = "true"
+			params["trustservercertificate"] = "true"
+		case SSLModeVerifyCA, SSLModeVerifyFull:
+			params["encrypt"] = "true"
+			params["trustservercertificate"] = "false"
+			setIfNotEmpty(params, "certificate", config.SSLRootCert)
+			setIfNotEmpty(params, "hostnameincertificate", config.SSLServerName)
+		}
+		return params, nil
+	default:
+		if config.SSLMode != "" && config.SSLMode != SSLModeDisable {
+			return nil, fmt.Errorf("ssl is not supported by the %s driver", config.Driver)
+		}
+		return nil, nil
+	}
+}
+
+// setIfNotEmpty sets params[key] to value unless value is empty
+func setIfNotEmpty(params map[string]string, key string, value string) {
+	if value != "" {
+		params[key] = value
+	}
+}
+
+// registerMySQLTLSConfig registers the tls.Config of the TLS settings with the mysql driver
+// and returns the name to use as the tls parameter
+func registerMySQLTLSConfig(config DBConfig) (string, error) {
+	tlsConfig := &tls.Config{ServerName: config.Host}
+	if config.SSLServerName != "" {
+		tlsConfig.ServerName = config.SSLServerName
+	}
+
+	if config.SSLRootCert != "" {
+		pem, err := ioutil.ReadFile(config.SSLRootCert)
+		if err != nil {
+			return "", fmt.Errorf("failed to read ssl root cert: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return "", fmt.Errorf("no certificate found in %s", config.SSLRootCert)
+		}
+	}
+	if config.SSLCert != "" {
+		certificate, err := tls.LoadX509KeyPair(config.SSLCert, config.SSLKey)
+		if err != nil {
+			return "", fmt.Errorf("failed to load ssl client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	switch config.SSLMode {
+	case SSLModeRequire:
+		tlsConfig.InsecureSkipVerify = true
+	case SSLModeVerifyCA:
+		// verify the chain but not the server name, which crypto/tls only allows through a custom verification
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyPeerCertificate = verifyCertificateChain(tlsConfig.RootCAs)
+	}
+
+	// the name identifies the settings, so registering the same settings again is harmless
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%s|%s|%s|%s", config.SSLMode, config.SSLRootCert, config.SSLCert, config.SSLKey, tlsConfig.ServerName, config.Host)))
+	name := "gosmm-" + hex.EncodeToString(hash[:8])
+	if err := mysql.RegisterTLSConfig(name, tlsConfig); err != nil {
+		return "", fmt.Errorf("failed to register tls config: %w", err)
+	}
+	return name, nil
+}
+
+// verifyCertificateChain returns a tls.Config.VerifyPeerCertificate function verifying the server
+// certificate chain against roots (the system roots when nil) without checking the server name
+func verifyCertificateChain(roots *x509.CertPool) func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("no server certificate")
+		}
+		certificates := make([]*x509.Certificate, len(rawCerts))
+		for i, raw := range rawCerts {
+			certificate, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return fmt.Errorf("failed to parse server certificate: %w", err)
+			}
+			certificates[i] = certificate
+		}
+		intermediates := x509.NewCertPool()
+		for _, certificate := range certificates[1:] {
+			intermediates.AddCert(certificate)
+		}
+		_, err := certificates[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates})
+		return err
+	}
+}
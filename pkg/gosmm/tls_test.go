@@ -0,0 +1,131 @@
+package gosmm
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// generateCertificate creates a certificate for commonName signed by parent (self-signed when nil)
+func generateCertificate(t *testing.T, commonName string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		DNSNames:              []string{commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+	return certificate, key
+}
+
+func TestTLSParamsPostgres(t *testing.T) {
+	dsn, err := buildDSN(DBConfig{
+		Driver:      "postgres",
+		Host:        "db.example.com",
+		Port:        5432,
+		User:        "root",
+		Password:    "password",
+		DBName:      "test_db",
+		SSLMode:     SSLModeVerifyFull,
+		SSLRootCert: "/etc/ssl/ca.pem",
+		SSLCert:     "/etc/ssl/client.pem",
+		SSLKey:      "/etc/ssl/client.key",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "application_name=gosmm dbname=test_db host=db.example.com password=password port=5432 sslcert=/etc/ssl/client.pem sslkey=/etc/ssl/client.key sslmode=verify-full sslrootcert=/etc/ssl/ca.pem user=root", dsn)
+
+	_, err = tlsParams(DBConfig{Driver: "postgres", SSLMode: SSLModeVerifyFull, SSLServerName: "db.internal"})
+	assert.Error(t, err)
+}
+
+func TestTLSParamsSQLServer(t *testing.T) {
+	params, err := tlsParams(DBConfig{Driver: "sqlserver", SSLMode: SSLModeVerifyFull, SSLRootCert: "/etc/ssl/ca.pem", SSLServerName: "db.internal"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"encrypt":                "true",
+		"trustservercertificate": "false",
+		"certificate":            "/etc/ssl/ca.pem",
+		"hostnameincertificate":  "db.internal",
+	}, params)
+
+	params, err = tlsParams(DBConfig{Driver: "sqlserver", SSLMode: SSLModeRequire})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"encrypt": "true", "trustservercertificate": "true"}, params)
+
+	_, err = tlsParams(DBConfig{Driver: "sqlserver", SSLMode: SSLModeRequire, SSLCert: "client.pem", SSLKey: "client.key"})
+	assert.Error(t, err)
+}
+
+func TestTLSParamsMySQL(t *testing.T) {
+	ca, _ := generateCertificate(t, "gosmm test CA", nil, nil)
+	rootCert := filepath.Join(t.TempDir(), "ca.pem")
+	if err := ioutil.WriteFile(rootCert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}), 0644); err != nil {
+		t.Fatalf("Failed to write CA certificate: %v", err)
+	}
+
+	dsn, err := buildDSN(DBConfig{Driver: "mysql", Host: "db.example.com", Port: 3306, User: "root", Password: "password", DBName: "test_db", SSLMode: SSLModeVerifyFull, SSLRootCert: rootCert})
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(dsn, "root:password@tcp(db.example.com:3306)/test_db?tls=gosmm-"), dsn)
+
+	dsn, err = buildDSN(DBConfig{Driver: "mysql", Host: "db.example.com", Port: 3306, User: "root", Password: "password", DBName: "test_db", SSLMode: SSLModeDisable})
+	assert.NoError(t, err)
+	assert.Equal(t, "root:password@tcp(db.example.com:3306)/test_db", dsn)
+
+	_, err = tlsParams(DBConfig{Driver: "mysql", SSLMode: SSLModeVerifyCA, SSLRootCert: filepath.Join(t.TempDir(), "missing.pem")})
+	assert.Error(t, err)
+}
+
+func TestTLSParamsErrors(t *testing.T) {
+	_, err := tlsParams(DBConfig{Driver: "postgres", SSLMode: "prefer"})
+	assert.Error(t, err)
+	_, err = tlsParams(DBConfig{Driver: "postgres", SSLMode: SSLModeRequire, SSLCert: "client.pem"})
+	assert.Error(t, err)
+	_, err = tlsParams(DBConfig{Driver: "sqlite3", SSLMode: SSLModeRequire})
+	assert.Error(t, err)
+}
+
+func TestVerifyCertificateChain(t *testing.T) {
+	ca, caKey := generateCertificate(t, "gosmm test CA", nil, nil)
+	server, _ := generateCertificate(t, "db.internal", ca, caKey)
+	otherCA, _ := generateCertificate(t, "other CA", nil, nil)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	// The server name is not checked
+	assert.NoError(t, verifyCertificateChain(roots)([][]byte{server.Raw}, nil))
+
+	otherRoots := x509.NewCertPool()
+	otherRoots.AddCert(otherCA)
+	assert.Error(t, verifyCertificateChain(otherRoots)([][]byte{server.Raw}, nil))
+	assert.Error(t, verifyCertificateChain(roots)(nil, nil))
+}
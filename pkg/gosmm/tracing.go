@@ -0,0 +1,69 @@
+package gosmm
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/k1e1n04/gosmm/v2/pkg/gosmm"
+
+// tracer returns the tracer of the configured TracerProvider, or of the global one
+func (c MigrationConfig) tracer() trace.Tracer {
+	if c.TracerProvider == nil {
+		return otel.GetTracerProvider().Tracer(tracerName)
+	}
+	return c.TracerProvider.Tracer(tracerName)
+}
+
+// startRunSpan starts the span of a migration run
+func startRunSpan(ctx context.Context, config MigrationConfig) (context.Context, trace.Span) {
+	return config.tracer().Start(ctx, "gosmm.migrate", trace.WithAttributes(
+		attribute.String("db.system", config.Driver),
+	))
+}
+
+// endSpan ends span, marking it as failed when err is not nil
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// migrationSpan is the span of a single migration, counting its executed statements and affected rows
+type migrationSpan struct {
+	span         trace.Span
+	statements   int
+	rowsAffected int64
+}
+
+// startMigrationSpan starts the span of a migration as a child of the run span in ctx
+func startMigrationSpan(ctx context.Context, config MigrationConfig, migration MigrationInfo) (context.Context, *migrationSpan) {
+	ctx, span := config.tracer().Start(ctx, "gosmm.migration", trace.WithAttributes(
+		attribute.String("gosmm.migration.filename", migration.Filename),
+		attribute.Int("gosmm.migration.installed_rank", migration.InstalledRank),
+	))
+	return ctx, &migrationSpan{span: span}
+}
+
+// observe counts the statements executed by the migration
+func (s *migrationSpan) observe(event Event) {
+	if event.Kind == EventStatementExecuted {
+		s.statements++
+		s.rowsAffected += event.RowsAffected
+	}
+}
+
+// end records the statement and row counts and ends the span
+func (s *migrationSpan) end(err error) {
+	s.span.SetAttributes(
+		attribute.Int("gosmm.migration.statements", s.statements),
+		attribute.Int64("gosmm.migration.rows_affected", s.rowsAffected),
+	)
+	endSpan(s.span, err)
+}
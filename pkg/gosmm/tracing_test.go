@@ -0,0 +1,67 @@
+package gosmm
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// spanAttributes returns the attributes of a recorded span keyed by name
+func spanAttributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attributes := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes() {
+		attributes[kv.Key] = kv.Value
+	}
+	return attributes
+}
+
+func TestMigrateWithContextTracing(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER); INSERT INTO users VALUES (1); INSERT INTO users VALUES (2);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230102_invalid_00002.sql"), []byte("CREATE TABLE;"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+
+	// The run span is a child of the caller's span
+	ctx, parent := provider.Tracer("deploy").Start(context.Background(), "deploy")
+	err := MigrateWithContext(ctx, db, MigrationConfig{MigrationsDir: dir, Driver: "sqlite3", TracerProvider: provider})
+	parent.End()
+	assert.Error(t, err)
+
+	spans := recorder.Ended()
+	if !assert.Len(t, spans, 4) {
+		return
+	}
+	first, second, run := spans[0], spans[1], spans[2]
+
+	assert.Equal(t, "gosmm.migrate", run.Name())
+	assert.Equal(t, parent.SpanContext().SpanID(), run.Parent().SpanID())
+	assert.Equal(t, codes.Error, run.Status().Code)
+	assert.Equal(t, int64(2), spanAttributes(run)["gosmm.migrations.pending"].AsInt64())
+
+	assert.Equal(t, "gosmm.migration", first.Name())
+	assert.Equal(t, run.SpanContext().SpanID(), first.Parent().SpanID())
+	attributes := spanAttributes(first)
+	assert.Equal(t, "v20230101_create_users_00001.sql", attributes["gosmm.migration.filename"].AsString())
+	assert.Equal(t, int64(3), attributes["gosmm.migration.statements"].AsInt64())
+	assert.Equal(t, int64(2), attributes["gosmm.migration.rows_affected"].AsInt64())
+	assert.Equal(t, codes.Unset, first.Status().Code)
+
+	assert.Equal(t, "v20230102_invalid_00002.sql", spanAttributes(second)["gosmm.migration.filename"].AsString())
+	assert.Equal(t, codes.Error, second.Status().Code)
+}
@@ -0,0 +1,91 @@
+package gosmm
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+func init() {
+	// trino-go-client registers its driver as "trino", and go-athena as "athena". Athena runs on Trino.
+	RegisterDialect("trino", trinoDialect{})
+	RegisterDialect("athena", trinoDialect{})
+}
+
+// trinoDialect supports Trino and Amazon Athena, best effort. Their catalogs have no real transactions, locks
+// or deletes, so the migrations are applied statement by statement and the history table and the migration
+// lock are kept in MigrationConfig.HistoryStore, e.g. a Postgres database.
+type trinoDialect struct{}
+
+// HistoryTableDDL implements Dialect, for catalogs able to host the history table such as Iceberg
+func (trinoDialect) HistoryTableDDL(table string) string {
+	return `CREATE TABLE IF NOT EXISTS ` + table + ` (
+		installed_rank INTEGER NOT NULL,
+		filename VARCHAR(255) NOT NULL,
+		installed_on TIMESTAMP(3) WITH TIME ZONE NOT NULL,
+		execution_time BIGINT NOT NULL,
+		success BOOLEAN NOT NULL,
+		checksum VARCHAR(64),
+		failed_statement INTEGER,
+		committed_statements INTEGER,
+		author VARCHAR(255),
+		ticket VARCHAR(255),
+		description VARCHAR(1000),
+		backup VARCHAR(1000),
+		applied_by VARCHAR(255),
+		context VARCHAR(1000),
+		rows_affected BIGINT,
+		approval VARCHAR(255),
+		ahead INTEGER
+	)`
+}
+
+// HistoryVersionTableDDL implements Dialect
+func (trinoDialect) HistoryVersionTableDDL(table string) string {
+	return `CREATE TABLE IF NOT EXISTS ` + table + ` (
+		version INTEGER NOT NULL,
+		upgraded_on TIMESTAMP(3) WITH TIME ZONE NOT NULL
+	)`
+}
+
+// LockTableDDL implements Dialect
+func (trinoDialect) LockTableDDL(table string) string {
+	return `CREATE TABLE IF NOT EXISTS ` + table + ` (
+		lock_name VARCHAR(255) NOT NULL,
+		owner VARCHAR(255) NOT NULL,
+		expires_at BIGINT NOT NULL
+	)`
+}
+
+// Lock implements Dialect with a no-op lock, the runs being serialized by the lock of the HistoryStore
+func (trinoDialect) Lock(context.Context, *sql.DB, string, time.Duration) (func() error, error) {
+	return func() error { return nil }, nil
+}
+
+// QuoteIdentifier implements Dialect
+func (trinoDialect) QuoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// BindParam implements Dialect
+func (trinoDialect) BindParam(int) string {
+	return "?"
+}
+
+// TransactionalDDL implements Dialect
+func (trinoDialect) TransactionalDDL() bool {
+	return false
+}
+
+// SplitStatements implements Dialect. Semicolons inside quoted strings, identifiers and comments do not end
+// a statement.
+func (trinoDialect) SplitStatements(sql string) []string {
+	s := &statementSplitter{driver: "trino", input: sql, delimiter: defaultDelimiter}
+	s.split()
+	return s.statements
+}
+
+func (trinoDialect) nonTransactional() {}
+
+func (trinoDialect) externalHistory() {}
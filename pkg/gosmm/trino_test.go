@@ -0,0 +1,70 @@
+package gosmm
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+)
+
+// trinoTestDriver is SQLite registered with the Trino dialect, standing in for trino-go-client
+const trinoTestDriver = "gosmm-test-trino"
+
+func init() {
+	sql.Register(trinoTestDriver, &sqlite3.SQLiteDriver{})
+	RegisterDialect(trinoTestDriver, trinoDialect{})
+}
+
+func TestMigrateWithHistoryStore(t *testing.T) {
+	dir := t.TempDir()
+	err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER);\nINSERT INTO users (id) VALUES (1);"), 0644)
+	assert.NoError(t, err)
+	db, err := ConnectDB(DBConfig{Driver: trinoTestDriver, DSN: filepath.Join(t.TempDir(), "test.db")})
+	assert.NoError(t, err)
+	defer db.Close()
+	historyDB, teardown := setupTestDB(t)
+	defer teardown()
+	historyDB.SetMaxOpenConns(1)
+
+	config := MigrationConfig{MigrationsDir: dir, Driver: trinoTestDriver}
+	assert.EqualError(t, MigrateWithConfig(db, config), "the gosmm-test-trino driver requires a HistoryStore")
+
+	config.HistoryStore = SQLHistoryStore{DB: historyDB, Driver: "sqlite3"}
+	assert.NoError(t, MigrateWithConfig(db, config))
+	var count int
+	assert.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&count))
+	assert.Equal(t, 1, count)
+	exists, err := historyTableExists(db, "sqlite3", "")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	report, err := Status(db, config)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, report.Pending)
+	assert.Equal(t, MigrationApplied, report.Migrations[0].State)
+	assert.NoError(t, MigrateWithConfig(db, config))
+
+	// a failed statement is recorded in the history store, the statements before it staying applied
+	err = ioutil.WriteFile(filepath.Join(dir, "v20230102_add_email_00002.sql"), []byte("INSERT INTO users (id) VALUES (2);\nINSERT INTO missing (id) VALUES (1);"), 0644)
+	assert.NoError(t, err)
+	assert.Error(t, MigrateWithConfig(db, config))
+	history, err := GetHistory(db, config)
+	assert.NoError(t, err)
+	assert.Len(t, history, 2)
+	assert.False(t, history[1].Success)
+	assert.Equal(t, 2, history[1].FailedStatement)
+	assert.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&count))
+	assert.Equal(t, 2, count)
+}
+
+func TestTrinoDialect(t *testing.T) {
+	assert.Equal(t, `"my""schema".gosmm_migration_history`, historyTableName("trino", `my"schema`))
+	assert.Equal(t, "?, ?", bindParams("athena", 2))
+	assert.False(t, transactionalMigrations("trino"))
+	assert.True(t, externalHistory("athena"))
+	assert.False(t, externalHistory("postgres"))
+	assert.Equal(t, []string{"SELECT ';'", "SELECT 1 -- ;"}, splitStatements("SELECT ';';\nSELECT 1 -- ;\n", "trino"))
+}
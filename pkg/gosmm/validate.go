@@ -0,0 +1,393 @@
+package gosmm
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// migrationFilenamePattern matches the vYYYYMMDD_description_NNNNN.sql migration filename convention,
+// with an optional environment suffix such as vYYYYMMDD_description_NNNNN.dev.sql
+var migrationFilenamePattern = regexp.MustCompile(`^v(\d{8})_(.+)_(\d{5})(?:\.[A-Za-z][A-Za-z0-9_-]*)?\.sql$`)
+
+// ValidationIssueKind identifies the kind of problem found by Validate
+type ValidationIssueKind string
+
+const (
+	// IssueInvalidFilename is reported for files not following the vYYYYMMDD_description_NNNNN.sql convention,
+	// or not matching MigrationConfig.FilenamePattern
+	IssueInvalidFilename ValidationIssueKind = "invalid_filename"
+	// IssueOrderingGap is reported when sequence numbers are not consecutive, if MigrationConfig.StrictOrdering is set
+	IssueOrderingGap ValidationIssueKind = "ordering_gap"
+	// IssueDuplicateSequence is reported when files share a sequence number, e.g. added by two branches
+	IssueDuplicateSequence ValidationIssueKind = "duplicate_sequence"
+	// IssueOutOfOrder is reported when a migration was applied after a migration of a later version,
+	// unless MigrationConfig.AllowOutOfOrder is set
+	IssueOutOfOrder ValidationIssueKind = "out_of_order"
+	// IssueChecksumMismatch is reported when an applied file was modified after it was applied
+	IssueChecksumMismatch ValidationIssueKind = "checksum_mismatch"
+	// IssueMissingFile is reported when a file recorded in the history table no longer exists
+	IssueMissingFile ValidationIssueKind = "missing_file"
+	// IssuePendingMigration is reported by Check for a migration that was not applied
+	IssuePendingMigration ValidationIssueKind = "pending_migration"
+	// IssueFailedMigration is reported by Check for a failed migration leaving the database dirty
+	IssueFailedMigration ValidationIssueKind = "failed_migration"
+)
+
+// ValidationIssue describes a single problem found by Validate
+type ValidationIssue struct {
+	Kind     ValidationIssueKind `json:"kind"`
+	Filename string              `json:"filename"`
+	Message  string              `json:"message"`
+}
+
+// ValidationError is returned by Validate when at least one issue is found
+type ValidationError struct {
+	Issues []ValidationIssue
+}
+
+// Error returns all issues, one per line
+func (e *ValidationError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "validation failed with %d issue(s):", len(e.Issues))
+	for _, issue := range e.Issues {
+		fmt.Fprintf(&b, "\n  [%s] %s: %s", issue.Kind, issue.Filename, issue.Message)
+	}
+	return b.String()
+}
+
+// Is reports whether the issues include one matching target, so that callers can check
+// errors.Is(err, ErrChecksumMismatch), errors.Is(err, ErrMissingFile), errors.Is(err, ErrPendingMigrations)
+// or errors.Is(err, ErrDirtyState)
+func (e *ValidationError) Is(target error) bool {
+	var kind ValidationIssueKind
+	switch target {
+	case ErrChecksumMismatch:
+		kind = IssueChecksumMismatch
+	case ErrMissingFile:
+		kind = IssueMissingFile
+	case ErrPendingMigrations:
+		kind = IssuePendingMigration
+	case ErrDirtyState:
+		kind = IssueFailedMigration
+	default:
+		return false
+	}
+	for _, issue := range e.Issues {
+		if issue.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// appliedMigration holds a successfully applied migration recorded in the history table
+type appliedMigration struct {
+	installedRank int
+	filename      string
+	checksum      sql.NullString
+	// ahead is set for a migration applied ahead of pending migrations sorting before it, see Apply
+	ahead bool
+}
+
+// Validate checks the migration files against the history table without modifying the database.
+// It returns a *ValidationError listing every issue found, or nil when the migrations are valid.
+func Validate(db *sql.DB, config MigrationConfig) error {
+	naming, err := config.naming()
+	if err != nil {
+		return err
+	}
+	files, err := readMigrationFiles(config.migrationDirs(), config.listing())
+	if err != nil {
+		return err
+	}
+	files = shadowEnvironmentFiles(files, config.Environment)
+
+	exists, err := historyTableExists(db, config.Driver, config.Schema)
+	if err != nil {
+		return fmt.Errorf("failed to check history table: %w", err)
+	}
+	applied := make(map[string]appliedMigration)
+	if exists {
+		applied, err = getAppliedMigrations(db, config.Driver, historyTableName(config.Driver, config.Schema))
+		if err != nil {
+			return fmt.Errorf("failed to load migration history: %w", err)
+		}
+	}
+
+	var issues []ValidationIssue
+	var filenames []string
+	// the duplicate sequence numbers are those of the files running together in config.Environment
+	var environmentFilenames []string
+	var valid []migrationFile
+	paths := make(map[string]string, len(files))
+	labels := make(map[string][]string, len(files))
+	for _, file := range files {
+		if file.isDir {
+			continue
+		}
+		filename := file.name
+		paths[filename] = file.path
+		labels[filename] = file.metadata.Labels
+		if _, ok := naming.version(filename); !ok {
+			issues = append(issues, ValidationIssue{
+				Kind:     IssueInvalidFilename,
+				Filename: filename,
+				Message:  "filename does not match " + naming.describe(),
+			})
+			continue
+		}
+		filenames = append(filenames, filename)
+		if file.inEnvironment(config.Environment) {
+			environmentFilenames = append(environmentFilenames, filename)
+		}
+		valid = append(valid, file)
+	}
+	sort.SliceStable(filenames, func(i, j int) bool {
+		return naming.less(filenames[i], filenames[j])
+	})
+	sort.SliceStable(environmentFilenames, func(i, j int) bool {
+		return naming.less(environmentFilenames[i], environmentFilenames[j])
+	})
+	ordered, err := orderedFilenames(valid, config.GoMigrations, naming)
+	if err != nil {
+		return err
+	}
+
+	issues = append(issues, findDuplicateSequences(environmentFilenames, naming, applied)...)
+	// the versions of a custom pattern have no sequence number
+	if naming.pattern == nil {
+		gaps := findOrderingGaps(filenames)
+		if config.StrictOrdering {
+			issues = append(issues, gaps...)
+		} else {
+			for _, gap := range gaps {
+				config.LogLevel.printf(LogWarn, "WARNING: %s: %s\n", gap.Filename, gap.Message)
+			}
+		}
+	}
+	if !config.AllowOutOfOrder {
+		issues = append(issues, findOutOfOrderMigrations(ordered, applied, labels)...)
+	}
+
+	for _, filename := range filenames {
+		migration, ok := applied[filename]
+		if !ok {
+			continue
+		}
+		delete(applied, filename)
+		if !migration.checksum.Valid || migration.checksum.String == "" {
+			continue // applied before checksums were recorded
+		}
+		data, err := config.readMigrationFile(paths[filename])
+		if err != nil {
+			return err
+		}
+		checksum, err := config.checksum(data)
+		if err != nil {
+			return err
+		}
+		if checksum != migration.checksum.String {
+			issues = append(issues, ValidationIssue{
+				Kind:     IssueChecksumMismatch,
+				Filename: filename,
+				Message:  fmt.Sprintf("checksum %s does not match applied checksum %s", checksum, migration.checksum.String),
+			})
+		}
+	}
+
+	for name := range config.GoMigrations {
+		delete(applied, name)
+	}
+
+	missing := make([]string, 0, len(applied))
+	for filename := range applied {
+		missing = append(missing, filename)
+	}
+	sort.Strings(missing)
+	for _, filename := range missing {
+		issues = append(issues, ValidationIssue{
+			Kind:     IssueMissingFile,
+			Filename: filename,
+			Message:  "file recorded in the history table was not found in the migrations directory",
+		})
+	}
+
+	if len(issues) > 0 {
+		return &ValidationError{Issues: issues}
+	}
+	return nil
+}
+
+// orderedFilenames returns the names of the files in the order they are applied, see orderMigrations
+func orderedFilenames(files []migrationFile, goMigrations map[string]GoMigrationFunc, naming migrationNaming) ([]string, error) {
+	// the migration files may require Go migrations, which are left out of the result
+	names, err := migrationNames(files, goMigrations, naming)
+	if err != nil {
+		return nil, err
+	}
+	ordered := make([]string, 0, len(files))
+	for _, name := range names {
+		if _, ok := goMigrations[name]; !ok {
+			ordered = append(ordered, name)
+		}
+	}
+	return ordered, nil
+}
+
+// findOrderingGaps reports files whose sequence number does not follow the previous file's sequence number.
+// Files sharing a sequence number are reported by findDuplicateSequences instead.
+func findOrderingGaps(sortedFilenames []string) []ValidationIssue {
+	var issues []ValidationIssue
+	previous := -1
+	for _, filename := range sortedFilenames {
+		sequence, err := strconv.Atoi(migrationFilenamePattern.FindStringSubmatch(filename)[3])
+		if err != nil {
+			continue
+		}
+		if previous >= 0 && sequence != previous+1 && sequence != previous {
+			issues = append(issues, ValidationIssue{
+				Kind:     IssueOrderingGap,
+				Filename: filename,
+				Message:  fmt.Sprintf("sequence number %05d does not follow %05d", sequence, previous),
+			})
+		}
+		previous = sequence
+	}
+	return issues
+}
+
+// findDuplicateSequences reports files sharing the sequence number of a previous file, such as
+// v20230101_add_email_00042.sql and v20230102_add_name_00042.sql added by two branches, whose order is
+// not the one their authors intended, with the renumbering of FixDuplicateSequences. For a custom filename
+// pattern, files sharing a version are reported.
+func findDuplicateSequences(sortedFilenames []string, naming migrationNaming, applied map[string]appliedMigration) []ValidationIssue {
+	var issues []ValidationIssue
+	var renames map[string]string
+	if naming.pattern == nil {
+		renames = renumberDuplicates(sortedFilenames, applied)
+	}
+	first := make(map[string]string)
+	for _, filename := range sortedFilenames {
+		sequence, _ := naming.version(filename)
+		if naming.pattern == nil {
+			sequence = migrationFilenamePattern.FindStringSubmatch(filename)[3]
+		}
+		if other, ok := first[sequence]; ok {
+			message := fmt.Sprintf("sequence number %s is also used by %s, renumber one of them", sequence, other)
+			if name, ok := renames[filename]; ok {
+				message = fmt.Sprintf("sequence number %s is also used by %s, renumber it to %s", sequence, other, name)
+			} else if name, ok := renames[other]; ok {
+				message = fmt.Sprintf("sequence number %s is also used by %s, which is not applied, renumber it to %s", sequence, other, name)
+			} else if naming.pattern == nil {
+				message = fmt.Sprintf("sequence number %s is also used by %s, both are applied", sequence, other)
+			}
+			issues = append(issues, ValidationIssue{
+				Kind:     IssueDuplicateSequence,
+				Filename: filename,
+				Message:  message,
+			})
+			continue
+		}
+		first[sequence] = filename
+	}
+	return issues
+}
+
+// findOutOfOrderMigrations reports the applied migrations whose installed_rank is lower than the rank of a
+// migration sorting before them, meaning they were applied before it although their version is later. The
+// migrations of other labels, left pending by a run restricted to its labels, are not compared, see labelsOrder,
+// and the migrations applied ahead on purpose are not reported, see Apply.
+func findOutOfOrderMigrations(sortedFilenames []string, applied map[string]appliedMigration, labels map[string][]string) []ValidationIssue {
+	var issues []ValidationIssue
+	var previous []appliedMigration
+	for _, filename := range sortedFilenames {
+		migration, ok := applied[filename]
+		if !ok {
+			continue
+		}
+		// a migration applied ahead of those sorting before it is not out of order
+		if migration.ahead {
+			previous = append(previous, migration)
+			continue
+		}
+		var latest appliedMigration
+		for _, before := range previous {
+			if before.installedRank > migration.installedRank && before.installedRank > latest.installedRank && labelsOrder(labels[filename], labels[before.filename]) {
+				latest = before
+			}
+		}
+		if latest.filename != "" {
+			issues = append(issues, ValidationIssue{
+				Kind:     IssueOutOfOrder,
+				Filename: filename,
+				Message:  fmt.Sprintf("applied with installed_rank %d before %s (installed_rank %d), which sorts before it", migration.installedRank, latest.filename, latest.installedRank),
+			})
+			continue
+		}
+		previous = append(previous, migration)
+	}
+	return issues
+}
+
+// getAppliedMigrations returns the successfully applied migrations keyed by filename
+func getAppliedMigrations(db *sql.DB, driver string, table string) (map[string]appliedMigration, error) {
+	checksumColumn := "checksum"
+	if !historyColumnExists(db, table, "checksum") {
+		checksumColumn = "NULL" // history table created before checksums were recorded
+	}
+
+	rows, err := db.Query(`SELECT installed_rank, filename, ` + checksumColumn + `, ` + historyColumnOrNull(db, table, "ahead") + ` FROM ` + table + ` WHERE success = ` + boolLiteral(driver, true))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[string]appliedMigration)
+	for rows.Next() {
+		var (
+			migration appliedMigration
+			ahead     sql.NullInt64
+		)
+		if err := rows.Scan(&migration.installedRank, &migration.filename, &migration.checksum, &ahead); err != nil {
+			return nil, err
+		}
+		migration.ahead = ahead.Int64 == 1
+		applied[migration.filename] = migration
+	}
+	return applied, rows.Err()
+}
+
+// historyTableExists reports whether the migration history table exists, without creating it
+func historyTableExists(db *sql.DB, driver string, schema string) (bool, error) {
+	var query string
+	args := []interface{}{migrationHistoryTable}
+	switch driver {
+	case "postgres":
+		query = `SELECT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_schema = COALESCE(NULLIF($2, ''), current_schema()) AND table_name = $1)`
+		args = append(args, schema)
+	case "mysql":
+		query = `SELECT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_schema = COALESCE(NULLIF(?, ''), DATABASE()) AND table_name = ?)`
+		args = []interface{}{schema, migrationHistoryTable}
+	case "sqlite3":
+		query = `SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = ?)`
+	case "sqlserver":
+		query = `SELECT CASE WHEN OBJECT_ID(@p1, N'U') IS NULL THEN 0 ELSE 1 END`
+		args = []interface{}{historyTableName(driver, schema)}
+	default:
+		if dialectFor(driver) == nil {
+			return false, fmt.Errorf("unsupported driver: %s", driver)
+		}
+		// the catalogs differ between the databases of the dialects, so the table is probed
+		return historyColumnExists(db, historyTableName(driver, schema), "filename"), nil
+	}
+
+	var exists bool
+	if err := db.QueryRow(query, args...).Scan(&exists); err != nil {
+		return false, err
+	}
+	return exists, nil
+}
@@ -0,0 +1,183 @@
+package gosmm
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	// Create test_migrations directory if it doesn't exist
+	if _, err := os.Stat(migrationsDir); os.IsNotExist(err) {
+		err := os.Mkdir(migrationsDir, 0755)
+		if err != nil {
+			t.Fatalf("Failed to create test_migrations directory: %v", err)
+		}
+	}
+
+	// Create a test migration file in the test_migrations directory
+	testMigrationFile := filepath.Join(migrationsDir, "v20230101_create_test_data_00001.sql")
+	if err := ioutil.WriteFile(testMigrationFile, []byte("CREATE TABLE test_table (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+
+	config := MigrationConfig{MigrationsDir: migrationsDir, Driver: "sqlite3"}
+	err := Validate(db, config)
+	assert.NoError(t, err)
+
+	// Check the history table was not created
+	exists, err := historyTableExists(db, "sqlite3", "")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	// Validate again after the migration was applied
+	err = MigrateWithConfig(db, config)
+	assert.NoError(t, err)
+	err = Validate(db, config)
+	assert.NoError(t, err)
+
+	// Delete the test migration file
+	if err := os.Remove(testMigrationFile); err != nil {
+		t.Fatalf("Failed to delete test migration file: %v", err)
+	}
+}
+
+func TestValidateWithIssues(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	// Create test_migrations directory if it doesn't exist
+	if _, err := os.Stat(migrationsDir); os.IsNotExist(err) {
+		err := os.Mkdir(migrationsDir, 0755)
+		if err != nil {
+			t.Fatalf("Failed to create test_migrations directory: %v", err)
+		}
+	}
+
+	// Create and apply a test migration file in the test_migrations directory
+	testMigrationFile1 := filepath.Join(migrationsDir, "v20230101_create_test_data_00001.sql")
+	if err := ioutil.WriteFile(testMigrationFile1, []byte("CREATE TABLE test_table (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	config := MigrationConfig{MigrationsDir: migrationsDir, Driver: "sqlite3"}
+	err := MigrateWithConfig(db, config)
+	assert.NoError(t, err)
+
+	// Modify the applied file, skip a sequence number and add an invalid filename
+	if err := ioutil.WriteFile(testMigrationFile1, []byte("CREATE TABLE test_table (id INTEGER, name TEXT);"), 0644); err != nil {
+		t.Fatalf("Failed to modify test migration file: %v", err)
+	}
+	testMigrationFile3 := filepath.Join(migrationsDir, "v20230101_create_test_data_00003.sql")
+	if err := ioutil.WriteFile(testMigrationFile3, []byte("CREATE TABLE test_table_3 (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	invalidMigrationFile := filepath.Join(migrationsDir, "create_test_data.sql")
+	if err := ioutil.WriteFile(invalidMigrationFile, []byte("CREATE TABLE test_table_4 (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+
+	// Record a migration whose file does not exist
+	_, err = db.Exec(`INSERT INTO gosmm_migration_history (installed_rank, filename, installed_on, execution_time, success)
+		VALUES (2, 'v20220101_removed_00000.sql', '2021-01-01 00:00:00', 0, TRUE)`)
+	if err != nil {
+		t.Fatalf("Failed to insert gosmm_migration_history entry: %v", err)
+	}
+
+	// gaps only fail the validation with StrictOrdering
+	config.StrictOrdering = true
+	err = Validate(db, config)
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Expected a ValidationError, got %v", err)
+	}
+	kinds := make([]ValidationIssueKind, 0, len(validationErr.Issues))
+	for _, issue := range validationErr.Issues {
+		kinds = append(kinds, issue.Kind)
+	}
+	assert.Equal(t, []ValidationIssueKind{
+		IssueInvalidFilename,
+		IssueOrderingGap,
+		IssueChecksumMismatch,
+		IssueMissingFile,
+	}, kinds)
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+	assert.ErrorIs(t, err, ErrMissingFile)
+
+	// Delete the test migration files
+	for _, file := range []string{testMigrationFile1, testMigrationFile3, invalidMigrationFile} {
+		if err := os.Remove(file); err != nil {
+			t.Fatalf("Failed to delete test migration file: %v", err)
+		}
+	}
+}
+
+func TestValidateOrdering(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	write := func(name string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("SELECT 1;"), 0644); err != nil {
+			t.Fatalf("Failed to create test migration file: %v", err)
+		}
+	}
+	write("v20230101_create_users_00001.sql")
+	write("v20230102_add_email_00002.sql")
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}
+	assert.NoError(t, MigrateWithConfig(db, config))
+
+	// two branches add the same sequence number, and a gap is only a warning
+	write("v20230103_add_name_00003.sql")
+	write("v20230104_add_age_00003.sql")
+	write("v20230105_add_city_00005.sql")
+	err := Validate(db, config)
+	var validationErr *ValidationError
+	if assert.ErrorAs(t, err, &validationErr) && assert.Len(t, validationErr.Issues, 1) {
+		assert.Equal(t, ValidationIssue{
+			Kind:     IssueDuplicateSequence,
+			Filename: "v20230104_add_age_00003.sql",
+			Message:  "sequence number 00003 is also used by v20230103_add_name_00003.sql, renumber it to v20230106_add_age_00006.sql",
+		}, validationErr.Issues[0])
+	}
+
+	config.StrictOrdering = true
+	err = Validate(db, config)
+	if assert.ErrorAs(t, err, &validationErr) && assert.Len(t, validationErr.Issues, 2) {
+		assert.Equal(t, IssueOrderingGap, validationErr.Issues[1].Kind)
+		assert.Equal(t, "v20230105_add_city_00005.sql", validationErr.Issues[1].Filename)
+	}
+}
+
+func TestValidateOutOfOrder(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	for _, name := range []string{"v20230101_create_users_00001.sql", "v20230102_add_email_00002.sql"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("SELECT 1;"), 0644); err != nil {
+			t.Fatalf("Failed to create test migration file: %v", err)
+		}
+	}
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}
+	assert.NoError(t, MigrateWithConfig(db, config))
+	// the second migration was applied first, e.g. merged from a branch applied with AllowOutOfOrder
+	if _, err := db.Exec("UPDATE gosmm_migration_history SET installed_rank = 3 - installed_rank"); err != nil {
+		t.Fatalf("Failed to update gosmm_migration_history: %v", err)
+	}
+
+	err := Validate(db, config)
+	var validationErr *ValidationError
+	if assert.ErrorAs(t, err, &validationErr) && assert.Len(t, validationErr.Issues, 1) {
+		assert.Equal(t, IssueOutOfOrder, validationErr.Issues[0].Kind)
+		assert.Equal(t, "v20230102_add_email_00002.sql", validationErr.Issues[0].Filename)
+	}
+
+	config.AllowOutOfOrder = true
+	assert.NoError(t, Validate(db, config))
+}
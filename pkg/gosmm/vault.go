@@ -0,0 +1,219 @@
+package gosmm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultVaultMount is the default mount path of the Vault database secrets engine
+const defaultVaultMount = "database"
+
+// VaultCredentials is a CredentialsProvider using the dynamic credentials of a role of the
+// Vault database secrets engine. The lease of the credentials is renewed in the background
+// while the database is open, so that long-running migrations keep their credentials;
+// new credentials are requested when it cannot be renewed anymore.
+// Closing the sql.DB opened with it stops the renewal.
+type VaultCredentials struct {
+	// Role is the name of the database secrets engine role
+	Role string
+	// Mount is the mount path of the database secrets engine, "database" when empty
+	Mount string
+	// Address of Vault, VAULT_ADDR when empty
+	Address string
+	// Token authenticating to Vault, VAULT_TOKEN when empty
+	Token string
+	// Namespace of the role (Vault Enterprise), VAULT_NAMESPACE when empty
+	Namespace string
+	// Client sends the requests, http.DefaultClient when nil
+	Client *http.Client
+
+	mu        sync.Mutex
+	lease     *vaultLease
+	stopRenew chan struct{}
+}
+
+// vaultLease is a lease of dynamic credentials
+type vaultLease struct {
+	id        string
+	user      string
+	password  string
+	renewable bool
+	expiresAt time.Time
+}
+
+// vaultResponse is the part of the Vault API responses used by VaultCredentials
+type vaultResponse struct {
+	LeaseID       string `json:"lease_id"`
+	LeaseDuration int    `json:"lease_duration"`
+	Renewable     bool   `json:"renewable"`
+	Data          struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	} `json:"data"`
+	Errors []string `json:"errors"`
+}
+
+// Password implements PasswordProvider
+func (v *VaultCredentials) Password(ctx context.Context, config DBConfig) (string, time.Time, error) {
+	_, password, expiresAt, err := v.Credentials(ctx, config)
+	return password, expiresAt, err
+}
+
+// Credentials implements CredentialsProvider, returning the credentials of the current lease
+// until it is about to expire
+func (v *VaultCredentials) Credentials(ctx context.Context, _ DBConfig) (string, string, time.Time, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.lease != nil && time.Now().Add(passwordRefreshMargin).Before(v.lease.expiresAt) {
+		return v.lease.user, v.lease.password, v.lease.expiresAt, nil
+	}
+
+	mount := v.Mount
+	if mount == "" {
+		mount = defaultVaultMount
+	}
+	var resp vaultResponse
+	if err := v.request(ctx, http.MethodGet, "/v1/"+strings.Trim(mount, "/")+"/creds/"+v.Role, nil, &resp); err != nil {
+		return "", "", time.Time{}, fmt.Errorf("failed to get credentials of vault role %s: %w", v.Role, err)
+	}
+	lease := &vaultLease{
+		id:        resp.LeaseID,
+		user:      resp.Data.Username,
+		password:  resp.Data.Password,
+		renewable: resp.Renewable,
+		expiresAt: time.Now().Add(time.Duration(resp.LeaseDuration) * time.Second),
+	}
+	v.stopRenewal()
+	v.lease = lease
+	if lease.renewable && resp.LeaseDuration > 0 {
+		v.stopRenew = make(chan struct{})
+		go v.renew(lease, time.Duration(resp.LeaseDuration)*time.Second, v.stopRenew)
+	}
+	return lease.user, lease.password, lease.expiresAt, nil
+}
+
+// Close implements io.Closer, stopping the renewal of the lease.
+// The lease is not revoked, the credentials expire with it.
+func (v *VaultCredentials) Close() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.stopRenewal()
+	return nil
+}
+
+// stopRenewal stops the renewal of the current lease, v.mu being held
+func (v *VaultCredentials) stopRenewal() {
+	if v.stopRenew != nil {
+		close(v.stopRenew)
+		v.stopRenew = nil
+	}
+}
+
+// renew renews lease when two thirds of its duration have elapsed, until stop is closed
+// or the lease cannot be renewed anymore
+func (v *VaultCredentials) renew(lease *vaultLease, duration time.Duration, stop chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-time.After(duration * 2 / 3):
+		}
+
+		var resp vaultResponse
+		body := map[string]interface{}{"lease_id": lease.id, "increment": int(duration / time.Second)}
+		err := v.request(context.Background(), http.MethodPut, "/v1/sys/leases/renew", body, &resp)
+		// the lease cannot be renewed anymore, new credentials are requested once it expires
+		if err != nil || resp.LeaseDuration <= 0 {
+			return
+		}
+
+		v.mu.Lock()
+		select {
+		case <-stop:
+			v.mu.Unlock()
+			return
+		default:
+		}
+		duration = time.Duration(resp.LeaseDuration) * time.Second
+		lease.expiresAt = time.Now().Add(duration)
+		v.mu.Unlock()
+		if !resp.Renewable {
+			return
+		}
+	}
+}
+
+// request sends a request to the Vault API and decodes its response into out
+func (v *VaultCredentials) request(ctx context.Context, method string, path string, body interface{}, out *vaultResponse) error {
+	address := firstNonEmpty(v.Address, os.Getenv("VAULT_ADDR"))
+	if address == "" {
+		return errors.New("missing vault address")
+	}
+	token := firstNonEmpty(v.Token, os.Getenv("VAULT_TOKEN"))
+	if token == "" {
+		return errors.New("missing vault token")
+	}
+
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(address, "/")+path, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("invalid vault address: %w", err)
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if namespace := firstNonEmpty(v.Namespace, os.Getenv("VAULT_NAMESPACE")); namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := v.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, out); err != nil && resp.StatusCode == http.StatusOK {
+		return fmt.Errorf("failed to parse vault response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		if len(out.Errors) > 0 {
+			return fmt.Errorf("%s: %s", resp.Status, strings.Join(out.Errors, ", "))
+		}
+		return errors.New(resp.Status)
+	}
+	return nil
+}
+
+// firstNonEmpty returns the first non-empty value
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,127 @@
+package gosmm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeVault is a Vault server issuing credentials for the migrator role of the database mount
+type fakeVault struct {
+	mu            sync.Mutex
+	issued        int
+	renewed       int
+	leaseDuration int
+}
+
+func (f *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.Header.Get("X-Vault-Token") != "root" {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"errors": ["permission denied"]}`))
+		return
+	}
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/v1/database/creds/migrator":
+		f.issued++
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"lease_id":       "database/creds/migrator/abc",
+			"lease_duration": f.leaseDuration,
+			"renewable":      true,
+			"data":           map[string]string{"username": "v-migrator-abc", "password": "s3cret"},
+		})
+	case r.Method == http.MethodPut && r.URL.Path == "/v1/sys/leases/renew":
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["lease_id"] != "database/creds/migrator/abc" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.renewed++
+		json.NewEncoder(w).Encode(map[string]interface{}{"lease_id": body["lease_id"], "lease_duration": f.leaseDuration, "renewable": true})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"errors": []}`))
+	}
+}
+
+func (f *fakeVault) counts() (int, int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.issued, f.renewed
+}
+
+func TestVaultCredentials(t *testing.T) {
+	vault := &fakeVault{leaseDuration: 3600}
+	server := httptest.NewServer(vault)
+	defer server.Close()
+
+	credentials := &VaultCredentials{Role: "migrator", Address: server.URL, Token: "root"}
+	defer credentials.Close()
+	user, password, expiresAt, err := credentials.Credentials(context.Background(), DBConfig{})
+	assert.NoError(t, err)
+	assert.Equal(t, "v-migrator-abc", user)
+	assert.Equal(t, "s3cret", password)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), expiresAt, time.Minute)
+
+	// The lease is reused until it is about to expire
+	_, _, _, err = credentials.Credentials(context.Background(), DBConfig{})
+	assert.NoError(t, err)
+	issued, _ := vault.counts()
+	assert.Equal(t, 1, issued)
+
+	_, _, _, err = (&VaultCredentials{Role: "migrator", Address: server.URL, Token: "wrong"}).Credentials(context.Background(), DBConfig{})
+	assert.ErrorContains(t, err, "permission denied")
+	_, _, _, err = (&VaultCredentials{Role: "unknown", Address: server.URL, Token: "root"}).Credentials(context.Background(), DBConfig{})
+	assert.Error(t, err)
+}
+
+func TestVaultCredentialsRenewal(t *testing.T) {
+	vault := &fakeVault{leaseDuration: 1}
+	server := httptest.NewServer(vault)
+	defer server.Close()
+
+	credentials := &VaultCredentials{Role: "migrator", Address: server.URL, Token: "root"}
+	_, _, _, err := credentials.Credentials(context.Background(), DBConfig{})
+	assert.NoError(t, err)
+
+	// The lease is renewed after two thirds of its duration
+	assert.Eventually(t, func() bool {
+		_, renewed := vault.counts()
+		return renewed > 0
+	}, 3*time.Second, 50*time.Millisecond)
+
+	// Closing stops the renewal
+	assert.NoError(t, credentials.Close())
+	_, renewed := vault.counts()
+	time.Sleep(1500 * time.Millisecond)
+	_, renewedAfterClose := vault.counts()
+	assert.LessOrEqual(t, renewedAfterClose, renewed+1)
+}
+
+func TestConnectDBWithVaultCredentials(t *testing.T) {
+	vault := &fakeVault{leaseDuration: 3600}
+	server := httptest.NewServer(vault)
+	defer server.Close()
+
+	// The user is provided by Vault
+	db, err := Connect(DBConfig{
+		Driver:           "sqlite3",
+		Host:             "localhost",
+		Port:             1,
+		DBName:           filepath.Join(t.TempDir(), "test.db"),
+		PasswordProvider: &VaultCredentials{Role: "migrator", Address: server.URL, Token: "root"},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, db.Close())
+	issued, _ := vault.counts()
+	assert.Equal(t, 1, issued)
+}
@@ -0,0 +1,107 @@
+package gosmm
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// SchemaVersion identifies the schema of a database, see CurrentVersion
+type SchemaVersion struct {
+	// Migration is the latest applied migration in the order of the versions, empty when none is applied
+	Migration string `json:"migration,omitempty"`
+	// InstalledOn is when Migration was applied
+	InstalledOn *time.Time `json:"installed_on,omitempty"`
+	// Applied is the number of applied migrations
+	Applied int `json:"applied"`
+	// Fingerprint is the SHA-256 of the schema, see CurrentVersion
+	Fingerprint string `json:"fingerprint"`
+}
+
+// CurrentVersion returns the latest applied migration and a fingerprint of the schema of the database, so that
+// an application can log or expose the schema it runs against. The fingerprint hashes the tables and indexes
+// returned by InspectSchema, so two databases with the same fingerprint have the same schema whatever the
+// migrations they went through; for the drivers InspectSchema does not support, it hashes the applied
+// migrations and their checksums instead. Like Status, it never modifies the database.
+func CurrentVersion(db *sql.DB, config MigrationConfig) (SchemaVersion, error) {
+	history, err := GetHistory(db, config)
+	if err != nil {
+		return SchemaVersion{}, err
+	}
+	naming, err := config.naming()
+	if err != nil {
+		return SchemaVersion{}, err
+	}
+	var version SchemaVersion
+	applied := make([]HistoryEntry, 0, len(history))
+	for _, entry := range history {
+		if !entry.Success {
+			continue
+		}
+		applied = append(applied, entry)
+		if version.Migration == "" || naming.less(version.Migration, entry.Filename) {
+			installedOn := entry.InstalledOn
+			version.Migration, version.InstalledOn = entry.Filename, &installedOn
+		}
+	}
+	version.Applied = len(applied)
+
+	hash := sha256.New()
+	switch config.Driver {
+	case "postgres", "mysql", "sqlite3", "sqlserver":
+		objects, err := InspectSchema(db, config)
+		if err != nil {
+			return SchemaVersion{}, err
+		}
+		for _, object := range objects {
+			fmt.Fprintf(hash, "%s\n%s\n", object.key(), object.Definition)
+		}
+	default:
+		for _, entry := range applied {
+			fmt.Fprintf(hash, "%s\x00%s\n", entry.Filename, entry.Checksum)
+		}
+	}
+	version.Fingerprint = hex.EncodeToString(hash.Sum(nil))
+	return version, nil
+}
+
+// RequireVersion fails fast at the startup of an application running against a database older than it
+// expects, e.g. after a partial deploy that updated the application but not its migrations. minVersion is
+// the version of the migration the application needs, or its filename, e.g. v20230102_add_email_00002.sql.
+// It returns an error wrapping ErrSchemaTooOld unless an applied migration has a version at least minVersion,
+// which the application can log as a warning instead of exiting. Only the history table is read, so the
+// migration files need not be deployed with the application.
+func RequireVersion(db *sql.DB, config MigrationConfig, minVersion string) error {
+	naming, err := config.naming()
+	if err != nil {
+		return err
+	}
+	required, ok := naming.version(minVersion)
+	if !ok {
+		if required, ok = naming.version(minVersion + sqlFileExtension); !ok {
+			required = minVersion
+		}
+	}
+	history, err := GetHistory(db, config)
+	if err != nil {
+		return err
+	}
+	var latest, latestVersion string
+	for _, entry := range history {
+		if !entry.Success {
+			continue
+		}
+		if version, _ := naming.version(entry.Filename); latest == "" || compareVersions(latestVersion, version) < 0 {
+			latest, latestVersion = entry.Filename, version
+		}
+	}
+	if latest == "" {
+		return fmt.Errorf("%w: no migration applied, %s required", ErrSchemaTooOld, minVersion)
+	}
+	if compareVersions(latestVersion, required) < 0 {
+		return fmt.Errorf("%w: latest applied migration %s, %s required", ErrSchemaTooOld, latest, minVersion)
+	}
+	return nil
+}
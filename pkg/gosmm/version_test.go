@@ -0,0 +1,89 @@
+package gosmm
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCurrentVersion(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}
+	version, err := CurrentVersion(db, config)
+	assert.NoError(t, err)
+	assert.Equal(t, "", version.Migration)
+	assert.Equal(t, 0, version.Applied)
+	empty := version.Fingerprint
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create migration file: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230102_add_email_00002.sql"), []byte("ALTER TABLE users ADD COLUMN email TEXT;"), 0644); err != nil {
+		t.Fatalf("Failed to create migration file: %v", err)
+	}
+	assert.NoError(t, MigrateWithConfig(db, config))
+	version, err = CurrentVersion(db, config)
+	assert.NoError(t, err)
+	assert.Equal(t, "v20230102_add_email_00002.sql", version.Migration)
+	assert.NotNil(t, version.InstalledOn)
+	assert.Equal(t, 2, version.Applied)
+	assert.Len(t, version.Fingerprint, 64)
+	assert.NotEqual(t, empty, version.Fingerprint)
+
+	// another database with the same migrations has the same fingerprint
+	other, teardownOther := setupTestDB(t)
+	defer teardownOther()
+	assert.NoError(t, MigrateWithConfig(other, config))
+	otherVersion, err := CurrentVersion(other, config)
+	assert.NoError(t, err)
+	assert.Equal(t, version.Fingerprint, otherVersion.Fingerprint)
+
+	// a change made outside of the migrations changes the fingerprint
+	if _, err := other.Exec("CREATE INDEX users_email ON users (email)"); err != nil {
+		t.Fatalf("Failed to create index: %v", err)
+	}
+	otherVersion, err = CurrentVersion(other, config)
+	assert.NoError(t, err)
+	assert.Equal(t, version.Migration, otherVersion.Migration)
+	assert.NotEqual(t, version.Fingerprint, otherVersion.Fingerprint)
+}
+
+func TestRequireVersion(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	config := MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}
+	err := RequireVersion(db, config, "v20230101_create_users_00001.sql")
+	assert.True(t, errors.Is(err, ErrSchemaTooOld))
+	assert.EqualError(t, err, "database schema is older than required: no migration applied, v20230101_create_users_00001.sql required")
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create migration file: %v", err)
+	}
+	assert.NoError(t, MigrateWithConfig(db, config))
+	assert.NoError(t, RequireVersion(db, config, "v20230101_create_users_00001.sql"))
+	assert.NoError(t, RequireVersion(db, config, "v20230101"))
+	err = RequireVersion(db, config, "v20230102_add_email_00002")
+	assert.True(t, errors.Is(err, ErrSchemaTooOld))
+	assert.EqualError(t, err, "database schema is older than required: latest applied migration v20230101_create_users_00001.sql, v20230102_add_email_00002 required")
+
+	// the versions of a filename pattern are compared numerically
+	db, teardown = setupTestDB(t)
+	defer teardown()
+	flyway := t.TempDir()
+	config = MigrationConfig{MigrationsDir: flyway, Driver: "sqlite3", FilenamePattern: FlywayFilenamePattern}
+	if err := ioutil.WriteFile(filepath.Join(flyway, "V1.10__create_orders.sql"), []byte("CREATE TABLE orders (id INTEGER);"), 0644); err != nil {
+		t.Fatalf("Failed to create migration file: %v", err)
+	}
+	assert.NoError(t, MigrateWithConfig(db, config))
+	assert.NoError(t, RequireVersion(db, config, "1.9"))
+	assert.NoError(t, RequireVersion(db, config, "V1.10__create_orders.sql"))
+	assert.True(t, errors.Is(RequireVersion(db, config, "1.11"), ErrSchemaTooOld))
+}
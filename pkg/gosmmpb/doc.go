@@ -0,0 +1,5 @@
+// Package gosmmpb holds the gRPC API of gosmm generated from migration.proto, including the
+// MigrationServiceClient used to control a `gosmm serve` migration runner.
+package gosmmpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative migration.proto
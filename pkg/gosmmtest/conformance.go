@@ -0,0 +1,294 @@
+package gosmmtest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/k1e1n04/gosmm/v2/pkg/gosmm"
+)
+
+const (
+	// conformanceUsers and conformanceOrders are the tables created by the checks of the conformance suite
+	conformanceUsers  = "gosmm_conformance_users"
+	conformanceOrders = "gosmm_conformance_orders"
+	// conformanceHistoryTable is the history table of gosmm, on which the dialects take their lock
+	conformanceHistoryTable = "gosmm_migration_history"
+)
+
+// conformanceTables are dropped before and after each check of the conformance suite, so that each check
+// starts from an empty database: the tables of the checks and those created by gosmm
+var conformanceTables = []string{conformanceUsers, conformanceOrders, conformanceHistoryTable, "gosmm_migration_history_version", "gosmm_migration_lock"}
+
+// ConformanceOptions adapts the conformance suite to a database
+type ConformanceOptions struct {
+	// CreateTable returns the statement creating the table with an integer id column and a name column holding
+	// strings of up to 100 characters, CREATE TABLE <table> (id INTEGER, name VARCHAR(100)) when nil
+	CreateTable func(table string) string
+}
+
+// Target is the database of a registered dialect the conformance suite runs against, see RunConformance
+type Target struct {
+	// Container describes the container of the database, whose driver is the one the dialect is registered under
+	Container ContainerSpec
+	// Options configures the container
+	Options Options
+	// Conformance adapts the suite to the database
+	Conformance ConformanceOptions
+}
+
+// RunConformance runs the conformance suite of CheckConformance against every dialect registered with
+// gosmm.RegisterDialect, each in a subtest named after its driver, on the database of its target started
+// with StartContainer. The dialects without a target are skipped, e.g. those of cloud databases, so that a
+// dialect contribution adds its target to the matrix:
+//
+//	func TestConformance(t *testing.T) {
+//		gosmmtest.RunConformance(t, gosmmtest.Target{Container: gosmmtest.ContainerSpec{
+//			Driver: "duckdb-server",
+//			Image:  "example/duckdb-server:1.0",
+//			Port:   5433,
+//			DSN:    func(host string, port int) string { return fmt.Sprintf("duckdb://%s:%d", host, port) },
+//			DSNEnv: "DUCKDB_TEST_DSN",
+//		}})
+//	}
+func RunConformance(t *testing.T, targets ...Target) {
+	byDriver := make(map[string]Target, len(targets))
+	for _, target := range targets {
+		if gosmm.LookupDialect(target.Container.Driver) == nil {
+			t.Errorf("gosmmtest: no dialect registered for the %s driver of a conformance target", target.Container.Driver)
+		}
+		byDriver[target.Container.Driver] = target
+	}
+	for _, driver := range gosmm.Dialects() {
+		driver := driver
+		t.Run(driver, func(t *testing.T) {
+			target, ok := byDriver[driver]
+			if !ok {
+				t.Skip("no conformance target for the " + driver + " dialect")
+			}
+			c, err := StartContainer(context.Background(), target.Options, target.Container)
+			if err != nil {
+				t.Fatalf("gosmmtest: %v", err)
+			}
+			defer c.Close()
+			db, err := gosmm.ConnectDB(c.Config)
+			if err != nil {
+				t.Fatalf("gosmmtest: failed to connect to the %s database: %v", driver, err)
+			}
+			defer db.Close()
+			CheckConformance(t, db, driver, target.Conformance)
+		})
+	}
+}
+
+// CheckConformance runs the conformance suite against db, a database of the dialect registered under driver,
+// each check in a subtest: the splitting of the migration files into statements, the migrations and their
+// history, the rollback of a failed migration as announced by Dialect.TransactionalDDL, and the lock keeping
+// concurrent runs from applying a migration twice. The tables of gosmm are dropped before and after each
+// check, so db must be a database dedicated to the tests.
+func CheckConformance(t *testing.T, db *sql.DB, driver string, options ConformanceOptions) {
+	dialect := gosmm.LookupDialect(driver)
+	if dialect == nil {
+		t.Fatalf("gosmmtest: no dialect registered for the %s driver", driver)
+	}
+	if options.CreateTable == nil {
+		options.CreateTable = func(table string) string {
+			return "CREATE TABLE " + table + " (id INTEGER, name VARCHAR(100))"
+		}
+	}
+	suite := conformance{db: db, driver: driver, dialect: dialect, options: options}
+	for _, check := range []struct {
+		name string
+		run  func(t *testing.T)
+	}{
+		{"SplitStatements", suite.checkSplitStatements},
+		{"Migrate", suite.checkMigrate},
+		{"FailedMigration", suite.checkFailedMigration},
+		{"Lock", suite.checkLock},
+	} {
+		t.Run(check.name, func(t *testing.T) {
+			suite.dropTables()
+			t.Cleanup(suite.dropTables)
+			check.run(t)
+		})
+	}
+}
+
+// conformance is the conformance suite of a dialect
+type conformance struct {
+	db      *sql.DB
+	driver  string
+	dialect gosmm.Dialect
+	options ConformanceOptions
+}
+
+// dropTables drops the tables of the checks and of gosmm, ignoring those that do not exist
+func (s conformance) dropTables() {
+	for _, table := range conformanceTables {
+		s.db.Exec("DROP TABLE " + table)
+	}
+}
+
+// config writes the migration files with the given contents to a temporary directory and returns the config
+// applying them
+func (s conformance) config(t *testing.T, contents ...string) gosmm.MigrationConfig {
+	dir := t.TempDir()
+	for i, content := range contents {
+		name := fmt.Sprintf("v202301%02d_conformance_%05d.sql", i+1, i+1)
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("gosmmtest: failed to write migration file: %v", err)
+		}
+	}
+	return gosmm.MigrationConfig{MigrationsDir: dir, Driver: s.driver}
+}
+
+// users returns the number of rows of the users table, or an error when it does not exist
+func (s conformance) users() (int, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM " + conformanceUsers).Scan(&count)
+	return count, err
+}
+
+// checkSplitStatements checks that the semicolons of comments and string literals do not split statements
+func (s conformance) checkSplitStatements(t *testing.T) {
+	statements := s.dialect.SplitStatements("-- the users; and their names\n" +
+		s.options.CreateTable(conformanceUsers) + ";\n" +
+		"INSERT INTO " + conformanceUsers + " (id, name) VALUES (1, 'a;b');\n")
+	if len(statements) != 2 {
+		t.Fatalf("split into %d statements instead of 2: %q", len(statements), statements)
+	}
+	if !strings.Contains(statements[1], "'a;b'") {
+		t.Errorf("the string literal of the second statement was split: %q", statements[1])
+	}
+}
+
+// checkMigrate checks that the migrations are applied once and recorded in the history
+func (s conformance) checkMigrate(t *testing.T) {
+	config := s.config(t,
+		s.options.CreateTable(conformanceUsers)+";\nINSERT INTO "+conformanceUsers+" (id, name) VALUES (1, 'a;b');\n",
+		s.options.CreateTable(conformanceOrders)+";\n",
+	)
+	if err := gosmm.MigrateWithConfig(s.db, config); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	var name string
+	if err := s.db.QueryRow("SELECT name FROM " + conformanceUsers + " WHERE id = 1").Scan(&name); err != nil {
+		t.Fatalf("failed to read the inserted row: %v", err)
+	}
+	if name != "a;b" {
+		t.Errorf("inserted name %q instead of %q", name, "a;b")
+	}
+
+	// a second run applies nothing
+	if err := gosmm.MigrateWithConfig(s.db, config); err != nil {
+		t.Fatalf("failed to migrate again: %v", err)
+	}
+	if count, err := s.users(); err != nil || count != 1 {
+		t.Errorf("%d users after the second run instead of 1 (%v)", count, err)
+	}
+	history, err := gosmm.GetHistory(s.db, config)
+	if err != nil {
+		t.Fatalf("failed to read the history: %v", err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("%d history entries instead of 2: %+v", len(history), history)
+	}
+	for i, entry := range history {
+		if entry.InstalledRank != i+1 || !entry.Success || entry.Checksum == "" {
+			t.Errorf("unexpected history entry %+v", entry)
+		}
+	}
+	if err := gosmm.Validate(s.db, config); err != nil {
+		t.Errorf("failed to validate: %v", err)
+	}
+	report, err := gosmm.Status(s.db, config)
+	if err != nil {
+		t.Fatalf("failed to read the status: %v", err)
+	}
+	if report.State != gosmm.StateUpToDate || report.Applied != 2 {
+		t.Errorf("status %s with %d applied migrations instead of up-to-date with 2", report.State, report.Applied)
+	}
+}
+
+// checkFailedMigration checks that a failed migration is recorded, leaving the database dirty, and that its
+// DDL is rolled back exactly when the dialect announces transactional DDL
+func (s conformance) checkFailedMigration(t *testing.T) {
+	config := s.config(t, s.options.CreateTable(conformanceUsers)+";\nINSERT INTO gosmm_conformance_missing (id) VALUES (1);\n")
+	err := gosmm.MigrateWithConfig(s.db, config)
+	var failed *gosmm.ErrMigrationFailed
+	if !errors.As(err, &failed) {
+		t.Fatalf("the failing migration returned %v instead of a *gosmm.ErrMigrationFailed", err)
+	}
+	if failed.StatementIndex != 2 {
+		t.Errorf("failed statement %d instead of 2", failed.StatementIndex)
+	}
+	_, err = s.users()
+	switch created := err == nil; {
+	case created && s.dialect.TransactionalDDL():
+		t.Errorf("the DDL of the failed migration was not rolled back, although the dialect has transactional DDL")
+	case !created && !s.dialect.TransactionalDDL():
+		t.Errorf("the DDL of the failed migration was rolled back, although the dialect has no transactional DDL: a resumed migration would skip it")
+	}
+
+	if err := gosmm.MigrateWithConfig(s.db, config); !errors.Is(err, gosmm.ErrDirtyState) {
+		t.Errorf("the run after the failure returned %v instead of gosmm.ErrDirtyState", err)
+	}
+	if err := gosmm.RestoreWithConfig(s.db, config); err != nil {
+		t.Fatalf("failed to restore: %v", err)
+	}
+	report, err := gosmm.Status(s.db, config)
+	if err != nil {
+		t.Fatalf("failed to read the status: %v", err)
+	}
+	if report.State != gosmm.StatePending {
+		t.Errorf("status %s after the restore instead of pending", report.State)
+	}
+}
+
+// checkLock checks that a run waits for the lock held by another run, and that concurrent runs apply each
+// migration once. It is skipped for the dialects taking no lock.
+func (s conformance) checkLock(t *testing.T) {
+	config := s.config(t, s.options.CreateTable(conformanceUsers)+";\nINSERT INTO "+conformanceUsers+" (id, name) VALUES (1, 'a');\n")
+	unlock, err := s.dialect.Lock(context.Background(), s.db, conformanceHistoryTable, 0)
+	if err != nil {
+		t.Fatalf("failed to lock: %v", err)
+	}
+	waiting := config
+	waiting.WaitForLock = time.Second
+	err = gosmm.MigrateWithConfig(s.db, waiting)
+	if unlockErr := unlock(); unlockErr != nil {
+		t.Fatalf("failed to unlock: %v", unlockErr)
+	}
+	if err == nil {
+		t.Skip("the dialect takes no lock")
+	}
+	if !errors.Is(err, gosmm.ErrLockTimeout) {
+		t.Fatalf("the run waiting for the held lock returned %v instead of gosmm.ErrLockTimeout", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = gosmm.MigrateWithConfig(s.db, config)
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Errorf("concurrent run failed: %v", err)
+		}
+	}
+	if count, err := s.users(); err != nil || count != 1 {
+		t.Errorf("%d users after the concurrent runs instead of 1 (%v)", count, err)
+	}
+}
@@ -0,0 +1,140 @@
+package gosmmtest
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/k1e1n04/gosmm/v2/pkg/gosmm"
+	"github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+)
+
+// conformanceDriver is the driver of conformanceDialect, SQLite registered under another name
+const conformanceDriver = "gosmmtest-sqlite"
+
+// conformanceDialect is a registered dialect of SQLite conforming to the suite, with a lock held in memory
+type conformanceDialect struct {
+	lock chan struct{}
+}
+
+func (d conformanceDialect) HistoryTableDDL(table string) string {
+	return `CREATE TABLE IF NOT EXISTS ` + table + ` (
+		installed_rank INTEGER PRIMARY KEY, filename TEXT, installed_on TIMESTAMP, execution_time INTEGER,
+		success BOOLEAN, checksum TEXT, failed_statement INTEGER, committed_statements INTEGER, author TEXT,
+		ticket TEXT, description TEXT, backup TEXT, applied_by TEXT, context TEXT, rows_affected INTEGER,
+		approval TEXT,
+		ahead INTEGER
+	)`
+}
+
+func (d conformanceDialect) HistoryVersionTableDDL(table string) string {
+	return `CREATE TABLE IF NOT EXISTS ` + table + ` (version INTEGER PRIMARY KEY, upgraded_on TIMESTAMP NOT NULL)`
+}
+
+func (d conformanceDialect) LockTableDDL(table string) string {
+	return `CREATE TABLE IF NOT EXISTS ` + table + ` (lock_name TEXT PRIMARY KEY, owner TEXT NOT NULL, expires_at INTEGER NOT NULL)`
+}
+
+func (d conformanceDialect) Lock(ctx context.Context, _ *sql.DB, _ string, wait time.Duration) (func() error, error) {
+	var timeout <-chan time.Time
+	if wait > 0 {
+		timeout = time.After(wait)
+	}
+	select {
+	case d.lock <- struct{}{}:
+		return func() error {
+			<-d.lock
+			return nil
+		}, nil
+	case <-timeout:
+		return nil, gosmm.ErrLockTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (d conformanceDialect) QuoteIdentifier(name string) string {
+	return `"` + name + `"`
+}
+
+func (d conformanceDialect) BindParam(int) string {
+	return "?"
+}
+
+func (d conformanceDialect) TransactionalDDL() bool {
+	return true
+}
+
+// SplitStatements splits on the semicolons outside of string literals, leaving the line comments out
+func (d conformanceDialect) SplitStatements(content string) []string {
+	var statements []string
+	var current strings.Builder
+	inString, inComment := false, false
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		switch {
+		case inComment:
+			inComment = c != '\n'
+			continue
+		case inString:
+			inString = c != '\''
+		case c == '\'':
+			inString = true
+		case c == '-' && strings.HasPrefix(content[i:], "--"):
+			inComment = true
+			continue
+		case c == ';':
+			if statement := strings.TrimSpace(current.String()); statement != "" {
+				statements = append(statements, statement)
+			}
+			current.Reset()
+			continue
+		}
+		current.WriteByte(c)
+	}
+	if statement := strings.TrimSpace(current.String()); statement != "" {
+		statements = append(statements, statement)
+	}
+	return statements
+}
+
+func init() {
+	sql.Register(conformanceDriver, &sqlite3.SQLiteDriver{})
+	gosmm.RegisterDialect(conformanceDriver, conformanceDialect{lock: make(chan struct{}, 1)})
+}
+
+func TestRunConformance(t *testing.T) {
+	t.Setenv("GOSMMTEST_SQLITE_DSN", filepath.Join(t.TempDir(), "conformance.db")+"?_busy_timeout=5000")
+	// no container is started for an existing database, as there is no Docker daemon to start it
+	withoutDocker(t)
+
+	// the other dialects, without a target, are skipped
+	RunConformance(t, Target{
+		Container: ContainerSpec{
+			Driver: conformanceDriver,
+			Image:  "example/sqlite:1",
+			Port:   1,
+			DSN:    func(host string, port int) string { return "unused" },
+			DSNEnv: "GOSMMTEST_SQLITE_DSN",
+		},
+	})
+}
+
+func TestStartContainer(t *testing.T) {
+	_, err := StartContainer(context.Background(), Options{}, ContainerSpec{Driver: conformanceDriver, Port: 1})
+	assert.EqualError(t, err, "incomplete container spec: the driver, image, port and DSN are required")
+
+	withoutDocker(t)
+	_, err = StartContainer(context.Background(), Options{StartupTimeout: time.Second}, ContainerSpec{
+		Driver: conformanceDriver,
+		Image:  "example/sqlite:1",
+		Port:   7000,
+		Env:    []string{"PASSWORD=secret"},
+		DSN:    func(host string, port int) string { return filepath.Join(t.TempDir(), "missing", "test.db") },
+	})
+	assert.ErrorContains(t, err, "failed to start "+conformanceDriver+" container")
+}
@@ -0,0 +1,155 @@
+package gosmmtest
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/k1e1n04/gosmm/v2/pkg/gosmm"
+)
+
+// UpdateGoldenEnv is the environment variable making AssertSchemaMatches write the golden files instead of
+// comparing them, e.g. GOSMM_UPDATE_GOLDEN=1 go test ./...
+const UpdateGoldenEnv = "GOSMM_UPDATE_GOLDEN"
+
+// AssertSchemaMatches checks that the schema of db matches the golden file, a schema dump written by
+// gosmm.DumpSchema, and reports the tables and indexes added, removed or changed otherwise, so that unintended
+// schema changes of the migrations fail the tests. The objects are compared, not the latest applied migration
+// in the header of the dump. When the GOSMM_UPDATE_GOLDEN environment variable is set, the golden file is
+// written instead. It returns whether the schema matches.
+func AssertSchemaMatches(t testing.TB, db *sql.DB, golden string) bool {
+	t.Helper()
+	driver, err := driverName(db)
+	if err != nil {
+		t.Errorf("gosmmtest: %v", err)
+		return false
+	}
+	config := gosmm.MigrationConfig{Driver: driver}
+
+	if os.Getenv(UpdateGoldenEnv) != "" {
+		var b bytes.Buffer
+		if err := gosmm.DumpSchema(db, config, &b); err != nil {
+			t.Errorf("gosmmtest: failed to dump schema: %v", err)
+			return false
+		}
+		if err := os.MkdirAll(filepath.Dir(golden), 0755); err != nil {
+			t.Errorf("gosmmtest: failed to write %s: %v", golden, err)
+			return false
+		}
+		if err := ioutil.WriteFile(golden, b.Bytes(), 0644); err != nil {
+			t.Errorf("gosmmtest: failed to write %s: %v", golden, err)
+			return false
+		}
+		return true
+	}
+
+	data, err := ioutil.ReadFile(golden)
+	if errors.Is(err, os.ErrNotExist) {
+		t.Errorf("gosmmtest: the golden file %s does not exist, run the tests with %s=1 to write it", golden, UpdateGoldenEnv)
+		return false
+	}
+	if err != nil {
+		t.Errorf("gosmmtest: failed to read %s: %v", golden, err)
+		return false
+	}
+	snapshot, err := gosmm.ParseSchema(bytes.NewReader(data))
+	if err != nil {
+		t.Errorf("gosmmtest: invalid golden file %s: %v", golden, err)
+		return false
+	}
+	objects, err := gosmm.InspectSchema(db, config)
+	if err != nil {
+		t.Errorf("gosmmtest: %v", err)
+		return false
+	}
+	drifts := gosmm.DiffSchema(snapshot.Objects, objects)
+	if len(drifts) == 0 {
+		return true
+	}
+	t.Errorf("gosmmtest: the schema does not match %s, run the tests with %s=1 to update it if the change is intended:\n%s",
+		golden, UpdateGoldenEnv, formatDrifts(drifts))
+	return false
+}
+
+// formatDrifts returns the differences of the objects, with the lines of their definitions prefixed with - when
+// they are only in the golden file and + when they are only in the database
+func formatDrifts(drifts []gosmm.SchemaDrift) string {
+	var b strings.Builder
+	for _, drift := range drifts {
+		fmt.Fprintf(&b, "\n%s %s %s:\n", drift.Change, drift.Kind, drift.Name)
+		for _, line := range diffLines(splitLines(drift.Expected), splitLines(drift.Actual)) {
+			b.WriteString("    " + line + "\n")
+		}
+	}
+	return b.String()
+}
+
+// splitLines returns the lines of s, none when it is empty
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// diffLines returns the lines of a and b in the order of their longest common subsequence, prefixed with "- "
+// when they are only in a, "+ " when they are only in b, and "  " otherwise
+func diffLines(a []string, b []string) []string {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var lines []string
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, "  "+a[i])
+			i, j = i+1, j+1
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, "- "+a[i])
+			i++
+		default:
+			lines = append(lines, "+ "+b[j])
+			j++
+		}
+	}
+	return lines
+}
+
+// driverName returns the gosmm driver name of the connections of db, whose driver is compared with those
+// registered under the supported names, including the registered dialects
+func driverName(db *sql.DB) (string, error) {
+	actual := reflect.TypeOf(db.Driver())
+	for _, name := range append([]string{"postgres", "mysql", "sqlite3", "sqlserver"}, gosmm.Dialects()...) {
+		probe, err := sql.Open(name, "")
+		if err != nil {
+			continue
+		}
+		match := reflect.TypeOf(probe.Driver()) == actual
+		probe.Close()
+		if match {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported driver %s", actual)
+}
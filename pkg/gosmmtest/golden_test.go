@@ -0,0 +1,77 @@
+package gosmmtest
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingT records the errors of the assertions instead of failing the test
+type recordingT struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recordingT) Helper() {}
+
+func (r *recordingT) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestAssertSchemaMatches(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+	_, err = db.Exec(`CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`)
+	assert.NoError(t, err)
+	golden := filepath.Join(t.TempDir(), "testdata", "schema.golden.sql")
+
+	// a missing golden file is reported
+	r := &recordingT{TB: t}
+	assert.False(t, AssertSchemaMatches(r, db, golden))
+	assert.Len(t, r.errors, 1)
+	assert.Contains(t, r.errors[0], "run the tests with GOSMM_UPDATE_GOLDEN=1 to write it")
+
+	// and written on demand
+	t.Setenv(UpdateGoldenEnv, "1")
+	assert.True(t, AssertSchemaMatches(t, db, golden))
+	assert.FileExists(t, golden)
+	t.Setenv(UpdateGoldenEnv, "")
+	assert.True(t, AssertSchemaMatches(t, db, golden))
+
+	// the changes of the schema are reported
+	_, err = db.Exec(`CREATE INDEX users_name ON users (name)`)
+	assert.NoError(t, err)
+	_, err = db.Exec(`ALTER TABLE users ADD COLUMN email TEXT`)
+	assert.NoError(t, err)
+	r = &recordingT{TB: t}
+	assert.False(t, AssertSchemaMatches(r, db, golden))
+	assert.Len(t, r.errors, 1)
+	assert.Contains(t, r.errors[0], "\nadded index users.users_name:\n    + CREATE INDEX users_name ON users (name)\n")
+	assert.Contains(t, r.errors[0], "\nchanged table users:\n")
+	assert.Contains(t, r.errors[0], "    + ")
+}
+
+func TestDiffLines(t *testing.T) {
+	a := []string{"CREATE TABLE users (", "  id INTEGER,", "  name TEXT", ")"}
+	b := []string{"CREATE TABLE users (", "  id INTEGER,", "  name TEXT,", "  email TEXT", ")"}
+	assert.Equal(t, []string{"  CREATE TABLE users (", "    id INTEGER,", "-   name TEXT", "+   name TEXT,", "+   email TEXT", "  )"}, diffLines(a, b))
+	assert.Equal(t, []string{"+ x"}, diffLines(nil, []string{"x"}))
+	assert.Empty(t, diffLines(nil, nil))
+	assert.Equal(t, "", strings.Join(splitLines(""), ""))
+}
+
+func TestDriverName(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	assert.NoError(t, err)
+	defer db.Close()
+	name, err := driverName(db)
+	assert.NoError(t, err)
+	assert.Equal(t, "sqlite3", name)
+}
@@ -0,0 +1,374 @@
+// Package gosmmtest starts Postgres and MySQL containers with testcontainers-go for the integration tests of an
+// application, and hands each test a database of its own with the migrations of the project applied. The
+// containers a crashed test binary leaves behind are removed by the Ryuk reaper of testcontainers.
+//
+// A test binary starts a container once in TestMain and closes it after the tests:
+//
+//	var postgres *gosmmtest.Container
+//
+//	func TestMain(m *testing.M) {
+//		var err error
+//		postgres, err = gosmmtest.StartPostgres(context.Background(), gosmmtest.Options{})
+//		if err != nil {
+//			log.Fatal(err)
+//		}
+//		code := m.Run()
+//		postgres.Close()
+//		os.Exit(code)
+//	}
+//
+//	func TestUsers(t *testing.T) {
+//		db := postgres.NewDB(t, gosmm.MigrationConfig{MigrationsDir: "../migrations"})
+//		...
+//	}
+//
+// RunConformance runs a conformance suite against the databases of the dialects registered with
+// gosmm.RegisterDialect, started in containers described by a ContainerSpec.
+package gosmmtest
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/go-sql-driver/mysql"
+	"github.com/k1e1n04/gosmm/v2/pkg/gosmm"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+const (
+	// DefaultPostgresImage is the image of the Postgres containers when Options.Image is empty
+	DefaultPostgresImage = "postgres:16-alpine"
+	// DefaultMySQLImage is the image of the MySQL containers when Options.Image is empty
+	DefaultMySQLImage = "mysql:8.0"
+	// defaultStartupTimeout bounds the wait for the database of a container to accept connections
+	defaultStartupTimeout = 2 * time.Minute
+	// password is the password of the databases of the containers, which only live as long as the tests
+	password = "gosmm"
+	// databaseName is the database created by the containers
+	databaseName = "gosmm"
+)
+
+// Options configures the containers
+type Options struct {
+	// Image is the image of the container, DefaultPostgresImage or DefaultMySQLImage when empty
+	Image string
+	// StartupTimeout bounds the wait for the database to accept connections, 2 minutes when zero
+	StartupTimeout time.Duration
+	// Provider is the container runtime, Docker when zero, e.g. testcontainers.ProviderPodman
+	Provider testcontainers.ProviderType
+	// DSNEnv is the environment variable holding the DSN of an existing database used instead of a container,
+	// e.g. a CI service. GOSMM_TEST_POSTGRES_DSN or GOSMM_TEST_MYSQL_DSN when empty.
+	DSNEnv string
+}
+
+// Container is a database server started for the tests, or an existing one given by Options.DSNEnv
+type Container struct {
+	// Config connects to the database of the container
+	Config gosmm.DBConfig
+
+	container testcontainers.Container
+}
+
+// ContainerSpec describes the container of a database, see StartContainer
+type ContainerSpec struct {
+	// Driver is the database/sql driver of the database, e.g. the driver of a dialect registered with
+	// gosmm.RegisterDialect
+	Driver string
+	// Image is the image of the container, unless Options.Image is set
+	Image string
+	// Port is the port the database listens on in the container
+	Port int
+	// Env are the environment variables of the container, e.g. ORACLE_PASSWORD=secret
+	Env []string
+	// DSN returns the DSN of the database of the container listening on host and port
+	DSN func(host string, port int) string
+	// WaitingFor is waited for before the database is connected to, e.g. wait.ForLog with the message of the
+	// database once initialized. Only the connections to the database are waited for when nil.
+	WaitingFor wait.Strategy
+	// DSNEnv is the environment variable holding the DSN of an existing database used instead of a container,
+	// unless Options.DSNEnv is set
+	DSNEnv string
+}
+
+// StartPostgres starts a Postgres container and waits for it to accept connections
+func StartPostgres(ctx context.Context, options Options) (*Container, error) {
+	return start(ctx, options, postgresSpec(), gosmm.DBConfig{User: databaseName, Params: map[string]string{"sslmode": "disable"}})
+}
+
+// postgresSpec describes the Postgres containers. The server is ready once it logs it a second time, the first
+// being the server initializing the database, which only listens on a Unix socket.
+func postgresSpec() ContainerSpec {
+	return ContainerSpec{
+		Driver:     "postgres",
+		Image:      DefaultPostgresImage,
+		Port:       5432,
+		Env:        []string{"POSTGRES_USER=" + databaseName, "POSTGRES_PASSWORD=" + password, "POSTGRES_DB=" + databaseName},
+		DSNEnv:     "GOSMM_TEST_POSTGRES_DSN",
+		WaitingFor: wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+	}
+}
+
+// StartMySQL starts a MySQL container and waits for it to accept connections
+func StartMySQL(ctx context.Context, options Options) (*Container, error) {
+	return start(ctx, options, ContainerSpec{
+		Driver: "mysql",
+		Image:  DefaultMySQLImage,
+		Port:   3306,
+		Env:    []string{"MYSQL_ROOT_PASSWORD=" + password, "MYSQL_DATABASE=" + databaseName},
+		DSNEnv: "GOSMM_TEST_MYSQL_DSN",
+	}, gosmm.DBConfig{User: "root", Params: map[string]string{"multiStatements": "true", "parseTime": "true"}})
+}
+
+// StartContainer starts a container of the database described by spec and waits for it to accept connections,
+// so that the dialects registered with gosmm.RegisterDialect are tested against their database like Postgres
+// and MySQL, see RunConformance. The database is connected to by the DSN of spec.
+func StartContainer(ctx context.Context, options Options, spec ContainerSpec) (*Container, error) {
+	if spec.Driver == "" || spec.Image == "" && options.Image == "" || spec.Port == 0 || spec.DSN == nil {
+		return nil, errors.New("incomplete container spec: the driver, image, port and DSN are required")
+	}
+	return start(ctx, options, spec, gosmm.DBConfig{})
+}
+
+// start starts a container of spec, or connects to the database of the DSN of its DSNEnv when set. The database
+// of the container is connected to by the DSN of spec, or by config when spec has none.
+func start(ctx context.Context, options Options, spec ContainerSpec, config gosmm.DBConfig) (*Container, error) {
+	dsnEnv, driver, port := spec.DSNEnv, spec.Driver, spec.Port
+	if options.DSNEnv != "" {
+		dsnEnv = options.DSNEnv
+	}
+	if dsn := os.Getenv(dsnEnv); dsnEnv != "" && dsn != "" {
+		c := &Container{Config: gosmm.DBConfig{Driver: driver, DSN: dsn}}
+		if err := c.wait(ctx, options.StartupTimeout); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+	image := spec.Image
+	if options.Image != "" {
+		image = options.Image
+	}
+	env := make(map[string]string, len(spec.Env))
+	for _, e := range spec.Env {
+		name, value, _ := strings.Cut(e, "=")
+		env[name] = value
+	}
+
+	// the database is connected to once the strategy of spec is satisfied, as the containers of some databases
+	// accept connections before their initialization is done
+	timeout := options.StartupTimeout
+	if timeout == 0 {
+		timeout = defaultStartupTimeout
+	}
+	strategy := wait.Strategy(databaseStrategy{spec: spec, config: config, timeout: timeout})
+	if spec.WaitingFor != nil {
+		strategy = wait.ForAll(spec.WaitingFor, strategy).WithStartupTimeout(timeout)
+	}
+	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        image,
+			Env:          env,
+			ExposedPorts: []string{strconv.Itoa(port) + "/tcp"},
+			Labels:       map[string]string{"gosmmtest": "true"},
+			WaitingFor:   strategy,
+		},
+		ProviderType: options.Provider,
+		Started:      true,
+	})
+	if err != nil {
+		if container != nil {
+			container.Terminate(context.Background())
+		}
+		return nil, fmt.Errorf("failed to start %s container: %w", driver, err)
+	}
+	c := &Container{container: container}
+	if c.Config, err = containerConfig(ctx, container, spec, config); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// databaseStrategy waits for the database of a container to accept connections
+type databaseStrategy struct {
+	spec    ContainerSpec
+	config  gosmm.DBConfig
+	timeout time.Duration
+}
+
+// WaitUntilReady implements wait.Strategy
+func (s databaseStrategy) WaitUntilReady(ctx context.Context, target wait.StrategyTarget) error {
+	config, err := containerConfig(ctx, target, s.spec, s.config)
+	if err != nil {
+		return err
+	}
+	return (&Container{Config: config}).wait(ctx, s.timeout)
+}
+
+// containerConfig returns the config connecting to the database of the container target, by the DSN of spec, or
+// by config when spec has none
+func containerConfig(ctx context.Context, target wait.StrategyTarget, spec ContainerSpec, config gosmm.DBConfig) (gosmm.DBConfig, error) {
+	host, err := target.Host(ctx)
+	if err != nil {
+		return gosmm.DBConfig{}, fmt.Errorf("failed to get the host of the %s container: %w", spec.Driver, err)
+	}
+	port, err := target.MappedPort(ctx, nat.Port(strconv.Itoa(spec.Port)+"/tcp"))
+	if err != nil {
+		return gosmm.DBConfig{}, fmt.Errorf("failed to get the port of the %s container: %w", spec.Driver, err)
+	}
+	if spec.DSN != nil {
+		return gosmm.DBConfig{Driver: spec.Driver, DSN: spec.DSN(host, port.Int())}, nil
+	}
+	config.Driver, config.Host, config.Port, config.Password, config.DBName = spec.Driver, host, port.Int(), password, databaseName
+	return config, nil
+}
+
+// wait waits for the database to accept connections, the databases of the containers taking a few seconds
+// to initialize
+func (c *Container) wait(ctx context.Context, timeout time.Duration) error {
+	if timeout == 0 {
+		timeout = defaultStartupTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	db, err := gosmm.ConnectDB(c.Config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	for {
+		err := db.PingContext(ctx)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("the %s database did not accept connections within %s: %w", c.Config.Driver, timeout, err)
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+// Close removes the container, and does nothing for an existing database
+func (c *Container) Close() error {
+	if c.container == nil {
+		return nil
+	}
+	return c.container.Terminate(context.Background())
+}
+
+// NewDB creates a schema (Postgres) or a database (MySQL) of its own for the test, applies the migrations of
+// config to it and returns a connection using it. The schema is dropped when the test finishes, so that the
+// tests sharing the container are isolated and can run in parallel. It fails the test on errors.
+func (c *Container) NewDB(t testing.TB, config gosmm.MigrationConfig) *sql.DB {
+	t.Helper()
+	name, err := isolatedName()
+	if err != nil {
+		t.Fatalf("gosmmtest: %v", err)
+	}
+	admin, err := gosmm.ConnectDB(c.Config)
+	if err != nil {
+		t.Fatalf("gosmmtest: failed to connect to the %s database: %v", c.Config.Driver, err)
+	}
+	defer admin.Close()
+
+	create, drop := "CREATE SCHEMA "+name, "DROP SCHEMA IF EXISTS "+name+" CASCADE"
+	if c.Config.Driver == "mysql" {
+		create, drop = "CREATE DATABASE "+name, "DROP DATABASE IF EXISTS "+name
+	}
+	if _, err := admin.Exec(create); err != nil {
+		t.Fatalf("gosmmtest: failed to create %s: %v", name, err)
+	}
+	t.Cleanup(func() {
+		admin, err := gosmm.ConnectDB(c.Config)
+		if err != nil {
+			t.Errorf("gosmmtest: failed to connect to the %s database: %v", c.Config.Driver, err)
+			return
+		}
+		defer admin.Close()
+		if _, err := admin.Exec(drop); err != nil {
+			t.Errorf("gosmmtest: failed to drop %s: %v", name, err)
+		}
+	})
+
+	dbConfig, err := isolatedConfig(c.Config, name)
+	if err != nil {
+		t.Fatalf("gosmmtest: %v", err)
+	}
+	db, err := gosmm.ConnectDB(dbConfig)
+	if err != nil {
+		t.Fatalf("gosmmtest: failed to connect to %s: %v", name, err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	config.Driver = c.Config.Driver
+	if config.Driver == "postgres" {
+		config.Schema = name
+	}
+	if err := gosmm.MigrateWithConfig(db, config); err != nil {
+		t.Fatalf("gosmmtest: failed to migrate %s: %v", name, err)
+	}
+	return db
+}
+
+// isolatedName returns a random name for the schema or database of a test
+func isolatedName() (string, error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "gosmmtest_" + hex.EncodeToString(b), nil
+}
+
+// isolatedConfig returns config connecting to the schema (Postgres) or the database (MySQL) name
+func isolatedConfig(config gosmm.DBConfig, name string) (gosmm.DBConfig, error) {
+	switch config.Driver {
+	case "postgres":
+		if config.DSN == "" {
+			params := map[string]string{"search_path": name}
+			for key, value := range config.Params {
+				params[key] = value
+			}
+			config.Params = params
+			return config, nil
+		}
+		if !strings.Contains(config.DSN, "://") {
+			config.DSN += " search_path=" + name
+			return config, nil
+		}
+		u, err := url.Parse(config.DSN)
+		if err != nil {
+			return gosmm.DBConfig{}, fmt.Errorf("invalid DSN: %w", err)
+		}
+		query := u.Query()
+		query.Set("search_path", name)
+		u.RawQuery = query.Encode()
+		config.DSN = u.String()
+		return config, nil
+	case "mysql":
+		if config.DSN == "" {
+			config.DBName = name
+			return config, nil
+		}
+		dsn, err := mysql.ParseDSN(config.DSN)
+		if err != nil {
+			return gosmm.DBConfig{}, fmt.Errorf("invalid DSN: %w", err)
+		}
+		dsn.DBName = name
+		config.DSN = dsn.FormatDSN()
+		return config, nil
+	default:
+		return gosmm.DBConfig{}, errors.New("unsupported driver: " + config.Driver)
+	}
+}
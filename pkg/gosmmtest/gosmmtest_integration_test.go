@@ -0,0 +1,76 @@
+//go:build integration
+
+package gosmmtest
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/k1e1n04/gosmm/v2/pkg/gosmm"
+	"github.com/stretchr/testify/assert"
+)
+
+// testNewDBIsolatesTests checks that the tests sharing the database of start get a schema of their own. It is
+// skipped unless env is set, when not empty.
+func testNewDBIsolatesTests(t *testing.T, start func(context.Context, Options) (*Container, error), env string) {
+	if env != "" && os.Getenv(env) == "" {
+		t.Skip(env + " is not set")
+	}
+	c, err := start(context.Background(), Options{})
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer c.Close()
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INT PRIMARY KEY);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	config := gosmm.MigrationConfig{MigrationsDir: dir}
+
+	// the same table is created in the schema of each test
+	first := c.NewDB(t, config)
+	second := c.NewDB(t, config)
+	_, err = first.Exec(`INSERT INTO users (id) VALUES (1)`)
+	assert.NoError(t, err)
+	var count int
+	assert.NoError(t, second.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&count))
+	assert.Equal(t, 0, count)
+}
+
+// the Postgres tests start a container unless GOSMM_TEST_POSTGRES_DSN is set
+func TestPostgresNewDBIsolatesTests(t *testing.T) {
+	testNewDBIsolatesTests(t, StartPostgres, "")
+}
+
+func TestMySQLNewDBIsolatesTests(t *testing.T) {
+	testNewDBIsolatesTests(t, StartMySQL, "GOSMM_TEST_MYSQL_DSN")
+}
+
+func TestPostgresTemplate(t *testing.T) {
+	c, err := StartPostgres(context.Background(), Options{})
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer c.Close()
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INT PRIMARY KEY);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	tpl, err := c.NewTemplate(context.Background(), gosmm.MigrationConfig{MigrationsDir: dir})
+	if err != nil {
+		t.Fatalf("Failed to create template: %v", err)
+	}
+	defer tpl.Close()
+
+	first, second := tpl.NewDB(t), tpl.NewDB(t)
+	_, err = first.Exec(`INSERT INTO users (id) VALUES (1)`)
+	assert.NoError(t, err)
+	var count int
+	assert.NoError(t, second.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&count))
+	assert.Equal(t, 0, count)
+}
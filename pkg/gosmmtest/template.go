@@ -0,0 +1,180 @@
+package gosmmtest
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/k1e1n04/gosmm/v2/pkg/gosmm"
+)
+
+// Template is a database migrated once and cloned for each test, which is much faster than applying all the
+// migrations for every test. Postgres databases are cloned with CREATE DATABASE ... TEMPLATE, SQLite databases
+// by copying their file.
+type Template struct {
+	driver string
+	// server connects to the Postgres server holding the template database name
+	server gosmm.DBConfig
+	name   string
+	// path is the file of the SQLite template
+	path string
+	// clone serializes the clones, Postgres refusing to clone a template being cloned by another session
+	clone sync.Mutex
+}
+
+// NewTemplate creates a database on the Postgres server of the container and applies the migrations of config
+// to it, the template cloned by Template.NewDB. It is dropped by Template.Close.
+func (c *Container) NewTemplate(ctx context.Context, config gosmm.MigrationConfig) (*Template, error) {
+	if c.Config.Driver != "postgres" {
+		return nil, fmt.Errorf("unsupported driver for templates: %s", c.Config.Driver)
+	}
+	name, err := isolatedName()
+	if err != nil {
+		return nil, err
+	}
+	tpl := &Template{driver: c.Config.Driver, server: c.Config, name: name + "_template"}
+	if err := tpl.exec(ctx, "CREATE DATABASE "+tpl.name); err != nil {
+		return nil, fmt.Errorf("failed to create template %s: %w", tpl.name, err)
+	}
+	if err := tpl.migrate(ctx, tpl.name, config); err != nil {
+		tpl.Close()
+		return nil, err
+	}
+	return tpl, nil
+}
+
+// NewSQLiteTemplate creates a SQLite database in a temporary directory and applies the migrations of config
+// to it, the template cloned by Template.NewDB. It is removed by Template.Close.
+func NewSQLiteTemplate(config gosmm.MigrationConfig) (*Template, error) {
+	dir, err := ioutil.TempDir("", "gosmmtest-")
+	if err != nil {
+		return nil, err
+	}
+	tpl := &Template{driver: "sqlite3", path: filepath.Join(dir, "template.db")}
+	db, err := sql.Open("sqlite3", tpl.path)
+	if err != nil {
+		tpl.Close()
+		return nil, err
+	}
+	defer db.Close()
+	config.Driver = tpl.driver
+	if err := gosmm.MigrateWithConfig(db, config); err != nil {
+		tpl.Close()
+		return nil, fmt.Errorf("failed to migrate template: %w", err)
+	}
+	return tpl, nil
+}
+
+// migrate applies the migrations of config to the Postgres database name
+func (tpl *Template) migrate(ctx context.Context, name string, config gosmm.MigrationConfig) error {
+	dbConfig, err := databaseConfig(tpl.server, name)
+	if err != nil {
+		return err
+	}
+	db, err := gosmm.ConnectDB(dbConfig)
+	if err != nil {
+		return fmt.Errorf("failed to connect to template %s: %w", name, err)
+	}
+	// the template cannot be cloned while a connection to it is open
+	defer db.Close()
+	config.Driver = tpl.driver
+	if err := gosmm.MigrateWithContext(ctx, db, config); err != nil {
+		return fmt.Errorf("failed to migrate template %s: %w", name, err)
+	}
+	return nil
+}
+
+// exec executes query on the Postgres server of the template
+func (tpl *Template) exec(ctx context.Context, query string) error {
+	db, err := gosmm.ConnectDB(tpl.server)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_, err = db.ExecContext(ctx, query)
+	return err
+}
+
+// NewDB clones the template into a database of its own for the test and returns a connection to it. The clone
+// is dropped when the test finishes. It fails the test on errors.
+func (tpl *Template) NewDB(t testing.TB) *sql.DB {
+	t.Helper()
+	if tpl.driver == "sqlite3" {
+		data, err := ioutil.ReadFile(tpl.path)
+		if err != nil {
+			t.Fatalf("gosmmtest: failed to read template: %v", err)
+		}
+		path := filepath.Join(t.TempDir(), "test.db")
+		if err := ioutil.WriteFile(path, data, 0600); err != nil {
+			t.Fatalf("gosmmtest: failed to clone template: %v", err)
+		}
+		db, err := sql.Open("sqlite3", path)
+		if err != nil {
+			t.Fatalf("gosmmtest: failed to open clone: %v", err)
+		}
+		t.Cleanup(func() { db.Close() })
+		return db
+	}
+
+	name, err := isolatedName()
+	if err != nil {
+		t.Fatalf("gosmmtest: %v", err)
+	}
+	tpl.clone.Lock()
+	err = tpl.exec(context.Background(), "CREATE DATABASE "+name+" TEMPLATE "+tpl.name)
+	tpl.clone.Unlock()
+	if err != nil {
+		t.Fatalf("gosmmtest: failed to clone template %s: %v", tpl.name, err)
+	}
+	t.Cleanup(func() {
+		if err := tpl.exec(context.Background(), "DROP DATABASE IF EXISTS "+name); err != nil {
+			t.Errorf("gosmmtest: failed to drop %s: %v", name, err)
+		}
+	})
+
+	dbConfig, err := databaseConfig(tpl.server, name)
+	if err != nil {
+		t.Fatalf("gosmmtest: %v", err)
+	}
+	db, err := gosmm.ConnectDB(dbConfig)
+	if err != nil {
+		t.Fatalf("gosmmtest: failed to connect to %s: %v", name, err)
+	}
+	// registered after the drop, so that the connection is closed first
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// Close drops the template database, or removes the SQLite template
+func (tpl *Template) Close() error {
+	if tpl.driver == "sqlite3" {
+		return os.RemoveAll(filepath.Dir(tpl.path))
+	}
+	return tpl.exec(context.Background(), "DROP DATABASE IF EXISTS "+tpl.name)
+}
+
+// databaseConfig returns the Postgres config connecting to the database name of the server of config
+func databaseConfig(config gosmm.DBConfig, name string) (gosmm.DBConfig, error) {
+	if config.DSN == "" {
+		config.DBName = name
+		return config, nil
+	}
+	if !strings.Contains(config.DSN, "://") {
+		config.DSN += " dbname=" + name
+		return config, nil
+	}
+	u, err := url.Parse(config.DSN)
+	if err != nil {
+		return gosmm.DBConfig{}, fmt.Errorf("invalid DSN: %w", err)
+	}
+	u.Path = "/" + name
+	config.DSN = u.String()
+	return config, nil
+}
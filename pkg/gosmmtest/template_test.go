@@ -0,0 +1,77 @@
+package gosmmtest
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/k1e1n04/gosmm/v2/pkg/gosmm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSQLiteTemplate(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER PRIMARY KEY);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	migrations := 0
+	config := gosmm.MigrationConfig{MigrationsDir: dir, Hooks: gosmm.Hooks{AfterEach: func(gosmm.MigrationInfo) error {
+		migrations++
+		return nil
+	}}}
+
+	tpl, err := NewSQLiteTemplate(config)
+	assert.NoError(t, err)
+
+	// the clones are migrated and isolated from each other
+	first, second := tpl.NewDB(t), tpl.NewDB(t)
+	_, err = first.Exec(`INSERT INTO users (id) VALUES (1)`)
+	assert.NoError(t, err)
+	var count int
+	assert.NoError(t, second.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&count))
+	assert.Equal(t, 0, count)
+	// the migrations were applied once, to the template
+	assert.Equal(t, 1, migrations)
+	status, err := gosmm.Status(second, gosmm.MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, status.Pending)
+
+	assert.NoError(t, tpl.Close())
+	_, err = os.Stat(tpl.path)
+	assert.True(t, os.IsNotExist(err))
+
+	_, err = NewSQLiteTemplate(gosmm.MigrationConfig{MigrationsDir: filepath.Join(dir, "missing")})
+	assert.Error(t, err)
+}
+
+func TestNewTemplateUnsupportedDriver(t *testing.T) {
+	_, err := (&Container{Config: gosmm.DBConfig{Driver: "mysql"}}).NewTemplate(context.Background(), gosmm.MigrationConfig{})
+	assert.EqualError(t, err, "unsupported driver for templates: mysql")
+}
+
+func TestDatabaseConfig(t *testing.T) {
+	tests := []struct {
+		config   gosmm.DBConfig
+		expected gosmm.DBConfig
+	}{
+		{
+			config:   gosmm.DBConfig{Driver: "postgres", Host: "127.0.0.1", DBName: "gosmm"},
+			expected: gosmm.DBConfig{Driver: "postgres", Host: "127.0.0.1", DBName: "gosmmtest_1"},
+		},
+		{
+			config:   gosmm.DBConfig{Driver: "postgres", DSN: "postgres://app@localhost/app?sslmode=disable"},
+			expected: gosmm.DBConfig{Driver: "postgres", DSN: "postgres://app@localhost/gosmmtest_1?sslmode=disable"},
+		},
+		{
+			config:   gosmm.DBConfig{Driver: "postgres", DSN: "host=localhost dbname=app"},
+			expected: gosmm.DBConfig{Driver: "postgres", DSN: "host=localhost dbname=app dbname=gosmmtest_1"},
+		},
+	}
+	for _, tt := range tests {
+		config, err := databaseConfig(tt.config, "gosmmtest_1")
+		assert.NoError(t, err)
+		assert.Equal(t, tt.expected, config)
+	}
+}
@@ -0,0 +1,199 @@
+// Package grpcserver serves the gosmm migration operations over gRPC, see gosmmpb.MigrationService.
+package grpcserver
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/k1e1n04/gosmm/v2/pkg/gosmm"
+	"github.com/k1e1n04/gosmm/v2/pkg/gosmmpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// eventKinds maps the progress events of a run to the events streamed by Migrate
+var eventKinds = map[gosmm.EventKind]gosmmpb.MigrateEvent_Kind{
+	gosmm.EventMigrationStarted:  gosmmpb.MigrateEvent_MIGRATION_STARTED,
+	gosmm.EventStatementExecuted: gosmmpb.MigrateEvent_STATEMENT_EXECUTED,
+	gosmm.EventMigrationFinished: gosmmpb.MigrateEvent_MIGRATION_FINISHED,
+	gosmm.EventMigrationFailed:   gosmmpb.MigrateEvent_MIGRATION_FAILED,
+}
+
+// service implements gosmmpb.MigrationServiceServer for a database
+type service struct {
+	gosmmpb.UnimplementedMigrationServiceServer
+	db     *sql.DB
+	config gosmm.MigrationConfig
+}
+
+// NewService returns the MigrationService of db described by config, to register on a grpc.Server
+// with gosmmpb.RegisterMigrationServiceServer
+func NewService(db *sql.DB, config gosmm.MigrationConfig) gosmmpb.MigrationServiceServer {
+	return &service{db: db, config: config}
+}
+
+// NewServer returns a grpc.Server serving the MigrationService of db described by config.
+// When token is set, every call requires an "authorization: Bearer <token>" metadata.
+func NewServer(db *sql.DB, config gosmm.MigrationConfig, token string, opts ...grpc.ServerOption) *grpc.Server {
+	if token != "" {
+		opts = append(opts, grpc.ChainUnaryInterceptor(UnaryTokenInterceptor(token)), grpc.ChainStreamInterceptor(StreamTokenInterceptor(token)))
+	}
+	server := grpc.NewServer(opts...)
+	gosmmpb.RegisterMigrationServiceServer(server, NewService(db, config))
+	return server
+}
+
+// Status returns the state of every migration
+func (s *service) Status(ctx context.Context, req *gosmmpb.StatusRequest) (*gosmmpb.StatusResponse, error) {
+	report, err := gosmm.Status(s.db, s.config)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return statusResponse(report), nil
+}
+
+// Migrate applies the pending migrations and streams their progress. The run is cancelled
+// when the client cancels the call.
+func (s *service) Migrate(req *gosmmpb.MigrateRequest, stream gosmmpb.MigrationService_MigrateServer) error {
+	config := s.config
+	progress := config.Progress
+	var sendErr error
+	config.Progress = func(event gosmm.Event) {
+		if progress != nil {
+			progress(event)
+		}
+		if sendErr != nil {
+			return
+		}
+		sendErr = stream.Send(migrateEvent(event))
+	}
+
+	err := gosmm.MigrateWithContext(stream.Context(), s.db, config)
+	switch {
+	case errors.Is(err, gosmm.ErrDirtyState):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	case err != nil:
+		return status.Error(codes.Internal, err.Error())
+	case sendErr != nil:
+		return sendErr
+	}
+	return stream.Send(&gosmmpb.MigrateEvent{Kind: gosmmpb.MigrateEvent_RUN_COMPLETED})
+}
+
+// Validate checks the migration files against the history table
+func (s *service) Validate(ctx context.Context, req *gosmmpb.ValidateRequest) (*gosmmpb.ValidateResponse, error) {
+	err := gosmm.Validate(s.db, s.config)
+	var validationErr *gosmm.ValidationError
+	if errors.As(err, &validationErr) {
+		resp := &gosmmpb.ValidateResponse{}
+		for _, issue := range validationErr.Issues {
+			resp.Issues = append(resp.Issues, &gosmmpb.ValidationIssue{Kind: string(issue.Kind), Filename: issue.Filename, Message: issue.Message})
+		}
+		return resp, nil
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &gosmmpb.ValidateResponse{}, nil
+}
+
+// Restore removes the failed migrations from the history table
+func (s *service) Restore(ctx context.Context, req *gosmmpb.RestoreRequest) (*gosmmpb.RestoreResponse, error) {
+	if err := gosmm.RestoreWithConfig(s.db, s.config); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &gosmmpb.RestoreResponse{}, nil
+}
+
+// Rollback rolls back the most recently applied migration with the down section of its file
+func (s *service) Rollback(ctx context.Context, req *gosmmpb.RollbackRequest) (*gosmmpb.RollbackResponse, error) {
+	filename, err := gosmm.RollbackWithContext(ctx, s.db, s.config)
+	switch {
+	case errors.Is(err, gosmm.ErrDirtyState):
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	case err != nil:
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &gosmmpb.RollbackResponse{Filename: filename}, nil
+}
+
+// statusResponse converts a status report to its message
+func statusResponse(report gosmm.StatusReport) *gosmmpb.StatusResponse {
+	resp := &gosmmpb.StatusResponse{
+		State:   string(report.State),
+		Applied: int32(report.Applied),
+		Pending: int32(report.Pending),
+		Failed:  int32(report.Failed),
+	}
+	for _, migration := range report.Migrations {
+		message := &gosmmpb.MigrationStatus{
+			Filename:        migration.Filename,
+			State:           string(migration.State),
+			InstalledRank:   int32(migration.InstalledRank),
+			ExecutionTimeMs: migration.ExecutionTime,
+			FailedStatement: int32(migration.FailedStatement),
+		}
+		if migration.InstalledOn != nil {
+			message.InstalledOn = timestamppb.New(*migration.InstalledOn)
+		}
+		resp.Migrations = append(resp.Migrations, message)
+	}
+	return resp
+}
+
+// migrateEvent converts a progress event to its message
+func migrateEvent(event gosmm.Event) *gosmmpb.MigrateEvent {
+	message := &gosmmpb.MigrateEvent{
+		Kind:           eventKinds[event.Kind],
+		Filename:       event.Migration.Filename,
+		Index:          int32(event.Index),
+		Total:          int32(event.Total),
+		StatementIndex: int32(event.StatementIndex),
+		StatementCount: int32(event.StatementCount),
+		DurationMs:     event.Duration.Milliseconds(),
+		RowsAffected:   event.RowsAffected,
+	}
+	if event.Err != nil {
+		message.Error = event.Err.Error()
+	}
+	return message
+}
+
+// UnaryTokenInterceptor rejects the unary calls without an "authorization: Bearer <token>" metadata
+func UnaryTokenInterceptor(token string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := authorize(ctx, token); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamTokenInterceptor rejects the streaming calls without an "authorization: Bearer <token>" metadata
+func StreamTokenInterceptor(token string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := authorize(stream.Context(), token); err != nil {
+			return err
+		}
+		return handler(srv, stream)
+	}
+}
+
+// authorize checks the token of the call, compared in constant time
+func authorize(ctx context.Context, token string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		if bearer, ok := strings.CutPrefix(value, "Bearer "); ok && subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "invalid or missing token")
+}
@@ -0,0 +1,196 @@
+package grpcserver
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/k1e1n04/gosmm/v2/pkg/gosmm"
+	"github.com/k1e1n04/gosmm/v2/pkg/gosmmpb"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func setupTestDB(t *testing.T) (*sql.DB, func()) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	// each connection to :memory: is a different database
+	db.SetMaxOpenConns(1)
+
+	return db, func() {
+		db.Close()
+	}
+}
+
+// dial serves the server on an in-memory listener and returns a client connected to it
+func dial(t *testing.T, server *grpc.Server) gosmmpb.MigrationServiceClient {
+	listener := bufconn.Listen(1 << 20)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to dial server: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return gosmmpb.NewMigrationServiceClient(conn)
+}
+
+// receiveAll returns the events of a Migrate stream and the error it ended with
+func receiveAll(stream gosmmpb.MigrationService_MigrateClient) ([]*gosmmpb.MigrateEvent, error) {
+	var events []*gosmmpb.MigrateEvent
+	for {
+		event, err := stream.Recv()
+		if err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			return events, err
+		}
+		events = append(events, event)
+	}
+}
+
+func TestMigrationService(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER);\nCREATE INDEX users_id ON users (id);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	client := dial(t, NewServer(db, gosmm.MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}, ""))
+	ctx := context.Background()
+
+	resp, err := client.Status(ctx, &gosmmpb.StatusRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, "pending", resp.State)
+	assert.Equal(t, int32(1), resp.Pending)
+
+	stream, err := client.Migrate(ctx, &gosmmpb.MigrateRequest{})
+	assert.NoError(t, err)
+	events, err := receiveAll(stream)
+	assert.NoError(t, err)
+	kinds := make([]gosmmpb.MigrateEvent_Kind, len(events))
+	for i, event := range events {
+		kinds[i] = event.Kind
+	}
+	assert.Equal(t, []gosmmpb.MigrateEvent_Kind{
+		gosmmpb.MigrateEvent_MIGRATION_STARTED,
+		gosmmpb.MigrateEvent_STATEMENT_EXECUTED,
+		gosmmpb.MigrateEvent_STATEMENT_EXECUTED,
+		gosmmpb.MigrateEvent_MIGRATION_FINISHED,
+		gosmmpb.MigrateEvent_RUN_COMPLETED,
+	}, kinds)
+	assert.Equal(t, "v20230101_create_users_00001.sql", events[0].Filename)
+	assert.Equal(t, int32(2), events[2].StatementIndex)
+	assert.Equal(t, int32(2), events[2].StatementCount)
+
+	resp, err = client.Status(ctx, &gosmmpb.StatusRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, "up-to-date", resp.State)
+	assert.Equal(t, "applied", resp.Migrations[0].State)
+	assert.NotNil(t, resp.Migrations[0].InstalledOn)
+
+	validation, err := client.Validate(ctx, &gosmmpb.ValidateRequest{})
+	assert.NoError(t, err)
+	assert.Empty(t, validation.Issues)
+}
+
+func TestMigrationServiceFailure(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_add_email_00001.sql"), []byte("ALTER TABLE missing_table ADD COLUMN email TEXT;"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	client := dial(t, NewServer(db, gosmm.MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}, ""))
+	ctx := context.Background()
+
+	stream, err := client.Migrate(ctx, &gosmmpb.MigrateRequest{})
+	assert.NoError(t, err)
+	events, err := receiveAll(stream)
+	assert.Equal(t, codes.Internal, status.Code(err))
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, gosmmpb.MigrateEvent_MIGRATION_FAILED, events[1].Kind)
+		assert.Contains(t, events[1].Error, "no such table: missing_table")
+	}
+
+	// The dirty database is a failed precondition until it is restored
+	stream, err = client.Migrate(ctx, &gosmmpb.MigrateRequest{})
+	assert.NoError(t, err)
+	_, err = receiveAll(stream)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	_, err = client.Rollback(ctx, &gosmmpb.RollbackRequest{})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	_, err = client.Restore(ctx, &gosmmpb.RestoreRequest{})
+	assert.NoError(t, err)
+	resp, err := client.Status(ctx, &gosmmpb.StatusRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, "pending", resp.State)
+}
+
+func TestMigrationServiceRollback(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER);\n-- gosmm:down\nDROP TABLE users;\n"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	client := dial(t, NewServer(db, gosmm.MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}, ""))
+	ctx := context.Background()
+
+	// There is nothing to roll back before the run
+	_, err := client.Rollback(ctx, &gosmmpb.RollbackRequest{})
+	assert.Equal(t, codes.Internal, status.Code(err))
+
+	stream, err := client.Migrate(ctx, &gosmmpb.MigrateRequest{})
+	assert.NoError(t, err)
+	_, err = receiveAll(stream)
+	assert.NoError(t, err)
+
+	rollback, err := client.Rollback(ctx, &gosmmpb.RollbackRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, "v20230101_create_users_00001.sql", rollback.Filename)
+	_, err = db.Exec("SELECT id FROM users")
+	assert.Error(t, err)
+	resp, err := client.Status(ctx, &gosmmpb.StatusRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, "pending", resp.State)
+}
+
+func TestMigrationServiceToken(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	client := dial(t, NewServer(db, gosmm.MigrationConfig{MigrationsDir: t.TempDir(), Driver: "sqlite3"}, "s3cret"))
+
+	_, err := client.Status(context.Background(), &gosmmpb.StatusRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	stream, err := client.Migrate(context.Background(), &gosmmpb.MigrateRequest{})
+	assert.NoError(t, err)
+	_, err = receiveAll(stream)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer s3cret")
+	resp, err := client.Status(ctx, &gosmmpb.StatusRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, "up-to-date", resp.State)
+}
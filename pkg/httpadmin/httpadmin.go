@@ -0,0 +1,162 @@
+// Package httpadmin exposes the migration state of a service embedding gosmm over HTTP,
+// for internal tooling such as deploy dashboards.
+package httpadmin
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/k1e1n04/gosmm/v2/pkg/gosmm"
+)
+
+// handler serves the migration endpoints
+type handler struct {
+	db     *sql.DB
+	config gosmm.MigrationConfig
+	token  string
+	// running is held while a run started by POST /migrations/run is in progress
+	running sync.Mutex
+}
+
+// errorResponse is the body of the failed requests
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// readyResponse is the body of GET /migrations/ready when the database is ready
+type readyResponse struct {
+	Ready bool `json:"ready"`
+}
+
+// NewHandler returns a handler serving the migrations of db described by config:
+//   - GET /migrations/status: the gosmm.StatusReport of the database
+//   - GET /migrations/pending: the pending migrations, as returned by gosmm.Plan
+//   - GET /migrations/ready: 200 when every migration is applied and 503 otherwise, see gosmm.Ready
+//   - GET /migrations/version: the gosmm.SchemaVersion of the database, see gosmm.CurrentVersion
+//   - POST /migrations/run: applies the pending migrations and returns the gosmm.StatusReport
+//
+// POST /migrations/run requires an "Authorization: Bearer <token>" header, and is disabled when token is empty.
+// The read-only endpoints are not authenticated; wrap the handler to restrict them.
+// Mount the handler at the root of the mux, or strip the prefix it is mounted at with http.StripPrefix.
+func NewHandler(db *sql.DB, config gosmm.MigrationConfig, token string) http.Handler {
+	h := &handler{db: db, config: config, token: token}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/migrations/status", h.status)
+	mux.HandleFunc("/migrations/pending", h.pending)
+	mux.HandleFunc("/migrations/ready", h.ready)
+	mux.HandleFunc("/migrations/version", h.version)
+	mux.HandleFunc("/migrations/run", h.run)
+	return mux
+}
+
+// status serves the status report of the database
+func (h *handler) status(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	report, err := gosmm.Status(h.db, h.config)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// pending serves the pending migrations
+func (h *handler) pending(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	plan, err := gosmm.Plan(h.db, h.config)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, plan)
+}
+
+// ready serves the readiness of the database, e.g. for a Kubernetes readiness probe
+func (h *handler) ready(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	if err := gosmm.Ready(h.db, h.config); err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, errorResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, readyResponse{Ready: true})
+}
+
+// version serves the latest applied migration and the fingerprint of the schema
+func (h *handler) version(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	version, err := gosmm.CurrentVersion(h.db, h.config)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, version)
+}
+
+// run applies the pending migrations. The run is not cancelled when the client disconnects,
+// so that a migration is not rolled back halfway because of a proxy timeout.
+func (h *handler) run(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	if h.token == "" {
+		writeJSON(w, http.StatusForbidden, errorResponse{Error: "running migrations over HTTP is disabled"})
+		return
+	}
+	if !h.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeJSON(w, http.StatusUnauthorized, errorResponse{Error: "invalid or missing token"})
+		return
+	}
+	if !h.running.TryLock() {
+		writeJSON(w, http.StatusConflict, errorResponse{Error: "a migration run is already in progress"})
+		return
+	}
+	defer h.running.Unlock()
+
+	if err := gosmm.MigrateWithContext(context.Background(), h.db, h.config); err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: err.Error()})
+		return
+	}
+	report, err := gosmm.Status(h.db, h.config)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// authorized reports whether the request holds the token, compared in constant time
+func (h *handler) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) == 1
+}
+
+// allowMethod replies 405 Method Not Allowed unless the request uses method
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeJSON(w, http.StatusMethodNotAllowed, errorResponse{Error: "method not allowed"})
+	return false
+}
+
+// writeJSON writes v as the JSON body of the response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,122 @@
+package httpadmin
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/k1e1n04/gosmm/v2/pkg/gosmm"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupTestDB(t *testing.T) (*sql.DB, func()) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	// each connection to :memory: is a different database
+	db.SetMaxOpenConns(1)
+
+	return db, func() {
+		db.Close()
+	}
+}
+
+// serve sends a request to the handler and decodes the JSON response into v
+func serve(t *testing.T, handler http.Handler, req *http.Request, v interface{}) int {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+	if err := json.Unmarshal(recorder.Body.Bytes(), v); err != nil {
+		t.Fatalf("Failed to decode response %q: %v", recorder.Body.String(), err)
+	}
+	return recorder.Code
+}
+
+func TestHandler(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_create_users_00001.sql"), []byte("CREATE TABLE users (id INTEGER);\nCREATE INDEX users_id ON users (id);"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	handler := NewHandler(db, gosmm.MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}, "s3cret")
+
+	var report gosmm.StatusReport
+	code := serve(t, handler, httptest.NewRequest(http.MethodGet, "/migrations/status", nil), &report)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, gosmm.StatePending, report.State)
+
+	var plan []gosmm.PlannedMigration
+	code = serve(t, handler, httptest.NewRequest(http.MethodGet, "/migrations/pending", nil), &plan)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []gosmm.PlannedMigration{{Filename: "v20230101_create_users_00001.sql", Statements: 2}}, plan)
+
+	// Not ready until the migrations are applied
+	var failure errorResponse
+	code = serve(t, handler, httptest.NewRequest(http.MethodGet, "/migrations/ready", nil), &failure)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "pending migrations: 1 migration(s) not applied", failure.Error)
+
+	// The run requires the token
+	code = serve(t, handler, httptest.NewRequest(http.MethodPost, "/migrations/run", nil), &failure)
+	assert.Equal(t, http.StatusUnauthorized, code)
+	req := httptest.NewRequest(http.MethodPost, "/migrations/run", nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+	code = serve(t, handler, req, &failure)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	req = httptest.NewRequest(http.MethodPost, "/migrations/run", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	code = serve(t, handler, req, &report)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, gosmm.StateUpToDate, report.State)
+	assert.Equal(t, 1, report.Applied)
+
+	code = serve(t, handler, httptest.NewRequest(http.MethodGet, "/migrations/pending", nil), &plan)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, plan)
+
+	var ready readyResponse
+	code = serve(t, handler, httptest.NewRequest(http.MethodGet, "/migrations/ready", nil), &ready)
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, ready.Ready)
+
+	var version gosmm.SchemaVersion
+	code = serve(t, handler, httptest.NewRequest(http.MethodGet, "/migrations/version", nil), &version)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "v20230101_create_users_00001.sql", version.Migration)
+	assert.Equal(t, 1, version.Applied)
+	assert.NotEmpty(t, version.Fingerprint)
+
+	code = serve(t, handler, httptest.NewRequest(http.MethodGet, "/migrations/run", nil), &failure)
+	assert.Equal(t, http.StatusMethodNotAllowed, code)
+}
+
+func TestHandlerRunFailure(t *testing.T) {
+	db, teardown := setupTestDB(t)
+	defer teardown()
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "v20230101_add_email_00001.sql"), []byte("ALTER TABLE missing_table ADD COLUMN email TEXT;"), 0644); err != nil {
+		t.Fatalf("Failed to create test migration file: %v", err)
+	}
+	config := gosmm.MigrationConfig{MigrationsDir: dir, Driver: "sqlite3"}
+
+	// Without a token, runs are disabled
+	var failure errorResponse
+	code := serve(t, NewHandler(db, config, ""), httptest.NewRequest(http.MethodPost, "/migrations/run", nil), &failure)
+	assert.Equal(t, http.StatusForbidden, code)
+
+	req := httptest.NewRequest(http.MethodPost, "/migrations/run", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	code = serve(t, NewHandler(db, config, "s3cret"), req, &failure)
+	assert.Equal(t, http.StatusInternalServerError, code)
+	assert.Contains(t, failure.Error, "no such table: missing_table")
+}